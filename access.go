@@ -1,10 +1,14 @@
 package osin
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -59,11 +63,76 @@ type AccessRequest struct {
 	// HttpRequest *http.Request for special use
 	HttpRequest *http.Request
 
+	// Context set with WithContext
+	ctx context.Context
+
 	// Optional code_verifier as described in rfc7636
 	CodeVerifier string
 
 	// Skip set access_token and refresh_token cookies
 	SkipSetCookie bool
+
+	// Optional authorization_details as described in rfc9396
+	AuthorizationDetails AuthorizationDetails
+
+	// How the user was authenticated. Set it for grants authenticating the
+	// user directly, like password.
+	AuthenticationContext AuthenticationContext
+
+	// Local subject identifier of the user. Set it for grants authenticating
+	// the user directly, like password.
+	Subject string
+
+	// OpenID Connect nonce of the authorize request, for the ID token
+	Nonce string
+
+	// OpenID Connect claims requested with the claims parameter of the
+	// authorize request
+	ClaimsRequest *ClaimsRequest
+
+	// Device authorization approved by the user, for the device_code grant
+	DeviceAuthorization *DeviceAuthorizationData
+
+	// Scope granted by the user, if narrower than Scope, and the date it
+	// was granted. Set from the authorization code or the refreshed token.
+	GrantedScopes string
+	GrantedAt     time.Time
+
+	// Token type to issue, overriding the TokenTypeSelector, the client and
+	// Config.TokenType, and its specific token response fields
+	TokenType       string
+	TokenTypeFields map[string]interface{}
+
+	// Key the tokens are bound to, issued as the cnf claim of the JWT
+	// access tokens: the JWK SHA-256 thumbprint of the DPoP proof key
+	// (rfc9449) and the SHA-256 thumbprint of the mutual TLS client
+	// certificate (rfc8705). Set them once the proof or the certificate
+	// is validated.
+	DPoPJKT        string
+	CertThumbprint string
+
+	// If set with Authorized, FinishAccessRequest answers mfa_required with
+	// an mfa_token instead of issuing the tokens. They are issued by the
+	// MFA_OTP grant once the one-time password is verified.
+	RequireMFA bool
+
+	// Challenge being completed, for the MFA_OTP grant. The one-time
+	// password is in Password.
+	MFAChallenge *MFAChallenge
+
+	// Risk level assessed by the server RiskEvaluator
+	RiskLevel string
+
+	// User authenticated by the server PasswordAuthenticator, for the
+	// password grant. Subject is set to its subject.
+	User *User
+
+	// Pre-authorized code redeemed, for the pre-authorized_code grant
+	PreAuthorizedCode *PreAuthorizedCodeData
+
+	// Set to issue a c_nonce with the tokens, for the key proofs of the
+	// credential requests. Set by the pre-authorized_code grant.
+	IssueCNonce bool
 }
 
 // AccessData represents an access grant (tokens, expiration, client, etc)
@@ -100,6 +169,60 @@ type AccessData struct {
 
 	// Data to be passed to storage. Not used by the library.
 	UserData interface{}
+
+	// Authorization details granted, as described in rfc9396
+	AuthorizationDetails AuthorizationDetails
+
+	// How the user was authenticated
+	AuthenticationContext AuthenticationContext
+
+	// Local subject identifier of the user, if any
+	Subject string
+
+	// Identifier shared by all the grants refreshed from the same original
+	// grant, to revoke them together
+	FamilyID string
+
+	// Authorization code of the original grant of the family, if any
+	OriginalCode string
+
+	// Risk level assessed by the server RiskEvaluator when issued
+	RiskLevel string
+
+	// OpenID Connect claims requested for the ID token and UserInfo
+	ClaimsRequest *ClaimsRequest
+
+	// Type of the access token. Config.TokenType if blank.
+	TokenType string
+
+	// Thumbprints of the DPoP proof key and of the mutual TLS client
+	// certificate the token is bound to, if any
+	DPoPJKT        string
+	CertThumbprint string
+
+	// HTTP client the tokens were issued to, with Config.RecordFingerprint
+	Fingerprint *Fingerprint
+
+	// Credential version of the user at issuance, from the server
+	// CredentialVersionChecker
+	CredentialVersion string
+
+	// Scope granted by the user at consent, distinct from the requested
+	// Scope. Scope if blank, see GrantedScope.
+	GrantedScopes string
+
+	// Date the user granted the authorization, kept across refreshes
+	GrantedAt time.Time
+
+	// Full token, like a JWT, the access token is a reference to. Set by
+	// AccessTokenGenReference.
+	ReferencedToken string
+
+	// c_nonce issued with the token for the key proofs of the credential
+	// requests (OpenID for Verifiable Credential Issuance), and its
+	// expiration. See CheckCNonce.
+	CNonce          string
+	CNonceExpiresAt time.Time
 }
 
 // IsExpired returns true if access expired
@@ -117,16 +240,58 @@ func (d *AccessData) ExpireAt() time.Time {
 	return d.CreatedAt.Add(time.Duration(d.ExpiresIn) * time.Second)
 }
 
+// GrantedScope returns the scope granted by the user, or the requested
+// scope if no narrower one was granted
+func (d *AccessData) GrantedScope() string {
+	if d.GrantedScopes != "" {
+		return d.GrantedScopes
+	}
+	return d.Scope
+}
+
+// AuthTime returns the time the user authenticated, zero if unknown
+func (d *AccessData) AuthTime() time.Time {
+	return d.AuthenticationContext.AuthTime
+}
+
 // AccessTokenGen generates access tokens
 type AccessTokenGen interface {
 	GenerateAccessToken(data *AccessData, generaterefresh bool) (accesstoken string, refreshtoken string, err error)
 }
 
+// RefreshTokenGen generates refresh tokens, separately from the access
+// tokens, see Server.RefreshTokenGen
+type RefreshTokenGen interface {
+	GenerateRefreshToken(data *AccessData) (refreshtoken string, err error)
+}
+
 // HandleAccessRequest is the http.HandlerFunc for handling access token requests
 func (s *Server) HandleAccessRequest(w *Response, r *http.Request) *AccessRequest {
+	if len(s.middleware) == 0 {
+		return s.handleAccessRequest(w, r)
+	}
+	ret, _ := s.runMiddleware(ENDPOINT_TOKEN, w, r, func(endpoint Endpoint, w *Response, r *http.Request) interface{} {
+		if ar := s.handleAccessRequest(w, r); ar != nil {
+			return ar
+		}
+		return nil
+	}).(*AccessRequest)
+	return ret
+}
+
+func (s *Server) handleAccessRequest(w *Response, r *http.Request) *AccessRequest {
+	w.NoStore = true
+	if w.Languages == nil {
+		w.Languages = RequestLanguages(r)
+	}
+
+	if !s.checkMaintenance(w) {
+		return nil
+	}
+
 	// Only allow GET or POST
 	if r.Method == "GET" {
-		if !s.Config.AllowGetAccessRequest {
+		if !s.requestConfig(w).AllowGetAccessRequest {
 			w.SetError(E_INVALID_REQUEST, "")
 			w.InternalError = errors.New("Request must be POST")
 			return nil
@@ -137,39 +302,110 @@ func (s *Server) HandleAccessRequest(w *Response, r *http.Request) *AccessReques
 		return nil
 	}
 
-	err := r.ParseForm()
+	err := s.parseForm(w, r)
 	if err != nil {
 		w.SetError(E_INVALID_REQUEST, "")
 		w.InternalError = err
 		return nil
 	}
+	if !s.checkParameterLengths(w, r) || !s.checkQueryCredentials(w, r) {
+		return nil
+	}
+
+	// replayed idempotency keys return the saved response
+	if s.replayIdempotentResponse(w, r) {
+		return nil
+	}
+
+	if !s.checkDPoPProofNonce(w, r) {
+		return nil
+	}
 
 	grantType := AccessRequestType(r.Form.Get("grant_type"))
-	if s.Config.AllowedAccessTypes.Exists(grantType) {
+	if s.requestConfig(w).AllowedAccessTypes.Exists(grantType) {
+		if !s.checkAccessTypeClient(w, r, grantType) {
+			return nil
+		}
+		var ar *AccessRequest
 		switch grantType {
 		case AUTHORIZATION_CODE:
-			return s.handleAuthorizationCodeRequest(w, r)
+			ar = s.handleAuthorizationCodeRequest(w, r)
 		case REFRESH_TOKEN:
-			return s.handleRefreshTokenRequest(w, r)
+			ar = s.handleRefreshTokenRequest(w, r)
 		case PASSWORD:
-			return s.handlePasswordRequest(w, r)
+			ar = s.handlePasswordRequest(w, r)
 		case CLIENT_CREDENTIALS:
-			return s.handleClientCredentialsRequest(w, r)
+			ar = s.handleClientCredentialsRequest(w, r)
 		case ASSERTION:
-			return s.handleAssertionRequest(w, r)
+			ar = s.handleAssertionRequest(w, r)
 		case ANONYMOUS:
-			return s.handleAnonymousRequest(w, r)
+			ar = s.handleAnonymousRequest(w, r)
 		case DEVICE:
-			return s.handleDeviceRequest(w, r)
+			ar = s.handleDeviceRequest(w, r)
 		case PLATFORM:
-			return s.handlePlatformRequest(w, r)
+			ar = s.handlePlatformRequest(w, r)
+		case DEVICE_CODE:
+			ar = s.handleDeviceCodeRequest(w, r)
+		case PRE_AUTHORIZED_CODE:
+			ar = s.handlePreAuthorizedCodeRequest(w, r)
+		case MFA_OTP:
+			ar = s.handleMFAOTPRequest(w, r)
+		default:
+			w.SetError(E_UNSUPPORTED_GRANT_TYPE, "")
+			return nil
+		}
+		if ar != nil && ar.Client != nil && !ClientAllowsGrantType(ar.Client, grantType) {
+			w.SetError(E_UNAUTHORIZED_CLIENT, "")
+			w.InternalError = fmt.Errorf("client %s may not use the %s grant type", ar.Client.GetID(), grantType)
+			return nil
 		}
+		return ar
 	}
 
 	w.SetError(E_UNSUPPORTED_GRANT_TYPE, "")
 	return nil
 }
 
+// ClientGrantTypes is an optional interface clients can implement to
+// restrict the grant types they may use. The implicit grant is IMPLICIT.
+type ClientGrantTypes interface {
+	// GetGrantTypes returns the grant types the client may use. Empty if
+	// unlimited.
+	GetGrantTypes() []AccessRequestType
+}
+
+// ClientAllowsGrantType returns true if the client may use the grant type,
+// per ClientGrantTypes
+func ClientAllowsGrantType(client Client, grantType AccessRequestType) bool {
+	gt, ok := client.(ClientGrantTypes)
+	if !ok {
+		return true
+	}
+	types := gt.GetGrantTypes()
+	return len(types) == 0 || AllowedAccessType(types).Exists(grantType)
+}
+
+// checkAccessTypeClient verifies that the presented client may use the
+// access type, per Config.AccessTypeClients. The client is authenticated
+// later by the access type handler. Sets an unauthorized_client error on the
+// response and returns false otherwise.
+func (s *Server) checkAccessTypeClient(w *Response, r *http.Request, grantType AccessRequestType) bool {
+	list, ok := s.requestConfig(w).AccessTypeClients[grantType]
+	if !ok {
+		return true
+	}
+	clientId := r.Form.Get("client_id")
+	if auth, err := CheckBasicAuth(r); err == nil && auth != nil {
+		clientId = auth.Username
+	}
+	if !list.Permits(clientId) {
+		w.SetError(E_UNAUTHORIZED_CLIENT, "")
+		w.InternalError = fmt.Errorf("client %s may not use the %s grant type", clientId, grantType)
+		return false
+	}
+	return true
+}
+
 func (s *Server) handleAuthorizationCodeRequest(w *Response, r *http.Request) *AccessRequest {
 	auth, err := CheckBasicAuth(r)
 	if err != nil {
@@ -186,14 +422,14 @@ func (s *Server) handleAuthorizationCodeRequest(w *Response, r *http.Request) *A
 			w.SetError(E_UNAUTHORIZED_CLIENT, "missing client_id in form body")
 			return nil
 		}
-		client = getClientWithoutSecret(clientID, w.Storage, w)
+		client = getClientWithoutSecret(r.Context(), clientID, w.Storage, w)
 	} else {
 		// get client authentication
-		auth := GetClientAuth(w, r, s.Config.AllowClientSecretInParams)
+		auth := GetClientAuth(w, r, s.requestConfig(w).AllowClientSecretInParams)
 		if auth == nil {
 			return nil
 		}
-		client = getClient(auth, w.Storage, w)
+		client = s.authenticateClient(auth, w, r)
 	}
 
 	// generate access token
@@ -203,8 +439,10 @@ func (s *Server) handleAuthorizationCodeRequest(w *Response, r *http.Request) *A
 		CodeVerifier:    r.Form.Get("code_verifier"),
 		RedirectUri:     r.Form.Get("redirect_uri"),
 		GenerateRefresh: true,
-		Expiration:      s.Config.AccessExpiration,
+		Expiration:      s.requestConfig(w).AccessExpirationFor(AUTHORIZATION_CODE),
 		HttpRequest:     r,
+
+		RefreshExpiration: s.requestConfig(w).GrantExpirations[AUTHORIZATION_CODE].Refresh,
 	}
 
 	// "code" is required
@@ -219,7 +457,7 @@ func (s *Server) handleAuthorizationCodeRequest(w *Response, r *http.Request) *A
 	}
 
 	// must be a valid authorization code
-	ret.AuthorizeData, err = w.Storage.LoadAuthorize(ret.Code)
+	ret.AuthorizeData, err = storageLoadAuthorize(r.Context(), w.Storage, ret.Code)
 	if err != nil {
 		w.SetError(E_INVALID_GRANT, "failed to load authorize data")
 		w.InternalError = err
@@ -233,7 +471,7 @@ func (s *Server) handleAuthorizationCodeRequest(w *Response, r *http.Request) *A
 		w.SetError(E_UNAUTHORIZED_CLIENT, "client is empty in authorize data")
 		return nil
 	}
-	if ret.AuthorizeData.Client.GetRedirectURI() == "" {
+	if !hasRedirectURI(ret.AuthorizeData.Client) {
 		w.SetError(E_UNAUTHORIZED_CLIENT, "authorize client redirect uri is empty")
 		return nil
 	}
@@ -249,17 +487,7 @@ func (s *Server) handleAuthorizationCodeRequest(w *Response, r *http.Request) *A
 	}
 
 	// check redirect uri
-	if ret.RedirectUri == "" {
-		ret.RedirectUri = FirstUri(ret.Client.GetRedirectURI(), s.Config.RedirectUriSeparator)
-	}
-	if err = ValidateUriList(ret.Client.GetRedirectURI(), ret.RedirectUri, s.Config.RedirectUriSeparator); err != nil {
-		w.SetError(E_INVALID_REQUEST, err.Error())
-		w.InternalError = err
-		return nil
-	}
-	if ret.AuthorizeData.RedirectUri != ret.RedirectUri {
-		w.SetError(E_INVALID_REQUEST, "")
-		w.InternalError = errors.New("redirect uri is different")
+	if !s.checkCodeRedirectUri(w, ret) {
 		return nil
 	}
 
@@ -287,10 +515,65 @@ func (s *Server) handleAuthorizationCodeRequest(w *Response, r *http.Request) *A
 	// set rest of data
 	ret.Scope = ret.AuthorizeData.Scope
 	ret.UserData = ret.AuthorizeData.UserData
+	ret.AuthenticationContext = ret.AuthorizeData.AuthenticationContext
+	ret.Subject = ret.AuthorizeData.Subject
+	ret.Nonce = ret.AuthorizeData.Nonce
+	ret.ClaimsRequest = ret.AuthorizeData.ClaimsRequest
+	ret.GrantedScopes = ret.AuthorizeData.GrantedScopes
+	ret.GrantedAt = ret.AuthorizeData.GrantedAt
+
+	// authorization details may only narrow the ones granted in the authorize request
+	var ok bool
+	if ret.AuthorizationDetails, ok = s.getGrantedAuthorizationDetails(w, r, ret.Client, ret.AuthorizeData.AuthorizationDetails); !ok {
+		return nil
+	}
 
 	return ret
 }
 
+// checkCodeRedirectUri verifies the redirect uri of an authorization code
+// request. With Config.StrictRedirectUriMatch, as described in
+// https://tools.ietf.org/html/rfc6749#section-4.1.3, it must be present if
+// it was present in the authorize request, with the same value, and absent
+// otherwise.
+func (s *Server) checkCodeRedirectUri(w *Response, ret *AccessRequest) bool {
+	if !s.requestConfig(w).StrictRedirectUriMatch {
+		if ret.RedirectUri == "" {
+			ret.RedirectUri = FirstRedirectURI(s.redirectURIs(w, ret.Client))
+		}
+		if err := ValidateRedirectURIs(s.redirectURIs(w, ret.Client), ret.RedirectUri); err != nil {
+			w.SetError(E_INVALID_REQUEST, err.Error())
+			w.InternalError = err
+			return false
+		}
+		if ret.AuthorizeData.RedirectUri != ret.RedirectUri {
+			w.SetError(E_INVALID_REQUEST, "")
+			w.InternalError = errors.New("redirect uri is different")
+			return false
+		}
+		return true
+	}
+
+	if ret.AuthorizeData.RedirectUriDefaulted {
+		if ret.RedirectUri != "" {
+			w.SetError(E_INVALID_REQUEST, "redirect_uri was not in the authorize request")
+			return false
+		}
+	} else {
+		if ret.RedirectUri == "" {
+			w.SetError(E_INVALID_REQUEST, "redirect_uri is required")
+			return false
+		}
+		if ret.RedirectUri != ret.AuthorizeData.RedirectUri {
+			w.SetError(E_INVALID_GRANT, "redirect_uri does not match the authorize request")
+			w.InternalError = errors.New("redirect uri is different")
+			return false
+		}
+	}
+	ret.RedirectUri = ret.AuthorizeData.RedirectUri
+	return true
+}
+
 func extraScopes(access_scopes, refresh_scopes string) bool {
 	access_scopes_list := strings.Split(access_scopes, ",")
 	refresh_scopes_list := strings.Split(refresh_scopes, ",")
@@ -317,7 +600,7 @@ func extraScopes(access_scopes, refresh_scopes string) bool {
 
 func (s *Server) handleRefreshTokenRequest(w *Response, r *http.Request) *AccessRequest {
 	// get client authentication
-	auth := GetClientAuth(w, r, s.Config.AllowClientSecretInParams)
+	auth := GetClientAuth(w, r, s.requestConfig(w).AllowClientSecretInParams)
 	if auth == nil {
 		return nil
 	}
@@ -332,8 +615,8 @@ func (s *Server) handleRefreshTokenRequest(w *Response, r *http.Request) *Access
 		Code:              refreshToken,
 		Scope:             r.Form.Get("scope"),
 		GenerateRefresh:   true,
-		Expiration:        s.Config.AccessExpiration,
-		RefreshExpiration: s.Config.RefreshExpiration,
+		Expiration:        s.requestConfig(w).AccessExpirationFor(REFRESH_TOKEN),
+		RefreshExpiration: s.requestConfig(w).RefreshExpirationFor(REFRESH_TOKEN),
 		HttpRequest:       r,
 	}
 
@@ -344,19 +627,25 @@ func (s *Server) handleRefreshTokenRequest(w *Response, r *http.Request) *Access
 	}
 
 	// must have a valid client
-	if ret.Client = getClient(auth, w.Storage, w); ret.Client == nil {
+	if ret.Client = s.authenticateClient(auth, w, r); ret.Client == nil {
 		return nil
 	}
 
 	// must be a valid refresh code
 	var err error
-	ret.AccessData, err = w.Storage.LoadRefresh(ret.Code)
-	if err != nil {
+	ret.AccessData, err = s.loadRefresh(r.Context(), w.Storage, ret.Code)
+	if (err == ErrNotFound || (err == nil && ret.AccessData == nil)) && s.requestConfig(w).RefreshGracePeriod > 0 {
+		// a token just rotated by a concurrent request gets the same tokens
+		if data := s.loadRotatedRefresh(w.Storage, ret.Code); data != nil {
+			ret.AccessData, ret.ForceAccessData, err = data, data, nil
+		}
+	}
+	if err != nil && err != ErrNotFound {
 		w.SetError(E_SERVER_ERROR, "failed to load refresh_token")
 		w.InternalError = err
 		return nil
 	}
-	if ret.AccessData == nil {
+	if err == ErrNotFound || ret.AccessData == nil {
 		w.SetError(E_INVALID_GRANT, "refresh_toke is invalid")
 		return nil
 	}
@@ -364,10 +653,18 @@ func (s *Server) handleRefreshTokenRequest(w *Response, r *http.Request) *Access
 		w.SetError(E_INVALID_GRANT, "accessData client is empty")
 		return nil
 	}
-	if ret.AccessData.Client.GetRedirectURI() == "" {
+	if !hasRedirectURI(ret.AccessData.Client) {
 		w.SetError(E_INVALID_GRANT, "accessData client redirect uri is empty")
 		return nil
 	}
+	if revoked, err := s.isRevoked(ret.Code, ret.AccessData); err != nil {
+		w.SetError(E_SERVER_ERROR, "failed to check refresh_token revocation")
+		w.InternalError = err
+		return nil
+	} else if revoked {
+		w.SetError(E_INVALID_GRANT, "refresh_token was revoked")
+		return nil
+	}
 
 	// client must be the same as the previous token
 	if !CheckClientID(ret.AccessData.Client, ret.Client.GetID()) {
@@ -380,6 +677,9 @@ func (s *Server) handleRefreshTokenRequest(w *Response, r *http.Request) *Access
 	// set rest of data
 	ret.RedirectUri = ret.AccessData.RedirectUri
 	ret.UserData = ret.AccessData.UserData
+	ret.AuthenticationContext = ret.AccessData.AuthenticationContext
+	ret.Subject = ret.AccessData.Subject
+	ret.ClaimsRequest = ret.AccessData.ClaimsRequest
 	if ret.Scope == "" {
 		ret.Scope = ret.AccessData.Scope
 	}
@@ -390,12 +690,40 @@ func (s *Server) handleRefreshTokenRequest(w *Response, r *http.Request) *Access
 		return nil
 	}
 
+	// the user consent is kept, narrowed to the requested scope
+	if ret.AccessData.GrantedScopes != "" {
+		ret.GrantedScopes = intersectScopes(ret.AccessData.GrantedScopes, ret.Scope)
+		if ret.GrantedScopes == "" {
+			w.SetError(E_ACCESS_DENIED, "the requested scope must not include any scope not originally granted by the resource owner")
+			w.InternalError = errors.New("the requested scope was not granted by the resource owner")
+			return nil
+		}
+	}
+	ret.GrantedAt = ret.AccessData.GrantedAt
+
+	// authorization details may only narrow the ones previously granted
+	var ok bool
+	if ret.AuthorizationDetails, ok = s.getGrantedAuthorizationDetails(w, r, ret.Client, ret.AccessData.AuthorizationDetails); !ok {
+		return nil
+	}
+
+	// a refresh can't upgrade the authentication, the client must
+	// authenticate the user again if it demands more than achieved
+	authReq, err := ParseAuthenticationRequirement(r)
+	if err != nil {
+		w.SetError(E_INVALID_REQUEST, err.Error())
+		return nil
+	}
+	if !s.CheckAuthenticationRequirement(w, ret.AccessData, authReq) {
+		return nil
+	}
+
 	return ret
 }
 
 func (s *Server) handlePasswordRequest(w *Response, r *http.Request) *AccessRequest {
 	// get client authentication
-	auth := GetClientAuth(w, r, s.Config.AllowClientSecretInParams)
+	auth := GetClientAuth(w, r, s.requestConfig(w).AllowClientSecretInParams)
 	if auth == nil {
 		return nil
 	}
@@ -407,8 +735,8 @@ func (s *Server) handlePasswordRequest(w *Response, r *http.Request) *AccessRequ
 		Password:          r.Form.Get("password"),
 		Scope:             r.Form.Get("scope"),
 		GenerateRefresh:   true,
-		Expiration:        s.Config.AccessExpiration,
-		RefreshExpiration: s.Config.RefreshExpiration,
+		Expiration:        s.requestConfig(w).AccessExpirationFor(PASSWORD),
+		RefreshExpiration: s.requestConfig(w).RefreshExpirationFor(PASSWORD),
 		HttpRequest:       r,
 	}
 
@@ -419,19 +747,47 @@ func (s *Server) handlePasswordRequest(w *Response, r *http.Request) *AccessRequ
 	}
 
 	// must have a valid client
-	if ret.Client = getClient(auth, w.Storage, w); ret.Client == nil {
+	if ret.Client = s.authenticateClient(auth, w, r); ret.Client == nil {
 		return nil
 	}
 
+	// verify the password, if not left to the application
+	if pa := s.passwordAuthenticator(); pa != nil {
+		user, err := pa.AuthenticatePassword(r.Context(), ret.Username, ret.Password)
+		if err != nil {
+			if isUserUnknown(err) {
+				w.SetError(E_INVALID_GRANT, "invalid username or password")
+			} else {
+				w.SetError(E_SERVER_ERROR, "")
+			}
+			w.InternalError = err
+			return nil
+		}
+		ret.User = user
+		ret.Subject = user.Subject
+		ret.AuthenticationContext = AuthenticationContext{AMR: []string{"pwd"}, AuthTime: s.Now()}
+	}
+
 	// set redirect uri
-	ret.RedirectUri = FirstUri(ret.Client.GetRedirectURI(), s.Config.RedirectUriSeparator)
+	ret.RedirectUri = FirstRedirectURI(s.redirectURIs(w, ret.Client))
+
+	// apply the client default and maximum scope
+	var ok bool
+	if ret.Scope, ok = s.limitScope(w, ret.Client, ret.Scope, ""); !ok {
+		return nil
+	}
+
+	// optional authorization details
+	if ret.AuthorizationDetails, ok = s.getAuthorizationDetails(w, r, ret.Client, ""); !ok {
+		return nil
+	}
 
 	return ret
 }
 
 func (s *Server) handleAnonymousRequest(w *Response, r *http.Request) *AccessRequest {
 	// get client authentication
-	auth := GetClientAuth(w, r, s.Config.AllowClientSecretInParams)
+	auth := GetClientAuth(w, r, s.requestConfig(w).AllowClientSecretInParams)
 	if auth == nil {
 		return nil
 	}
@@ -442,25 +798,31 @@ func (s *Server) handleAnonymousRequest(w *Response, r *http.Request) *AccessReq
 		Username:          r.Form.Get("user_id"),
 		Scope:             r.Form.Get("scope"),
 		GenerateRefresh:   true,
-		Expiration:        s.Config.AccessExpiration,
-		RefreshExpiration: s.Config.RefreshExpiration,
+		Expiration:        s.requestConfig(w).AccessExpirationFor(ANONYMOUS),
+		RefreshExpiration: s.requestConfig(w).RefreshExpirationFor(ANONYMOUS),
 		HttpRequest:       r,
 	}
 
 	// must have a valid client
-	if ret.Client = getClient(auth, w.Storage, w); ret.Client == nil {
+	if ret.Client = s.authenticateClient(auth, w, r); ret.Client == nil {
 		return nil
 	}
 
 	// set redirect uri
-	ret.RedirectUri = FirstUri(ret.Client.GetRedirectURI(), s.Config.RedirectUriSeparator)
+	ret.RedirectUri = FirstRedirectURI(s.redirectURIs(w, ret.Client))
+
+	// apply the client default and maximum scope
+	var ok bool
+	if ret.Scope, ok = s.limitScope(w, ret.Client, ret.Scope, ""); !ok {
+		return nil
+	}
 
 	return ret
 }
 
 func (s *Server) handleDeviceRequest(w *Response, r *http.Request) *AccessRequest {
 	// get client authentication
-	auth := GetClientAuth(w, r, s.Config.AllowClientSecretInParams)
+	auth := GetClientAuth(w, r, s.requestConfig(w).AllowClientSecretInParams)
 	if auth == nil {
 		return nil
 	}
@@ -471,18 +833,24 @@ func (s *Server) handleDeviceRequest(w *Response, r *http.Request) *AccessReques
 		Password:          r.Form.Get("device_id"),
 		Scope:             r.Form.Get("scope"),
 		GenerateRefresh:   true,
-		Expiration:        s.Config.AccessExpiration,
-		RefreshExpiration: s.Config.RefreshExpiration,
+		Expiration:        s.requestConfig(w).AccessExpirationFor(DEVICE),
+		RefreshExpiration: s.requestConfig(w).RefreshExpirationFor(DEVICE),
 		HttpRequest:       r,
 	}
 
 	// must have a valid client
-	if ret.Client = getClient(auth, w.Storage, w); ret.Client == nil {
+	if ret.Client = s.authenticateClient(auth, w, r); ret.Client == nil {
 		return nil
 	}
 
 	// set redirect uri
-	ret.RedirectUri = FirstUri(ret.Client.GetRedirectURI(), s.Config.RedirectUriSeparator)
+	ret.RedirectUri = FirstRedirectURI(s.redirectURIs(w, ret.Client))
+
+	// apply the client default and maximum scope
+	var ok bool
+	if ret.Scope, ok = s.limitScope(w, ret.Client, ret.Scope, ""); !ok {
+		return nil
+	}
 
 	return ret
 }
@@ -503,14 +871,14 @@ func (s *Server) handlePlatformRequest(w *Response, r *http.Request) *AccessRequ
 			w.SetError(E_UNAUTHORIZED_CLIENT, "client_id is empty in form body")
 			return nil
 		}
-		client = getClientWithoutSecret(clientID, w.Storage, w)
+		client = getClientWithoutSecret(r.Context(), clientID, w.Storage, w)
 	} else {
 		// get client authentication
-		auth := GetClientAuth(w, r, s.Config.AllowClientSecretInParams)
+		auth := GetClientAuth(w, r, s.requestConfig(w).AllowClientSecretInParams)
 		if auth == nil {
 			return nil
 		}
-		client = getClient(auth, w.Storage, w)
+		client = s.authenticateClient(auth, w, r)
 	}
 
 	// generate access token
@@ -519,8 +887,8 @@ func (s *Server) handlePlatformRequest(w *Response, r *http.Request) *AccessRequ
 		Password:          r.Form.Get("platform_token"),
 		Scope:             r.Form.Get("scope"),
 		GenerateRefresh:   true,
-		Expiration:        s.Config.AccessExpiration,
-		RefreshExpiration: s.Config.RefreshExpiration,
+		Expiration:        s.requestConfig(w).AccessExpirationFor(PLATFORM),
+		RefreshExpiration: s.requestConfig(w).RefreshExpirationFor(PLATFORM),
 		HttpRequest:       r,
 	}
 
@@ -530,14 +898,20 @@ func (s *Server) handlePlatformRequest(w *Response, r *http.Request) *AccessRequ
 	}
 
 	// set redirect uri
-	ret.RedirectUri = FirstUri(ret.Client.GetRedirectURI(), s.Config.RedirectUriSeparator)
+	ret.RedirectUri = FirstRedirectURI(s.redirectURIs(w, ret.Client))
+
+	// apply the client default and maximum scope
+	var ok bool
+	if ret.Scope, ok = s.limitScope(w, ret.Client, ret.Scope, ""); !ok {
+		return nil
+	}
 
 	return ret
 }
 
 func (s *Server) handleClientCredentialsRequest(w *Response, r *http.Request) *AccessRequest {
 	// get client authentication
-	auth := GetClientAuth(w, r, s.Config.AllowClientSecretInParams)
+	auth := GetClientAuth(w, r, s.requestConfig(w).AllowClientSecretInParams)
 	if auth == nil {
 		return nil
 	}
@@ -551,25 +925,41 @@ func (s *Server) handleClientCredentialsRequest(w *Response, r *http.Request) *A
 		Type:            CLIENT_CREDENTIALS,
 		Scope:           r.Form.Get("scope"),
 		GenerateRefresh: false,
-		Expiration:      s.Config.AccessExpiration,
+		Expiration:      s.requestConfig(w).AccessExpirationFor(CLIENT_CREDENTIALS),
 		HttpRequest:     r,
 		SkipSetCookie:   true,
 	}
 
 	// must have a valid client
-	if ret.Client = getClient(auth, w.Storage, w); ret.Client == nil {
+	if ret.Client = s.authenticateClient(auth, w, r); ret.Client == nil {
 		return nil
 	}
 
 	// set redirect uri
-	ret.RedirectUri = FirstUri(ret.Client.GetRedirectURI(), s.Config.RedirectUriSeparator)
+	ret.RedirectUri = FirstRedirectURI(s.redirectURIs(w, ret.Client))
+
+	// resolve the granted scope
+	if !s.resolveScope(w, ret) {
+		return nil
+	}
+
+	// apply the client default and maximum scope
+	var ok bool
+	if ret.Scope, ok = s.limitScope(w, ret.Client, ret.Scope, ""); !ok {
+		return nil
+	}
+
+	// optional authorization details
+	if ret.AuthorizationDetails, ok = s.getAuthorizationDetails(w, r, ret.Client, ""); !ok {
+		return nil
+	}
 
 	return ret
 }
 
 func (s *Server) handleAssertionRequest(w *Response, r *http.Request) *AccessRequest {
 	// get client authentication
-	auth := GetClientAuth(w, r, s.Config.AllowClientSecretInParams)
+	auth := GetClientAuth(w, r, s.requestConfig(w).AllowClientSecretInParams)
 	if auth == nil {
 		return nil
 	}
@@ -581,7 +971,7 @@ func (s *Server) handleAssertionRequest(w *Response, r *http.Request) *AccessReq
 		AssertionType:   r.Form.Get("assertion_type"),
 		Assertion:       r.Form.Get("assertion"),
 		GenerateRefresh: false, // assertion should NOT generate a refresh token, per the RFC
-		Expiration:      s.Config.AccessExpiration,
+		Expiration:      s.requestConfig(w).AccessExpirationFor(ASSERTION),
 		HttpRequest:     r,
 	}
 
@@ -592,12 +982,23 @@ func (s *Server) handleAssertionRequest(w *Response, r *http.Request) *AccessReq
 	}
 
 	// must have a valid client
-	if ret.Client = getClient(auth, w.Storage, w); ret.Client == nil {
+	if ret.Client = s.authenticateClient(auth, w, r); ret.Client == nil {
+		return nil
+	}
+
+	// verify the assertion if its type is registered
+	if !s.verifyAssertion(w, ret) {
 		return nil
 	}
 
 	// set redirect uri
-	ret.RedirectUri = FirstUri(ret.Client.GetRedirectURI(), s.Config.RedirectUriSeparator)
+	ret.RedirectUri = FirstRedirectURI(s.redirectURIs(w, ret.Client))
+
+	// apply the client default and maximum scope
+	var ok bool
+	if ret.Scope, ok = s.limitScope(w, ret.Client, ret.Scope, ""); !ok {
+		return nil
+	}
 
 	return ret
 }
@@ -607,77 +1008,230 @@ func (s *Server) FinishAccessRequest(w *Response, r *http.Request, ar *AccessReq
 	if w.IsError {
 		return
 	}
+	s.finishAccessRequest(w, r, ar)
+}
+
+func (s *Server) finishAccessRequest(w *Response, r *http.Request, ar *AccessRequest) {
 	redirectUri := r.Form.Get("redirect_uri")
 	// Get redirect uri from AccessRequest if it's there (e.g., refresh token request)
 	if ar.RedirectUri != "" {
 		redirectUri = ar.RedirectUri
 	}
-	if ar.Authorized {
-		var ret *AccessData
-		var err error
-
-		if ar.ForceAccessData == nil {
-			// generate access token
-			ret = &AccessData{
-				Client:          ar.Client,
-				AuthorizeData:   ar.AuthorizeData,
-				AccessData:      ar.AccessData,
-				RedirectUri:     redirectUri,
-				CreatedAt:       s.Now(),
-				ExpiresIn:       ar.Expiration,
-				RefreshExpireIn: ar.RefreshExpiration,
-				UserData:        ar.UserData,
-				Scope:           ar.Scope,
-			}
+	s.approveAccess(w, r, ar)
+	if !s.evaluateRisk(w, ar) {
+		return
+	}
+	if ar.Authorized && ar.RequireMFA && ar.Type != MFA_OTP {
+		s.issueMFAChallenge(w, ar)
+		return
+	}
+	if !ar.Authorized {
+		w.SetError(E_ACCESS_DENIED, "")
+		return
+	}
+
+	// issue once for the concurrent requests with the same idempotency
+	// key, the ones waiting replay the saved response
+	if key, _ := s.idempotencyKey(w, r); key != "" {
+		defer s.lockIdempotency(key)()
+		if s.replayIssuedResponse(w, r, ar.Client) {
+			return
+		}
+	}
+
+	// coalesce identical refreshes, each authorized on its own
+	if ar.Type == REFRESH_TOKEN && s.requestConfig(w).CoalesceRefreshRequests {
+		s.refreshFlights.do(refreshFlightKey(ar, redirectUri), w, func() {
+			s.issueAccess(w, r, ar, redirectUri)
+		})
+		return
+	}
+	s.issueAccess(w, r, ar, redirectUri)
+}
 
-			// generate access token
-			ret.AccessToken, ret.RefreshToken, err = s.AccessTokenGen.GenerateAccessToken(ret, ar.GenerateRefresh)
-			if err != nil {
+// issueAccess issues the tokens of an authorized access request
+func (s *Server) issueAccess(w *Response, r *http.Request, ar *AccessRequest, redirectUri string) {
+	var ret *AccessData
+	var tokenTypeFields map[string]interface{}
+	var err error
+
+	// grants not persisted can't be refreshed
+	persist := s.requestConfig(w).PersistenceFor(ar.Type) != PERSIST_NEVER
+	generateRefresh := ar.GenerateRefresh && persist
+
+	// serialize the refreshes of the same token, so the ones racing
+	// with a rotation get the same tokens
+	graceRefresh := ar.Type == REFRESH_TOKEN && s.requestConfig(w).RefreshGracePeriod > 0 && ar.ForceAccessData == nil
+	if graceRefresh {
+		defer s.lockRefresh(ar.Code)()
+		if data := s.loadRotatedRefresh(w.Storage, ar.Code); data != nil {
+			ar.ForceAccessData, graceRefresh = data, false
+		}
+	}
+
+	if ar.ForceAccessData == nil {
+		// consume the single-use grants before issuing
+		if ar.PreAuthorizedCode != nil && !s.consumePreAuthorizedCode(w, ar) {
+			return
+		}
+		if ar.MFAChallenge != nil && !s.consumeMFAChallenge(w, ar) {
+			return
+		}
+
+		// generate access token
+		ret = &AccessData{
+			Client:          ar.Client,
+			AuthorizeData:   ar.AuthorizeData,
+			AccessData:      s.accessLineage(w, ar.AccessData),
+			RedirectUri:     redirectUri,
+			CreatedAt:       s.Now(),
+			ExpiresIn:       ar.Expiration,
+			RefreshExpireIn: ar.RefreshExpiration,
+			UserData:        ar.UserData,
+			Scope:           ar.Scope,
+
+			AuthorizationDetails: ar.AuthorizationDetails,
+
+			AuthenticationContext: ar.AuthenticationContext,
+			Subject:               ar.Subject,
+			RiskLevel:             ar.RiskLevel,
+			ClaimsRequest:         ar.ClaimsRequest,
+			GrantedScopes:         ar.GrantedScopes,
+			GrantedAt:             ar.GrantedAt,
+			Fingerprint:           s.fingerprint(w, r),
+			DPoPJKT:               ar.DPoPJKT,
+			CertThumbprint:        ar.CertThumbprint,
+		}
+		if ret.GrantedAt.IsZero() {
+			ret.GrantedAt = ret.CreatedAt
+		}
+		if ret.CredentialVersion, err = s.credentialVersion(ret.Subject); err != nil {
+			w.SetError(E_SERVER_ERROR, "")
+			w.InternalError = err
+			return
+		}
+		if ret.TokenType, tokenTypeFields, err = s.selectTokenType(w, ar); err != nil {
+			w.SetError(E_SERVER_ERROR, "")
+			w.InternalError = err
+			return
+		}
+		if err = setAccessFamily(ret, ar.AccessData); err != nil {
+			w.SetError(E_SERVER_ERROR, "")
+			w.InternalError = err
+			return
+		}
+		if ar.IssueCNonce {
+			if err = s.issueCNonce(w, ret); err != nil {
 				w.SetError(E_SERVER_ERROR, "")
 				w.InternalError = err
 				return
 			}
-		} else {
-			ret = ar.ForceAccessData
 		}
 
-		// save access token
-		if err = w.Storage.SaveAccess(ret); err != nil {
+		// generate access token, and the refresh token unless it has
+		// its own generator
+		generaterefresh := generateRefresh && s.RefreshTokenGen == nil
+		if gen, ok := s.AccessTokenGen.(AccessTokenGenWithRequest); ok {
+			ret.AccessToken, ret.RefreshToken, err = gen.GenerateAccessTokenWithRequest(ar, ret, generaterefresh)
+		} else if gen, ok := s.AccessTokenGen.(AccessTokenGenWithContext); ok {
+			ret.AccessToken, ret.RefreshToken, err = gen.GenerateAccessTokenContext(ar.Context(), ret, generaterefresh)
+		} else {
+			ret.AccessToken, ret.RefreshToken, err = s.AccessTokenGen.GenerateAccessToken(ret, generaterefresh)
+		}
+		if err == nil && generateRefresh && s.RefreshTokenGen != nil {
+			ret.RefreshToken, err = s.RefreshTokenGen.GenerateRefreshToken(ret)
+		}
+		if err != nil {
 			w.SetError(E_SERVER_ERROR, "")
 			w.InternalError = err
 			return
 		}
 
-		// remove authorization token
-		if ret.AuthorizeData != nil {
-			w.Storage.RemoveAuthorize(ret.AuthorizeData.Code)
-		}
+		// run the shadow generator, if any
+		s.generateShadowToken(ret, generateRefresh)
+	} else {
+		ret = ar.ForceAccessData
+	}
 
-		// remove previous access token
-		if ret.AccessData != nil && !s.Config.RetainTokenAfterRefresh {
-			w.Storage.RemoveAccess(ret.AccessData.AccessToken)
+	// save access token
+	if persist {
+		if err = storageSaveAccess(ar.Context(), w.Storage, ret); err != nil {
+			w.SetError(E_SERVER_ERROR, "")
+			w.InternalError = err
+			return
 		}
+	}
 
-		// output data
-		w.Output["access_token"] = ret.AccessToken
-		w.Output["token_type"] = s.Config.TokenType
-		w.Output["expires_in"] = ret.ExpiresIn
-		if ret.RefreshToken != "" {
-			w.Output["refresh_token"] = ret.RefreshToken
-			w.Output["refresh_expires_in"] = ret.RefreshExpireIn
-			if !ar.SkipSetCookie {
-				AddTokenInCookie(w, ret.RefreshToken, "refresh_token", int64(int32(time.Now().Unix())+ret.RefreshExpireIn), s.Config.CookieDomain)
-			}
-		}
-		if ret.Scope != "" {
-			w.Output["scope"] = ret.Scope
+	// remember the rotation during the grace period
+	if graceRefresh {
+		if err = s.saveRotatedRefresh(w, ar.Code, ret); err != nil {
+			w.SetError(E_SERVER_ERROR, "")
+			w.InternalError = err
+			return
 		}
+	}
+
+	if ar.Type == REFRESH_TOKEN {
+		s.trackRefreshUsage(r, ret)
+	}
+
+	s.emitEvent(&Event{
+		Type:    EVENT_TOKEN_ISSUED,
+		Client:  ar.Client,
+		Request: r,
+		Data:    map[string]interface{}{"grant_type": ar.Type, "subject": ret.Subject, "fingerprint": ret.Fingerprint},
+	})
 
-		if !ar.SkipSetCookie {
-			AddTokenInCookie(w, ret.AccessToken, "access_token", int64(int32(time.Now().Unix())+ret.ExpiresIn), s.Config.CookieDomain)
+	// remove authorization token
+	if ret.AuthorizeData != nil {
+		storageRemoveAuthorize(ar.Context(), w.Storage, ret.AuthorizeData.Code)
+	}
+
+	// remove device authorization
+	if ar.DeviceAuthorization != nil {
+		if ds, ok := storageAs[DeviceStorage](w.Storage); ok {
+			ds.RemoveDeviceAuthorization(ar.DeviceAuthorization.DeviceCode)
 		}
-	} else {
-		w.SetError(E_ACCESS_DENIED, "")
+	}
+
+	// remove previous access token, which may be unlinked from the
+	// lineage of the new grant
+	previous := ret.AccessData
+	if ar.ForceAccessData == nil {
+		previous = ar.AccessData
+	}
+	if previous != nil && !s.requestConfig(w).RetainTokenAfterRefresh {
+		storageRemoveAccess(ar.Context(), w.Storage, previous.AccessToken)
+	}
+
+	// output data
+	w.SetTokenResponse(&TokenResponse{
+		AccessToken:          ret.AccessToken,
+		TokenType:            s.accessTokenType(w, ret),
+		ExpiresIn:            ret.ExpiresIn,
+		RefreshToken:         ret.RefreshToken,
+		RefreshExpiresIn:     ret.RefreshExpireIn,
+		Scope:                ret.GrantedScope(),
+		AuthorizationDetails: ret.AuthorizationDetails,
+		Extra:                tokenTypeFields,
+	})
+
+	if ret.CNonce != "" {
+		w.Output["c_nonce"] = ret.CNonce
+		w.Output["c_nonce_expires_in"] = int32(ret.CNonceExpiresAt.Sub(s.Now()).Seconds())
+	}
+
+	if ret.RefreshToken != "" && !ar.SkipSetCookie {
+		AddTokenInCookie(w, ret.RefreshToken, "refresh_token", int64(int32(time.Now().Unix())+ret.RefreshExpireIn), s.requestConfig(w).CookieDomain)
+	}
+	if !ar.SkipSetCookie {
+		AddTokenInCookie(w, ret.AccessToken, "access_token", int64(int32(time.Now().Unix())+ret.ExpiresIn), s.requestConfig(w).CookieDomain)
+	}
+
+	// save the response for replays of the idempotency key
+	if err = s.saveIdempotentResponse(w, r, ret.Client); err != nil {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = err
 	}
 }
 
@@ -685,34 +1239,80 @@ func (s *Server) FinishAccessRequest(w *Response, r *http.Request, ar *AccessReq
 
 // getClient looks up and authenticates the basic auth using the given
 // storage. Sets an error on the response if auth fails or a server error occurs.
-func getClient(auth *BasicAuth, storage Storage, w *Response) Client {
-	client, err := storage.GetClient(auth.Username)
+func getClient(ctx context.Context, auth *BasicAuth, storage Storage, w *Response) Client {
+	client, _ := matchClient(ctx, auth, storage, w, time.Now())
+	return client
+}
+
+// matchClient is getClient matching the secrets of the client at time
+// 'now', also returning the name of the secret that matched
+func matchClient(ctx context.Context, auth *BasicAuth, storage Storage, w *Response, now time.Time) (Client, string) {
+	client, err := storageGetClient(ctx, storage, auth.Username)
 	if err != nil && err != ErrNotFound {
 		w.SetError(E_SERVER_ERROR, "failed to get oauth client")
 		w.InternalError = err
-		return nil
+		return nil, ""
 	}
 	if client == nil {
 		w.SetError(E_INVALID_CLIENT, "oauth client is empty")
-		return nil
+		return nil, ""
 	}
 
-	if !CheckClientSecret(client, auth.Password) {
+	secretName, ok := MatchClientSecret(client, auth.Password, now)
+	if !ok {
 		w.SetError(E_INVALID_CLIENT, "oauth client secret not match")
-		return nil
+		return nil, ""
 	}
 
-	if client.GetRedirectURI() == "" {
+	if !hasRedirectURI(client) {
 		w.SetError(E_INVALID_CLIENT, "oauth client redirect uri is empty")
+		return nil, ""
+	}
+	return client, secretName
+}
+
+// authenticateClient authenticates the client like getClient, throttling
+// failed attempts per presented client_id and source IP when the server has
+// a ClientAuthLimiter, and emitting EVENT_CLIENT_AUTHENTICATED on success.
+func (s *Server) authenticateClient(auth *BasicAuth, w *Response, r *http.Request) Client {
+	key := auth.Username + "|" + RemoteIP(r)
+	if s.ClientAuthLimiter != nil {
+		if ok, wait := s.ClientAuthLimiter.Allow(key); !ok {
+			w.SetError(E_INVALID_CLIENT, "too many failed client authentication attempts")
+			w.InternalError = errors.New("client authentication throttled")
+			w.Headers.Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			return nil
+		}
+	}
+
+	client, secretName := matchClient(r.Context(), auth, w.Storage, w, s.Now())
+	if client == nil {
+		if s.ClientAuthLimiter != nil && w.ErrorId == E_INVALID_CLIENT {
+			s.ClientAuthLimiter.Fail(key)
+		}
+		// https://tools.ietf.org/html/rfc6749#section-5.2
+		if w.ErrorId == E_INVALID_CLIENT && (r.Header.Get("Authorization") != "" || w.StatusCode == http.StatusUnauthorized) {
+			w.SetChallenge("Basic", s.requestConfig(w).Realm, "")
+		}
 		return nil
 	}
+	if s.ClientAuthLimiter != nil {
+		s.ClientAuthLimiter.Reset(key)
+	}
+
+	s.emitEvent(&Event{
+		Type:    EVENT_CLIENT_AUTHENTICATED,
+		Client:  client,
+		Request: r,
+		Data:    map[string]interface{}{"secret": secretName},
+	})
 	return client
 }
 
 // getClientWithoutSecret looks up and authenticates the client using the given
 // storage. Sets an error on the response if auth fails or a server error occurs.
-func getClientWithoutSecret(clientId string, storage Storage, w *Response) Client {
-	client, err := storage.GetClient(clientId)
+func getClientWithoutSecret(ctx context.Context, clientId string, storage Storage, w *Response) Client {
+	client, err := storageGetClient(ctx, storage, clientId)
 	if err != nil {
 		w.SetError(E_SERVER_ERROR, "failed to get oauth client")
 		w.InternalError = err
@@ -723,7 +1323,7 @@ func getClientWithoutSecret(clientId string, storage Storage, w *Response) Clien
 		return nil
 	}
 
-	if client.GetRedirectURI() == "" {
+	if !hasRedirectURI(client) {
 		w.SetError(E_UNAUTHORIZED_CLIENT, "oauth client redirect uri is empty")
 		return nil
 	}
@@ -1,6 +1,7 @@
 package osin
 
 import (
+	"context"
 	"net/http"
 	"net/url"
 	"testing"
@@ -23,6 +24,7 @@ func TestAccessAuthorizationCode(t *testing.T) {
 	req.Form = make(url.Values)
 	req.Form.Set("grant_type", string(AUTHORIZATION_CODE))
 	req.Form.Set("code", "9999")
+	req.Form.Set("redirect_uri", "http://localhost:14000/appauth")
 	req.Form.Set("state", "a")
 	req.PostForm = make(url.Values)
 
@@ -313,6 +315,48 @@ func TestAccessPassword(t *testing.T) {
 	}
 }
 
+func TestGrantPersistence(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{PASSWORD, CLIENT_CREDENTIALS}
+	sconfig.GrantPersistence = map[AccessRequestType]GrantPersistence{CLIENT_CREDENTIALS: PERSIST_NEVER}
+	storage := NewTestingStorage()
+	server := NewServer(sconfig, storage)
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+
+	tests := map[AccessRequestType]struct {
+		token   string
+		persist bool
+	}{
+		CLIENT_CREDENTIALS: {"1", false},
+		PASSWORD:           {"2", true},
+	}
+	for _, grantType := range []AccessRequestType{CLIENT_CREDENTIALS, PASSWORD} {
+		test := tests[grantType]
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = url.Values{"grant_type": {string(grantType)}, "username": {"testing"}, "password": {"testing"}}
+		req.PostForm = make(url.Values)
+
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.GenerateRefresh = true
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if resp.IsError || resp.Output["access_token"] != test.token {
+			t.Fatalf("%s: unexpected response %v", grantType, resp.Output)
+		}
+		_, hasRefresh := resp.Output["refresh_token"]
+		_, saved := storage.access[test.token]
+		if hasRefresh != test.persist || saved != test.persist {
+			t.Errorf("%s: expected persistence %t, got refresh token %t and saved %t", grantType, test.persist, hasRefresh, saved)
+		}
+	}
+}
+
 func TestAccessClientCredentials(t *testing.T) {
 	sconfig := NewServerConfig()
 	sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
@@ -608,7 +652,7 @@ func TestGetClientWithoutMatcher(t *testing.T) {
 			Password: "invalidsecret",
 		}
 		w := &Response{}
-		client := getClient(auth, storage, w)
+		client := getClient(context.Background(), auth, storage, w)
 		if client != nil {
 			t.Errorf("Expected error, got client: %v", client)
 		}
@@ -621,7 +665,7 @@ func TestGetClientWithoutMatcher(t *testing.T) {
 			Password: "myclientsecret",
 		}
 		w := &Response{}
-		client := getClient(auth, storage, w)
+		client := getClient(context.Background(), auth, storage, w)
 		if client != myclient {
 			t.Errorf("Expected client, got nil with response: %v", w)
 		}
@@ -658,7 +702,7 @@ func TestGetClientSecretMatcher(t *testing.T) {
 			Password: "invalidsecret",
 		}
 		w := &Response{}
-		client := getClient(auth, storage, w)
+		client := getClient(context.Background(), auth, storage, w)
 		if client != nil {
 			t.Errorf("Expected error, got client: %v", client)
 		}
@@ -671,7 +715,7 @@ func TestGetClientSecretMatcher(t *testing.T) {
 			Password: "myclientsecret",
 		}
 		w := &Response{}
-		client := getClient(auth, storage, w)
+		client := getClient(context.Background(), auth, storage, w)
 		if client != myclient {
 			t.Errorf("Expected client, got nil with response: %v", w)
 		}
@@ -739,6 +783,7 @@ func TestAccessAuthorizationCodePKCE(t *testing.T) {
 		req.Form = make(url.Values)
 		req.Form.Set("grant_type", string(AUTHORIZATION_CODE))
 		req.Form.Set("code", "pkce-code")
+		req.Form.Set("redirect_uri", "http://localhost:14000/appauth")
 		req.Form.Set("state", "a")
 		req.Form.Set("code_verifier", test.Verifier)
 		req.PostForm = make(url.Values)
@@ -767,3 +812,237 @@ func TestAccessAuthorizationCodePKCE(t *testing.T) {
 		}
 	}
 }
+
+func TestGrantExpirations(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS, PASSWORD}
+	sconfig.GrantExpirations = map[AccessRequestType]GrantExpiration{
+		CLIENT_CREDENTIALS: {Access: 300},
+		PASSWORD:           {Refresh: 7200},
+	}
+	server := NewServer(sconfig, NewTestingStorage())
+
+	testcases := map[string]struct {
+		Form              url.Values
+		Expiration        int32
+		RefreshExpiration int32
+	}{
+		"client credentials": {
+			Form:       url.Values{"grant_type": {string(CLIENT_CREDENTIALS)}},
+			Expiration: 300,
+		},
+		"password": {
+			Form:              url.Values{"grant_type": {string(PASSWORD)}, "username": {"testing"}, "password": {"testing"}},
+			Expiration:        3600,
+			RefreshExpiration: 7200,
+		},
+	}
+
+	for k, tc := range testcases {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = tc.Form
+		req.PostForm = tc.Form
+
+		ar := server.HandleAccessRequest(resp, req)
+		if ar == nil {
+			t.Fatalf("%s: request should succeed: %v", k, resp.Output)
+		}
+		if ar.Expiration != tc.Expiration || ar.RefreshExpiration != tc.RefreshExpiration {
+			t.Errorf("%s: unexpected expirations %d/%d", k, ar.Expiration, ar.RefreshExpiration)
+		}
+	}
+}
+
+func TestAccessAuthorizationCodeRedirectUri(t *testing.T) {
+	testcases := map[string]struct {
+		Defaulted     bool
+		RedirectUri   string
+		Strict        bool
+		ExpectedError string
+	}{
+		"present in both": {
+			RedirectUri: "http://localhost:14000/appauth",
+			Strict:      true,
+		},
+		"missing": {
+			Strict:        true,
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		"different": {
+			RedirectUri:   "http://localhost:14000/appauth/other",
+			Strict:        true,
+			ExpectedError: E_INVALID_GRANT,
+		},
+		"absent in both": {
+			Defaulted: true,
+			Strict:    true,
+		},
+		"absent in authorize": {
+			Defaulted:     true,
+			RedirectUri:   "http://localhost:14000/appauth",
+			Strict:        true,
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		"default missing": {},
+		"default absent in authorize": {
+			Defaulted:   true,
+			RedirectUri: "http://localhost:14000/appauth",
+		},
+		"default different": {
+			RedirectUri:   "http://localhost:14000/appauth/other",
+			ExpectedError: E_INVALID_REQUEST,
+		},
+	}
+
+	for k, tc := range testcases {
+		storage := NewTestingStorage()
+		storage.authorize["9999"].RedirectUriDefaulted = tc.Defaulted
+		sconfig := NewServerConfig()
+		sconfig.StrictRedirectUriMatch = tc.Strict
+		server := NewServer(sconfig, storage)
+		server.AccessTokenGen = &TestingAccessTokenGen{}
+		resp := server.NewResponse()
+
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = make(url.Values)
+		req.Form.Set("grant_type", string(AUTHORIZATION_CODE))
+		req.Form.Set("code", "9999")
+		if tc.RedirectUri != "" {
+			req.Form.Set("redirect_uri", tc.RedirectUri)
+		}
+		req.PostForm = make(url.Values)
+
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if resp.ErrorId != tc.ExpectedError {
+			t.Errorf("%s: expected error %q, got %v", k, tc.ExpectedError, resp.Output)
+		}
+	}
+}
+
+func TestAccessTypeClients(t *testing.T) {
+	testcases := map[string]struct {
+		List    ClientAccessList
+		Allowed bool
+	}{
+		"allowed": {
+			List:    ClientAccessList{Allow: []string{"1234", "5678"}},
+			Allowed: true,
+		},
+		"not allowed": {
+			List: ClientAccessList{Allow: []string{"5678"}},
+		},
+		"denied": {
+			List: ClientAccessList{Deny: []string{"1234"}},
+		},
+		"allowed and denied": {
+			List: ClientAccessList{Allow: []string{"1234"}, Deny: []string{"1234"}},
+		},
+	}
+
+	for k, tc := range testcases {
+		sconfig := NewServerConfig()
+		sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+		sconfig.AccessTypeClients = map[AccessRequestType]ClientAccessList{CLIENT_CREDENTIALS: tc.List}
+		server := NewServer(sconfig, NewTestingStorage())
+		server.AccessTokenGen = &TestingAccessTokenGen{}
+		resp := server.NewResponse()
+
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = url.Values{"grant_type": {string(CLIENT_CREDENTIALS)}}
+		req.PostForm = make(url.Values)
+
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if tc.Allowed && resp.IsError {
+			t.Errorf("%s: unexpected error: %v", k, resp.Output)
+		}
+		if !tc.Allowed && resp.ErrorId != E_UNAUTHORIZED_CLIENT {
+			t.Errorf("%s: expected %s, got %v", k, E_UNAUTHORIZED_CLIENT, resp.Output)
+		}
+	}
+}
+
+type clientWithGrantTypes struct {
+	DefaultClient
+	GrantTypes []AccessRequestType
+}
+
+func (c *clientWithGrantTypes) GetGrantTypes() []AccessRequestType {
+	return c.GrantTypes
+}
+
+func TestClientGrantTypes(t *testing.T) {
+	testcases := map[string]struct {
+		GrantTypes []AccessRequestType
+		Token      bool
+		Implicit   bool
+	}{
+		"unlimited":          {Token: true, Implicit: true},
+		"client credentials": {GrantTypes: []AccessRequestType{CLIENT_CREDENTIALS}, Token: true},
+		"implicit":           {GrantTypes: []AccessRequestType{IMPLICIT}, Implicit: true},
+	}
+
+	for k, tc := range testcases {
+		sconfig := NewServerConfig()
+		sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+		sconfig.AllowedAuthorizeTypes = AllowedAuthorizeType{TOKEN}
+		storage := NewTestingStorage()
+		storage.SetClient("1234", &clientWithGrantTypes{
+			DefaultClient: DefaultClient{Id: "1234", Secret: "aabbccdd", RedirectUri: "http://localhost:14000/appauth"},
+			GrantTypes:    tc.GrantTypes,
+		})
+		server := NewServer(sconfig, storage)
+		server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+		server.AccessTokenGen = &TestingAccessTokenGen{}
+
+		resp := server.NewResponse()
+		req, _ := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = url.Values{"grant_type": {string(CLIENT_CREDENTIALS)}}
+		req.PostForm = make(url.Values)
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if tc.Token && resp.IsError {
+			t.Errorf("%s: unexpected token error: %v", k, resp.Output)
+		}
+		if !tc.Token && resp.ErrorId != E_UNAUTHORIZED_CLIENT {
+			t.Errorf("%s: expected %s, got %v", k, E_UNAUTHORIZED_CLIENT, resp.Output)
+		}
+		resp.Close()
+
+		resp = server.NewResponse()
+		req, _ = http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+		req.Form = url.Values{"response_type": {string(TOKEN)}, "client_id": {"1234"}, "state": {"a"}}
+		if ar := server.HandleAuthorizeRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAuthorizeRequest(resp, req, ar)
+		}
+		if tc.Implicit && resp.IsError {
+			t.Errorf("%s: unexpected implicit error: %v", k, resp.Output)
+		}
+		if !tc.Implicit && resp.ErrorId != E_UNAUTHORIZED_CLIENT {
+			t.Errorf("%s: expected implicit %s, got %v", k, E_UNAUTHORIZED_CLIENT, resp.Output)
+		}
+		resp.Close()
+	}
+}
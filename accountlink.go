@@ -0,0 +1,287 @@
+package osin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrAccountLinked is returned when linking an upstream identity already
+// linked to another local user
+var ErrAccountLinked = errors.New("upstream identity already linked to another user")
+
+// AccountLinkConflictError is returned by AccountLinker when a new upstream
+// identity has the email of an existing user. The identity isn't linked
+// automatically, as the provider may not own the email: the application
+// should ask the user to log in to the existing account and call Link.
+type AccountLinkConflictError struct {
+	Identity *UpstreamIdentity
+
+	// Local user with the same email
+	Subject string
+}
+
+func (e *AccountLinkConflictError) Error() string {
+	return fmt.Sprintf("email of the %s identity %s already used by user %s", e.Identity.Provider, e.Identity.Subject, e.Subject)
+}
+
+// AccountLink links an upstream identity to a local user
+type AccountLink struct {
+	// Identity at the upstream provider
+	Provider        string
+	Issuer          string
+	UpstreamSubject string
+
+	// Local subject identifier of the user
+	Subject string
+
+	// Email of the upstream identity, lowercased, for conflict detection
+	Email string
+
+	// Date linked
+	LinkedAt time.Time
+}
+
+// AccountLinkStore stores the links of the upstream identities to the local
+// users. An upstream identity is linked to a single user, a user may have
+// several linked identities.
+type AccountLinkStore interface {
+	// LoadAccountLink looks up the link of an upstream identity.
+	// Returns ErrNotFound if not found.
+	LoadAccountLink(issuer, upstreamSubject string) (*AccountLink, error)
+
+	// AccountLinksBySubject lists the identities linked to a local user
+	AccountLinksBySubject(subject string) ([]*AccountLink, error)
+
+	// AccountLinksByEmail lists the identities with the email
+	AccountLinksByEmail(email string) ([]*AccountLink, error)
+
+	// SaveAccountLink saves a link. Returns ErrAccountLinked if the
+	// upstream identity is linked to another user.
+	SaveAccountLink(link *AccountLink) error
+
+	// RemoveAccountLink deletes the link of an upstream identity
+	RemoveAccountLink(issuer, upstreamSubject string) error
+}
+
+// MemoryAccountLinkStore is an in-memory AccountLinkStore, for single
+// instance deployments and tests
+type MemoryAccountLinkStore struct {
+	mu    sync.Mutex
+	links map[string]*AccountLink
+}
+
+// NewMemoryAccountLinkStore creates a new MemoryAccountLinkStore
+func NewMemoryAccountLinkStore() *MemoryAccountLinkStore {
+	return &MemoryAccountLinkStore{
+		links: make(map[string]*AccountLink),
+	}
+}
+
+func accountLinkKey(issuer, upstreamSubject string) string {
+	return issuer + "\x00" + upstreamSubject
+}
+
+func (m *MemoryAccountLinkStore) LoadAccountLink(issuer, upstreamSubject string) (*AccountLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if l, ok := m.links[accountLinkKey(issuer, upstreamSubject)]; ok {
+		c := *l
+		return &c, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MemoryAccountLinkStore) AccountLinksBySubject(subject string) ([]*AccountLink, error) {
+	return m.filter(func(l *AccountLink) bool { return l.Subject == subject }), nil
+}
+
+func (m *MemoryAccountLinkStore) AccountLinksByEmail(email string) ([]*AccountLink, error) {
+	return m.filter(func(l *AccountLink) bool { return l.Email == email }), nil
+}
+
+func (m *MemoryAccountLinkStore) filter(match func(l *AccountLink) bool) []*AccountLink {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ret []*AccountLink
+	for _, l := range m.links {
+		if match(l) {
+			c := *l
+			ret = append(ret, &c)
+		}
+	}
+	return ret
+}
+
+func (m *MemoryAccountLinkStore) SaveAccountLink(link *AccountLink) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := accountLinkKey(link.Issuer, link.UpstreamSubject)
+	if l, ok := m.links[key]; ok && l.Subject != link.Subject {
+		return ErrAccountLinked
+	}
+	c := *link
+	m.links[key] = &c
+	return nil
+}
+
+func (m *MemoryAccountLinkStore) RemoveAccountLink(issuer, upstreamSubject string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.links, accountLinkKey(issuer, upstreamSubject))
+	return nil
+}
+
+// UserEmailFinder is implemented by the UserStores able to look up users
+// by email, for the conflict detection of AccountLinker
+type UserEmailFinder interface {
+	// FindUserByEmail returns the user with the email, ErrNotFound if none
+	FindUserByEmail(ctx context.Context, email string) (*User, error)
+}
+
+// AccountLinker is an IdentityMapper resolving the upstream identities to
+// the local users they are linked to, so brokered logins keep the same
+// subject. New identities create a new user, unless their email is used by
+// another user, see AccountLinkConflictError.
+type AccountLinker struct {
+	Store AccountLinkStore
+
+	// Local users checked for email conflicts, if it implements
+	// UserEmailFinder
+	UserStore UserStore
+
+	// Returns the subject of the user created for a new identity - a
+	// random identifier if nil
+	NewSubject func(ctx context.Context, id *UpstreamIdentity) (string, error)
+
+	// Current time, time.Now if nil
+	Now func() time.Time
+}
+
+func (l *AccountLinker) now() time.Time {
+	if l.Now != nil {
+		return l.Now()
+	}
+	return time.Now()
+}
+
+// upstreamEmail returns the lowercased email claim of the identity
+func upstreamEmail(id *UpstreamIdentity) string {
+	email, _ := id.Claims["email"].(string)
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// MapIdentity satisfies IdentityMapper, setting the subject of the linked
+// user, or linking the identity to a new user. The identity is kept as
+// UserData if not set.
+func (l *AccountLinker) MapIdentity(ctx context.Context, ar *AuthorizeRequest, id *UpstreamIdentity) error {
+	subject, err := l.Resolve(ctx, id)
+	if err != nil {
+		return err
+	}
+	ar.Subject = subject
+	if ar.UserData == nil {
+		ar.UserData = id
+	}
+	return nil
+}
+
+// Resolve returns the subject of the user linked to the identity, linking
+// it to a new user if none
+func (l *AccountLinker) Resolve(ctx context.Context, id *UpstreamIdentity) (string, error) {
+	if id.Subject == "" {
+		return "", errors.New("upstream identity has no subject")
+	}
+	link, err := l.Store.LoadAccountLink(id.Issuer, id.Subject)
+	if err != nil && err != ErrNotFound {
+		return "", err
+	}
+	if link != nil {
+		// keep the email current for the conflict detection
+		if email := upstreamEmail(id); email != link.Email {
+			link.Email = email
+			if err = l.Store.SaveAccountLink(link); err != nil {
+				return "", err
+			}
+		}
+		return link.Subject, nil
+	}
+
+	if email := upstreamEmail(id); email != "" {
+		if subject, err := l.emailOwner(ctx, email); err != nil {
+			return "", err
+		} else if subject != "" {
+			return "", &AccountLinkConflictError{Identity: id, Subject: subject}
+		}
+	}
+
+	var subject string
+	if l.NewSubject != nil {
+		subject, err = l.NewSubject(ctx, id)
+	} else {
+		subject, err = (TokenFormat{}).Generate()
+	}
+	if err != nil {
+		return "", err
+	}
+	if err = l.Link(ctx, subject, id); err != nil {
+		return "", err
+	}
+	return subject, nil
+}
+
+// emailOwner returns the subject of a user with the email, blank if none
+func (l *AccountLinker) emailOwner(ctx context.Context, email string) (string, error) {
+	links, err := l.Store.AccountLinksByEmail(email)
+	if err != nil {
+		return "", err
+	}
+	if len(links) > 0 {
+		return links[0].Subject, nil
+	}
+	if finder, ok := l.UserStore.(UserEmailFinder); ok {
+		user, err := finder.FindUserByEmail(ctx, email)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return "", err
+		}
+		if user != nil {
+			return user.Subject, nil
+		}
+	}
+	return "", nil
+}
+
+// Link links the upstream identity to the local user, like after the user
+// logged in to resolve an AccountLinkConflictError. Returns
+// ErrAccountLinked if the identity is linked to another user.
+func (l *AccountLinker) Link(ctx context.Context, subject string, id *UpstreamIdentity) error {
+	return l.Store.SaveAccountLink(&AccountLink{
+		Provider:        id.Provider,
+		Issuer:          id.Issuer,
+		UpstreamSubject: id.Subject,
+		Subject:         subject,
+		Email:           upstreamEmail(id),
+		LinkedAt:        l.now(),
+	})
+}
+
+// Unlink removes the link of an upstream identity to the local user.
+// Returns ErrNotFound if the identity isn't linked to the user.
+func (l *AccountLinker) Unlink(ctx context.Context, subject, issuer, upstreamSubject string) error {
+	link, err := l.Store.LoadAccountLink(issuer, upstreamSubject)
+	if err != nil {
+		return err
+	}
+	if link.Subject != subject {
+		return ErrNotFound
+	}
+	return l.Store.RemoveAccountLink(issuer, upstreamSubject)
+}
+
+// Links lists the upstream identities linked to the local user
+func (l *AccountLinker) Links(ctx context.Context, subject string) ([]*AccountLink, error) {
+	return l.Store.AccountLinksBySubject(subject)
+}
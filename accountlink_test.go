@@ -0,0 +1,90 @@
+package osin
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// emailUserStore is a testUserStore able to find users by email
+type emailUserStore struct {
+	*testUserStore
+}
+
+func (s emailUserStore) FindUserByEmail(ctx context.Context, email string) (*User, error) {
+	for _, u := range s.users {
+		if u.Claims["email"] == email {
+			return u, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func TestAccountLinker(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryAccountLinkStore()
+	subjects := 0
+	linker := &AccountLinker{
+		Store:     store,
+		UserStore: emailUserStore{newTestUserStore()},
+		NewSubject: func(ctx context.Context, id *UpstreamIdentity) (string, error) {
+			subjects++
+			return "new-" + id.Provider, nil
+		},
+	}
+	identity := func(provider, sub, email string) *UpstreamIdentity {
+		return &UpstreamIdentity{Provider: provider, Issuer: "https://" + provider, Subject: sub, Claims: map[string]interface{}{"email": email}}
+	}
+
+	// new identities create a user, once
+	for i := 0; i < 2; i++ {
+		ar := &AuthorizeRequest{}
+		if err := linker.MapIdentity(ctx, ar, identity("google", "g1", "Bob@example.com")); err != nil {
+			t.Fatal(err)
+		}
+		if ar.Subject != "new-google" || subjects != 1 {
+			t.Fatalf("Unexpected subject %q after %d creations", ar.Subject, subjects)
+		}
+	}
+
+	// email collisions with linked identities and local users
+	tests := map[string]struct {
+		id      *UpstreamIdentity
+		subject string
+	}{
+		"linked identity": {identity("github", "42", "bob@example.com"), "new-google"},
+		"local user":      {identity("github", "43", "alice@example.com"), "user-1"},
+	}
+	for name, test := range tests {
+		_, err := linker.Resolve(ctx, test.id)
+		var conflict *AccountLinkConflictError
+		if !errors.As(err, &conflict) || conflict.Subject != test.subject {
+			t.Errorf("%s: expected conflict with %s, got %v", name, test.subject, err)
+		}
+	}
+
+	// the user links the identity after logging in
+	if err := linker.Link(ctx, "new-google", identity("github", "42", "bob@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if subject, err := linker.Resolve(ctx, identity("github", "42", "bob@example.com")); err != nil || subject != "new-google" {
+		t.Fatalf("Linked identity resolved to %q: %v", subject, err)
+	}
+	if err := linker.Link(ctx, "user-1", identity("github", "42", "bob@example.com")); err != ErrAccountLinked {
+		t.Fatalf("Expected %v, got %v", ErrAccountLinked, err)
+	}
+	if links, _ := linker.Links(ctx, "new-google"); len(links) != 2 {
+		t.Fatalf("Expected 2 links, got %d", len(links))
+	}
+
+	// unlink
+	if err := linker.Unlink(ctx, "user-1", "https://github", "42"); err != ErrNotFound {
+		t.Fatalf("Another user's identity should not be unlinked, got %v", err)
+	}
+	if err := linker.Unlink(ctx, "new-google", "https://github", "42"); err != nil {
+		t.Fatal(err)
+	}
+	if links, _ := linker.Links(ctx, "new-google"); len(links) != 1 || links[0].Provider != "google" {
+		t.Fatalf("Unexpected links after unlink %+v", links)
+	}
+}
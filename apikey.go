@@ -0,0 +1,290 @@
+package osin
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"math"
+	"strings"
+	"time"
+)
+
+// APIKey is a long-lived key, presented as a bearer token like the access
+// tokens. Only the hash of the key is stored.
+type APIKey struct {
+	// Public identifier of the key, part of the key
+	ID string
+
+	// Hex SHA-256 of the key
+	Hash string
+
+	// Client information
+	Client Client
+
+	// Local subject identifier of the user the key acts for, if any
+	Subject string
+
+	Scope string
+
+	// Description of the key for its owner, like "CI deploys"
+	Name string
+
+	// Date created
+	CreatedAt time.Time
+
+	// Expiration date. Never expires if zero.
+	ExpiresAt time.Time
+
+	// Date revoked. Valid if zero.
+	RevokedAt time.Time
+
+	// Credential version of the subject when issued, from the server
+	// CredentialVersionChecker
+	CredentialVersion string
+
+	// Data to be passed to storage. Not used by the library.
+	UserData interface{}
+}
+
+// IsValidAt returns true if the key is not revoked nor expired at time t
+func (k *APIKey) IsValidAt(t time.Time) bool {
+	return k.RevokedAt.IsZero() && (k.ExpiresAt.IsZero() || t.Before(k.ExpiresAt))
+}
+
+// APIKeyStorage is implemented by the storages keeping API keys
+type APIKeyStorage interface {
+	// SaveAPIKey saves a new or revoked key
+	SaveAPIKey(key *APIKey) error
+
+	// LoadAPIKey looks up a key by id. Client information MUST be loaded
+	// together. Returns ErrNotFound if not found.
+	LoadAPIKey(id string) (*APIKey, error)
+
+	// APIKeysByClient lists the keys of a client, revoked ones included
+	APIKeysByClient(clientId string) ([]*APIKey, error)
+}
+
+// APIKeyRequest is a request to issue an API key
+type APIKeyRequest struct {
+	Client  Client
+	Subject string
+	Name    string
+
+	// Scope of the key, limited by the client maximum scope
+	Scope string
+
+	// Lifetime of the key. Never expires if 0.
+	Expiration time.Duration
+
+	UserData interface{}
+}
+
+// apiKeyStorage returns the server storage as APIKeyStorage
+func (s *Server) apiKeyStorage() (APIKeyStorage, error) {
+	ks, ok := storageAs[APIKeyStorage](s.Storage)
+	if !ok {
+		return nil, errors.New("storage does not implement APIKeyStorage")
+	}
+	return ks, nil
+}
+
+// defaultAPIKeyPrefix is the prefix of the API keys if not configured
+const defaultAPIKeyPrefix = "osk_"
+
+func (s *Server) apiKeyPrefix() string {
+	if p := s.config().APIKeyPrefix; p != "" {
+		return p
+	}
+	return defaultAPIKeyPrefix
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueAPIKey creates an API key for the client, returning the key, to be
+// shown once to its owner, and the saved APIKey. Keys have the form
+// "<prefix><id>_<secret>".
+func (s *Server) IssueAPIKey(req *APIKeyRequest) (string, *APIKey, error) {
+	ks, err := s.apiKeyStorage()
+	if err != nil {
+		return "", nil, err
+	}
+	if req.Client == nil {
+		return "", nil, errors.New("api key request has no client")
+	}
+	if cl, ok := req.Client.(ClientScopeLimits); ok {
+		if max := cl.GetMaxScope(); max != "" {
+			for _, sc := range strings.Fields(req.Scope) {
+				if !HasScope(max, sc) {
+					return "", nil, ErrScopeNotAllowed
+				}
+			}
+		}
+	}
+
+	id, err := (TokenFormat{Length: 8, Encoding: TOKEN_ENCODING_HEX}).Generate()
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := (TokenFormat{Length: 32, Encoding: TOKEN_ENCODING_BASE62}).Generate()
+	if err != nil {
+		return "", nil, err
+	}
+	key := s.apiKeyPrefix() + id + "_" + secret
+
+	ret := &APIKey{
+		ID:        id,
+		Hash:      hashAPIKey(key),
+		Client:    req.Client,
+		Subject:   req.Subject,
+		Scope:     req.Scope,
+		Name:      req.Name,
+		CreatedAt: s.Now(),
+		UserData:  req.UserData,
+	}
+	if req.Expiration > 0 {
+		ret.ExpiresAt = ret.CreatedAt.Add(req.Expiration)
+	}
+	if ret.CredentialVersion, err = s.credentialVersion(req.Subject); err != nil {
+		return "", nil, err
+	}
+	if err = ks.SaveAPIKey(ret); err != nil {
+		return "", nil, err
+	}
+	return key, ret, nil
+}
+
+// RevokeAPIKey revokes the API key with the id, emitting
+// EVENT_TOKEN_REVOKED. Returns ErrNotFound if unknown.
+func (s *Server) RevokeAPIKey(id string) error {
+	ks, err := s.apiKeyStorage()
+	if err != nil {
+		return err
+	}
+	key, err := ks.LoadAPIKey(id)
+	if err != nil {
+		return err
+	}
+	if !key.RevokedAt.IsZero() {
+		return nil
+	}
+	key.RevokedAt = s.Now()
+	if err = ks.SaveAPIKey(key); err != nil {
+		return err
+	}
+	s.emitEvent(&Event{
+		Type:   EVENT_TOKEN_REVOKED,
+		Client: key.Client,
+		Data:   map[string]interface{}{"api_key_id": key.ID, "subject": key.Subject},
+	})
+	return nil
+}
+
+// IsAPIKey returns true if the token has the API key prefix of the server
+func (s *Server) IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, s.apiKeyPrefix())
+}
+
+// loadAPIKey looks up and validates an API key, with the current client
+// information. Returns ErrNotFound if the key is unknown, revoked or
+// expired, or its client no longer exists.
+func (s *Server) loadAPIKey(ctx context.Context, storage Storage, token string) (*APIKey, error) {
+	rest := strings.TrimPrefix(token, s.apiKeyPrefix())
+	sep := strings.IndexByte(rest, '_')
+	if rest == token || sep <= 0 {
+		return nil, ErrNotFound
+	}
+	ks, ok := storageAs[APIKeyStorage](storage)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	key, err := ks.LoadAPIKey(rest[:sep])
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashAPIKey(token))) != 1 || !key.IsValidAt(s.Now()) || key.Client == nil {
+		return nil, ErrNotFound
+	}
+	if key.Client, err = storageGetClient(ctx, storage, key.Client.GetID()); err != nil {
+		return nil, err
+	}
+	if key.Client == nil {
+		return nil, ErrNotFound
+	}
+	return key, nil
+}
+
+// accessData returns the key as the access data of a grant, to be
+// validated like the access tokens
+func (k *APIKey) accessData(token string) *AccessData {
+	// keys never expiring are valid as long as the longest access data
+	expiresIn := int64(math.MaxInt32)
+	if !k.ExpiresAt.IsZero() && int64(k.ExpiresAt.Sub(k.CreatedAt)/time.Second) < expiresIn {
+		expiresIn = int64(k.ExpiresAt.Sub(k.CreatedAt) / time.Second)
+	}
+	return &AccessData{
+		Client:            k.Client,
+		AccessToken:       token,
+		ExpiresIn:         int32(expiresIn),
+		Scope:             k.Scope,
+		CreatedAt:         k.CreatedAt,
+		Subject:           k.Subject,
+		CredentialVersion: k.CredentialVersion,
+		UserData:          k.UserData,
+	}
+}
+
+// loadAccess loads the access data of a token, API keys included. It is
+// the token lookup of the endpoints validating access tokens.
+func (s *Server) loadAccess(ctx context.Context, storage Storage, token string) (*AccessData, error) {
+	if s.IsAPIKey(token) {
+		key, err := s.loadAPIKey(ctx, storage, token)
+		if err == nil {
+			return key.accessData(token), nil
+		}
+		if err != ErrNotFound {
+			return nil, err
+		}
+	}
+	return s.loadStoredAccess(ctx, storage, token)
+}
+
+// VerifyAPIKey validates an API key, returning it as a VerifiedToken. It
+// is a TokenIntrospector function, to validate the API keys with the
+// access tokens in Verifier.APIKeys. Returns ErrInvalidToken if the key is
+// unknown, revoked or expired, its client no longer exists, or the
+// credentials of its subject changed.
+func (s *Server) VerifyAPIKey(ctx context.Context, token string) (*VerifiedToken, error) {
+	if _, err := s.apiKeyStorage(); err != nil {
+		return nil, err
+	}
+	storage := s.Storage.Clone()
+	defer storage.Close()
+
+	key, err := s.loadAPIKey(ctx, storage, token)
+	if err == ErrNotFound {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if revoked, err := s.isRevoked(token, key.accessData(token)); err != nil {
+		return nil, err
+	} else if revoked {
+		return nil, ErrInvalidToken
+	}
+
+	return &VerifiedToken{
+		Token:     token,
+		ClientId:  key.Client.GetID(),
+		Subject:   key.Subject,
+		Scope:     key.Scope,
+		IssuedAt:  key.CreatedAt,
+		ExpiresAt: key.ExpiresAt,
+		Claims:    map[string]interface{}{"api_key_id": key.ID, "token_type": "api_key"},
+	}, nil
+}
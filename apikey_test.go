@@ -0,0 +1,131 @@
+package osin
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// apiKeyTestingStorage is a TestingStorage keeping API keys
+type apiKeyTestingStorage struct {
+	*TestingStorage
+	keys map[string]*APIKey
+}
+
+func (s *apiKeyTestingStorage) Clone() Storage {
+	return s
+}
+
+func (s *apiKeyTestingStorage) SaveAPIKey(key *APIKey) error {
+	c := *key
+	s.keys[key.ID] = &c
+	return nil
+}
+
+func (s *apiKeyTestingStorage) LoadAPIKey(id string) (*APIKey, error) {
+	if k, ok := s.keys[id]; ok {
+		c := *k
+		return &c, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *apiKeyTestingStorage) APIKeysByClient(clientId string) ([]*APIKey, error) {
+	var ret []*APIKey
+	for _, k := range s.keys {
+		if k.Client.GetID() == clientId {
+			ret = append(ret, k)
+		}
+	}
+	return ret, nil
+}
+
+func TestAPIKey(t *testing.T) {
+	storage := &apiKeyTestingStorage{TestingStorage: NewTestingStorage(), keys: make(map[string]*APIKey)}
+	server := NewServer(NewServerConfig(), storage)
+	client, _ := storage.GetClient("1234")
+
+	key, saved, err := server.IssueAPIKey(&APIKeyRequest{Client: client, Subject: "user-1", Scope: "read", Name: "ci"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, "osk_"+saved.ID+"_") || !server.IsAPIKey(key) || strings.Contains(saved.Hash, key) {
+		t.Fatalf("Unexpected key %s %+v", key, saved)
+	}
+	expiring, _, err := server.IssueAPIKey(&APIKeyRequest{Client: client, Expiration: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := &Verifier{APIKeys: TokenIntrospectorFunc(server.VerifyAPIKey)}
+	req, _ := http.NewRequest("GET", "http://localhost/api", nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	token, err := v.VerifyRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Subject != "user-1" || token.ClientId != "1234" || !token.HasScope("read") || !token.ExpiresAt.IsZero() {
+		t.Fatalf("Unexpected token %+v", token)
+	}
+
+	// the endpoints validating access tokens accept API keys
+	resp := server.NewResponse()
+	if ir := server.HandleInfoRequest(resp, req); ir == nil || ir.AccessData.Subject != "user-1" {
+		t.Fatalf("API key should be accepted by the info endpoint: %v", resp.Output)
+	}
+	if data, err := server.ValidateTokens(context.Background(), []string{key, "unknown"}); err != nil || data[0] == nil || data[0].Scope != "read" || data[1] != nil {
+		t.Fatalf("Unexpected validation %v, %v", data, err)
+	}
+
+	server.Now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if err := server.RevokeAPIKey(saved.ID); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"revoked":      key,
+		"expired":      expiring,
+		"wrong secret": "osk_" + saved.ID + "_x",
+		"unknown id":   "osk_0000_x",
+		"no separator": "osk_" + saved.ID,
+		"access token": "1",
+		"other prefix": strings.Replace(key, "osk_", "xyz_", 1),
+	}
+	for name, token := range tests {
+		if _, err := v.Verify(context.Background(), token); err != ErrInvalidToken {
+			t.Errorf("%s: expected %v, got %v", name, ErrInvalidToken, err)
+		}
+	}
+	if err := server.RevokeAPIKey("unknown"); err != ErrNotFound {
+		t.Errorf("Expected %v, got %v", ErrNotFound, err)
+	}
+}
+
+func TestAPIKeyInvalidated(t *testing.T) {
+	storage := &apiKeyTestingStorage{TestingStorage: NewTestingStorage(), keys: make(map[string]*APIKey)}
+	server := NewServer(NewServerConfig(), storage)
+	version := "1"
+	server.CredentialVersionChecker = CredentialVersionCheckerFunc(func(subject string) (string, error) {
+		return version, nil
+	})
+	client, _ := storage.GetClient("1234")
+	key, _, err := server.IssueAPIKey(&APIKeyRequest{Client: client, Subject: "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = server.VerifyAPIKey(context.Background(), key); err != nil {
+		t.Fatal(err)
+	}
+
+	version = "2"
+	if _, err = server.VerifyAPIKey(context.Background(), key); err != ErrInvalidToken {
+		t.Errorf("Key issued before a credential change: expected %v, got %v", ErrInvalidToken, err)
+	}
+
+	version = "1"
+	delete(storage.clients, "1234")
+	if _, err = server.VerifyAPIKey(context.Background(), key); err != ErrInvalidToken {
+		t.Errorf("Key of a removed client: expected %v, got %v", ErrInvalidToken, err)
+	}
+}
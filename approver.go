@@ -0,0 +1,38 @@
+package osin
+
+import (
+	"context"
+	"net/http"
+)
+
+// AccessApprover can veto centrally the access requests authorized by the
+// application, to enforce policies like disabled users or geo restrictions
+type AccessApprover interface {
+	// Approve returns nil to issue the tokens, or an error to deny them.
+	// It is only called for authorized requests.
+	Approve(ctx context.Context, ar *AccessRequest) error
+}
+
+// AccessApproverFunc allows a function to be used as an AccessApprover
+type AccessApproverFunc func(ctx context.Context, ar *AccessRequest) error
+
+// Approve calls f(ctx, ar)
+func (f AccessApproverFunc) Approve(ctx context.Context, ar *AccessRequest) error {
+	return f(ctx, ar)
+}
+
+// approveAccess lets the server AccessApprover, if any, deny the requests
+// authorized by the application. It never authorizes a denied request.
+func (s *Server) approveAccess(w *Response, r *http.Request, ar *AccessRequest) {
+	if s.AccessApprover == nil || !ar.Authorized {
+		return
+	}
+	ctx := ar.Context()
+	if ar.ctx == nil && ar.HttpRequest == nil && r != nil {
+		ctx = r.Context()
+	}
+	if err := s.AccessApprover.Approve(ctx, ar); err != nil {
+		ar.Authorized = false
+		w.InternalError = err
+	}
+}
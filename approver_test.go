@@ -0,0 +1,64 @@
+package osin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestAccessApprover(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{PASSWORD}
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+	server.AccessApprover = AccessApproverFunc(func(ctx context.Context, ar *AccessRequest) error {
+		if ar.Username == "disabled" {
+			return errors.New("user is disabled")
+		}
+		return nil
+	})
+
+	for _, username := range []string{"testing", "disabled"} {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = url.Values{
+			"grant_type": {string(PASSWORD)},
+			"username":   {username},
+			"password":   {"testing"},
+		}
+		req.PostForm = req.Form
+
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+
+		if username == "disabled" {
+			if resp.ErrorId != E_ACCESS_DENIED || resp.InternalError == nil {
+				t.Fatalf("Disabled user should be denied: %v", resp.Output)
+			}
+		} else if resp.IsError {
+			t.Fatalf("Request should succeed: %v", resp.Output)
+		}
+	}
+
+	// the approver can't authorize a request denied by the application
+	resp := server.NewResponse()
+	req, _ := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+	req.SetBasicAuth("1234", "aabbccdd")
+	req.Form = url.Values{"grant_type": {string(PASSWORD)}, "username": {"testing"}, "password": {"wrong"}}
+	req.PostForm = req.Form
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		ar.Authorized = false
+		server.FinishAccessRequest(resp, req, ar)
+	}
+	if resp.ErrorId != E_ACCESS_DENIED {
+		t.Fatalf("Denied request should stay denied: %v", resp.Output)
+	}
+}
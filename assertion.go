@@ -0,0 +1,338 @@
+package osin
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// Assertion type of JWT bearer assertions (RFC 7523)
+	ASSERTION_TYPE_JWT = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+	// Assertion type of SAML 2.0 bearer assertions (RFC 7522)
+	ASSERTION_TYPE_SAML2 = "urn:ietf:params:oauth:grant-type:saml2-bearer"
+)
+
+// AssertionResult is the outcome of a verified assertion
+type AssertionResult struct {
+	// Subject the tokens are issued for
+	Subject string
+
+	// Scopes granted by the assertion. If not empty, the requested scope
+	// must be a subset of it, and it is used if no scope is requested.
+	Scope string
+
+	// Data copied to AccessRequest.UserData
+	UserData interface{}
+}
+
+// AssertionVerifier verifies the assertions of a registered assertion type
+type AssertionVerifier interface {
+	// VerifyAssertion returns an error if the assertion is invalid or the
+	// client may not use it
+	VerifyAssertion(client Client, assertion string) (*AssertionResult, error)
+}
+
+// AssertionVerifierFunc is a function implementing AssertionVerifier
+type AssertionVerifierFunc func(client Client, assertion string) (*AssertionResult, error)
+
+// VerifyAssertion calls f(client, assertion)
+func (f AssertionVerifierFunc) VerifyAssertion(client Client, assertion string) (*AssertionResult, error) {
+	return f(client, assertion)
+}
+
+// RegisterAssertionVerifier registers the verifier of an assertion type.
+// Assertions of types not registered are passed to the application
+// unverified.
+func (s *Server) RegisterAssertionVerifier(assertionType string, v AssertionVerifier) {
+	if s.AssertionVerifiers == nil {
+		s.AssertionVerifiers = make(map[string]AssertionVerifier)
+	}
+	s.AssertionVerifiers[assertionType] = v
+}
+
+// verifyAssertion runs the registered verifier of the assertion type, if
+// any, filling the request from its result. Sets an error on the response
+// and returns false if invalid.
+func (s *Server) verifyAssertion(w *Response, ar *AccessRequest) bool {
+	v, ok := s.AssertionVerifiers[ar.AssertionType]
+	if !ok || v == nil {
+		return true
+	}
+
+	res, err := v.VerifyAssertion(ar.Client, ar.Assertion)
+	if err != nil || res == nil {
+		w.SetError(E_INVALID_GRANT, "")
+		w.InternalError = err
+		return false
+	}
+
+	if res.Scope != "" {
+		if ar.Scope == "" {
+			ar.Scope = res.Scope
+		} else if extraScopes(res.Scope, ar.Scope) {
+			w.SetError(E_INVALID_SCOPE, "")
+			return false
+		}
+	}
+	ar.Subject = res.Subject
+	ar.UserData = res.UserData
+	return true
+}
+
+// JWTAssertionVerifier verifies JWT bearer assertions (RFC 7523).
+// Assertions with a "jti" claim are accepted once until they expire. The
+// identifiers seen are kept in server memory, replays on other servers
+// sharing the issuer keys are not detected.
+type JWTAssertionVerifier struct {
+	// Returns the verification key of the issuer and key id, as accepted by
+	// JWT.Verify. Required.
+	KeyFunc func(issuer, keyId string) (interface{}, error)
+
+	// Required audience, usually the token endpoint URL of the server
+	Audience string
+
+	// Refuses the assertions without a "jti" claim, which can't be
+	// protected from replays
+	RequireJTI bool
+
+	// Maximum time between the "iat" and "exp" claims, if not 0
+	MaxLifetime time.Duration
+
+	// Allowed clock skew
+	Leeway time.Duration
+
+	// Returns the current time, time.Now if nil
+	Now func() time.Time
+
+	// identifiers of the accepted assertions, until they expire
+	seen memoCache
+}
+
+// VerifyAssertion verifies the signature and the iss, sub, aud, exp, nbf,
+// iat and jti claims. The result holds the "sub" and "scope" claims, and
+// all the claims as UserData.
+func (v *JWTAssertionVerifier) VerifyAssertion(client Client, assertion string) (*AssertionResult, error) {
+	if v.KeyFunc == nil {
+		return nil, errors.New("jwt assertion verifier has no KeyFunc")
+	}
+	if v.Audience == "" {
+		return nil, errors.New("jwt assertion verifier has no Audience")
+	}
+	now := time.Now
+	if v.Now != nil {
+		now = v.Now
+	}
+
+	t, err := ParseJWT(assertion)
+	if err != nil {
+		return nil, err
+	}
+	iss, sub := t.StringClaim("iss"), t.StringClaim("sub")
+	if iss == "" || sub == "" {
+		return nil, errors.New("jwt assertion requires the iss and sub claims")
+	}
+	key, err := v.KeyFunc(iss, t.KeyID())
+	if err != nil {
+		return nil, err
+	}
+	if err = t.Verify(key); err != nil {
+		return nil, err
+	}
+	if !t.HasAudience(v.Audience) {
+		return nil, ErrJWTAudienceMismatch
+	}
+	t0 := now()
+	if err = t.ValidateTimes(t0, v.Leeway); err != nil {
+		return nil, err
+	}
+	exp, _ := t.TimeClaim("exp")
+	if v.MaxLifetime > 0 {
+		iat, ok := t.TimeClaim("iat")
+		if !ok || exp.Sub(iat) > v.MaxLifetime {
+			return nil, errors.New("jwt assertion lifetime is too long")
+		}
+	}
+	if jti := t.StringClaim("jti"); jti != "" {
+		if !v.seen.add(iss+"|"+jti, true, t0, exp.Add(v.Leeway)) {
+			return nil, errors.New("jwt assertion was already used")
+		}
+	} else if v.RequireJTI {
+		return nil, errors.New("jwt assertion requires the jti claim")
+	}
+
+	return &AssertionResult{
+		Subject:  sub,
+		Scope:    t.StringClaim("scope"),
+		UserData: t.Claims,
+	}, nil
+}
+
+// SAMLAssertion holds the fields of a SAML 2.0 assertion checked by
+// SAMLAssertionVerifier
+type SAMLAssertion struct {
+	XMLName xml.Name
+	ID      string `xml:"ID,attr"`
+	Issuer  string `xml:"Issuer"`
+	Subject struct {
+		NameID       string `xml:"NameID"`
+		Confirmation []struct {
+			Method string `xml:"Method,attr"`
+			Data   struct {
+				NotOnOrAfter string `xml:"NotOnOrAfter,attr"`
+				Recipient    string `xml:"Recipient,attr"`
+			} `xml:"SubjectConfirmationData"`
+		} `xml:"SubjectConfirmation"`
+	} `xml:"Subject"`
+	Conditions struct {
+		NotBefore    string   `xml:"NotBefore,attr"`
+		NotOnOrAfter string   `xml:"NotOnOrAfter,attr"`
+		Audiences    []string `xml:"AudienceRestriction>Audience"`
+	} `xml:"Conditions"`
+	Attributes []struct {
+		Name   string   `xml:"Name,attr"`
+		Values []string `xml:"AttributeValue"`
+	} `xml:"AttributeStatement>Attribute"`
+}
+
+// SAMLAssertionVerifier verifies SAML 2.0 bearer assertions (RFC 7522).
+// XML signatures require canonicalization, which the standard library
+// doesn't provide, so their verification is delegated to SignatureVerifier.
+// Assertions are accepted once by their ID until their subject confirmation
+// expires. The IDs seen are kept in server memory, replays on other servers
+// are not detected.
+type SAMLAssertionVerifier struct {
+	// Verifies the XML signature of the decoded assertion, using an XML-DSig
+	// library, and returns the serialized element the signature references.
+	// Only this element is read, so unsigned assertions wrapped around the
+	// signed one are ignored. Required.
+	SignatureVerifier func(assertion []byte) (signed []byte, err error)
+
+	// Trusted issuers. Any issuer is accepted if empty.
+	Issuers []string
+
+	// Required audience, usually the token endpoint URL of the server
+	Audience string
+
+	// Allowed clock skew
+	Leeway time.Duration
+
+	// Returns the current time, time.Now if nil
+	Now func() time.Time
+
+	// IDs of the accepted assertions, until they expire
+	seen memoCache
+}
+
+// VerifyAssertion decodes the base64url assertion, verifies its signature,
+// issuer, audience, validity period and bearer subject confirmation, read
+// from the signed Assertion element. The result holds the NameID as
+// subject, and the attributes as a map[string][]string UserData.
+func (v *SAMLAssertionVerifier) VerifyAssertion(client Client, assertion string) (*AssertionResult, error) {
+	if v.SignatureVerifier == nil {
+		return nil, errors.New("saml assertion verifier has no SignatureVerifier")
+	}
+	if v.Audience == "" {
+		return nil, errors.New("saml assertion verifier has no Audience")
+	}
+	now := time.Now
+	if v.Now != nil {
+		now = v.Now
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(assertion, "="))
+	if err != nil {
+		return nil, err
+	}
+	signed, err := v.SignatureVerifier(raw)
+	if err != nil {
+		return nil, err
+	}
+	var a SAMLAssertion
+	if err = xml.Unmarshal(signed, &a); err != nil {
+		return nil, err
+	}
+	if a.XMLName.Local != "Assertion" {
+		return nil, fmt.Errorf("saml assertion signature references a %s element", a.XMLName.Local)
+	}
+
+	if len(v.Issuers) > 0 && !stringInList(a.Issuer, v.Issuers) {
+		return nil, fmt.Errorf("saml assertion issuer %s is not trusted", a.Issuer)
+	}
+	if a.ID == "" {
+		return nil, errors.New("saml assertion has no ID")
+	}
+	if a.Subject.NameID == "" {
+		return nil, errors.New("saml assertion has no subject")
+	}
+	if !stringInList(v.Audience, a.Conditions.Audiences) {
+		return nil, errors.New("saml assertion audience mismatch")
+	}
+
+	t := now()
+	if err = checkSAMLTime(a.Conditions.NotOnOrAfter, func(at time.Time) bool { return t.Add(-v.Leeway).Before(at) }); err != nil {
+		return nil, err
+	}
+	if err = checkSAMLTime(a.Conditions.NotBefore, func(at time.Time) bool { return !t.Add(v.Leeway).Before(at) }); err != nil {
+		return nil, err
+	}
+
+	// a bearer confirmation addressed to the audience is required
+	confirmed := false
+	var expiresAt time.Time
+	for _, c := range a.Subject.Confirmation {
+		if c.Method != "urn:oasis:names:tc:SAML:2.0:cm:bearer" || c.Data.NotOnOrAfter == "" {
+			continue
+		}
+		if c.Data.Recipient != "" && c.Data.Recipient != v.Audience {
+			continue
+		}
+		if checkSAMLTime(c.Data.NotOnOrAfter, func(at time.Time) bool { expiresAt = at; return t.Add(-v.Leeway).Before(at) }) == nil {
+			confirmed = true
+			break
+		}
+	}
+	if !confirmed {
+		return nil, errors.New("saml assertion has no valid bearer subject confirmation")
+	}
+	if !v.seen.add(a.Issuer+"|"+a.ID, true, t, expiresAt.Add(v.Leeway)) {
+		return nil, errors.New("saml assertion was already used")
+	}
+
+	attrs := make(map[string][]string, len(a.Attributes))
+	for _, at := range a.Attributes {
+		attrs[at.Name] = append(attrs[at.Name], at.Values...)
+	}
+	return &AssertionResult{
+		Subject:  a.Subject.NameID,
+		UserData: attrs,
+	}, nil
+}
+
+// checkSAMLTime parses an optional SAML dateTime and checks it with valid
+func checkSAMLTime(value string, valid func(time.Time) bool) error {
+	if value == "" {
+		return nil
+	}
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return err
+	}
+	if !valid(at) {
+		return errors.New("saml assertion is not valid at this time")
+	}
+	return nil
+}
+
+func stringInList(s string, list []string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
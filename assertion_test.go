@@ -0,0 +1,261 @@
+package osin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAssertionVerifierJWT(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1500000000, 0)
+
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{ASSERTION}
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+	server.RegisterAssertionVerifier(ASSERTION_TYPE_JWT, &JWTAssertionVerifier{
+		KeyFunc: func(issuer, keyId string) (interface{}, error) {
+			if issuer != "https://idp.example.com" {
+				return nil, errors.New("unknown issuer")
+			}
+			return &key.PublicKey, nil
+		},
+		Audience: "http://localhost:14000/token",
+		Now:      func() time.Time { return now },
+	})
+
+	claims := map[string]interface{}{
+		"iss":   "https://idp.example.com",
+		"sub":   "user-1",
+		"aud":   []string{"http://localhost:14000/token"},
+		"exp":   now.Add(time.Minute).Unix(),
+		"iat":   now.Unix(),
+		"scope": "read,write",
+	}
+	valid, err := SignJWT(claims, "ES256", "k1", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims["exp"] = now.Add(-time.Minute).Unix()
+	expired, err := SignJWT(claims, "ES256", "k1", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims["exp"] = now.Add(time.Minute).Unix()
+	claims["aud"] = "https://other.example.com/token"
+	otherAudience, err := SignJWT(claims, "ES256", "k1", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		Assertion string
+		Scope     string
+		ErrorId   string
+		Expected  string
+	}{
+		"valid":          {Assertion: valid, Expected: "read,write"},
+		"narrowed":       {Assertion: valid, Scope: "read", Expected: "read"},
+		"extra scope":    {Assertion: valid, Scope: "admin", ErrorId: E_INVALID_SCOPE},
+		"expired":        {Assertion: expired, ErrorId: E_INVALID_GRANT},
+		"other audience": {Assertion: otherAudience, ErrorId: E_INVALID_GRANT},
+		"bad signature":  {Assertion: valid[:len(valid)-4] + "AAAA", ErrorId: E_INVALID_GRANT},
+		"malformed":      {Assertion: "not-a-jwt", ErrorId: E_INVALID_GRANT},
+	}
+
+	for k, test := range tests {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/token", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = url.Values{
+			"grant_type":     {string(ASSERTION)},
+			"assertion_type": {ASSERTION_TYPE_JWT},
+			"assertion":      {test.Assertion},
+			"scope":          {test.Scope},
+		}
+		req.PostForm = req.Form
+
+		ar := server.HandleAccessRequest(resp, req)
+		if test.ErrorId != "" {
+			if ar != nil || resp.ErrorId != test.ErrorId {
+				t.Errorf("%s: expected error %s, got %s", k, test.ErrorId, resp.ErrorId)
+			}
+			continue
+		}
+		if ar == nil {
+			t.Errorf("%s: request failed: %v", k, resp.Output)
+			continue
+		}
+		if ar.Subject != "user-1" || ar.Scope != test.Expected {
+			t.Errorf("%s: unexpected subject %s and scope %s", k, ar.Subject, ar.Scope)
+		}
+		if claims, ok := ar.UserData.(map[string]interface{}); !ok || claims["iss"] != "https://idp.example.com" {
+			t.Errorf("%s: claims should be the user data: %v", k, ar.UserData)
+		}
+	}
+
+	// assertions with a jti are accepted once
+	v := server.AssertionVerifiers[ASSERTION_TYPE_JWT].(*JWTAssertionVerifier)
+	claims["aud"] = "http://localhost:14000/token"
+	claims["jti"] = "j1"
+	once, err := SignJWT(claims, "ES256", "k1", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = v.VerifyAssertion(nil, once); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = v.VerifyAssertion(nil, once); err == nil {
+		t.Fatal("Replayed assertion should be refused")
+	}
+	v.RequireJTI = true
+	if _, err = v.VerifyAssertion(nil, valid); err == nil {
+		t.Fatal("Assertion without jti should be refused")
+	}
+	v.Audience = ""
+	if _, err = v.VerifyAssertion(nil, valid); err == nil {
+		t.Fatal("A verifier without audience should refuse the assertions")
+	}
+}
+
+func TestAssertionVerifierPassthrough(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{ASSERTION}
+	server := NewServer(sconfig, NewTestingStorage())
+	server.RegisterAssertionVerifier(ASSERTION_TYPE_JWT, AssertionVerifierFunc(func(client Client, assertion string) (*AssertionResult, error) {
+		return nil, errors.New("should not be called")
+	}))
+
+	resp := server.NewResponse()
+	req, err := http.NewRequest("POST", "http://localhost:14000/token", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("1234", "aabbccdd")
+	req.Form = url.Values{
+		"grant_type":     {string(ASSERTION)},
+		"assertion_type": {"urn:example:custom"},
+		"assertion":      {"opaque"},
+	}
+	req.PostForm = req.Form
+
+	if ar := server.HandleAccessRequest(resp, req); ar == nil || ar.Assertion != "opaque" {
+		t.Fatalf("Unregistered assertion types should be passed unverified: %v", resp.Output)
+	}
+}
+
+func TestJWTSignVerify(t *testing.T) {
+	secret := []byte("secret")
+	token, err := SignJWT(map[string]interface{}{"sub": "a", "exp": 10}, "HS256", "", secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwt, err := ParseJWT(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = jwt.Verify(secret); err != nil {
+		t.Fatalf("Signature should be valid: %s", err)
+	}
+	if err = jwt.Verify([]byte("other")); err != ErrJWTSignature {
+		t.Fatalf("Signature should be invalid with another key: %v", err)
+	}
+	if err = jwt.ValidateTimes(time.Unix(20, 0), 0); err != ErrJWTExpired {
+		t.Fatalf("Token should be expired: %v", err)
+	}
+
+	jwt.Header["alg"] = "none"
+	if err = jwt.Verify(nil); err != ErrJWTAlgorithm {
+		t.Fatalf("The none algorithm must be refused: %v", err)
+	}
+}
+
+func TestSAMLAssertionVerifier(t *testing.T) {
+	const assertion = `<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="a1">
+  <saml:Issuer>https://idp.example.com</saml:Issuer>
+  <saml:Subject>
+    <saml:NameID>user-1</saml:NameID>
+    <saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">
+      <saml:SubjectConfirmationData NotOnOrAfter="2017-07-14T03:00:00Z" Recipient="http://localhost:14000/token"/>
+    </saml:SubjectConfirmation>
+  </saml:Subject>
+  <saml:Conditions NotBefore="2017-07-14T02:00:00Z" NotOnOrAfter="2017-07-14T03:00:00Z">
+    <saml:AudienceRestriction><saml:Audience>http://localhost:14000/token</saml:Audience></saml:AudienceRestriction>
+  </saml:Conditions>
+  <saml:AttributeStatement>
+    <saml:Attribute Name="email"><saml:AttributeValue>user@example.com</saml:AttributeValue></saml:Attribute>
+  </saml:AttributeStatement>
+</saml:Assertion>`
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(assertion))
+
+	now := time.Date(2017, 7, 14, 2, 30, 0, 0, time.UTC)
+	v := &SAMLAssertionVerifier{
+		SignatureVerifier: func(assertion []byte) ([]byte, error) { return assertion, nil },
+		Issuers:           []string{"https://idp.example.com"},
+		Audience:          "http://localhost:14000/token",
+		Now:               func() time.Time { return now },
+	}
+
+	res, err := v.VerifyAssertion(nil, encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs, _ := res.UserData.(map[string][]string); res.Subject != "user-1" || len(attrs["email"]) != 1 {
+		t.Fatalf("Unexpected result: %+v", res)
+	}
+	if _, err = v.VerifyAssertion(nil, encoded); err == nil {
+		t.Fatal("Replayed assertion should be refused")
+	}
+
+	now = now.Add(time.Hour)
+	if _, err = v.VerifyAssertion(nil, encoded); err == nil {
+		t.Fatal("Expired assertion should be refused")
+	}
+
+	now = now.Add(-time.Hour)
+	v.Audience = "https://other.example.com"
+	if _, err = v.VerifyAssertion(nil, encoded); err == nil {
+		t.Fatal("Assertion for another audience should be refused")
+	}
+
+	v.Audience = "http://localhost:14000/token"
+	v.SignatureVerifier = func(assertion []byte) ([]byte, error) { return nil, errors.New("bad signature") }
+	if _, err = v.VerifyAssertion(nil, encoded); err == nil {
+		t.Fatal("Assertion with an invalid signature should be refused")
+	}
+
+	// the claims are read from the signed element only, not from an
+	// assertion wrapped around it
+	signed := strings.Replace(assertion, `ID="a1"`, `ID="a2"`, 1)
+	wrapped := strings.Replace(assertion, "<saml:NameID>user-1</saml:NameID>", "<saml:NameID>admin</saml:NameID>", 1)
+	wrapped = strings.Replace(wrapped, "</saml:Assertion>", "<saml:Advice>"+signed+"</saml:Advice></saml:Assertion>", 1)
+	v.SignatureVerifier = func(raw []byte) ([]byte, error) { return []byte(signed), nil }
+	if res, err = v.VerifyAssertion(nil, base64.RawURLEncoding.EncodeToString([]byte(wrapped))); err != nil || res.Subject != "user-1" {
+		t.Fatalf("Expected the subject of the signed assertion, got %+v %v", res, err)
+	}
+
+	v.SignatureVerifier = func(raw []byte) ([]byte, error) { return []byte("<saml:Advice/>"), nil }
+	if _, err = v.VerifyAssertion(nil, encoded); err == nil {
+		t.Fatal("A signature referencing another element should be refused")
+	}
+
+	v.SignatureVerifier = func(raw []byte) ([]byte, error) { return raw, nil }
+	v.Audience = ""
+	if _, err = v.VerifyAssertion(nil, encoded); err == nil {
+		t.Fatal("A verifier without audience should refuse the assertions")
+	}
+}
@@ -0,0 +1,167 @@
+package osin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+)
+
+// AuthorizationDetail is an entry of the authorization_details parameter, as
+// described in RFC 9396
+type AuthorizationDetail struct {
+	Type       string   `json:"type"`
+	Locations  []string `json:"locations,omitempty"`
+	Actions    []string `json:"actions,omitempty"`
+	DataTypes  []string `json:"datatypes,omitempty"`
+	Identifier string   `json:"identifier,omitempty"`
+	Privileges []string `json:"privileges,omitempty"`
+
+	// Type specific fields
+	Extra map[string]interface{} `json:"-"`
+}
+
+type authorizationDetailFields AuthorizationDetail
+
+// MarshalJSON encodes the common fields together with the type specific ones
+func (d AuthorizationDetail) MarshalJSON() ([]byte, error) {
+	common, err := json.Marshal(authorizationDetailFields(d))
+	if err != nil || len(d.Extra) == 0 {
+		return common, err
+	}
+
+	m := make(map[string]interface{}, len(d.Extra)+6)
+	for k, v := range d.Extra {
+		m[k] = v
+	}
+	if err = json.Unmarshal(common, &m); err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON decodes the common fields, keeping the remaining ones in Extra
+func (d *AuthorizationDetail) UnmarshalJSON(b []byte) error {
+	var fields authorizationDetailFields
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	for _, k := range []string{"type", "locations", "actions", "datatypes", "identifier", "privileges"} {
+		delete(m, k)
+	}
+	*d = AuthorizationDetail(fields)
+	if len(m) > 0 {
+		d.Extra = m
+	}
+	return nil
+}
+
+// AuthorizationDetails is the list of authorization details of a request
+type AuthorizationDetails []AuthorizationDetail
+
+// ParseAuthorizationDetails parses the JSON authorization_details parameter.
+// Each entry must have a type.
+func ParseAuthorizationDetails(s string) (AuthorizationDetails, error) {
+	var ret AuthorizationDetails
+	if err := json.Unmarshal([]byte(s), &ret); err != nil {
+		return nil, err
+	}
+	for i := range ret {
+		if ret[i].Type == "" {
+			return nil, fmt.Errorf("authorization detail %d has no type", i)
+		}
+	}
+	return ret, nil
+}
+
+// Contains returns true if the list has an entry equal to detail
+func (a AuthorizationDetails) Contains(detail AuthorizationDetail) bool {
+	for _, d := range a {
+		if reflect.DeepEqual(d, detail) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizationDetailValidator validates authorization details of a registered type
+type AuthorizationDetailValidator interface {
+	// ValidateAuthorizationDetail returns an error if the client may not
+	// request the detail, or if it is malformed
+	ValidateAuthorizationDetail(client Client, detail *AuthorizationDetail) error
+}
+
+// RegisterAuthorizationDetailType registers an authorization details type
+// with its validator. Requests with types not registered are refused.
+func (s *Server) RegisterAuthorizationDetailType(detailType string, v AuthorizationDetailValidator) {
+	if s.AuthorizationDetailValidators == nil {
+		s.AuthorizationDetailValidators = make(map[string]AuthorizationDetailValidator)
+	}
+	s.AuthorizationDetailValidators[detailType] = v
+}
+
+// validateAuthorizationDetails checks that every detail type is registered
+// and accepted by its validator
+func (s *Server) validateAuthorizationDetails(client Client, details AuthorizationDetails) error {
+	for i := range details {
+		v, ok := s.AuthorizationDetailValidators[details[i].Type]
+		if !ok {
+			return fmt.Errorf("authorization details type %s is not supported", details[i].Type)
+		}
+		if v == nil {
+			continue
+		}
+		if err := v.ValidateAuthorizationDetail(client, &details[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getAuthorizationDetails parses and validates the authorization_details
+// parameter of a request for a new grant, if present. Sets an error on the
+// response and returns false if invalid.
+func (s *Server) getAuthorizationDetails(w *Response, r *http.Request, client Client, state string) (AuthorizationDetails, bool) {
+	return s.parseAuthorizationDetails(w, r, client, nil, false, state)
+}
+
+// getGrantedAuthorizationDetails parses and validates the
+// authorization_details parameter of a request using an existing grant, like
+// a code exchange or a refresh. The requested details must be a subset of
+// the granted ones, none if the grant has none, and the granted ones are
+// returned if the parameter is absent. Sets an error on the response and
+// returns false if invalid.
+func (s *Server) getGrantedAuthorizationDetails(w *Response, r *http.Request, client Client, granted AuthorizationDetails) (AuthorizationDetails, bool) {
+	return s.parseAuthorizationDetails(w, r, client, granted, true, "")
+}
+
+func (s *Server) parseAuthorizationDetails(w *Response, r *http.Request, client Client, granted AuthorizationDetails, limited bool, state string) (AuthorizationDetails, bool) {
+	param := r.Form.Get("authorization_details")
+	if param == "" {
+		return granted, true
+	}
+
+	details, err := ParseAuthorizationDetails(param)
+	if err == nil {
+		err = s.validateAuthorizationDetails(client, details)
+	}
+	if err == nil && limited {
+		for _, d := range details {
+			if !granted.Contains(d) {
+				err = errors.New("authorization details were not granted")
+				break
+			}
+		}
+	}
+	if err != nil {
+		w.SetErrorState(E_INVALID_AUTHORIZATION_DETAILS, "", state)
+		w.InternalError = err
+		return nil, false
+	}
+	return details, true
+}
@@ -0,0 +1,205 @@
+package osin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const testPaymentDetails = `[{"type":"payment_initiation","actions":["initiate"],"instructedAmount":{"currency":"EUR","amount":"123.50"}}]`
+
+type paymentDetailValidator struct {
+}
+
+func (v *paymentDetailValidator) ValidateAuthorizationDetail(client Client, detail *AuthorizationDetail) error {
+	if _, ok := detail.Extra["instructedAmount"]; !ok {
+		return errors.New("missing instructedAmount")
+	}
+	return nil
+}
+
+func TestParseAuthorizationDetails(t *testing.T) {
+	details, err := ParseAuthorizationDetails(testPaymentDetails)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(details) != 1 || details[0].Type != "payment_initiation" || details[0].Actions[0] != "initiate" {
+		t.Fatalf("Unexpected details: %+v", details)
+	}
+	if _, ok := details[0].Extra["instructedAmount"]; !ok {
+		t.Fatalf("Type specific fields should be kept")
+	}
+
+	b, err := json.Marshal(details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := ParseAuthorizationDetails(string(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Contains(details[0]) {
+		t.Fatalf("Round trip changed the details: %s", b)
+	}
+
+	if _, err := ParseAuthorizationDetails(`[{"actions":["initiate"]}]`); err == nil {
+		t.Fatalf("Details without type should fail")
+	}
+	if _, err := ParseAuthorizationDetails(`{"type":"x"}`); err == nil {
+		t.Fatalf("Details must be an array")
+	}
+}
+
+func TestAuthorizeAuthorizationDetails(t *testing.T) {
+	testcases := map[string]struct {
+		Details       string
+		Register      bool
+		ExpectedError string
+	}{
+		"registered": {
+			Details:  testPaymentDetails,
+			Register: true,
+		},
+		"not registered": {
+			Details:       testPaymentDetails,
+			ExpectedError: E_INVALID_AUTHORIZATION_DETAILS,
+		},
+		"rejected by validator": {
+			Details:       `[{"type":"payment_initiation"}]`,
+			Register:      true,
+			ExpectedError: E_INVALID_AUTHORIZATION_DETAILS,
+		},
+		"malformed": {
+			Details:       `[{`,
+			Register:      true,
+			ExpectedError: E_INVALID_AUTHORIZATION_DETAILS,
+		},
+	}
+
+	for k, tc := range testcases {
+		storage := NewTestingStorage()
+		server := NewServer(NewServerConfig(), storage)
+		server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+		if tc.Register {
+			server.RegisterAuthorizationDetailType("payment_initiation", &paymentDetailValidator{})
+		}
+		resp := server.NewResponse()
+
+		req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Form = make(url.Values)
+		req.Form.Set("response_type", string(CODE))
+		req.Form.Set("client_id", "1234")
+		req.Form.Set("authorization_details", tc.Details)
+
+		if ar := server.HandleAuthorizeRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAuthorizeRequest(resp, req, ar)
+		}
+
+		if tc.ExpectedError != "" {
+			if resp.ErrorId != tc.ExpectedError {
+				t.Errorf("%s: expected error %s, got %v", k, tc.ExpectedError, resp.Output)
+			}
+			continue
+		}
+		if resp.IsError {
+			t.Errorf("%s: unexpected error: %v", k, resp.Output)
+			continue
+		}
+		if d := storage.authorize["1"].AuthorizationDetails; len(d) != 1 || d[0].Type != "payment_initiation" {
+			t.Errorf("%s: authorization details not saved: %+v", k, d)
+		}
+	}
+}
+
+func TestAccessAuthorizationDetails(t *testing.T) {
+	granted, err := ParseAuthorizationDetails(`[{"type":"payment_initiation","actions":["initiate"]},{"type":"payment_initiation","actions":["status"]}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := map[string]struct {
+		Granted       AuthorizationDetails
+		Details       string
+		ExpectedCount int
+		ExpectedError string
+	}{
+		"all granted": {
+			Granted:       granted,
+			ExpectedCount: 2,
+		},
+		"narrowed": {
+			Granted:       granted,
+			Details:       `[{"type":"payment_initiation","actions":["status"]}]`,
+			ExpectedCount: 1,
+		},
+		"not granted": {
+			Granted:       granted,
+			Details:       `[{"type":"payment_initiation","actions":["cancel"]}]`,
+			ExpectedError: E_INVALID_AUTHORIZATION_DETAILS,
+		},
+		"grant without details": {
+			Details:       `[{"type":"payment_initiation","actions":["status"]}]`,
+			ExpectedError: E_INVALID_AUTHORIZATION_DETAILS,
+		},
+	}
+
+	for k, tc := range testcases {
+		storage := NewTestingStorage()
+		storage.authorize["rar"] = &AuthorizeData{
+			Client:               storage.clients["1234"],
+			Code:                 "rar",
+			ExpiresIn:            3600,
+			CreatedAt:            time.Now(),
+			RedirectUri:          "http://localhost:14000/appauth",
+			AuthorizationDetails: tc.Granted,
+		}
+		server := NewServer(NewServerConfig(), storage)
+		server.AccessTokenGen = &TestingAccessTokenGen{}
+		server.RegisterAuthorizationDetailType("payment_initiation", nil)
+		resp := server.NewResponse()
+
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = make(url.Values)
+		req.Form.Set("grant_type", string(AUTHORIZATION_CODE))
+		req.Form.Set("code", "rar")
+		req.Form.Set("redirect_uri", "http://localhost:14000/appauth")
+		if tc.Details != "" {
+			req.Form.Set("authorization_details", tc.Details)
+		}
+		req.PostForm = make(url.Values)
+
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+
+		if tc.ExpectedError != "" {
+			if resp.ErrorId != tc.ExpectedError {
+				t.Errorf("%s: expected error %s, got %v", k, tc.ExpectedError, resp.Output)
+			}
+			continue
+		}
+		if resp.IsError {
+			t.Errorf("%s: unexpected error: %v", k, resp.Output)
+			continue
+		}
+		d, ok := resp.Output["authorization_details"].(AuthorizationDetails)
+		if !ok || len(d) != tc.ExpectedCount {
+			t.Errorf("%s: expected %d authorization details in output, got %v", k, tc.ExpectedCount, resp.Output["authorization_details"])
+		}
+		if a := storage.access["1"]; a == nil || len(a.AuthorizationDetails) != tc.ExpectedCount {
+			t.Errorf("%s: authorization details not saved with access data", k)
+		}
+	}
+}
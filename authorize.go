@@ -1,6 +1,8 @@
 package osin
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/url"
 	"regexp"
@@ -20,8 +22,23 @@ const (
 
 var (
 	pkceMatcher = regexp.MustCompile("^[a-zA-Z0-9~._-]{43,128}$")
+
+	// grant types of the response types, checked with ClientGrantTypes
+	authorizeGrantTypes = map[AuthorizeRequestType]AccessRequestType{
+		CODE:  AUTHORIZATION_CODE,
+		TOKEN: IMPLICIT,
+	}
 )
 
+// ClientAuthorizationExpiration is an optional interface clients can
+// implement to be issued authorization codes with an expiration other than
+// Config.AuthorizationExpiration
+type ClientAuthorizationExpiration interface {
+	// GetAuthorizationExpiration returns the code expiration in seconds,
+	// or 0 for the configured one
+	GetAuthorizationExpiration() int32
+}
+
 // Authorize request information
 type AuthorizeRequest struct {
 	Type        AuthorizeRequestType
@@ -30,9 +47,17 @@ type AuthorizeRequest struct {
 	RedirectUri string
 	State       string
 
+	// Set if the request had no redirect_uri and RedirectUri is the
+	// client default
+	RedirectUriDefaulted bool
+
 	// Set if request is authorized
 	Authorized bool
 
+	// Scope granted by the user at consent, if narrower than the requested
+	// Scope. Set it with Authorized.
+	GrantedScopes string
+
 	// Token expiration in seconds. Change if different from default.
 	// If type = TOKEN, this expiration will be for the ACCESS token.
 	Expiration int32
@@ -43,10 +68,35 @@ type AuthorizeRequest struct {
 	// HttpRequest *http.Request for special use
 	HttpRequest *http.Request
 
+	// Context set with WithContext
+	ctx context.Context
+
 	// Optional code_challenge as described in rfc7636
 	CodeChallenge string
 	// Optional code_challenge_method as described in rfc7636
 	CodeChallengeMethod string
+
+	// Optional authorization_details as described in rfc9396
+	AuthorizationDetails AuthorizationDetails
+
+	// Authentication demanded by the client with acr_values and max_age
+	AuthenticationRequirement *AuthenticationRequirement
+
+	// How the user was authenticated. Set it after login.
+	AuthenticationContext AuthenticationContext
+
+	// Local subject identifier of the user. Set it after login.
+	Subject string
+
+	// Optional OpenID Connect nonce, to be included in the ID token
+	Nonce string
+
+	// Optional OpenID Connect claims request parameter
+	ClaimsRequest *ClaimsRequest
+
+	// How the response parameters are returned: "query", "fragment" or
+	// blank for the default of the response type
+	ResponseMode string
 }
 
 // Authorization data
@@ -66,6 +116,10 @@ type AuthorizeData struct {
 	// Redirect Uri from request
 	RedirectUri string
 
+	// Set if the request had no redirect_uri and RedirectUri is the
+	// client default
+	RedirectUriDefaulted bool
+
 	// State data from request
 	State string
 
@@ -79,6 +133,30 @@ type AuthorizeData struct {
 	CodeChallenge string
 	// Optional code_challenge_method as described in rfc7636
 	CodeChallengeMethod string
+
+	// Authorization details granted, as described in rfc9396
+	AuthorizationDetails AuthorizationDetails
+
+	// How the user was authenticated
+	AuthenticationContext AuthenticationContext
+
+	// Local subject identifier of the user
+	Subject string
+
+	// Optional OpenID Connect nonce from the authorize request
+	Nonce string
+
+	// Optional OpenID Connect claims requested with the claims parameter
+	ClaimsRequest *ClaimsRequest
+
+	// Scope granted by the user at consent. Scope if blank.
+	GrantedScopes string
+
+	// Date the user granted the authorization
+	GrantedAt time.Time
+
+	// HTTP client the code was issued to, with Config.RecordFingerprint
+	Fingerprint *Fingerprint
 }
 
 // IsExpired is true if authorization expired
@@ -104,7 +182,36 @@ type AuthorizeTokenGen interface {
 // HandleAuthorizeRequest is the main http.HandlerFunc for handling
 // authorization requests
 func (s *Server) HandleAuthorizeRequest(w *Response, r *http.Request) *AuthorizeRequest {
-	r.ParseForm()
+	if len(s.middleware) == 0 {
+		return s.handleAuthorizeRequest(w, r)
+	}
+	ret, _ := s.runMiddleware(ENDPOINT_AUTHORIZE, w, r, func(endpoint Endpoint, w *Response, r *http.Request) interface{} {
+		if ar := s.handleAuthorizeRequest(w, r); ar != nil {
+			return ar
+		}
+		return nil
+	}).(*AuthorizeRequest)
+	return ret
+}
+
+func (s *Server) handleAuthorizeRequest(w *Response, r *http.Request) *AuthorizeRequest {
+	formErr := s.parseForm(w, r)
+	if w.Languages == nil {
+		w.Languages = RequestLanguages(r)
+	}
+
+	if !s.checkMaintenance(w) {
+		return nil
+	}
+
+	if formErr == ErrRequestTooLarge {
+		w.SetError(E_INVALID_REQUEST, "")
+		w.InternalError = formErr
+		return nil
+	}
+	if !s.checkParameterLengths(w, r) {
+		return nil
+	}
 
 	// create the authorization request
 	unescapedUri, err := url.QueryUnescape(r.Form.Get("redirect_uri"))
@@ -119,6 +226,10 @@ func (s *Server) HandleAuthorizeRequest(w *Response, r *http.Request) *Authorize
 		RedirectUri: unescapedUri,
 		Authorized:  false,
 		HttpRequest: r,
+		Nonce:       r.Form.Get("nonce"),
+
+		RedirectUriDefaulted: unescapedUri == "",
+		ResponseMode:         r.Form.Get("response_mode"),
 	}
 
 	clientIDs := r.Form["client_id"]
@@ -129,7 +240,7 @@ func (s *Server) HandleAuthorizeRequest(w *Response, r *http.Request) *Authorize
 	for _, id := range clientIDs {
 		// must have a valid client
 		var cl Client
-		cl, err = w.Storage.GetClient(id)
+		cl, err = storageGetClient(r.Context(), w.Storage, id)
 		if err != nil {
 			w.SetErrorState(E_SERVER_ERROR, "unable to get client", ret.State)
 			w.InternalError = err
@@ -139,7 +250,7 @@ func (s *Server) HandleAuthorizeRequest(w *Response, r *http.Request) *Authorize
 			w.SetErrorState(E_UNAUTHORIZED_CLIENT, "client not found", ret.State)
 			return nil
 		}
-		if cl.GetRedirectURI() == "" {
+		if !hasRedirectURI(cl) {
 			w.SetErrorState(E_UNAUTHORIZED_CLIENT, "client redirect URI not found", ret.State)
 			return nil
 		}
@@ -148,10 +259,10 @@ func (s *Server) HandleAuthorizeRequest(w *Response, r *http.Request) *Authorize
 		// check redirect uri, if there are multiple client redirect uri's
 		// set the first redirect_uri of the client
 		if ret.RedirectUri == "" {
-			ret.RedirectUri = FirstUri(cl.GetRedirectURI(), s.Config.RedirectUriSeparator)
+			ret.RedirectUri = FirstRedirectURI(s.redirectURIs(w, cl))
 		}
 	}
-	if err = ValidateUriList(comboClient.GetRedirectURI(), ret.RedirectUri, ","); err != nil {
+	if err = ValidateRedirectURIs(s.redirectURIs(w, comboClient), ret.RedirectUri); err != nil {
 		w.SetErrorState(E_INVALID_REQUEST, "redirect URI invalid", ret.State)
 		return nil
 	}
@@ -163,16 +274,75 @@ func (s *Server) HandleAuthorizeRequest(w *Response, r *http.Request) *Authorize
 
 	w.SetRedirect(ret.RedirectUri)
 
+	// https://openid.net/specs/oauth-v2-multiple-response-types-1_0.html#ResponseModes
+	// tokens, and errors of the implicit flow, are never returned in the query
 	requestType := AuthorizeRequestType(r.Form.Get("response_type"))
-	if s.Config.AllowedAuthorizeTypes.Exists(requestType) {
+	if requestType == TOKEN || ret.ResponseMode == "fragment" {
+		w.SetRedirectFragment(true)
+	}
+	if ret.ResponseMode != "" && ret.ResponseMode != "query" && ret.ResponseMode != "fragment" {
+		w.SetErrorState(E_INVALID_REQUEST, "response_mode not supported", ret.State)
+		return nil
+	}
+	if requestType == TOKEN && ret.ResponseMode == "query" {
+		w.SetErrorState(E_INVALID_REQUEST, "response_mode query not allowed for response_type token", ret.State)
+		return nil
+	}
+
+	if s.requestConfig(w).RequireState && ret.State == "" {
+		w.SetErrorState(E_INVALID_REQUEST, "state is required", "")
+		return nil
+	}
+
+	// https://openid.net/specs/openid-connect-core-1_0.html#AuthRequest
+	if s.requestConfig(w).RequireNonce && ret.Nonce == "" && HasScope(ret.Scope, "openid") {
+		w.SetErrorState(E_INVALID_REQUEST, "nonce is required", ret.State)
+		return nil
+	}
+
+	// apply the client default and maximum scope
+	var ok bool
+	if ret.Scope, ok = s.limitScope(w, ret.Client, ret.Scope, ret.State); !ok {
+		return nil
+	}
+
+	// Optional authorization_details (https://www.rfc-editor.org/rfc/rfc9396)
+	if ret.AuthorizationDetails, ok = s.getAuthorizationDetails(w, r, ret.Client, ret.State); !ok {
+		return nil
+	}
+
+	// Optional claims parameter (https://openid.net/specs/openid-connect-core-1_0.html#ClaimsParameter)
+	if claims := r.Form.Get("claims"); claims != "" {
+		if ret.ClaimsRequest, err = ParseClaimsRequest(claims); err != nil {
+			w.SetErrorState(E_INVALID_REQUEST, "invalid claims parameter", ret.State)
+			w.InternalError = err
+			return nil
+		}
+	}
+
+	// Optional acr_values and max_age
+	if ret.AuthenticationRequirement, err = ParseAuthenticationRequirement(r); err != nil {
+		w.SetErrorState(E_INVALID_REQUEST, err.Error(), ret.State)
+		return nil
+	}
+
+	if requestType == TOKEN && s.requestConfig(w).DisableImplicit {
+		w.SetErrorState(E_UNSUPPORTED_RESPONSE_TYPE, "the implicit flow is disabled, use response_type code", ret.State)
+		return nil
+	}
+	if grantType, ok := authorizeGrantTypes[requestType]; ok && !ClientAllowsGrantType(ret.Client, grantType) {
+		w.SetErrorState(E_UNAUTHORIZED_CLIENT, "", ret.State)
+		w.InternalError = errors.New("client may not use the " + string(grantType) + " grant type")
+		return nil
+	}
+	if s.requestConfig(w).AllowedAuthorizeTypes.Exists(requestType) {
 		switch requestType {
 		case CODE:
 			ret.Type = CODE
-			ret.Expiration = s.Config.AuthorizationExpiration
 
 			// Optional PKCE support (https://tools.ietf.org/html/rfc7636)
 			if codeChallenge := r.Form.Get("code_challenge"); len(codeChallenge) == 0 {
-				if s.Config.RequirePKCEForPublicClients && CheckClientSecret(ret.Client, "") {
+				if s.requestConfig(w).RequirePKCEForPublicClients && s.isPublicClient(ret.Client) {
 					// https://tools.ietf.org/html/rfc7636#section-4.4.1
 					w.SetErrorState(E_INVALID_REQUEST, "code_challenge (rfc7636) required for public clients", ret.State)
 					return nil
@@ -198,10 +368,16 @@ func (s *Server) HandleAuthorizeRequest(w *Response, r *http.Request) *Authorize
 				ret.CodeChallenge = codeChallenge
 				ret.CodeChallengeMethod = codeChallengeMethod
 			}
+			ret.Expiration = s.authorizationExpiration(w, ret)
 
 		case TOKEN:
 			ret.Type = TOKEN
-			ret.Expiration = s.Config.AccessExpiration
+			ret.Expiration = s.requestConfig(w).AccessExpirationFor(IMPLICIT)
+
+			if s.requestConfig(w).ImplicitExactRedirectUri && !s.exactRedirectUri(w, ret.Client, ret.RedirectUri) {
+				w.SetErrorState(E_INVALID_REQUEST, "redirect URI must match a registered one exactly", ret.State)
+				return nil
+			}
 		}
 		return ret
 	}
@@ -210,6 +386,42 @@ func (s *Server) HandleAuthorizeRequest(w *Response, r *http.Request) *Authorize
 	return nil
 }
 
+// saveAuthorizeCode generates the code of the authorization and saves it.
+// If the storage detects collisions, a new code is generated for each one.
+func (s *Server) saveAuthorizeCode(w *Response, ar *AuthorizeRequest, data *AuthorizeData) error {
+	storage := w.Storage
+	inserter, _ := storage.(AuthorizeInserter)
+	attempts := s.requestConfig(w).AuthorizeCodeAttempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	for i := 0; ; i++ {
+		var err error
+		if gen, ok := s.AuthorizeTokenGen.(AuthorizeTokenGenWithContext); ok {
+			data.Code, err = gen.GenerateAuthorizeTokenContext(ar.Context(), data)
+		} else {
+			data.Code, err = s.AuthorizeTokenGen.GenerateAuthorizeToken(data)
+		}
+		if err != nil {
+			return err
+		}
+
+		if inserter == nil {
+			return storageSaveAuthorize(ar.Context(), storage, data)
+		}
+		err = inserter.InsertAuthorize(data)
+		if err != ErrAlreadyExists || i+1 >= attempts {
+			return err
+		}
+	}
+}
+
+// exactRedirectUri returns true if the redirect uri is exactly equal to one
+// registered by the client
+func (s *Server) exactRedirectUri(w *Response, client Client, redirectUri string) bool {
+	return ValidateRedirectURIsExact(s.redirectURIs(w, client), redirectUri) == nil
+}
+
 func (s *Server) FinishAuthorizeRequest(w *Response, r *http.Request, ar *AuthorizeRequest) {
 	// don't process if is already an error
 	if w.IsError {
@@ -218,11 +430,19 @@ func (s *Server) FinishAuthorizeRequest(w *Response, r *http.Request, ar *Author
 
 	// force redirect response
 	w.SetRedirect(ar.RedirectUri)
+	if ar.ResponseMode == "fragment" {
+		w.SetRedirectFragment(true)
+	}
 
 	if ar.Authorized {
 		if ar.Type == TOKEN {
 			w.SetRedirectFragment(true)
 
+			expiration := ar.Expiration
+			if max := s.requestConfig(w).ImplicitMaxExpiration; max > 0 && (expiration <= 0 || expiration > max) {
+				expiration = max
+			}
+
 			// generate token directly
 			ret := &AccessRequest{
 				Type:            IMPLICIT,
@@ -232,8 +452,17 @@ func (s *Server) FinishAuthorizeRequest(w *Response, r *http.Request, ar *Author
 				Scope:           ar.Scope,
 				GenerateRefresh: false, // per the RFC, should NOT generate a refresh token in this case
 				Authorized:      true,
-				Expiration:      ar.Expiration,
+				Expiration:      expiration,
 				UserData:        ar.UserData,
+
+				AuthorizationDetails: ar.AuthorizationDetails,
+
+				AuthenticationContext: ar.AuthenticationContext,
+				Subject:               ar.Subject,
+				Nonce:                 ar.Nonce,
+				ClaimsRequest:         ar.ClaimsRequest,
+				GrantedScopes:         ar.GrantedScopes,
+				GrantedAt:             s.Now(),
 			}
 
 			s.FinishAccessRequest(w, r, ret)
@@ -250,33 +479,59 @@ func (s *Server) FinishAuthorizeRequest(w *Response, r *http.Request, ar *Author
 				State:       ar.State,
 				Scope:       ar.Scope,
 				UserData:    ar.UserData,
+
+				RedirectUriDefaulted: ar.RedirectUriDefaulted,
 				// Optional PKCE challenge
 				CodeChallenge:       ar.CodeChallenge,
 				CodeChallengeMethod: ar.CodeChallengeMethod,
+				// Optional rfc9396 authorization details
+				AuthorizationDetails: ar.AuthorizationDetails,
+
+				AuthenticationContext: ar.AuthenticationContext,
+				Subject:               ar.Subject,
+				Nonce:                 ar.Nonce,
+				ClaimsRequest:         ar.ClaimsRequest,
+				GrantedScopes:         ar.GrantedScopes,
+				GrantedAt:             s.Now(),
+				Fingerprint:           s.fingerprint(w, r),
 			}
 
-			// generate token code
-			code, err := s.AuthorizeTokenGen.GenerateAuthorizeToken(ret)
-			if err != nil {
+			// generate and save the authorization code
+			if err := s.saveAuthorizeCode(w, ar, ret); err != nil {
 				w.SetErrorState(E_SERVER_ERROR, "", ar.State)
 				w.InternalError = err
 				return
 			}
-			ret.Code = code
 
-			// save authorization token
-			if err = w.Storage.SaveAuthorize(ret); err != nil {
-				w.SetErrorState(E_SERVER_ERROR, "", ar.State)
-				w.InternalError = err
-				return
-			}
+			s.emitEvent(&Event{
+				Type:    EVENT_CODE_ISSUED,
+				Client:  ar.Client,
+				Request: r,
+				Data:    map[string]interface{}{"subject": ret.Subject, "fingerprint": ret.Fingerprint},
+			})
 
 			// redirect with code
-			w.Output["code"] = ret.Code
-			w.Output["state"] = ret.State
+			w.SetAuthorizeResponse(&AuthorizeResponse{
+				Code:  ret.Code,
+				State: ret.State,
+			})
 		}
 	} else {
-		// redirect with error
-		w.SetErrorState(E_ACCESS_DENIED, "", ar.State)
+		// redirect with error, or as set by the consent denial handler
+		s.finishConsentDenial(w, ar)
+	}
+}
+
+// authorizationExpiration returns the expiration of the code of the
+// request, from the client or the configuration, shortened without PKCE
+// if configured
+func (s *Server) authorizationExpiration(w *Response, ar *AuthorizeRequest) int32 {
+	expiration := s.requestConfig(w).AuthorizationExpiration
+	if c, ok := ar.Client.(ClientAuthorizationExpiration); ok && c.GetAuthorizationExpiration() > 0 {
+		expiration = c.GetAuthorizationExpiration()
+	}
+	if max := s.requestConfig(w).AuthorizationExpirationWithoutPKCE; max > 0 && ar.CodeChallenge == "" && expiration > max {
+		expiration = max
 	}
+	return expiration
 }
@@ -301,3 +301,167 @@ func TestAuthorizeCodePKCES256(t *testing.T) {
 		t.Errorf("Expected stored code_challenge S256, got %s", token.CodeChallengeMethod)
 	}
 }
+
+func TestAuthorizeTokenHardening(t *testing.T) {
+	testcases := map[string]struct {
+		Config       func(c *ServerConfig)
+		ResponseMode string
+		RedirectUri  string
+		ErrorId      string
+		ExpiresIn    interface{}
+	}{
+		"default": {
+			ExpiresIn: int32(3600),
+		},
+		"short ttl": {
+			Config:    func(c *ServerConfig) { c.ImplicitMaxExpiration = 300 },
+			ExpiresIn: int32(300),
+		},
+		"disabled": {
+			Config:  func(c *ServerConfig) { c.DisableImplicit = true },
+			ErrorId: E_UNSUPPORTED_RESPONSE_TYPE,
+		},
+		"query response mode": {
+			ResponseMode: "query",
+			ErrorId:      E_INVALID_REQUEST,
+		},
+		"subpath redirect uri": {
+			Config:      func(c *ServerConfig) { c.ImplicitExactRedirectUri = true },
+			RedirectUri: "http://localhost:14000/appauth/sub",
+			ErrorId:     E_INVALID_REQUEST,
+		},
+		"exact redirect uri": {
+			Config:      func(c *ServerConfig) { c.ImplicitExactRedirectUri = true },
+			RedirectUri: "http://localhost:14000/appauth",
+			ExpiresIn:   int32(3600),
+		},
+	}
+
+	for k, tc := range testcases {
+		sconfig := NewServerConfig()
+		sconfig.AllowedAuthorizeTypes = AllowedAuthorizeType{TOKEN}
+		if tc.Config != nil {
+			tc.Config(sconfig)
+		}
+		server := NewServer(sconfig, NewTestingStorage())
+		server.AccessTokenGen = &TestingAccessTokenGen{}
+		resp := server.NewResponse()
+
+		req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Form = make(url.Values)
+		req.Form.Set("response_type", string(TOKEN))
+		req.Form.Set("client_id", "1234")
+		req.Form.Set("state", "a")
+		req.Form.Set("response_mode", tc.ResponseMode)
+		req.Form.Set("redirect_uri", tc.RedirectUri)
+
+		if ar := server.HandleAuthorizeRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAuthorizeRequest(resp, req, ar)
+		}
+
+		if resp.ErrorId != tc.ErrorId {
+			t.Errorf("%s: expected error %q, got %q", k, tc.ErrorId, resp.ErrorId)
+			continue
+		}
+		if resp.Type == REDIRECT && !resp.RedirectInFragment {
+			t.Errorf("%s: response should be in the fragment", k)
+		}
+		if tc.ErrorId == "" && resp.Output["expires_in"] != tc.ExpiresIn {
+			t.Errorf("%s: expected expires_in %v, got %v", k, tc.ExpiresIn, resp.Output["expires_in"])
+		}
+		if _, ok := resp.Output["refresh_token"]; ok {
+			t.Errorf("%s: implicit flow must not issue refresh tokens", k)
+		}
+	}
+}
+
+func TestAuthorizeCodeFragmentResponseMode(t *testing.T) {
+	sconfig := NewServerConfig()
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+	resp := server.NewResponse()
+
+	req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Form = make(url.Values)
+	req.Form.Set("response_type", string(CODE))
+	req.Form.Set("client_id", "1234")
+	req.Form.Set("response_mode", "fragment")
+
+	if ar := server.HandleAuthorizeRequest(resp, req); ar != nil {
+		ar.Authorized = true
+		server.FinishAuthorizeRequest(resp, req, ar)
+	}
+
+	if resp.IsError {
+		t.Fatalf("Should not be an error: %v", resp.Output)
+	}
+	if !resp.RedirectInFragment {
+		t.Fatalf("Response should be in the fragment")
+	}
+}
+
+type expirationClient struct {
+	DefaultClient
+	expiration int32
+}
+
+func (c *expirationClient) GetAuthorizationExpiration() int32 {
+	return c.expiration
+}
+
+func TestAuthorizationExpiration(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AuthorizationExpirationWithoutPKCE = 120
+	server := NewServer(sconfig, NewTestingStorage())
+
+	partner := &expirationClient{DefaultClient: DefaultClient{Id: "partner"}, expiration: 600}
+	firstParty := &expirationClient{DefaultClient: DefaultClient{Id: "app"}, expiration: 30}
+	tests := map[string]struct {
+		ar       *AuthorizeRequest
+		expected int32
+	}{
+		"configured": {
+			ar:       &AuthorizeRequest{Client: &DefaultClient{}, CodeChallenge: "c"},
+			expected: 250,
+		},
+		"configured without pkce": {
+			ar:       &AuthorizeRequest{Client: &DefaultClient{}},
+			expected: 120,
+		},
+		"client": {
+			ar:       &AuthorizeRequest{Client: partner, CodeChallenge: "c"},
+			expected: 600,
+		},
+		"client without pkce": {
+			ar:       &AuthorizeRequest{Client: partner},
+			expected: 120,
+		},
+		"shorter client without pkce": {
+			ar:       &AuthorizeRequest{Client: firstParty},
+			expected: 30,
+		},
+	}
+	for k, test := range tests {
+		if e := server.authorizationExpiration(server.NewResponse(), test.ar); e != test.expected {
+			t.Errorf("%s: expected expiration %d, got %d", k, test.expected, e)
+		}
+	}
+
+	resp := server.NewResponse()
+	req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Form = url.Values{"response_type": {string(CODE)}, "client_id": {"1234"}}
+	ar := server.HandleAuthorizeRequest(resp, req)
+	if ar == nil || ar.Expiration != 120 {
+		t.Fatalf("Unexpected authorize request: %+v", ar)
+	}
+}
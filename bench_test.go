@@ -0,0 +1,81 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// benchResponseWriter discards the response
+type benchResponseWriter struct {
+	header http.Header
+}
+
+func (w *benchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *benchResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *benchResponseWriter) WriteHeader(int) {
+}
+
+func (w *benchResponseWriter) reset() {
+	for k := range w.header {
+		delete(w.header, k)
+	}
+}
+
+func BenchmarkClientCredentialsRequest(b *testing.B) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+	server := NewServer(sconfig, NewTestingStorage())
+	req, err := http.NewRequest("POST", "http://localhost:14000/token", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	req.SetBasicAuth("1234", "aabbccdd")
+	req.Form = url.Values{"grant_type": {string(CLIENT_CREDENTIALS)}, "scope": {"a"}}
+	req.PostForm = req.Form
+
+	w := &benchResponseWriter{header: make(http.Header)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.reset()
+		resp := server.NewResponse()
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			ar.SkipSetCookie = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if resp.IsError {
+			b.Fatalf("Error in response: %v", resp.Output)
+		}
+		OutputJSON(resp, w, req)
+		resp.Close()
+	}
+}
+
+func BenchmarkOutputJSON(b *testing.B) {
+	req, err := http.NewRequest("POST", "http://localhost:14000/token", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	resp := NewResponse(NewTestingStorage())
+	resp.Output["access_token"] = "ZmE5YjM0NjUtNjQ3Ni00ZDM1LWIzYTItY2NmOTI3OTk1YjNl"
+	resp.Output["token_type"] = "Bearer"
+	resp.Output["expires_in"] = int32(3600)
+	resp.Output["scope"] = "a"
+	w := &benchResponseWriter{header: make(http.Header)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.reset()
+		OutputJSON(resp, w, req)
+	}
+}
@@ -0,0 +1,524 @@
+package osin
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UpstreamIdentity is a user authenticated by an upstream identity provider
+type UpstreamIdentity struct {
+	// Name of the connector, as registered on the server
+	Provider string
+
+	// Issuer and subject identifier of the user at the provider
+	Issuer  string
+	Subject string
+
+	// Claims returned by the provider, like "email"
+	Claims map[string]interface{}
+}
+
+// UpstreamLogin is a login in progress at an upstream identity provider,
+// saved with the suspended authorize request
+type UpstreamLogin struct {
+	Provider string
+
+	// Hash of the cookie binding the login to the user agent
+	Binding string
+
+	// Data kept by the connector until the callback, like the nonce
+	Data map[string]string
+}
+
+// UpstreamConnector authenticates users at an upstream identity provider.
+// OIDCConnector connects OpenID Connect providers; SAML identity providers
+// are connected by implementing it over a SAML library, with the
+// RelayState carrying the state.
+type UpstreamConnector interface {
+	// LoginURL returns the URL of the provider the user is redirected to.
+	// The provider must return to callbackURL with the state, as the
+	// "state" or "RelayState" parameter. Data needed by Callback is
+	// saved in login.Data.
+	LoginURL(ctx context.Context, state, callbackURL string, login *UpstreamLogin) (string, error)
+
+	// Callback verifies the response of the provider to callbackURL and
+	// returns the authenticated identity
+	Callback(ctx context.Context, r *http.Request, callbackURL string, login *UpstreamLogin) (*UpstreamIdentity, error)
+}
+
+// IdentityMapper maps an upstream identity to the local user of a brokered
+// authorize request, setting its Subject and UserData. Returning an error
+// denies the request.
+type IdentityMapper interface {
+	MapIdentity(ctx context.Context, ar *AuthorizeRequest, id *UpstreamIdentity) error
+}
+
+// IdentityMapperFunc allows a function to be used as an IdentityMapper
+type IdentityMapperFunc func(ctx context.Context, ar *AuthorizeRequest, id *UpstreamIdentity) error
+
+// MapIdentity calls f(ctx, ar, id)
+func (f IdentityMapperFunc) MapIdentity(ctx context.Context, ar *AuthorizeRequest, id *UpstreamIdentity) error {
+	return f(ctx, ar, id)
+}
+
+// RegisterUpstreamConnector adds the connector of an upstream identity
+// provider under its name
+func (s *Server) RegisterUpstreamConnector(name string, c UpstreamConnector) {
+	if s.UpstreamConnectors == nil {
+		s.UpstreamConnectors = make(map[string]UpstreamConnector)
+	}
+	s.UpstreamConnectors[name] = c
+}
+
+// UPSTREAM_BINDING_COOKIE is the name of the cookie binding a login at an
+// upstream provider to the user agent that started it
+const UPSTREAM_BINDING_COOKIE = "osin_upstream"
+
+// upstreamBindingCookie returns the cookie binding the brokered login of
+// a pending request to the user agent
+func (s *Server) upstreamBindingCookie(handle, value string, maxAge int) *http.Cookie {
+	h := sha256.Sum256([]byte(handle))
+	return &http.Cookie{
+		// named after the login, for the logins in progress in other tabs
+		Name:     UPSTREAM_BINDING_COOKIE + "_" + base64.RawURLEncoding.EncodeToString(h[:8]),
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   true,
+		HttpOnly: true,
+		// the SAML providers return with a cross-site POST
+		SameSite: http.SameSiteNoneMode,
+	}
+}
+
+// RedirectToUpstream suspends the authorize request in the
+// PendingAuthorizeStore and redirects the user to the login of the
+// upstream provider. The provider returns to Config.UpstreamCallbackUri,
+// where HandleUpstreamCallback resumes the request. The login is bound to
+// the user agent with a cookie set on the response, so a callback URL
+// can't be replayed in another browser.
+func (s *Server) RedirectToUpstream(w *Response, ar *AuthorizeRequest, provider string) {
+	c, ok := s.UpstreamConnectors[provider]
+	if !ok || c == nil {
+		w.SetErrorState(E_INVALID_REQUEST, "unknown identity provider", ar.State)
+		return
+	}
+	if s.PendingAuthorizeStore == nil || s.requestConfig(w).UpstreamCallbackUri == "" {
+		w.SetErrorState(E_SERVER_ERROR, "", ar.State)
+		w.InternalError = errors.New("brokering needs a pending authorize store and an upstream callback uri")
+		return
+	}
+
+	p := s.newPendingAuthorize(s.requestConfig(w), ar)
+	p.Upstream = &UpstreamLogin{Provider: provider, Data: make(map[string]string)}
+	var err error
+	if p.Handle, err = newPendingHandle(); err != nil {
+		w.SetErrorState(E_SERVER_ERROR, "", ar.State)
+		w.InternalError = err
+		return
+	}
+	binding, err := (TokenFormat{Length: 16}).Generate()
+	if err != nil {
+		w.SetErrorState(E_SERVER_ERROR, "", ar.State)
+		w.InternalError = err
+		return
+	}
+	bh := sha256.Sum256([]byte(binding))
+	p.Upstream.Binding = base64.RawURLEncoding.EncodeToString(bh[:])
+	loginURL, err := c.LoginURL(ar.Context(), p.Handle, s.requestConfig(w).UpstreamCallbackUri, p.Upstream)
+	if err != nil {
+		w.SetErrorState(E_SERVER_ERROR, "", ar.State)
+		w.InternalError = err
+		return
+	}
+	if err = s.PendingAuthorizeStore.SavePendingAuthorize(p); err != nil {
+		w.SetErrorState(E_SERVER_ERROR, "", ar.State)
+		w.InternalError = err
+		return
+	}
+	w.Headers.Add("Set-Cookie", s.upstreamBindingCookie(p.Handle, binding, int(s.requestConfig(w).PendingAuthorizeExpiration)).String())
+	w.SetRedirect(loginURL)
+}
+
+// checkUpstreamBinding verifies the callback comes from the user agent
+// that started the brokered login, clearing its binding cookie
+func (s *Server) checkUpstreamBinding(w *Response, r *http.Request, p *PendingAuthorize) bool {
+	name := s.upstreamBindingCookie(p.Handle, "", 0).Name
+	w.Headers.Add("Set-Cookie", s.upstreamBindingCookie(p.Handle, "", -1).String())
+	cookie, err := r.Cookie(name)
+	if err != nil || p.Upstream.Binding == "" {
+		return false
+	}
+	h := sha256.Sum256([]byte(cookie.Value))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(h[:])), []byte(p.Upstream.Binding)) == 1
+}
+
+// HandleUpstreamCallback verifies the response of the upstream provider
+// and resumes the suspended authorize request, its Subject, UserData and
+// AuthenticationContext set from the upstream identity by the
+// IdentityMapper. The application then sets Authorized, after consent if
+// needed, and calls FinishAuthorizeRequest. Failed upstream logins are
+// redirected to the client with access_denied.
+func (s *Server) HandleUpstreamCallback(w *Response, r *http.Request) *AuthorizeRequest {
+	if err := s.parseForm(w, r); err != nil {
+		w.SetError(E_INVALID_REQUEST, "")
+		w.InternalError = err
+		return nil
+	}
+	if !s.checkParameterLengths(w, r) {
+		return nil
+	}
+	state := r.Form.Get("state")
+	if state == "" {
+		state = r.Form.Get("RelayState")
+	}
+	if state == "" {
+		w.SetError(E_INVALID_REQUEST, "state is required")
+		return nil
+	}
+
+	p := s.takePendingAuthorize(w, state)
+	if p == nil {
+		return nil
+	}
+	if p.Upstream == nil {
+		w.SetError(E_INVALID_REQUEST, "authorize request not brokered")
+		return nil
+	}
+	if !s.checkUpstreamBinding(w, r, p) {
+		w.SetError(E_INVALID_REQUEST, "upstream login started in another user agent")
+		return nil
+	}
+	c, ok := s.UpstreamConnectors[p.Upstream.Provider]
+	if !ok || c == nil {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = fmt.Errorf("upstream connector %q not registered", p.Upstream.Provider)
+		return nil
+	}
+	ar := s.restorePendingAuthorize(w, r, p)
+	if ar == nil {
+		return nil
+	}
+
+	id, err := c.Callback(r.Context(), r, s.requestConfig(w).UpstreamCallbackUri, p.Upstream)
+	if err != nil {
+		w.SetErrorState(E_ACCESS_DENIED, "upstream authentication failed", ar.State)
+		w.InternalError = err
+		return nil
+	}
+	id.Provider = p.Upstream.Provider
+
+	ar.AuthenticationContext = AuthenticationContext{AMR: []string{"fed"}, AuthTime: s.Now()}
+	mapper := s.IdentityMapper
+	if mapper == nil {
+		mapper = IdentityMapperFunc(mapUpstreamIdentity)
+	}
+	if err = mapper.MapIdentity(r.Context(), ar, id); err != nil {
+		w.SetErrorState(E_ACCESS_DENIED, "", ar.State)
+		w.InternalError = err
+		return nil
+	}
+	return ar
+}
+
+// mapUpstreamIdentity is the default IdentityMapper, deriving the subject
+// from the provider and the upstream subject, and keeping the identity as
+// UserData if not set
+func mapUpstreamIdentity(ctx context.Context, ar *AuthorizeRequest, id *UpstreamIdentity) error {
+	if id.Subject == "" {
+		return errors.New("upstream identity has no subject")
+	}
+	ar.Subject = id.Provider + ":" + id.Subject
+	if ar.UserData == nil {
+		ar.UserData = id
+	}
+	return nil
+}
+
+// OIDCConnector is an UpstreamConnector for an OpenID Connect provider,
+// using the authorization code flow with PKCE and verifying the ID token
+type OIDCConnector struct {
+	// Issuer identifier of the provider. Its endpoints are discovered from
+	// its openid-configuration if not set.
+	Issuer string
+
+	// Credentials of the client registered at the provider
+	ClientID     string
+	ClientSecret string
+
+	// Returns the client secret of each token request instead of
+	// ClientSecret, if not nil, like the signed JWTs of Sign in with Apple
+	ClientSecretFunc func(ctx context.Context) (string, error)
+
+	// If true, the client credentials are sent in the token request body
+	// (client_secret_post) instead of with HTTP basic authentication
+	ClientSecretPost bool
+
+	// Scope requested (default "openid email profile")
+	Scope string
+
+	// Additional authorize request parameters, like "prompt"
+	AuthorizeParams url.Values
+
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	JWKSURI               string
+
+	// HTTP client calling the provider. http.DefaultClient if nil.
+	HTTPClient *http.Client
+
+	// Current time, time.Now if nil
+	Now func() time.Time
+
+	mu         sync.Mutex
+	keys       *JSONWebKeySet
+	discovered *oidcEndpoints
+}
+
+// oidcEndpoints are the endpoints of an OpenID Connect provider
+type oidcEndpoints struct {
+	authorization, token, jwks string
+}
+
+func (c *OIDCConnector) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+// discover returns the endpoints, the ones not set discovered from the
+// provider metadata. The metadata is fetched without holding the lock.
+func (c *OIDCConnector) discover(ctx context.Context) (oidcEndpoints, error) {
+	e := oidcEndpoints{c.AuthorizationEndpoint, c.TokenEndpoint, c.JWKSURI}
+	if e.authorization != "" && e.token != "" && e.jwks != "" {
+		return e, nil
+	}
+	c.mu.Lock()
+	discovered := c.discovered
+	c.mu.Unlock()
+	if discovered != nil {
+		return *discovered, nil
+	}
+
+	var metadata struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	u := strings.TrimSuffix(c.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := c.getJSON(ctx, u, &metadata); err != nil {
+		return e, err
+	}
+	if metadata.Issuer != c.Issuer {
+		return e, fmt.Errorf("provider metadata issuer %q doesn't match %q", metadata.Issuer, c.Issuer)
+	}
+	if e.authorization == "" {
+		e.authorization = metadata.AuthorizationEndpoint
+	}
+	if e.token == "" {
+		e.token = metadata.TokenEndpoint
+	}
+	if e.jwks == "" {
+		e.jwks = metadata.JWKSURI
+	}
+	c.mu.Lock()
+	c.discovered = &e
+	c.mu.Unlock()
+	return e, nil
+}
+
+func (c *OIDCConnector) client() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (c *OIDCConnector) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s failed with status %d", u, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// LoginURL satisfies UpstreamConnector
+func (c *OIDCConnector) LoginURL(ctx context.Context, state, callbackURL string, login *UpstreamLogin) (string, error) {
+	endpoints, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := (TokenFormat{Length: 16}).Generate()
+	if err != nil {
+		return "", err
+	}
+	verifier, err := (TokenFormat{Length: 32}).Generate()
+	if err != nil {
+		return "", err
+	}
+	login.Data["nonce"] = nonce
+	login.Data["code_verifier"] = verifier
+
+	scope := c.Scope
+	if scope == "" {
+		scope = "openid email profile"
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{}
+	for k, v := range c.AuthorizeParams {
+		q[k] = v
+	}
+	q.Set("response_type", "code")
+	q.Set("client_id", c.ClientID)
+	q.Set("redirect_uri", callbackURL)
+	q.Set("scope", scope)
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", PKCE_S256)
+
+	u, err := url.Parse(endpoints.authorization)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range u.Query() {
+		if _, ok := q[k]; !ok {
+			q[k] = v
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Callback satisfies UpstreamConnector, exchanging the code for the ID
+// token and verifying it
+func (c *OIDCConnector) Callback(ctx context.Context, r *http.Request, callbackURL string, login *UpstreamLogin) (*UpstreamIdentity, error) {
+	if e := r.Form.Get("error"); e != "" {
+		return nil, fmt.Errorf("provider returned %s: %s", e, r.Form.Get("error_description"))
+	}
+	code := r.Form.Get("code")
+	if code == "" {
+		return nil, errors.New("provider returned no code")
+	}
+	endpoints, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	idToken, err := c.exchange(ctx, endpoints.token, code, callbackURL, login.Data["code_verifier"])
+	if err != nil {
+		return nil, err
+	}
+	t, err := ParseJWT(idToken)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.verify(ctx, endpoints.jwks, t); err != nil {
+		return nil, err
+	}
+	if t.StringClaim("iss") != c.Issuer || !t.HasAudience(c.ClientID) {
+		return nil, errors.New("id token issued for another issuer or audience")
+	}
+	if err = t.ValidateTimes(c.now(), time.Minute); err != nil {
+		return nil, err
+	}
+	if nonce := login.Data["nonce"]; nonce == "" || t.StringClaim("nonce") != nonce {
+		return nil, errors.New("id token nonce mismatch")
+	}
+	return &UpstreamIdentity{
+		Issuer:  c.Issuer,
+		Subject: t.StringClaim("sub"),
+		Claims:  t.Claims,
+	}, nil
+}
+
+// exchange redeems the authorization code at the token endpoint, returning
+// the ID token
+func (c *OIDCConnector) exchange(ctx context.Context, tokenEndpoint, code, callbackURL, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {string(AUTHORIZATION_CODE)},
+		"code":          {code},
+		"redirect_uri":  {callbackURL},
+		"code_verifier": {verifier},
+	}
+	secret := c.ClientSecret
+	if c.ClientSecretFunc != nil {
+		var err error
+		if secret, err = c.ClientSecretFunc(ctx); err != nil {
+			return "", err
+		}
+	}
+	if secret == "" || c.ClientSecretPost {
+		form.Set("client_id", c.ClientID)
+	}
+	if secret != "" && c.ClientSecretPost {
+		form.Set("client_secret", secret)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if secret != "" && !c.ClientSecretPost {
+		req.SetBasicAuth(c.ClientID, secret)
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed with status %d", resp.StatusCode)
+	}
+	var tokens struct {
+		IdToken string `json:"id_token"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return "", err
+	}
+	if tokens.IdToken == "" {
+		return "", errors.New("token response has no id_token")
+	}
+	return tokens.IdToken, nil
+}
+
+// verify verifies the ID token signature with the provider keys, fetching
+// them again once if they don't verify it, in case they were rotated
+func (c *OIDCConnector) verify(ctx context.Context, jwksURI string, t *JWT) error {
+	c.mu.Lock()
+	keys := c.keys
+	c.mu.Unlock()
+	if keys != nil && keys.VerifyJWT(t) == nil {
+		return nil
+	}
+	keys = &JSONWebKeySet{}
+	if err := c.getJSON(ctx, jwksURI, keys); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.keys = keys
+	c.mu.Unlock()
+	return keys.VerifyJWT(t)
+}
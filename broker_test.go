@@ -0,0 +1,155 @@
+package osin
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// newTestProvider starts an OpenID Connect provider issuing ID tokens for
+// the "alice" subject, with the nonce of the last authorize request
+func newTestProvider(t *testing.T) *httptest.Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := NewJSONWebKey(key.Public(), "k1", "RS256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var srv *httptest.Server
+	nonces := make(map[string]string)
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{
+				"issuer":                 srv.URL,
+				"authorization_endpoint": srv.URL + "/authorize",
+				"token_endpoint":         srv.URL + "/token",
+				"jwks_uri":               srv.URL + "/jwks",
+			})
+		case "/jwks":
+			json.NewEncoder(w).Encode(JSONWebKeySet{Keys: []JSONWebKey{jwk}})
+		case "/authorize":
+			// the code is the nonce key
+			nonces["code-1"] = r.Form.Get("nonce")
+			w.WriteHeader(http.StatusOK)
+		case "/token":
+			if id, secret, _ := r.BasicAuth(); id != "osin" || secret != "upstream-secret" || r.Form.Get("code_verifier") == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			token, _ := SignJWT(map[string]interface{}{
+				"iss":   srv.URL,
+				"aud":   "osin",
+				"sub":   "alice",
+				"email": "alice@example.com",
+				"nonce": nonces[r.Form.Get("code")],
+				"exp":   time.Now().Add(time.Minute).Unix(),
+			}, "RS256", "k1", key)
+			json.NewEncoder(w).Encode(map[string]string{"id_token": token, "access_token": "upstream"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return srv
+}
+
+func TestBrokeredAuthorize(t *testing.T) {
+	provider := newTestProvider(t)
+	defer provider.Close()
+
+	sconfig := NewServerConfig()
+	sconfig.UpstreamCallbackUri = "http://localhost:14000/upstream/callback"
+	storage := NewTestingStorage()
+	server := NewServer(sconfig, storage)
+	server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+	server.PendingAuthorizeStore = NewMemoryPendingAuthorizeStore()
+	server.RegisterUpstreamConnector("corp", &OIDCConnector{Issuer: provider.URL, ClientID: "osin", ClientSecret: "upstream-secret"})
+
+	// authorize request brokered to the provider
+	resp := server.NewResponse()
+	req, _ := http.NewRequest("GET", "http://localhost:14000/appauth?response_type=code&client_id=1234&state=a&nonce=n1", nil)
+	ar := server.HandleAuthorizeRequest(resp, req)
+	if ar == nil {
+		t.Fatalf("Authorize request should be valid: %v", resp.Output)
+	}
+	server.RedirectToUpstream(resp, ar, "corp")
+	if resp.IsError {
+		t.Fatalf("Unexpected error: %v", resp.Output)
+	}
+	cookies := (&http.Response{Header: resp.Headers}).Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly || !cookies[0].Secure {
+		t.Fatalf("Expected the binding cookie, got %v", resp.Headers["Set-Cookie"])
+	}
+	loginURL, err := resp.GetRedirectUrl()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(loginURL)
+	q := u.Query()
+	if u.Path != "/authorize" || q.Get("redirect_uri") != sconfig.UpstreamCallbackUri || q.Get("code_challenge_method") != PKCE_S256 {
+		t.Fatalf("Unexpected login url %s", loginURL)
+	}
+	if r, err := http.Get(loginURL); err != nil || r.StatusCode != http.StatusOK {
+		t.Fatalf("Login at the provider failed: %v", err)
+	}
+
+	// callback of the provider
+	callback := func(query string, cookies []*http.Cookie) (*Response, *AuthorizeRequest) {
+		resp := server.NewResponse()
+		req, _ := http.NewRequest("GET", sconfig.UpstreamCallbackUri+"?"+query, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		ar := server.HandleUpstreamCallback(resp, req)
+		return resp, ar
+	}
+	resp, ar = callback("code=code-1&state="+url.QueryEscape(q.Get("state")), cookies)
+	if ar == nil {
+		t.Fatalf("Callback should succeed: %v %v", resp.Output, resp.InternalError)
+	}
+	if ar.Subject != "corp:alice" || ar.Nonce != "n1" || ar.State != "a" || ar.AuthenticationContext.AMR[0] != "fed" {
+		t.Fatalf("Unexpected resumed request %+v", ar)
+	}
+	if id, ok := ar.UserData.(*UpstreamIdentity); !ok || id.Claims["email"] != "alice@example.com" {
+		t.Fatalf("Unexpected user data %+v", ar.UserData)
+	}
+	ar.Authorized = true
+	server.FinishAuthorizeRequest(resp, req, ar)
+	if resp.IsError || resp.Output["code"] != "1" || storage.authorize["1"].Subject != "corp:alice" {
+		t.Fatalf("Unexpected authorize response %v", resp.Output)
+	}
+
+	// the state is single use
+	if resp, ar = callback("code=code-1&state="+url.QueryEscape(q.Get("state")), cookies); ar != nil {
+		t.Fatal("Callback should not be replayed")
+	}
+
+	// the callback is refused in another user agent
+	resp = server.NewResponse()
+	ar = server.HandleAuthorizeRequest(resp, req)
+	server.RedirectToUpstream(resp, ar, "corp")
+	loginURL, _ = resp.GetRedirectUrl()
+	u, _ = url.Parse(loginURL)
+	if resp, ar = callback("code=code-1&state="+url.QueryEscape(u.Query().Get("state")), nil); ar != nil || resp.ErrorId != E_INVALID_REQUEST {
+		t.Fatalf("Callback without the binding cookie should fail: %v", resp.Output)
+	}
+
+	// upstream errors are returned to the client
+	resp = server.NewResponse()
+	ar = server.HandleAuthorizeRequest(resp, req)
+	server.RedirectToUpstream(resp, ar, "corp")
+	loginURL, _ = resp.GetRedirectUrl()
+	u, _ = url.Parse(loginURL)
+	resp, ar = callback("error=access_denied&state="+url.QueryEscape(u.Query().Get("state")), (&http.Response{Header: resp.Headers}).Cookies())
+	if ar != nil || resp.ErrorId != E_ACCESS_DENIED || resp.URL != "http://localhost:14000/appauth" || resp.Output["state"] != "a" {
+		t.Fatalf("Unexpected upstream error response %v %s", resp.Output, resp.URL)
+	}
+}
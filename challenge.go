@@ -0,0 +1,37 @@
+package osin
+
+import (
+	"strings"
+)
+
+// SetChallenge sets the WWW-Authenticate header on the response, as described
+// in RFC 6750 section 3 for the Bearer scheme and RFC 7617 for the Basic one.
+// errorId is the error reported in the challenge, and should be empty when the
+// request had no authentication information.
+func (r *Response) SetChallenge(scheme string, realm string, errorId string) {
+	params := make([]string, 0, 3)
+	if realm != "" || scheme == "Basic" {
+		params = append(params, `realm="`+quoteChallenge(realm)+`"`)
+	}
+	if errorId != "" {
+		description := deferror.Get(errorId)
+		if errorId == r.ErrorId {
+			if d, ok := r.Output["error_description"].(string); ok {
+				description = d
+			}
+		}
+		params = append(params, `error="`+quoteChallenge(errorId)+`"`)
+		params = append(params, `error_description="`+quoteChallenge(description)+`"`)
+	}
+
+	challenge := scheme
+	if len(params) > 0 {
+		challenge += " " + strings.Join(params, ", ")
+	}
+	r.SetHeader("WWW-Authenticate", challenge)
+}
+
+// quoteChallenge escapes a quoted-string value of a challenge parameter
+func quoteChallenge(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v)
+}
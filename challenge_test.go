@@ -0,0 +1,136 @@
+package osin
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSetChallenge(t *testing.T) {
+	testcases := map[string]struct {
+		Scheme   string
+		Realm    string
+		ErrorId  string
+		Expected string
+	}{
+		"bearer without error": {
+			Scheme:   "Bearer",
+			Realm:    "example",
+			Expected: `Bearer realm="example"`,
+		},
+		"bearer without realm": {
+			Scheme:   "Bearer",
+			Expected: `Bearer`,
+		},
+		"bearer with error": {
+			Scheme:   "Bearer",
+			Realm:    `ex"ample`,
+			ErrorId:  E_INVALID_TOKEN,
+			Expected: `Bearer realm="ex\"ample", error="invalid_token", error_description="The access token provided is expired, revoked, malformed, or invalid for other reasons."`,
+		},
+		"basic": {
+			Scheme:   "Basic",
+			Expected: `Basic realm=""`,
+		},
+	}
+
+	for k, tc := range testcases {
+		w := NewResponse(NewTestingStorage())
+		w.SetChallenge(tc.Scheme, tc.Realm, tc.ErrorId)
+		if v := w.Headers.Get("WWW-Authenticate"); v != tc.Expected {
+			t.Errorf("%s: expected %s, got %s", k, tc.Expected, v)
+		}
+	}
+}
+
+func TestInfoChallenge(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.Realm = "example"
+	server := NewServer(sconfig, NewTestingStorage())
+
+	testcases := map[string]struct {
+		Authorization string
+		Expected      string
+	}{
+		"missing token": {
+			Expected: `Bearer realm="example"`,
+		},
+		"unknown token": {
+			Authorization: "Bearer unknown",
+			Expected:      `Bearer realm="example", error="invalid_token", error_description="The access token provided is expired, revoked, malformed, or invalid for other reasons."`,
+		},
+	}
+
+	for k, tc := range testcases {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("GET", "http://localhost:14000/info", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.Authorization != "" {
+			req.Header.Set("Authorization", tc.Authorization)
+		}
+
+		if ir := server.HandleInfoRequest(resp, req); ir != nil {
+			t.Fatalf("%s: request should fail", k)
+		}
+		if v := resp.Headers.Get("WWW-Authenticate"); v != tc.Expected {
+			t.Errorf("%s: expected %s, got %s", k, tc.Expected, v)
+		}
+	}
+}
+
+func TestClientAuthenticationChallenge(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+	sconfig.Realm = "example"
+	server := NewServer(sconfig, NewTestingStorage())
+	resp := server.NewResponse()
+
+	req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("1234", "wrong")
+	req.Form = make(map[string][]string)
+	req.Form.Set("grant_type", string(CLIENT_CREDENTIALS))
+	req.PostForm = req.Form
+
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		t.Fatalf("Request should fail")
+	}
+	if resp.ErrorId != E_INVALID_CLIENT {
+		t.Fatalf("Unexpected error: %s", resp.ErrorId)
+	}
+	if v := resp.Headers.Get("WWW-Authenticate"); v != `Basic realm="example"` {
+		t.Fatalf("Unexpected challenge: %s", v)
+	}
+}
+
+func TestClientAuthenticationChallengeInParams(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+	sconfig.AllowClientSecretInParams = true
+	server := NewServer(sconfig, NewTestingStorage())
+	server.ErrorStatusMapper = RFCErrorStatus
+	resp := server.NewResponse()
+
+	req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Form = make(map[string][]string)
+	req.Form.Set("grant_type", string(CLIENT_CREDENTIALS))
+	req.Form.Set("client_id", "1234")
+	req.Form.Set("client_secret", "wrong")
+	req.PostForm = req.Form
+
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		t.Fatalf("Request should fail")
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Unexpected status: %d", resp.StatusCode)
+	}
+	if v := resp.Headers.Get("WWW-Authenticate"); v != `Basic realm=""` {
+		t.Fatalf("Unexpected challenge: %s", v)
+	}
+}
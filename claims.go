@@ -0,0 +1,155 @@
+package osin
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Targets of the claims request parameter
+const (
+	CLAIMS_USERINFO = "userinfo"
+	CLAIMS_ID_TOKEN = "id_token"
+)
+
+// ScopeClaims are the claims requested by the OpenID Connect standard
+// scopes, as described in https://openid.net/specs/openid-connect-core-1_0.html#ScopeClaims
+var ScopeClaims = map[string][]string{
+	"profile": {"name", "family_name", "given_name", "middle_name", "nickname",
+		"preferred_username", "profile", "picture", "website", "gender",
+		"birthdate", "zoneinfo", "locale", "updated_at"},
+	"email":   {"email", "email_verified"},
+	"address": {"address"},
+	"phone":   {"phone_number", "phone_number_verified"},
+}
+
+// ClaimRequest is how an individual claim is requested. A nil ClaimRequest
+// requests the claim as voluntary, with no value restriction.
+type ClaimRequest struct {
+	// The claim is necessary for the client
+	Essential bool `json:"essential,omitempty"`
+
+	// The claim must have this value
+	Value interface{} `json:"value,omitempty"`
+
+	// The claim must have one of these values, in order of preference
+	Values []interface{} `json:"values,omitempty"`
+}
+
+// IsEssential returns true if the claim is requested as essential
+func (c *ClaimRequest) IsEssential() bool {
+	return c != nil && c.Essential
+}
+
+// Accepts returns true if the value meets the requested value or values
+func (c *ClaimRequest) Accepts(value interface{}) bool {
+	if c == nil {
+		return true
+	}
+	if c.Value != nil {
+		return claimValueEqual(c.Value, value)
+	}
+	if len(c.Values) > 0 {
+		for _, v := range c.Values {
+			if claimValueEqual(v, value) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// claimValueEqual compares the values through their JSON encoding, as the
+// requested ones are decoded from JSON
+func claimValueEqual(a, b interface{}) bool {
+	ja, erra := json.Marshal(a)
+	jb, errb := json.Marshal(b)
+	if erra != nil || errb != nil {
+		return reflect.DeepEqual(a, b)
+	}
+	return string(ja) == string(jb)
+}
+
+// ClaimsRequest is the OpenID Connect claims request parameter, as described
+// in https://openid.net/specs/openid-connect-core-1_0.html#ClaimsParameter
+type ClaimsRequest struct {
+	UserInfo map[string]*ClaimRequest `json:"userinfo,omitempty"`
+	IDToken  map[string]*ClaimRequest `json:"id_token,omitempty"`
+}
+
+// ParseClaimsRequest parses the JSON claims parameter
+func ParseClaimsRequest(s string) (*ClaimsRequest, error) {
+	var ret ClaimsRequest
+	if err := json.Unmarshal([]byte(s), &ret); err != nil {
+		return nil, err
+	}
+	for _, target := range []map[string]*ClaimRequest{ret.UserInfo, ret.IDToken} {
+		for name, c := range target {
+			if c != nil && c.Value != nil && len(c.Values) > 0 {
+				return nil, fmt.Errorf("claim %s has both value and values", name)
+			}
+		}
+	}
+	return &ret, nil
+}
+
+// Claims returns the claims requested for the target, CLAIMS_USERINFO or
+// CLAIMS_ID_TOKEN
+func (c *ClaimsRequest) Claims(target string) map[string]*ClaimRequest {
+	if c == nil {
+		return nil
+	}
+	switch target {
+	case CLAIMS_USERINFO:
+		return c.UserInfo
+	case CLAIMS_ID_TOKEN:
+		return c.IDToken
+	}
+	return nil
+}
+
+// Names returns the sorted names of the claims to release to the target:
+// the ones requested individually and, for CLAIMS_USERINFO, the ones of the
+// standard scopes in scope
+func (c *ClaimsRequest) Names(target string, scope string) []string {
+	set := make(map[string]bool)
+	if target == CLAIMS_USERINFO {
+		for s, names := range ScopeClaims {
+			if HasScope(scope, s) {
+				for _, name := range names {
+					set[name] = true
+				}
+			}
+		}
+	}
+	for name := range c.Claims(target) {
+		set[name] = true
+	}
+
+	ret := make([]string, 0, len(set))
+	for name := range set {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Filter keeps the claims of the target Names, dropping the ones not
+// accepted by their requested value. Returns the essential claims missing
+// from the result, for the application to decide whether to proceed.
+func (c *ClaimsRequest) Filter(target string, scope string, claims map[string]interface{}) (map[string]interface{}, []string) {
+	requested := c.Claims(target)
+	ret := make(map[string]interface{})
+	var missing []string
+	for _, name := range c.Names(target, scope) {
+		v, ok := claims[name]
+		if ok && requested[name].Accepts(v) {
+			ret[name] = v
+		} else if requested[name].IsEssential() {
+			missing = append(missing, name)
+		}
+	}
+	return ret, missing
+}
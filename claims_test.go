@@ -0,0 +1,118 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestClaimsRequestFilter(t *testing.T) {
+	cr, err := ParseClaimsRequest(`{
+		"userinfo": {"email": {"essential": true}, "nickname": null},
+		"id_token": {"acr": {"values": ["gold", "silver"]}, "phone_number_verified": {"essential": true, "value": true}}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := map[string]interface{}{
+		"email":                 "user@example.com",
+		"email_verified":        true,
+		"acr":                   "bronze",
+		"phone_number_verified": true,
+		"address":               "somewhere",
+	}
+
+	userinfo, missing := cr.Filter(CLAIMS_USERINFO, "openid email", claims)
+	if !reflect.DeepEqual(userinfo, map[string]interface{}{"email": "user@example.com", "email_verified": true}) || len(missing) != 0 {
+		t.Errorf("Unexpected userinfo claims: %v, missing %v", userinfo, missing)
+	}
+
+	idtoken, missing := cr.Filter(CLAIMS_ID_TOKEN, "openid email", claims)
+	if !reflect.DeepEqual(idtoken, map[string]interface{}{"phone_number_verified": true}) || len(missing) != 0 {
+		t.Errorf("Unexpected id token claims: %v, missing %v", idtoken, missing)
+	}
+
+	// an essential claim without the requested value is missing
+	claims["phone_number_verified"] = false
+	if _, missing = cr.Filter(CLAIMS_ID_TOKEN, "", claims); !reflect.DeepEqual(missing, []string{"phone_number_verified"}) {
+		t.Errorf("Unexpected missing claims: %v", missing)
+	}
+
+	if _, err = ParseClaimsRequest(`{"id_token": {"acr": {"value": "a", "values": ["b"]}}}`); err == nil {
+		t.Error("Claim with both value and values must fail")
+	}
+}
+
+func TestAuthorizeClaimsRequest(t *testing.T) {
+	testcases := map[string]struct {
+		Claims        string
+		ExpectedError string
+	}{
+		"valid": {
+			Claims: `{"id_token":{"email_verified":{"essential":true}}}`,
+		},
+		"malformed": {
+			Claims:        `{"id_token":`,
+			ExpectedError: E_INVALID_REQUEST,
+		},
+	}
+
+	for k, tc := range testcases {
+		storage := NewTestingStorage()
+		server := NewServer(NewServerConfig(), storage)
+		server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+		server.AccessTokenGen = &TestingAccessTokenGen{}
+		resp := server.NewResponse()
+
+		req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Form = make(url.Values)
+		req.Form.Set("response_type", string(CODE))
+		req.Form.Set("client_id", "1234")
+		req.Form.Set("scope", "openid")
+		req.Form.Set("claims", tc.Claims)
+
+		if ar := server.HandleAuthorizeRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAuthorizeRequest(resp, req, ar)
+		}
+
+		if tc.ExpectedError != "" {
+			if resp.ErrorId != tc.ExpectedError {
+				t.Errorf("%s: expected error %s, got %v", k, tc.ExpectedError, resp.Output)
+			}
+			continue
+		}
+		if resp.IsError {
+			t.Errorf("%s: unexpected error: %v", k, resp.Output)
+			continue
+		}
+
+		// the requested claims follow the code into the access data
+		resp = server.NewResponse()
+		req, err = http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = make(url.Values)
+		req.Form.Set("grant_type", string(AUTHORIZATION_CODE))
+		req.Form.Set("code", "1")
+		req.PostForm = make(url.Values)
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if resp.IsError {
+			t.Errorf("%s: unexpected access error: %v", k, resp.Output)
+			continue
+		}
+		if cr := storage.access["1"].ClaimsRequest; cr == nil || !cr.Claims(CLAIMS_ID_TOKEN)["email_verified"].IsEssential() {
+			t.Errorf("%s: claims request not saved: %+v", k, cr)
+		}
+	}
+}
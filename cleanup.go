@@ -0,0 +1,108 @@
+package osin
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ExpiringStorage is an optional interface storages can implement to purge
+// expired grants
+type ExpiringStorage interface {
+	// PurgeExpired removes the authorize codes, access tokens and refresh
+	// tokens expired at 'now', returning how many of each were removed
+	PurgeExpired(now time.Time) (PurgeResult, error)
+}
+
+// PurgeResult holds the number of grants purged by ExpiringStorage
+type PurgeResult struct {
+	Authorize int
+	Access    int
+	Refresh   int
+}
+
+// cleanupWorker holds the background cleanup state
+type cleanupWorker struct {
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+// PurgeExpired removes the expired grants from the server storage, which
+// must implement ExpiringStorage. Emits EVENT_GRANTS_PURGED with the counts.
+func (s *Server) PurgeExpired() (PurgeResult, error) {
+	storage := s.Storage.Clone()
+	defer storage.Close()
+
+	es, ok := storageAs[ExpiringStorage](storage)
+	if !ok {
+		return PurgeResult{}, errors.New("storage does not implement ExpiringStorage")
+	}
+	res, err := es.PurgeExpired(s.Now())
+	if err != nil {
+		return res, err
+	}
+	s.emitEvent(&Event{
+		Type: EVENT_GRANTS_PURGED,
+		Data: map[string]interface{}{
+			"authorize": res.Authorize,
+			"access":    res.Access,
+			"refresh":   res.Refresh,
+		},
+	})
+	return res, nil
+}
+
+// StartCleanup starts a background worker purging the expired grants every
+// interval, until StopCleanup is called. The storage must implement
+// ExpiringStorage. Purge errors are emitted as EVENT_GRANTS_PURGE_FAILED.
+func (s *Server) StartCleanup(interval time.Duration) error {
+	if _, ok := storageAs[ExpiringStorage](s.Storage); !ok {
+		return errors.New("storage does not implement ExpiringStorage")
+	}
+	if interval <= 0 {
+		return errors.New("cleanup interval must be positive")
+	}
+
+	s.cleanup.mu.Lock()
+	defer s.cleanup.mu.Unlock()
+	if s.cleanup.stop != nil {
+		return errors.New("cleanup already started")
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	s.cleanup.stop, s.cleanup.done = stop, done
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if _, err := s.PurgeExpired(); err != nil {
+					s.emitEvent(&Event{
+						Type: EVENT_GRANTS_PURGE_FAILED,
+						Data: map[string]interface{}{"error": err},
+					})
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// StopCleanup stops the background worker started by StartCleanup, waiting
+// for a running purge to finish
+func (s *Server) StopCleanup() {
+	s.cleanup.mu.Lock()
+	stop, done := s.cleanup.stop, s.cleanup.done
+	s.cleanup.stop, s.cleanup.done = nil, nil
+	s.cleanup.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
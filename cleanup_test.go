@@ -0,0 +1,100 @@
+package osin
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// expiringStorage is a TestingStorage purging its expired grants
+type expiringStorage struct {
+	*TestingStorage
+	mu sync.Mutex
+}
+
+func (s *expiringStorage) Clone() Storage {
+	return s
+}
+
+func (s *expiringStorage) PurgeExpired(now time.Time) (PurgeResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res PurgeResult
+	for k, d := range s.authorize {
+		if d.IsExpiredAt(now) {
+			delete(s.authorize, k)
+			res.Authorize++
+		}
+	}
+	for k, d := range s.access {
+		if d.IsExpiredAt(now) {
+			delete(s.access, k)
+			res.Access++
+		}
+	}
+	for k, token := range s.refresh {
+		if _, ok := s.access[token]; !ok {
+			delete(s.refresh, k)
+			res.Refresh++
+		}
+	}
+	return res, nil
+}
+
+func TestPurgeExpired(t *testing.T) {
+	storage := &expiringStorage{TestingStorage: NewTestingStorage()}
+	server := NewServer(NewServerConfig(), storage)
+	server.Now = func() time.Time { return time.Now().Add(48 * time.Hour) }
+
+	var events []*Event
+	server.AddEventListener(EventListenerFunc(func(e *Event) {
+		events = append(events, e)
+	}))
+
+	res, err := server.PurgeExpired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Authorize != 1 || res.Access != len(NewTestingStorage().access) || res.Refresh != 1 {
+		t.Fatalf("Unexpected purge result: %+v", res)
+	}
+	if len(events) != 1 || events[0].Type != EVENT_GRANTS_PURGED || events[0].Data["access"] != res.Access {
+		t.Fatalf("Unexpected events: %v", events)
+	}
+
+	if _, err := NewServer(NewServerConfig(), NewTestingStorage()).PurgeExpired(); err == nil {
+		t.Fatalf("Storage without purge support should fail")
+	}
+}
+
+func TestStartCleanup(t *testing.T) {
+	storage := &expiringStorage{TestingStorage: NewTestingStorage()}
+	server := NewServer(NewServerConfig(), storage)
+	server.Now = func() time.Time { return time.Now().Add(48 * time.Hour) }
+
+	purged := make(chan struct{}, 10)
+	server.AddEventListener(EventListenerFunc(func(e *Event) {
+		if e.Type == EVENT_GRANTS_PURGED {
+			purged <- struct{}{}
+		}
+	}))
+
+	if err := server.StartCleanup(time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.StartCleanup(time.Millisecond); err == nil {
+		t.Fatalf("Starting the cleanup twice should fail")
+	}
+	select {
+	case <-purged:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Cleanup didn't run")
+	}
+	server.StopCleanup()
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if len(storage.access) != 0 {
+		t.Fatalf("Expired access data should be purged")
+	}
+}
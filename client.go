@@ -1,8 +1,10 @@
 package osin
 
 import (
-	"github.com/AccelByte/go-jose/jwt"
 	"strings"
+	"time"
+
+	"github.com/AccelByte/go-jose/jwt"
 )
 
 // Client information
@@ -28,6 +30,28 @@ type ClientSecretMatcher interface {
 	ClientSecretMatches(secret string) bool
 }
 
+// ClientSecret is one of the secrets of a client
+type ClientSecret struct {
+	// Name of the secret, like "current" or "previous", reported in events
+	Name string
+
+	// Secret value
+	Secret string
+
+	// Expiration of the secret. Zero if it never expires.
+	ExpiresAt time.Time
+}
+
+// ClientSecretList is an optional interface clients can implement to have
+// more than one active secret, allowing secret rotation without a breaking
+// cutover. If a Client implements ClientSecretList, the framework will never
+// call GetSecret nor ClientSecretMatches. A client without secrets is a
+// public client.
+type ClientSecretList interface {
+	// GetSecrets returns the client secrets, current first
+	GetSecrets() []ClientSecret
+}
+
 type ClientIDMatcher interface {
 	// ClientIDMatches returns true if the given ID matches
 	ClientIDMatches(id string) bool
@@ -39,6 +63,9 @@ type DefaultClient struct {
 	Secret      string
 	RedirectUri string
 	UserData    interface{}
+
+	// Redirect uris with their types, used instead of RedirectUri if set
+	RedirectUris []RedirectURI
 }
 
 func (d *DefaultClient) GetID() string {
@@ -62,6 +89,31 @@ func (d *DefaultClient) ClientSecretMatches(secret string) bool {
 	return d.Secret == secret
 }
 
+// RotatingClient is a DefaultClient with a previous secret still accepted
+// until PreviousSecretExpiresAt, during a secret rotation
+type RotatingClient struct {
+	DefaultClient
+	PreviousSecret          string
+	PreviousSecretExpiresAt time.Time
+}
+
+// GetSecrets implement the ClientSecretList interface
+func (d *RotatingClient) GetSecrets() []ClientSecret {
+	ret := []ClientSecret{{Name: "current", Secret: d.Secret}}
+	if d.PreviousSecret != "" {
+		ret = append(ret, ClientSecret{Name: "previous", Secret: d.PreviousSecret, ExpiresAt: d.PreviousSecretExpiresAt})
+	}
+	return ret
+}
+
+// Rotate makes the current secret the previous one, valid until expiresAt,
+// and sets the new current secret
+func (d *RotatingClient) Rotate(secret string, expiresAt time.Time) {
+	d.PreviousSecret = d.Secret
+	d.PreviousSecretExpiresAt = expiresAt
+	d.Secret = secret
+}
+
 // ComboClient implements osin.Client interface
 // This type of client is intended to handle multiple audience
 // in the token
@@ -112,9 +164,18 @@ func (client *ComboClient) ClientIDMatches(id string) bool {
 	return client.Audience.Contains(id)
 }
 
+// GetRedirectURIs satisfies the ClientRedirectURIList interface
+func (d *DefaultClient) GetRedirectURIs() []RedirectURI {
+	return d.RedirectUris
+}
+
 func (d *DefaultClient) CopyFrom(client Client) {
 	d.Id = client.GetID()
 	d.Secret = client.GetSecret()
 	d.RedirectUri = client.GetRedirectURI()
 	d.UserData = client.GetUserData()
+	d.RedirectUris = nil
+	if l, ok := client.(ClientRedirectURIList); ok {
+		d.RedirectUris = l.GetRedirectURIs()
+	}
 }
@@ -1,7 +1,10 @@
 package osin
 
 import (
+	"net/http"
+	"net/url"
 	"testing"
+	"time"
 )
 
 func TestClientIntfUserData(t *testing.T) {
@@ -16,3 +19,94 @@ func TestClientIntfUserData(t *testing.T) {
 		t.Error("Returned interface is not a reference")
 	}
 }
+
+func TestRotatingClientSecret(t *testing.T) {
+	now := time.Now()
+	c := &RotatingClient{
+		DefaultClient: DefaultClient{
+			Id:     "rotating",
+			Secret: "old",
+		},
+	}
+	c.Rotate("new", now.Add(time.Hour))
+
+	if name, ok := MatchClientSecret(c, "new", now); !ok || name != "current" {
+		t.Errorf("Current secret should match, got %s %v", name, ok)
+	}
+	if name, ok := MatchClientSecret(c, "old", now); !ok || name != "previous" {
+		t.Errorf("Previous secret should match during the rotation window, got %s %v", name, ok)
+	}
+	if _, ok := MatchClientSecret(c, "old", now.Add(2*time.Hour)); ok {
+		t.Errorf("Previous secret should not match after it expires")
+	}
+	if _, ok := MatchClientSecret(c, "other", now); ok {
+		t.Errorf("Unknown secret should not match")
+	}
+}
+
+// secretListClient is a client with a list of secrets
+type secretListClient struct {
+	DefaultClient
+	secrets []ClientSecret
+}
+
+func (c *secretListClient) GetSecrets() []ClientSecret { return c.secrets }
+
+func TestClientSecretListPublic(t *testing.T) {
+	c := &secretListClient{DefaultClient: DefaultClient{Id: "public", Secret: "ignored"}}
+	if _, ok := MatchClientSecret(c, "", time.Now()); !ok {
+		t.Errorf("Client without secrets should be a public client")
+	}
+	if _, ok := MatchClientSecret(c, "ignored", time.Now()); ok {
+		t.Errorf("Client without secrets should not match a secret")
+	}
+}
+
+func TestAccessRotatingClientSecretEvent(t *testing.T) {
+	storage := NewTestingStorage()
+	client := &RotatingClient{
+		DefaultClient: DefaultClient{
+			Id:          "rotating",
+			Secret:      "old",
+			RedirectUri: "http://localhost:14000/appauth",
+		},
+	}
+	client.Rotate("new", time.Now().Add(time.Hour))
+	storage.clients["rotating"] = client
+
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+	server := NewServer(sconfig, storage)
+	var events []*Event
+	server.AddEventListener(EventListenerFunc(func(e *Event) {
+		events = append(events, e)
+	}))
+	resp := server.NewResponse()
+
+	req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("rotating", "old")
+	req.Form = make(url.Values)
+	req.Form.Set("grant_type", string(CLIENT_CREDENTIALS))
+	req.PostForm = make(url.Values)
+
+	if ar := server.HandleAccessRequest(resp, req); ar == nil {
+		t.Fatalf("Previous secret should be accepted: %v", resp.Output)
+	}
+
+	if len(events) != 1 || events[0].Type != EVENT_CLIENT_AUTHENTICATED {
+		t.Fatalf("Expected client authenticated event, got %v", events)
+	}
+	if d := events[0].Data["secret"]; d != "previous" {
+		t.Fatalf("Expected previous secret to be reported, got %v", d)
+	}
+
+	// the secrets expire at the server time
+	server.Now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	resp = server.NewResponse()
+	if ar := server.HandleAccessRequest(resp, req); ar != nil || resp.ErrorId != E_INVALID_CLIENT {
+		t.Fatalf("Previous secret should be expired at the server time: %v", resp.Output)
+	}
+}
@@ -0,0 +1,262 @@
+// Package clients loads static osin clients from a YAML or JSON file, for
+// small deployments without a client database. The file can be reloaded
+// while serving, on SIGHUP or when it changes.
+//
+//	clients:
+//	  - id: webapp
+//	    secret_hash: "$2a$10$..."     # bcrypt, or sha256:<hex>
+//	    redirect_uris: [https://app.example.com/callback]
+//	    scopes: [profile, orders]     # scopes the client may request
+//	    default_scope: profile        # granted when none is requested
+//	    grant_types: [authorization_code, refresh_token]
+//	  - id: spa                       # no secret_hash: public client
+//	    redirect_uris: [https://spa.example.com/]
+//	    grant_types: [authorization_code]
+//
+// The grant type of the implicit flow is "implicit". Clients without
+// grant_types may use all the grant types allowed by the server.
+package clients
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/RangelReale/osin"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// Prefix of the sha256 secret hashes, followed by the hex digest
+const SHA256_PREFIX = "sha256:"
+
+// ClientConfig is the definition of a client in the file
+type ClientConfig struct {
+	Id           string   `json:"id" yaml:"id"`
+	SecretHash   string   `json:"secret_hash" yaml:"secret_hash"`
+	RedirectURIs []string `json:"redirect_uris" yaml:"redirect_uris"`
+	Scopes       []string `json:"scopes" yaml:"scopes"`
+	DefaultScope string   `json:"default_scope" yaml:"default_scope"`
+	GrantTypes   []string `json:"grant_types" yaml:"grant_types"`
+}
+
+// File is the content of a clients file
+type File struct {
+	Clients []ClientConfig `json:"clients" yaml:"clients"`
+}
+
+// Client is a client loaded from a file. It implements osin.Client,
+// osin.ClientSecretMatcher, osin.ClientRedirectURIList,
+// osin.ClientScopeLimits and osin.ClientGrantTypes.
+type Client struct {
+	config     ClientConfig
+	grantTypes []osin.AccessRequestType
+}
+
+// newClient validates the client definition
+func newClient(c ClientConfig) (*Client, error) {
+	if c.Id == "" {
+		return nil, errors.New("client without id")
+	}
+	if len(c.RedirectURIs) == 0 {
+		return nil, fmt.Errorf("client %s: no redirect_uris", c.Id)
+	}
+	if c.SecretHash != "" && !strings.HasPrefix(c.SecretHash, SHA256_PREFIX) {
+		if _, err := bcrypt.Cost([]byte(c.SecretHash)); err != nil {
+			return nil, fmt.Errorf("client %s: secret_hash is neither bcrypt nor %s<hex>", c.Id, SHA256_PREFIX)
+		}
+	}
+	if c.DefaultScope != "" && len(c.Scopes) > 0 {
+		for _, sc := range strings.Fields(c.DefaultScope) {
+			if !stringInList(sc, c.Scopes) {
+				return nil, fmt.Errorf("client %s: default_scope %s not in scopes", c.Id, sc)
+			}
+		}
+	}
+	ret := &Client{config: c}
+	for _, gt := range c.GrantTypes {
+		if gt == "implicit" {
+			ret.grantTypes = append(ret.grantTypes, osin.IMPLICIT)
+		} else {
+			ret.grantTypes = append(ret.grantTypes, osin.AccessRequestType(gt))
+		}
+	}
+	return ret, nil
+}
+
+func stringInList(s string, list []string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// GetID satisfies osin.Client
+func (c *Client) GetID() string {
+	return c.config.Id
+}
+
+// GetSecret satisfies osin.Client. Always empty, the secret is only
+// checked by ClientSecretMatches.
+func (c *Client) GetSecret() string {
+	return ""
+}
+
+// GetRedirectURI satisfies osin.Client, returning the first redirect uri
+func (c *Client) GetRedirectURI() string {
+	return c.config.RedirectURIs[0]
+}
+
+// GetUserData satisfies osin.Client
+func (c *Client) GetUserData() interface{} {
+	return nil
+}
+
+// ClientSecretMatches satisfies osin.ClientSecretMatcher. Public clients,
+// without secret_hash, only match an empty secret.
+func (c *Client) ClientSecretMatches(secret string) bool {
+	hash := c.config.SecretHash
+	switch {
+	case hash == "":
+		return secret == ""
+	case strings.HasPrefix(hash, SHA256_PREFIX):
+		sum := sha256.Sum256([]byte(secret))
+		return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(hash[len(SHA256_PREFIX):]))) == 1
+	default:
+		return secret != "" && bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret)) == nil
+	}
+}
+
+// GetRedirectURIs satisfies osin.ClientRedirectURIList
+func (c *Client) GetRedirectURIs() []osin.RedirectURI {
+	ret := make([]osin.RedirectURI, len(c.config.RedirectURIs))
+	for i, uri := range c.config.RedirectURIs {
+		ret[i] = osin.RedirectURI{URI: uri}
+	}
+	return ret
+}
+
+// GetDefaultScope satisfies osin.ClientScopeLimits
+func (c *Client) GetDefaultScope() string {
+	return c.config.DefaultScope
+}
+
+// GetMaxScope satisfies osin.ClientScopeLimits
+func (c *Client) GetMaxScope() string {
+	return strings.Join(c.config.Scopes, " ")
+}
+
+// GetGrantTypes satisfies osin.ClientGrantTypes
+func (c *Client) GetGrantTypes() []osin.AccessRequestType {
+	return c.grantTypes
+}
+
+// Store holds the clients of a file. It is safe for concurrent use.
+type Store struct {
+	path string
+
+	mu      sync.RWMutex
+	clients map[string]*Client
+}
+
+// FromFile loads the clients of a file, parsed as JSON if its extension
+// is .json, or else as YAML
+func FromFile(path string) (*Store, error) {
+	s := &Store{path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Path returns the path of the clients file
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Reload reads the file again. On error the loaded clients are kept.
+func (s *Store) Reload() error {
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	clients, err := parse(b, strings.EqualFold(filepath.Ext(s.path), ".json"))
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+	s.mu.Lock()
+	s.clients = clients
+	s.mu.Unlock()
+	return nil
+}
+
+// parse decodes and validates the clients of a file, failing on unknown
+// fields
+func parse(b []byte, isJSON bool) (map[string]*Client, error) {
+	var f File
+	if isJSON {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&f); err != nil {
+			return nil, err
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err := dec.Decode(&f); err != nil {
+			return nil, err
+		}
+	}
+	ret := make(map[string]*Client, len(f.Clients))
+	for _, cc := range f.Clients {
+		c, err := newClient(cc)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := ret[c.GetID()]; ok {
+			return nil, fmt.Errorf("duplicate client %s", c.GetID())
+		}
+		ret[c.GetID()] = c
+	}
+	return ret, nil
+}
+
+// GetClient returns a client, osin.ErrNotFound if not in the file
+func (s *Store) GetClient(id string) (osin.Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if c, ok := s.clients[id]; ok {
+		return c, nil
+	}
+	return nil, osin.ErrNotFound
+}
+
+// Storage returns an osin.Storage getting the clients from the store, and
+// the grants from inner
+func (s *Store) Storage(inner osin.Storage) osin.Storage {
+	return &storage{Storage: inner, store: s}
+}
+
+// storage is an osin.Storage with the clients of a Store
+type storage struct {
+	osin.Storage
+	store *Store
+}
+
+func (s *storage) Clone() osin.Storage {
+	return &storage{Storage: s.Storage.Clone(), store: s.store}
+}
+
+func (s *storage) GetClient(id string) (osin.Client, error) {
+	return s.store.GetClient(id)
+}
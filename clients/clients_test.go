@@ -0,0 +1,202 @@
+package clients
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/RangelReale/osin/osintest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func sha256Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return SHA256_PREFIX + hex.EncodeToString(sum[:])
+}
+
+func writeFile(t *testing.T, path, content string) {
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFromFile(t *testing.T) {
+	bhash, err := bcrypt.GenerateFromPassword([]byte("bsecret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "clients.yaml")
+	writeFile(t, yamlPath, `
+clients:
+  - id: web
+    secret_hash: "`+string(bhash)+`"
+    redirect_uris: [http://localhost/cb, http://localhost/cb2]
+    scopes: [read, write]
+    default_scope: read
+    grant_types: [authorization_code, implicit]
+  - id: spa
+    redirect_uris: [http://localhost/spa]
+`)
+	jsonPath := filepath.Join(dir, "clients.json")
+	writeFile(t, jsonPath, `{"clients": [{"id": "svc", "secret_hash": "`+sha256Hash("ssecret")+`", "redirect_uris": ["http://localhost/svc"]}]}`)
+
+	ys, err := FromFile(yamlPath)
+	if err != nil {
+		t.Fatalf("Error loading yaml: %s", err)
+	}
+	js, err := FromFile(jsonPath)
+	if err != nil {
+		t.Fatalf("Error loading json: %s", err)
+	}
+
+	web, err := ys.GetClient("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if web.GetRedirectURI() != "http://localhost/cb" || len(osin.ClientRedirectURIs(web, "")) != 2 {
+		t.Fatalf("Unexpected redirect uris: %v", osin.ClientRedirectURIs(web, ""))
+	}
+	if limits := web.(osin.ClientScopeLimits); limits.GetMaxScope() != "read write" || limits.GetDefaultScope() != "read" {
+		t.Fatalf("Unexpected scopes %q %q", limits.GetMaxScope(), limits.GetDefaultScope())
+	}
+	if !osin.ClientAllowsGrantType(web, osin.IMPLICIT) || osin.ClientAllowsGrantType(web, osin.CLIENT_CREDENTIALS) {
+		t.Fatalf("Unexpected grant types %v", web.(osin.ClientGrantTypes).GetGrantTypes())
+	}
+
+	spa, _ := ys.GetClient("spa")
+	svc, _ := js.GetClient("svc")
+	secrets := map[string]struct {
+		client  osin.Client
+		secret  string
+		matches bool
+	}{
+		"bcrypt":             {web, "bsecret", true},
+		"bcrypt wrong":       {web, "other", false},
+		"bcrypt empty":       {web, "", false},
+		"sha256":             {svc, "ssecret", true},
+		"sha256 wrong":       {svc, "other", false},
+		"public empty":       {spa, "", true},
+		"public with secret": {spa, "bsecret", false},
+	}
+	for name, test := range secrets {
+		if osin.CheckClientSecret(test.client, test.secret) != test.matches {
+			t.Errorf("%s: expected match %v", name, test.matches)
+		}
+	}
+
+	if _, err = ys.GetClient("svc"); err != osin.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestFromFileInvalid(t *testing.T) {
+	dir := t.TempDir()
+	tests := map[string]string{
+		"no id":           "clients:\n  - redirect_uris: [http://localhost/cb]\n",
+		"no redirect":     "clients:\n  - id: web\n",
+		"duplicate":       "clients:\n  - id: web\n    redirect_uris: [http://localhost/cb]\n  - id: web\n    redirect_uris: [http://localhost/cb]\n",
+		"plain secret":    "clients:\n  - id: web\n    secret_hash: secret\n    redirect_uris: [http://localhost/cb]\n",
+		"unknown field":   "clients:\n  - id: web\n    secret: secret\n    redirect_uris: [http://localhost/cb]\n",
+		"default scope":   "clients:\n  - id: web\n    redirect_uris: [http://localhost/cb]\n    scopes: [read]\n    default_scope: write\n",
+		"malformed input": "clients: [",
+	}
+	for name, content := range tests {
+		path := filepath.Join(dir, strings.ReplaceAll(name, " ", "_")+".yaml")
+		writeFile(t, path, content)
+		if _, err := FromFile(path); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.yaml")
+	writeFile(t, path, "clients:\n  - id: a\n    redirect_uris: [http://localhost/cb]\n")
+	s, err := FromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, path, "clients: [")
+	if err = s.Reload(); err == nil {
+		t.Fatal("Expected reload error")
+	}
+	if _, err = s.GetClient("a"); err != nil {
+		t.Fatalf("Clients lost on failed reload: %s", err)
+	}
+
+	errs := make(chan error, 10)
+	stop, err := s.Watch(func(err error) { errs <- err })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	writeFile(t, path, "clients:\n  - id: b\n    redirect_uris: [http://localhost/cb]\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err = s.GetClient("b"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("File change not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err = s.GetClient("a"); err != osin.ErrNotFound {
+		t.Fatalf("Removed client still loaded: %v", err)
+	}
+}
+
+func TestStorageGrantTypes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.yaml")
+	writeFile(t, path, `
+clients:
+  - id: svc
+    secret_hash: "`+sha256Hash("ssecret")+`"
+    redirect_uris: [http://localhost/svc]
+    grant_types: [client_credentials]
+  - id: web
+    secret_hash: "`+sha256Hash("wsecret")+`"
+    redirect_uris: [http://localhost/web]
+    grant_types: [authorization_code]
+`)
+	s, err := FromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := osin.NewServerConfig()
+	config.AllowedAccessTypes = osin.AllowedAccessType{osin.AUTHORIZATION_CODE, osin.CLIENT_CREDENTIALS}
+	server := osin.NewServer(config, s.Storage(osintest.NewStorage()))
+
+	tests := map[string]struct {
+		id, secret string
+		errorId    string
+	}{
+		"allowed":     {"svc", "ssecret", ""},
+		"not allowed": {"web", "wsecret", osin.E_UNAUTHORIZED_CLIENT},
+		"bad secret":  {"svc", "wsecret", osin.E_INVALID_CLIENT},
+	}
+	for name, test := range tests {
+		req, _ := http.NewRequest("POST", "http://localhost:14000/token", strings.NewReader(url.Values{"grant_type": {"client_credentials"}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(test.id, test.secret)
+
+		resp := server.NewResponse()
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if resp.ErrorId != test.errorId {
+			t.Errorf("%s: expected error %q, got %q", name, test.errorId, resp.ErrorId)
+		}
+		resp.Close()
+	}
+}
@@ -0,0 +1,83 @@
+package clients
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ReloadOnSignal reloads the file on each of the signals, SIGHUP if none,
+// until the returned function is called. Reload errors, after which the
+// previous clients are kept, are passed to onError if not nil.
+func (s *Store) ReloadOnSignal(onError func(error), sig ...os.Signal) (stop func()) {
+	if len(sig) == 0 {
+		sig = []os.Signal{syscall.SIGHUP}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sig...)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ch:
+				s.reload(onError)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
+
+// Watch reloads the file when it changes, until the returned function is
+// called. The directory of the file is watched, so files replaced by a
+// rename are reloaded too. Reload errors are passed to onError if not nil.
+func (s *Store) Watch(onError func(error)) (stop func(), err error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err = w.Add(filepath.Dir(s.path)); err != nil {
+		w.Close()
+		return nil, err
+	}
+	name := filepath.Clean(s.path)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(ev.Name) == name && ev.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+					s.reload(onError)
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				if onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+	return func() {
+		w.Close()
+		<-done
+	}, nil
+}
+
+func (s *Store) reload(onError func(error)) {
+	if err := s.Reload(); err != nil && onError != nil {
+		onError(err)
+	}
+}
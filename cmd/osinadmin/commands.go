@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/RangelReale/osin/osintest"
+)
+
+var (
+	errUsage            = errors.New("usage")
+	errClientsReadOnly  = errors.New("the storage can't save clients")
+	errGrantsNotListed  = errors.New("the storage can't list the grants of a client")
+	errPurgeUnsupported = errors.New("the storage doesn't purge expired grants, they expire by themselves")
+)
+
+const usage = `usage: osinadmin [backend flags] <command> [flags]
+
+commands:
+  client create -redirect uri [-id id] [-secret secret]
+  client rotate -id id [-secret secret]
+  client remove -id id
+  token mint -client id [-scope scope] [-expires seconds] [-refresh]
+  grants list -client id
+  grants revoke (-token token | -client id)
+  purge
+`
+
+// clientStore is implemented by the storages able to save clients, like
+// the etcd and DynamoDB storages
+type clientStore interface {
+	SetClient(ctx context.Context, client osin.Client) error
+	RemoveClient(ctx context.Context, id string) error
+}
+
+// grantLister is implemented by the storages able to list the access
+// tokens of a client, like the DynamoDB storage
+type grantLister interface {
+	AccessTokensByClient(ctx context.Context, clientId string) ([]string, error)
+}
+
+// admin runs the commands against a storage, writing their results to out
+type admin struct {
+	storage osin.Storage
+	out     io.Writer
+
+	// Returns the current time - default time.Now
+	now func() time.Time
+}
+
+func (a *admin) currentTime() time.Time {
+	if a.now != nil {
+		return a.now()
+	}
+	return time.Now()
+}
+
+// run runs the command in args
+func (a *admin) run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	cmd, args := args[0], args[1:]
+	if cmd == "purge" {
+		return a.purge(args)
+	}
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch cmd + " " + args[0] {
+	case "client create":
+		return a.clientCreate(ctx, args[1:])
+	case "client rotate":
+		return a.clientRotate(ctx, args[1:])
+	case "client remove":
+		return a.clientRemove(ctx, args[1:])
+	case "token mint":
+		return a.tokenMint(args[1:])
+	case "grants list":
+		return a.grantsList(ctx, args[1:])
+	case "grants revoke":
+		return a.grantsRevoke(ctx, args[1:])
+	}
+	return errUsage
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// parse parses the flags, failing if any of the required ones is empty
+func parse(fs *flag.FlagSet, args []string, required ...string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	for _, name := range required {
+		if fs.Lookup(name).Value.String() == "" {
+			return fmt.Errorf("%s: -%s is required", fs.Name(), name)
+		}
+	}
+	return nil
+}
+
+func (a *admin) clientStore() (clientStore, error) {
+	cs, ok := a.storage.(clientStore)
+	if !ok {
+		return nil, errClientsReadOnly
+	}
+	return cs, nil
+}
+
+func newSecret() (string, error) {
+	return osin.TokenFormat{Length: 32}.Generate()
+}
+
+func (a *admin) clientCreate(ctx context.Context, args []string) error {
+	fs := newFlagSet("client create")
+	id := fs.String("id", "", "client id, generated if empty")
+	secret := fs.String("secret", "", "client secret, generated if empty")
+	redirect := fs.String("redirect", "", "redirect uri, many separated by the redirect uri separator")
+	if err := parse(fs, args, "redirect"); err != nil {
+		return err
+	}
+	cs, err := a.clientStore()
+	if err != nil {
+		return err
+	}
+	if *id == "" {
+		if *id, err = (osin.TokenFormat{}).Generate(); err != nil {
+			return err
+		}
+	}
+	if *secret == "" {
+		if *secret, err = newSecret(); err != nil {
+			return err
+		}
+	}
+	if _, err = a.storage.GetClient(*id); err == nil {
+		return fmt.Errorf("client %q already exists", *id)
+	} else if err != osin.ErrNotFound {
+		return err
+	}
+	client := &osin.DefaultClient{Id: *id, Secret: *secret, RedirectUri: *redirect}
+	if err = cs.SetClient(ctx, client); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "id\t%s\nsecret\t%s\n", client.Id, client.Secret)
+	return nil
+}
+
+// clientRotate replaces the secret of a client. The storages save clients
+// as osin.DefaultClient, so the previous secret stops working at once.
+func (a *admin) clientRotate(ctx context.Context, args []string) error {
+	fs := newFlagSet("client rotate")
+	id := fs.String("id", "", "client id")
+	secret := fs.String("secret", "", "new client secret, generated if empty")
+	if err := parse(fs, args, "id"); err != nil {
+		return err
+	}
+	cs, err := a.clientStore()
+	if err != nil {
+		return err
+	}
+	current, err := a.storage.GetClient(*id)
+	if err != nil {
+		return err
+	}
+	if *secret == "" {
+		if *secret, err = newSecret(); err != nil {
+			return err
+		}
+	}
+	client := &osin.DefaultClient{}
+	client.CopyFrom(current)
+	client.Secret = *secret
+	if err = cs.SetClient(ctx, client); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "id\t%s\nsecret\t%s\n", client.Id, client.Secret)
+	return nil
+}
+
+func (a *admin) clientRemove(ctx context.Context, args []string) error {
+	fs := newFlagSet("client remove")
+	id := fs.String("id", "", "client id")
+	if err := parse(fs, args, "id"); err != nil {
+		return err
+	}
+	cs, err := a.clientStore()
+	if err != nil {
+		return err
+	}
+	return cs.RemoveClient(ctx, *id)
+}
+
+func (a *admin) tokenMint(args []string) error {
+	fs := newFlagSet("token mint")
+	clientId := fs.String("client", "", "client id")
+	scope := fs.String("scope", "", "granted scope")
+	expires := fs.Int("expires", 3600, "lifetime of the access token in seconds")
+	refresh := fs.Bool("refresh", false, "also mint a refresh token")
+	if err := parse(fs, args, "client"); err != nil {
+		return err
+	}
+	if *expires <= 0 {
+		return errors.New("token mint: -expires must be positive")
+	}
+	client, err := a.storage.GetClient(*clientId)
+	if err != nil {
+		return err
+	}
+	data, err := osintest.MintAccessToken(a.storage, &osin.AccessData{
+		Client:      client,
+		Scope:       *scope,
+		ExpiresIn:   int32(*expires),
+		RedirectUri: osin.FirstUri(client.GetRedirectURI(), ""),
+		CreatedAt:   a.currentTime(),
+	}, *refresh)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "access_token\t%s\n", data.AccessToken)
+	if data.RefreshToken != "" {
+		fmt.Fprintf(a.out, "refresh_token\t%s\n", data.RefreshToken)
+	}
+	fmt.Fprintf(a.out, "expires_at\t%s\n", data.ExpireAt().UTC().Format(time.RFC3339))
+	return nil
+}
+
+func (a *admin) clientGrants(ctx context.Context, clientId string) ([]string, error) {
+	gl, ok := a.storage.(grantLister)
+	if !ok {
+		return nil, errGrantsNotListed
+	}
+	return gl.AccessTokensByClient(ctx, clientId)
+}
+
+// grantsList writes a line per access token of the client, skipping the
+// ones removed while listing
+func (a *admin) grantsList(ctx context.Context, args []string) error {
+	fs := newFlagSet("grants list")
+	clientId := fs.String("client", "", "client id")
+	if err := parse(fs, args, "client"); err != nil {
+		return err
+	}
+	tokens, err := a.clientGrants(ctx, *clientId)
+	if err != nil {
+		return err
+	}
+	now := a.currentTime()
+	for _, token := range tokens {
+		data, err := a.storage.LoadAccess(token)
+		if err == osin.ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		state := "active"
+		if data.IsExpiredAt(now) {
+			state = "expired"
+		}
+		fmt.Fprintf(a.out, "%s\t%s\t%s\t%s\t%s\n", data.AccessToken, data.RefreshToken,
+			strings.Join(strings.Fields(data.Scope), ","), data.ExpireAt().UTC().Format(time.RFC3339), state)
+	}
+	return nil
+}
+
+// grantsRevoke removes an access token and its refresh token, or all the
+// ones of a client
+func (a *admin) grantsRevoke(ctx context.Context, args []string) error {
+	fs := newFlagSet("grants revoke")
+	token := fs.String("token", "", "access token")
+	clientId := fs.String("client", "", "client id")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	var tokens []string
+	switch {
+	case *token != "" && *clientId == "":
+		tokens = []string{*token}
+	case *token == "" && *clientId != "":
+		var err error
+		if tokens, err = a.clientGrants(ctx, *clientId); err != nil {
+			return err
+		}
+	default:
+		return errors.New("grants revoke: one of -token or -client is required")
+	}
+	revoked := 0
+	for _, t := range tokens {
+		data, err := a.storage.LoadAccess(t)
+		if err == osin.ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if data.RefreshToken != "" {
+			if err = a.storage.RemoveRefresh(data.RefreshToken); err != nil && err != osin.ErrNotFound {
+				return err
+			}
+		}
+		if err = a.storage.RemoveAccess(t); err != nil && err != osin.ErrNotFound {
+			return err
+		}
+		revoked++
+	}
+	fmt.Fprintf(a.out, "revoked\t%d\n", revoked)
+	return nil
+}
+
+func (a *admin) purge(args []string) error {
+	if err := parse(newFlagSet("purge"), args); err != nil {
+		return err
+	}
+	es, ok := a.storage.(osin.ExpiringStorage)
+	if !ok {
+		return errPurgeUnsupported
+	}
+	res, err := es.PurgeExpired(a.currentTime())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "authorize\t%d\naccess\t%d\nrefresh\t%d\n", res.Authorize, res.Access, res.Refresh)
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/RangelReale/osin/osintest"
+)
+
+// testStore adds saving clients and listing grants to osintest.Storage,
+// like the etcd and DynamoDB storages
+type testStore struct {
+	*osintest.Storage
+	removed map[string]bool
+}
+
+func (s *testStore) SetClient(ctx context.Context, client osin.Client) error {
+	delete(s.removed, client.GetID())
+	s.Storage.SetClient(client.GetID(), client)
+	return nil
+}
+
+func (s *testStore) RemoveClient(ctx context.Context, id string) error {
+	s.removed[id] = true
+	return nil
+}
+
+func (s *testStore) GetClient(id string) (osin.Client, error) {
+	if s.removed[id] {
+		return nil, osin.ErrNotFound
+	}
+	return s.Storage.GetClient(id)
+}
+
+func (s *testStore) AccessTokensByClient(ctx context.Context, clientId string) ([]string, error) {
+	var ret []string
+	for _, call := range s.Calls() {
+		if call.Method != "SaveAccess" || call.Err != nil {
+			continue
+		}
+		if data, err := s.Storage.LoadAccess(call.Arg); err == nil && data.Client.GetID() == clientId {
+			ret = append(ret, call.Arg)
+		}
+	}
+	return ret, nil
+}
+
+func newTestAdmin() (*admin, *testStore, *bytes.Buffer) {
+	store := &testStore{Storage: osintest.NewStorage(), removed: make(map[string]bool)}
+	out := &bytes.Buffer{}
+	return &admin{storage: store, out: out}, store, out
+}
+
+// outputValue returns the value of the first "name\tvalue" line of out
+func outputValue(out, name string) string {
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, name+"\t") {
+			return strings.TrimPrefix(line, name+"\t")
+		}
+	}
+	return ""
+}
+
+func TestAdminClients(t *testing.T) {
+	a, store, out := newTestAdmin()
+	ctx := context.Background()
+
+	if err := a.run(ctx, []string{"client", "create", "-id", "1234", "-redirect", "http://localhost/cb"}); err != nil {
+		t.Fatalf("Error creating client: %s", err)
+	}
+	secret := outputValue(out.String(), "secret")
+	c, err := store.GetClient("1234")
+	if err != nil {
+		t.Fatalf("Client not saved: %s", err)
+	}
+	if secret == "" || c.GetSecret() != secret || c.GetRedirectURI() != "http://localhost/cb" {
+		t.Fatalf("Unexpected client %+v with printed secret %q", c, secret)
+	}
+
+	if err = a.run(ctx, []string{"client", "create", "-id", "1234", "-redirect", "http://localhost/cb"}); err == nil {
+		t.Fatal("Creating an existing client should fail")
+	}
+
+	out.Reset()
+	if err = a.run(ctx, []string{"client", "rotate", "-id", "1234"}); err != nil {
+		t.Fatalf("Error rotating client: %s", err)
+	}
+	c, _ = store.GetClient("1234")
+	if rotated := outputValue(out.String(), "secret"); rotated == secret || c.GetSecret() != rotated {
+		t.Fatalf("Secret not rotated: printed %q, stored %q", rotated, c.GetSecret())
+	}
+	if c.GetRedirectURI() != "http://localhost/cb" {
+		t.Fatalf("Rotation changed the redirect uri: %s", c.GetRedirectURI())
+	}
+
+	if err = a.run(ctx, []string{"client", "remove", "-id", "1234"}); err != nil {
+		t.Fatalf("Error removing client: %s", err)
+	}
+	if _, err = store.GetClient("1234"); err != osin.ErrNotFound {
+		t.Fatalf("Client not removed: %v", err)
+	}
+
+	if err = a.run(ctx, []string{"client", "create"}); err == nil {
+		t.Fatal("Creating a client without redirect uri should fail")
+	}
+}
+
+func TestAdminGrants(t *testing.T) {
+	a, store, out := newTestAdmin()
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	a.now = func() time.Time { return now }
+	store.Storage.SetClient("1234", &osin.DefaultClient{Id: "1234", Secret: "aabbccdd", RedirectUri: "http://localhost/cb"})
+
+	if err := a.run(ctx, []string{"token", "mint", "-client", "1234", "-scope", "read write", "-refresh"}); err != nil {
+		t.Fatalf("Error minting token: %s", err)
+	}
+	access, refresh := outputValue(out.String(), "access_token"), outputValue(out.String(), "refresh_token")
+	if access == "" || refresh == "" {
+		t.Fatalf("Tokens not printed: %s", out)
+	}
+	if outputValue(out.String(), "expires_at") != "2026-01-02T04:04:05Z" {
+		t.Fatalf("Unexpected expiration: %s", out)
+	}
+	if data, err := store.LoadRefresh(refresh); err != nil || data.AccessToken != access || data.Scope != "read write" {
+		t.Fatalf("Token not saved: %+v %v", data, err)
+	}
+
+	out.Reset()
+	if err := a.run(ctx, []string{"grants", "list", "-client", "1234"}); err != nil {
+		t.Fatalf("Error listing grants: %s", err)
+	}
+	if expected := access + "\t" + refresh + "\tread,write\t2026-01-02T04:04:05Z\tactive\n"; out.String() != expected {
+		t.Fatalf("Unexpected grants %q, expected %q", out, expected)
+	}
+
+	out.Reset()
+	if err := a.run(ctx, []string{"grants", "revoke", "-client", "1234"}); err != nil {
+		t.Fatalf("Error revoking grants: %s", err)
+	}
+	if outputValue(out.String(), "revoked") != "1" {
+		t.Fatalf("Unexpected revoke output: %s", out)
+	}
+	if _, err := store.LoadAccess(access); err != osin.ErrNotFound {
+		t.Fatalf("Access token not revoked: %v", err)
+	}
+	if _, err := store.LoadRefresh(refresh); err != osin.ErrNotFound {
+		t.Fatalf("Refresh token not revoked: %v", err)
+	}
+
+	if err := a.run(ctx, []string{"grants", "revoke", "-token", access, "-client", "1234"}); err == nil {
+		t.Fatal("Revoking with both -token and -client should fail")
+	}
+}
+
+func TestAdminUnsupported(t *testing.T) {
+	a := &admin{storage: osintest.NewStorage(), out: &bytes.Buffer{}}
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		args []string
+		err  error
+	}{
+		"client create": {[]string{"client", "create", "-redirect", "http://localhost/cb"}, errClientsReadOnly},
+		"grants list":   {[]string{"grants", "list", "-client", "1234"}, errGrantsNotListed},
+		"purge":         {[]string{"purge"}, errPurgeUnsupported},
+		"unknown":       {[]string{"client", "frobnicate"}, errUsage},
+		"empty":         {nil, errUsage},
+	}
+	for name, test := range tests {
+		if err := a.run(ctx, test.args); err != test.err {
+			t.Errorf("%s: expected %v, got %v", name, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Command osinadmin manages the clients and grants of an osin storage:
+// create and rotate clients, mint test tokens, list and revoke grants and
+// purge the expired ones.
+//
+// The storage is selected by the backend flags, before the command:
+//
+//	osinadmin -backend etcd -etcd-endpoints localhost:2379 -etcd-prefix /osin/ client create -redirect http://localhost/cb
+//	osinadmin -backend dynamodb -dynamodb-table osin -dynamodb-region eu-west-1 grants list -client 1234
+//
+// The DynamoDB credentials are read from AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/RangelReale/osin/storage/dynamodb"
+	"github.com/RangelReale/osin/storage/etcd"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func main() {
+	backend := flag.String("backend", "", "storage backend: etcd or dynamodb")
+	etcdEndpoints := flag.String("etcd-endpoints", "localhost:2379", "etcd endpoints, comma separated")
+	etcdPrefix := flag.String("etcd-prefix", "/osin/", "prefix of the etcd keys")
+	ddbTable := flag.String("dynamodb-table", "", "DynamoDB table")
+	ddbRegion := flag.String("dynamodb-region", os.Getenv("AWS_REGION"), "DynamoDB region")
+	ddbEndpoint := flag.String("dynamodb-endpoint", "", "DynamoDB endpoint, for local instances")
+	timeout := flag.Duration("timeout", 30*time.Second, "timeout of the command")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		fmt.Fprintln(os.Stderr, "\nbackend flags:")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	var storage osin.Storage
+	switch *backend {
+	case "etcd":
+		client, err := clientv3.New(clientv3.Config{
+			Endpoints:   strings.Split(*etcdEndpoints, ","),
+			DialTimeout: 5 * time.Second,
+		})
+		if err != nil {
+			fail(err)
+		}
+		defer client.Close()
+		storage = etcd.New(client, *etcdPrefix)
+	case "dynamodb":
+		if *ddbTable == "" {
+			fail(errors.New("-dynamodb-table is required"))
+		}
+		opts := ddb.Options{
+			Region:      *ddbRegion,
+			Credentials: aws.NewCredentialsCache(aws.CredentialsProviderFunc(envCredentials)),
+		}
+		if *ddbEndpoint != "" {
+			opts.BaseEndpoint = aws.String(*ddbEndpoint)
+		}
+		storage = dynamodb.New(ddb.New(opts), *ddbTable)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	a := &admin{storage: storage, out: os.Stdout}
+	if err := a.run(ctx, flag.Args()); err == errUsage {
+		flag.Usage()
+		os.Exit(2)
+	} else if err != nil {
+		fail(err)
+	}
+}
+
+// envCredentials reads the AWS credentials from the environment
+func envCredentials(ctx context.Context) (aws.Credentials, error) {
+	creds := aws.Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		Source:          "environment",
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return aws.Credentials{}, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required")
+	}
+	return creds, nil
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "osinadmin:", err)
+	os.Exit(1)
+}
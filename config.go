@@ -1,5 +1,11 @@
 package osin
 
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
 // AllowedAuthorizeType is a collection of allowed auth request types
 type AllowedAuthorizeType []AuthorizeRequestType
 
@@ -26,31 +32,120 @@ func (t AllowedAccessType) Exists(rt AccessRequestType) bool {
 	return false
 }
 
+// ClientAccessList restricts the clients that may use a grant type
+type ClientAccessList struct {
+	// Client ids allowed. All the clients not denied are allowed if empty.
+	Allow []string
+
+	// Client ids denied, even if allowed
+	Deny []string
+}
+
+// Permits returns true if the client may use the grant type
+func (l ClientAccessList) Permits(clientId string) bool {
+	if stringInList(clientId, l.Deny) {
+		return false
+	}
+	return len(l.Allow) == 0 || stringInList(clientId, l.Allow)
+}
+
+// GrantExpiration holds the token expirations in seconds of a grant type.
+// Zero values use the server defaults.
+type GrantExpiration struct {
+	Access  int32
+	Refresh int32
+}
+
+// GrantPersistence is whether the access grants of a grant type are saved
+// in the storage
+type GrantPersistence string
+
+const (
+	// The access grants are saved (the default)
+	PERSIST_ALWAYS GrantPersistence = ""
+
+	// The access grants are not saved, for self-contained tokens like JWTs
+	// of machine grants. No refresh token is issued, and the tokens are
+	// unknown to the info, introspection and revocation endpoints.
+	PERSIST_NEVER GrantPersistence = "never"
+)
+
 // ServerConfig contains server configuration information
 type ServerConfig struct {
-	// Authorization token expiration in seconds (default 5 minutes)
+	// Authorization token expiration in seconds (default 5 minutes).
+	// Clients implementing ClientAuthorizationExpiration override it.
 	AuthorizationExpiration int32
 
+	// Maximum authorization token expiration in seconds of requests
+	// without PKCE, usually of confidential clients. No limit if 0 (the
+	// default).
+	AuthorizationExpirationWithoutPKCE int32
+
 	// Access token expiration in seconds (default 1 hour)
 	AccessExpiration int32
 
 	// Refresh token expiration in seconds (default 1 day)
 	RefreshExpiration int32
 
+	// Token expirations by grant type, overriding AccessExpiration and
+	// RefreshExpiration. IMPLICIT is used for the implicit flow.
+	GrantExpirations map[AccessRequestType]GrantExpiration
+
+	// Persistence of the access grants by grant type. All the grants are
+	// saved if empty (the default).
+	GrantPersistence map[AccessRequestType]GrantPersistence
+
 	// Domain attribute of token cookie
 	CookieDomain string
 
 	// Token type to return
 	TokenType string
 
+	// Realm of the WWW-Authenticate challenges sent when bearer or client
+	// authentication fails
+	Realm string
+
+	// Extra headers added to every response created by Server.NewResponse,
+	// like security headers. They replace the default headers of the same name.
+	ResponseHeaders http.Header
+
+	// Format of the tokens and codes of the default generators, set by
+	// NewServer (default base64-encoded random UUIDs). With a checksum, the
+	// tokens without it are rejected without a storage lookup, unless they
+	// match one of PreviousTokenFormats.
+	TokenFormat TokenFormat
+
+	// Formats of the tokens issued before TokenFormat was changed, still
+	// accepted until these tokens expire. A format without a checksum
+	// accepts any token. UpdateConfig adds the TokenFormat it replaces
+	// until the server restarts.
+	PreviousTokenFormats []TokenFormat
+
 	// List of allowed authorize types (only CODE by default)
 	AllowedAuthorizeTypes AllowedAuthorizeType
 
 	// List of allowed access types (only AUTHORIZATION_CODE by default)
 	AllowedAccessTypes AllowedAccessType
 
+	// Clients allowed or denied by access type, checked on the presented
+	// client id before the request is handled. Access types without entry
+	// are open to all clients.
+	AccessTypeClients map[AccessRequestType]ClientAccessList
+
+	// Reporting of the internal errors of error responses, like the
+	// storage errors behind server_error: hidden (the default), appended to
+	// error_description, logged, or logged with a reference returned to the
+	// client in error_reference. Don't expose them in production.
+	ErrorDetailLevel ErrorDetailLevel
+
+	// If positive, requests failing because the storage is unavailable, see
+	// BreakerStorage, get temporarily_unavailable with a Retry-After of
+	// this many seconds, instead of server_error. Disabled if 0 (the default).
+	StorageUnavailableRetryAfter int32
+
 	// HTTP status code to return for errors - default 200
-	// Only used if response was created from server
+	// Only used if response was created from server, and the server has no
+	// ErrorStatusMapper
 	ErrorStatusCode int
 
 	// If true allows client secret also in params, else only in
@@ -70,21 +165,360 @@ type ServerConfig struct {
 	// RetainTokenAfter Refresh allows the server to retain the access and
 	// refresh token for re-use - default false
 	RetainTokenAfterRefresh bool
+
+	// Known acr values ordered from weakest to strongest, so a stronger
+	// achieved acr satisfies a weaker required one. If blank (the default),
+	// the achieved acr must be one of the required values.
+	ACRLevels []string
+
+	// API keys accepted by the reference token validation endpoint, in the
+	// X-Api-Key header. If empty (the default), the endpoint refuses all requests.
+	ValidationAPIKeys []string
+
+	// Maximum number of tokens in a validation request - default 100
+	MaxValidationBatch int
+
+	// Seconds a gateway may keep an idle connection to the validation
+	// endpoint open, advertised in the Keep-Alive response header so it
+	// reuses connections instead of reconnecting for every batch. Set the
+	// IdleTimeout of the http.Server at least as long. Not advertised if 0
+	// (default 90)
+	ValidationKeepAlive int32
+
+	// Suspended authorize request expiration in seconds (default 10 minutes)
+	PendingAuthorizeExpiration int32
+
+	// Device code expiration in seconds (default 10 minutes)
+	DeviceCodeExpiration int32
+
+	// Minimum polling interval of devices in seconds (default 5)
+	DevicePollInterval int32
+
+	// Verification page where users enter the user codes of the device
+	// authorization grant
+	DeviceVerificationUri string
+
+	// Callback endpoint of the upstream identity providers, where
+	// HandleUpstreamCallback is served, see RedirectToUpstream
+	UpstreamCallbackUri string
+
+	// Multi-factor authentication challenge expiration in seconds (default 5 minutes)
+	MFAChallengeExpiration int32
+
+	// Maximum one-time passwords tried per challenge (default 5). No limit if 0.
+	MFAMaxAttempts int
+
+	// Pre-authorized code expiration in seconds (default 5 minutes)
+	PreAuthorizedCodeExpiration int32
+
+	// Maximum transaction codes tried per pre-authorized code (default 5).
+	// No limit if 0.
+	TxCodeMaxAttempts int
+
+	// Client redeeming the pre-authorized codes of wallets without a
+	// client_id. Those are refused if blank (the default).
+	PreAuthorizedAnonymousClient string
+
+	// c_nonce expiration in seconds (default 5 minutes)
+	CNonceExpiration int32
+
+	// If true, DPoP proofs must carry a nonce provided by the server: the
+	// token requests with a DPoP header are refused with use_dpop_nonce
+	// otherwise, see Server.CheckDPoPNonce - default false
+	RequireDPoPNonce bool
+
+	// Expiration and rotation of the server provided nonces by purpose.
+	// DPoP nonces default to 5 minutes with NONCE_ROTATE_PERIODIC, c_nonces
+	// to CNonceExpiration with NONCE_ROTATE_ON_USE.
+	NoncePolicies map[NoncePurpose]NoncePolicy
+
+	// If true, authorize requests without state are refused - default false
+	RequireState bool
+
+	// If true, OpenID Connect authorize requests (with the openid scope)
+	// without nonce are refused - default false
+	RequireNonce bool
+
+	// If true, the implicit flow (response_type token) is refused even if
+	// allowed in AllowedAuthorizeTypes - default false
+	DisableImplicit bool
+
+	// Maximum access token expiration in seconds for the implicit flow,
+	// enforced over AuthorizeRequest.Expiration. No limit if 0 (the default).
+	ImplicitMaxExpiration int32
+
+	// If true, the redirect uri of implicit flow requests must be exactly
+	// equal to a registered one, instead of a subpath - default false
+	ImplicitExactRedirectUri bool
+
+	// If true, the redirect uri of authorization code token requests
+	// follows RFC 6749 section 4.1.3 exactly: it is required if it was in
+	// the authorize request, with the same value, and refused otherwise.
+	// The storage must persist AuthorizeData.RedirectUriDefaulted. If false,
+	// it defaults to the first client redirect uri and must equal the one
+	// of the authorization code - default false
+	StrictRedirectUriMatch bool
+
+	// Maximum size in bytes of the authorize and token request bodies
+	// (default 1MB). No limit if 0.
+	MaxRequestBodySize int64
+
+	// Maximum length in bytes of request parameters by name. Requests
+	// exceeding them fail with invalid_request. Defaults limit assertion,
+	// code_verifier and scope.
+	MaxParameterLengths map[string]int
+
+	// Refuses requests with credentials in the URL query, which leaks into
+	// logs, with invalid_request. Client secrets, passwords, codes and
+	// tokens are only accepted in the POST body or headers.
+	ForbidQueryCredentials bool
+
+	// Requests exceeding the maximum scope of their client, see
+	// ClientScopeLimits, get the excess scopes trimmed instead of failing
+	// with invalid_scope
+	TrimExcessScope bool
+
+	// Window in seconds of the request counters given to the
+	// RiskEvaluator (default 3600)
+	RiskVelocityWindow int32
+
+	// Maximum time in seconds resource servers may cache the introspection
+	// responses of active tokens, bounded by the token expiration. Responses
+	// get Cache-Control and ETag headers, and conditional requests with
+	// If-None-Match are answered 304 Not Modified. Revocations are only
+	// seen by the caches once it elapses. Disabled if 0 (the default).
+	IntrospectionCacheMaxAge int32
+
+	// Client ids, like resource servers, allowed to introspect the tokens
+	// of any client when the server has no TokenAccessPolicy. Other clients
+	// may only introspect their own tokens.
+	IntrospectionClients []string
+
+	// If true, the source IP, user agent and location of the issuing
+	// requests are recorded as the Fingerprint of the grants - default false
+	RecordFingerprint bool
+
+	// AES keys encrypting the UserData serialized by storages, see
+	// UserDataCodec. The first one encrypts, all of them decrypt. UserData
+	// is only JSON encoded if empty. Storages don't read the configuration:
+	// set their UserDataCodec to the one of ServerConfig.UserDataCodec, or
+	// UserData is stored in plaintext.
+	UserDataKeys [][]byte
+
+	// Maximum number of previous grants linked through AccessData.AccessData
+	// on refresh. Older ones are unlinked. No limit if 0 (the default).
+	MaxLineageDepth int
+
+	// If true, refreshed grants link no previous grant at all, keeping only
+	// AccessData.FamilyID and AccessData.OriginalCode - default false
+	FlattenLineage bool
+
+	// Time in seconds a rotated refresh token still returns the tokens it
+	// was rotated to, for clients racing to refresh the same token. Disabled
+	// if 0 (the default).
+	RefreshGracePeriod int32
+
+	// If true, concurrent identical refresh requests of a client are
+	// finished once, the others waiting and getting the same response, so
+	// they don't race rotating the refresh token - default false
+	CoalesceRefreshRequests bool
+
+	// Time in seconds token requests with an Idempotency-Key header are
+	// replayed, returning the same response instead of issuing new tokens.
+	// Disabled if 0 (the default).
+	IdempotencyWindow int32
+
+	// Maximum number of token responses kept in server memory for the
+	// idempotency keys, when the storage doesn't implement
+	// IdempotencyStorage. The ones expiring first are dropped when full.
+	// Default 10000.
+	IdempotencyMaxEntries int
+
+	// Number of authorization codes generated before failing, when the
+	// storage implements AuthorizeInserter and reports a collision.
+	// Default 3.
+	AuthorizeCodeAttempts int
+
+	// Prefix of the API keys issued by Server.IssueAPIKey, telling them
+	// apart from the access tokens (default "osk_")
+	APIKeyPrefix string
 }
 
 // NewServerConfig returns a new ServerConfig with default configuration
 func NewServerConfig() *ServerConfig {
 	return &ServerConfig{
-		AuthorizationExpiration:   250,
-		AccessExpiration:          3600,
-		RefreshExpiration:         86400,
-		TokenType:                 "Bearer",
-		AllowedAuthorizeTypes:     AllowedAuthorizeType{CODE},
-		AllowedAccessTypes:        AllowedAccessType{AUTHORIZATION_CODE},
-		ErrorStatusCode:           200,
-		AllowClientSecretInParams: false,
-		AllowGetAccessRequest:     false,
-		RetainTokenAfterRefresh:   false,
-		CookieDomain:              "",
+		AuthorizationExpiration:     250,
+		AccessExpiration:            3600,
+		RefreshExpiration:           86400,
+		TokenType:                   "Bearer",
+		AllowedAuthorizeTypes:       AllowedAuthorizeType{CODE},
+		AllowedAccessTypes:          AllowedAccessType{AUTHORIZATION_CODE},
+		ErrorStatusCode:             200,
+		AllowClientSecretInParams:   false,
+		AllowGetAccessRequest:       false,
+		RetainTokenAfterRefresh:     false,
+		CookieDomain:                "",
+		MaxValidationBatch:          100,
+		ValidationKeepAlive:         90,
+		PendingAuthorizeExpiration:  600,
+		DeviceCodeExpiration:        600,
+		DevicePollInterval:          5,
+		MFAChallengeExpiration:      300,
+		MFAMaxAttempts:              5,
+		PreAuthorizedCodeExpiration: 300,
+		TxCodeMaxAttempts:           5,
+		CNonceExpiration:            300,
+		RiskVelocityWindow:          3600,
+		AuthorizeCodeAttempts:       3,
+		IdempotencyMaxEntries:       defaultIdempotencyMaxEntries,
+		MaxRequestBodySize:          1 << 20,
+		MaxParameterLengths: map[string]int{
+			"assertion":     64 << 10,
+			"code_verifier": 128,
+			"scope":         4096,
+		},
+	}
+}
+
+// NewServerConfigStrict returns a new ServerConfig with the defaults of
+// NewServerConfig hardened: PKCE for public clients, state and nonce
+// required, no implicit flow, no credentials in the URL query, and HTTP
+// error status codes.
+func NewServerConfigStrict() *ServerConfig {
+	c := NewServerConfig()
+	c.AllowedAccessTypes = AllowedAccessType{AUTHORIZATION_CODE, REFRESH_TOKEN}
+	c.ErrorStatusCode = http.StatusBadRequest
+	c.RequirePKCEForPublicClients = true
+	c.RequireState = true
+	c.RequireNonce = true
+	c.DisableImplicit = true
+	c.ImplicitExactRedirectUri = true
+	c.ForbidQueryCredentials = true
+	return c
+}
+
+// Validate returns an error if the configuration has invalid or
+// contradictory settings, which would otherwise show up as unexpected
+// behavior at runtime
+func (c *ServerConfig) Validate() error {
+	if c.AuthorizationExpiration <= 0 {
+		return errors.New("authorization expiration must be positive")
+	}
+	if c.AuthorizationExpirationWithoutPKCE < 0 {
+		return errors.New("authorization expiration without PKCE must not be negative")
+	}
+	if c.AccessExpiration <= 0 {
+		return errors.New("access expiration must be positive")
+	}
+	if c.RefreshExpiration < 0 {
+		return errors.New("refresh expiration must not be negative")
+	}
+	if c.RefreshExpiration > 0 && c.RefreshExpiration < c.AccessExpiration {
+		return errors.New("refresh expiration is shorter than access expiration")
+	}
+	for t, e := range c.GrantExpirations {
+		if e.Access < 0 || e.Refresh < 0 {
+			return fmt.Errorf("expirations of %s must not be negative", t)
+		}
+		if r := c.RefreshExpirationFor(t); r > 0 && r < c.AccessExpirationFor(t) {
+			return fmt.Errorf("refresh expiration of %s is shorter than access expiration", t)
+		}
+	}
+	for purpose, p := range c.NoncePolicies {
+		switch p.Rotation {
+		case "", NONCE_ROTATE_ON_USE, NONCE_ROTATE_PERIODIC:
+		default:
+			return fmt.Errorf("unknown rotation %s of %s nonces", p.Rotation, purpose)
+		}
+		if p.Expiration < 0 {
+			return fmt.Errorf("expiration of %s nonces must not be negative", purpose)
+		}
+	}
+	for t, p := range c.GrantPersistence {
+		switch p {
+		case PERSIST_ALWAYS:
+		case PERSIST_NEVER:
+			if t == REFRESH_TOKEN {
+				return errors.New("refresh token grants must be persisted")
+			}
+		default:
+			return fmt.Errorf("unknown persistence %s of %s", p, t)
+		}
+	}
+	if e := c.GrantExpirations[IMPLICIT].Refresh; e > 0 {
+		return errors.New("implicit flow never issues refresh tokens, but has a refresh expiration")
+	}
+	if c.TokenType == "" {
+		return errors.New("token type is empty")
+	}
+	if len(c.AllowedAuthorizeTypes) == 0 && len(c.AllowedAccessTypes) == 0 {
+		return errors.New("no authorize or access types allowed")
+	}
+	if c.DisableImplicit && c.ImplicitMaxExpiration > 0 {
+		return errors.New("implicit flow is disabled, but has a maximum expiration")
+	}
+	switch c.ErrorDetailLevel {
+	case ERROR_DETAIL_HIDDEN, ERROR_DETAIL_EXPOSE, ERROR_DETAIL_LOG, ERROR_DETAIL_REFERENCE:
+	default:
+		return fmt.Errorf("unknown error detail level %s", c.ErrorDetailLevel)
+	}
+	if c.ErrorStatusCode != 0 && (c.ErrorStatusCode < 100 || c.ErrorStatusCode > 599) {
+		return fmt.Errorf("invalid error status code %d", c.ErrorStatusCode)
+	}
+	if c.AllowedAccessTypes.Exists(DEVICE_CODE) && c.DeviceCodeExpiration <= 0 {
+		return errors.New("device code expiration must be positive")
+	}
+	if c.AllowedAccessTypes.Exists(PRE_AUTHORIZED_CODE) && (c.PreAuthorizedCodeExpiration <= 0 || c.CNonceExpiration <= 0) {
+		return errors.New("pre-authorized code and c_nonce expirations must be positive")
+	}
+	if c.MaxValidationBatch < 0 || c.ValidationKeepAlive < 0 || c.MFAMaxAttempts < 0 || c.AuthorizeCodeAttempts < 0 || c.TxCodeMaxAttempts < 0 {
+		return errors.New("limits must not be negative")
+	}
+	for _, k := range c.UserDataKeys {
+		if l := len(k); l != 16 && l != 24 && l != 32 {
+			return fmt.Errorf("invalid user data key size %d", l)
+		}
+	}
+	return nil
+}
+
+// AccessExpirationFor returns the access token expiration of the grant type
+func (c *ServerConfig) AccessExpirationFor(t AccessRequestType) int32 {
+	if e := c.GrantExpirations[t].Access; e > 0 {
+		return e
+	}
+	return c.AccessExpiration
+}
+
+// PersistenceFor returns the persistence of the access grants of the grant
+// type
+func (c *ServerConfig) PersistenceFor(t AccessRequestType) GrantPersistence {
+	return c.GrantPersistence[t]
+}
+
+// NoncePolicyFor returns the policy of the server provided nonces of the
+// purpose, with the defaults for the unset fields
+func (c *ServerConfig) NoncePolicyFor(purpose NoncePurpose) NoncePolicy {
+	ret := NoncePolicy{Expiration: 300, Rotation: NONCE_ROTATE_ON_USE}
+	switch purpose {
+	case NONCE_DPOP:
+		ret.Rotation = NONCE_ROTATE_PERIODIC
+	case NONCE_C_NONCE:
+		ret.Expiration = c.CNonceExpiration
+	}
+	if p := c.NoncePolicies[purpose]; p.Expiration > 0 {
+		ret.Expiration = p.Expiration
+	}
+	if p := c.NoncePolicies[purpose]; p.Rotation != "" {
+		ret.Rotation = p.Rotation
+	}
+	return ret
+}
+
+// RefreshExpirationFor returns the refresh token expiration of the grant type
+func (c *ServerConfig) RefreshExpirationFor(t AccessRequestType) int32 {
+	if e := c.GrantExpirations[t].Refresh; e > 0 {
+		return e
 	}
+	return c.RefreshExpiration
 }
@@ -0,0 +1,66 @@
+package osin
+
+import (
+	"testing"
+)
+
+func TestServerConfigValidate(t *testing.T) {
+	if err := NewServerConfig().Validate(); err != nil {
+		t.Errorf("Default config should be valid: %s", err)
+	}
+	if err := NewServerConfigStrict().Validate(); err != nil {
+		t.Errorf("Strict config should be valid: %s", err)
+	}
+
+	tests := map[string]func(c *ServerConfig){
+		"refresh shorter than access": func(c *ServerConfig) {
+			c.RefreshExpiration = 60
+		},
+		"grant refresh shorter than access": func(c *ServerConfig) {
+			c.GrantExpirations = map[AccessRequestType]GrantExpiration{PASSWORD: {Access: 7200, Refresh: 3600}}
+		},
+		"refresh grant not persisted": func(c *ServerConfig) {
+			c.GrantPersistence = map[AccessRequestType]GrantPersistence{REFRESH_TOKEN: PERSIST_NEVER}
+		},
+		"unknown persistence": func(c *ServerConfig) {
+			c.GrantPersistence = map[AccessRequestType]GrantPersistence{CLIENT_CREDENTIALS: "sometimes"}
+		},
+		"implicit refresh": func(c *ServerConfig) {
+			c.GrantExpirations = map[AccessRequestType]GrantExpiration{IMPLICIT: {Refresh: 86400}}
+		},
+		"implicit disabled with max expiration": func(c *ServerConfig) {
+			c.DisableImplicit = true
+			c.ImplicitMaxExpiration = 600
+		},
+		"empty token type": func(c *ServerConfig) {
+			c.TokenType = ""
+		},
+		"no access expiration": func(c *ServerConfig) {
+			c.AccessExpiration = 0
+		},
+		"no allowed types": func(c *ServerConfig) {
+			c.AllowedAuthorizeTypes = nil
+			c.AllowedAccessTypes = nil
+		},
+		"invalid error status": func(c *ServerConfig) {
+			c.ErrorStatusCode = 1000
+		},
+		"invalid user data key": func(c *ServerConfig) {
+			c.UserDataKeys = [][]byte{[]byte("short")}
+		},
+		"pre-authorized code without c_nonce expiration": func(c *ServerConfig) {
+			c.AllowedAccessTypes = AllowedAccessType{PRE_AUTHORIZED_CODE}
+			c.CNonceExpiration = 0
+		},
+		"unknown nonce rotation": func(c *ServerConfig) {
+			c.NoncePolicies = map[NoncePurpose]NoncePolicy{NONCE_DPOP: {Rotation: "daily"}}
+		},
+	}
+	for k, modify := range tests {
+		c := NewServerConfig()
+		modify(c)
+		if err := c.Validate(); err == nil {
+			t.Errorf("%s: expected an error", k)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package connectors
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/RangelReale/osin"
+)
+
+// Issuer of Sign in with Apple
+const APPLE_ISSUER = "https://appleid.apple.com"
+
+// AppleConnector is an osin.UpstreamConnector for Sign in with Apple. Its
+// client secret is a JWT signed with the private key of the team, and the
+// name of the user is only returned by the first login, in the "user"
+// parameter of the callback.
+type AppleConnector struct {
+	*osin.OIDCConnector
+
+	// Team of the Apple developer account, and the id and ES256 private
+	// key of its Sign in with Apple key
+	TeamID string
+	KeyID  string
+	Key    crypto.Signer
+
+	// Current time, time.Now if nil
+	Now func() time.Time
+}
+
+// NewAppleConnector creates the connector of the services id, with the
+// callback response posted as a form, as Apple requires when asking for
+// the name and email
+func NewAppleConnector(servicesID, teamID, keyID string, key crypto.Signer) *AppleConnector {
+	c := &AppleConnector{TeamID: teamID, KeyID: keyID, Key: key}
+	c.OIDCConnector = &osin.OIDCConnector{
+		Issuer:           APPLE_ISSUER,
+		ClientID:         servicesID,
+		ClientSecretFunc: c.ClientSecret,
+		ClientSecretPost: true,
+		Scope:            "name email",
+		AuthorizeParams:  url.Values{"response_mode": {"form_post"}},
+	}
+	return c
+}
+
+func (c *AppleConnector) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+// ClientSecret returns the client secret JWT, valid for 5 minutes
+func (c *AppleConnector) ClientSecret(ctx context.Context) (string, error) {
+	now := c.now()
+	return osin.SignJWT(map[string]interface{}{
+		"iss": c.TeamID,
+		"sub": c.ClientID,
+		"aud": APPLE_ISSUER,
+		"iat": now.Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+	}, "ES256", c.KeyID, c.Key)
+}
+
+// Callback satisfies osin.UpstreamConnector, adding the name of the first
+// login to the ID token claims
+func (c *AppleConnector) Callback(ctx context.Context, r *http.Request, callbackURL string, login *osin.UpstreamLogin) (*osin.UpstreamIdentity, error) {
+	id, err := c.OIDCConnector.Callback(ctx, r, callbackURL, login)
+	if err != nil {
+		return nil, err
+	}
+	// Apple sends booleans as strings
+	for _, name := range []string{"email_verified", "is_private_email"} {
+		if s, ok := id.Claims[name].(string); ok {
+			id.Claims[name] = s == "true"
+		}
+	}
+	var user struct {
+		Name struct {
+			FirstName string `json:"firstName"`
+			LastName  string `json:"lastName"`
+		} `json:"name"`
+	}
+	if u := r.Form.Get("user"); u != "" && json.Unmarshal([]byte(u), &user) == nil {
+		if user.Name.FirstName != "" {
+			id.Claims["given_name"] = user.Name.FirstName
+		}
+		if user.Name.LastName != "" {
+			id.Claims["family_name"] = user.Name.LastName
+		}
+	}
+	return id, nil
+}
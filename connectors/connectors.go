@@ -0,0 +1,112 @@
+// Package connectors provides upstream identity provider connectors for
+// the common social logins, to broker osin authorize requests to them, see
+// osin.Server.RedirectToUpstream. The claims of each provider are mapped to
+// the standard OpenID Connect claims by a ClaimMapping template.
+//
+//	server.Config.UpstreamCallbackUri = "https://auth.example.com/upstream/callback"
+//	server.RegisterUpstreamConnector("google", connectors.Google(googleID, googleSecret))
+//	server.RegisterUpstreamConnector("github", connectors.GitHub(githubID, githubSecret))
+//
+// The login page then calls server.RedirectToUpstream with the provider
+// chosen by the user, and the callback endpoint server.HandleUpstreamCallback.
+package connectors
+
+import (
+	"context"
+	"crypto"
+	"net/http"
+
+	"github.com/RangelReale/osin"
+)
+
+// ClaimMapping maps local claims to the claims of an upstream provider
+type ClaimMapping map[string]string
+
+// Claim mapping templates of the providers
+var (
+	GoogleClaims = ClaimMapping{
+		"email":          "email",
+		"email_verified": "email_verified",
+		"name":           "name",
+		"given_name":     "given_name",
+		"family_name":    "family_name",
+		"picture":        "picture",
+		"locale":         "locale",
+		"hd":             "hd",
+	}
+
+	GitHubClaims = ClaimMapping{
+		"preferred_username": "login",
+		"name":               "name",
+		"email":              "email",
+		"email_verified":     "email_verified",
+		"picture":            "avatar_url",
+		"profile":            "html_url",
+	}
+
+	AppleClaims = ClaimMapping{
+		"email":            "email",
+		"email_verified":   "email_verified",
+		"is_private_email": "is_private_email",
+		"given_name":       "given_name",
+		"family_name":      "family_name",
+	}
+)
+
+// Apply returns the local claims of the upstream claims. Claims missing
+// upstream are left out.
+func (m ClaimMapping) Apply(claims map[string]interface{}) map[string]interface{} {
+	ret := make(map[string]interface{}, len(m))
+	for local, upstream := range m {
+		if v, ok := claims[upstream]; ok && v != nil {
+			ret[local] = v
+		}
+	}
+	return ret
+}
+
+// Mapped is a connector whose identity claims are mapped with a
+// ClaimMapping
+type Mapped struct {
+	osin.UpstreamConnector
+	Claims ClaimMapping
+}
+
+// Callback satisfies osin.UpstreamConnector, mapping the claims of the
+// identity
+func (m *Mapped) Callback(ctx context.Context, r *http.Request, callbackURL string, login *osin.UpstreamLogin) (*osin.UpstreamIdentity, error) {
+	id, err := m.UpstreamConnector.Callback(ctx, r, callbackURL, login)
+	if err != nil {
+		return nil, err
+	}
+	id.Claims = m.Claims.Apply(id.Claims)
+	return id, nil
+}
+
+// Google returns the connector of Sign in with Google
+func Google(clientID, clientSecret string) *Mapped {
+	return &Mapped{
+		UpstreamConnector: &osin.OIDCConnector{
+			Issuer:       "https://accounts.google.com",
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+		},
+		Claims: GoogleClaims,
+	}
+}
+
+// GitHub returns the connector of Sign in with GitHub
+func GitHub(clientID, clientSecret string) *Mapped {
+	return &Mapped{
+		UpstreamConnector: &GitHubConnector{ClientID: clientID, ClientSecret: clientSecret},
+		Claims:            GitHubClaims,
+	}
+}
+
+// Apple returns the connector of Sign in with Apple, see NewAppleConnector
+func Apple(servicesID, teamID, keyID string, key crypto.Signer) *Mapped {
+	return &Mapped{
+		UpstreamConnector: NewAppleConnector(servicesID, teamID, keyID, key),
+		Claims:            AppleClaims,
+	}
+}
@@ -0,0 +1,172 @@
+package connectors
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+)
+
+// callbackRequest is the request of the provider to the callback
+func callbackRequest(method string, form url.Values) *http.Request {
+	var req *http.Request
+	if method == "POST" {
+		req, _ = http.NewRequest("POST", "https://auth.example.com/callback", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req, _ = http.NewRequest("GET", "https://auth.example.com/callback?"+form.Encode(), nil)
+	}
+	req.ParseForm()
+	return req
+}
+
+func TestGitHub(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.URL.Path {
+		case "/login/oauth/access_token":
+			if r.Form.Get("code") != "code-1" || r.Form.Get("client_secret") != "secret" {
+				json.NewEncoder(w).Encode(map[string]string{"error": "bad_verification_code"})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "gh-token"})
+		case "/api/user":
+			if r.Header.Get("Authorization") != "Bearer gh-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"id": 583231, "login": "octocat", "name": "The Octocat",
+				"email": "unverified@example.com", "avatar_url": "https://avatars.example.com/583231",
+			})
+		case "/api/user/emails":
+			json.NewEncoder(w).Encode([]map[string]interface{}{
+				{"email": "old@example.com", "primary": false, "verified": true},
+				{"email": "octocat@example.com", "primary": true, "verified": true},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	gh := GitHub("client", "secret")
+	gh.UpstreamConnector.(*GitHubConnector).URL = srv.URL
+	gh.UpstreamConnector.(*GitHubConnector).APIURL = srv.URL + "/api"
+	login := &osin.UpstreamLogin{Provider: "github", Data: make(map[string]string)}
+
+	loginURL, err := gh.LoginURL(context.Background(), "st", "https://auth.example.com/callback", login)
+	if err != nil || !strings.HasPrefix(loginURL, srv.URL+"/login/oauth/authorize?") || !strings.Contains(loginURL, "state=st") {
+		t.Fatalf("Unexpected login url %s %v", loginURL, err)
+	}
+
+	id, err := gh.Callback(context.Background(), callbackRequest("GET", url.Values{"code": {"code-1"}}), "https://auth.example.com/callback", login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"preferred_username": "octocat",
+		"name":               "The Octocat",
+		"email":              "octocat@example.com",
+		"email_verified":     true,
+		"picture":            "https://avatars.example.com/583231",
+	}
+	if id.Subject != "583231" || len(id.Claims) != len(expected) {
+		t.Fatalf("Unexpected identity %+v", id)
+	}
+	for k, v := range expected {
+		if id.Claims[k] != v {
+			t.Errorf("Claim %s: expected %v, got %v", k, v, id.Claims[k])
+		}
+	}
+
+	if _, err = gh.Callback(context.Background(), callbackRequest("GET", url.Values{"code": {"wrong"}}), "https://auth.example.com/callback", login); err == nil {
+		t.Fatal("Expected error with a wrong code")
+	}
+}
+
+func TestApple(t *testing.T) {
+	teamKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	appleKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	jwk, _ := osin.NewJSONWebKey(appleKey.Public(), "apple-1", "RS256")
+
+	var nonce string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.URL.Path {
+		case "/auth/keys":
+			json.NewEncoder(w).Encode(osin.JSONWebKeySet{Keys: []osin.JSONWebKey{jwk}})
+		case "/auth/token":
+			secret, err := osin.ParseJWT(r.Form.Get("client_secret"))
+			if err != nil || secret.Verify(&teamKey.PublicKey) != nil || secret.StringClaim("iss") != "TEAM" ||
+				secret.StringClaim("sub") != "com.example.web" || r.Form.Get("client_id") != "com.example.web" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			token, _ := osin.SignJWT(map[string]interface{}{
+				"iss": APPLE_ISSUER, "aud": "com.example.web", "sub": "001234.abcd",
+				"email": "x1@privaterelay.appleid.com", "email_verified": "true", "is_private_email": "true",
+				"nonce": nonce, "exp": time.Now().Add(time.Minute).Unix(),
+			}, "RS256", "apple-1", appleKey)
+			json.NewEncoder(w).Encode(map[string]string{"id_token": token})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	apple := Apple("com.example.web", "TEAM", "KEY1", teamKey)
+	oidc := apple.UpstreamConnector.(*AppleConnector).OIDCConnector
+	oidc.AuthorizationEndpoint = srv.URL + "/auth/authorize"
+	oidc.TokenEndpoint = srv.URL + "/auth/token"
+	oidc.JWKSURI = srv.URL + "/auth/keys"
+	login := &osin.UpstreamLogin{Provider: "apple", Data: make(map[string]string)}
+
+	loginURL, err := apple.LoginURL(context.Background(), "st", "https://auth.example.com/callback", login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(loginURL)
+	if u.Query().Get("response_mode") != "form_post" || u.Query().Get("scope") != "name email" {
+		t.Fatalf("Unexpected login url %s", loginURL)
+	}
+	nonce = u.Query().Get("nonce")
+
+	req := callbackRequest("POST", url.Values{
+		"code":  {"code-1"},
+		"state": {"st"},
+		"user":  {`{"name":{"firstName":"Jane","lastName":"Appleseed"},"email":"x1@privaterelay.appleid.com"}`},
+	})
+	id, err := apple.Callback(context.Background(), req, "https://auth.example.com/callback", login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Subject != "001234.abcd" || id.Claims["given_name"] != "Jane" || id.Claims["family_name"] != "Appleseed" ||
+		id.Claims["email_verified"] != true || id.Claims["is_private_email"] != true {
+		t.Fatalf("Unexpected identity %+v", id)
+	}
+	if _, ok := id.Claims["nonce"]; ok {
+		t.Error("Claims not in the template must be left out")
+	}
+}
+
+func TestGoogle(t *testing.T) {
+	google := Google("client", "secret").UpstreamConnector.(*osin.OIDCConnector)
+	if google.Issuer != "https://accounts.google.com" || google.ClientID != "client" {
+		t.Fatalf("Unexpected Google connector %+v", google)
+	}
+	claims := GoogleClaims.Apply(map[string]interface{}{"email": "a@example.com", "email_verified": true, "at_hash": "x"})
+	if len(claims) != 2 || claims["email"] != "a@example.com" {
+		t.Fatalf("Unexpected claims %v", claims)
+	}
+}
@@ -0,0 +1,167 @@
+package connectors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/RangelReale/osin"
+)
+
+// GitHubConnector is an osin.UpstreamConnector for GitHub, which doesn't
+// support OpenID Connect. The identity is read from the user API, with the
+// primary email if verified.
+type GitHubConnector struct {
+	ClientID     string
+	ClientSecret string
+
+	// Scope requested (default "read:user user:email")
+	Scope string
+
+	// URL of GitHub and of its API, for GitHub Enterprise Server (default
+	// "https://github.com" and "https://api.github.com")
+	URL    string
+	APIURL string
+
+	// HTTP client calling GitHub. http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+func (c *GitHubConnector) url() string {
+	if c.URL == "" {
+		return "https://github.com"
+	}
+	return strings.TrimSuffix(c.URL, "/")
+}
+
+func (c *GitHubConnector) apiURL() string {
+	if c.APIURL == "" {
+		return "https://api.github.com"
+	}
+	return strings.TrimSuffix(c.APIURL, "/")
+}
+
+func (c *GitHubConnector) client() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// LoginURL satisfies osin.UpstreamConnector
+func (c *GitHubConnector) LoginURL(ctx context.Context, state, callbackURL string, login *osin.UpstreamLogin) (string, error) {
+	scope := c.Scope
+	if scope == "" {
+		scope = "read:user user:email"
+	}
+	q := url.Values{
+		"client_id":    {c.ClientID},
+		"redirect_uri": {callbackURL},
+		"scope":        {scope},
+		"state":        {state},
+		"allow_signup": {"false"},
+	}
+	return c.url() + "/login/oauth/authorize?" + q.Encode(), nil
+}
+
+// Callback satisfies osin.UpstreamConnector
+func (c *GitHubConnector) Callback(ctx context.Context, r *http.Request, callbackURL string, login *osin.UpstreamLogin) (*osin.UpstreamIdentity, error) {
+	if e := r.Form.Get("error"); e != "" {
+		return nil, fmt.Errorf("github returned %s: %s", e, r.Form.Get("error_description"))
+	}
+	code := r.Form.Get("code")
+	if code == "" {
+		return nil, errors.New("github returned no code")
+	}
+
+	token, err := c.exchange(ctx, code, callbackURL)
+	if err != nil {
+		return nil, err
+	}
+	var user map[string]interface{}
+	if err = c.get(ctx, token, "/user", &user); err != nil {
+		return nil, err
+	}
+	id, ok := user["id"].(float64)
+	if !ok {
+		return nil, errors.New("github user has no id")
+	}
+
+	// the public email of the profile may be unverified, or missing
+	delete(user, "email")
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err = c.get(ctx, token, "/user/emails", &emails); err != nil {
+		return nil, err
+	}
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			user["email"] = e.Email
+			user["email_verified"] = true
+		}
+	}
+
+	return &osin.UpstreamIdentity{
+		Issuer:  c.url(),
+		Subject: strconv.FormatInt(int64(id), 10),
+		Claims:  user,
+	}, nil
+}
+
+// exchange redeems the code for an access token
+func (c *GitHubConnector) exchange(ctx context.Context, code, callbackURL string) (string, error) {
+	form := url.Values{
+		"client_id":     {c.ClientID},
+		"client_secret": {c.ClientSecret},
+		"code":          {code},
+		"redirect_uri":  {callbackURL},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url()+"/login/oauth/access_token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var ret struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+		return "", err
+	}
+	if ret.AccessToken == "" {
+		return "", fmt.Errorf("github token request failed: %s", ret.Error)
+	}
+	return ret.AccessToken, nil
+}
+
+func (c *GitHubConnector) get(ctx context.Context, token, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.apiURL()+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s failed with status %d", path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,145 @@
+package osin
+
+import (
+	"context"
+	"net/http"
+)
+
+// requestContext returns the context of the request, or the background
+// context if there is none
+func requestContext(ctx context.Context, r *http.Request) context.Context {
+	if ctx != nil {
+		return ctx
+	}
+	if r != nil {
+		return r.Context()
+	}
+	return context.Background()
+}
+
+// Context returns the context of the request, set with WithContext or
+// taken from HttpRequest. It is passed to the AccessApprover, the
+// context-aware generators and the ContextStorage.
+func (ar *AccessRequest) Context() context.Context {
+	return requestContext(ar.ctx, ar.HttpRequest)
+}
+
+// WithContext returns a shallow copy of the request with its context
+// changed to ctx, like http.Request.WithContext
+func (ar *AccessRequest) WithContext(ctx context.Context) *AccessRequest {
+	if ctx == nil {
+		panic("nil context")
+	}
+	ret := *ar
+	ret.ctx = ctx
+	return &ret
+}
+
+// Context returns the context of the request, set with WithContext or
+// taken from HttpRequest. It is passed to the context-aware generators and
+// the ContextStorage.
+func (ar *AuthorizeRequest) Context() context.Context {
+	return requestContext(ar.ctx, ar.HttpRequest)
+}
+
+// WithContext returns a shallow copy of the request with its context
+// changed to ctx, like http.Request.WithContext
+func (ar *AuthorizeRequest) WithContext(ctx context.Context) *AuthorizeRequest {
+	if ctx == nil {
+		panic("nil context")
+	}
+	ret := *ar
+	ret.ctx = ctx
+	return &ret
+}
+
+// AuthorizeTokenGenWithContext is an optional interface authorization code
+// generators can implement to receive the request context
+type AuthorizeTokenGenWithContext interface {
+	GenerateAuthorizeTokenContext(ctx context.Context, data *AuthorizeData) (string, error)
+}
+
+// AccessTokenGenWithContext is an optional interface access token
+// generators can implement to receive the request context. Generators
+// implementing AccessTokenGenWithRequest get it from AccessRequest.Context.
+type AccessTokenGenWithContext interface {
+	GenerateAccessTokenContext(ctx context.Context, data *AccessData, generaterefresh bool) (accesstoken string, refreshtoken string, err error)
+}
+
+// ContextStorage is an optional interface storages can implement to receive
+// the request context, for deadlines and tracing. The server calls these
+// methods instead of the Storage ones when implemented.
+type ContextStorage interface {
+	GetClientContext(ctx context.Context, id string) (Client, error)
+	SaveAuthorizeContext(ctx context.Context, data *AuthorizeData) error
+	LoadAuthorizeContext(ctx context.Context, code string) (*AuthorizeData, error)
+	RemoveAuthorizeContext(ctx context.Context, code string) error
+	SaveAccessContext(ctx context.Context, data *AccessData) error
+	LoadAccessContext(ctx context.Context, token string) (*AccessData, error)
+	RemoveAccessContext(ctx context.Context, token string) error
+	LoadRefreshContext(ctx context.Context, token string) (*AccessData, error)
+	RemoveRefreshContext(ctx context.Context, token string) error
+}
+
+func storageGetClient(ctx context.Context, storage Storage, id string) (Client, error) {
+	if cs, ok := storage.(ContextStorage); ok {
+		return cs.GetClientContext(ctx, id)
+	}
+	return storage.GetClient(id)
+}
+
+func storageSaveAuthorize(ctx context.Context, storage Storage, data *AuthorizeData) error {
+	if cs, ok := storage.(ContextStorage); ok {
+		return cs.SaveAuthorizeContext(ctx, data)
+	}
+	return storage.SaveAuthorize(data)
+}
+
+func storageLoadAuthorize(ctx context.Context, storage Storage, code string) (*AuthorizeData, error) {
+	if cs, ok := storage.(ContextStorage); ok {
+		return cs.LoadAuthorizeContext(ctx, code)
+	}
+	return storage.LoadAuthorize(code)
+}
+
+func storageRemoveAuthorize(ctx context.Context, storage Storage, code string) error {
+	if cs, ok := storage.(ContextStorage); ok {
+		return cs.RemoveAuthorizeContext(ctx, code)
+	}
+	return storage.RemoveAuthorize(code)
+}
+
+func storageSaveAccess(ctx context.Context, storage Storage, data *AccessData) error {
+	if cs, ok := storage.(ContextStorage); ok {
+		return cs.SaveAccessContext(ctx, data)
+	}
+	return storage.SaveAccess(data)
+}
+
+func storageLoadAccess(ctx context.Context, storage Storage, token string) (*AccessData, error) {
+	if cs, ok := storage.(ContextStorage); ok {
+		return cs.LoadAccessContext(ctx, token)
+	}
+	return storage.LoadAccess(token)
+}
+
+func storageRemoveAccess(ctx context.Context, storage Storage, token string) error {
+	if cs, ok := storage.(ContextStorage); ok {
+		return cs.RemoveAccessContext(ctx, token)
+	}
+	return storage.RemoveAccess(token)
+}
+
+func storageLoadRefresh(ctx context.Context, storage Storage, token string) (*AccessData, error) {
+	if cs, ok := storage.(ContextStorage); ok {
+		return cs.LoadRefreshContext(ctx, token)
+	}
+	return storage.LoadRefresh(token)
+}
+
+func storageRemoveRefresh(ctx context.Context, storage Storage, token string) error {
+	if cs, ok := storage.(ContextStorage); ok {
+		return cs.RemoveRefreshContext(ctx, token)
+	}
+	return storage.RemoveRefresh(token)
+}
@@ -0,0 +1,111 @@
+package osin
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+type contextTestKey struct{}
+
+// contextTestingStorage records the contexts received by the storage
+type contextTestingStorage struct {
+	*TestingStorage
+	saved []context.Context
+}
+
+func (s *contextTestingStorage) Clone() Storage {
+	return s
+}
+
+func (s *contextTestingStorage) GetClientContext(ctx context.Context, id string) (Client, error) {
+	return s.GetClient(id)
+}
+
+func (s *contextTestingStorage) SaveAuthorizeContext(ctx context.Context, data *AuthorizeData) error {
+	return s.SaveAuthorize(data)
+}
+
+func (s *contextTestingStorage) LoadAuthorizeContext(ctx context.Context, code string) (*AuthorizeData, error) {
+	return s.LoadAuthorize(code)
+}
+
+func (s *contextTestingStorage) RemoveAuthorizeContext(ctx context.Context, code string) error {
+	return s.RemoveAuthorize(code)
+}
+
+func (s *contextTestingStorage) SaveAccessContext(ctx context.Context, data *AccessData) error {
+	s.saved = append(s.saved, ctx)
+	return s.SaveAccess(data)
+}
+
+func (s *contextTestingStorage) LoadAccessContext(ctx context.Context, token string) (*AccessData, error) {
+	return s.LoadAccess(token)
+}
+
+func (s *contextTestingStorage) RemoveAccessContext(ctx context.Context, token string) error {
+	return s.RemoveAccess(token)
+}
+
+func (s *contextTestingStorage) LoadRefreshContext(ctx context.Context, token string) (*AccessData, error) {
+	return s.LoadRefresh(token)
+}
+
+func (s *contextTestingStorage) RemoveRefreshContext(ctx context.Context, token string) error {
+	return s.RemoveRefresh(token)
+}
+
+func TestAccessRequestContext(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+	storage := &contextTestingStorage{TestingStorage: NewTestingStorage()}
+	server := NewServer(sconfig, storage)
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+
+	var approved context.Context
+	server.AccessApprover = AccessApproverFunc(func(ctx context.Context, ar *AccessRequest) error {
+		approved = ctx
+		return nil
+	})
+
+	tests := map[string]struct {
+		Override string
+		Expected string
+	}{
+		"request":  {Expected: "request"},
+		"override": {Override: "override", Expected: "override"},
+	}
+
+	for k, test := range tests {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), contextTestKey{}, "request"))
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = url.Values{"grant_type": {string(CLIENT_CREDENTIALS)}}
+		req.PostForm = req.Form
+
+		ar := server.HandleAccessRequest(resp, req)
+		if ar == nil {
+			t.Fatalf("%s: error handling request: %v", k, resp.Output)
+		}
+		if test.Override != "" {
+			ar = ar.WithContext(context.WithValue(context.Background(), contextTestKey{}, test.Override))
+		}
+		ar.Authorized = true
+		server.FinishAccessRequest(resp, req, ar)
+		if resp.IsError {
+			t.Fatalf("%s: error in response: %v", k, resp.Output)
+		}
+
+		if v := approved.Value(contextTestKey{}); v != test.Expected {
+			t.Errorf("%s: expected approver context %s, got %v", k, test.Expected, v)
+		}
+		if v := storage.saved[len(storage.saved)-1].Value(contextTestKey{}); v != test.Expected {
+			t.Errorf("%s: expected storage context %s, got %v", k, test.Expected, v)
+		}
+	}
+}
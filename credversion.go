@@ -0,0 +1,43 @@
+package osin
+
+// CredentialVersionChecker returns the current version of the credentials of
+// a user, like a counter bumped on password changes and admin resets. Tokens
+// record the version at issuance, and are rejected once it changes, killing
+// the existing sessions without scanning the storage.
+type CredentialVersionChecker interface {
+	// CredentialVersion returns the current credential version of the
+	// local subject
+	CredentialVersion(subject string) (string, error)
+}
+
+// CredentialVersionCheckerFunc allows a function to be used as a
+// CredentialVersionChecker
+type CredentialVersionCheckerFunc func(subject string) (string, error)
+
+// CredentialVersion calls f(subject)
+func (f CredentialVersionCheckerFunc) CredentialVersion(subject string) (string, error) {
+	return f(subject)
+}
+
+// credentialVersion returns the current credential version of the subject,
+// blank if there is no CredentialVersionChecker or subject
+func (s *Server) credentialVersion(subject string) (string, error) {
+	if s.CredentialVersionChecker == nil || subject == "" {
+		return "", nil
+	}
+	return s.CredentialVersionChecker.CredentialVersion(subject)
+}
+
+// isCredentialStale returns true if the access data was issued with a
+// credential version other than the current one. Access data without
+// recorded version, issued before the checker was set, is not stale.
+func (s *Server) isCredentialStale(data *AccessData) (bool, error) {
+	if data == nil || data.CredentialVersion == "" {
+		return false, nil
+	}
+	current, err := s.credentialVersion(data.Subject)
+	if err != nil || current == "" {
+		return false, err
+	}
+	return current != data.CredentialVersion, nil
+}
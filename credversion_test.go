@@ -0,0 +1,83 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestCredentialVersion(t *testing.T) {
+	storage := NewTestingStorage()
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{PASSWORD, REFRESH_TOKEN}
+	server := NewServer(sconfig, storage)
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+	versions := map[string]string{"user-1": "1"}
+	server.CredentialVersionChecker = CredentialVersionCheckerFunc(func(subject string) (string, error) {
+		return versions[subject], nil
+	})
+
+	// the version is recorded at issuance
+	resp := server.NewResponse()
+	req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("1234", "aabbccdd")
+	req.Form = make(url.Values)
+	req.Form.Set("grant_type", string(PASSWORD))
+	req.Form.Set("username", "user")
+	req.Form.Set("password", "pass")
+	req.PostForm = make(url.Values)
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		ar.Authorized = true
+		ar.Subject = "user-1"
+		server.FinishAccessRequest(resp, req, ar)
+	}
+	if resp.IsError {
+		t.Fatalf("Unexpected error: %v", resp.Output)
+	}
+	if v := storage.access["1"].CredentialVersion; v != "1" {
+		t.Fatalf("Expected credential version 1, got %q", v)
+	}
+
+	info := func() bool {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer 1")
+		if ir := server.HandleInfoRequest(resp, req); ir != nil {
+			server.FinishInfoRequest(resp, req, ir)
+		}
+		return !resp.IsError
+	}
+	if !info() {
+		t.Fatal("Token with the current credential version should be valid")
+	}
+
+	// a password change invalidates the token and its refresh token
+	versions["user-1"] = "2"
+	if info() {
+		t.Error("Token with a stale credential version should be rejected")
+	}
+
+	resp = server.NewResponse()
+	req, err = http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("1234", "aabbccdd")
+	req.Form = make(url.Values)
+	req.Form.Set("grant_type", string(REFRESH_TOKEN))
+	req.Form.Set("refresh_token", "r1")
+	req.PostForm = make(url.Values)
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		ar.Authorized = true
+		server.FinishAccessRequest(resp, req, ar)
+	}
+	if resp.ErrorId != E_INVALID_GRANT {
+		t.Errorf("Expected %s refreshing a stale token, got %v", E_INVALID_GRANT, resp.Output)
+	}
+}
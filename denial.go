@@ -0,0 +1,58 @@
+package osin
+
+// ConsentDenialAction is the behavior when the user denies consent on the
+// authorize endpoint
+type ConsentDenialAction int
+
+const (
+	// Redirect to the request redirect uri with error=access_denied (default)
+	DENIAL_REDIRECT_ERROR ConsentDenialAction = iota
+
+	// Don't redirect, return an access_denied data response so the
+	// application can show a local page
+	DENIAL_LOCAL_PAGE
+
+	// Redirect to the denial uri registered by the client, with
+	// error=access_denied. Falls back to DENIAL_REDIRECT_ERROR if the
+	// client has none.
+	DENIAL_CLIENT_URI
+)
+
+// HandleConsentDenial allows a ConsentDenialAction to be used directly as a
+// ConsentDenialHandler that always returns itself
+func (a ConsentDenialAction) HandleConsentDenial(ar *AuthorizeRequest) ConsentDenialAction {
+	return a
+}
+
+// ConsentDenialHandler decides the behavior when the user denies consent
+type ConsentDenialHandler interface {
+	HandleConsentDenial(ar *AuthorizeRequest) ConsentDenialAction
+}
+
+// ClientDenialURI is an optional interface clients can implement to register
+// an uri where the user is redirected when consent is denied
+type ClientDenialURI interface {
+	// GetDenialURI returns the denial uri, or blank if none
+	GetDenialURI() string
+}
+
+// finishConsentDenial sets the response for a denied authorize request
+func (s *Server) finishConsentDenial(w *Response, ar *AuthorizeRequest) {
+	action := DENIAL_REDIRECT_ERROR
+	if s.ConsentDenialHandler != nil {
+		action = s.ConsentDenialHandler.HandleConsentDenial(ar)
+	}
+
+	switch action {
+	case DENIAL_LOCAL_PAGE:
+		w.Type = DATA
+		w.URL = ""
+	case DENIAL_CLIENT_URI:
+		if c, ok := ar.Client.(ClientDenialURI); ok && c.GetDenialURI() != "" {
+			w.SetRedirect(c.GetDenialURI())
+			w.SetRedirectFragment(false)
+		}
+	}
+
+	w.SetErrorState(E_ACCESS_DENIED, "", ar.State)
+}
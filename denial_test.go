@@ -0,0 +1,93 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+type clientWithDenialURI struct {
+	DefaultClient
+	DenialUri string
+}
+
+func (c *clientWithDenialURI) GetDenialURI() string { return c.DenialUri }
+
+func TestAuthorizeConsentDenial(t *testing.T) {
+	testcases := map[string]struct {
+		Handler      ConsentDenialHandler
+		ClientID     string
+		ExpectedType ResponseType
+		ExpectedURL  string
+	}{
+		"default": {
+			ClientID:     "1234",
+			ExpectedType: REDIRECT,
+			ExpectedURL:  "http://localhost:14000/appauth?error=access_denied&error_description=The+resource+owner+or+authorization+server+denied+the+request.&state=a",
+		},
+		"local page": {
+			Handler:      DENIAL_LOCAL_PAGE,
+			ClientID:     "1234",
+			ExpectedType: DATA,
+		},
+		"client uri": {
+			Handler:      DENIAL_CLIENT_URI,
+			ClientID:     "denial",
+			ExpectedType: REDIRECT,
+			ExpectedURL:  "http://localhost:14000/denied?error=access_denied&error_description=The+resource+owner+or+authorization+server+denied+the+request.&state=a",
+		},
+		"client uri fallback": {
+			Handler:      DENIAL_CLIENT_URI,
+			ClientID:     "1234",
+			ExpectedType: REDIRECT,
+			ExpectedURL:  "http://localhost:14000/appauth?error=access_denied&error_description=The+resource+owner+or+authorization+server+denied+the+request.&state=a",
+		},
+	}
+
+	for k, tc := range testcases {
+		storage := NewTestingStorage()
+		storage.clients["denial"] = &clientWithDenialURI{
+			DefaultClient: DefaultClient{
+				Id:          "denial",
+				RedirectUri: "http://localhost:14000/appauth",
+			},
+			DenialUri: "http://localhost:14000/denied",
+		}
+		server := NewServer(NewServerConfig(), storage)
+		server.ConsentDenialHandler = tc.Handler
+		resp := server.NewResponse()
+
+		req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Form = make(url.Values)
+		req.Form.Set("response_type", string(CODE))
+		req.Form.Set("client_id", tc.ClientID)
+		req.Form.Set("state", "a")
+
+		if ar := server.HandleAuthorizeRequest(resp, req); ar != nil {
+			ar.Authorized = false
+			server.FinishAuthorizeRequest(resp, req, ar)
+		}
+
+		if !resp.IsError || resp.ErrorId != E_ACCESS_DENIED {
+			t.Errorf("%s: expected access_denied, got %v", k, resp.Output)
+			continue
+		}
+		if resp.Type != tc.ExpectedType {
+			t.Errorf("%s: expected response type %v, got %v", k, tc.ExpectedType, resp.Type)
+			continue
+		}
+		if tc.ExpectedType == REDIRECT {
+			u, err := resp.GetRedirectUrl()
+			if err != nil {
+				t.Errorf("%s: %v", k, err)
+				continue
+			}
+			if u != tc.ExpectedURL {
+				t.Errorf("%s: expected\n\t%v, got\n\t%v", k, tc.ExpectedURL, u)
+			}
+		}
+	}
+}
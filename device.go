@@ -0,0 +1,515 @@
+package osin
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Device authorization grant, as described in https://tools.ietf.org/html/rfc8628
+const (
+	DEVICE_CODE AccessRequestType = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+// DeviceAuthorizationStatus is the user decision on a device authorization
+type DeviceAuthorizationStatus string
+
+const (
+	DEVICE_PENDING  DeviceAuthorizationStatus = "pending"
+	DEVICE_APPROVED DeviceAuthorizationStatus = "approved"
+	DEVICE_DENIED   DeviceAuthorizationStatus = "denied"
+)
+
+// DeviceStorage is an optional interface storages implement to support the
+// device authorization grant
+type DeviceStorage interface {
+	// SaveDeviceAuthorization saves a new or updated device authorization
+	SaveDeviceAuthorization(data *DeviceAuthorizationData) error
+
+	// LoadDeviceAuthorization looks up a device authorization by device code.
+	// Client information MUST be loaded together.
+	LoadDeviceAuthorization(deviceCode string) (*DeviceAuthorizationData, error)
+
+	// LoadDeviceAuthorizationByUserCode looks up a device authorization by
+	// normalized user code. Client information MUST be loaded together.
+	LoadDeviceAuthorizationByUserCode(userCode string) (*DeviceAuthorizationData, error)
+
+	// RemoveDeviceAuthorization deletes a device authorization
+	RemoveDeviceAuthorization(deviceCode string) error
+}
+
+// DeviceAuthorizationData is a pending device authorization
+type DeviceAuthorizationData struct {
+	// Client information
+	Client Client
+
+	// Code the device polls the token endpoint with
+	DeviceCode string
+
+	// Normalized code the user enters on the verification page
+	UserCode string
+
+	// Requested scope
+	Scope string
+
+	// Expiration of the codes in seconds
+	ExpiresIn int32
+
+	// Minimum polling interval in seconds
+	Interval int32
+
+	// Date created
+	CreatedAt time.Time
+
+	// Date of the last token request of the device
+	LastPolledAt time.Time
+
+	// User decision
+	Status DeviceAuthorizationStatus
+
+	// Local subject identifier of the user who approved
+	Subject string
+
+	// Data to be passed to storage. Not used by the library.
+	UserData interface{}
+}
+
+// IsExpiredAt is true if the device authorization expires at time 't'
+func (d *DeviceAuthorizationData) IsExpiredAt(t time.Time) bool {
+	return d.CreatedAt.Add(time.Duration(d.ExpiresIn) * time.Second).Before(t)
+}
+
+// UserCodeGenerator generates user codes like "WDJB-MJHT"
+type UserCodeGenerator struct {
+	// Characters of the codes (default BCDFGHJKLMNPQRSTVWXZ, consonants
+	// without ambiguous characters, as recommended by RFC 8628 section 6.1)
+	Charset string
+
+	// Number of characters (default 8)
+	Length int
+
+	// Characters per group, 0 for no grouping (default 4)
+	GroupSize int
+
+	// Group separator (default "-")
+	Separator string
+}
+
+// NewUserCodeGenerator returns a UserCodeGenerator with default settings
+func NewUserCodeGenerator() *UserCodeGenerator {
+	return &UserCodeGenerator{
+		Charset:   "BCDFGHJKLMNPQRSTVWXZ",
+		Length:    8,
+		GroupSize: 4,
+		Separator: "-",
+	}
+}
+
+// Generate returns a random user code, formatted for display
+func (g *UserCodeGenerator) Generate() (string, error) {
+	code, err := RandomString(g.Charset, g.Length)
+	if err != nil {
+		return "", err
+	}
+	return g.Format(code), nil
+}
+
+// Format groups a normalized user code for display
+func (g *UserCodeGenerator) Format(code string) string {
+	if g.GroupSize <= 0 {
+		return code
+	}
+	var groups []string
+	for len(code) > g.GroupSize {
+		groups = append(groups, code[:g.GroupSize])
+		code = code[g.GroupSize:]
+	}
+	groups = append(groups, code)
+	return strings.Join(groups, g.Separator)
+}
+
+// Normalize converts a user code as typed by the user to its stored form,
+// uppercasing it and dropping the characters not in the charset, like
+// separators and spaces
+func (g *UserCodeGenerator) Normalize(code string) string {
+	code = strings.ToUpper(code)
+	charset := strings.ToUpper(g.Charset)
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(charset, r) {
+			return r
+		}
+		return -1
+	}, code)
+}
+
+// deviceStorage returns the response storage as DeviceStorage, setting an
+// error if it doesn't support the device grant
+func deviceStorage(w *Response) DeviceStorage {
+	ds, ok := storageAs[DeviceStorage](w.Storage)
+	if !ok {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = errors.New("storage does not implement DeviceStorage")
+		return nil
+	}
+	return ds
+}
+
+// getDeviceClient authenticates confidential clients and looks up public
+// ones by client_id
+func (s *Server) getDeviceClient(w *Response, r *http.Request) Client {
+	auth, err := CheckBasicAuth(r)
+	if err != nil {
+		w.SetError(E_INVALID_CLIENT, "failed to check basic oauth client")
+		w.InternalError = err
+		return nil
+	}
+	if auth == nil && s.requestConfig(w).AllowClientSecretInParams && r.Form.Get("client_secret") != "" {
+		auth = &BasicAuth{Username: r.Form.Get("client_id"), Password: r.Form.Get("client_secret")}
+	}
+	if auth != nil {
+		return s.authenticateClient(auth, w, r)
+	}
+
+	clientID := r.Form.Get("client_id")
+	if clientID == "" {
+		w.SetError(E_INVALID_CLIENT, "missing client_id in form body")
+		return nil
+	}
+	client := getClientWithoutSecret(r.Context(), clientID, w.Storage, w)
+	if client != nil && !s.isPublicClient(client) {
+		w.SetError(E_INVALID_CLIENT, "client authentication required")
+		return nil
+	}
+	return client
+}
+
+// DeviceAuthorizationRequest is a device authorization request
+// (https://tools.ietf.org/html/rfc8628#section-3.1)
+type DeviceAuthorizationRequest struct {
+	Client Client
+	Scope  string
+
+	// Set if request is authorized
+	Authorized bool
+
+	// Expiration of the codes in seconds. Change if different from default.
+	Expiration int32
+
+	// Minimum polling interval in seconds. Change if different from default.
+	Interval int32
+
+	// Data to be passed to storage. Not used by the library.
+	UserData interface{}
+
+	// HttpRequest *http.Request for special use
+	HttpRequest *http.Request
+}
+
+// HandleDeviceAuthorizationRequest is the http.HandlerFunc for handling
+// device authorization requests
+func (s *Server) HandleDeviceAuthorizationRequest(w *Response, r *http.Request) *DeviceAuthorizationRequest {
+	w.NoStore = true
+
+	if r.Method != "POST" {
+		w.SetError(E_INVALID_REQUEST, "")
+		w.InternalError = errors.New("Request must be POST")
+		return nil
+	}
+	if err := s.parseForm(w, r); err != nil {
+		w.SetError(E_INVALID_REQUEST, "")
+		w.InternalError = err
+		return nil
+	}
+	if !s.checkQueryCredentials(w, r) {
+		return nil
+	}
+	if !s.requestConfig(w).AllowedAccessTypes.Exists(DEVICE_CODE) {
+		w.SetError(E_UNAUTHORIZED_CLIENT, "the device authorization grant is not allowed")
+		return nil
+	}
+
+	ret := &DeviceAuthorizationRequest{
+		Scope:       r.Form.Get("scope"),
+		Expiration:  s.requestConfig(w).DeviceCodeExpiration,
+		Interval:    s.requestConfig(w).DevicePollInterval,
+		HttpRequest: r,
+	}
+	if ret.Client = s.getDeviceClient(w, r); ret.Client == nil {
+		return nil
+	}
+
+	// apply the client default and maximum scope
+	var ok bool
+	if ret.Scope, ok = s.limitScope(w, ret.Client, ret.Scope, ""); !ok {
+		return nil
+	}
+	return ret
+}
+
+// FinishDeviceAuthorizationRequest generates and stores the device and user
+// codes, and outputs them with the verification uri
+func (s *Server) FinishDeviceAuthorizationRequest(w *Response, r *http.Request, ar *DeviceAuthorizationRequest) {
+	// don't process if is already an error
+	if w.IsError {
+		return
+	}
+	if !ar.Authorized {
+		w.SetError(E_ACCESS_DENIED, "")
+		return
+	}
+	ds := deviceStorage(w)
+	if ds == nil {
+		return
+	}
+
+	ret := &DeviceAuthorizationData{
+		Client:    ar.Client,
+		Scope:     ar.Scope,
+		ExpiresIn: ar.Expiration,
+		Interval:  ar.Interval,
+		CreatedAt: s.Now(),
+		Status:    DEVICE_PENDING,
+		UserData:  ar.UserData,
+	}
+
+	var err error
+	if ret.DeviceCode, err = (TokenFormat{Length: 32}).Generate(); err != nil {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = err
+		return
+	}
+
+	// retry on the unlikely user code collisions
+	var userCode string
+	for i := 0; i < 3 && ret.UserCode == ""; i++ {
+		if userCode, err = s.UserCodeGen.Generate(); err != nil {
+			w.SetError(E_SERVER_ERROR, "")
+			w.InternalError = err
+			return
+		}
+		if existing, _ := ds.LoadDeviceAuthorizationByUserCode(s.UserCodeGen.Normalize(userCode)); existing == nil {
+			ret.UserCode = s.UserCodeGen.Normalize(userCode)
+		}
+	}
+	if ret.UserCode == "" {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = errors.New("failed to generate a unique user code")
+		return
+	}
+
+	if err = ds.SaveDeviceAuthorization(ret); err != nil {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = err
+		return
+	}
+
+	w.Output["device_code"] = ret.DeviceCode
+	w.Output["user_code"] = userCode
+	w.Output["expires_in"] = ret.ExpiresIn
+	w.Output["interval"] = ret.Interval
+	if s.requestConfig(w).DeviceVerificationUri != "" {
+		w.Output["verification_uri"] = s.requestConfig(w).DeviceVerificationUri
+		if u, err := url.Parse(s.requestConfig(w).DeviceVerificationUri); err == nil {
+			q := u.Query()
+			q.Set("user_code", userCode)
+			u.RawQuery = q.Encode()
+			w.Output["verification_uri_complete"] = u.String()
+		}
+	}
+}
+
+// DeviceVerificationRequest is the submission of a user code on the
+// verification page
+type DeviceVerificationRequest struct {
+	// Normalized user code
+	UserCode string
+
+	// Device authorization being verified
+	DeviceAuthorization *DeviceAuthorizationData
+
+	// Set if the user approved the device. Set it after login and consent.
+	Authorized bool
+
+	// Local subject identifier of the user. Set it after login, or set
+	// User instead, like the one returned by Server.AuthenticateUser.
+	Subject string
+	User    *User
+
+	// HttpRequest *http.Request for special use
+	HttpRequest *http.Request
+}
+
+// HandleDeviceVerificationRequest looks up the device authorization of the
+// user_code parameter, for the verification page. Failed lookups are
+// throttled by source IP when the server has a UserCodeLimiter.
+func (s *Server) HandleDeviceVerificationRequest(w *Response, r *http.Request) *DeviceVerificationRequest {
+	if err := s.parseForm(w, r); err != nil {
+		w.SetError(E_INVALID_REQUEST, "")
+		w.InternalError = err
+		return nil
+	}
+	if !s.checkParameterLengths(w, r) {
+		return nil
+	}
+	ds := deviceStorage(w)
+	if ds == nil {
+		return nil
+	}
+
+	key := RemoteIP(r)
+	if s.UserCodeLimiter != nil {
+		if ok, _ := s.UserCodeLimiter.Allow(key); !ok {
+			w.SetError(E_SLOW_DOWN, "too many invalid user codes, try again later")
+			return nil
+		}
+	}
+
+	ret := &DeviceVerificationRequest{
+		UserCode:    s.UserCodeGen.Normalize(r.Form.Get("user_code")),
+		HttpRequest: r,
+	}
+	if ret.UserCode == "" {
+		w.SetError(E_INVALID_REQUEST, "user_code is required")
+		return nil
+	}
+
+	var err error
+	ret.DeviceAuthorization, err = ds.LoadDeviceAuthorizationByUserCode(ret.UserCode)
+	if err != nil && err != ErrNotFound {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = err
+		return nil
+	}
+	if ret.DeviceAuthorization == nil || ret.DeviceAuthorization.IsExpiredAt(s.Now()) ||
+		ret.DeviceAuthorization.Status != DEVICE_PENDING {
+		if s.UserCodeLimiter != nil {
+			s.UserCodeLimiter.Fail(key)
+		}
+		w.SetError(E_INVALID_REQUEST, "invalid or expired user code")
+		return nil
+	}
+	if s.UserCodeLimiter != nil {
+		s.UserCodeLimiter.Reset(key)
+	}
+	return ret
+}
+
+// FinishDeviceVerificationRequest records the user decision, binding the
+// device authorization to the user
+func (s *Server) FinishDeviceVerificationRequest(w *Response, r *http.Request, vr *DeviceVerificationRequest) {
+	// don't process if is already an error
+	if w.IsError {
+		return
+	}
+	ds := deviceStorage(w)
+	if ds == nil {
+		return
+	}
+
+	if vr.Authorized {
+		if vr.User != nil && vr.Subject == "" {
+			vr.Subject = vr.User.Subject
+		}
+		// the user must still exist when known to the server
+		if s.UserStore != nil && vr.User == nil {
+			if _, err := s.UserStore.FindUserBySubject(r.Context(), vr.Subject); err != nil {
+				if errors.Is(err, ErrNotFound) {
+					w.SetError(E_ACCESS_DENIED, "unknown user")
+				} else {
+					w.SetError(E_SERVER_ERROR, "")
+				}
+				w.InternalError = err
+				return
+			}
+		}
+		vr.DeviceAuthorization.Status = DEVICE_APPROVED
+		vr.DeviceAuthorization.Subject = vr.Subject
+	} else {
+		vr.DeviceAuthorization.Status = DEVICE_DENIED
+	}
+	if err := ds.SaveDeviceAuthorization(vr.DeviceAuthorization); err != nil {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = err
+		return
+	}
+	w.Output["status"] = string(vr.DeviceAuthorization.Status)
+}
+
+// handleDeviceCodeRequest handles the device polling the token endpoint
+// (https://tools.ietf.org/html/rfc8628#section-3.4)
+func (s *Server) handleDeviceCodeRequest(w *Response, r *http.Request) *AccessRequest {
+	ds := deviceStorage(w)
+	if ds == nil {
+		return nil
+	}
+
+	ret := &AccessRequest{
+		Type:              DEVICE_CODE,
+		Code:              r.Form.Get("device_code"),
+		GenerateRefresh:   true,
+		Expiration:        s.requestConfig(w).AccessExpirationFor(DEVICE_CODE),
+		RefreshExpiration: s.requestConfig(w).RefreshExpirationFor(DEVICE_CODE),
+		HttpRequest:       r,
+	}
+	if ret.Code == "" {
+		w.SetError(E_INVALID_REQUEST, "device_code is empty")
+		return nil
+	}
+	if ret.Client = s.getDeviceClient(w, r); ret.Client == nil {
+		return nil
+	}
+
+	da, err := ds.LoadDeviceAuthorization(ret.Code)
+	if err != nil && err != ErrNotFound {
+		w.SetError(E_SERVER_ERROR, "failed to load device authorization")
+		w.InternalError = err
+		return nil
+	}
+	if da == nil || da.Client == nil || !CheckClientID(da.Client, ret.Client.GetID()) {
+		w.SetError(E_INVALID_GRANT, "device_code is invalid")
+		return nil
+	}
+
+	now := s.Now()
+	if da.IsExpiredAt(now) {
+		ds.RemoveDeviceAuthorization(da.DeviceCode)
+		w.SetError(E_EXPIRED_TOKEN, "")
+		return nil
+	}
+
+	switch da.Status {
+	case DEVICE_DENIED:
+		ds.RemoveDeviceAuthorization(da.DeviceCode)
+		w.SetError(E_ACCESS_DENIED, "")
+		return nil
+	case DEVICE_APPROVED:
+	default:
+		tooFast := !da.LastPolledAt.IsZero() && now.Sub(da.LastPolledAt) < time.Duration(da.Interval)*time.Second
+		da.LastPolledAt = now
+		if tooFast {
+			// the interval is increased by 5 seconds for all the following
+			// polls, https://www.rfc-editor.org/rfc/rfc8628#section-3.5
+			da.Interval += 5
+		}
+		if err := ds.SaveDeviceAuthorization(da); err != nil {
+			w.SetError(E_SERVER_ERROR, "")
+			w.InternalError = err
+			return nil
+		}
+		if tooFast {
+			w.SetError(E_SLOW_DOWN, "")
+		} else {
+			w.SetError(E_AUTHORIZATION_PENDING, "")
+		}
+		return nil
+	}
+
+	ret.DeviceAuthorization = da
+	ret.Scope = da.Scope
+	ret.Subject = da.Subject
+	ret.UserData = da.UserData
+	ret.RedirectUri = FirstRedirectURI(s.redirectURIs(w, ret.Client))
+	return ret
+}
@@ -0,0 +1,178 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// deviceTestingStorage is a TestingStorage supporting the device grant
+type deviceTestingStorage struct {
+	*TestingStorage
+	devices map[string]*DeviceAuthorizationData
+}
+
+func newDeviceTestingStorage() *deviceTestingStorage {
+	return &deviceTestingStorage{
+		TestingStorage: NewTestingStorage(),
+		devices:        make(map[string]*DeviceAuthorizationData),
+	}
+}
+
+func (s *deviceTestingStorage) Clone() Storage {
+	return s
+}
+
+func (s *deviceTestingStorage) SaveDeviceAuthorization(data *DeviceAuthorizationData) error {
+	s.devices[data.DeviceCode] = data
+	return nil
+}
+
+func (s *deviceTestingStorage) LoadDeviceAuthorization(deviceCode string) (*DeviceAuthorizationData, error) {
+	if d, ok := s.devices[deviceCode]; ok {
+		return d, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *deviceTestingStorage) LoadDeviceAuthorizationByUserCode(userCode string) (*DeviceAuthorizationData, error) {
+	for _, d := range s.devices {
+		if d.UserCode == userCode {
+			return d, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *deviceTestingStorage) RemoveDeviceAuthorization(deviceCode string) error {
+	delete(s.devices, deviceCode)
+	return nil
+}
+
+func TestUserCodeGenerator(t *testing.T) {
+	gen := NewUserCodeGenerator()
+	code, err := gen.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile("^[BCDFGHJKLMNPQRSTVWXZ]{4}-[BCDFGHJKLMNPQRSTVWXZ]{4}$").MatchString(code) {
+		t.Fatalf("Unexpected user code: %s", code)
+	}
+	if n := gen.Normalize("wdjb mjht"); n != "WDJBMJHT" {
+		t.Fatalf("Unexpected normalized code: %s", n)
+	}
+	if f := gen.Format("WDJBMJHT"); f != "WDJB-MJHT" {
+		t.Fatalf("Unexpected formatted code: %s", f)
+	}
+}
+
+func TestDeviceFlow(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{DEVICE_CODE}
+	sconfig.DeviceVerificationUri = "https://example.com/device"
+	storage := newDeviceTestingStorage()
+	server := NewServer(sconfig, storage)
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+	now := time.Now()
+	server.Now = func() time.Time { return now }
+
+	postForm := func(form url.Values) *http.Request {
+		req, err := http.NewRequest("POST", "http://localhost:14000/device", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = form
+		req.PostForm = form
+		return req
+	}
+
+	// device authorization
+	resp := server.NewResponse()
+	req := postForm(url.Values{"scope": {"everything"}})
+	if dar := server.HandleDeviceAuthorizationRequest(resp, req); dar != nil {
+		dar.Authorized = true
+		server.FinishDeviceAuthorizationRequest(resp, req, dar)
+	}
+	if resp.IsError {
+		t.Fatalf("Device authorization should succeed: %v", resp.Output)
+	}
+	deviceCode := resp.Output["device_code"].(string)
+	userCode := resp.Output["user_code"].(string)
+	if resp.Output["verification_uri_complete"] != "https://example.com/device?user_code="+userCode {
+		t.Fatalf("Unexpected verification uri: %v", resp.Output["verification_uri_complete"])
+	}
+
+	poll := func() *Response {
+		resp := server.NewResponse()
+		req := postForm(url.Values{"grant_type": {string(DEVICE_CODE)}, "device_code": {deviceCode}})
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		return resp
+	}
+
+	if resp = poll(); resp.ErrorId != E_AUTHORIZATION_PENDING {
+		t.Fatalf("Expected authorization_pending, got %v", resp.Output)
+	}
+	if resp = poll(); resp.ErrorId != E_SLOW_DOWN {
+		t.Fatalf("Expected slow_down, got %v", resp.Output)
+	}
+
+	// slow_down increases the interval by 5 seconds
+	now = now.Add(6 * time.Second)
+	if resp = poll(); resp.ErrorId != E_SLOW_DOWN {
+		t.Fatalf("Expected slow_down with the increased interval, got %v", resp.Output)
+	}
+	now = now.Add(16 * time.Second)
+	if resp = poll(); resp.ErrorId != E_AUTHORIZATION_PENDING {
+		t.Fatalf("Expected authorization_pending, got %v", resp.Output)
+	}
+
+	// verification page, with a throttled wrong code
+	limiter := NewBackoffRateLimiter()
+	limiter.Threshold = 1
+	limiter.Now = server.Now
+	server.UserCodeLimiter = limiter
+	req, _ = http.NewRequest("GET", "http://localhost:14000/device?user_code=BBBB-BBBB", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	resp = server.NewResponse()
+	if vr := server.HandleDeviceVerificationRequest(resp, req); vr != nil {
+		t.Fatalf("Unknown user code should fail")
+	}
+	req, _ = http.NewRequest("GET", "http://localhost:14000/device?user_code="+url.QueryEscape(userCode), nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	resp = server.NewResponse()
+	if vr := server.HandleDeviceVerificationRequest(resp, req); vr != nil || resp.ErrorId != E_SLOW_DOWN {
+		t.Fatalf("Throttled verification should fail, got %v", resp.Output)
+	}
+
+	req.RemoteAddr = "10.0.0.2:1234"
+	resp = server.NewResponse()
+	vr := server.HandleDeviceVerificationRequest(resp, req)
+	if vr == nil {
+		t.Fatalf("Verification should succeed: %v", resp.Output)
+	}
+	vr.Authorized = true
+	vr.Subject = "user1"
+	server.FinishDeviceVerificationRequest(resp, req, vr)
+	if resp.IsError || resp.Output["status"] != "approved" {
+		t.Fatalf("Unexpected verification result: %v", resp.Output)
+	}
+
+	// tokens
+	now = now.Add(10 * time.Second)
+	resp = poll()
+	if resp.IsError {
+		t.Fatalf("Token request should succeed: %v", resp.Output)
+	}
+	if resp.Output["access_token"] != "1" {
+		t.Fatalf("Unexpected access token: %v", resp.Output["access_token"])
+	}
+	if len(storage.devices) != 0 {
+		t.Fatalf("Device authorization should be removed")
+	}
+}
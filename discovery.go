@@ -0,0 +1,180 @@
+package osin
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// Paths of the metadata documents, relative to the issuer
+const (
+	WELL_KNOWN_OAUTH_METADATA       = "/.well-known/oauth-authorization-server"
+	WELL_KNOWN_OPENID_CONFIGURATION = "/.well-known/openid-configuration"
+)
+
+// DiscoveryConfig holds what the metadata documents advertise that the
+// server can't derive from its configuration, like the endpoint URLs
+type DiscoveryConfig struct {
+	// Issuer identifier, an https URL. Required.
+	Issuer string
+
+	// URLs of the endpoints served. Empty ones are not advertised.
+	AuthorizationEndpoint       string
+	TokenEndpoint               string
+	IntrospectionEndpoint       string
+	RevocationEndpoint          string
+	DeviceAuthorizationEndpoint string
+	RegistrationEndpoint        string
+	UserInfoEndpoint            string
+	JWKSURI                     string
+
+	// Scopes, claims and acr values supported, if advertised
+	ScopesSupported    []string
+	ClaimsSupported    []string
+	ACRValuesSupported []string
+
+	// Human readable documentation of the service
+	ServiceDocumentation string
+
+	// Extra metadata, overriding the generated values
+	Extra map[string]interface{}
+}
+
+// OAuthMetadata returns the authorization server metadata, as described in
+// RFC 8414, from the server configuration and capabilities
+func (s *Server) OAuthMetadata(d *DiscoveryConfig) map[string]interface{} {
+	m := make(map[string]interface{})
+	setString := func(name, value string) {
+		if value != "" {
+			m[name] = value
+		}
+	}
+	setList := func(name string, values []string) {
+		if len(values) > 0 {
+			m[name] = values
+		}
+	}
+
+	setString("issuer", d.Issuer)
+	setString("authorization_endpoint", d.AuthorizationEndpoint)
+	setString("token_endpoint", d.TokenEndpoint)
+	setString("introspection_endpoint", d.IntrospectionEndpoint)
+	setString("revocation_endpoint", d.RevocationEndpoint)
+	setString("device_authorization_endpoint", d.DeviceAuthorizationEndpoint)
+	setString("registration_endpoint", d.RegistrationEndpoint)
+	setString("jwks_uri", d.JWKSURI)
+	setString("service_documentation", d.ServiceDocumentation)
+	setList("scopes_supported", d.ScopesSupported)
+
+	config := s.config()
+	var responseTypes []string
+	for _, t := range config.AllowedAuthorizeTypes {
+		if t == TOKEN && config.DisableImplicit {
+			continue
+		}
+		responseTypes = append(responseTypes, string(t))
+	}
+	m["response_types_supported"] = responseTypes
+	m["response_modes_supported"] = []string{"query", "fragment"}
+
+	var grantTypes []string
+	for _, t := range config.AllowedAccessTypes {
+		grantTypes = append(grantTypes, string(t))
+	}
+	for _, t := range config.AllowedAuthorizeTypes {
+		if t == TOKEN && !config.DisableImplicit {
+			grantTypes = append(grantTypes, "implicit")
+		}
+	}
+	setList("grant_types_supported", grantTypes)
+	if config.AllowedAccessTypes.Exists(PRE_AUTHORIZED_CODE) {
+		m["pre-authorized_grant_anonymous_access_supported"] = config.PreAuthorizedAnonymousClient != ""
+	}
+
+	authMethods := []string{"client_secret_basic"}
+	if config.AllowClientSecretInParams {
+		authMethods = append(authMethods, "client_secret_post")
+	}
+	authMethods = append(authMethods, "none")
+	m["token_endpoint_auth_methods_supported"] = authMethods
+	if d.IntrospectionEndpoint != "" {
+		m["introspection_endpoint_auth_methods_supported"] = authMethods[:len(authMethods)-1]
+	}
+	if d.RevocationEndpoint != "" {
+		m["revocation_endpoint_auth_methods_supported"] = authMethods[:len(authMethods)-1]
+	}
+	m["code_challenge_methods_supported"] = []string{PKCE_PLAIN, PKCE_S256}
+
+	var detailTypes []string
+	for t := range s.AuthorizationDetailValidators {
+		detailTypes = append(detailTypes, t)
+	}
+	sort.Strings(detailTypes)
+	setList("authorization_details_types_supported", detailTypes)
+
+	for k, v := range d.Extra {
+		m[k] = v
+	}
+	return m
+}
+
+// OpenIDConfiguration returns the OpenID Connect discovery document, the
+// OAuthMetadata with the OpenID Connect provider metadata
+func (s *Server) OpenIDConfiguration(d *DiscoveryConfig) map[string]interface{} {
+	m := s.OAuthMetadata(d)
+	if d.UserInfoEndpoint != "" {
+		m["userinfo_endpoint"] = d.UserInfoEndpoint
+	}
+
+	subjectTypes := []string{SUBJECT_PUBLIC}
+	if s.SubjectIdentifierProvider != nil {
+		subjectTypes = append(subjectTypes, SUBJECT_PAIRWISE)
+	}
+	m["subject_types_supported"] = subjectTypes
+
+	algs := []string{"RS256"}
+	if s.KeySet != nil {
+		if a := s.KeySet.Algorithms(); len(a) > 0 {
+			algs = a
+		}
+	}
+	m["id_token_signing_alg_values_supported"] = algs
+	m["request_parameter_supported"] = false
+	m["claims_parameter_supported"] = true
+	m["claim_types_supported"] = []string{"normal", "aggregated", "distributed"}
+
+	if len(d.ClaimsSupported) > 0 {
+		m["claims_supported"] = d.ClaimsSupported
+	}
+	if len(d.ACRValuesSupported) > 0 {
+		m["acr_values_supported"] = d.ACRValuesSupported
+	}
+
+	for k, v := range d.Extra {
+		m[k] = v
+	}
+	return m
+}
+
+// OAuthMetadataHandler serves the OAuthMetadata, to be registered on
+// WELL_KNOWN_OAUTH_METADATA. The document is generated on each request, so
+// it follows the configuration changes.
+func (s *Server) OAuthMetadataHandler(d *DiscoveryConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveMetadata(w, s.OAuthMetadata(d))
+	})
+}
+
+// OpenIDConfigurationHandler serves the OpenIDConfiguration, to be
+// registered on WELL_KNOWN_OPENID_CONFIGURATION
+func (s *Server) OpenIDConfigurationHandler(d *DiscoveryConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveMetadata(w, s.OpenIDConfiguration(d))
+	})
+}
+
+func serveMetadata(w http.ResponseWriter, m map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "max-age=300")
+	json.NewEncoder(w).Encode(m)
+}
@@ -0,0 +1,70 @@
+package osin
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestOpenIDConfiguration(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAuthorizeTypes = AllowedAuthorizeType{CODE, TOKEN}
+	sconfig.AllowedAccessTypes = AllowedAccessType{AUTHORIZATION_CODE, REFRESH_TOKEN}
+	server := NewServer(sconfig, NewTestingStorage())
+	server.KeySet = &KeySet{}
+	if err := server.KeySet.Maintain(); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &DiscoveryConfig{
+		Issuer:                "https://auth.example.com",
+		AuthorizationEndpoint: "https://auth.example.com/authorize",
+		TokenEndpoint:         "https://auth.example.com/token",
+		UserInfoEndpoint:      "https://auth.example.com/userinfo",
+		ClaimsSupported:       []string{"sub", "email"},
+	}
+
+	rec := httptest.NewRecorder()
+	server.OpenIDConfigurationHandler(d).ServeHTTP(rec, httptest.NewRequest("GET", WELL_KNOWN_OPENID_CONFIGURATION, nil))
+	var doc map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[string]interface{}{
+		"issuer":                                "https://auth.example.com",
+		"token_endpoint":                        "https://auth.example.com/token",
+		"userinfo_endpoint":                     "https://auth.example.com/userinfo",
+		"response_types_supported":              []interface{}{"code", "token"},
+		"grant_types_supported":                 []interface{}{"authorization_code", "refresh_token", "implicit"},
+		"subject_types_supported":               []interface{}{"public"},
+		"id_token_signing_alg_values_supported": []interface{}{"ES256"},
+		"claims_supported":                      []interface{}{"sub", "email"},
+	}
+	for k, v := range expect {
+		if !reflect.DeepEqual(doc[k], v) {
+			t.Errorf("%s: expected %v, got %v", k, v, doc[k])
+		}
+	}
+
+	// the OAuth metadata shares the fields, without the OpenID Connect ones
+	meta := server.OAuthMetadata(d)
+	if meta["token_endpoint"] != "https://auth.example.com/token" {
+		t.Errorf("Unexpected metadata token endpoint: %v", meta["token_endpoint"])
+	}
+	if _, ok := meta["subject_types_supported"]; ok {
+		t.Error("OAuth metadata must not have subject_types_supported")
+	}
+
+	// configuration changes are reflected
+	sconfig.DisableImplicit = true
+	server.SubjectIdentifierProvider = &PairwiseSubjectIdentifierProvider{Salt: []byte("salt")}
+	doc2 := server.OpenIDConfiguration(d)
+	if !reflect.DeepEqual(doc2["response_types_supported"], []string{"code"}) {
+		t.Errorf("Unexpected response types: %v", doc2["response_types_supported"])
+	}
+	if !reflect.DeepEqual(doc2["subject_types_supported"], []string{"public", "pairwise"}) {
+		t.Errorf("Unexpected subject types: %v", doc2["subject_types_supported"])
+	}
+}
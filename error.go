@@ -1,5 +1,9 @@
 package osin
 
+import (
+	"net/http"
+)
+
 type DefaultErrorId string
 
 const (
@@ -13,6 +17,33 @@ const (
 	E_UNSUPPORTED_GRANT_TYPE           = "unsupported_grant_type"
 	E_INVALID_GRANT                    = "invalid_grant"
 	E_INVALID_CLIENT                   = "invalid_client"
+
+	// https://tools.ietf.org/html/rfc6750#section-3.1
+	E_INVALID_TOKEN      = "invalid_token"
+	E_INSUFFICIENT_SCOPE = "insufficient_scope"
+
+	// https://tools.ietf.org/html/rfc8628#section-3.5
+	E_AUTHORIZATION_PENDING = "authorization_pending"
+	E_SLOW_DOWN             = "slow_down"
+	E_EXPIRED_TOKEN         = "expired_token"
+
+	// A second authentication factor is required, see MFA_OTP
+	E_MFA_REQUIRED = "mfa_required"
+
+	// https://www.rfc-editor.org/rfc/rfc9396#section-5
+	E_INVALID_AUTHORIZATION_DETAILS = "invalid_authorization_details"
+
+	// https://www.rfc-editor.org/rfc/rfc9470#section-3
+	E_INSUFFICIENT_USER_AUTHENTICATION = "insufficient_user_authentication"
+
+	// https://www.rfc-editor.org/rfc/rfc7591#section-3.2.2
+	E_INVALID_CLIENT_METADATA       = "invalid_client_metadata"
+	E_INVALID_SOFTWARE_STATEMENT    = "invalid_software_statement"
+	E_UNAPPROVED_SOFTWARE_STATEMENT = "unapproved_software_statement"
+
+	// https://www.rfc-editor.org/rfc/rfc9449#section-5
+	E_INVALID_DPOP_PROOF = "invalid_dpop_proof"
+	E_USE_DPOP_NONCE     = "use_dpop_nonce"
 )
 
 var (
@@ -29,6 +60,10 @@ type DefaultErrors struct {
 // http://tools.ietf.org/html/rfc6749#section-4.2.2.1
 // http://tools.ietf.org/html/rfc6749#section-5.2
 // http://tools.ietf.org/html/rfc6749#section-7.2
+// http://tools.ietf.org/html/rfc6750#section-3.1
+// http://tools.ietf.org/html/rfc8628#section-3.5
+// https://tools.ietf.org/html/rfc7591#section-3.2.2
+// https://www.rfc-editor.org/rfc/rfc9449#section-5
 func NewDefaultErrors() *DefaultErrors {
 	r := &DefaultErrors{errormap: make(map[string]string)}
 	r.errormap[E_INVALID_REQUEST] = "The request is missing a required parameter, includes an invalid parameter value, includes a parameter more than once, or is otherwise malformed."
@@ -41,6 +76,19 @@ func NewDefaultErrors() *DefaultErrors {
 	r.errormap[E_UNSUPPORTED_GRANT_TYPE] = "The authorization grant type is not supported by the authorization server."
 	r.errormap[E_INVALID_GRANT] = "The provided authorization grant (e.g., authorization code, resource owner credentials) or refresh token is invalid, expired, revoked, does not match the redirection URI used in the authorization request, or was issued to another client."
 	r.errormap[E_INVALID_CLIENT] = "Client authentication failed (e.g., unknown client, no client authentication included, or unsupported authentication method)."
+	r.errormap[E_INVALID_TOKEN] = "The access token provided is expired, revoked, malformed, or invalid for other reasons."
+	r.errormap[E_INSUFFICIENT_SCOPE] = "The request requires higher privileges than provided by the access token."
+	r.errormap[E_AUTHORIZATION_PENDING] = "The authorization request is still pending as the end user hasn't yet completed the user-interaction steps."
+	r.errormap[E_SLOW_DOWN] = "The authorization request is still pending and polling should continue, but the interval must be increased."
+	r.errormap[E_EXPIRED_TOKEN] = "The device_code has expired, and the device authorization session has concluded."
+	r.errormap[E_MFA_REQUIRED] = "Multi-factor authentication is required."
+	r.errormap[E_INVALID_AUTHORIZATION_DETAILS] = "The authorization details are invalid, of an unknown type, or not allowed for the client."
+	r.errormap[E_INSUFFICIENT_USER_AUTHENTICATION] = "The authentication event associated with the access token does not meet the authentication requirements."
+	r.errormap[E_INVALID_CLIENT_METADATA] = "The value of one of the client metadata fields is invalid."
+	r.errormap[E_INVALID_SOFTWARE_STATEMENT] = "The software statement presented is invalid."
+	r.errormap[E_UNAPPROVED_SOFTWARE_STATEMENT] = "The software statement presented is not approved for use by this authorization server."
+	r.errormap[E_INVALID_DPOP_PROOF] = "The DPoP proof is invalid."
+	r.errormap[E_USE_DPOP_NONCE] = "The DPoP proof must contain the nonce provided by the server in the DPoP-Nonce header."
 	return r
 }
 
@@ -50,3 +98,22 @@ func (e *DefaultErrors) Get(id string) string {
 	}
 	return id
 }
+
+// ErrorStatusMapper returns the HTTP status code of an error response
+type ErrorStatusMapper func(id string) int
+
+// RFCErrorStatus maps errors to the HTTP status codes of RFC 6749 section 5.2
+// and RFC 6750 section 3.1. Unknown errors are 400.
+func RFCErrorStatus(id string) int {
+	switch id {
+	case E_INVALID_CLIENT, E_INVALID_TOKEN, E_INSUFFICIENT_USER_AUTHENTICATION:
+		return http.StatusUnauthorized
+	case E_ACCESS_DENIED, E_INSUFFICIENT_SCOPE:
+		return http.StatusForbidden
+	case E_SERVER_ERROR:
+		return http.StatusInternalServerError
+	case E_TEMPORARILY_UNAVAILABLE:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,58 @@
+package osin
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// ErrorDetailLevel controls how the internal errors of responses are
+// reported, see ServerConfig.ErrorDetailLevel
+type ErrorDetailLevel string
+
+const (
+	// The internal error is neither output nor logged
+	ERROR_DETAIL_HIDDEN ErrorDetailLevel = ""
+
+	// The internal error is appended to error_description, for development
+	ERROR_DETAIL_EXPOSE ErrorDetailLevel = "expose"
+
+	// The internal error is logged, for staging
+	ERROR_DETAIL_LOG ErrorDetailLevel = "log"
+
+	// The internal error is logged with a random reference, returned to
+	// the client in error_reference so reports can be matched to the log
+	ERROR_DETAIL_REFERENCE ErrorDetailLevel = "reference"
+)
+
+// applyErrorDetail reports the internal error of the response according to
+// its ErrorDetailLevel, once
+func (r *Response) applyErrorDetail() {
+	if !r.IsError || r.InternalError == nil || r.errorDetailApplied {
+		return
+	}
+	r.errorDetailApplied = true
+
+	switch r.ErrorDetailLevel {
+	case ERROR_DETAIL_EXPOSE:
+		desc, _ := r.Output["error_description"].(string)
+		if desc != "" {
+			desc += ": "
+		}
+		r.Output["error_description"] = desc + r.InternalError.Error()
+	case ERROR_DETAIL_LOG:
+		logrus.WithFields(logrus.Fields{
+			"error": r.ErrorId,
+		}).Warn("oauth2 error response: ", r.InternalError)
+	case ERROR_DETAIL_REFERENCE:
+		ref, err := RandomString(BASE62_ALPHABET, 16)
+		if err != nil {
+			ref = ""
+		}
+		logrus.WithFields(logrus.Fields{
+			"error":     r.ErrorId,
+			"reference": ref,
+		}).Warn("oauth2 error response: ", r.InternalError)
+		if ref != "" {
+			r.Output["error_reference"] = ref
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package osin
+
+import (
+	"net/http"
+	"time"
+)
+
+// EventType is the type of a server event
+type EventType string
+
+const (
+	// A client authenticated with its secret. Data["secret"] holds the name
+	// of the secret that matched.
+	EVENT_CLIENT_AUTHENTICATED EventType = "client_authenticated"
+
+	// Expired grants were purged from the storage. Data["authorize"],
+	// Data["access"] and Data["refresh"] hold the purged counts.
+	EVENT_GRANTS_PURGED EventType = "grants_purged"
+
+	// A background purge failed. Data["error"] holds the error.
+	EVENT_GRANTS_PURGE_FAILED EventType = "grants_purge_failed"
+
+	// A token was revoked at the revocation endpoint. Data["refresh"] is
+	// true if the revoked token was a refresh token. Also emitted by
+	// Server.RevokeAPIKey, with Data["api_key_id"] holding the key id.
+	EVENT_TOKEN_REVOKED EventType = "token_revoked"
+
+	// An authorization code was issued. Data["subject"] holds the subject
+	// and Data["fingerprint"] the *Fingerprint, if recorded.
+	EVENT_CODE_ISSUED EventType = "code_issued"
+
+	// Tokens were issued. Data["grant_type"] holds the grant type,
+	// Data["subject"] the subject and Data["fingerprint"] the *Fingerprint,
+	// if recorded.
+	EVENT_TOKEN_ISSUED EventType = "token_issued"
+
+	// A client was registered. Not emitted by the server, the application
+	// registering clients emits it with EmitEvent.
+	EVENT_CLIENT_REGISTERED EventType = "client_registered"
+)
+
+// Event is emitted by the server on notable actions, for auditing and
+// notifications
+type Event struct {
+	Type EventType
+	Time time.Time
+
+	// Client involved, if any
+	Client Client
+
+	// HTTP request that caused the event, if any
+	Request *http.Request
+
+	// Event specific data
+	Data map[string]interface{}
+}
+
+// EventListener receives the server events. It is called synchronously,
+// so long running work must be done asynchronously.
+type EventListener interface {
+	HandleEvent(e *Event)
+}
+
+// EventListenerFunc allows a function to be used as an EventListener
+type EventListenerFunc func(e *Event)
+
+// HandleEvent calls f(e)
+func (f EventListenerFunc) HandleEvent(e *Event) {
+	f(e)
+}
+
+// AddEventListener registers a listener for the server events
+func (s *Server) AddEventListener(l EventListener) {
+	s.EventListeners = append(s.EventListeners, l)
+}
+
+// EmitEvent sends an event of the application, like
+// EVENT_CLIENT_REGISTERED, to the listeners of the server events
+func (s *Server) EmitEvent(e *Event) {
+	s.emitEvent(e)
+}
+
+// emitEvent sends the event to all listeners
+func (s *Server) emitEvent(e *Event) {
+	if len(s.EventListeners) == 0 {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = s.Now()
+	}
+	for _, l := range s.EventListeners {
+		l.HandleEvent(e)
+	}
+}
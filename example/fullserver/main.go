@@ -0,0 +1,132 @@
+package main
+
+// A complete authorization server, with login and consent pages.
+// Open url in browser:
+// http://localhost:14000/app
+// and log in with test/test.
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/RangelReale/osin"
+	"github.com/RangelReale/osin/example/webflow"
+	"github.com/RangelReale/osin/osintest"
+)
+
+const redirectUri = "http://localhost:14000/appauth"
+
+func authenticate(login, password string) (string, bool) {
+	if login == "test" && password == "test" {
+		return "user-test", true
+	}
+	return "", false
+}
+
+func main() {
+	storage := osintest.NewStorage()
+	storage.SetClient("1234", &osin.DefaultClient{
+		Id:          "1234",
+		Secret:      "aabbccdd",
+		RedirectUri: redirectUri,
+	})
+
+	sconfig := osin.NewServerConfig()
+	sconfig.AllowedAuthorizeTypes = osin.AllowedAuthorizeType{osin.CODE}
+	sconfig.AllowedAccessTypes = osin.AllowedAccessType{osin.AUTHORIZATION_CODE,
+		osin.REFRESH_TOKEN, osin.PASSWORD, osin.CLIENT_CREDENTIALS}
+	sconfig.RequirePKCEForPublicClients = true
+	server := osin.NewServer(sconfig, storage)
+
+	http.Handle("/authorize", &webflow.AuthorizeHandler{
+		Server:       server,
+		Sessions:     webflow.NewSessions(),
+		Authenticate: authenticate,
+	})
+	http.Handle("/token", &webflow.TokenHandler{Server: server, Authenticate: authenticate})
+	http.Handle("/introspect", &webflow.IntrospectionHandler{Server: server})
+	http.Handle("/revoke", &webflow.RevocationHandler{Server: server})
+
+	// Application home endpoint
+	http.HandleFunc("/app", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html><body>"))
+		w.Write([]byte(fmt.Sprintf("<a href=\"/authorize?response_type=code&client_id=1234&state=xyz&scope=profile+email&redirect_uri=%s\">Login</a><br/>", url.QueryEscape(redirectUri))))
+		w.Write([]byte("</body></html>"))
+	})
+
+	// Application destination: exchanges the code, then introspects,
+	// refreshes and revokes the tokens
+	http.HandleFunc("/appauth", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Write([]byte("<html><body>"))
+		defer w.Write([]byte("</body></html>"))
+
+		if e := r.Form.Get("error"); e != "" {
+			w.Write([]byte(fmt.Sprintf("ERROR: %s<br/>", e)))
+			return
+		}
+		code := r.Form.Get("code")
+		if code == "" {
+			w.Write([]byte("Nothing to do"))
+			return
+		}
+
+		auth := &osin.BasicAuth{Username: "1234", Password: "aabbccdd"}
+		token := make(map[string]interface{})
+		err := post("/token", url.Values{"grant_type": {"authorization_code"}, "redirect_uri": {redirectUri}, "code": {code}}, auth, token)
+		if !show(w, "TOKEN", token, err) {
+			return
+		}
+		at, _ := token["access_token"].(string)
+		rt, _ := token["refresh_token"].(string)
+
+		info := make(map[string]interface{})
+		err = post("/introspect", url.Values{"token": {at}}, auth, info)
+		show(w, "INTROSPECTION", info, err)
+
+		refreshed := make(map[string]interface{})
+		err = post("/token", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {rt}}, auth, refreshed)
+		if !show(w, "REFRESH", refreshed, err) {
+			return
+		}
+
+		rt, _ = refreshed["refresh_token"].(string)
+		revoked := make(map[string]interface{})
+		err = post("/revoke", url.Values{"token": {rt}}, auth, revoked)
+		show(w, "REVOCATION", revoked, err)
+	})
+
+	log.Fatal(http.ListenAndServe(":14000", nil))
+}
+
+// show writes a response of the server, returning false on errors
+func show(w http.ResponseWriter, title string, output map[string]interface{}, err error) bool {
+	if err != nil {
+		w.Write([]byte(fmt.Sprintf("%s ERROR: %s<br/>", title, err)))
+		return false
+	}
+	w.Write([]byte(fmt.Sprintf("%s: %v<br/>", title, output)))
+	_, isError := output["error"]
+	return !isError
+}
+
+// post calls an endpoint of the server, decoding the JSON response
+func post(path string, form url.Values, auth *osin.BasicAuth, output map[string]interface{}) error {
+	req, err := http.NewRequest("POST", "http://localhost:14000"+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(auth.Username, auth.Password)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(&output)
+}
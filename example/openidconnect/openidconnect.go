@@ -151,6 +151,7 @@ func handleAuthorization(w http.ResponseWriter, r *http.Request) {
 		}
 
 		ar.Authorized = true
+		ar.Subject = "id-of-test-user"
 		scopes := make(map[string]bool)
 		for _, s := range strings.Fields(ar.Scope) {
 			scopes[s] = true
@@ -162,15 +163,24 @@ func handleAuthorization(w http.ResponseWriter, r *http.Request) {
 		// The ID Token will be serialized and signed during the code for token exchange.
 		if scopes["openid"] {
 
+			// The subject the client receives depends on its subject type (public or pairwise).
+			sub, err := server.SubjectIdentifier(ar.Client, ar.Subject)
+			if err != nil {
+				resp.SetError(osin.E_SERVER_ERROR, "")
+				resp.InternalError = err
+				osin.OutputJSON(resp, w, r)
+				return
+			}
+
 			// These values would be tied to the end user authorizing the client.
 			now := time.Now()
 			idToken := IDToken{
 				Issuer:     issuer,
-				UserID:     "id-of-test-user",
+				UserID:     sub,
 				ClientID:   ar.Client.GetID(),
 				Expiration: now.Add(time.Hour).Unix(),
 				IssuedAt:   now.Unix(),
-				Nonce:      r.URL.Query().Get("nonce"),
+				Nonce:      ar.Nonce,
 			}
 
 			if scopes["profile"] {
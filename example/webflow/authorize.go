@@ -0,0 +1,93 @@
+package webflow
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/RangelReale/osin"
+)
+
+// AuthenticateFunc checks the credentials of a user, returning its subject
+type AuthenticateFunc func(login, password string) (subject string, ok bool)
+
+// AuthorizeHandler is the authorization endpoint. Users log in, then
+// consent to the client request.
+type AuthorizeHandler struct {
+	Server       *osin.Server
+	Sessions     *Sessions
+	Authenticate AuthenticateFunc
+
+	// Pages - default DefaultTemplates
+	Templates *Templates
+}
+
+func (h *AuthorizeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	resp := h.Server.NewResponse()
+	defer resp.Close()
+
+	if ar := h.Server.HandleAuthorizeRequest(resp, r); ar != nil {
+		if !h.approve(ar, w, r) {
+			// a page was rendered
+			return
+		}
+		h.Server.FinishAuthorizeRequest(resp, r, ar)
+	}
+	logError(resp)
+	osin.OutputJSON(resp, w, r)
+}
+
+// approve logs in the user and asks for consent, returning true when the
+// request is decided
+func (h *AuthorizeHandler) approve(ar *osin.AuthorizeRequest, w http.ResponseWriter, r *http.Request) bool {
+	templates := h.Templates
+	if templates == nil {
+		templates = DefaultTemplates
+	}
+	data := &PageData{
+		Request: ar,
+		Action:  r.URL.Path + "?" + r.URL.RawQuery,
+		Subject: h.Sessions.Subject(r),
+	}
+	step := ""
+	if r.Method == "POST" {
+		step = r.PostForm.Get("step")
+	}
+
+	if data.Subject == "" {
+		if step == "login" {
+			subject, ok := h.Authenticate(r.PostForm.Get("login"), r.PostForm.Get("password"))
+			if ok {
+				if err := h.Sessions.Login(w, subject); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return false
+				}
+				data.Subject = subject
+				templates.render(w, templates.Consent, data)
+				return false
+			}
+			data.Error = "Invalid login or password"
+		}
+		templates.render(w, templates.Login, data)
+		return false
+	}
+
+	if step != "consent" {
+		templates.render(w, templates.Consent, data)
+		return false
+	}
+	ar.Authorized = r.PostForm.Get("consent") == "allow"
+	ar.Subject = data.Subject
+	ar.AuthenticationContext = osin.AuthenticationContext{
+		AMR:      []string{"pwd"},
+		AuthTime: time.Now(),
+	}
+	return true
+}
+
+// logError logs the internal error of a failed response
+func logError(resp *osin.Response) {
+	if resp.IsError && resp.InternalError != nil {
+		log.Printf("ERROR: %s", resp.InternalError)
+	}
+}
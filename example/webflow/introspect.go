@@ -0,0 +1,39 @@
+package webflow
+
+import (
+	"net/http"
+
+	"github.com/RangelReale/osin"
+)
+
+// IntrospectionHandler is the token introspection endpoint
+type IntrospectionHandler struct {
+	Server *osin.Server
+}
+
+func (h *IntrospectionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	resp := h.Server.NewResponse()
+	defer resp.Close()
+
+	if ir := h.Server.HandleIntrospectionRequest(resp, r); ir != nil {
+		h.Server.FinishIntrospectionRequest(resp, r, ir)
+	}
+	logError(resp)
+	osin.OutputJSON(resp, w, r)
+}
+
+// RevocationHandler is the token revocation endpoint
+type RevocationHandler struct {
+	Server *osin.Server
+}
+
+func (h *RevocationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	resp := h.Server.NewResponse()
+	defer resp.Close()
+
+	if rr := h.Server.HandleRevocationRequest(resp, r); rr != nil {
+		h.Server.FinishRevocationRequest(resp, r, rr)
+	}
+	logError(resp)
+	osin.OutputJSON(resp, w, r)
+}
@@ -0,0 +1,77 @@
+package webflow
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SESSION_COOKIE is the cookie holding the login session id
+const SESSION_COOKIE = "osin_session"
+
+// Sessions keeps the logged in users in memory, by session cookie
+type Sessions struct {
+	// Session lifetime - default 1 hour
+	MaxAge time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]session
+}
+
+type session struct {
+	subject   string
+	expiresAt time.Time
+}
+
+// NewSessions creates an empty session store
+func NewSessions() *Sessions {
+	return &Sessions{
+		MaxAge:   time.Hour,
+		sessions: make(map[string]session),
+	}
+}
+
+// Login starts a session of the subject, setting the session cookie
+func (s *Sessions) Login(w http.ResponseWriter, subject string) error {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	id := base64.RawURLEncoding.EncodeToString(b)
+
+	s.mu.Lock()
+	s.sessions[id] = session{subject: subject, expiresAt: time.Now().Add(s.MaxAge)}
+	s.mu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SESSION_COOKIE,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(s.MaxAge / time.Second),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// Subject returns the logged in subject of the request, or "" if none
+func (s *Sessions) Subject(r *http.Request) string {
+	cookie, err := r.Cookie(SESSION_COOKIE)
+	if err != nil {
+		return ""
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[cookie.Value]
+	if !ok {
+		return ""
+	}
+	if time.Now().After(sess.expiresAt) {
+		delete(s.sessions, cookie.Value)
+		return ""
+	}
+	return sess.subject
+}
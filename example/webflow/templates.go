@@ -0,0 +1,84 @@
+// Package webflow has the pieces of a complete osin server: the handlers of
+// each endpoint, and login and consent pages rendered with html/template.
+// See example/fullserver for the wiring.
+package webflow
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/RangelReale/osin"
+)
+
+// TemplateFuncs are the functions available to the templates
+var TemplateFuncs = template.FuncMap{
+	// scopes splits a scope in its values
+	"scopes": func(scope string) []string {
+		return strings.Fields(scope)
+	},
+	// clientID returns the id of a client
+	"clientID": func(client osin.Client) string {
+		if client == nil {
+			return ""
+		}
+		return client.GetID()
+	},
+}
+
+// PageData is the data of the login and consent templates
+type PageData struct {
+	// The authorization request being processed
+	Request *osin.AuthorizeRequest
+
+	// URL the form must post to, keeping the authorization parameters
+	Action string
+
+	// Logged in user, on the consent page
+	Subject string
+
+	// Error message of a failed login
+	Error string
+}
+
+// Templates renders the pages of the authorization endpoint
+type Templates struct {
+	Login   *template.Template
+	Consent *template.Template
+}
+
+// DefaultTemplates are minimal login and consent pages
+var DefaultTemplates = &Templates{
+	Login: template.Must(template.New("login").Funcs(TemplateFuncs).Parse(`<!DOCTYPE html>
+<html><body>
+<h1>Sign in to {{clientID .Request.Client}}</h1>
+{{with .Error}}<p style="color:red">{{.}}</p>{{end}}
+<form action="{{.Action}}" method="POST">
+<input type="hidden" name="step" value="login">
+<label>Login <input type="text" name="login" autofocus></label><br>
+<label>Password <input type="password" name="password"></label><br>
+<input type="submit" value="Sign in">
+</form>
+</body></html>
+`)),
+	Consent: template.Must(template.New("consent").Funcs(TemplateFuncs).Parse(`<!DOCTYPE html>
+<html><body>
+<h1>{{clientID .Request.Client}} wants to access your account</h1>
+<p>Signed in as {{.Subject}}</p>
+{{with scopes .Request.Scope}}<ul>{{range .}}<li>{{.}}</li>{{end}}</ul>{{end}}
+<form action="{{.Action}}" method="POST">
+<input type="hidden" name="step" value="consent">
+<button type="submit" name="consent" value="allow">Allow</button>
+<button type="submit" name="consent" value="deny">Deny</button>
+</form>
+</body></html>
+`)),
+}
+
+func (t *Templates) render(w http.ResponseWriter, tmpl *template.Template, data *PageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := tmpl.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,36 @@
+package webflow
+
+import (
+	"net/http"
+
+	"github.com/RangelReale/osin"
+)
+
+// TokenHandler is the token endpoint. Codes, refresh tokens and client
+// credentials are always approved, the server having checked them; the
+// password grant is approved if Authenticate accepts the user.
+type TokenHandler struct {
+	Server *osin.Server
+
+	// Checks the password grant, which is denied if nil
+	Authenticate AuthenticateFunc
+}
+
+func (h *TokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	resp := h.Server.NewResponse()
+	defer resp.Close()
+
+	if ar := h.Server.HandleAccessRequest(resp, r); ar != nil {
+		switch ar.Type {
+		case osin.AUTHORIZATION_CODE, osin.REFRESH_TOKEN, osin.CLIENT_CREDENTIALS:
+			ar.Authorized = true
+		case osin.PASSWORD:
+			if h.Authenticate != nil {
+				ar.Subject, ar.Authorized = h.Authenticate(ar.Username, ar.Password)
+			}
+		}
+		h.Server.FinishAccessRequest(resp, r, ar)
+	}
+	logError(resp)
+	osin.OutputJSON(resp, w, r)
+}
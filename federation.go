@@ -0,0 +1,619 @@
+package osin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Well-known path of the entity configuration (OpenID Federation 1.0)
+	FEDERATION_CONFIGURATION_PATH = "/.well-known/openid-federation"
+
+	// Content type of entity statements
+	ENTITY_STATEMENT_CONTENT_TYPE = "application/entity-statement+jwt"
+)
+
+var (
+	ErrNoTrustChain = errors.New("no trust chain to a trust anchor")
+)
+
+// EntityStatement is a verified OpenID Federation entity statement
+type EntityStatement struct {
+	Issuer    string
+	Subject   string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+
+	// Federation keys of the subject
+	JWKS JSONWebKeySet
+
+	// Superiors of the subject, in its entity configuration
+	AuthorityHints []string
+
+	// Metadata by entity type, like "openid_relying_party"
+	Metadata map[string]map[string]interface{}
+
+	// Policies applied to the subordinate metadata, by entity type and field
+	MetadataPolicy map[string]map[string]map[string]interface{}
+
+	// All the claims
+	Claims map[string]interface{}
+
+	token *JWT
+}
+
+// parseEntityStatement parses and verifies an entity statement with keys,
+// or with its own keys if nil, as for entity configurations
+func parseEntityStatement(raw string, keys *JSONWebKeySet, now time.Time) (*EntityStatement, error) {
+	t, err := ParseJWT(raw)
+	if err != nil {
+		return nil, err
+	}
+	if typ, _ := t.Header["typ"].(string); typ != "entity-statement+jwt" {
+		return nil, errors.New("entity statement has an invalid typ")
+	}
+	if err = t.ValidateTimes(now, time.Minute); err != nil {
+		return nil, err
+	}
+
+	ret := &EntityStatement{
+		Issuer:  t.StringClaim("iss"),
+		Subject: t.StringClaim("sub"),
+		Claims:  t.Claims,
+		token:   t,
+	}
+	ret.IssuedAt, _ = t.TimeClaim("iat")
+	ret.ExpiresAt, _ = t.TimeClaim("exp")
+	if ret.Issuer == "" || ret.Subject == "" {
+		return nil, errors.New("entity statement requires the iss and sub claims")
+	}
+	if err = remarshal(t.Claims["jwks"], &ret.JWKS); err != nil {
+		return nil, err
+	}
+	if err = remarshal(t.Claims["authority_hints"], &ret.AuthorityHints); err != nil {
+		return nil, err
+	}
+	if err = remarshal(t.Claims["metadata"], &ret.Metadata); err != nil {
+		return nil, err
+	}
+	if err = remarshal(t.Claims["metadata_policy"], &ret.MetadataPolicy); err != nil {
+		return nil, err
+	}
+
+	if keys == nil {
+		keys = &ret.JWKS
+	}
+	if err = keys.VerifyJWT(t); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// FederationEntity publishes the entity configuration of this server
+type FederationEntity struct {
+	// Entity identifier, the https URL of the server
+	EntityID string
+
+	// Federation signing key, as accepted by SignJWT, with its id and algorithm
+	SigningKey interface{}
+	KeyID      string
+	Algorithm  string
+
+	// Published federation keys, including the one of SigningKey
+	JWKS JSONWebKeySet
+
+	// Superiors issuing subordinate statements about this entity
+	AuthorityHints []string
+
+	// Metadata by entity type, like "openid_provider"
+	Metadata map[string]interface{}
+
+	// Validity of the configuration, default 24 hours
+	Lifetime time.Duration
+
+	// Returns the current time, time.Now if nil
+	Now func() time.Time
+}
+
+// EntityConfiguration returns the signed, self-issued entity statement
+func (e *FederationEntity) EntityConfiguration() (string, error) {
+	now := time.Now
+	if e.Now != nil {
+		now = e.Now
+	}
+	lifetime := e.Lifetime
+	if lifetime <= 0 {
+		lifetime = 24 * time.Hour
+	}
+
+	n := now()
+	claims := map[string]interface{}{
+		"iss":  e.EntityID,
+		"sub":  e.EntityID,
+		"iat":  n.Unix(),
+		"exp":  n.Add(lifetime).Unix(),
+		"jwks": e.JWKS,
+	}
+	if len(e.AuthorityHints) > 0 {
+		claims["authority_hints"] = e.AuthorityHints
+	}
+	if len(e.Metadata) > 0 {
+		claims["metadata"] = e.Metadata
+	}
+	header := map[string]interface{}{"alg": e.Algorithm, "typ": "entity-statement+jwt"}
+	if e.KeyID != "" {
+		header["kid"] = e.KeyID
+	}
+	return SignJWTWithHeader(claims, header, e.SigningKey)
+}
+
+// ServeHTTP serves the entity configuration, to be registered on
+// FEDERATION_CONFIGURATION_PATH
+func (e *FederationEntity) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	statement, err := e.EntityConfiguration()
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", ENTITY_STATEMENT_CONTENT_TYPE)
+	io.WriteString(w, statement)
+}
+
+// TrustChain is a verified chain of entity statements from an entity to a
+// trust anchor
+type TrustChain struct {
+	// Entity configuration of the subject first, then the subordinate
+	// statements issued by each superior, and the trust anchor
+	// configuration last
+	Statements []*EntityStatement
+
+	// Entity identifier of the trust anchor
+	TrustAnchor string
+
+	// Earliest expiration of the statements
+	ExpiresAt time.Time
+}
+
+// Metadata returns the subject metadata of an entity type, with the
+// metadata and policies of the superiors applied. The policies are applied
+// in order from the trust anchor, without the combination checks of the
+// specification.
+func (c *TrustChain) Metadata(entityType string) (map[string]interface{}, error) {
+	ret := make(map[string]interface{})
+	for k, v := range c.Statements[0].Metadata[entityType] {
+		ret[k] = v
+	}
+	if len(c.Statements) > 2 {
+		for k, v := range c.Statements[1].Metadata[entityType] {
+			ret[k] = v
+		}
+	}
+	for i := len(c.Statements) - 2; i >= 1; i-- {
+		for field, policy := range c.Statements[i].MetadataPolicy[entityType] {
+			if err := applyMetadataPolicy(ret, field, policy); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ret, nil
+}
+
+// applyMetadataPolicy applies the value, add, default, one_of, subset_of,
+// superset_of and essential operators of a metadata field
+func applyMetadataPolicy(metadata map[string]interface{}, field string, policy map[string]interface{}) error {
+	if v, ok := policy["value"]; ok {
+		if v == nil {
+			delete(metadata, field)
+		} else {
+			metadata[field] = v
+		}
+	}
+	if v, ok := policy["add"]; ok {
+		cur := policyList(metadata[field])
+		for _, a := range policyList(v) {
+			if !policyListContains(cur, a) {
+				cur = append(cur, a)
+			}
+		}
+		metadata[field] = cur
+	}
+	if v, ok := policy["default"]; ok {
+		if _, exists := metadata[field]; !exists {
+			metadata[field] = v
+		}
+	}
+	cur, exists := metadata[field]
+	if v, ok := policy["one_of"]; ok && exists && !policyListContains(policyList(v), cur) {
+		return fmt.Errorf("metadata %s is not one of the allowed values", field)
+	}
+	if v, ok := policy["subset_of"]; ok && exists {
+		allowed := policyList(v)
+		subset := make([]interface{}, 0)
+		for _, c := range policyList(cur) {
+			if policyListContains(allowed, c) {
+				subset = append(subset, c)
+			}
+		}
+		metadata[field] = subset
+	}
+	if v, ok := policy["superset_of"]; ok && exists {
+		values := policyList(metadata[field])
+		for _, s := range policyList(v) {
+			if !policyListContains(values, s) {
+				return fmt.Errorf("metadata %s is missing required values", field)
+			}
+		}
+	}
+	if v, _ := policy["essential"].(bool); v {
+		if _, exists := metadata[field]; !exists {
+			return fmt.Errorf("metadata %s is essential", field)
+		}
+	}
+	return nil
+}
+
+func policyList(v interface{}) []interface{} {
+	switch l := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return l
+	}
+	return []interface{}{v}
+}
+
+func policyListContains(list []interface{}, v interface{}) bool {
+	for _, l := range list {
+		if jsonEqual(l, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// TrustChainResolver resolves and verifies the trust chains of federation
+// entities, following their authority hints up to a configured trust anchor
+type TrustChainResolver struct {
+	// Federation keys of the trust anchors, by entity identifier
+	TrustAnchors map[string]JSONWebKeySet
+
+	// Fetches an entity statement. Uses an HTTP GET with a 10 seconds
+	// timeout if nil.
+	Fetch func(url string) (string, error)
+
+	// Maximum number of intermediates between an entity and a trust
+	// anchor, default 5
+	MaxPathLength int
+
+	// Hosts entity statements may be fetched from, for the entities,
+	// intermediates and trust anchors. Any https host if empty.
+	AllowedHosts []string
+
+	// Time the clients of FederationStorage that failed to resolve are
+	// remembered, not to fetch their statements again meanwhile. Default 5
+	// minutes.
+	FailureTTL time.Duration
+
+	// Returns the current time, time.Now if nil
+	Now func() time.Time
+
+	// client ids that failed to resolve
+	unresolved memoCache
+}
+
+// Resolve returns a verified trust chain of the entity
+func (r *TrustChainResolver) Resolve(entityID string) (*TrustChain, error) {
+	leaf, err := r.fetchConfiguration(entityID)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := r.TrustAnchors[entityID]; ok {
+		return nil, errors.New("trust anchors can't be resolved as subordinates")
+	}
+
+	chain, anchor, err := r.resolveSuperiors(leaf, 0)
+	if err != nil {
+		return nil, err
+	}
+	ret := &TrustChain{
+		Statements:  append([]*EntityStatement{leaf}, chain...),
+		TrustAnchor: anchor,
+	}
+	for _, s := range ret.Statements {
+		if ret.ExpiresAt.IsZero() || s.ExpiresAt.Before(ret.ExpiresAt) {
+			ret.ExpiresAt = s.ExpiresAt
+		}
+	}
+	return ret, nil
+}
+
+// resolveSuperiors returns the statements issued by the superiors of the
+// entity configuration, up to a trust anchor
+func (r *TrustChainResolver) resolveSuperiors(sub *EntityStatement, depth int) ([]*EntityStatement, string, error) {
+	maxPath := r.MaxPathLength
+	if maxPath <= 0 {
+		maxPath = 5
+	}
+
+	for _, hint := range sub.AuthorityHints {
+		superior, err := r.fetchConfiguration(hint)
+		if err != nil {
+			continue
+		}
+		fetchEndpoint, _ := superior.Metadata["federation_entity"]["federation_fetch_endpoint"].(string)
+		if fetchEndpoint == "" {
+			continue
+		}
+		raw, err := r.fetch(fetchEndpoint + "?sub=" + url.QueryEscape(sub.Subject))
+		if err != nil {
+			continue
+		}
+		statement, err := parseEntityStatement(raw, &superior.JWKS, r.now())
+		if err != nil || statement.Issuer != hint || statement.Subject != sub.Subject {
+			continue
+		}
+
+		// the configuration of the subordinate must be signed with the keys
+		// its superior vouches for
+		if statement.JWKS.VerifyJWT(sub.token) != nil {
+			continue
+		}
+
+		if _, ok := r.TrustAnchors[hint]; ok {
+			return []*EntityStatement{statement, superior}, hint, nil
+		}
+		if depth >= maxPath {
+			continue
+		}
+		rest, anchor, err := r.resolveSuperiors(superior, depth+1)
+		if err == nil {
+			return append([]*EntityStatement{statement}, rest...), anchor, nil
+		}
+	}
+	return nil, "", ErrNoTrustChain
+}
+
+// fetchConfiguration fetches and verifies an entity configuration, with the
+// configured keys for trust anchors
+func (r *TrustChainResolver) fetchConfiguration(entityID string) (*EntityStatement, error) {
+	raw, err := r.fetch(strings.TrimRight(entityID, "/") + FEDERATION_CONFIGURATION_PATH)
+	if err != nil {
+		return nil, err
+	}
+	var keys *JSONWebKeySet
+	if anchorKeys, ok := r.TrustAnchors[entityID]; ok {
+		keys = &anchorKeys
+	}
+	ret, err := parseEntityStatement(raw, keys, r.now())
+	if err != nil {
+		return nil, err
+	}
+	if ret.Issuer != entityID || ret.Subject != entityID {
+		return nil, errors.New("entity configuration is not self-issued")
+	}
+	return ret, nil
+}
+
+func (r *TrustChainResolver) fetch(u string) (string, error) {
+	pu, err := url.Parse(u)
+	if err != nil || pu.Scheme != "https" || pu.Host == "" {
+		return "", fmt.Errorf("entity statement url %q is not https", u)
+	}
+	if len(r.AllowedHosts) > 0 && !stringInList(pu.Hostname(), r.AllowedHosts) {
+		return "", fmt.Errorf("entity statement host %q is not allowed", pu.Hostname())
+	}
+	if r.Fetch != nil {
+		return r.Fetch(u)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(u)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching %s returned status %d", u, resp.StatusCode)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// remarshal converts a decoded JSON value to v. Nil values are ignored.
+func remarshal(value interface{}, v interface{}) error {
+	if value == nil {
+		return nil
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (r *TrustChainResolver) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+	return time.Now()
+}
+
+// FederatedClient is a client registered automatically through its trust chain
+type FederatedClient struct {
+	DefaultClient
+
+	// Resolved openid_relying_party metadata
+	Metadata map[string]interface{}
+
+	// Trust chain the client was registered with
+	TrustChain *TrustChain
+}
+
+// ClientSecretMatches implement the ClientSecretMatcher interface. Federated
+// clients have no secret: only clients with token_endpoint_auth_method
+// "none" are accepted without other authentication.
+func (c *FederatedClient) ClientSecretMatches(secret string) bool {
+	method, _ := c.Metadata["token_endpoint_auth_method"].(string)
+	return secret == "" && method == "none"
+}
+
+// FederationStorage is a Storage decorator registering federated clients on
+// their first use: https client ids unknown to the inner storage are resolved
+// through their trust chain, using their openid_relying_party metadata. The
+// request context is passed to the inner storage, and its optional
+// interfaces are found through Unwrap.
+type FederationStorage struct {
+	Storage
+
+	// Resolves the trust chains of the clients
+	Resolver *TrustChainResolver
+
+	// Separator of the client redirect uris, as Config.RedirectUriSeparator
+	RedirectUriSeparator string
+
+	// Optional, persists the automatically registered clients. They should
+	// be resolved again after TrustChain.ExpiresAt.
+	Register func(client *FederatedClient) error
+}
+
+// Clone the storage, sharing the resolver
+func (s *FederationStorage) Clone() Storage {
+	c := *s
+	c.Storage = s.Storage.Clone()
+	return &c
+}
+
+// Unwrap returns the inner storage
+func (s *FederationStorage) Unwrap() Storage {
+	return s.Storage
+}
+
+// GetClient returns the client of the inner storage, or resolves it if the
+// id is an https URL and the resolver has trust anchors. ErrNotFound is
+// returned if it can't be resolved, and for the Resolver.FailureTTL that
+// follows.
+func (s *FederationStorage) GetClient(id string) (Client, error) {
+	return s.GetClientContext(context.Background(), id)
+}
+
+// GetClientContext satisfies ContextStorage
+func (s *FederationStorage) GetClientContext(ctx context.Context, id string) (Client, error) {
+	client, err := storageGetClient(ctx, s.Storage, id)
+	if (err != nil && err != ErrNotFound) || client != nil || !strings.HasPrefix(id, "https://") {
+		return client, err
+	}
+	// nothing is fetched for anyone presenting a client id without a
+	// trust anchor to resolve it with
+	if s.Resolver == nil || len(s.Resolver.TrustAnchors) == 0 {
+		return nil, ErrNotFound
+	}
+	now := s.Resolver.now()
+	if _, failed := s.Resolver.unresolved.get(id, now); failed {
+		return nil, ErrNotFound
+	}
+
+	ret, err := s.resolveClient(id)
+	if err == ErrNotFound {
+		ttl := s.Resolver.FailureTTL
+		if ttl <= 0 {
+			ttl = 5 * time.Minute
+		}
+		s.Resolver.unresolved.put(id, true, now, now.Add(ttl))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.Register != nil {
+		if err = s.Register(ret); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// resolveClient resolves the client through its trust chain. Returns
+// ErrNotFound if it can't be resolved.
+func (s *FederationStorage) resolveClient(id string) (*FederatedClient, error) {
+	chain, err := s.Resolver.Resolve(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	metadata, err := chain.Metadata("openid_relying_party")
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	var uris []string
+	if err = remarshal(metadata["redirect_uris"], &uris); err != nil || len(uris) == 0 {
+		return nil, ErrNotFound
+	}
+
+	redirectUris := make([]RedirectURI, len(uris))
+	for i, u := range uris {
+		redirectUris[i] = RedirectURI{URI: u}
+	}
+
+	// without a separator only the first uri fits in RedirectUri
+	if s.RedirectUriSeparator == "" {
+		uris = uris[:1]
+	}
+
+	ret := &FederatedClient{
+		DefaultClient: DefaultClient{
+			Id:           id,
+			RedirectUri:  strings.Join(uris, s.RedirectUriSeparator),
+			RedirectUris: redirectUris,
+		},
+		Metadata:   metadata,
+		TrustChain: chain,
+	}
+	return ret, nil
+}
+
+// SaveAuthorizeContext satisfies ContextStorage
+func (s *FederationStorage) SaveAuthorizeContext(ctx context.Context, data *AuthorizeData) error {
+	return storageSaveAuthorize(ctx, s.Storage, data)
+}
+
+// LoadAuthorizeContext satisfies ContextStorage
+func (s *FederationStorage) LoadAuthorizeContext(ctx context.Context, code string) (*AuthorizeData, error) {
+	return storageLoadAuthorize(ctx, s.Storage, code)
+}
+
+// RemoveAuthorizeContext satisfies ContextStorage
+func (s *FederationStorage) RemoveAuthorizeContext(ctx context.Context, code string) error {
+	return storageRemoveAuthorize(ctx, s.Storage, code)
+}
+
+// SaveAccessContext satisfies ContextStorage
+func (s *FederationStorage) SaveAccessContext(ctx context.Context, data *AccessData) error {
+	return storageSaveAccess(ctx, s.Storage, data)
+}
+
+// LoadAccessContext satisfies ContextStorage
+func (s *FederationStorage) LoadAccessContext(ctx context.Context, token string) (*AccessData, error) {
+	return storageLoadAccess(ctx, s.Storage, token)
+}
+
+// RemoveAccessContext satisfies ContextStorage
+func (s *FederationStorage) RemoveAccessContext(ctx context.Context, token string) error {
+	return storageRemoveAccess(ctx, s.Storage, token)
+}
+
+// LoadRefreshContext satisfies ContextStorage
+func (s *FederationStorage) LoadRefreshContext(ctx context.Context, token string) (*AccessData, error) {
+	return storageLoadRefresh(ctx, s.Storage, token)
+}
+
+// RemoveRefreshContext satisfies ContextStorage
+func (s *FederationStorage) RemoveRefreshContext(ctx context.Context, token string) error {
+	return storageRemoveRefresh(ctx, s.Storage, token)
+}
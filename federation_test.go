@@ -0,0 +1,170 @@
+package osin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFederationTrustChain(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	newEntity := func(id string) *FederationEntity {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jwk, err := NewJSONWebKey(&key.PublicKey, id+"#1", "ES256")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &FederationEntity{
+			EntityID:   id,
+			SigningKey: key,
+			KeyID:      jwk.Kid,
+			Algorithm:  "ES256",
+			JWKS:       JSONWebKeySet{Keys: []JSONWebKey{jwk}},
+			Now:        func() time.Time { return now },
+		}
+	}
+
+	anchor := newEntity("https://anchor.example.com")
+	anchor.Metadata = map[string]interface{}{
+		"federation_entity": map[string]interface{}{
+			"federation_fetch_endpoint": "https://anchor.example.com/fetch",
+		},
+	}
+	leaf := newEntity("https://rp.example.com")
+	leaf.AuthorityHints = []string{anchor.EntityID}
+	leaf.Metadata = map[string]interface{}{
+		"openid_relying_party": map[string]interface{}{
+			"redirect_uris": []string{"https://rp.example.com/cb"},
+			"grant_types":   []string{"authorization_code", "password"},
+		},
+	}
+
+	anchorConf, err := anchor.EntityConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafConf, err := leaf.EntityConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	subordinate, err := SignJWTWithHeader(map[string]interface{}{
+		"iss":  anchor.EntityID,
+		"sub":  leaf.EntityID,
+		"iat":  now.Unix(),
+		"exp":  now.Add(time.Hour).Unix(),
+		"jwks": leaf.JWKS,
+		"metadata_policy": map[string]interface{}{
+			"openid_relying_party": map[string]interface{}{
+				"grant_types":                map[string]interface{}{"subset_of": []string{"authorization_code", "refresh_token"}},
+				"token_endpoint_auth_method": map[string]interface{}{"default": "none"},
+			},
+		},
+	}, map[string]interface{}{"alg": "ES256", "typ": "entity-statement+jwt", "kid": anchor.KeyID}, anchor.SigningKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	documents := map[string]string{
+		anchor.EntityID + FEDERATION_CONFIGURATION_PATH:                     anchorConf,
+		leaf.EntityID + FEDERATION_CONFIGURATION_PATH:                       leafConf,
+		"https://anchor.example.com/fetch?sub=https%3A%2F%2Frp.example.com": subordinate,
+	}
+	fetches := 0
+	resolver := &TrustChainResolver{
+		TrustAnchors: map[string]JSONWebKeySet{anchor.EntityID: anchor.JWKS},
+		Fetch: func(url string) (string, error) {
+			fetches++
+			if d, ok := documents[url]; ok {
+				return d, nil
+			}
+			return "", errors.New("not found: " + url)
+		},
+		Now: func() time.Time { return now },
+	}
+
+	chain, err := resolver.Resolve(leaf.EntityID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain.Statements) != 3 || chain.TrustAnchor != anchor.EntityID || !chain.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Fatalf("Unexpected chain: %+v", chain)
+	}
+
+	storage := &FederationStorage{Storage: NewTestingStorage(), Resolver: resolver, RedirectUriSeparator: " "}
+	client, err := storage.GetClient(leaf.EntityID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := client.(*FederatedClient)
+	if fc.GetRedirectURI() != "https://rp.example.com/cb" {
+		t.Fatalf("Unexpected redirect uri: %s", fc.GetRedirectURI())
+	}
+	if grants := fc.Metadata["grant_types"].([]interface{}); len(grants) != 1 || grants[0] != "authorization_code" {
+		t.Fatalf("Metadata policy should be applied: %v", fc.Metadata)
+	}
+	if !fc.ClientSecretMatches("") {
+		t.Fatal("Client without secret should use the default auth method none")
+	}
+
+	// unknown clients are fetched once
+	fetches = 0
+	for i := 0; i < 2; i++ {
+		if _, err = storage.GetClient("https://unknown.example.com"); err != ErrNotFound {
+			t.Fatalf("Unknown client should not be found: %v", err)
+		}
+	}
+	if fetches != 1 {
+		t.Fatalf("Failed lookups should be remembered, got %d fetches", fetches)
+	}
+
+	// hosts not allowed are not fetched
+	resolver.AllowedHosts = []string{"anchor.example.com"}
+	fetches = 0
+	if _, err = storage.GetClient("https://rp2.example.com"); err != ErrNotFound || fetches != 0 {
+		t.Fatalf("Host not allowed should not be fetched: %v, %d fetches", err, fetches)
+	}
+	resolver.AllowedHosts = nil
+
+	// untrusted anchor
+	resolver.TrustAnchors = map[string]JSONWebKeySet{"https://other.example.com": anchor.JWKS}
+	if _, err = storage.GetClient(leaf.EntityID); err != ErrNotFound {
+		t.Fatalf("Client without trust chain should not be found: %v", err)
+	}
+
+	// nothing is fetched without trust anchors
+	resolver.TrustAnchors = nil
+	fetches = 0
+	if _, err = storage.GetClient("https://rp3.example.com"); err != ErrNotFound || fetches != 0 {
+		t.Fatalf("Client should not be fetched without trust anchors: %v, %d fetches", err, fetches)
+	}
+}
+
+func TestFederationEntityServeHTTP(t *testing.T) {
+	e := &FederationEntity{EntityID: "https://op.example.com", SigningKey: []byte("key"), Algorithm: "HS256"}
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest("GET", FEDERATION_CONFIGURATION_PATH, nil))
+	if w.Code != 200 || w.Header().Get("Content-Type") != ENTITY_STATEMENT_CONTENT_TYPE {
+		t.Fatalf("Unexpected response: %d %v", w.Code, w.Header())
+	}
+	jwt, err := ParseJWT(w.Body.String())
+	if err != nil || jwt.StringClaim("sub") != "https://op.example.com" {
+		t.Fatalf("Unexpected entity configuration: %v", err)
+	}
+}
+
+func TestFederationStorageOptionalInterfaces(t *testing.T) {
+	var storage Storage = &FederationStorage{Storage: newDeviceTestingStorage()}
+	if _, ok := storageAs[DeviceStorage](storage); !ok {
+		t.Errorf("DeviceStorage of the inner storage should be found")
+	}
+	if _, ok := storage.(ContextStorage); !ok {
+		t.Errorf("FederationStorage should pass the context through")
+	}
+}
@@ -0,0 +1,35 @@
+package osin
+
+import (
+	"net/http"
+)
+
+// Fingerprint identifies the HTTP client a grant was issued to, to tell
+// the user which device a session belongs to
+type Fingerprint struct {
+	// Source IP and user agent of the issuing request
+	IP        string
+	UserAgent string
+
+	// Location of the IP, if the server has a GeoLocator
+	Geo *GeoLocation `json:",omitempty"`
+}
+
+// fingerprint returns the fingerprint of the request, if
+// Config.RecordFingerprint is set. The location is left out if the
+// GeoLocator fails, not to fail the grant.
+func (s *Server) fingerprint(w *Response, r *http.Request) *Fingerprint {
+	if !s.requestConfig(w).RecordFingerprint || r == nil {
+		return nil
+	}
+	ret := &Fingerprint{
+		IP:        RemoteIP(r),
+		UserAgent: r.UserAgent(),
+	}
+	if s.GeoLocator != nil && ret.IP != "" {
+		if geo, err := s.GeoLocator(ret.IP); err == nil {
+			ret.Geo = geo
+		}
+	}
+	return ret
+}
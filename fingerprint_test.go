@@ -0,0 +1,83 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	storage := NewTestingStorage()
+	sconfig := NewServerConfig()
+	sconfig.RecordFingerprint = true
+	server := NewServer(sconfig, storage)
+	server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+	server.GeoLocator = func(ip string) (*GeoLocation, error) {
+		return &GeoLocation{Country: "BR"}, nil
+	}
+	events := make(map[EventType]*Event)
+	server.AddEventListener(EventListenerFunc(func(e *Event) {
+		events[e.Type] = e
+	}))
+
+	resp := server.NewResponse()
+	req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "10.0.0.1:5000"
+	req.Header.Set("User-Agent", "browser")
+	req.Form = make(url.Values)
+	req.Form.Set("response_type", string(CODE))
+	req.Form.Set("client_id", "1234")
+	if ar := server.HandleAuthorizeRequest(resp, req); ar != nil {
+		ar.Authorized = true
+		server.FinishAuthorizeRequest(resp, req, ar)
+	}
+	if resp.IsError {
+		t.Fatalf("Unexpected authorize error: %v", resp.Output)
+	}
+	if fp := storage.authorize["1"].Fingerprint; fp == nil || fp.IP != "10.0.0.1" || fp.UserAgent != "browser" || fp.Geo.Country != "BR" {
+		t.Errorf("Unexpected code fingerprint: %+v", fp)
+	}
+	if e := events[EVENT_CODE_ISSUED]; e == nil || e.Data["fingerprint"] != storage.authorize["1"].Fingerprint {
+		t.Errorf("Code issued event without fingerprint: %+v", e)
+	}
+
+	resp = server.NewResponse()
+	req, err = http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "10.0.0.2:5000"
+	req.Header.Set("User-Agent", "backend")
+	req.SetBasicAuth("1234", "aabbccdd")
+	req.Form = make(url.Values)
+	req.Form.Set("grant_type", string(AUTHORIZATION_CODE))
+	req.Form.Set("code", "1")
+	req.PostForm = make(url.Values)
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		ar.Authorized = true
+		server.FinishAccessRequest(resp, req, ar)
+	}
+	if resp.IsError {
+		t.Fatalf("Unexpected access error: %v", resp.Output)
+	}
+	ad := storage.access["1"]
+	if fp := ad.Fingerprint; fp == nil || fp.IP != "10.0.0.2" || fp.UserAgent != "backend" {
+		t.Errorf("Unexpected access fingerprint: %+v", fp)
+	}
+	if fp := ad.AuthorizeData.Fingerprint; fp == nil || fp.IP != "10.0.0.1" {
+		t.Errorf("The code fingerprint should be kept: %+v", fp)
+	}
+	if e := events[EVENT_TOKEN_ISSUED]; e == nil || e.Data["grant_type"] != AUTHORIZATION_CODE || e.Data["fingerprint"] != ad.Fingerprint {
+		t.Errorf("Unexpected token issued event: %+v", e)
+	}
+
+	// not recorded by default
+	sconfig.RecordFingerprint = false
+	if fp := server.fingerprint(server.NewResponse(), req); fp != nil {
+		t.Errorf("Fingerprint recorded without Config.RecordFingerprint: %+v", fp)
+	}
+}
@@ -0,0 +1,89 @@
+package grpcadapter
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+
+	"github.com/RangelReale/osin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Credentials are the client credentials of a gRPC call
+type Credentials struct {
+	ClientId     string
+	ClientSecret string
+}
+
+type credentialsKey struct{}
+
+// CredentialsFromContext returns the client credentials authenticated by
+// AuthInterceptor, or nil
+func CredentialsFromContext(ctx context.Context) *Credentials {
+	c, _ := ctx.Value(credentialsKey{}).(*Credentials)
+	return c
+}
+
+// credentialsFromMetadata reads the client credentials of the incoming
+// metadata, from "authorization" (Basic) or "client_id" and "client_secret"
+func credentialsFromMetadata(ctx context.Context) *Credentials {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+	if v := md.Get("authorization"); len(v) > 0 {
+		s := strings.SplitN(v[0], " ", 2)
+		if len(s) != 2 || !strings.EqualFold(s[0], "Basic") {
+			return nil
+		}
+		b, err := base64.StdEncoding.DecodeString(s[1])
+		if err != nil {
+			return nil
+		}
+		pair := strings.SplitN(string(b), ":", 2)
+		if len(pair) != 2 {
+			return nil
+		}
+		return &Credentials{ClientId: pair[0], ClientSecret: pair[1]}
+	}
+	if v := md.Get("client_id"); len(v) > 0 {
+		c := &Credentials{ClientId: v[0]}
+		if s := md.Get("client_secret"); len(s) > 0 {
+			c.ClientSecret = s[0]
+		}
+		return c
+	}
+	return nil
+}
+
+// AuthInterceptor authenticates the clients calling the TokenService
+// against the server storage, refusing unauthenticated calls. Calls to
+// other services pass through.
+func AuthInterceptor(server *osin.Server) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !strings.HasPrefix(info.FullMethod, "/osin.v1.TokenService/") {
+			return handler(ctx, req)
+		}
+
+		creds := credentialsFromMetadata(ctx)
+		if creds == nil {
+			return nil, status.Error(codes.Unauthenticated, "missing client credentials")
+		}
+		storage := server.Storage.Clone()
+		defer storage.Close()
+		client, err := storage.GetClient(creds.ClientId)
+		if err != nil && err != osin.ErrNotFound {
+			return nil, status.Error(codes.Internal, "failed to get client")
+		}
+		if client == nil {
+			return nil, status.Error(codes.Unauthenticated, "invalid client credentials")
+		}
+		if _, ok := osin.MatchClientSecret(client, creds.ClientSecret, server.Now()); !ok {
+			return nil, status.Error(codes.Unauthenticated, "invalid client credentials")
+		}
+		return handler(context.WithValue(ctx, credentialsKey{}, creds), req)
+	}
+}
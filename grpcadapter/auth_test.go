@@ -0,0 +1,106 @@
+package grpcadapter
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/RangelReale/osin"
+	"github.com/RangelReale/osin/osintest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func newServer() (*osin.Server, *osintest.Storage) {
+	storage := osintest.NewStorage()
+	storage.SetClient("1234", &osin.DefaultClient{Id: "1234", Secret: "aabbccdd", RedirectUri: "http://localhost:14000/appauth"})
+	sconfig := osin.NewServerConfig()
+	sconfig.AllowedAccessTypes = osin.AllowedAccessType{osin.CLIENT_CREDENTIALS, osin.PASSWORD}
+	return osin.NewServer(sconfig, storage), storage
+}
+
+func basicAuth(id, secret string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(id+":"+secret))
+}
+
+func TestAuthInterceptor(t *testing.T) {
+	server, storage := newServer()
+	interceptor := AuthInterceptor(server)
+
+	testcases := map[string]struct {
+		Method   string
+		Metadata metadata.MD
+		Fail     error
+		Code     codes.Code
+		ClientId string
+	}{
+		"basic auth": {
+			Metadata: metadata.Pairs("authorization", basicAuth("1234", "aabbccdd")),
+			ClientId: "1234",
+		},
+		"metadata credentials": {
+			Metadata: metadata.Pairs("client_id", "1234", "client_secret", "aabbccdd"),
+			ClientId: "1234",
+		},
+		"no credentials": {
+			Code: codes.Unauthenticated,
+		},
+		"not basic auth": {
+			Metadata: metadata.Pairs("authorization", "Bearer 1234"),
+			Code:     codes.Unauthenticated,
+		},
+		"malformed basic auth": {
+			Metadata: metadata.Pairs("authorization", "Basic !!!"),
+			Code:     codes.Unauthenticated,
+		},
+		"wrong secret": {
+			Metadata: metadata.Pairs("authorization", basicAuth("1234", "wrong")),
+			Code:     codes.Unauthenticated,
+		},
+		"unknown client": {
+			Metadata: metadata.Pairs("client_id", "5678", "client_secret", "aabbccdd"),
+			Code:     codes.Unauthenticated,
+		},
+		"storage failure": {
+			Metadata: metadata.Pairs("authorization", basicAuth("1234", "aabbccdd")),
+			Fail:     errors.New("connection refused"),
+			Code:     codes.Internal,
+		},
+		"other service": {
+			Method: "/grpc.health.v1.Health/Check",
+		},
+	}
+
+	for k, tc := range testcases {
+		storage.FailWith("GetClient", tc.Fail)
+		ctx := context.Background()
+		if tc.Metadata != nil {
+			ctx = metadata.NewIncomingContext(ctx, tc.Metadata)
+		}
+		method := tc.Method
+		if method == "" {
+			method = "/osin.v1.TokenService/Token"
+		}
+
+		var creds *Credentials
+		called := false
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			called = true
+			creds = CredentialsFromContext(ctx)
+			return nil, nil
+		})
+		if status.Code(err) != tc.Code {
+			t.Errorf("%s: expected %v, got %v", k, tc.Code, err)
+			continue
+		}
+		if called != (tc.Code == codes.OK) {
+			t.Errorf("%s: handler called %v", k, called)
+		}
+		if tc.ClientId != "" && (creds == nil || creds.ClientId != tc.ClientId) {
+			t.Errorf("%s: unexpected credentials %+v", k, creds)
+		}
+	}
+}
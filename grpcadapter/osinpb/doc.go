@@ -0,0 +1,6 @@
+// Package osinpb holds the protobuf definitions of the osin gRPC token
+// service. The Go code is generated from token.proto with protoc,
+// protoc-gen-go and protoc-gen-go-grpc.
+package osinpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative token.proto
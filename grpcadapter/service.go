@@ -0,0 +1,209 @@
+// Package grpcadapter exposes token issuance, introspection and revocation
+// of an osin server as a gRPC service, for services that don't speak HTTP
+// form encoding. The calls run the same handlers as the HTTP endpoints.
+package grpcadapter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/RangelReale/osin"
+	"github.com/RangelReale/osin/grpcadapter/osinpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Service implements osinpb.TokenServiceServer with an osin server. Install
+// AuthInterceptor to authenticate the calling clients.
+type Service struct {
+	osinpb.UnimplementedTokenServiceServer
+
+	Server *osin.Server
+
+	// Decides whether tokens are issued. If nil, only the authorization
+	// code, refresh token and client credentials grants, fully verified by
+	// the server, are authorized. The server AccessApprover, if any, still
+	// has the final say.
+	Approve func(ctx context.Context, ar *osin.AccessRequest) bool
+}
+
+// NewService creates a new service backed by the server
+func NewService(server *osin.Server) *Service {
+	return &Service{Server: server}
+}
+
+// newRequest builds the HTTP form request the server handlers receive
+func newRequest(ctx context.Context, form url.Values) (*http.Request, error) {
+	creds := CredentialsFromContext(ctx)
+	if creds == nil {
+		return nil, status.Error(codes.Unauthenticated, "missing client credentials")
+	}
+	r, err := http.NewRequestWithContext(ctx, "POST", "/", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth(creds.ClientId, creds.ClientSecret)
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		r.RemoteAddr = p.Addr.String()
+	}
+	return r, nil
+}
+
+// responseError converts an error response to a gRPC status
+func responseError(w *osin.Response) error {
+	code := codes.FailedPrecondition
+	switch w.ErrorId {
+	case osin.E_INVALID_CLIENT:
+		code = codes.Unauthenticated
+	case osin.E_UNAUTHORIZED_CLIENT, osin.E_ACCESS_DENIED:
+		code = codes.PermissionDenied
+	case osin.E_INVALID_REQUEST, osin.E_INVALID_SCOPE, osin.E_UNSUPPORTED_GRANT_TYPE:
+		code = codes.InvalidArgument
+	case osin.E_SERVER_ERROR:
+		code = codes.Internal
+	case osin.E_TEMPORARILY_UNAVAILABLE:
+		code = codes.Unavailable
+	}
+	msg := w.ErrorId
+	if desc, ok := w.Output["error_description"].(string); ok && desc != "" {
+		msg += ": " + desc
+	}
+	return status.Error(code, msg)
+}
+
+// toStruct converts response fields to a protobuf struct, through JSON
+func toStruct(fields map[string]interface{}) (*structpb.Struct, error) {
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err = json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(m)
+}
+
+// Token issues tokens, running HandleAccessRequest and FinishAccessRequest
+func (s *Service) Token(ctx context.Context, req *osinpb.TokenRequest) (*osinpb.TokenResponse, error) {
+	form := make(url.Values)
+	for k, v := range req.GetParameters() {
+		form.Set(k, v)
+	}
+	form.Set("grant_type", req.GetGrantType())
+	if req.GetScope() != "" {
+		form.Set("scope", req.GetScope())
+	}
+	r, err := newRequest(ctx, form)
+	if err != nil {
+		return nil, err
+	}
+
+	w := s.Server.NewResponse()
+	defer w.Close()
+	if ar := s.Server.HandleAccessRequest(w, r); ar != nil {
+		ar.SkipSetCookie = true
+		if s.Approve != nil {
+			ar.Authorized = s.Approve(ctx, ar)
+		} else {
+			switch ar.Type {
+			case osin.AUTHORIZATION_CODE, osin.REFRESH_TOKEN, osin.CLIENT_CREDENTIALS:
+				ar.Authorized = true
+			}
+		}
+		s.Server.FinishAccessRequest(w, r, ar)
+	}
+	if w.IsError {
+		return nil, responseError(w)
+	}
+
+	ret := &osinpb.TokenResponse{}
+	extra := make(map[string]interface{})
+	for k, v := range w.Output {
+		switch k {
+		case "access_token":
+			ret.AccessToken, _ = v.(string)
+		case "token_type":
+			ret.TokenType, _ = v.(string)
+		case "expires_in":
+			ret.ExpiresIn, _ = v.(int32)
+		case "refresh_token":
+			ret.RefreshToken, _ = v.(string)
+		case "refresh_expires_in":
+		case "scope":
+			ret.Scope, _ = v.(string)
+		default:
+			extra[k] = v
+		}
+	}
+	if len(extra) > 0 {
+		if ret.Extra, err = toStruct(extra); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	return ret, nil
+}
+
+// Introspect describes a token, running HandleIntrospectionRequest and
+// FinishIntrospectionRequest
+func (s *Service) Introspect(ctx context.Context, req *osinpb.IntrospectRequest) (*osinpb.IntrospectResponse, error) {
+	r, err := newRequest(ctx, url.Values{
+		"token":           {req.GetToken()},
+		"token_type_hint": {req.GetTokenTypeHint()},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	w := s.Server.NewResponse()
+	defer w.Close()
+	if ir := s.Server.HandleIntrospectionRequest(w, r); ir != nil {
+		s.Server.FinishIntrospectionRequest(w, r, ir)
+	}
+	if w.IsError {
+		return nil, responseError(w)
+	}
+
+	ret := &osinpb.IntrospectResponse{}
+	ret.Active, _ = w.Output["active"].(bool)
+	fields := make(map[string]interface{})
+	for k, v := range w.Output {
+		if k != "active" {
+			fields[k] = v
+		}
+	}
+	if len(fields) > 0 {
+		if ret.Fields, err = toStruct(fields); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	return ret, nil
+}
+
+// Revoke revokes a token, running HandleRevocationRequest and
+// FinishRevocationRequest
+func (s *Service) Revoke(ctx context.Context, req *osinpb.RevokeRequest) (*osinpb.RevokeResponse, error) {
+	r, err := newRequest(ctx, url.Values{
+		"token":           {req.GetToken()},
+		"token_type_hint": {req.GetTokenTypeHint()},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	w := s.Server.NewResponse()
+	defer w.Close()
+	if rr := s.Server.HandleRevocationRequest(w, r); rr != nil {
+		s.Server.FinishRevocationRequest(w, r, rr)
+	}
+	if w.IsError {
+		return nil, responseError(w)
+	}
+	return &osinpb.RevokeResponse{}, nil
+}
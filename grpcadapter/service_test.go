@@ -0,0 +1,150 @@
+package grpcadapter
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/RangelReale/osin"
+	"github.com/RangelReale/osin/grpcadapter/osinpb"
+	"github.com/RangelReale/osin/osintest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// authenticated returns the context of a call authenticated by
+// AuthInterceptor
+func authenticated(id, secret string) context.Context {
+	return context.WithValue(context.Background(), credentialsKey{}, &Credentials{ClientId: id, ClientSecret: secret})
+}
+
+func TestServiceToken(t *testing.T) {
+	server, _ := newServer()
+	service := NewService(server)
+	ctx := authenticated("1234", "aabbccdd")
+
+	resp, err := service.Token(ctx, &osinpb.TokenRequest{GrantType: string(osin.CLIENT_CREDENTIALS)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.AccessToken == "" || resp.TokenType != "Bearer" || resp.ExpiresIn != 3600 {
+		t.Fatalf("Unexpected token response %+v", resp)
+	}
+
+	testcases := map[string]struct {
+		Ctx     context.Context
+		Request *osinpb.TokenRequest
+		Approve func(ctx context.Context, ar *osin.AccessRequest) bool
+		Code    codes.Code
+	}{
+		"not authenticated": {
+			Ctx:     context.Background(),
+			Request: &osinpb.TokenRequest{GrantType: string(osin.CLIENT_CREDENTIALS)},
+			Code:    codes.Unauthenticated,
+		},
+		"invalid client": {
+			Ctx:     authenticated("1234", "wrong"),
+			Request: &osinpb.TokenRequest{GrantType: string(osin.CLIENT_CREDENTIALS)},
+			Code:    codes.Unauthenticated,
+		},
+		"unsupported grant": {
+			Request: &osinpb.TokenRequest{GrantType: string(osin.AUTHORIZATION_CODE)},
+			Code:    codes.InvalidArgument,
+		},
+		"password not approved by default": {
+			Request: &osinpb.TokenRequest{
+				GrantType:  string(osin.PASSWORD),
+				Parameters: map[string]string{"username": "user", "password": "pass"},
+			},
+			Code: codes.PermissionDenied,
+		},
+		"password approved": {
+			Request: &osinpb.TokenRequest{
+				GrantType:  string(osin.PASSWORD),
+				Parameters: map[string]string{"username": "user", "password": "pass"},
+			},
+			Approve: func(ctx context.Context, ar *osin.AccessRequest) bool {
+				return ar.Username == "user" && ar.Password == "pass"
+			},
+		},
+	}
+
+	for k, tc := range testcases {
+		if tc.Ctx == nil {
+			tc.Ctx = ctx
+		}
+		service.Approve = tc.Approve
+		_, err := service.Token(tc.Ctx, tc.Request)
+		if status.Code(err) != tc.Code {
+			t.Errorf("%s: expected %v, got %v", k, tc.Code, err)
+		}
+	}
+}
+
+func TestServiceIntrospectRevoke(t *testing.T) {
+	server, storage := newServer()
+	service := NewService(server)
+	ctx := authenticated("1234", "aabbccdd")
+	client, _ := storage.GetClient("1234")
+	access, err := osintest.MintAccessToken(storage, &osin.AccessData{Client: client, Scope: "everything"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := service.Introspect(ctx, &osinpb.IntrospectRequest{Token: access.AccessToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Active || resp.Fields.GetFields()["client_id"].GetStringValue() != "1234" ||
+		resp.Fields.GetFields()["scope"].GetStringValue() != "everything" {
+		t.Fatalf("Unexpected introspection %v", resp)
+	}
+
+	if _, err = service.Introspect(ctx, &osinpb.IntrospectRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument without token, got %v", err)
+	}
+	if _, err = service.Introspect(context.Background(), &osinpb.IntrospectRequest{Token: access.AccessToken}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Expected Unauthenticated, got %v", err)
+	}
+
+	storage.FailNext("LoadAccess", errors.New("connection refused"))
+	if _, err = service.Introspect(ctx, &osinpb.IntrospectRequest{Token: access.AccessToken}); status.Code(err) != codes.Internal {
+		t.Fatalf("Expected Internal on storage failure, got %v", err)
+	}
+
+	if _, err = service.Revoke(ctx, &osinpb.RevokeRequest{Token: access.AccessToken}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err = service.Introspect(ctx, &osinpb.IntrospectRequest{Token: access.AccessToken}); err != nil || resp.Active {
+		t.Fatalf("Revoked token should be inactive, got %v, %v", resp, err)
+	}
+	if _, err = service.Revoke(authenticated("1234", "wrong"), &osinpb.RevokeRequest{Token: access.AccessToken}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Expected Unauthenticated, got %v", err)
+	}
+}
+
+func TestResponseError(t *testing.T) {
+	testcases := map[string]codes.Code{
+		osin.E_INVALID_CLIENT:          codes.Unauthenticated,
+		osin.E_UNAUTHORIZED_CLIENT:     codes.PermissionDenied,
+		osin.E_ACCESS_DENIED:           codes.PermissionDenied,
+		osin.E_INVALID_REQUEST:         codes.InvalidArgument,
+		osin.E_INVALID_SCOPE:           codes.InvalidArgument,
+		osin.E_UNSUPPORTED_GRANT_TYPE:  codes.InvalidArgument,
+		osin.E_SERVER_ERROR:            codes.Internal,
+		osin.E_TEMPORARILY_UNAVAILABLE: codes.Unavailable,
+		osin.E_INVALID_GRANT:           codes.FailedPrecondition,
+	}
+
+	for id, code := range testcases {
+		w := osin.NewResponse(osintest.NewStorage())
+		w.SetError(id, "details")
+		err := responseError(w)
+		if status.Code(err) != code {
+			t.Errorf("%s: expected %v, got %v", id, code, err)
+		}
+		if msg := status.Convert(err).Message(); msg != id+": details" {
+			t.Errorf("%s: unexpected message %q", id, msg)
+		}
+	}
+}
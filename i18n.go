@@ -0,0 +1,85 @@
+package osin
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MessageCatalog localizes error descriptions. The error ids are never
+// localized.
+type MessageCatalog interface {
+	// Message returns the description of the error in the first supported
+	// of the languages, given in preference order. description is the
+	// untranslated description. Returns false if there is no translation.
+	Message(languages []string, id string, description string) (string, bool)
+}
+
+// MapMessageCatalog is a MessageCatalog of messages by language tag, keyed by
+// untranslated description or, to translate the default descriptions, by
+// error id. The messages of a language are also used for its regional
+// variants, e.g. "pt" for "pt-BR".
+type MapMessageCatalog map[string]map[string]string
+
+// Message returns the translated description
+func (c MapMessageCatalog) Message(languages []string, id string, description string) (string, bool) {
+	for _, lang := range languages {
+		for _, tag := range []string{lang, strings.SplitN(lang, "-", 2)[0]} {
+			messages, ok := c[strings.ToLower(tag)]
+			if !ok {
+				continue
+			}
+			if m, ok := messages[description]; ok && description != "" {
+				return m, true
+			}
+			if m, ok := messages[id]; ok {
+				return m, true
+			}
+		}
+	}
+	return "", false
+}
+
+// RequestLanguages returns the languages preferred by the user, from the
+// ui_locales parameter followed by the Accept-Language header
+func RequestLanguages(r *http.Request) []string {
+	var ret []string
+	form := r.Form
+	if form == nil {
+		form = r.URL.Query()
+	}
+	for _, l := range strings.Fields(form.Get("ui_locales")) {
+		ret = append(ret, strings.ToLower(l))
+	}
+
+	type weighted struct {
+		lang string
+		q    float64
+	}
+	var accept []weighted
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		fields := strings.Split(part, ";")
+		lang := strings.ToLower(strings.TrimSpace(fields[0]))
+		if lang == "" || lang == "*" {
+			continue
+		}
+		q := 1.0
+		for _, p := range fields[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			accept = append(accept, weighted{lang, q})
+		}
+	}
+	sort.SliceStable(accept, func(i, j int) bool { return accept[i].q > accept[j].q })
+	for _, a := range accept {
+		ret = append(ret, a.lang)
+	}
+	return ret
+}
@@ -0,0 +1,62 @@
+package osin
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestRequestLanguages(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://localhost:14000/authorize?ui_locales=fr-CA%20fr", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Language", "en;q=0.5, pt-BR, de;q=0")
+
+	expected := []string{"fr-ca", "fr", "pt-br", "en"}
+	if langs := RequestLanguages(req); !reflect.DeepEqual(langs, expected) {
+		t.Fatalf("Expected %v, got %v", expected, langs)
+	}
+}
+
+func TestLocalizedAuthorizeError(t *testing.T) {
+	sconfig := NewServerConfig()
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+	server.MessageCatalog = MapMessageCatalog{
+		"pt": {
+			E_ACCESS_DENIED: "O acesso foi negado.",
+		},
+	}
+	resp := server.NewResponse()
+
+	req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Language", "pt-BR,en;q=0.8")
+	req.Form = make(map[string][]string)
+	req.Form.Set("response_type", string(CODE))
+	req.Form.Set("client_id", "1234")
+	req.Form.Set("state", "a")
+
+	if ar := server.HandleAuthorizeRequest(resp, req); ar != nil {
+		ar.Authorized = false
+		server.FinishAuthorizeRequest(resp, req, ar)
+	}
+
+	if resp.ErrorId != E_ACCESS_DENIED {
+		t.Fatalf("Unexpected error: %s", resp.ErrorId)
+	}
+	if d := resp.Output["error_description"]; d != "O acesso foi negado." {
+		t.Fatalf("Unexpected description: %s", d)
+	}
+
+	// untranslated languages get the default description
+	resp = server.NewResponse()
+	resp.Languages = []string{"de"}
+	resp.SetError(E_ACCESS_DENIED, "")
+	if d := resp.Output["error_description"]; d != deferror.Get(E_ACCESS_DENIED) {
+		t.Fatalf("Unexpected description: %s", d)
+	}
+}
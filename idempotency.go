@@ -0,0 +1,225 @@
+package osin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash/fnv"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IDEMPOTENCY_KEY_HEADER is the header of the token requests carrying an
+// idempotency key
+const IDEMPOTENCY_KEY_HEADER = "Idempotency-Key"
+
+// defaultIdempotencyMaxEntries is the default of
+// Config.IdempotencyMaxEntries
+const defaultIdempotencyMaxEntries = 10000
+
+// IdempotentResponse is a token response saved under an idempotency key
+type IdempotentResponse struct {
+	// Hash of the request parameters and credentials, so the key only
+	// replays the same request
+	Fingerprint string
+
+	// Id of the client the response was issued to
+	ClientID string
+
+	// Status code, headers and output of the response
+	StatusCode int
+	Headers    http.Header
+	Output     ResponseData
+}
+
+// IdempotencyStorage is an optional interface storages can implement to
+// share the responses saved under idempotency keys between servers.
+// Responses are kept in server memory otherwise. Concurrent requests with
+// the same key are serialized in each server only.
+type IdempotencyStorage interface {
+	// SaveIdempotentResponse saves the token response of an idempotency key
+	// until expiresAt
+	SaveIdempotentResponse(key string, response *IdempotentResponse, expiresAt time.Time) error
+
+	// LoadIdempotentResponse loads the token response of an idempotency key.
+	// Returns ErrNotFound if unknown or expired.
+	LoadIdempotentResponse(key string) (*IdempotentResponse, error)
+}
+
+// idempotencyKey returns the storage key and the request fingerprint of a
+// token request with an idempotency key, or empty strings if disabled or
+// the request has no key. Keys are scoped to the presented client id.
+func (s *Server) idempotencyKey(w *Response, r *http.Request) (key, fingerprint string) {
+	header := r.Header.Get(IDEMPOTENCY_KEY_HEADER)
+	if s.requestConfig(w).IdempotencyWindow <= 0 || header == "" {
+		return "", ""
+	}
+	clientId := r.Form.Get("client_id")
+	if auth, err := CheckBasicAuth(r); err == nil && auth != nil {
+		clientId = auth.Username
+	}
+
+	h := sha256.New()
+	h.Write([]byte(r.Header.Get("Authorization")))
+	h.Write([]byte{0})
+	h.Write([]byte(r.Form.Encode()))
+	return clientId + "|" + header, hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotentClient authenticates the client of a replayed token request,
+// like the grant handlers: with its credentials, or with its client_id
+// only for the public clients. Sets an error on the response on failure.
+func (s *Server) idempotentClient(w *Response, r *http.Request) Client {
+	auth, err := CheckBasicAuth(r)
+	if err != nil {
+		w.SetError(E_INVALID_CLIENT, "")
+		w.InternalError = err
+		return nil
+	}
+	_, hasSecret := r.Form["client_secret"]
+	if auth == nil && !(hasSecret && s.requestConfig(w).AllowClientSecretInParams) {
+		client := getClientWithoutSecret(r.Context(), r.Form.Get("client_id"), w.Storage, w)
+		if client != nil && !s.isPublicClient(client) {
+			w.SetError(E_INVALID_CLIENT, "")
+			w.InternalError = errors.New("client authentication not set")
+			return nil
+		}
+		return client
+	}
+	if auth = GetClientAuth(w, r, s.requestConfig(w).AllowClientSecretInParams); auth == nil {
+		return nil
+	}
+	return s.authenticateClient(auth, w, r)
+}
+
+// idempotency holds the in-memory responses saved under idempotency keys
+type idempotency struct {
+	// striped locks serializing the requests with the same key
+	locks [64]sync.Mutex
+
+	saved memoCache
+}
+
+// lockIdempotency serializes the token requests with the same idempotency
+// key in this server, returning the unlock function
+func (s *Server) lockIdempotency(key string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	l := &s.idempotency.locks[h.Sum32()%uint32(len(s.idempotency.locks))]
+	l.Lock()
+	return l.Unlock
+}
+
+// loadIdempotentResponse loads the response saved under an idempotency key,
+// or nil. Sets an error on the response and returns false on failure.
+func (s *Server) loadIdempotentResponse(w *Response, key string) (*IdempotentResponse, bool) {
+	if is, ok := storageAs[IdempotencyStorage](w.Storage); ok {
+		saved, err := is.LoadIdempotentResponse(key)
+		if err != nil && err != ErrNotFound {
+			w.SetError(E_SERVER_ERROR, "")
+			w.InternalError = err
+			return nil, false
+		}
+		return saved, true
+	}
+	if v, ok := s.idempotency.saved.get(key, s.Now()); ok {
+		return v.(*IdempotentResponse), true
+	}
+	return nil, true
+}
+
+// replayIdempotentResponse outputs the saved response of a replayed
+// idempotency key, returning true if replayed. The client is authenticated
+// first, and reusing a key with different parameters is an error.
+func (s *Server) replayIdempotentResponse(w *Response, r *http.Request) bool {
+	key, fingerprint := s.idempotencyKey(w, r)
+	if key == "" {
+		return false
+	}
+
+	saved, ok := s.loadIdempotentResponse(w, key)
+	if !ok {
+		return true
+	}
+	if saved == nil {
+		return false
+	}
+
+	client := s.idempotentClient(w, r)
+	if client == nil {
+		return true
+	}
+	s.outputIdempotentResponse(w, saved, client, fingerprint)
+	return true
+}
+
+// replayIssuedResponse is replayIdempotentResponse for an authorized
+// request of the client, called with the key locked before issuing the
+// tokens, so the concurrent requests with the same key issue them once
+func (s *Server) replayIssuedResponse(w *Response, r *http.Request, client Client) bool {
+	key, fingerprint := s.idempotencyKey(w, r)
+	if key == "" {
+		return false
+	}
+
+	saved, ok := s.loadIdempotentResponse(w, key)
+	if !ok {
+		return true
+	}
+	if saved == nil {
+		return false
+	}
+	s.outputIdempotentResponse(w, saved, client, fingerprint)
+	return true
+}
+
+// outputIdempotentResponse outputs a saved response, if it was issued to the
+// client for the same request
+func (s *Server) outputIdempotentResponse(w *Response, saved *IdempotentResponse, client Client, fingerprint string) {
+	if saved.ClientID != client.GetID() || saved.Fingerprint != fingerprint {
+		w.SetError(E_INVALID_REQUEST, "idempotency key reused with different parameters")
+		return
+	}
+	if saved.StatusCode != 0 {
+		w.StatusCode = saved.StatusCode
+	}
+	for k, v := range saved.Headers {
+		w.Headers[k] = append([]string(nil), v...)
+	}
+	for k, v := range saved.Output {
+		w.Output[k] = v
+	}
+	w.Headers.Set("Idempotent-Replayed", "true")
+}
+
+// saveIdempotentResponse saves the token response issued to the client
+// under the request idempotency key, if any
+func (s *Server) saveIdempotentResponse(w *Response, r *http.Request, client Client) error {
+	key, fingerprint := s.idempotencyKey(w, r)
+	if key == "" {
+		return nil
+	}
+
+	saved := &IdempotentResponse{
+		Fingerprint: fingerprint,
+		ClientID:    client.GetID(),
+		StatusCode:  w.StatusCode,
+		Headers:     w.Headers.Clone(),
+		Output:      make(ResponseData, len(w.Output)),
+	}
+	for k, v := range w.Output {
+		saved.Output[k] = v
+	}
+	now := s.Now()
+	expiresAt := now.Add(time.Duration(s.requestConfig(w).IdempotencyWindow) * time.Second)
+	if is, ok := storageAs[IdempotencyStorage](w.Storage); ok {
+		return is.SaveIdempotentResponse(key, saved, expiresAt)
+	}
+	max := s.requestConfig(w).IdempotencyMaxEntries
+	if max <= 0 {
+		max = defaultIdempotencyMaxEntries
+	}
+	s.idempotency.saved.putMax(key, saved, now, expiresAt, max)
+	return nil
+}
@@ -0,0 +1,134 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func idempotentTokenRequest(server *Server, key, scope string) *Response {
+	return idempotentTokenRequestSecret(server, key, scope, "aabbccdd")
+}
+
+func idempotentTokenRequestSecret(server *Server, key, scope, secret string) *Response {
+	resp := server.NewResponse()
+	req, _ := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+	req.SetBasicAuth("1234", secret)
+	if key != "" {
+		req.Header.Set(IDEMPOTENCY_KEY_HEADER, key)
+	}
+	req.Form = url.Values{
+		"grant_type": {string(CLIENT_CREDENTIALS)},
+		"scope":      {scope},
+	}
+	req.PostForm = req.Form
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		ar.Authorized = true
+		server.FinishAccessRequest(resp, req, ar)
+	}
+	return resp
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+	sconfig.IdempotencyWindow = 60
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+
+	first := idempotentTokenRequest(server, "k1", "a")
+	if first.IsError {
+		t.Fatalf("Error in response: %v", first.Output)
+	}
+
+	replay := idempotentTokenRequest(server, "k1", "a")
+	if replay.IsError {
+		t.Fatalf("Error in replayed response: %v", replay.Output)
+	}
+	if replay.Output["access_token"] != first.Output["access_token"] {
+		t.Fatalf("Expected the same access token, got %v and %v", first.Output["access_token"], replay.Output["access_token"])
+	}
+	if replay.Headers.Get("Idempotent-Replayed") != "true" {
+		t.Fatal("Replayed response should be marked")
+	}
+
+	if other := idempotentTokenRequest(server, "k1", "b"); !other.IsError || other.ErrorId != E_INVALID_REQUEST {
+		t.Fatalf("Reusing a key with different parameters should fail: %v", other.Output)
+	}
+	if other := idempotentTokenRequest(server, "k2", "a"); other.Output["access_token"] == first.Output["access_token"] {
+		t.Fatal("A different key should issue new tokens")
+	}
+	if other := idempotentTokenRequest(server, "", "a"); other.Output["access_token"] == first.Output["access_token"] {
+		t.Fatal("A request without key should issue new tokens")
+	}
+
+	// the client is authenticated before replaying
+	if other := idempotentTokenRequestSecret(server, "k1", "a", "wrong"); other.ErrorId != E_INVALID_CLIENT {
+		t.Fatalf("Replaying with a wrong secret should fail: %v", other.Output)
+	}
+
+	// the status code and the headers are replayed
+	v, _ := server.idempotency.saved.get("1234|k1", server.Now())
+	saved := v.(*IdempotentResponse)
+	saved.StatusCode = http.StatusCreated
+	saved.Headers.Set("X-Saved", "1")
+	replay = idempotentTokenRequest(server, "k1", "a")
+	if replay.StatusCode != http.StatusCreated || replay.Headers.Get("X-Saved") != "1" {
+		t.Fatalf("Expected the saved status and headers, got %d %v", replay.StatusCode, replay.Headers)
+	}
+
+	// the response is bound to the client it was issued to
+	saved.ClientID = "other"
+	if other := idempotentTokenRequest(server, "k1", "a"); other.ErrorId != E_INVALID_REQUEST {
+		t.Fatalf("Replaying the response of another client should fail: %v", other.Output)
+	}
+}
+
+func TestIdempotencyKeyConcurrent(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+	sconfig.IdempotencyWindow = 60
+	sconfig.IdempotencyMaxEntries = 2
+	server := NewServer(sconfig, NewTestingStorage())
+	gen := &TestingAccessTokenGen{}
+	server.AccessTokenGen = gen
+
+	newRequest := func(key string) *http.Request {
+		req, _ := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Header.Set(IDEMPOTENCY_KEY_HEADER, key)
+		req.Form = url.Values{"grant_type": {string(CLIENT_CREDENTIALS)}}
+		req.PostForm = req.Form
+		return req
+	}
+
+	// both requests are handled before either is finished
+	var responses [2]*Response
+	var requests [2]*AccessRequest
+	for i := range responses {
+		responses[i] = server.NewResponse()
+		if requests[i] = server.HandleAccessRequest(responses[i], newRequest("k1")); requests[i] == nil {
+			t.Fatalf("Request %d failed: %v", i, responses[i].Output)
+		}
+	}
+	for i, ar := range requests {
+		ar.Authorized = true
+		server.FinishAccessRequest(responses[i], ar.HttpRequest, ar)
+		if responses[i].IsError {
+			t.Fatalf("Request %d failed: %v", i, responses[i].Output)
+		}
+	}
+	if gen.acounter != 1 || responses[1].Output["access_token"] != responses[0].Output["access_token"] {
+		t.Fatalf("Expected the tokens issued once, got %v and %v", responses[0].Output, responses[1].Output)
+	}
+
+	// the responses kept in memory are bounded
+	for _, key := range []string{"k2", "k3", "k4"} {
+		if resp := idempotentTokenRequest(server, key, ""); resp.IsError {
+			t.Fatalf("Request failed: %v", resp.Output)
+		}
+	}
+	if n := len(server.idempotency.saved.entries); n != 2 {
+		t.Fatalf("Expected 2 saved responses, got %d", n)
+	}
+}
@@ -15,9 +15,14 @@ type InfoRequest struct {
 // NOT an RFC specification.
 func (s *Server) HandleInfoRequest(w *Response, r *http.Request) *InfoRequest {
 	r.ParseForm()
+	if !s.checkQueryCredentials(w, r) {
+		w.SetChallenge("Bearer", s.requestConfig(w).Realm, "")
+		return nil
+	}
 	bearer := CheckBearerAuth(r)
 	if bearer == nil {
 		w.SetError(E_INVALID_REQUEST, "")
+		w.SetChallenge("Bearer", s.requestConfig(w).Realm, "")
 		return nil
 	}
 
@@ -28,32 +33,47 @@ func (s *Server) HandleInfoRequest(w *Response, r *http.Request) *InfoRequest {
 
 	if ret.Code == "" {
 		w.SetError(E_INVALID_REQUEST, "")
+		w.SetChallenge("Bearer", s.requestConfig(w).Realm, "")
 		return nil
 	}
 
 	var err error
 
 	// load access data
-	ret.AccessData, err = w.Storage.LoadAccess(ret.Code)
+	ret.AccessData, err = s.loadAccess(r.Context(), w.Storage, ret.Code)
 	if err != nil {
 		w.SetError(E_INVALID_REQUEST, "")
 		w.InternalError = err
+		if err == ErrNotFound {
+			w.SetChallenge("Bearer", s.requestConfig(w).Realm, E_INVALID_TOKEN)
+		}
 		return nil
 	}
 	if ret.AccessData == nil {
 		w.SetError(E_INVALID_REQUEST, "")
+		w.SetChallenge("Bearer", s.requestConfig(w).Realm, E_INVALID_TOKEN)
 		return nil
 	}
 	if ret.AccessData.Client == nil {
 		w.SetError(E_UNAUTHORIZED_CLIENT, "")
 		return nil
 	}
-	if ret.AccessData.Client.GetRedirectURI() == "" {
+	if !hasRedirectURI(ret.AccessData.Client) {
 		w.SetError(E_UNAUTHORIZED_CLIENT, "")
 		return nil
 	}
 	if ret.AccessData.IsExpiredAt(s.Now()) {
 		w.SetError(E_INVALID_GRANT, "")
+		w.SetChallenge("Bearer", s.requestConfig(w).Realm, E_INVALID_TOKEN)
+		return nil
+	}
+	if revoked, err := s.isRevoked(ret.Code, ret.AccessData); err != nil {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = err
+		return nil
+	} else if revoked {
+		w.SetError(E_INVALID_GRANT, "token was revoked")
+		w.SetChallenge("Bearer", s.requestConfig(w).Realm, E_INVALID_TOKEN)
 		return nil
 	}
 
@@ -70,12 +90,34 @@ func (s *Server) FinishInfoRequest(w *Response, r *http.Request, ir *InfoRequest
 	// output data
 	w.Output["client_id"] = ir.AccessData.Client.GetID()
 	w.Output["access_token"] = ir.AccessData.AccessToken
-	w.Output["token_type"] = s.Config.TokenType
+	w.Output["token_type"] = s.accessTokenType(w, ir.AccessData)
 	w.Output["expires_in"] = ir.AccessData.CreatedAt.Add(time.Duration(ir.AccessData.ExpiresIn)*time.Second).Sub(s.Now()) / time.Second
 	if ir.AccessData.RefreshToken != "" {
 		w.Output["refresh_token"] = ir.AccessData.RefreshToken
 	}
-	if ir.AccessData.Scope != "" {
-		w.Output["scope"] = ir.AccessData.Scope
+	if scope := ir.AccessData.GrantedScope(); scope != "" {
+		w.Output["scope"] = scope
+	}
+	if ir.AccessData.Subject != "" {
+		sub, err := s.SubjectIdentifier(ir.AccessData.Client, ir.AccessData.Subject)
+		if err != nil {
+			w.SetError(E_SERVER_ERROR, "")
+			w.InternalError = err
+			return
+		}
+		w.Output["sub"] = sub
+	}
+	if len(ir.AccessData.AuthorizationDetails) > 0 {
+		w.Output["authorization_details"] = ir.AccessData.AuthorizationDetails
+	}
+	ac := ir.AccessData.AuthenticationContext
+	if ac.ACR != "" {
+		w.Output["acr"] = ac.ACR
+	}
+	if len(ac.AMR) > 0 {
+		w.Output["amr"] = ac.AMR
+	}
+	if !ac.AuthTime.IsZero() {
+		w.Output["auth_time"] = ac.AuthTime.Unix()
 	}
 }
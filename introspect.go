@@ -0,0 +1,267 @@
+package osin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// IntrospectionRequest is a token introspection request, as described in
+// RFC 7662
+type IntrospectionRequest struct {
+	// Token to introspect and the optional token_type_hint
+	Token         string
+	TokenTypeHint string
+
+	// Authenticated client calling the endpoint, usually a resource server
+	Client Client
+
+	// Access data of the token. Nil if the token is not active.
+	AccessData *AccessData
+
+	// True if the token is a refresh token
+	IsRefresh bool
+
+	// HttpRequest *http.Request for special use
+	HttpRequest *http.Request
+}
+
+// IntrospectionFieldFilter returns true if the calling client may see a
+// field of the introspection response. "active" is always exposed.
+type IntrospectionFieldFilter func(caller Client, field string) bool
+
+// IntrospectionExtender returns custom fields added to the introspection
+// response of an active token, usually derived from AccessData.UserData
+type IntrospectionExtender func(ir *IntrospectionRequest) (map[string]interface{}, error)
+
+// HandleIntrospectionRequest is the token introspection endpoint handler.
+// The calling client must authenticate. Unknown, expired and revoked tokens,
+// and the tokens the TokenAccessPolicy denies to the client, are not an
+// error, they are reported inactive.
+func (s *Server) HandleIntrospectionRequest(w *Response, r *http.Request) *IntrospectionRequest {
+	// Only allow POST
+	if r.Method != "POST" {
+		w.SetError(E_INVALID_REQUEST, "")
+		w.InternalError = errors.New("Request must be POST")
+		return nil
+	}
+	if err := s.parseForm(w, r); err != nil {
+		w.SetError(E_INVALID_REQUEST, "")
+		w.InternalError = err
+		return nil
+	}
+	if !s.checkQueryCredentials(w, r) {
+		return nil
+	}
+	w.NoStore = true
+
+	// get client authentication
+	auth := GetClientAuth(w, r, s.requestConfig(w).AllowClientSecretInParams)
+	if auth == nil {
+		return nil
+	}
+
+	ret := &IntrospectionRequest{
+		Token:         r.PostForm.Get("token"),
+		TokenTypeHint: r.PostForm.Get("token_type_hint"),
+		HttpRequest:   r,
+	}
+	if ret.Token == "" {
+		w.SetError(E_INVALID_REQUEST, "token is required")
+		return nil
+	}
+
+	// must have a valid client
+	if ret.Client = s.authenticateClient(auth, w, r); ret.Client == nil {
+		return nil
+	}
+
+	// look up the token, following the hint first
+	lookups := []bool{false, true}
+	if ret.TokenTypeHint == "refresh_token" {
+		lookups = []bool{true, false}
+	}
+	for _, refresh := range lookups {
+		var data *AccessData
+		var err error
+		if refresh {
+			data, err = s.loadRefresh(r.Context(), w.Storage, ret.Token)
+		} else {
+			data, err = s.loadAccess(r.Context(), w.Storage, ret.Token)
+		}
+		if err != nil && err != ErrNotFound {
+			w.SetError(E_SERVER_ERROR, "")
+			w.InternalError = err
+			return nil
+		}
+		if data != nil && data.Client != nil {
+			ret.AccessData, ret.IsRefresh = data, refresh
+			break
+		}
+	}
+	if ret.AccessData == nil {
+		return ret
+	}
+
+	// tokens the client may not introspect are reported inactive, not to
+	// disclose them
+	if !s.allowTokenAccess(w, ENDPOINT_INTROSPECTION, ret.Client, ret.AccessData) {
+		ret.AccessData = nil
+		return ret
+	}
+
+	// expired and revoked tokens are inactive
+	now := s.Now()
+	if ret.IsRefresh {
+		if ret.AccessData.RefreshExpireIn > 0 && ret.AccessData.CreatedAt.Add(time.Duration(ret.AccessData.RefreshExpireIn)*time.Second).Before(now) {
+			ret.AccessData = nil
+			return ret
+		}
+	} else if ret.AccessData.IsExpiredAt(now) {
+		ret.AccessData = nil
+		return ret
+	}
+	revoked, err := s.isRevoked(ret.Token, ret.AccessData)
+	if err != nil {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = err
+		return nil
+	}
+	if revoked {
+		ret.AccessData = nil
+	}
+	return ret
+}
+
+// FinishIntrospectionRequest outputs the introspection response, filtered
+// by the server IntrospectionFieldFilter and extended by the server
+// IntrospectionExtender
+func (s *Server) FinishIntrospectionRequest(w *Response, r *http.Request, ir *IntrospectionRequest) {
+	// don't process if is already an error
+	if w.IsError {
+		return
+	}
+
+	if ir.AccessData == nil {
+		w.Output["active"] = false
+		return
+	}
+	data := ir.AccessData
+
+	fields := map[string]interface{}{
+		"client_id":  data.Client.GetID(),
+		"token_type": s.accessTokenType(w, data),
+		"iat":        data.CreatedAt.Unix(),
+	}
+	if ir.IsRefresh {
+		fields["token_type"] = "refresh_token"
+		if data.RefreshExpireIn > 0 {
+			fields["exp"] = data.CreatedAt.Add(time.Duration(data.RefreshExpireIn) * time.Second).Unix()
+		}
+	} else {
+		fields["exp"] = data.ExpireAt().Unix()
+	}
+	if scope := data.GrantedScope(); scope != "" {
+		fields["scope"] = scope
+	}
+	if data.GrantedScopes != "" && data.GrantedScopes != data.Scope {
+		fields["requested_scope"] = data.Scope
+	}
+	if !data.GrantedAt.IsZero() {
+		fields["granted_at"] = data.GrantedAt.Unix()
+	}
+	if data.Subject != "" {
+		sub, err := s.SubjectIdentifier(data.Client, data.Subject)
+		if err != nil {
+			w.SetError(E_SERVER_ERROR, "")
+			w.InternalError = err
+			return
+		}
+		fields["sub"] = sub
+	}
+	if len(data.AuthorizationDetails) > 0 {
+		fields["authorization_details"] = data.AuthorizationDetails
+	}
+	ac := data.AuthenticationContext
+	if ac.ACR != "" {
+		fields["acr"] = ac.ACR
+	}
+	if len(ac.AMR) > 0 {
+		fields["amr"] = ac.AMR
+	}
+	if !ac.AuthTime.IsZero() {
+		fields["auth_time"] = ac.AuthTime.Unix()
+	}
+
+	if s.IntrospectionExtender != nil {
+		extra, err := s.IntrospectionExtender(ir)
+		if err != nil {
+			w.SetError(E_SERVER_ERROR, "")
+			w.InternalError = err
+			return
+		}
+		for k, v := range extra {
+			fields[k] = v
+		}
+	}
+
+	for k, v := range fields {
+		if s.IntrospectionFieldFilter == nil || s.IntrospectionFieldFilter(ir.Client, k) {
+			w.Output[k] = v
+		}
+	}
+	w.Output["active"] = true
+
+	s.setIntrospectionCaching(w, r, ir)
+}
+
+// setIntrospectionCaching lets the caller cache the response of an active
+// token for Config.IntrospectionCacheMaxAge, at most until the token
+// expires, answering conditional requests matching the ETag with 304
+func (s *Server) setIntrospectionCaching(w *Response, r *http.Request, ir *IntrospectionRequest) {
+	if s.requestConfig(w).IntrospectionCacheMaxAge <= 0 {
+		return
+	}
+	maxAge := int64(s.requestConfig(w).IntrospectionCacheMaxAge)
+	data := ir.AccessData
+	expiresIn := int64(data.ExpiresIn)
+	if ir.IsRefresh {
+		expiresIn = int64(data.RefreshExpireIn)
+	}
+	if expiresIn > 0 {
+		left := int64(data.CreatedAt.Add(time.Duration(expiresIn)*time.Second).Sub(s.Now()) / time.Second)
+		if left < maxAge {
+			maxAge = left
+		}
+	}
+	if maxAge <= 0 {
+		return
+	}
+
+	// the output differs by caller, so the ETag is derived from it
+	b, err := json.Marshal(w.Output)
+	if err != nil {
+		return
+	}
+	sum := sha256.Sum256(b)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.NoStore = false
+	w.Headers.Del("Pragma")
+	w.Headers.Del("Expires")
+	w.SetHeader("Cache-Control", fmt.Sprintf("private, max-age=%d", maxAge))
+	w.SetHeader("ETag", etag)
+
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		if tag = strings.TrimSpace(tag); tag == etag || tag == "W/"+etag || tag == "*" {
+			w.StatusCode = http.StatusNotModified
+			w.Output = make(ResponseData)
+			return
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package osin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newIntrospectionRequest(t *testing.T, token string) *http.Request {
+	req, err := http.NewRequest("POST", "http://localhost:14000/introspect", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("1234", "aabbccdd")
+	req.Form = url.Values{"token": {token}}
+	req.PostForm = req.Form
+	return req
+}
+
+func TestIntrospection(t *testing.T) {
+	storage := NewTestingStorage()
+	storage.access["9999"].UserData = map[string]string{"username": "jane"}
+	storage.access["9999"].Subject = "user-1"
+	server := NewServer(NewServerConfig(), storage)
+	server.IntrospectionExtender = func(ir *IntrospectionRequest) (map[string]interface{}, error) {
+		ud, _ := ir.AccessData.UserData.(map[string]string)
+		return map[string]interface{}{"username": ud["username"]}, nil
+	}
+
+	tests := map[string]struct {
+		Token    string
+		Filter   IntrospectionFieldFilter
+		Active   bool
+		Username interface{}
+	}{
+		"active":  {Token: "9999", Active: true, Username: "jane"},
+		"unknown": {Token: "unknown"},
+		"filtered": {
+			Token:  "9999",
+			Active: true,
+			Filter: func(caller Client, field string) bool { return field != "username" },
+		},
+	}
+
+	for k, test := range tests {
+		server.IntrospectionFieldFilter = test.Filter
+		resp := server.NewResponse()
+		req := newIntrospectionRequest(t, test.Token)
+		if ir := server.HandleIntrospectionRequest(resp, req); ir != nil {
+			server.FinishIntrospectionRequest(resp, req, ir)
+		}
+		if resp.IsError {
+			t.Fatalf("%s: request failed: %v", k, resp.Output)
+		}
+		if resp.Output["active"] != test.Active {
+			t.Errorf("%s: expected active %v, got %v", k, test.Active, resp.Output)
+		}
+		if resp.Output["username"] != test.Username {
+			t.Errorf("%s: expected username %v, got %v", k, test.Username, resp.Output["username"])
+		}
+		if test.Active && resp.Output["sub"] != "user-1" {
+			t.Errorf("%s: sub should be exposed: %v", k, resp.Output)
+		}
+		if !test.Active && len(resp.Output) != 1 {
+			t.Errorf("%s: inactive tokens should only report active: %v", k, resp.Output)
+		}
+	}
+}
+
+func TestIntrospectionRequiresClient(t *testing.T) {
+	server := NewServer(NewServerConfig(), NewTestingStorage())
+	resp := server.NewResponse()
+	req := newIntrospectionRequest(t, "9999")
+	req.SetBasicAuth("1234", "wrong")
+	if ir := server.HandleIntrospectionRequest(resp, req); ir != nil || resp.ErrorId != E_INVALID_CLIENT {
+		t.Fatalf("Unauthenticated callers should be refused: %v", resp.Output)
+	}
+}
+
+func TestIntrospectionCaching(t *testing.T) {
+	storage := NewTestingStorage()
+	sconfig := NewServerConfig()
+	sconfig.IntrospectionCacheMaxAge = 60
+	server := NewServer(sconfig, storage)
+
+	introspect := func(token, etag string) *httptest.ResponseRecorder {
+		resp := server.NewResponse()
+		req := newIntrospectionRequest(t, token)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if ir := server.HandleIntrospectionRequest(resp, req); ir != nil {
+			server.FinishIntrospectionRequest(resp, req, ir)
+		}
+		rec := httptest.NewRecorder()
+		if err := OutputJSON(resp, rec, req); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	rec := introspect("9999", "")
+	if cc := rec.Header().Get("Cache-Control"); cc != "private, max-age=60" {
+		t.Errorf("Unexpected Cache-Control: %s", cc)
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("Active token response should have an ETag")
+	}
+
+	// conditional requests with the same output are not modified
+	if rec = introspect("9999", etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("Expected 304 without body, got %d %s", rec.Code, rec.Body)
+	}
+
+	// the max age is bounded by the token expiration
+	storage.access["9999"].CreatedAt = time.Now().Add(-time.Duration(storage.access["9999"].ExpiresIn-10) * time.Second)
+	if cc := introspect("9999", "").Header().Get("Cache-Control"); cc != "private, max-age=9" && cc != "private, max-age=10" {
+		t.Errorf("Unexpected Cache-Control near expiration: %s", cc)
+	}
+
+	// inactive tokens are not cached
+	if cc := introspect("unknown", etag).Header().Get("Cache-Control"); !strings.Contains(cc, "no-store") {
+		t.Errorf("Inactive token response should not be cached: %s", cc)
+	}
+}
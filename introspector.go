@@ -0,0 +1,243 @@
+package osin
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrIntrospectionUnavailable is returned by RemoteIntrospector while its
+// circuit is open, after repeated failures calling the endpoint
+var ErrIntrospectionUnavailable = errors.New("introspection endpoint unavailable")
+
+// RemoteIntrospector is a TokenIntrospector calling the RFC 7662
+// introspection endpoint of an authorization server, like
+// HandleIntrospectionRequest, so resource servers can validate opaque tokens
+// of another instance. Results are cached, and calls are suspended after
+// repeated failures.
+type RemoteIntrospector struct {
+	// URL of the introspection endpoint. Required.
+	URL string
+
+	// Credentials of the resource server at the authorization server, sent
+	// with HTTP basic authentication. With mutual TLS, see MTLSHTTPClient,
+	// leave ClientSecret blank to send only the client_id.
+	ClientID     string
+	ClientSecret string
+
+	// HTTP client calling the endpoint. http.DefaultClient if nil.
+	HTTPClient *http.Client
+
+	// Maximum time active tokens are cached, bounded by their expiration
+	// (default 1 minute). Disabled if negative.
+	CacheTTL time.Duration
+
+	// If true, the cache time is also bounded by the Cache-Control header
+	// of the responses, see Config.IntrospectionCacheMaxAge
+	HonorCacheControl bool
+
+	// Time inactive tokens are cached. Not cached if 0 (the default).
+	NegativeCacheTTL time.Duration
+
+	// Consecutive failures opening the circuit (default 5), and the time it
+	// stays open (default 30 seconds)
+	FailureThreshold int
+	OpenDuration     time.Duration
+
+	// Current time, time.Now if nil
+	Now func() time.Time
+
+	cache memoCache
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// MTLSHTTPClient returns an HTTP client authenticating with the client
+// certificate, for RemoteIntrospector with mutual TLS client authentication.
+// The system roots verify the server if roots is nil.
+func MTLSHTTPClient(cert tls.Certificate, roots *x509.CertPool) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      roots,
+		MinVersion:   tls.VersionTLS12,
+	}
+	return &http.Client{Transport: transport, Timeout: 10 * time.Second}
+}
+
+func (i *RemoteIntrospector) now() time.Time {
+	if i.Now != nil {
+		return i.Now()
+	}
+	return time.Now()
+}
+
+// IntrospectToken satisfies the TokenIntrospector interface
+func (i *RemoteIntrospector) IntrospectToken(ctx context.Context, token string) (*VerifiedToken, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+	now := i.now()
+	if v, ok := i.cache.get(key, now); ok {
+		if v == nil {
+			return nil, ErrInvalidToken
+		}
+		return v.(*VerifiedToken), nil
+	}
+
+	if !i.allow(now) {
+		return nil, ErrIntrospectionUnavailable
+	}
+	ret, maxAge, err := i.introspect(ctx, token)
+	i.record(err == nil || err == ErrInvalidToken, now)
+	if err == ErrInvalidToken {
+		if i.NegativeCacheTTL > 0 {
+			i.cache.put(key, nil, now, now.Add(i.NegativeCacheTTL))
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := i.CacheTTL
+	if ttl == 0 {
+		ttl = time.Minute
+	}
+	if i.HonorCacheControl && maxAge >= 0 && maxAge < ttl {
+		ttl = maxAge
+	}
+	expiresAt := now.Add(ttl)
+	if !ret.ExpiresAt.IsZero() && ret.ExpiresAt.Before(expiresAt) {
+		expiresAt = ret.ExpiresAt
+	}
+	if expiresAt.After(now) {
+		i.cache.put(key, ret, now, expiresAt)
+	}
+	return ret, nil
+}
+
+// allow returns false while the circuit is open
+func (i *RemoteIntrospector) allow(now time.Time) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return !now.Before(i.openUntil)
+}
+
+// record counts the consecutive failures, opening the circuit at the
+// threshold
+func (i *RemoteIntrospector) record(ok bool, now time.Time) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if ok {
+		i.failures = 0
+		return
+	}
+	i.failures++
+	threshold := i.FailureThreshold
+	if threshold <= 0 {
+		threshold = 5
+	}
+	if i.failures >= threshold {
+		open := i.OpenDuration
+		if open <= 0 {
+			open = 30 * time.Second
+		}
+		i.openUntil = now.Add(open)
+		i.failures = 0
+	}
+}
+
+// introspect calls the endpoint, returning the token and the max-age of
+// the response, negative if not set
+func (i *RemoteIntrospector) introspect(ctx context.Context, token string) (*VerifiedToken, time.Duration, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	if i.ClientSecret == "" && i.ClientID != "" {
+		form.Set("client_id", i.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", i.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, -1, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if i.ClientSecret != "" {
+		req.SetBasicAuth(i.ClientID, i.ClientSecret)
+	}
+
+	client := i.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, -1, fmt.Errorf("introspection failed with status %d", resp.StatusCode)
+	}
+
+	var fields map[string]interface{}
+	d := json.NewDecoder(resp.Body)
+	d.UseNumber()
+	if err = d.Decode(&fields); err != nil {
+		return nil, -1, err
+	}
+	if active, _ := fields["active"].(bool); !active {
+		return nil, -1, ErrInvalidToken
+	}
+
+	ret := &VerifiedToken{Token: token, Claims: fields}
+	ret.ClientId, _ = fields["client_id"].(string)
+	ret.Subject, _ = fields["sub"].(string)
+	ret.Scope, _ = fields["scope"].(string)
+	ret.IssuedAt = numericDate(fields["iat"])
+	ret.ExpiresAt = numericDate(fields["exp"])
+	if !ret.ExpiresAt.IsZero() && !i.now().Before(ret.ExpiresAt) {
+		return nil, -1, ErrInvalidToken
+	}
+	return ret, responseMaxAge(resp.Header), nil
+}
+
+// numericDate converts a JSON NumericDate, zero if invalid
+func numericDate(v interface{}) time.Time {
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(int64(f), 0)
+}
+
+// responseMaxAge returns the max-age of the Cache-Control header, zero for
+// no-store and no-cache, negative if not set
+func responseMaxAge(h http.Header) time.Duration {
+	for _, d := range strings.Split(h.Get("Cache-Control"), ",") {
+		d = strings.TrimSpace(d)
+		if d == "no-store" || d == "no-cache" {
+			return 0
+		}
+		if strings.HasPrefix(d, "max-age=") {
+			if n, err := strconv.Atoi(strings.TrimPrefix(d, "max-age=")); err == nil {
+				return time.Duration(n) * time.Second
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,80 @@
+package osin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRemoteIntrospector(t *testing.T) {
+	server := NewServer(NewServerConfig(), NewTestingStorage())
+	server.Storage.(*TestingStorage).access["9999"].Subject = "user-1"
+	calls, fail := 0, false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		resp := server.NewResponse()
+		defer resp.Close()
+		if ir := server.HandleIntrospectionRequest(resp, r); ir != nil {
+			server.FinishIntrospectionRequest(resp, r, ir)
+		}
+		OutputJSON(resp, w, r)
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	i := &RemoteIntrospector{
+		URL:              srv.URL,
+		ClientID:         "1234",
+		ClientSecret:     "aabbccdd",
+		NegativeCacheTTL: time.Minute,
+		FailureThreshold: 2,
+		Now:              func() time.Time { return now },
+	}
+	v := &Verifier{Introspector: i}
+
+	vt, err := v.Verify(context.Background(), "9999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vt.ClientId != "1234" || vt.Subject != "user-1" {
+		t.Errorf("Unexpected token: %+v", vt)
+	}
+	if _, err = v.Verify(context.Background(), "unknown"); err != ErrInvalidToken {
+		t.Errorf("Expected %v, got %v", ErrInvalidToken, err)
+	}
+
+	// active and inactive results are cached
+	v.Verify(context.Background(), "9999")
+	v.Verify(context.Background(), "unknown")
+	if calls != 2 {
+		t.Errorf("Expected 2 calls, got %d", calls)
+	}
+
+	// the circuit opens after repeated failures
+	now = now.Add(2 * time.Minute)
+	fail = true
+	for n := 0; n < 2; n++ {
+		if _, err = v.Verify(context.Background(), "9999"); err == nil || err == ErrIntrospectionUnavailable {
+			t.Errorf("Expected endpoint failure, got %v", err)
+		}
+	}
+	if _, err = v.Verify(context.Background(), "9999"); err != ErrIntrospectionUnavailable {
+		t.Errorf("Expected %v, got %v", ErrIntrospectionUnavailable, err)
+	}
+	if calls != 4 {
+		t.Errorf("Calls should be suspended while open, got %d", calls)
+	}
+
+	// and closes once the open duration elapses
+	fail = false
+	now = now.Add(time.Minute)
+	if _, err = v.Verify(context.Background(), "9999"); err != nil {
+		t.Errorf("Unexpected error after the circuit closed: %v", err)
+	}
+}
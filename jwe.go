@@ -0,0 +1,323 @@
+package osin
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+var (
+	ErrJWEMalformed = errors.New("malformed jwe")
+	ErrJWEAlgorithm = errors.New("unsupported jwe algorithm")
+	ErrJWEDecrypt   = errors.New("jwe decryption failed")
+)
+
+// EncryptionConfig is the key and algorithms a client registered to receive
+// encrypted JWTs (JWE, RFC 7516). Key management algorithms are RSA-OAEP,
+// RSA-OAEP-256 and ECDH-ES; content encryption algorithms are A128GCM,
+// A192GCM and A256GCM.
+type EncryptionConfig struct {
+	// Public key of the client, an *rsa.PublicKey or *ecdsa.PublicKey
+	Key crypto.PublicKey
+
+	// Key id of the client key, if any
+	KeyID string
+
+	// Key management algorithm ("alg"), default RSA-OAEP-256 for RSA keys
+	// and ECDH-ES for EC keys
+	Algorithm string
+
+	// Content encryption algorithm ("enc"), default A256GCM
+	Encryption string
+}
+
+// ClientEncryption is an optional interface clients can implement to
+// receive encrypted ID tokens and UserInfo responses
+type ClientEncryption interface {
+	// GetIDTokenEncryption returns the encryption of the ID tokens, or nil
+	// if they are only signed
+	GetIDTokenEncryption() *EncryptionConfig
+
+	// GetUserInfoEncryption returns the encryption of the JWT UserInfo
+	// responses, or nil if they are only signed
+	GetUserInfoEncryption() *EncryptionConfig
+}
+
+// EncryptJWT encrypts a signed JWT, producing a nested JWS-in-JWE token
+func EncryptJWT(jws string, cfg *EncryptionConfig) (string, error) {
+	return encryptJWE([]byte(jws), map[string]interface{}{"cty": "JWT"}, cfg)
+}
+
+func encryptJWE(plaintext []byte, header map[string]interface{}, cfg *EncryptionConfig) (string, error) {
+	enc := cfg.Encryption
+	if enc == "" {
+		enc = "A256GCM"
+	}
+	keySize, err := jweKeySize(enc)
+	if err != nil {
+		return "", err
+	}
+	alg := cfg.Algorithm
+
+	var cek, encryptedKey []byte
+	switch pub := cfg.Key.(type) {
+	case *rsa.PublicKey:
+		if alg == "" {
+			alg = "RSA-OAEP-256"
+		}
+		h, err := jweOAEPHash(alg)
+		if err != nil {
+			return "", err
+		}
+		cek = make([]byte, keySize)
+		if _, err = rand.Read(cek); err != nil {
+			return "", err
+		}
+		if encryptedKey, err = rsa.EncryptOAEP(h, rand.Reader, pub, cek, nil); err != nil {
+			return "", err
+		}
+	case *ecdsa.PublicKey:
+		if alg == "" {
+			alg = "ECDH-ES"
+		}
+		if alg != "ECDH-ES" {
+			return "", ErrJWEAlgorithm
+		}
+		remote, err := pub.ECDH()
+		if err != nil {
+			return "", err
+		}
+		ephemeral, err := remote.Curve().GenerateKey(rand.Reader)
+		if err != nil {
+			return "", err
+		}
+		z, err := ephemeral.ECDH(remote)
+		if err != nil {
+			return "", err
+		}
+		header["epk"] = ecdhJSONWebKey(ephemeral.PublicKey(), pub)
+		cek = concatKDF(z, enc, keySize)
+	default:
+		return "", fmt.Errorf("unsupported encryption key type %T", cfg.Key)
+	}
+
+	header["alg"] = alg
+	header["enc"] = enc
+	if cfg.KeyID != "" {
+		header["kid"] = cfg.KeyID
+	}
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	protected := base64.RawURLEncoding.EncodeToString(h)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(iv); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, plaintext, []byte(protected))
+	ciphertext, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+
+	enc64 := base64.RawURLEncoding.EncodeToString
+	return strings.Join([]string{protected, enc64(encryptedKey), enc64(iv), enc64(ciphertext), enc64(tag)}, "."), nil
+}
+
+// DecryptJWE decrypts a compact JWE with the private key, an
+// *rsa.PrivateKey or *ecdsa.PrivateKey, returning the plaintext
+func DecryptJWE(token string, key crypto.PrivateKey) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return nil, ErrJWEMalformed
+	}
+	var raw [5][]byte
+	for i, p := range parts {
+		b, err := base64.RawURLEncoding.DecodeString(p)
+		if err != nil {
+			return nil, ErrJWEMalformed
+		}
+		raw[i] = b
+	}
+	var header struct {
+		Alg string      `json:"alg"`
+		Enc string      `json:"enc"`
+		Epk *JSONWebKey `json:"epk"`
+	}
+	if err := json.Unmarshal(raw[0], &header); err != nil {
+		return nil, ErrJWEMalformed
+	}
+	keySize, err := jweKeySize(header.Enc)
+	if err != nil {
+		return nil, err
+	}
+
+	var cek []byte
+	switch priv := key.(type) {
+	case *rsa.PrivateKey:
+		h, err := jweOAEPHash(header.Alg)
+		if err != nil {
+			return nil, err
+		}
+		if cek, err = rsa.DecryptOAEP(h, nil, priv, raw[1], nil); err != nil || len(cek) != keySize {
+			return nil, ErrJWEDecrypt
+		}
+	case *ecdsa.PrivateKey:
+		if header.Alg != "ECDH-ES" || header.Epk == nil {
+			return nil, ErrJWEAlgorithm
+		}
+		epk, err := header.Epk.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		ecpub, ok := epk.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, ErrJWEMalformed
+		}
+		remote, err := ecpub.ECDH()
+		if err != nil {
+			return nil, ErrJWEMalformed
+		}
+		local, err := priv.ECDH()
+		if err != nil {
+			return nil, err
+		}
+		z, err := local.ECDH(remote)
+		if err != nil {
+			return nil, ErrJWEDecrypt
+		}
+		cek = concatKDF(z, header.Enc, keySize)
+	default:
+		return nil, fmt.Errorf("unsupported decryption key type %T", key)
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw[2]) != gcm.NonceSize() {
+		return nil, ErrJWEMalformed
+	}
+	plaintext, err := gcm.Open(nil, raw[2], append(raw[3], raw[4]...), []byte(parts[0]))
+	if err != nil {
+		return nil, ErrJWEDecrypt
+	}
+	return plaintext, nil
+}
+
+func jweKeySize(enc string) (int, error) {
+	switch enc {
+	case "A128GCM":
+		return 16, nil
+	case "A192GCM":
+		return 24, nil
+	case "A256GCM":
+		return 32, nil
+	}
+	return 0, ErrJWEAlgorithm
+}
+
+func jweOAEPHash(alg string) (hash.Hash, error) {
+	switch alg {
+	case "RSA-OAEP":
+		return sha1.New(), nil
+	case "RSA-OAEP-256":
+		return sha256.New(), nil
+	}
+	return nil, ErrJWEAlgorithm
+}
+
+// concatKDF derives the content encryption key of ECDH-ES in direct key
+// agreement mode (RFC 7518 section 4.6.2), without PartyUInfo and PartyVInfo
+func concatKDF(z []byte, enc string, keySize int) []byte {
+	otherInfo := make([]byte, 0, 4+len(enc)+12)
+	otherInfo = binary.BigEndian.AppendUint32(otherInfo, uint32(len(enc)))
+	otherInfo = append(otherInfo, enc...)
+	otherInfo = binary.BigEndian.AppendUint32(otherInfo, 0) // PartyUInfo
+	otherInfo = binary.BigEndian.AppendUint32(otherInfo, 0) // PartyVInfo
+	otherInfo = binary.BigEndian.AppendUint32(otherInfo, uint32(keySize*8))
+
+	var ret []byte
+	for counter := uint32(1); len(ret) < keySize; counter++ {
+		h := sha256.New()
+		binary.Write(h, binary.BigEndian, counter)
+		h.Write(z)
+		h.Write(otherInfo)
+		ret = h.Sum(ret)
+	}
+	return ret[:keySize]
+}
+
+// ecdhJSONWebKey encodes an ephemeral ECDH public key as a JWK
+func ecdhJSONWebKey(pub *ecdh.PublicKey, like *ecdsa.PublicKey) JSONWebKey {
+	b := pub.Bytes() // uncompressed point 0x04 || X || Y
+	size := (len(b) - 1) / 2
+	return JSONWebKey{
+		Kty: "EC",
+		Crv: like.Curve.Params().Name,
+		X:   base64.RawURLEncoding.EncodeToString(b[1 : 1+size]),
+		Y:   base64.RawURLEncoding.EncodeToString(b[1+size:]),
+	}
+}
+
+// encodeClientJWT signs the claims with the server key set, and encrypts
+// them with the client configuration, if any
+func (s *Server) encodeClientJWT(claims interface{}, encryption *EncryptionConfig) (string, error) {
+	if s.KeySet == nil {
+		return "", errors.New("server has no KeySet")
+	}
+	jws, err := s.KeySet.Sign(claims)
+	if err != nil {
+		return "", err
+	}
+	if encryption == nil || encryption.Key == nil {
+		return jws, nil
+	}
+	return EncryptJWT(jws, encryption)
+}
+
+// EncodeIDToken signs the ID token claims with the server KeySet, and
+// encrypts them if the client implements ClientEncryption with an ID token
+// encryption key
+func (s *Server) EncodeIDToken(client Client, claims interface{}) (string, error) {
+	var encryption *EncryptionConfig
+	if ce, ok := client.(ClientEncryption); ok {
+		encryption = ce.GetIDTokenEncryption()
+	}
+	return s.encodeClientJWT(claims, encryption)
+}
+
+// EncodeUserInfo signs the UserInfo claims with the server KeySet, for JWT
+// UserInfo responses, and encrypts them if the client implements
+// ClientEncryption with a UserInfo encryption key
+func (s *Server) EncodeUserInfo(client Client, claims interface{}) (string, error) {
+	var encryption *EncryptionConfig
+	if ce, ok := client.(ClientEncryption); ok {
+		encryption = ce.GetUserInfoEncryption()
+	}
+	return s.encodeClientJWT(claims, encryption)
+}
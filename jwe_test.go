@@ -0,0 +1,84 @@
+package osin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+)
+
+type testEncryptedClient struct {
+	DefaultClient
+	IDTokenEncryption *EncryptionConfig
+}
+
+func (c *testEncryptedClient) GetIDTokenEncryption() *EncryptionConfig {
+	return c.IDTokenEncryption
+}
+
+func (c *testEncryptedClient) GetUserInfoEncryption() *EncryptionConfig {
+	return nil
+}
+
+func TestEncodeIDTokenEncrypted(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServer(NewServerConfig(), NewTestingStorage())
+	if server.KeySet, err = NewKeySet(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		Encryption *EncryptionConfig
+		Private    interface{}
+	}{
+		"rsa-oaep-256": {&EncryptionConfig{Key: &rsaKey.PublicKey}, rsaKey},
+		"rsa-oaep":     {&EncryptionConfig{Key: &rsaKey.PublicKey, Algorithm: "RSA-OAEP", Encryption: "A128GCM"}, rsaKey},
+		"ecdh-es":      {&EncryptionConfig{Key: &ecKey.PublicKey, KeyID: "enc-1"}, ecKey},
+	}
+
+	for k, test := range tests {
+		client := &testEncryptedClient{
+			DefaultClient:     DefaultClient{Id: "1234"},
+			IDTokenEncryption: test.Encryption,
+		}
+		token, err := server.EncodeIDToken(client, map[string]interface{}{"sub": "user-1"})
+		if err != nil {
+			t.Fatalf("%s: %s", k, err)
+		}
+
+		jws, err := DecryptJWE(token, test.Private)
+		if err != nil {
+			t.Fatalf("%s: %s", k, err)
+		}
+		jwt, err := server.KeySet.Verify(string(jws))
+		if err != nil {
+			t.Fatalf("%s: nested token should verify: %s", k, err)
+		}
+		if jwt.StringClaim("sub") != "user-1" {
+			t.Fatalf("%s: unexpected claims %v", k, jwt.Claims)
+		}
+
+		// tampered tokens don't decrypt
+		if _, err = DecryptJWE(token[:len(token)-2]+"AA", test.Private); err == nil {
+			t.Fatalf("%s: tampered token should not decrypt", k)
+		}
+	}
+
+	// clients without encryption get a signed token
+	token, err := server.EncodeIDToken(&DefaultClient{Id: "1234"}, map[string]interface{}{"sub": "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = server.KeySet.Verify(token); err != nil {
+		t.Fatalf("Token should be signed only: %s", err)
+	}
+}
@@ -0,0 +1,133 @@
+package osin
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// JSONWebKey is a public JSON Web Key (RFC 7517)
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// NewJSONWebKey encodes an RSA, ECDSA or Ed25519 public key
+func NewJSONWebKey(pub crypto.PublicKey, kid string, alg string) (JSONWebKey, error) {
+	enc := base64.RawURLEncoding
+	ret := JSONWebKey{Kid: kid, Use: "sig", Alg: alg}
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		ret.Kty = "RSA"
+		ret.N = enc.EncodeToString(k.N.Bytes())
+		ret.E = enc.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+	case *ecdsa.PublicKey:
+		ret.Kty = "EC"
+		ret.Crv = k.Curve.Params().Name
+		size := (k.Curve.Params().BitSize + 7) / 8
+		x, y := make([]byte, size), make([]byte, size)
+		k.X.FillBytes(x)
+		k.Y.FillBytes(y)
+		ret.X = enc.EncodeToString(x)
+		ret.Y = enc.EncodeToString(y)
+	case ed25519.PublicKey:
+		ret.Kty = "OKP"
+		ret.Crv = "Ed25519"
+		ret.X = enc.EncodeToString(k)
+	default:
+		return ret, fmt.Errorf("unsupported key type %T", pub)
+	}
+	return ret, nil
+}
+
+// PublicKey decodes the key, in the types accepted by JWT.Verify
+func (k JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	dec := base64.RawURLEncoding
+	switch k.Kty {
+	case "RSA":
+		n, err := dec.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := dec.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := dec.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := dec.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := dec.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+// JSONWebKeySet is a JWK Set, as published on a jwks_uri
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// VerifyJWT verifies the token with the key of its "kid" header, or with
+// each signature key if it has none
+func (s JSONWebKeySet) VerifyJWT(t *JWT) error {
+	kid := t.KeyID()
+	for _, k := range s.Keys {
+		if (kid != "" && k.Kid != kid) || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		pub, err := k.PublicKey()
+		if err != nil {
+			continue
+		}
+		if t.Verify(pub) == nil {
+			return nil
+		}
+	}
+	return ErrJWTSignature
+}
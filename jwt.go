@@ -0,0 +1,384 @@
+package osin
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	ErrJWTMalformed        = errors.New("malformed jwt")
+	ErrJWTSignature        = errors.New("invalid jwt signature")
+	ErrJWTAlgorithm        = errors.New("unsupported jwt algorithm")
+	ErrJWTKeyMismatch      = errors.New("jwt key doesn't match the algorithm")
+	ErrJWTExpired          = errors.New("jwt is expired")
+	ErrJWTNotYetValid      = errors.New("jwt is not yet valid")
+	ErrJWTAudienceMismatch = errors.New("jwt audience mismatch")
+)
+
+// ContextSigner is an optional interface crypto.Signer keys can implement
+// to sign with a context, like HSM or remote keys honoring cancellation
+type ContextSigner interface {
+	SignContext(ctx context.Context, rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error)
+}
+
+// JWT is a parsed, compact serialized JSON Web Token (RFC 7519). Its claims
+// must not be trusted before Verify succeeds.
+type JWT struct {
+	Header map[string]interface{}
+	Claims map[string]interface{}
+
+	signingInput string
+	signature    []byte
+}
+
+// ParseJWT parses a compact serialized JWT, without verifying it
+func ParseJWT(token string) (*JWT, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrJWTMalformed
+	}
+	ret := &JWT{signingInput: parts[0] + "." + parts[1]}
+
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+	if err = json.Unmarshal(header, &ret.Header); err != nil {
+		return nil, ErrJWTMalformed
+	}
+	claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+	d := json.NewDecoder(strings.NewReader(string(claims)))
+	d.UseNumber()
+	if err = d.Decode(&ret.Claims); err != nil {
+		return nil, ErrJWTMalformed
+	}
+	if ret.signature, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+		return nil, ErrJWTMalformed
+	}
+	return ret, nil
+}
+
+// Algorithm returns the "alg" header
+func (t *JWT) Algorithm() string {
+	v, _ := t.Header["alg"].(string)
+	return v
+}
+
+// KeyID returns the "kid" header
+func (t *JWT) KeyID() string {
+	v, _ := t.Header["kid"].(string)
+	return v
+}
+
+// StringClaim returns a string claim, or "" if missing
+func (t *JWT) StringClaim(name string) string {
+	v, _ := t.Claims[name].(string)
+	return v
+}
+
+// TimeClaim returns a NumericDate claim, like "exp"
+func (t *JWT) TimeClaim(name string) (time.Time, bool) {
+	n, ok := t.Claims[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+// HasAudience returns true if the "aud" claim, a string or a list, contains aud
+func (t *JWT) HasAudience(aud string) bool {
+	switch v := t.Claims["aud"].(type) {
+	case string:
+		return v == aud
+	case []interface{}:
+		for _, a := range v {
+			if a == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ValidateTimes verifies the "exp" and "nbf" claims at time 'now', with
+// leeway for clock skew. The "exp" claim is required.
+func (t *JWT) ValidateTimes(now time.Time, leeway time.Duration) error {
+	exp, ok := t.TimeClaim("exp")
+	if !ok {
+		return errors.New("jwt has no exp claim")
+	}
+	if !now.Add(-leeway).Before(exp) {
+		return ErrJWTExpired
+	}
+	if nbf, ok := t.TimeClaim("nbf"); ok && now.Add(leeway).Before(nbf) {
+		return ErrJWTNotYetValid
+	}
+	return nil
+}
+
+// Verify verifies the signature with the key: a []byte secret for the HS
+// algorithms, an *rsa.PublicKey for RS and PS, an *ecdsa.PublicKey for ES
+// and an ed25519.PublicKey for EdDSA. The "none" algorithm is never accepted.
+func (t *JWT) Verify(key interface{}) error {
+	alg := t.Algorithm()
+	switch alg {
+	case "HS256", "HS384", "HS512":
+		secret, ok := key.([]byte)
+		if !ok {
+			return ErrJWTKeyMismatch
+		}
+		mac := hmac.New(jwtHash(alg).New, secret)
+		mac.Write([]byte(t.signingInput))
+		if !hmac.Equal(mac.Sum(nil), t.signature) {
+			return ErrJWTSignature
+		}
+		return nil
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrJWTKeyMismatch
+		}
+		h := jwtHash(alg)
+		digest := jwtDigest(h, t.signingInput)
+		var err error
+		if alg[0] == 'P' {
+			err = rsa.VerifyPSS(pub, h, digest, t.signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			err = rsa.VerifyPKCS1v15(pub, h, digest, t.signature)
+		}
+		if err != nil {
+			return ErrJWTSignature
+		}
+		return nil
+	case "ES256", "ES384", "ES512":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrJWTKeyMismatch
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(t.signature) != 2*size {
+			return ErrJWTSignature
+		}
+		r := new(big.Int).SetBytes(t.signature[:size])
+		s := new(big.Int).SetBytes(t.signature[size:])
+		if !ecdsa.Verify(pub, jwtDigest(jwtHash(alg), t.signingInput), r, s) {
+			return ErrJWTSignature
+		}
+		return nil
+	case "EdDSA":
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return ErrJWTKeyMismatch
+		}
+		if !ed25519.Verify(pub, []byte(t.signingInput), t.signature) {
+			return ErrJWTSignature
+		}
+		return nil
+	}
+	return ErrJWTAlgorithm
+}
+
+// SignJWT serializes and signs the claims. key is a []byte secret for the
+// HS algorithms, or a crypto.Signer holding an RSA, ECDSA or Ed25519 private
+// key, including keys held by a KMS or HSM.
+func SignJWT(claims interface{}, alg string, kid string, key interface{}) (string, error) {
+	header := map[string]interface{}{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	return SignJWTWithHeader(claims, header, key)
+}
+
+// SignJWTWithHeader serializes and signs the claims with a custom header,
+// which must hold the "alg"
+func SignJWTWithHeader(claims interface{}, header map[string]interface{}, key interface{}) (string, error) {
+	return SignJWTContext(context.Background(), claims, header, key)
+}
+
+// SignJWTContext is SignJWTWithHeader returning ctx.Err() if ctx is done
+// before the signature completes. Keys implementing ContextSigner are given
+// ctx, the others are left signing in the background.
+func SignJWTContext(ctx context.Context, claims interface{}, header map[string]interface{}, key interface{}) (string, error) {
+	alg, _ := header["alg"].(string)
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+
+	var sig []byte
+	switch alg {
+	case "HS256", "HS384", "HS512":
+		secret, ok := key.([]byte)
+		if !ok {
+			return "", ErrJWTKeyMismatch
+		}
+		mac := hmac.New(jwtHash(alg).New, secret)
+		mac.Write([]byte(signingInput))
+		sig = mac.Sum(nil)
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA":
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return "", ErrJWTKeyMismatch
+		}
+		if sig, err = signJWTInput(ctx, signer, alg, signingInput); err != nil {
+			return "", err
+		}
+	default:
+		return "", ErrJWTAlgorithm
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func signJWTInput(ctx context.Context, signer crypto.Signer, alg string, signingInput string) ([]byte, error) {
+	switch pub := signer.Public().(type) {
+	case *rsa.PublicKey:
+		if alg[0] != 'R' && alg[0] != 'P' {
+			return nil, ErrJWTKeyMismatch
+		}
+		h := jwtHash(alg)
+		var opts crypto.SignerOpts = h
+		if alg[0] == 'P' {
+			opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: h}
+		}
+		return signDigest(ctx, signer, jwtDigest(h, signingInput), opts)
+	case *ecdsa.PublicKey:
+		if alg[0] != 'E' || alg == "EdDSA" {
+			return nil, ErrJWTKeyMismatch
+		}
+		h := jwtHash(alg)
+		der, err := signDigest(ctx, signer, jwtDigest(h, signingInput), h)
+		if err != nil {
+			return nil, err
+		}
+		// convert the ASN.1 signature to the fixed size R || S of JWS
+		r, s, err := parseECDSASignature(der)
+		if err != nil {
+			return nil, err
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		sig := make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+		return sig, nil
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			return nil, ErrJWTKeyMismatch
+		}
+		return signDigest(ctx, signer, []byte(signingInput), crypto.Hash(0))
+	}
+	return nil, fmt.Errorf("unsupported signer key type %T", signer.Public())
+}
+
+// signDigest signs with the signer, returning ctx.Err() if ctx is done
+// first
+func signDigest(ctx context.Context, signer crypto.Signer, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if cs, ok := signer.(ContextSigner); ok {
+		return cs.SignContext(ctx, rand.Reader, digest, opts)
+	}
+	if ctx.Done() == nil {
+		return signer.Sign(rand.Reader, digest, opts)
+	}
+	type result struct {
+		sig []byte
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		sig, err := signer.Sign(rand.Reader, digest, opts)
+		ch <- result{sig, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.sig, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// parseECDSASignature decodes an ASN.1 DER ECDSA signature
+func parseECDSASignature(der []byte) (*big.Int, *big.Int, error) {
+	var sig struct {
+		R, S *big.Int
+	}
+	rest, err := asn1.Unmarshal(der, &sig)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rest) > 0 {
+		return nil, nil, errors.New("trailing data after ecdsa signature")
+	}
+	return sig.R, sig.S, nil
+}
+
+// JWTAlgorithmForKey returns the default JWS algorithm of a public key
+func JWTAlgorithmForKey(pub crypto.PublicKey) (string, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return "RS256", nil
+	case *ecdsa.PublicKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			return "ES256", nil
+		case 384:
+			return "ES384", nil
+		case 521:
+			return "ES512", nil
+		}
+	case ed25519.PublicKey:
+		return "EdDSA", nil
+	}
+	return "", fmt.Errorf("unsupported key type %T", pub)
+}
+
+func jwtHash(alg string) crypto.Hash {
+	switch alg[len(alg)-3:] {
+	case "384":
+		return crypto.SHA384
+	case "512":
+		return crypto.SHA512
+	}
+	return crypto.SHA256
+}
+
+func jwtDigest(h crypto.Hash, input string) []byte {
+	var hh hash.Hash
+	switch h {
+	case crypto.SHA384:
+		hh = sha512.New384()
+	case crypto.SHA512:
+		hh = sha512.New()
+	default:
+		hh = sha256.New()
+	}
+	hh.Write([]byte(input))
+	return hh.Sum(nil)
+}
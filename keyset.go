@@ -0,0 +1,415 @@
+package osin
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// A new signing key was activated. Data["kid"] holds its key id and
+	// Data["previous"] the one of the replaced key, if any.
+	EVENT_KEY_ROTATED EventType = "key_rotated"
+
+	// A key was generated and published ahead of its activation.
+	// Data["kid"] holds its key id and Data["activates_at"] the activation time.
+	EVENT_KEY_PUBLISHED EventType = "key_published"
+
+	// A replaced key left the key set. Data["kid"] holds its key id.
+	EVENT_KEY_EXPIRED EventType = "key_expired"
+
+	// Scheduled key maintenance failed. Data["error"] holds the error.
+	EVENT_KEY_ROTATION_FAILED EventType = "key_rotation_failed"
+)
+
+// SigningKey is a key of a KeySet
+type SigningKey struct {
+	// Key id, published as "kid"
+	ID string
+
+	// JWS algorithm
+	Algorithm string
+
+	// Private key
+	Signer crypto.Signer
+
+	// Time the key starts signing. It is published before.
+	ActivatesAt time.Time
+}
+
+// KeySet holds the signing keys of the JWTs issued by the server, rotating
+// them on a schedule. New keys are published ahead of their activation, the
+// newest active key signs, and replaced keys are published and accepted for
+// verification during a grace period. Keys are held in memory, use AddKey to
+// restore persisted ones.
+type KeySet struct {
+	// Generates new keys. ECDSA P-256 (ES256) keys are generated if nil.
+	Generate func() (crypto.Signer, error)
+
+	// Time a key signs before being replaced, 7 days with NewKeySet. Keys
+	// are rotated by Maintain only if positive, see StartRotation.
+	RotationInterval time.Duration
+
+	// Time a new key is published before its activation, so verifiers
+	// caching the JWKS fetch it in time, default 1 hour
+	PrepublishPeriod time.Duration
+
+	// Time a replaced key remains published and accepted for verification,
+	// default 24 hours. It should exceed the lifetime of the signed tokens.
+	GracePeriod time.Duration
+
+	// Time limit of each signature, for HSM or remote keys. No limit if 0
+	// (the default).
+	SignTimeout time.Duration
+
+	// Listeners receiving the key events
+	EventListeners []EventListener
+
+	// Returns the current time, time.Now if nil
+	Now func() time.Time
+
+	mu       sync.RWMutex
+	keys     []*SigningKey // ordered by activation
+	activeID string        // id of the key last reported active
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewKeySet creates a key set with the default durations and an initial key
+func NewKeySet() (*KeySet, error) {
+	ks := &KeySet{
+		RotationInterval: 7 * 24 * time.Hour,
+		PrepublishPeriod: time.Hour,
+		GracePeriod:      24 * time.Hour,
+	}
+	if _, err := ks.Rotate(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+func (ks *KeySet) now() time.Time {
+	if ks.Now != nil {
+		return ks.Now()
+	}
+	return time.Now()
+}
+
+func (ks *KeySet) emit(typ EventType, data map[string]interface{}) {
+	e := &Event{Type: typ, Time: ks.now(), Data: data}
+	for _, l := range ks.EventListeners {
+		l.HandleEvent(e)
+	}
+}
+
+// newKey generates a key activating at the time
+func (ks *KeySet) newKey(activatesAt time.Time) (*SigningKey, error) {
+	generate := ks.Generate
+	if generate == nil {
+		generate = func() (crypto.Signer, error) {
+			return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		}
+	}
+	signer, err := generate()
+	if err != nil {
+		return nil, err
+	}
+	id, err := RandomString(BASE62_ALPHABET, 16)
+	if err != nil {
+		return nil, err
+	}
+	key, err := NewSigningKey(id, signer)
+	if err != nil {
+		return nil, err
+	}
+	key.ActivatesAt = activatesAt
+	return key, nil
+}
+
+// NewSigningKey creates an active key from a signer, like a PKCS #11 or KMS
+// key, with the default algorithm of its public key
+func NewSigningKey(id string, signer crypto.Signer) (*SigningKey, error) {
+	alg, err := JWTAlgorithmForKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{ID: id, Algorithm: alg, Signer: signer}, nil
+}
+
+// AddKey adds an existing key, like one restored from persistent storage
+func (ks *KeySet) AddKey(key *SigningKey) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = append(ks.keys, key)
+	sort.SliceStable(ks.keys, func(i, j int) bool { return ks.keys[i].ActivatesAt.Before(ks.keys[j].ActivatesAt) })
+}
+
+// Rotate immediately activates a new key, dropping the keys published
+// ahead but not yet active. The replaced key stays valid for verification
+// during the grace period.
+func (ks *KeySet) Rotate() (*SigningKey, error) {
+	now := ks.now()
+	key, err := ks.newKey(now)
+	if err != nil {
+		return nil, err
+	}
+
+	ks.mu.Lock()
+	previous := ks.active(now)
+	kept := ks.keys[:0]
+	for _, k := range ks.keys {
+		if !k.ActivatesAt.After(now) {
+			kept = append(kept, k)
+		}
+	}
+	ks.keys = append(kept, key)
+	ks.activeID = key.ID
+	ks.mu.Unlock()
+
+	data := map[string]interface{}{"kid": key.ID}
+	if previous != nil {
+		data["previous"] = previous.ID
+	}
+	ks.emit(EVENT_KEY_ROTATED, data)
+	return key, nil
+}
+
+// Maintain publishes the next key when its activation approaches, reports
+// the activation of published keys and drops the expired ones. Called
+// periodically by StartRotation.
+func (ks *KeySet) Maintain() error {
+	now := ks.now()
+	interval := ks.RotationInterval
+
+	ks.mu.Lock()
+	current := ks.active(now)
+	previous := ks.activeID
+	activated := current != nil && previous != "" && current.ID != previous
+	if current != nil {
+		ks.activeID = current.ID
+	}
+
+	// drop the keys replaced for longer than the grace period
+	var expired []string
+	kept := ks.keys[:0]
+	for i, k := range ks.keys {
+		if i+1 < len(ks.keys) && !ks.keys[i+1].ActivatesAt.Add(ks.gracePeriod()).After(now) {
+			expired = append(expired, k.ID)
+			continue
+		}
+		kept = append(kept, k)
+	}
+	ks.keys = kept
+
+	// publish the next key ahead of its activation
+	var next time.Time
+	if current == nil {
+		next = now
+	} else if last := ks.keys[len(ks.keys)-1]; interval > 0 && !last.ActivatesAt.After(now) {
+		next = last.ActivatesAt.Add(interval)
+		if next.Add(-ks.prepublishPeriod()).After(now) {
+			next = time.Time{}
+		} else if next.Before(now) {
+			next = now
+		}
+	}
+	ks.mu.Unlock()
+
+	if activated {
+		ks.emit(EVENT_KEY_ROTATED, map[string]interface{}{"kid": current.ID, "previous": previous})
+	}
+	for _, id := range expired {
+		ks.emit(EVENT_KEY_EXPIRED, map[string]interface{}{"kid": id})
+	}
+	if next.IsZero() {
+		return nil
+	}
+
+	key, err := ks.newKey(next)
+	if err != nil {
+		return err
+	}
+	ks.AddKey(key)
+	if next.Equal(now) {
+		ks.mu.Lock()
+		ks.activeID = key.ID
+		ks.mu.Unlock()
+		ks.emit(EVENT_KEY_ROTATED, map[string]interface{}{"kid": key.ID})
+	} else {
+		ks.emit(EVENT_KEY_PUBLISHED, map[string]interface{}{"kid": key.ID, "activates_at": next})
+	}
+	return nil
+}
+
+func (ks *KeySet) prepublishPeriod() time.Duration {
+	if ks.PrepublishPeriod > 0 {
+		return ks.PrepublishPeriod
+	}
+	return time.Hour
+}
+
+func (ks *KeySet) gracePeriod() time.Duration {
+	if ks.GracePeriod > 0 {
+		return ks.GracePeriod
+	}
+	return 24 * time.Hour
+}
+
+// active returns the newest key active at the time. Must hold the lock.
+func (ks *KeySet) active(t time.Time) *SigningKey {
+	for i := len(ks.keys) - 1; i >= 0; i-- {
+		if !ks.keys[i].ActivatesAt.After(t) {
+			return ks.keys[i]
+		}
+	}
+	return nil
+}
+
+// Algorithms returns the distinct algorithms of the keys, for metadata
+func (ks *KeySet) Algorithms() []string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	var ret []string
+	seen := make(map[string]bool)
+	for _, k := range ks.keys {
+		if !seen[k.Algorithm] {
+			seen[k.Algorithm] = true
+			ret = append(ret, k.Algorithm)
+		}
+	}
+	return ret
+}
+
+// SigningKey returns the key currently signing
+func (ks *KeySet) SigningKey() (*SigningKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if key := ks.active(ks.now()); key != nil {
+		return key, nil
+	}
+	return nil, errors.New("key set has no active key")
+}
+
+// Sign signs the claims with the current key
+func (ks *KeySet) Sign(claims interface{}) (string, error) {
+	return ks.SignContext(context.Background(), claims)
+}
+
+// SignContext signs the claims with the current key, failing when ctx is
+// done or after SignTimeout
+func (ks *KeySet) SignContext(ctx context.Context, claims interface{}) (string, error) {
+	key, err := ks.SigningKey()
+	if err != nil {
+		return "", err
+	}
+	header := map[string]interface{}{"alg": key.Algorithm, "typ": "JWT"}
+	if key.ID != "" {
+		header["kid"] = key.ID
+	}
+	return ks.signWithKey(ctx, key, claims, header)
+}
+
+// signWithKey signs the claims with a key of the set, within SignTimeout
+func (ks *KeySet) signWithKey(ctx context.Context, key *SigningKey, claims interface{}, header map[string]interface{}) (string, error) {
+	if ks.SignTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ks.SignTimeout)
+		defer cancel()
+	}
+	return SignJWTContext(ctx, claims, header, key.Signer)
+}
+
+// Verify parses a token and verifies its signature with the published key
+// of its "kid". Claims like "exp" must be checked by the caller.
+func (ks *KeySet) Verify(token string) (*JWT, error) {
+	t, err := ParseJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	if err = ks.JWKS().VerifyJWT(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// JWKS returns the published public keys: the ones not yet active, the
+// current one and the replaced ones still in their grace period
+func (ks *KeySet) JWKS() JSONWebKeySet {
+	now := ks.now()
+	grace := ks.gracePeriod()
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	ret := JSONWebKeySet{Keys: make([]JSONWebKey, 0, len(ks.keys))}
+	for i, k := range ks.keys {
+		if i+1 < len(ks.keys) && !ks.keys[i+1].ActivatesAt.Add(grace).After(now) {
+			continue
+		}
+		if jwk, err := NewJSONWebKey(k.Signer.Public(), k.ID, k.Algorithm); err == nil {
+			ret.Keys = append(ret.Keys, jwk)
+		}
+	}
+	return ret
+}
+
+// ServeHTTP serves the JWKS, to be registered on the jwks_uri
+func (ks *KeySet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "max-age=300")
+	json.NewEncoder(w).Encode(ks.JWKS())
+}
+
+// StartRotation runs Maintain every interval until StopRotation is called.
+// The interval should be well below PrepublishPeriod. Failures are emitted
+// as EVENT_KEY_ROTATION_FAILED.
+func (ks *KeySet) StartRotation(interval time.Duration) error {
+	if interval <= 0 {
+		return errors.New("key rotation interval must be positive")
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if ks.stop != nil {
+		return errors.New("key rotation already started")
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	ks.stop, ks.done = stop, done
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := ks.Maintain(); err != nil {
+					ks.emit(EVENT_KEY_ROTATION_FAILED, map[string]interface{}{"error": err})
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// StopRotation stops the scheduled maintenance started by StartRotation
+func (ks *KeySet) StopRotation() {
+	ks.mu.Lock()
+	stop, done := ks.stop, ks.done
+	ks.stop, ks.done = nil, nil
+	ks.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
@@ -0,0 +1,169 @@
+package osin
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestKeySetRotation(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	var events []EventType
+	ks := &KeySet{
+		RotationInterval: 24 * time.Hour,
+		PrepublishPeriod: time.Hour,
+		GracePeriod:      2 * time.Hour,
+		Now:              func() time.Time { return now },
+		EventListeners: []EventListener{EventListenerFunc(func(e *Event) {
+			events = append(events, e.Type)
+		})},
+	}
+
+	// the first maintenance activates a key
+	if err := ks.Maintain(); err != nil {
+		t.Fatal(err)
+	}
+	first, err := ks.SigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := ks.Sign(map[string]interface{}{"sub": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the next key is published before its activation, without signing
+	now = now.Add(23*time.Hour + 30*time.Minute)
+	if err = ks.Maintain(); err != nil {
+		t.Fatal(err)
+	}
+	if key, _ := ks.SigningKey(); key != first {
+		t.Fatal("The published key should not sign before its activation")
+	}
+	if n := len(ks.JWKS().Keys); n != 2 {
+		t.Fatalf("Both keys should be published, got %d", n)
+	}
+
+	// after activation, the new key signs and the old one still verifies
+	now = now.Add(time.Hour)
+	if err = ks.Maintain(); err != nil {
+		t.Fatal(err)
+	}
+	if key, _ := ks.SigningKey(); key == first {
+		t.Fatal("The new key should sign after its activation")
+	}
+	if _, err = ks.Verify(token); err != nil {
+		t.Fatalf("Token of the replaced key should verify during the grace period: %s", err)
+	}
+
+	// after the grace period, the old key is gone
+	now = now.Add(3 * time.Hour)
+	if err = ks.Maintain(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ks.Verify(token); err == nil {
+		t.Fatal("Token of the expired key should not verify")
+	}
+	if n := len(ks.JWKS().Keys); n != 1 {
+		t.Fatalf("Only the current key should be published, got %d", n)
+	}
+
+	expected := []EventType{EVENT_KEY_ROTATED, EVENT_KEY_PUBLISHED, EVENT_KEY_ROTATED, EVENT_KEY_EXPIRED}
+	if len(events) != len(expected) {
+		t.Fatalf("Unexpected events: %v", events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Fatalf("Unexpected events: %v", events)
+		}
+	}
+}
+
+func TestKeySetManualRotate(t *testing.T) {
+	ks, err := NewKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := ks.Sign(map[string]interface{}{"sub": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ks.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ks.Verify(token); err != nil {
+		t.Fatalf("Token of the replaced key should verify: %s", err)
+	}
+
+	w := httptest.NewRecorder()
+	ks.ServeHTTP(w, httptest.NewRequest("GET", "/jwks", nil))
+	if w.Code != 200 || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Unexpected JWKS response: %d", w.Code)
+	}
+}
+
+// slowSigner is a crypto.Signer, like an HSM key, blocking until release
+// is closed
+type slowSigner struct {
+	crypto.Signer
+	release chan struct{}
+}
+
+func (s *slowSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	<-s.release
+	return s.Signer.Sign(rand, digest, opts)
+}
+
+// contextSigner is a crypto.Signer implementing ContextSigner
+type contextSigner struct {
+	crypto.Signer
+	deadline time.Time
+}
+
+func (s *contextSigner) SignContext(ctx context.Context, rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.deadline, _ = ctx.Deadline()
+	return s.Signer.Sign(rand, digest, opts)
+}
+
+func TestKeySetSignTimeout(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slow := &slowSigner{Signer: priv, release: make(chan struct{})}
+	defer close(slow.release)
+	key, err := NewSigningKey("hsm-1", slow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Algorithm != "ES256" {
+		t.Fatalf("Unexpected algorithm %s", key.Algorithm)
+	}
+	ks := &KeySet{SignTimeout: 10 * time.Millisecond}
+	ks.AddKey(key)
+	if _, err = ks.Sign(map[string]interface{}{"sub": "a"}); err != context.DeadlineExceeded {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+
+	cs := &contextSigner{Signer: priv}
+	key, _ = NewSigningKey("hsm-2", cs)
+	ks = &KeySet{SignTimeout: time.Minute}
+	ks.AddKey(key)
+	token, err := ks.SignContext(context.Background(), map[string]interface{}{"sub": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.deadline.IsZero() {
+		t.Fatal("The context signer should get the timeout")
+	}
+	if jwt, err := ks.Verify(token); err != nil || jwt.KeyID() != "hsm-2" {
+		t.Fatalf("Token not verified: %v", err)
+	}
+}
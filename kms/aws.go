@@ -0,0 +1,94 @@
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+)
+
+// AWSClient is the part of the AWS KMS API used by AWSKMS. Wrap the
+// kms.Client of aws-sdk-go-v2 to implement it:
+//
+//	func (c kmsClient) GetPublicKey(ctx context.Context, keyId string) ([]byte, error) {
+//		out, err := c.Client.GetPublicKey(ctx, &kms.GetPublicKeyInput{KeyId: &keyId})
+//		if err != nil {
+//			return nil, err
+//		}
+//		return out.PublicKey, nil
+//	}
+//
+//	func (c kmsClient) Sign(ctx context.Context, keyId string, digest []byte, algorithm string) ([]byte, error) {
+//		out, err := c.Client.Sign(ctx, &kms.SignInput{KeyId: &keyId, Message: digest,
+//			MessageType: types.MessageTypeDigest, SigningAlgorithm: types.SigningAlgorithmSpec(algorithm)})
+//		if err != nil {
+//			return nil, err
+//		}
+//		return out.Signature, nil
+//	}
+type AWSClient interface {
+	// GetPublicKey returns the DER PKIX public key of the key
+	GetPublicKey(ctx context.Context, keyId string) ([]byte, error)
+
+	// Sign signs a digest with the signing algorithm, like "ECDSA_SHA_256"
+	Sign(ctx context.Context, keyId string, digest []byte, algorithm string) ([]byte, error)
+}
+
+// AWSKMS is a Backend signing with an asymmetric key of AWS KMS
+type AWSKMS struct {
+	Client AWSClient
+
+	// Key id, ARN or alias
+	KeyId string
+
+	// public key, set by PublicKey
+	public crypto.PublicKey
+}
+
+// PublicKey satisfies Backend
+func (a *AWSKMS) PublicKey(ctx context.Context) (crypto.PublicKey, error) {
+	der, err := a.Client.GetPublicKey(ctx, a.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	if a.public, err = x509.ParsePKIXPublicKey(der); err != nil {
+		return nil, err
+	}
+	return a.public, nil
+}
+
+// awsHashes are the hash suffixes of the signing algorithms
+var awsHashes = map[crypto.Hash]string{
+	crypto.SHA256: "SHA_256",
+	crypto.SHA384: "SHA_384",
+	crypto.SHA512: "SHA_512",
+}
+
+// Sign satisfies Backend
+func (a *AWSKMS) Sign(ctx context.Context, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hash, ok := awsHashes[opts.HashFunc()]
+	if !ok {
+		return nil, ErrUnsupportedAlgorithm
+	}
+	var algorithm string
+	switch a.public.(type) {
+	case *rsa.PublicKey:
+		algorithm = "RSASSA_PKCS1_V1_5_" + hash
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			// AWS KMS salts PSS with the hash length
+			if pss.SaltLength != rsa.PSSSaltLengthEqualsHash {
+				return nil, ErrUnsupportedAlgorithm
+			}
+			algorithm = "RSASSA_PSS_" + hash
+		}
+	case *ecdsa.PublicKey:
+		algorithm = "ECDSA_" + hash
+	case nil:
+		return nil, errors.New("kms: PublicKey must be called before Sign")
+	default:
+		return nil, ErrUnsupportedKey
+	}
+	return a.Client.Sign(ctx, a.KeyId, digest, algorithm)
+}
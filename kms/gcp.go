@@ -0,0 +1,94 @@
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// GCP_ENDPOINT is the Cloud KMS API endpoint
+const GCP_ENDPOINT = "https://cloudkms.googleapis.com"
+
+// GCPKMS is a Backend signing with an asymmetric key version of Google
+// Cloud KMS, through its REST API. The signature algorithm is fixed by the
+// key version, RSA keys must use PSS or PKCS #1 v1.5 as requested by osin.
+type GCPKMS struct {
+	// Key version resource name, like "projects/p/locations/global/
+	// keyRings/r/cryptoKeys/k/cryptoKeyVersions/1"
+	Name string
+
+	// Returns an OAuth access token with the cloudkms scope, like the
+	// Token of an oauth2.TokenSource. Required.
+	Token func(ctx context.Context) (string, error)
+
+	// API endpoint (default GCP_ENDPOINT)
+	Endpoint string
+
+	// HTTP client - default http.DefaultClient
+	HTTPClient *http.Client
+
+	// algorithm of the key version, set by PublicKey
+	algorithm string
+}
+
+func (g *GCPKMS) request(ctx context.Context, method, path string, in, out interface{}) error {
+	token, err := g.Token(ctx)
+	if err != nil {
+		return err
+	}
+	endpoint := g.Endpoint
+	if endpoint == "" {
+		endpoint = GCP_ENDPOINT
+	}
+	url := strings.TrimSuffix(endpoint, "/") + "/v1/" + g.Name + path
+	return doJSON(ctx, g.HTTPClient, method, url, http.Header{"Authorization": {"Bearer " + token}}, in, out)
+}
+
+// PublicKey satisfies Backend
+func (g *GCPKMS) PublicKey(ctx context.Context) (crypto.PublicKey, error) {
+	var resp struct {
+		Pem       string `json:"pem"`
+		Algorithm string `json:"algorithm"`
+	}
+	if err := g.request(ctx, "GET", "/publicKey", nil, &resp); err != nil {
+		return nil, err
+	}
+	g.algorithm = resp.Algorithm
+	return parsePublicKeyPEM(resp.Pem)
+}
+
+// gcpDigests are the names of the digests of asymmetricSign
+var gcpDigests = map[crypto.Hash]string{
+	crypto.SHA256: "sha256",
+	crypto.SHA384: "sha384",
+	crypto.SHA512: "sha512",
+}
+
+// Sign satisfies Backend
+func (g *GCPKMS) Sign(ctx context.Context, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	name, ok := gcpDigests[opts.HashFunc()]
+	if !ok {
+		return nil, ErrUnsupportedAlgorithm
+	}
+	// the key version can't switch between PSS and PKCS #1 v1.5
+	if strings.HasPrefix(g.algorithm, "RSA_SIGN_") && strings.HasPrefix(g.algorithm, "RSA_SIGN_PSS_") != isPSS(opts) {
+		return nil, fmt.Errorf("%w: key version algorithm is %s", ErrUnsupportedAlgorithm, g.algorithm)
+	}
+	if pss, ok := opts.(*rsa.PSSOptions); ok && pss.SaltLength != rsa.PSSSaltLengthEqualsHash {
+		return nil, ErrUnsupportedAlgorithm
+	}
+	req := map[string]interface{}{
+		"digest": map[string]string{name: base64.StdEncoding.EncodeToString(digest)},
+	}
+	var resp struct {
+		Signature string `json:"signature"`
+	}
+	if err := g.request(ctx, "POST", ":asymmetricSign", req, &resp); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Signature)
+}
@@ -0,0 +1,253 @@
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/RangelReale/osin"
+)
+
+func publicKeyPEM(t *testing.T, key crypto.Signer) string {
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// localSign signs like the key management services
+func localSign(key crypto.Signer, digest []byte, hash crypto.Hash, pss bool) ([]byte, error) {
+	var opts crypto.SignerOpts = hash
+	if pss {
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+	}
+	return key.Sign(rand.Reader, digest, opts)
+}
+
+// signAndVerify signs a JWT with the backend and verifies it with its
+// public key
+func signAndVerify(t *testing.T, backend Backend, alg string) error {
+	signer, err := NewSigner(context.Background(), backend)
+	if err != nil {
+		t.Fatalf("Error creating signer: %s", err)
+	}
+	token, err := osin.SignJWT(map[string]interface{}{"sub": "user"}, alg, "kid", signer)
+	if err != nil {
+		return err
+	}
+	jwt, err := osin.ParseJWT(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = jwt.Verify(signer.Public()); err != nil {
+		t.Fatalf("%s: signature not verified: %s", alg, err)
+	}
+	return nil
+}
+
+func newVaultServer(t *testing.T, key crypto.Signer) *httptest.Server {
+	hashes := map[string]crypto.Hash{"sha2-256": crypto.SHA256, "sha2-384": crypto.SHA384, "sha2-512": crypto.SHA512}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/v1/transit/keys/osin":
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+				"latest_version": 2,
+				"keys":           map[string]interface{}{"2": map[string]string{"public_key": publicKeyPEM(t, key)}},
+			}})
+		case r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/v1/transit/sign/osin/"):
+			var req struct {
+				Input              string `json:"input"`
+				Prehashed          bool   `json:"prehashed"`
+				SignatureAlgorithm string `json:"signature_algorithm"`
+				KeyVersion         int    `json:"key_version"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			digest, _ := base64.StdEncoding.DecodeString(req.Input)
+			hash := hashes[strings.TrimPrefix(r.URL.Path, "/v1/transit/sign/osin/")]
+			if !req.Prehashed || req.KeyVersion != 2 || hash == 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			sig, err := localSign(key, digest, hash, req.SignatureAlgorithm == "pss")
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{
+				"signature": "vault:v2:" + base64.StdEncoding.EncodeToString(sig),
+			}})
+		case r.Method == "GET" && r.URL.Path == "/v1/secret/data/osin/clients/1234":
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+				"data": map[string]string{"client_secret": "aabbccdd"},
+			}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestVaultTransit(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	tests := map[string]struct {
+		key crypto.Signer
+		alg string
+	}{
+		"ES256": {ecKey, "ES256"},
+		"RS256": {rsaKey, "RS256"},
+		"PS384": {rsaKey, "PS384"},
+	}
+	for name, test := range tests {
+		srv := newVaultServer(t, test.key)
+		backend := &VaultTransit{Address: srv.URL, Token: "token", Key: "osin"}
+		if err := signAndVerify(t, backend, test.alg); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		if backend.Version != 2 {
+			t.Errorf("%s: version not pinned: %d", name, backend.Version)
+		}
+		srv.Close()
+	}
+
+	srv := newVaultServer(t, ecKey)
+	defer srv.Close()
+	if _, err := NewSigner(context.Background(), &VaultTransit{Address: srv.URL, Token: "wrong", Key: "osin"}); err == nil {
+		t.Fatal("Expected error with a wrong token")
+	}
+}
+
+func TestGCPKMS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const name = "/v1/projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1"
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == "GET" && r.URL.Path == name+"/publicKey":
+			json.NewEncoder(w).Encode(map[string]string{"pem": publicKeyPEM(t, rsaKey), "algorithm": "RSA_SIGN_PSS_2048_SHA256"})
+		case r.Method == "POST" && r.URL.Path == name+":asymmetricSign":
+			var req struct {
+				Digest map[string]string `json:"digest"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			digest, _ := base64.StdEncoding.DecodeString(req.Digest["sha256"])
+			sig, _ := localSign(rsaKey, digest, crypto.SHA256, true)
+			json.NewEncoder(w).Encode(map[string]string{"signature": base64.StdEncoding.EncodeToString(sig)})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	backend := &GCPKMS{
+		Name:     "projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1",
+		Token:    func(ctx context.Context) (string, error) { return "token", nil },
+		Endpoint: srv.URL,
+	}
+	if err := signAndVerify(t, backend, "PS256"); err != nil {
+		t.Fatalf("Error signing: %s", err)
+	}
+	if err := signAndVerify(t, backend, "RS256"); err == nil {
+		t.Fatal("A PSS key version shouldn't sign PKCS #1 v1.5")
+	}
+}
+
+// fakeAWSClient signs with a local key, recording the algorithms
+type fakeAWSClient struct {
+	key        crypto.Signer
+	algorithms []string
+}
+
+func (c *fakeAWSClient) GetPublicKey(ctx context.Context, keyId string) ([]byte, error) {
+	return x509.MarshalPKIXPublicKey(c.key.Public())
+}
+
+func (c *fakeAWSClient) Sign(ctx context.Context, keyId string, digest []byte, algorithm string) ([]byte, error) {
+	c.algorithms = append(c.algorithms, algorithm)
+	hash := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}[algorithm[len(algorithm)-3:]]
+	return localSign(c.key, digest, hash, strings.HasPrefix(algorithm, "RSASSA_PSS_"))
+}
+
+func TestAWSKMS(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	tests := map[string]struct {
+		key       crypto.Signer
+		alg       string
+		algorithm string
+	}{
+		"ES384": {ecKey, "ES384", "ECDSA_SHA_384"},
+		"RS512": {rsaKey, "RS512", "RSASSA_PKCS1_V1_5_SHA_512"},
+		"PS256": {rsaKey, "PS256", "RSASSA_PSS_SHA_256"},
+	}
+	for name, test := range tests {
+		client := &fakeAWSClient{key: test.key}
+		if err := signAndVerify(t, &AWSKMS{Client: client, KeyId: "alias/osin"}, test.alg); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		if len(client.algorithms) != 1 || client.algorithms[0] != test.algorithm {
+			t.Errorf("%s: expected %s, got %v", name, test.algorithm, client.algorithms)
+		}
+	}
+}
+
+func TestVaultSecrets(t *testing.T) {
+	var requests int32
+	vault := newVaultServer(t, nil)
+	defer vault.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		vault.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	var resolveErrors int32
+	secrets := &VaultSecrets{Address: srv.URL, Token: "token", TTL: 60e9}
+	newClient := func(ref string) *SecretClient {
+		return &SecretClient{
+			Client:    &osin.DefaultClient{Id: "1234", RedirectUri: "http://localhost:14000/appauth"},
+			SecretRef: ref,
+			Resolver:  secrets,
+			OnError:   func(osin.Client, error) { atomic.AddInt32(&resolveErrors, 1) },
+		}
+	}
+
+	client := newClient("osin/clients/1234")
+	if !osin.CheckClientSecret(client, "aabbccdd") || osin.CheckClientSecret(client, "wrong") {
+		t.Fatal("Unexpected secret match")
+	}
+	if client.GetSecret() != "aabbccdd" {
+		t.Fatalf("Unexpected secret %q", client.GetSecret())
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("Secret not cached, %d requests", n)
+	}
+
+	if osin.CheckClientSecret(newClient("osin/clients/missing"), "") {
+		t.Fatal("A secret failing to resolve shouldn't match")
+	}
+	if atomic.LoadInt32(&resolveErrors) != 1 {
+		t.Fatal("Resolve error not reported")
+	}
+}
@@ -0,0 +1,63 @@
+package kms
+
+import (
+	"context"
+	"crypto/subtle"
+	"time"
+
+	"github.com/RangelReale/osin"
+)
+
+// SecretResolver returns a secret kept out of the osin storage by its
+// reference, like a Vault path
+type SecretResolver interface {
+	ResolveSecret(ctx context.Context, ref string) (string, error)
+}
+
+// SecretClient is a client whose secret is resolved by reference when it
+// authenticates. The storage saves the Client with an empty secret and the
+// SecretRef. Secrets failing to resolve match nothing, the error is passed
+// to OnError if not nil.
+type SecretClient struct {
+	osin.Client
+
+	// Reference of the secret, like "osin/clients/1234"
+	SecretRef string
+
+	Resolver SecretResolver
+
+	// Timeout of the resolution (default 5 seconds)
+	Timeout time.Duration
+
+	OnError func(client osin.Client, err error)
+}
+
+func (c *SecretClient) resolve() (string, bool) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	secret, err := c.Resolver.ResolveSecret(ctx, c.SecretRef)
+	if err != nil {
+		if c.OnError != nil {
+			c.OnError(c.Client, err)
+		}
+		return "", false
+	}
+	return secret, true
+}
+
+// GetSecret satisfies osin.Client, resolving the secret. Empty if it fails
+// to resolve.
+func (c *SecretClient) GetSecret() string {
+	secret, _ := c.resolve()
+	return secret
+}
+
+// ClientSecretMatches satisfies osin.ClientSecretMatcher
+func (c *SecretClient) ClientSecretMatches(secret string) bool {
+	resolved, ok := c.resolve()
+	return ok && subtle.ConstantTimeCompare([]byte(resolved), []byte(secret)) == 1
+}
@@ -0,0 +1,150 @@
+// Package kms keeps the signing keys and client secrets of an osin server
+// in external key management, so they never live in process memory.
+//
+// Signer is a crypto.Signer whose private key stays in AWS KMS, Google
+// Cloud KMS or a HashiCorp Vault transit engine, usable wherever osin
+// takes a signing key: osin.SigningKey, osin.SignJWT and the federation
+// entity configuration.
+//
+//	backend := &kms.VaultTransit{Address: "https://vault:8200", Token: token, Key: "osin"}
+//	signer, err := kms.NewSigner(ctx, backend)
+//	...
+//	alg, _ := osin.JWTAlgorithmForKey(signer.Public())
+//	keySet.AddKey(&osin.SigningKey{ID: "vault-osin-1", Algorithm: alg, Signer: signer})
+//
+// VaultSecrets resolves client secrets kept in a Vault KV engine, see
+// SecretClient.
+package kms
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrUnsupportedKey       = errors.New("kms: unsupported key type")
+	ErrUnsupportedAlgorithm = errors.New("kms: unsupported signature algorithm")
+)
+
+// Backend signs with a private key held by a key management service
+type Backend interface {
+	// PublicKey returns the public key of the signing key
+	PublicKey(ctx context.Context) (crypto.PublicKey, error)
+
+	// Sign signs a digest like crypto.Signer: PKCS #1 v1.5, or PSS if opts
+	// is a *rsa.PSSOptions, for RSA keys, and ASN.1 DER for ECDSA keys
+	Sign(ctx context.Context, digest []byte, opts crypto.SignerOpts) ([]byte, error)
+}
+
+// Signer is a crypto.Signer signing with a Backend. Its public key is
+// fetched once, by NewSigner.
+type Signer struct {
+	backend Backend
+	public  crypto.PublicKey
+
+	// Timeout of each signature (default 10 seconds)
+	Timeout time.Duration
+}
+
+// NewSigner creates a signer, fetching the public key of the backend
+func NewSigner(ctx context.Context, backend Backend) (*Signer, error) {
+	pub, err := backend.PublicKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch pub.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return nil, ErrUnsupportedKey
+	}
+	return &Signer{backend: backend, public: pub}, nil
+}
+
+// Public satisfies crypto.Signer
+func (s *Signer) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Sign satisfies crypto.Signer, signing the digest with the backend. The
+// rand reader is not used.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.SignContext(context.Background(), rand, digest, opts)
+}
+
+// SignContext satisfies osin.ContextSigner, like Sign with the backend
+// request canceled when ctx is done
+func (s *Signer) SignContext(ctx context.Context, rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts == nil || opts.HashFunc() == 0 {
+		return nil, ErrUnsupportedAlgorithm
+	}
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return s.backend.Sign(ctx, digest, opts)
+}
+
+// isPSS returns true if opts asks for an RSA-PSS signature
+func isPSS(opts crypto.SignerOpts) bool {
+	_, ok := opts.(*rsa.PSSOptions)
+	return ok
+}
+
+// parsePublicKeyPEM decodes a PEM PKIX public key
+func parsePublicKeyPEM(s string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, errors.New("kms: invalid public key PEM")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// doJSON sends a JSON request, if in is not nil, and decodes the JSON
+// response into out
+func doJSON(ctx context.Context, client *http.Client, method, url string, header http.Header, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kms: %s %s failed with status %d", method, url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,190 @@
+package kms
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VaultTransit is a Backend signing with a key of a HashiCorp Vault
+// transit secrets engine. The token needs the read capability on
+// <mount>/keys/<key> and update on <mount>/sign/<key>/*.
+type VaultTransit struct {
+	// Vault address, like "https://vault:8200"
+	Address string
+
+	// Vault token
+	Token string
+
+	// Mount path of the transit engine (default "transit")
+	Mount string
+
+	// Key name
+	Key string
+
+	// Key version signing. The latest one when PublicKey is called if 0,
+	// so a key rotated in Vault doesn't sign before being published.
+	Version int
+
+	// HTTP client - default http.DefaultClient
+	HTTPClient *http.Client
+}
+
+func (v *VaultTransit) url(path string) string {
+	mount := v.Mount
+	if mount == "" {
+		mount = "transit"
+	}
+	return strings.TrimSuffix(v.Address, "/") + "/v1/" + mount + "/" + path
+}
+
+func (v *VaultTransit) header() http.Header {
+	return http.Header{"X-Vault-Token": {v.Token}}
+}
+
+// PublicKey satisfies Backend, pinning Version to the latest version if 0
+func (v *VaultTransit) PublicKey(ctx context.Context) (crypto.PublicKey, error) {
+	var resp struct {
+		Data struct {
+			LatestVersion int `json:"latest_version"`
+			Keys          map[string]struct {
+				PublicKey string `json:"public_key"`
+			} `json:"keys"`
+		} `json:"data"`
+	}
+	if err := doJSON(ctx, v.HTTPClient, "GET", v.url("keys/"+v.Key), v.header(), nil, &resp); err != nil {
+		return nil, err
+	}
+	if v.Version == 0 {
+		v.Version = resp.Data.LatestVersion
+	}
+	key, ok := resp.Data.Keys[strconv.Itoa(v.Version)]
+	if !ok || key.PublicKey == "" {
+		return nil, fmt.Errorf("kms: no public key for version %d of vault key %s", v.Version, v.Key)
+	}
+	return parsePublicKeyPEM(key.PublicKey)
+}
+
+// vaultHashes are the transit hash algorithm names
+var vaultHashes = map[crypto.Hash]string{
+	crypto.SHA256: "sha2-256",
+	crypto.SHA384: "sha2-384",
+	crypto.SHA512: "sha2-512",
+}
+
+// Sign satisfies Backend
+func (v *VaultTransit) Sign(ctx context.Context, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hash, ok := vaultHashes[opts.HashFunc()]
+	if !ok {
+		return nil, ErrUnsupportedAlgorithm
+	}
+	req := map[string]interface{}{
+		"input":                base64.StdEncoding.EncodeToString(digest),
+		"prehashed":            true,
+		"marshaling_algorithm": "asn1",
+		"signature_algorithm":  "pkcs1v15",
+	}
+	if isPSS(opts) {
+		req["signature_algorithm"] = "pss"
+		req["salt_length"] = "hash"
+	}
+	if v.Version > 0 {
+		req["key_version"] = v.Version
+	}
+	var resp struct {
+		Data struct {
+			Signature string `json:"signature"`
+		} `json:"data"`
+	}
+	if err := doJSON(ctx, v.HTTPClient, "POST", v.url("sign/"+v.Key+"/"+hash), v.header(), req, &resp); err != nil {
+		return nil, err
+	}
+	// signatures are "vault:v<version>:<base64>"
+	parts := strings.SplitN(resp.Data.Signature, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" {
+		return nil, errors.New("kms: invalid vault signature")
+	}
+	return base64.StdEncoding.DecodeString(parts[2])
+}
+
+// VaultSecrets resolves secrets stored in a HashiCorp Vault KV version 2
+// engine, caching them for TTL. It is safe for concurrent use.
+type VaultSecrets struct {
+	// Vault address, like "https://vault:8200"
+	Address string
+
+	// Vault token
+	Token string
+
+	// Mount path of the KV engine (default "secret")
+	Mount string
+
+	// Field of the secret holding the value (default "client_secret")
+	Field string
+
+	// Time a resolved secret is cached, so rotated secrets are used after
+	// at most TTL. Not cached if 0.
+	TTL time.Duration
+
+	// HTTP client - default http.DefaultClient
+	HTTPClient *http.Client
+
+	mu    sync.Mutex
+	cache map[string]cachedSecret
+}
+
+type cachedSecret struct {
+	value     string
+	expiresAt time.Time
+}
+
+// ResolveSecret satisfies SecretResolver, reading the secret at path, like
+// "osin/clients/1234"
+func (v *VaultSecrets) ResolveSecret(ctx context.Context, path string) (string, error) {
+	if v.TTL > 0 {
+		v.mu.Lock()
+		c, ok := v.cache[path]
+		v.mu.Unlock()
+		if ok && time.Now().Before(c.expiresAt) {
+			return c.value, nil
+		}
+	}
+
+	mount, field := v.Mount, v.Field
+	if mount == "" {
+		mount = "secret"
+	}
+	if field == "" {
+		field = "client_secret"
+	}
+	var resp struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	url := strings.TrimSuffix(v.Address, "/") + "/v1/" + mount + "/data/" + strings.TrimPrefix(path, "/")
+	if err := doJSON(ctx, v.HTTPClient, "GET", url, http.Header{"X-Vault-Token": {v.Token}}, nil, &resp); err != nil {
+		return "", err
+	}
+	value, ok := resp.Data.Data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("kms: no %s field in vault secret %s", field, path)
+	}
+
+	if v.TTL > 0 {
+		v.mu.Lock()
+		if v.cache == nil {
+			v.cache = make(map[string]cachedSecret)
+		}
+		v.cache[path] = cachedSecret{value: value, expiresAt: time.Now().Add(v.TTL)}
+		v.mu.Unlock()
+	}
+	return value, nil
+}
@@ -0,0 +1,154 @@
+// Package lambdaadapter runs osin endpoints on AWS Lambda behind API
+// Gateway, translating the proxy events to the requests the server handlers
+// expect, and their responses back, without an HTTP server.
+package lambdaadapter
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/RangelReale/osin"
+)
+
+// Handler handles a request of an osin endpoint, filling the response,
+// like calling HandleAccessRequest and FinishAccessRequest
+type Handler func(w *osin.Response, r *http.Request)
+
+// NewRequestFromProxy creates the HTTP request of a REST API proxy event.
+// The source IP is the RemoteAddr.
+func NewRequestFromProxy(ctx context.Context, e *ProxyRequest) (*http.Request, error) {
+	query := make(url.Values)
+	for k, v := range e.QueryStringParameters {
+		query.Set(k, v)
+	}
+	for k, v := range e.MultiValueQueryStringParameters {
+		query[k] = append([]string(nil), v...)
+	}
+	header := make(http.Header)
+	for k, v := range e.Headers {
+		header.Set(k, v)
+	}
+	for k, v := range e.MultiValueHeaders {
+		header.Del(k)
+		for _, s := range v {
+			header.Add(k, s)
+		}
+	}
+	return newRequest(ctx, e.HTTPMethod, e.Path, query.Encode(), header, e.Body, e.IsBase64Encoded,
+		e.RequestContext.DomainName, e.RequestContext.Identity.SourceIP)
+}
+
+// NewRequestFromHTTPAPI creates the HTTP request of an HTTP API event. The
+// cookies are joined in the Cookie header, and the source IP is the
+// RemoteAddr.
+func NewRequestFromHTTPAPI(ctx context.Context, e *HTTPAPIRequest) (*http.Request, error) {
+	header := make(http.Header)
+	for k, v := range e.Headers {
+		header.Set(k, v)
+	}
+	if len(e.Cookies) > 0 {
+		header.Set("Cookie", strings.Join(e.Cookies, "; "))
+	}
+	path := e.RawPath
+	if path == "" {
+		path = e.RequestContext.HTTP.Path
+	}
+	return newRequest(ctx, e.RequestContext.HTTP.Method, path, e.RawQueryString, header, e.Body, e.IsBase64Encoded,
+		e.RequestContext.DomainName, e.RequestContext.HTTP.SourceIP)
+}
+
+func newRequest(ctx context.Context, method, path, query string, header http.Header, body string, base64Body bool, host, sourceIP string) (*http.Request, error) {
+	b := []byte(body)
+	if base64Body {
+		var err error
+		if b, err = base64.StdEncoding.DecodeString(body); err != nil {
+			return nil, err
+		}
+	}
+	if host == "" {
+		host = header.Get("Host")
+	}
+	u := &url.URL{Scheme: "https", Host: host, Path: path, RawQuery: query}
+	r, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	r.Header = header
+	r.Host = host
+	if sourceIP != "" {
+		r.RemoteAddr = net.JoinHostPort(sourceIP, "0")
+	}
+	return r, nil
+}
+
+// NewProxyResponse renders the response for a REST API proxy event
+func NewProxyResponse(rs *osin.Response) (*ProxyResponse, error) {
+	rr, err := rs.Render()
+	if err != nil {
+		return nil, err
+	}
+	ret := &ProxyResponse{
+		StatusCode:        rr.StatusCode,
+		Headers:           make(map[string]string),
+		MultiValueHeaders: make(map[string][]string),
+		Body:              string(rr.Body),
+	}
+	for k, v := range rr.Header {
+		if len(v) == 1 {
+			ret.Headers[k] = v[0]
+		} else if len(v) > 1 {
+			ret.MultiValueHeaders[k] = v
+		}
+	}
+	return ret, nil
+}
+
+// NewHTTPAPIResponse renders the response for an HTTP API event
+func NewHTTPAPIResponse(rs *osin.Response) (*HTTPAPIResponse, error) {
+	rr, err := rs.Render()
+	if err != nil {
+		return nil, err
+	}
+	ret := &HTTPAPIResponse{
+		StatusCode: rr.StatusCode,
+		Headers:    make(map[string]string),
+		Body:       string(rr.Body),
+	}
+	for k, v := range rr.Header {
+		if k == "Set-Cookie" {
+			ret.Cookies = append(ret.Cookies, v...)
+		} else if len(v) > 0 {
+			ret.Headers[k] = strings.Join(v, ",")
+		}
+	}
+	return ret, nil
+}
+
+// ServeProxy handles a REST API proxy event with the handler
+func ServeProxy(ctx context.Context, server *osin.Server, e *ProxyRequest, h Handler) (*ProxyResponse, error) {
+	r, err := NewRequestFromProxy(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	resp := server.NewResponse()
+	defer resp.Close()
+	h(resp, r)
+	return NewProxyResponse(resp)
+}
+
+// ServeHTTPAPI handles an HTTP API event with the handler
+func ServeHTTPAPI(ctx context.Context, server *osin.Server, e *HTTPAPIRequest, h Handler) (*HTTPAPIResponse, error) {
+	r, err := NewRequestFromHTTPAPI(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	resp := server.NewResponse()
+	defer resp.Close()
+	h(resp, r)
+	return NewHTTPAPIResponse(resp)
+}
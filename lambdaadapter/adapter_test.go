@@ -0,0 +1,115 @@
+package lambdaadapter
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/RangelReale/osin"
+	"github.com/RangelReale/osin/osintest"
+)
+
+func newServer() *osin.Server {
+	storage := osintest.NewStorage()
+	storage.SetClient("1234", &osin.DefaultClient{Id: "1234", Secret: "aabbccdd", RedirectUri: "http://localhost:14000/appauth"})
+	sconfig := osin.NewServerConfig()
+	sconfig.AllowedAccessTypes = osin.AllowedAccessType{osin.CLIENT_CREDENTIALS}
+	return osin.NewServer(sconfig, storage)
+}
+
+func tokenHandler(server *osin.Server) Handler {
+	return func(w *osin.Response, r *http.Request) {
+		if ar := server.HandleAccessRequest(w, r); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(w, r, ar)
+		}
+	}
+}
+
+func TestServeProxy(t *testing.T) {
+	server := newServer()
+	e := &ProxyRequest{
+		Path:       "/token",
+		HTTPMethod: "POST",
+		Headers: map[string]string{
+			"content-type":  "application/x-www-form-urlencoded",
+			"authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte("1234:aabbccdd")),
+		},
+		Body:            base64.StdEncoding.EncodeToString([]byte("grant_type=client_credentials")),
+		IsBase64Encoded: true,
+		RequestContext: ProxyRequestContext{
+			DomainName: "auth.example.com",
+			Identity:   ProxyIdentity{SourceIP: "10.0.0.1"},
+		},
+	}
+
+	resp, err := ServeProxy(context.Background(), server, e, func(w *osin.Response, r *http.Request) {
+		w.Headers.Add("Set-Cookie", "c=3")
+		w.Headers.Add("Set-Cookie", "d=4")
+		tokenHandler(server)(w, r)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("Unexpected status %d: %s", resp.StatusCode, resp.Body)
+	}
+	output := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(resp.Body), &output); err != nil {
+		t.Fatal(err)
+	}
+	if output["access_token"] == nil || output["token_type"] != "Bearer" {
+		t.Fatalf("Unexpected output: %v", output)
+	}
+	if resp.Headers["Content-Type"] != "application/json" {
+		t.Errorf("Unexpected headers: %v", resp.Headers)
+	}
+	if c := resp.MultiValueHeaders["Set-Cookie"]; len(c) != 2 || c[1] != "d=4" {
+		t.Errorf("Unexpected cookies: %v", c)
+	}
+}
+
+func TestServeHTTPAPI(t *testing.T) {
+	server := newServer()
+	e := &HTTPAPIRequest{
+		RawPath: "/token",
+		Headers: map[string]string{
+			"content-type": "application/x-www-form-urlencoded",
+		},
+		Cookies: []string{"a=1", "b=2"},
+		Body:    "grant_type=client_credentials&client_id=1234&client_secret=wrong",
+		RequestContext: HTTPAPIRequestContext{
+			DomainName: "auth.example.com",
+			HTTP:       HTTPDescription{Method: "POST", SourceIP: "10.0.0.1"},
+		},
+	}
+
+	var cookies []*http.Cookie
+	resp, err := ServeHTTPAPI(context.Background(), server, e, func(w *osin.Response, r *http.Request) {
+		cookies = r.Cookies()
+		if r.RemoteAddr != "10.0.0.1:0" || r.Host != "auth.example.com" {
+			t.Errorf("Unexpected request: %s %s", r.RemoteAddr, r.Host)
+		}
+		w.Headers.Add("Set-Cookie", "c=3")
+		w.Headers.Add("Set-Cookie", "d=4")
+		tokenHandler(server)(w, r)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cookies) != 2 || cookies[1].Value != "2" {
+		t.Errorf("Unexpected request cookies: %v", cookies)
+	}
+	if !strings.Contains(resp.Body, osin.E_INVALID_CLIENT) {
+		t.Errorf("Expected %s, got %s", osin.E_INVALID_CLIENT, resp.Body)
+	}
+	if len(resp.Cookies) != 2 || resp.Cookies[0] != "c=3" {
+		t.Errorf("Unexpected response cookies: %v", resp.Cookies)
+	}
+	if _, ok := resp.Headers["Set-Cookie"]; ok {
+		t.Error("Set-Cookie should only be in Cookies")
+	}
+}
@@ -0,0 +1,79 @@
+package lambdaadapter
+
+// ProxyRequest is an API Gateway REST API (payload version 1.0) proxy
+// event. The fields and their JSON names match those of
+// events.APIGatewayProxyRequest of github.com/aws/aws-lambda-go.
+type ProxyRequest struct {
+	Resource                        string              `json:"resource"`
+	Path                            string              `json:"path"`
+	HTTPMethod                      string              `json:"httpMethod"`
+	Headers                         map[string]string   `json:"headers"`
+	MultiValueHeaders               map[string][]string `json:"multiValueHeaders"`
+	QueryStringParameters           map[string]string   `json:"queryStringParameters"`
+	MultiValueQueryStringParameters map[string][]string `json:"multiValueQueryStringParameters"`
+	RequestContext                  ProxyRequestContext `json:"requestContext"`
+	Body                            string              `json:"body"`
+	IsBase64Encoded                 bool                `json:"isBase64Encoded,omitempty"`
+}
+
+// ProxyRequestContext is the request context of a ProxyRequest
+type ProxyRequestContext struct {
+	RequestID  string        `json:"requestId"`
+	DomainName string        `json:"domainName"`
+	Identity   ProxyIdentity `json:"identity"`
+}
+
+// ProxyIdentity is the caller identity of a ProxyRequest
+type ProxyIdentity struct {
+	SourceIP  string `json:"sourceIp"`
+	UserAgent string `json:"userAgent"`
+}
+
+// ProxyResponse is the response to a ProxyRequest. Headers with multiple
+// values, like Set-Cookie, are in MultiValueHeaders.
+type ProxyResponse struct {
+	StatusCode        int                 `json:"statusCode"`
+	Headers           map[string]string   `json:"headers"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders"`
+	Body              string              `json:"body"`
+	IsBase64Encoded   bool                `json:"isBase64Encoded,omitempty"`
+}
+
+// HTTPAPIRequest is an API Gateway HTTP API (payload version 2.0) event.
+// The fields and their JSON names match those of
+// events.APIGatewayV2HTTPRequest of github.com/aws/aws-lambda-go.
+type HTTPAPIRequest struct {
+	RawPath               string                `json:"rawPath"`
+	RawQueryString        string                `json:"rawQueryString"`
+	Cookies               []string              `json:"cookies,omitempty"`
+	Headers               map[string]string     `json:"headers"`
+	QueryStringParameters map[string]string     `json:"queryStringParameters,omitempty"`
+	RequestContext        HTTPAPIRequestContext `json:"requestContext"`
+	Body                  string                `json:"body,omitempty"`
+	IsBase64Encoded       bool                  `json:"isBase64Encoded"`
+}
+
+// HTTPAPIRequestContext is the request context of an HTTPAPIRequest
+type HTTPAPIRequestContext struct {
+	RequestID  string          `json:"requestId"`
+	DomainName string          `json:"domainName"`
+	HTTP       HTTPDescription `json:"http"`
+}
+
+// HTTPDescription describes the HTTP request of an HTTPAPIRequest
+type HTTPDescription struct {
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	SourceIP  string `json:"sourceIp"`
+	UserAgent string `json:"userAgent"`
+}
+
+// HTTPAPIResponse is the response to an HTTPAPIRequest. Set-Cookie headers
+// are in Cookies, other headers with multiple values are joined with commas.
+type HTTPAPIResponse struct {
+	StatusCode      int               `json:"statusCode"`
+	Headers         map[string]string `json:"headers"`
+	Cookies         []string          `json:"cookies,omitempty"`
+	Body            string            `json:"body"`
+	IsBase64Encoded bool              `json:"isBase64Encoded,omitempty"`
+}
@@ -0,0 +1,355 @@
+// Package ldapauth authenticates the users of an osin server against an
+// LDAP directory or Active Directory, by binding as the user. The
+// Authenticator is an osin.PasswordAuthenticator and an osin.UserStore,
+// mapping entry attributes to claims.
+//
+//	auth := ldapauth.New(ldapauth.ActiveDirectory("ldaps://dc.example.com", "DC=example,DC=com"))
+//	auth.BindDN, auth.BindPassword = "CN=osin,OU=Services,DC=example,DC=com", password
+//	defer auth.Close()
+//	server.UserStore = auth
+//
+// Searches run on a pool of connections bound as the BindDN account.
+package ldapauth
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/go-ldap/ldap/v3"
+)
+
+var ErrAmbiguousUser = errors.New("ldapauth: several entries match the user")
+
+// Config is the directory and the mapping of its entries to users
+type Config struct {
+	// Directory URL, like "ldaps://ldap.example.com" or
+	// "ldap://ldap.example.com:389"
+	URL string
+
+	// Upgrades ldap:// connections with StartTLS
+	StartTLS bool
+
+	// TLS configuration of ldaps:// and StartTLS - default system roots
+	TLSConfig *tls.Config
+
+	// Account searching the users, anonymous if empty
+	BindDN       string
+	BindPassword string
+
+	// Base of the user searches, like "ou=people,dc=example,dc=com"
+	BaseDN string
+
+	// Filter finding a user by username, %s being replaced by the escaped
+	// username (default "(uid=%s)")
+	UserFilter string
+
+	// Attribute of the username (default "uid")
+	UsernameAttribute string
+
+	// Attribute of the subject, which must never change for a user
+	// (default "entryUUID"). Binary attributes, like the objectGUID of
+	// Active Directory, are hex encoded if BinarySubject.
+	SubjectAttribute string
+	BinarySubject    bool
+
+	// Claims by attribute, like {"email": "mail"} (default email, name,
+	// given_name and family_name). Attributes with several values are
+	// mapped to a []string.
+	Claims map[string]string
+
+	// Idle connections kept (default 4)
+	PoolSize int
+
+	// Timeout of the connections and the operations (default 10 seconds)
+	Timeout time.Duration
+}
+
+// ActiveDirectory returns a configuration for an Active Directory domain,
+// finding the users by sAMAccountName or userPrincipalName, with their
+// objectGUID as subject
+func ActiveDirectory(url, baseDN string) Config {
+	return Config{
+		URL:               url,
+		BaseDN:            baseDN,
+		UserFilter:        "(&(objectCategory=person)(objectClass=user)(|(sAMAccountName=%[1]s)(userPrincipalName=%[1]s)))",
+		UsernameAttribute: "sAMAccountName",
+		SubjectAttribute:  "objectGUID",
+		BinarySubject:     true,
+		Claims: map[string]string{
+			"email":       "mail",
+			"name":        "displayName",
+			"given_name":  "givenName",
+			"family_name": "sn",
+			"groups":      "memberOf",
+		},
+	}
+}
+
+// conn is the part of *ldap.Conn used, to be replaced in tests
+type conn interface {
+	Bind(username, password string) error
+	Search(req *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+// Authenticator is an osin.PasswordAuthenticator and osin.UserStore over
+// a directory. It is safe for concurrent use.
+type Authenticator struct {
+	Config
+
+	// opens the connections - dialLDAP if nil
+	dial func() (conn, error)
+
+	mu   sync.Mutex
+	idle []conn
+}
+
+// New creates an authenticator. Connections are opened on demand.
+func New(config Config) *Authenticator {
+	return &Authenticator{Config: config}
+}
+
+func (a *Authenticator) timeout() time.Duration {
+	if a.Timeout <= 0 {
+		return 10 * time.Second
+	}
+	return a.Timeout
+}
+
+func (a *Authenticator) dialLDAP() (conn, error) {
+	c, err := ldap.DialURL(a.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: a.timeout()}),
+		ldap.DialWithTLSConfig(a.TLSConfig))
+	if err != nil {
+		return nil, err
+	}
+	c.SetTimeout(a.timeout())
+	if a.StartTLS {
+		if err = c.StartTLS(a.TLSConfig); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// get returns an idle connection bound as BindDN, or opens one
+func (a *Authenticator) get() (conn, error) {
+	a.mu.Lock()
+	if n := len(a.idle); n > 0 {
+		c := a.idle[n-1]
+		a.idle = a.idle[:n-1]
+		a.mu.Unlock()
+		return c, nil
+	}
+	a.mu.Unlock()
+
+	dial := a.dial
+	if dial == nil {
+		dial = a.dialLDAP
+	}
+	c, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	if a.BindDN != "" {
+		if err = c.Bind(a.BindDN, a.BindPassword); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// put returns a connection bound as BindDN to the pool, closing it if the
+// pool is full
+func (a *Authenticator) put(c conn) {
+	size := a.PoolSize
+	if size <= 0 {
+		size = 4
+	}
+	a.mu.Lock()
+	if len(a.idle) < size {
+		a.idle = append(a.idle, c)
+		c = nil
+	}
+	a.mu.Unlock()
+	if c != nil {
+		c.Close()
+	}
+}
+
+// Close closes the idle connections
+func (a *Authenticator) Close() {
+	a.mu.Lock()
+	idle := a.idle
+	a.idle = nil
+	a.mu.Unlock()
+	for _, c := range idle {
+		c.Close()
+	}
+}
+
+func (a *Authenticator) attributes() []string {
+	attrs := []string{a.subjectAttribute(), a.usernameAttribute()}
+	for _, attr := range a.claims() {
+		attrs = append(attrs, attr)
+	}
+	return attrs
+}
+
+func (a *Authenticator) subjectAttribute() string {
+	if a.SubjectAttribute == "" {
+		return "entryUUID"
+	}
+	return a.SubjectAttribute
+}
+
+func (a *Authenticator) usernameAttribute() string {
+	if a.UsernameAttribute == "" {
+		return "uid"
+	}
+	return a.UsernameAttribute
+}
+
+func (a *Authenticator) claims() map[string]string {
+	if a.Claims == nil {
+		return map[string]string{"email": "mail", "name": "cn", "given_name": "givenName", "family_name": "sn"}
+	}
+	return a.Claims
+}
+
+// search returns the single entry matching the filter
+func (a *Authenticator) search(c conn, filter string) (*ldap.Entry, error) {
+	res, err := c.Search(ldap.NewSearchRequest(a.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(a.timeout()/time.Second), false, filter, a.attributes(), nil))
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, osin.ErrNotFound
+		}
+		return nil, err
+	}
+	switch len(res.Entries) {
+	case 0:
+		return nil, osin.ErrNotFound
+	case 1:
+		return res.Entries[0], nil
+	default:
+		return nil, ErrAmbiguousUser
+	}
+}
+
+func (a *Authenticator) userFilter(username string) string {
+	filter := a.UserFilter
+	if filter == "" {
+		filter = "(uid=%s)"
+	}
+	return fmt.Sprintf(filter, ldap.EscapeFilter(username))
+}
+
+// user maps an entry to a user
+func (a *Authenticator) user(e *ldap.Entry) (*osin.User, error) {
+	u := &osin.User{
+		Username: e.GetAttributeValue(a.usernameAttribute()),
+		Claims:   make(map[string]interface{}),
+	}
+	if a.BinarySubject {
+		u.Subject = hex.EncodeToString(e.GetRawAttributeValue(a.subjectAttribute()))
+	} else {
+		u.Subject = e.GetAttributeValue(a.subjectAttribute())
+	}
+	if u.Subject == "" {
+		return nil, fmt.Errorf("ldapauth: entry %s has no %s", e.DN, a.subjectAttribute())
+	}
+	for claim, attr := range a.claims() {
+		switch values := e.GetAttributeValues(attr); len(values) {
+		case 0:
+		case 1:
+			u.Claims[claim] = values[0]
+		default:
+			u.Claims[claim] = values
+		}
+	}
+	return u, nil
+}
+
+// find returns the user of the entry matching the filter
+func (a *Authenticator) find(filter string) (*osin.User, error) {
+	c, err := a.get()
+	if err != nil {
+		return nil, err
+	}
+	e, err := a.search(c, filter)
+	if err != nil && !errors.Is(err, osin.ErrNotFound) && !errors.Is(err, ErrAmbiguousUser) {
+		c.Close()
+		return nil, err
+	}
+	a.put(c)
+	if err != nil {
+		return nil, err
+	}
+	return a.user(e)
+}
+
+// FindUserByUsername satisfies osin.UserStore
+func (a *Authenticator) FindUserByUsername(ctx context.Context, username string) (*osin.User, error) {
+	return a.find(a.userFilter(username))
+}
+
+// FindUserBySubject satisfies osin.UserStore
+func (a *Authenticator) FindUserBySubject(ctx context.Context, subject string) (*osin.User, error) {
+	value := subject
+	if a.BinarySubject {
+		raw, err := hex.DecodeString(subject)
+		if err != nil {
+			return nil, osin.ErrNotFound
+		}
+		value = string(raw)
+	}
+	return a.find("(" + a.subjectAttribute() + "=" + ldap.EscapeFilter(value) + ")")
+}
+
+// AuthenticatePassword satisfies osin.PasswordAuthenticator, finding the
+// user entry and binding as it with the password. Empty passwords are
+// rejected, as the directory would accept them as unauthenticated binds.
+func (a *Authenticator) AuthenticatePassword(ctx context.Context, username, password string) (*osin.User, error) {
+	if strings.TrimSpace(username) == "" || password == "" {
+		return nil, osin.ErrInvalidCredentials
+	}
+	c, err := a.get()
+	if err != nil {
+		return nil, err
+	}
+	e, err := a.search(c, a.userFilter(username))
+	if err != nil {
+		if errors.Is(err, osin.ErrNotFound) || errors.Is(err, ErrAmbiguousUser) {
+			a.put(c)
+		} else {
+			c.Close()
+		}
+		return nil, err
+	}
+
+	err = c.Bind(e.DN, password)
+	// the connection returns to the pool bound as BindDN again
+	if a.BindDN == "" || c.Bind(a.BindDN, a.BindPassword) != nil {
+		c.Close()
+	} else {
+		a.put(c)
+	}
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, osin.ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	return a.user(e)
+}
@@ -0,0 +1,169 @@
+package ldapauth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/RangelReale/osin"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// directory is a fake LDAP server, with entries by search filter
+type directory struct {
+	mu        sync.Mutex
+	entries   map[string][]*ldap.Entry
+	passwords map[string]string
+	dials     int
+	closed    int
+}
+
+// fakeConn is a connection to the directory, recording its bound DN
+type fakeConn struct {
+	dir   *directory
+	bound string
+}
+
+func (d *directory) dial() (conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dials++
+	return &fakeConn{dir: d}, nil
+}
+
+func (c *fakeConn) Bind(username, password string) error {
+	c.dir.mu.Lock()
+	defer c.dir.mu.Unlock()
+	if p, ok := c.dir.passwords[username]; !ok || p != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+	c.bound = username
+	return nil
+}
+
+func (c *fakeConn) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	c.dir.mu.Lock()
+	defer c.dir.mu.Unlock()
+	if c.bound != "cn=osin,dc=example,dc=com" {
+		return nil, ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("not bound as the service account"))
+	}
+	return &ldap.SearchResult{Entries: c.dir.entries[req.Filter]}, nil
+}
+
+func (c *fakeConn) Close() error {
+	c.dir.mu.Lock()
+	defer c.dir.mu.Unlock()
+	c.dir.closed++
+	return nil
+}
+
+func newDirectory() *directory {
+	alice := ldap.NewEntry("uid=alice,ou=people,dc=example,dc=com", map[string][]string{
+		"entryUUID": {"6f1c2e9a-1"},
+		"uid":       {"alice"},
+		"mail":      {"alice@example.com"},
+		"cn":        {"Alice"},
+		"memberOf":  {"cn=admins", "cn=users"},
+	})
+	return &directory{
+		entries: map[string][]*ldap.Entry{
+			"(uid=alice)":            {alice},
+			"(entryUUID=6f1c2e9a-1)": {alice},
+			"(uid=dup)":              {alice, alice},
+		},
+		passwords: map[string]string{
+			"cn=osin,dc=example,dc=com":             "service",
+			"uid=alice,ou=people,dc=example,dc=com": "secret",
+		},
+	}
+}
+
+func newTestAuthenticator(dir *directory) *Authenticator {
+	a := New(Config{
+		BindDN:       "cn=osin,dc=example,dc=com",
+		BindPassword: "service",
+		BaseDN:       "dc=example,dc=com",
+		Claims:       map[string]string{"email": "mail", "name": "cn", "groups": "memberOf"},
+		PoolSize:     1,
+	})
+	a.dial = dir.dial
+	return a
+}
+
+func TestAuthenticatePassword(t *testing.T) {
+	dir := newDirectory()
+	auth := newTestAuthenticator(dir)
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		username string
+		password string
+		err      error
+	}{
+		"valid":          {"alice", "secret", nil},
+		"wrong password": {"alice", "wrong", osin.ErrInvalidCredentials},
+		"empty password": {"alice", "", osin.ErrInvalidCredentials},
+		"unknown user":   {"bob", "secret", osin.ErrNotFound},
+		"ambiguous user": {"dup", "secret", ErrAmbiguousUser},
+		"filter escaped": {"alice)(uid=*", "secret", osin.ErrNotFound},
+	}
+	for name, test := range tests {
+		user, err := auth.AuthenticatePassword(ctx, test.username, test.password)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", name, test.err, err)
+		}
+		if err != nil {
+			continue
+		}
+		if user.Subject != "6f1c2e9a-1" || user.Username != "alice" || user.Claims["email"] != "alice@example.com" {
+			t.Errorf("%s: unexpected user %+v", name, user)
+		}
+		if groups, ok := user.Claims["groups"].([]string); !ok || len(groups) != 2 {
+			t.Errorf("%s: unexpected groups %v", name, user.Claims["groups"])
+		}
+	}
+
+	// the pooled connection was rebound as the service account
+	if dir.dials != 1 {
+		t.Fatalf("Expected a single pooled connection, got %d dials", dir.dials)
+	}
+	if _, err := auth.FindUserBySubject(ctx, "6f1c2e9a-1"); err != nil {
+		t.Fatalf("Search after a user bind failed: %s", err)
+	}
+	auth.Close()
+	if dir.closed != 1 {
+		t.Fatalf("Idle connection not closed")
+	}
+}
+
+func TestActiveDirectorySubject(t *testing.T) {
+	dir := newDirectory()
+	guid := string([]byte{0x01, 0x2a, 0xff, 0x00})
+	bob := ldap.NewEntry("CN=Bob,OU=Users,DC=example,DC=com", map[string][]string{
+		"objectGUID":     {guid},
+		"sAMAccountName": {"bob"},
+		"displayName":    {"Bob"},
+	})
+	config := ActiveDirectory("ldaps://dc.example.com", "DC=example,DC=com")
+	dir.entries["(objectGUID="+ldap.EscapeFilter(guid)+")"] = []*ldap.Entry{bob}
+	dir.entries[(&Authenticator{Config: config}).userFilter("bob")] = []*ldap.Entry{bob}
+
+	config.BindDN, config.BindPassword = "cn=osin,dc=example,dc=com", "service"
+	auth := New(config)
+	auth.dial = dir.dial
+
+	user, err := auth.FindUserByUsername(context.Background(), "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Subject != "012aff00" || user.Claims["name"] != "Bob" {
+		t.Fatalf("Unexpected user %+v", user)
+	}
+	if user, err = auth.FindUserBySubject(context.Background(), user.Subject); err != nil || user.Username != "bob" {
+		t.Fatalf("Lookup by subject failed: %v %+v", err, user)
+	}
+	if _, err = auth.FindUserBySubject(context.Background(), "not hex"); err != osin.ErrNotFound {
+		t.Fatalf("Expected not found, got %v", err)
+	}
+}
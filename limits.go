@@ -0,0 +1,94 @@
+package osin
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ErrRequestTooLarge is returned when the request body exceeds
+// ServerConfig.MaxRequestBodySize
+var ErrRequestTooLarge = errors.New("request body too large")
+
+// limitedBody is a request body failing with ErrRequestTooLarge after max bytes
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// allow reading EOF exactly at the limit
+		var one [1]byte
+		if n, _ := b.ReadCloser.Read(one[:]); n > 0 {
+			return 0, ErrRequestTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// parseForm parses the request form, limiting the body to MaxRequestBodySize
+func (s *Server) parseForm(w *Response, r *http.Request) error {
+	if max := s.requestConfig(w).MaxRequestBodySize; max > 0 && r.Body != nil && r.Form == nil {
+		r.Body = &limitedBody{ReadCloser: r.Body, remaining: max}
+	}
+	return r.ParseForm()
+}
+
+// QueryCredentialParameters are the parameters refused in the URL query
+// when Config.ForbidQueryCredentials is set
+var QueryCredentialParameters = []string{
+	"client_secret",
+	"client_assertion",
+	"password",
+	"code",
+	"code_verifier",
+	"refresh_token",
+	"device_code",
+	"assertion",
+	"token",
+	"access_token",
+}
+
+// checkQueryCredentials verifies no credentials were sent in the URL query
+// when ForbidQueryCredentials is set. Sets an invalid_request error on the
+// response and returns false otherwise.
+func (s *Server) checkQueryCredentials(w *Response, r *http.Request) bool {
+	if !s.requestConfig(w).ForbidQueryCredentials || r.URL == nil || r.URL.RawQuery == "" {
+		return true
+	}
+	query := r.URL.Query()
+	for _, name := range QueryCredentialParameters {
+		if _, ok := query[name]; ok {
+			w.SetError(E_INVALID_REQUEST, fmt.Sprintf("%s must not be sent in the query string", name))
+			return false
+		}
+	}
+	return true
+}
+
+// checkParameterLengths verifies the form parameters against
+// MaxParameterLengths. Sets an invalid_request error on the response
+// and returns false if any is too long.
+func (s *Server) checkParameterLengths(w *Response, r *http.Request) bool {
+	for name, max := range s.requestConfig(w).MaxParameterLengths {
+		if max <= 0 {
+			continue
+		}
+		for _, v := range r.Form[name] {
+			if len(v) > max {
+				w.SetError(E_INVALID_REQUEST, fmt.Sprintf("%s is too long", name))
+				w.InternalError = fmt.Errorf("%s has %d bytes, over the limit of %d", name, len(v), max)
+				return false
+			}
+		}
+	}
+	return true
+}
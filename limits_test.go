@@ -0,0 +1,160 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAccessRequestBodyLimit(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+	sconfig.MaxRequestBodySize = 100
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+
+	testcases := map[string]struct {
+		Body    string
+		IsError bool
+	}{
+		"within limit": {
+			Body: "grant_type=client_credentials",
+		},
+		"over limit": {
+			Body:    "grant_type=client_credentials&padding=" + strings.Repeat("a", 100),
+			IsError: true,
+		},
+	}
+
+	for k, tc := range testcases {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", strings.NewReader(tc.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("1234", "aabbccdd")
+
+		server.HandleAccessRequest(resp, req)
+		if resp.IsError != tc.IsError {
+			t.Errorf("%s: expected error %v, got %v", k, tc.IsError, resp.Output)
+		}
+		if tc.IsError && resp.InternalError != ErrRequestTooLarge {
+			t.Errorf("%s: unexpected internal error %v", k, resp.InternalError)
+		}
+	}
+}
+
+func TestParameterLengthLimits(t *testing.T) {
+	sconfig := NewServerConfig()
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+
+	testcases := map[string]struct {
+		Scope   string
+		IsError bool
+	}{
+		"short scope": {
+			Scope: "everything",
+		},
+		"long scope": {
+			Scope:   strings.Repeat("a", 5000),
+			IsError: true,
+		},
+	}
+
+	for k, tc := range testcases {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Form = make(url.Values)
+		req.Form.Set("response_type", string(CODE))
+		req.Form.Set("client_id", "1234")
+		req.Form.Set("scope", tc.Scope)
+
+		if ar := server.HandleAuthorizeRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAuthorizeRequest(resp, req, ar)
+		}
+		if resp.IsError != tc.IsError {
+			t.Errorf("%s: expected error %v, got %v", k, tc.IsError, resp.Output)
+		}
+		if tc.IsError && resp.ErrorId != E_INVALID_REQUEST {
+			t.Errorf("%s: unexpected error %s", k, resp.ErrorId)
+		}
+	}
+}
+
+func TestForbidQueryCredentials(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{REFRESH_TOKEN}
+	sconfig.ForbidQueryCredentials = true
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+
+	testcases := map[string]struct {
+		Query   string
+		Body    string
+		IsError bool
+	}{
+		"body": {
+			Body: "grant_type=refresh_token&refresh_token=r9999",
+		},
+		"query token": {
+			Query:   "?refresh_token=r9999",
+			Body:    "grant_type=refresh_token",
+			IsError: true,
+		},
+		"query secret": {
+			Query:   "?client_secret=aabbccdd",
+			Body:    "grant_type=refresh_token&refresh_token=r9999",
+			IsError: true,
+		},
+		"query other": {
+			Query: "?grant_type=refresh_token",
+			Body:  "refresh_token=r9999",
+		},
+	}
+
+	for k, tc := range testcases {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth"+tc.Query, strings.NewReader(tc.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("1234", "aabbccdd")
+
+		server.HandleAccessRequest(resp, req)
+		if resp.IsError != tc.IsError {
+			t.Errorf("%s: expected error %v, got %v", k, tc.IsError, resp.Output)
+		}
+		if tc.IsError && resp.ErrorId != E_INVALID_REQUEST {
+			t.Errorf("%s: expected invalid_request, got %s", k, resp.ErrorId)
+		}
+	}
+}
+
+func TestDeviceVerificationBodyLimit(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.MaxRequestBodySize = 100
+	server := NewServer(sconfig, NewTestingStorage())
+
+	body := "user_code=BBBB-BBBB&padding=" + strings.Repeat("a", 100)
+	req, err := http.NewRequest("POST", "http://localhost:14000/device", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp := server.NewResponse()
+	if vr := server.HandleDeviceVerificationRequest(resp, req); vr != nil {
+		t.Fatalf("Verification should fail")
+	}
+	if resp.ErrorId != E_INVALID_REQUEST || resp.InternalError != ErrRequestTooLarge {
+		t.Fatalf("Expected invalid_request, got %v %v", resp.Output, resp.InternalError)
+	}
+}
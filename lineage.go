@@ -0,0 +1,185 @@
+package osin
+
+import (
+	"strings"
+	"time"
+)
+
+// Refresh lineage
+//
+// Each refresh links the previous grant through AccessData.AccessData, so
+// without a limit the chain grows with every refresh, and storages
+// serializing the whole chain store ever larger entries. Config.MaxLineageDepth
+// caps the chain and Config.FlattenLineage removes it, while
+// AccessData.FamilyID and AccessData.OriginalCode keep the references needed
+// to revoke a family and to trace it to its authorization code.
+//
+// Migrating an existing storage:
+//   - persist FamilyID and OriginalCode with the access data. Grants saved
+//     before have none; they get a new FamilyID on their next refresh.
+//   - trim the chains already stored with TrimAccessLineage when loading
+//     them, or in a one-off job rewriting every entry, as the server only
+//     trims the grants it creates.
+//   - code walking AccessData.AccessData must stop at nil, and use
+//     FamilyID instead to find the grants of the same family.
+
+// TrimAccessLineage returns a copy of data linking at most depth previous
+// grants. The stored entries are not modified. A depth of 0 unlinks all.
+func TrimAccessLineage(data *AccessData, depth int) *AccessData {
+	if data == nil {
+		return nil
+	}
+	ret := *data
+	if depth <= 0 {
+		ret.AccessData = nil
+	} else {
+		ret.AccessData = TrimAccessLineage(data.AccessData, depth-1)
+	}
+	return &ret
+}
+
+// accessLineage returns the previous grant to link to a refreshed one, per
+// the lineage configuration
+func (s *Server) accessLineage(w *Response, previous *AccessData) *AccessData {
+	if previous == nil {
+		return nil
+	}
+	config := s.requestConfig(w)
+	if config.FlattenLineage {
+		return nil
+	}
+	if config.MaxLineageDepth > 0 {
+		return TrimAccessLineage(previous, config.MaxLineageDepth-1)
+	}
+	return previous
+}
+
+// setAccessFamily sets the family of a new grant, inherited from the
+// previous one, or new for original grants
+func setAccessFamily(data *AccessData, previous *AccessData) error {
+	if previous != nil && previous.FamilyID != "" {
+		data.FamilyID = previous.FamilyID
+		data.OriginalCode = previous.OriginalCode
+		return nil
+	}
+
+	var err error
+	if data.FamilyID, err = (TokenFormat{Length: 16}).Generate(); err != nil {
+		return err
+	}
+	if data.AuthorizeData != nil {
+		data.OriginalCode = data.AuthorizeData.Code
+	} else if previous != nil && previous.AuthorizeData != nil {
+		// grant saved before families were introduced
+		data.OriginalCode = previous.AuthorizeData.Code
+	}
+	return nil
+}
+
+// TokenChain is a report of the lineage of a token, for support tooling
+// and debugging refresh anomalies. Tokens are masked.
+type TokenChain struct {
+	// Grant family and authorization code the chain started from, if known
+	FamilyID     string `json:"family_id,omitempty"`
+	OriginalCode string `json:"original_code,omitempty"`
+
+	// True if the described token is a refresh token
+	IsRefresh bool `json:"is_refresh"`
+
+	// Grants of the chain, from the one of the token to the oldest linked
+	Grants []TokenChainGrant `json:"grants"`
+
+	// Authorization the chain started from, if still linked
+	Authorization *TokenChainAuthorization `json:"authorization,omitempty"`
+}
+
+// TokenChainGrant is a grant of a TokenChain
+type TokenChainGrant struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	ClientID     string    `json:"client_id,omitempty"`
+	Subject      string    `json:"sub,omitempty"`
+	Scope        string    `json:"scope,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	Expired      bool      `json:"expired"`
+}
+
+// TokenChainAuthorization is the authorization a TokenChain started from
+type TokenChainAuthorization struct {
+	Code        string    `json:"code"`
+	ClientID    string    `json:"client_id,omitempty"`
+	Scope       string    `json:"scope,omitempty"`
+	RedirectUri string    `json:"redirect_uri,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// maskToken keeps the last characters of a token, enough to tell tokens
+// apart in a report without disclosing them
+func maskToken(token string) string {
+	if len(token) <= 8 {
+		return strings.Repeat("*", len(token))
+	}
+	return "..." + token[len(token)-4:]
+}
+
+// DescribeTokenChain reports the lineage of an access or refresh token,
+// walking the previous grants linked by AccessData.AccessData back to the
+// authorization. Returns ErrNotFound for unknown tokens.
+func (s *Server) DescribeTokenChain(token string) (*TokenChain, error) {
+	storage := s.Storage.Clone()
+	defer storage.Close()
+
+	ret := &TokenChain{}
+	data, err := storage.LoadAccess(token)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	if data == nil {
+		if data, err = storage.LoadRefresh(token); err != nil {
+			return nil, err
+		}
+		if data == nil {
+			return nil, ErrNotFound
+		}
+		ret.IsRefresh = true
+	}
+	ret.FamilyID = data.FamilyID
+	ret.OriginalCode = maskToken(data.OriginalCode)
+
+	now := s.Now()
+	var authorization *AuthorizeData
+	seen := make(map[*AccessData]bool)
+	for d := data; d != nil && !seen[d]; d = d.AccessData {
+		seen[d] = true
+		grant := TokenChainGrant{
+			AccessToken:  maskToken(d.AccessToken),
+			RefreshToken: maskToken(d.RefreshToken),
+			Subject:      d.Subject,
+			Scope:        d.Scope,
+			CreatedAt:    d.CreatedAt,
+			ExpiresAt:    d.ExpireAt(),
+			Expired:      d.IsExpiredAt(now),
+		}
+		if d.Client != nil {
+			grant.ClientID = d.Client.GetID()
+		}
+		ret.Grants = append(ret.Grants, grant)
+		if d.AuthorizeData != nil {
+			authorization = d.AuthorizeData
+		}
+	}
+
+	if authorization != nil {
+		ret.Authorization = &TokenChainAuthorization{
+			Code:        maskToken(authorization.Code),
+			Scope:       authorization.Scope,
+			RedirectUri: authorization.RedirectUri,
+			CreatedAt:   authorization.CreatedAt,
+		}
+		if authorization.Client != nil {
+			ret.Authorization.ClientID = authorization.Client.GetID()
+		}
+	}
+	return ret, nil
+}
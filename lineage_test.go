@@ -0,0 +1,126 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func refreshForTest(t *testing.T, server *Server, refreshToken string) string {
+	resp := server.NewResponse()
+	req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("1234", "aabbccdd")
+	req.Form = url.Values{
+		"grant_type":    {string(REFRESH_TOKEN)},
+		"refresh_token": {refreshToken},
+	}
+	req.PostForm = req.Form
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		ar.Authorized = true
+		server.FinishAccessRequest(resp, req, ar)
+	}
+	if resp.IsError {
+		t.Fatalf("Refresh failed: %v", resp.Output)
+	}
+	return resp.Output["refresh_token"].(string)
+}
+
+func lineageDepth(d *AccessData) int {
+	n := 0
+	for d = d.AccessData; d != nil; d = d.AccessData {
+		n++
+	}
+	return n
+}
+
+func TestRefreshLineage(t *testing.T) {
+	tests := map[string]struct {
+		MaxDepth int
+		Flatten  bool
+		Expected int
+	}{
+		"unlimited": {Expected: 3},
+		"capped":    {MaxDepth: 2, Expected: 2},
+		"flattened": {MaxDepth: 2, Flatten: true, Expected: 0},
+	}
+
+	for k, test := range tests {
+		sconfig := NewServerConfig()
+		sconfig.AllowedAccessTypes = AllowedAccessType{REFRESH_TOKEN}
+		sconfig.MaxLineageDepth = test.MaxDepth
+		sconfig.FlattenLineage = test.Flatten
+		sconfig.RetainTokenAfterRefresh = true
+		storage := NewTestingStorage()
+		server := NewServer(sconfig, storage)
+		server.AccessTokenGen = &TestingAccessTokenGen{}
+
+		token := "r9999"
+		for i := 0; i < 3; i++ {
+			token = refreshForTest(t, server, token)
+		}
+		data, err := storage.LoadRefresh(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := lineageDepth(data); n != test.Expected {
+			t.Errorf("%s: expected lineage depth %d, got %d", k, test.Expected, n)
+		}
+		if first := storage.access["1"]; data.FamilyID == "" || data.FamilyID != first.FamilyID || data.OriginalCode != "9999" {
+			t.Errorf("%s: family should be kept: %s %s %s", k, data.FamilyID, first.FamilyID, data.OriginalCode)
+		}
+
+		// stored entries are not trimmed
+		if storage.access["2"].AccessData == nil && !test.Flatten {
+			t.Errorf("%s: previous grants should keep their lineage", k)
+		}
+	}
+}
+
+func TestRefreshLineageRemovesPrevious(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{REFRESH_TOKEN}
+	sconfig.FlattenLineage = true
+	storage := NewTestingStorage()
+	server := NewServer(sconfig, storage)
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+
+	refreshForTest(t, server, "r9999")
+	if _, ok := storage.access["9999"]; ok {
+		t.Fatal("Previous access token should be removed with a flattened lineage")
+	}
+}
+
+func TestDescribeTokenChain(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{REFRESH_TOKEN}
+	sconfig.RetainTokenAfterRefresh = true
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+
+	token := refreshForTest(t, server, "r9999")
+	token = refreshForTest(t, server, token)
+
+	chain, err := server.DescribeTokenChain(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !chain.IsRefresh || chain.FamilyID == "" {
+		t.Fatalf("Unexpected chain %+v", chain)
+	}
+	if len(chain.Grants) != 3 {
+		t.Fatalf("Expected 3 grants, got %d", len(chain.Grants))
+	}
+	if chain.Grants[0].ClientID != "1234" || chain.Grants[0].RefreshToken != "**" {
+		t.Fatalf("Unexpected grant %+v", chain.Grants[0])
+	}
+	if chain.Authorization == nil || chain.Authorization.Code != "****" {
+		t.Fatalf("Unexpected authorization %+v", chain.Authorization)
+	}
+
+	if _, err = server.DescribeTokenChain("unknown"); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+}
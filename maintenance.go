@@ -0,0 +1,53 @@
+package osin
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maintenanceWindow holds the server maintenance mode state
+type maintenanceWindow struct {
+	mu    sync.RWMutex
+	until time.Time
+}
+
+// StartMaintenance puts the server in maintenance mode until the given time.
+// While in maintenance, authorize and token requests are answered with
+// temporarily_unavailable and a Retry-After header. Info requests on existing
+// tokens are still served.
+func (s *Server) StartMaintenance(until time.Time) {
+	s.maintenance.mu.Lock()
+	defer s.maintenance.mu.Unlock()
+	s.maintenance.until = until
+}
+
+// StopMaintenance ends the maintenance mode before its planned end
+func (s *Server) StopMaintenance() {
+	s.StartMaintenance(time.Time{})
+}
+
+// InMaintenance returns true and the planned end if the server is in maintenance mode
+func (s *Server) InMaintenance() (bool, time.Time) {
+	s.maintenance.mu.RLock()
+	defer s.maintenance.mu.RUnlock()
+	if s.maintenance.until.IsZero() || !s.Now().Before(s.maintenance.until) {
+		return false, time.Time{}
+	}
+	return true, s.maintenance.until
+}
+
+// checkMaintenance sets the temporarily_unavailable error on the response if
+// the server is in maintenance mode, and returns false
+func (s *Server) checkMaintenance(w *Response) bool {
+	in, until := s.InMaintenance()
+	if !in {
+		return true
+	}
+	w.SetError(E_TEMPORARILY_UNAVAILABLE, "")
+	w.InternalError = errors.New("server in maintenance mode")
+	w.Headers.Set("Retry-After", strconv.Itoa(int(math.Ceil(until.Sub(s.Now()).Seconds()))))
+	return false
+}
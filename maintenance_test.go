@@ -0,0 +1,77 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	now := time.Now()
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{PASSWORD}
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+	server.Now = func() time.Time { return now }
+	server.StartMaintenance(now.Add(90 * time.Second))
+
+	// token requests are refused
+	resp := server.NewResponse()
+	req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("1234", "aabbccdd")
+	req.Form = make(url.Values)
+	req.Form.Set("grant_type", string(PASSWORD))
+	req.Form.Set("username", "testing")
+	req.Form.Set("password", "testing")
+	req.PostForm = make(url.Values)
+
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		t.Fatalf("Access request should be refused in maintenance")
+	}
+	if resp.ErrorId != E_TEMPORARILY_UNAVAILABLE {
+		t.Fatalf("Expected temporarily_unavailable, got %v", resp.Output)
+	}
+	if h := resp.Headers.Get("Retry-After"); h != "90" {
+		t.Fatalf("Unexpected Retry-After: %s", h)
+	}
+
+	// authorize requests are refused
+	resp = server.NewResponse()
+	req, err = http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Form = make(url.Values)
+	req.Form.Set("response_type", string(CODE))
+	req.Form.Set("client_id", "1234")
+	if ar := server.HandleAuthorizeRequest(resp, req); ar != nil || resp.ErrorId != E_TEMPORARILY_UNAVAILABLE {
+		t.Fatalf("Authorize request should be refused in maintenance")
+	}
+
+	// info requests are served
+	resp = server.NewResponse()
+	req, err = http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer 9999")
+	if ir := server.HandleInfoRequest(resp, req); ir == nil {
+		t.Fatalf("Info request should be served in maintenance: %v", resp.Output)
+	}
+
+	// maintenance ends by itself
+	now = now.Add(2 * time.Minute)
+	if in, _ := server.InMaintenance(); in {
+		t.Fatalf("Maintenance should have ended")
+	}
+
+	server.StartMaintenance(now.Add(time.Hour))
+	server.StopMaintenance()
+	if in, _ := server.InMaintenance(); in {
+		t.Fatalf("Maintenance should be stopped")
+	}
+}
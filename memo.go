@@ -0,0 +1,88 @@
+package osin
+
+import (
+	"sync"
+	"time"
+)
+
+// memoCache is an in-memory map of short-lived values, swept lazily
+type memoCache struct {
+	mu        sync.Mutex
+	entries   map[string]memoEntry
+	nextSweep time.Time
+}
+
+type memoEntry struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+// put saves the value until expiresAt, removing the expired ones
+func (c *memoCache) put(key string, value interface{}, now, expiresAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.putLocked(key, value, now, expiresAt)
+}
+
+// putMax is put keeping at most max entries, dropping the ones expiring
+// first when full
+func (c *memoCache) putMax(key string, value interface{}, now, expiresAt time.Time, max int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= max {
+		c.sweep(now)
+		for len(c.entries) >= max {
+			var first string
+			for k, e := range c.entries {
+				if first == "" || e.expiresAt.Before(c.entries[first].expiresAt) {
+					first = k
+				}
+			}
+			delete(c.entries, first)
+		}
+	}
+	c.putLocked(key, value, now, expiresAt)
+}
+
+func (c *memoCache) putLocked(key string, value interface{}, now, expiresAt time.Time) {
+	if c.entries == nil {
+		c.entries = make(map[string]memoEntry)
+	}
+	if now.After(c.nextSweep) {
+		c.sweep(now)
+		c.nextSweep = expiresAt
+	}
+	c.entries[key] = memoEntry{value: value, expiresAt: expiresAt}
+}
+
+// sweep removes the expired entries
+func (c *memoCache) sweep(now time.Time) {
+	for k, e := range c.entries {
+		if now.After(e.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// add saves the value like put, only if the key has no value yet. Returns
+// false if it has.
+func (c *memoCache) add(key string, value interface{}, now, expiresAt time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok && !now.After(e.expiresAt) {
+		return false
+	}
+	c.putLocked(key, value, now, expiresAt)
+	return true
+}
+
+// get returns the value if not expired
+func (c *memoCache) get(key string, now time.Time) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || now.After(e.expiresAt) {
+		return nil, false
+	}
+	return e.value, true
+}
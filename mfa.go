@@ -0,0 +1,223 @@
+package osin
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+const (
+	// Grant exchanging an mfa_token and a one-time password for the tokens
+	MFA_OTP AccessRequestType = "mfa_otp"
+)
+
+// MFAStorage is an optional interface storages implement to support the
+// multi-factor authentication challenges
+type MFAStorage interface {
+	// SaveMFAChallenge saves a new or updated challenge
+	SaveMFAChallenge(data *MFAChallenge) error
+
+	// LoadMFAChallenge looks up a challenge by token.
+	// Client information MUST be loaded together.
+	LoadMFAChallenge(token string) (*MFAChallenge, error)
+
+	// RemoveMFAChallenge deletes a challenge
+	RemoveMFAChallenge(token string) error
+
+	// ConsumeMFAChallenge deletes a challenge and returns it, atomically,
+	// so a challenge is completed once. Returns ErrNotFound if not found.
+	ConsumeMFAChallenge(token string) (*MFAChallenge, error)
+
+	// IncrementMFAAttempts increments the Attempts of a challenge and
+	// returns the new value, atomically, so concurrent attempts are all
+	// counted. Returns ErrNotFound if not found.
+	IncrementMFAAttempts(token string) (int, error)
+}
+
+// MFAChallenge is an access request suspended until the user completes a
+// second authentication factor
+type MFAChallenge struct {
+	// Challenge token returned to the client as mfa_token
+	Token string
+
+	// Client information
+	Client Client
+
+	// Grant type of the suspended request
+	GrantType AccessRequestType
+
+	// Data of the suspended request
+	Scope                 string
+	Username              string
+	Subject               string
+	AuthorizationDetails  AuthorizationDetails
+	AuthenticationContext AuthenticationContext
+	GenerateRefresh       bool
+	Expiration            int32
+	RefreshExpiration     int32
+
+	// Challenge expiration in seconds
+	ExpiresIn int32
+
+	// Date created
+	CreatedAt time.Time
+
+	// Number of one-time passwords tried, see IncrementMFAAttempts
+	Attempts int
+
+	// Data to be passed to storage. Not used by the library.
+	UserData interface{}
+}
+
+// IsExpiredAt is true if the challenge expires at time 't'
+func (c *MFAChallenge) IsExpiredAt(t time.Time) bool {
+	return c.CreatedAt.Add(time.Duration(c.ExpiresIn) * time.Second).Before(t)
+}
+
+// mfaStorage returns the response storage as MFAStorage, setting an error
+// if it doesn't support the challenges
+func mfaStorage(w *Response) MFAStorage {
+	ms, ok := storageAs[MFAStorage](w.Storage)
+	if !ok {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = errors.New("storage does not implement MFAStorage")
+		return nil
+	}
+	return ms
+}
+
+// consumeMFAChallenge consumes the challenge completed by the request
+// before the tokens are issued, so concurrent requests complete it once.
+// Sets an error on the response and returns false on failure.
+func (s *Server) consumeMFAChallenge(w *Response, ar *AccessRequest) bool {
+	ms := mfaStorage(w)
+	if ms == nil {
+		return false
+	}
+	if _, err := ms.ConsumeMFAChallenge(ar.MFAChallenge.Token); err == ErrNotFound {
+		w.SetError(E_INVALID_GRANT, "mfa_token is invalid")
+		return false
+	} else if err != nil {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = err
+		return false
+	}
+	return true
+}
+
+// issueMFAChallenge stores the access request as a challenge and outputs
+// the mfa_required error with the mfa_token
+func (s *Server) issueMFAChallenge(w *Response, ar *AccessRequest) {
+	ms := mfaStorage(w)
+	if ms == nil {
+		return
+	}
+
+	ch := &MFAChallenge{
+		Client:                ar.Client,
+		GrantType:             ar.Type,
+		Scope:                 ar.Scope,
+		Username:              ar.Username,
+		Subject:               ar.Subject,
+		AuthorizationDetails:  ar.AuthorizationDetails,
+		AuthenticationContext: ar.AuthenticationContext,
+		GenerateRefresh:       ar.GenerateRefresh,
+		Expiration:            ar.Expiration,
+		RefreshExpiration:     ar.RefreshExpiration,
+		ExpiresIn:             s.requestConfig(w).MFAChallengeExpiration,
+		CreatedAt:             s.Now(),
+		UserData:              ar.UserData,
+	}
+	var err error
+	if ch.Token, err = (TokenFormat{Length: 32}).Generate(); err != nil {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = err
+		return
+	}
+	if err = ms.SaveMFAChallenge(ch); err != nil {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = err
+		return
+	}
+
+	w.SetError(E_MFA_REQUIRED, "")
+	w.Output["mfa_token"] = ch.Token
+}
+
+// handleMFAOTPRequest loads the challenge of the mfa_token. The application
+// must verify AccessRequest.Password, the one-time password, before
+// authorizing the request.
+func (s *Server) handleMFAOTPRequest(w *Response, r *http.Request) *AccessRequest {
+	// get client authentication
+	auth := GetClientAuth(w, r, s.requestConfig(w).AllowClientSecretInParams)
+	if auth == nil {
+		return nil
+	}
+	ms := mfaStorage(w)
+	if ms == nil {
+		return nil
+	}
+
+	ret := &AccessRequest{
+		Type:        MFA_OTP,
+		Code:        r.Form.Get("mfa_token"),
+		Password:    r.Form.Get("otp"),
+		HttpRequest: r,
+	}
+
+	// "mfa_token" and "otp" are required
+	if ret.Code == "" || ret.Password == "" {
+		w.SetError(E_INVALID_REQUEST, "mfa_token and otp are required")
+		return nil
+	}
+
+	// must have a valid client
+	if ret.Client = s.authenticateClient(auth, w, r); ret.Client == nil {
+		return nil
+	}
+
+	ch, err := ms.LoadMFAChallenge(ret.Code)
+	if err != nil && err != ErrNotFound {
+		w.SetError(E_SERVER_ERROR, "failed to load mfa_token")
+		w.InternalError = err
+		return nil
+	}
+	if ch == nil || ch.Client == nil || !CheckClientID(ch.Client, ret.Client.GetID()) {
+		w.SetError(E_INVALID_GRANT, "mfa_token is invalid")
+		return nil
+	}
+	if ch.IsExpiredAt(s.Now()) {
+		ms.RemoveMFAChallenge(ch.Token)
+		w.SetError(E_INVALID_GRANT, "mfa_token is expired")
+		return nil
+	}
+
+	// limit the one-time passwords tried per challenge, counting the
+	// concurrent attempts too
+	if ch.Attempts, err = ms.IncrementMFAAttempts(ch.Token); err == ErrNotFound {
+		w.SetError(E_INVALID_GRANT, "mfa_token is invalid")
+		return nil
+	} else if err != nil {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = err
+		return nil
+	}
+	if max := s.requestConfig(w).MFAMaxAttempts; max > 0 && ch.Attempts > max {
+		ms.RemoveMFAChallenge(ch.Token)
+		w.SetError(E_INVALID_GRANT, "too many one-time password attempts")
+		return nil
+	}
+
+	ret.MFAChallenge = ch
+	ret.Scope = ch.Scope
+	ret.Username = ch.Username
+	ret.Subject = ch.Subject
+	ret.AuthorizationDetails = ch.AuthorizationDetails
+	ret.AuthenticationContext = ch.AuthenticationContext
+	ret.GenerateRefresh = ch.GenerateRefresh
+	ret.Expiration = ch.Expiration
+	ret.RefreshExpiration = ch.RefreshExpiration
+	ret.UserData = ch.UserData
+	ret.RedirectUri = FirstRedirectURI(s.redirectURIs(w, ret.Client))
+	return ret
+}
@@ -0,0 +1,144 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// mfaTestingStorage is a TestingStorage supporting mfa challenges
+type mfaTestingStorage struct {
+	*TestingStorage
+	challenges map[string]*MFAChallenge
+}
+
+func (s *mfaTestingStorage) Clone() Storage {
+	return s
+}
+
+func (s *mfaTestingStorage) SaveMFAChallenge(data *MFAChallenge) error {
+	s.challenges[data.Token] = data
+	return nil
+}
+
+func (s *mfaTestingStorage) LoadMFAChallenge(token string) (*MFAChallenge, error) {
+	if c, ok := s.challenges[token]; ok {
+		return c, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *mfaTestingStorage) RemoveMFAChallenge(token string) error {
+	delete(s.challenges, token)
+	return nil
+}
+
+func (s *mfaTestingStorage) ConsumeMFAChallenge(token string) (*MFAChallenge, error) {
+	c, ok := s.challenges[token]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delete(s.challenges, token)
+	return c, nil
+}
+
+func (s *mfaTestingStorage) IncrementMFAAttempts(token string) (int, error) {
+	c, ok := s.challenges[token]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	c.Attempts++
+	return c.Attempts, nil
+}
+
+func TestMFAChallenge(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{PASSWORD, MFA_OTP}
+	sconfig.MFAMaxAttempts = 2
+	storage := &mfaTestingStorage{TestingStorage: NewTestingStorage(), challenges: make(map[string]*MFAChallenge)}
+	server := NewServer(sconfig, storage)
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+
+	request := func(form url.Values) *Response {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = form
+		req.PostForm = form
+
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			switch ar.Type {
+			case PASSWORD:
+				ar.Authorized = ar.Username == "testing" && ar.Password == "testing"
+				ar.RequireMFA = true
+				ar.Subject = "user1"
+			case MFA_OTP:
+				ar.Authorized = ar.Password == "123456"
+			}
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		return resp
+	}
+
+	resp := request(url.Values{"grant_type": {string(PASSWORD)}, "username": {"testing"}, "password": {"testing"}})
+	if resp.ErrorId != E_MFA_REQUIRED {
+		t.Fatalf("Expected mfa_required, got %v", resp.Output)
+	}
+	if _, ok := resp.Output["access_token"]; ok {
+		t.Fatalf("Tokens must not be issued before the second factor")
+	}
+	mfaToken := resp.Output["mfa_token"].(string)
+
+	resp = request(url.Values{"grant_type": {string(MFA_OTP)}, "mfa_token": {mfaToken}, "otp": {"000000"}})
+	if resp.ErrorId != E_ACCESS_DENIED {
+		t.Fatalf("Wrong otp should be denied, got %v", resp.Output)
+	}
+
+	resp = request(url.Values{"grant_type": {string(MFA_OTP)}, "mfa_token": {mfaToken}, "otp": {"123456"}})
+	if resp.IsError {
+		t.Fatalf("Correct otp should issue the tokens: %v", resp.Output)
+	}
+	if resp.Output["access_token"] != "1" || resp.Output["refresh_token"] != "r1" {
+		t.Fatalf("Unexpected tokens: %v", resp.Output)
+	}
+	if len(storage.challenges) != 0 {
+		t.Fatalf("Completed challenge should be removed")
+	}
+
+	// attempts are limited
+	resp = request(url.Values{"grant_type": {string(PASSWORD)}, "username": {"testing"}, "password": {"testing"}})
+	mfaToken = resp.Output["mfa_token"].(string)
+	for i := 0; i < 2; i++ {
+		request(url.Values{"grant_type": {string(MFA_OTP)}, "mfa_token": {mfaToken}, "otp": {"000000"}})
+	}
+	resp = request(url.Values{"grant_type": {string(MFA_OTP)}, "mfa_token": {mfaToken}, "otp": {"123456"}})
+	if resp.ErrorId != E_INVALID_GRANT {
+		t.Fatalf("Too many attempts should fail, got %v", resp.Output)
+	}
+
+	// concurrent requests complete the challenge once
+	resp = request(url.Values{"grant_type": {string(PASSWORD)}, "username": {"testing"}, "password": {"testing"}})
+	mfaToken = resp.Output["mfa_token"].(string)
+	var responses [2]*Response
+	var requests [2]*AccessRequest
+	for i := range requests {
+		req, _ := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = url.Values{"grant_type": {string(MFA_OTP)}, "mfa_token": {mfaToken}, "otp": {"123456"}}
+		req.PostForm = req.Form
+		responses[i] = server.NewResponse()
+		if requests[i] = server.HandleAccessRequest(responses[i], req); requests[i] == nil {
+			t.Fatalf("Request %d failed: %v", i, responses[i].Output)
+		}
+	}
+	for i, ar := range requests {
+		ar.Authorized = true
+		server.FinishAccessRequest(responses[i], ar.HttpRequest, ar)
+	}
+	if responses[0].IsError || responses[1].ErrorId != E_INVALID_GRANT {
+		t.Fatalf("Expected the challenge completed once, got %v and %v", responses[0].Output, responses[1].Output)
+	}
+}
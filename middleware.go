@@ -0,0 +1,38 @@
+package osin
+
+import (
+	"net/http"
+)
+
+// Endpoint identifies the server endpoint handling a request
+type Endpoint string
+
+const (
+	ENDPOINT_AUTHORIZE     Endpoint = "authorize"
+	ENDPOINT_TOKEN         Endpoint = "token"
+	ENDPOINT_INTROSPECTION Endpoint = "introspection"
+	ENDPOINT_REVOCATION    Endpoint = "revocation"
+)
+
+// Handler handles a request of an endpoint. It returns the *AuthorizeRequest
+// or *AccessRequest to be finished, or nil if the request failed or was
+// answered, with the response set accordingly.
+type Handler func(endpoint Endpoint, w *Response, r *http.Request) interface{}
+
+// Middleware wraps a Handler, to run code before and after it or to stop the
+// request by setting an error on the response and returning nil
+type Middleware func(next Handler) Handler
+
+// Use registers middleware wrapping HandleAuthorizeRequest and
+// HandleAccessRequest. The first registered middleware is the outermost one.
+func (s *Server) Use(m ...Middleware) {
+	s.middleware = append(s.middleware, m...)
+}
+
+// runMiddleware runs the handler of the endpoint through the registered middleware
+func (s *Server) runMiddleware(endpoint Endpoint, w *Response, r *http.Request, h Handler) interface{} {
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		h = s.middleware[i](h)
+	}
+	return h(endpoint, w, r)
+}
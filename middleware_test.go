@@ -0,0 +1,73 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+
+	var calls []string
+	server.Use(func(next Handler) Handler {
+		return func(endpoint Endpoint, w *Response, r *http.Request) interface{} {
+			calls = append(calls, "outer:"+string(endpoint))
+			ret := next(endpoint, w, r)
+			if ar, ok := ret.(*AccessRequest); ok {
+				calls = append(calls, "outer:client="+ar.Client.GetID())
+			}
+			return ret
+		}
+	}, func(next Handler) Handler {
+		return func(endpoint Endpoint, w *Response, r *http.Request) interface{} {
+			calls = append(calls, "inner")
+			if r.Header.Get("X-Bot") != "" {
+				w.SetError(E_ACCESS_DENIED, "bot detected")
+				return nil
+			}
+			return next(endpoint, w, r)
+		}
+	})
+
+	newRequest := func() *http.Request {
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = make(url.Values)
+		req.Form.Set("grant_type", string(CLIENT_CREDENTIALS))
+		req.PostForm = req.Form
+		return req
+	}
+
+	resp := server.NewResponse()
+	ar := server.HandleAccessRequest(resp, newRequest())
+	if ar == nil || resp.IsError {
+		t.Fatalf("Request should succeed: %v", resp.Output)
+	}
+	expected := []string{"outer:token", "inner", "outer:client=1234"}
+	if len(calls) != len(expected) {
+		t.Fatalf("Expected calls %v, got %v", expected, calls)
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Fatalf("Expected calls %v, got %v", expected, calls)
+		}
+	}
+
+	// middleware stopping the request
+	resp = server.NewResponse()
+	req := newRequest()
+	req.Header.Set("X-Bot", "1")
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		t.Fatalf("Request should be stopped by the middleware")
+	}
+	if resp.ErrorId != E_ACCESS_DENIED {
+		t.Fatalf("Unexpected error: %s", resp.ErrorId)
+	}
+}
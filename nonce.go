@@ -0,0 +1,317 @@
+package osin
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrInvalidNonce is returned by CheckNonce for nonces that are unknown,
+// expired, already used or issued for another purpose
+var ErrInvalidNonce = errors.New("invalid or expired nonce")
+
+// NoncePurpose is what a server provided nonce is used for
+type NoncePurpose string
+
+const (
+	// Nonce of the DPoP proofs (https://www.rfc-editor.org/rfc/rfc9449#section-8)
+	NONCE_DPOP NoncePurpose = "dpop"
+
+	// c_nonce of the key proofs of the credential requests (OpenID for
+	// Verifiable Credential Issuance)
+	NONCE_C_NONCE NoncePurpose = "c_nonce"
+)
+
+// NonceRotation is when new nonces are issued
+type NonceRotation string
+
+const (
+	// A new nonce is issued each time, and consumed when checked
+	NONCE_ROTATE_ON_USE NonceRotation = "on_use"
+
+	// The same nonce is issued until half its lifetime, so the previous
+	// one stays valid while clients switch to the new one
+	NONCE_ROTATE_PERIODIC NonceRotation = "periodic"
+)
+
+// NoncePolicy is the lifetime and rotation of the nonces of a purpose
+type NoncePolicy struct {
+	// Expiration in seconds
+	Expiration int32
+
+	Rotation NonceRotation
+}
+
+// Nonce is a server provided nonce
+type Nonce struct {
+	Value     string
+	Purpose   NoncePurpose
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// IsExpiredAt is true if the nonce expires at time 't'
+func (n *Nonce) IsExpiredAt(t time.Time) bool {
+	return !t.Before(n.ExpiresAt)
+}
+
+// NonceStore stores the server provided nonces
+type NonceStore interface {
+	SaveNonce(n *Nonce) error
+
+	// LoadNonce looks up a nonce. Returns ErrNotFound if not found.
+	LoadNonce(value string) (*Nonce, error)
+
+	// ConsumeNonce deletes a nonce and returns it, atomically, so a nonce
+	// is consumed once. Returns ErrNotFound if not found.
+	ConsumeNonce(value string) (*Nonce, error)
+
+	RemoveNonce(value string) error
+}
+
+// MemoryNonceStore is an in-memory NonceStore, for single instance
+// deployments and tests. Expired nonces are dropped when saving, at most
+// once a minute.
+type MemoryNonceStore struct {
+	mu      sync.Mutex
+	nonces  map[string]*Nonce
+	sweptAt time.Time
+}
+
+// NewMemoryNonceStore creates a new MemoryNonceStore
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{
+		nonces: make(map[string]*Nonce),
+	}
+}
+
+func (m *MemoryNonceStore) SaveNonce(n *Nonce) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if n.CreatedAt.Sub(m.sweptAt) >= time.Minute {
+		for k, v := range m.nonces {
+			if v.IsExpiredAt(n.CreatedAt) {
+				delete(m.nonces, k)
+			}
+		}
+		m.sweptAt = n.CreatedAt
+	}
+	c := *n
+	m.nonces[n.Value] = &c
+	return nil
+}
+
+func (m *MemoryNonceStore) LoadNonce(value string) (*Nonce, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if n, ok := m.nonces[value]; ok {
+		c := *n
+		return &c, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MemoryNonceStore) ConsumeNonce(value string) (*Nonce, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.nonces[value]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delete(m.nonces, value)
+	return n, nil
+}
+
+func (m *MemoryNonceStore) RemoveNonce(value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.nonces, value)
+	return nil
+}
+
+// currentNonces are the nonces issued with NONCE_ROTATE_PERIODIC
+type currentNonces struct {
+	mu     sync.Mutex
+	nonces map[NoncePurpose]*Nonce
+}
+
+// IssueNonce returns a nonce for the purpose, new or current per the
+// Config.NoncePolicies rotation, saved in the server NonceStore
+func (s *Server) IssueNonce(purpose NoncePurpose) (*Nonce, error) {
+	return s.issueNonce(s.config(), purpose)
+}
+
+func (s *Server) issueNonce(config *ServerConfig, purpose NoncePurpose) (*Nonce, error) {
+	if s.NonceStore == nil {
+		return nil, errors.New("no nonce store")
+	}
+	policy := config.NoncePolicyFor(purpose)
+	now := s.Now()
+
+	if policy.Rotation == NONCE_ROTATE_PERIODIC {
+		s.currentNonces.mu.Lock()
+		defer s.currentNonces.mu.Unlock()
+		if n := s.currentNonces.nonces[purpose]; n != nil && now.Before(n.CreatedAt.Add(n.ExpiresAt.Sub(n.CreatedAt)/2)) {
+			return n, nil
+		}
+	}
+
+	value, err := (TokenFormat{}).Generate()
+	if err != nil {
+		return nil, err
+	}
+	n := &Nonce{
+		Value:     value,
+		Purpose:   purpose,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Duration(policy.Expiration) * time.Second),
+	}
+	if err = s.NonceStore.SaveNonce(n); err != nil {
+		return nil, err
+	}
+	if policy.Rotation == NONCE_ROTATE_PERIODIC {
+		if s.currentNonces.nonces == nil {
+			s.currentNonces.nonces = make(map[NoncePurpose]*Nonce)
+		}
+		s.currentNonces.nonces[purpose] = n
+	}
+	return n, nil
+}
+
+// CheckNonce validates a nonce issued by IssueNonce for the purpose,
+// consuming it with NONCE_ROTATE_ON_USE. Returns ErrInvalidNonce if it is
+// not valid.
+func (s *Server) CheckNonce(purpose NoncePurpose, value string) error {
+	return s.checkNonce(s.config(), purpose, value)
+}
+
+func (s *Server) checkNonce(config *ServerConfig, purpose NoncePurpose, value string) error {
+	if s.NonceStore == nil {
+		return errors.New("no nonce store")
+	}
+	if value == "" {
+		return ErrInvalidNonce
+	}
+	n, err := s.NonceStore.LoadNonce(value)
+	if err == ErrNotFound {
+		return ErrInvalidNonce
+	}
+	if err != nil {
+		return err
+	}
+	if n.Purpose != purpose || n.IsExpiredAt(s.Now()) {
+		return ErrInvalidNonce
+	}
+	if config.NoncePolicyFor(purpose).Rotation == NONCE_ROTATE_ON_USE {
+		// only one of concurrent requests consumes the nonce
+		if _, err = s.NonceStore.ConsumeNonce(value); err == ErrNotFound {
+			return ErrInvalidNonce
+		}
+		return err
+	}
+	return nil
+}
+
+// CheckDPoPNonce checks the nonce of a DPoP proof, validated by the
+// application, when Config.RequireDPoPNonce is set. The token endpoint
+// calls it for the requests with a DPoP proof, resource servers call it
+// themselves. A missing or invalid nonce sets a use_dpop_nonce error on the
+// response, as a DPoP challenge for resource servers, and returns false.
+// The DPoP-Nonce header carries the nonce of the next proof either way.
+func (s *Server) CheckDPoPNonce(w *Response, nonce string, resource bool) bool {
+	if !s.requestConfig(w).RequireDPoPNonce {
+		return true
+	}
+	err := s.checkNonce(s.requestConfig(w), NONCE_DPOP, nonce)
+	if err != nil && err != ErrInvalidNonce {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = err
+		return false
+	}
+
+	next, nerr := s.issueNonce(s.requestConfig(w), NONCE_DPOP)
+	if nerr != nil {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = nerr
+		return false
+	}
+	if err != nil {
+		w.SetError(E_USE_DPOP_NONCE, "")
+		w.InternalError = err
+		if resource {
+			w.SetChallenge("DPoP", s.requestConfig(w).Realm, E_USE_DPOP_NONCE)
+		}
+	}
+	w.SetHeader("DPoP-Nonce", next.Value)
+	return err == nil
+}
+
+// checkDPoPProofNonce checks the nonce of the DPoP proof of a token
+// request, if any, with CheckDPoPNonce. Only the nonce is checked: the
+// proof itself is validated by the application.
+func (s *Server) checkDPoPProofNonce(w *Response, r *http.Request) bool {
+	proof := r.Header.Get("DPoP")
+	if proof == "" || !s.requestConfig(w).RequireDPoPNonce {
+		return true
+	}
+	t, err := ParseJWT(proof)
+	if err != nil {
+		w.SetError(E_INVALID_DPOP_PROOF, "")
+		w.InternalError = err
+		return false
+	}
+	return s.CheckDPoPNonce(w, t.StringClaim("nonce"), false)
+}
+
+// NonceRequest is a request to the nonce endpoint
+type NonceRequest struct {
+	// c_nonce issued
+	Nonce *Nonce
+
+	// HttpRequest *http.Request for special use
+	HttpRequest *http.Request
+}
+
+// HandleNonceRequest is the nonce endpoint handler of OpenID for Verifiable
+// Credential Issuance, issuing a c_nonce
+// (https://openid.net/specs/openid-4-verifiable-credential-issuance-1_0.html#section-7)
+func (s *Server) HandleNonceRequest(w *Response, r *http.Request) *NonceRequest {
+	// Only allow POST
+	if r.Method != "POST" {
+		w.SetError(E_INVALID_REQUEST, "")
+		w.InternalError = errors.New("Request must be POST")
+		return nil
+	}
+	w.NoStore = true
+
+	n, err := s.issueNonce(s.requestConfig(w), NONCE_C_NONCE)
+	if err != nil {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = err
+		return nil
+	}
+	return &NonceRequest{
+		Nonce:       n,
+		HttpRequest: r,
+	}
+}
+
+// FinishNonceRequest outputs the c_nonce, and a DPoP nonce in the
+// DPoP-Nonce header with Config.RequireDPoPNonce
+func (s *Server) FinishNonceRequest(w *Response, r *http.Request, nr *NonceRequest) {
+	// don't process if is already an error
+	if w.IsError {
+		return
+	}
+	if s.requestConfig(w).RequireDPoPNonce {
+		n, err := s.issueNonce(s.requestConfig(w), NONCE_DPOP)
+		if err != nil {
+			w.SetError(E_SERVER_ERROR, "")
+			w.InternalError = err
+			return
+		}
+		w.SetHeader("DPoP-Nonce", n.Value)
+	}
+	w.Output["c_nonce"] = nr.Nonce.Value
+}
@@ -0,0 +1,221 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNoncePropagation(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.RequireNonce = true
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+
+	newAuthorizeRequest := func(nonce string) *http.Request {
+		req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Form = make(url.Values)
+		req.Form.Set("response_type", string(CODE))
+		req.Form.Set("client_id", "1234")
+		req.Form.Set("scope", "openid profile")
+		req.Form.Set("nonce", nonce)
+		return req
+	}
+
+	// missing nonce is refused
+	resp := server.NewResponse()
+	if ar := server.HandleAuthorizeRequest(resp, newAuthorizeRequest("")); ar != nil {
+		t.Fatalf("Request without nonce should fail")
+	}
+	if resp.ErrorId != E_INVALID_REQUEST {
+		t.Fatalf("Unexpected error: %s", resp.ErrorId)
+	}
+
+	// nonce is stored with the code
+	resp = server.NewResponse()
+	req := newAuthorizeRequest("n-0S6_WzA2Mj")
+	ar := server.HandleAuthorizeRequest(resp, req)
+	if ar == nil {
+		t.Fatalf("Request should succeed: %v", resp.Output)
+	}
+	ar.Authorized = true
+	server.FinishAuthorizeRequest(resp, req, ar)
+	if resp.IsError {
+		t.Fatalf("Request should succeed: %v", resp.Output)
+	}
+	code := resp.Output["code"].(string)
+	authData, err := server.Storage.LoadAuthorize(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authData.Nonce != "n-0S6_WzA2Mj" {
+		t.Fatalf("Unexpected stored nonce: %s", authData.Nonce)
+	}
+
+	// and carried to the access request
+	resp = server.NewResponse()
+	req, err = http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("1234", "aabbccdd")
+	req.Form = make(url.Values)
+	req.Form.Set("grant_type", string(AUTHORIZATION_CODE))
+	req.Form.Set("code", code)
+	req.PostForm = req.Form
+
+	acr := server.HandleAccessRequest(resp, req)
+	if acr == nil {
+		t.Fatalf("Access request should succeed: %v", resp.Output)
+	}
+	if acr.Nonce != "n-0S6_WzA2Mj" {
+		t.Fatalf("Unexpected access request nonce: %s", acr.Nonce)
+	}
+}
+
+func TestServerNonces(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.RequireDPoPNonce = true
+	server := NewServer(sconfig, NewTestingStorage())
+	server.NonceStore = NewMemoryNonceStore()
+	now := time.Now()
+	server.Now = func() time.Time { return now }
+
+	// nonce endpoint
+	req, _ := http.NewRequest("POST", "http://localhost:14000/nonce", nil)
+	resp := server.NewResponse()
+	if nr := server.HandleNonceRequest(resp, req); nr != nil {
+		server.FinishNonceRequest(resp, req, nr)
+	}
+	cnonce, _ := resp.Output["c_nonce"].(string)
+	dpopNonce := resp.Headers.Get("DPoP-Nonce")
+	if resp.IsError || cnonce == "" || dpopNonce == "" {
+		t.Fatalf("Unexpected nonce response %v %v", resp.Output, resp.Headers)
+	}
+
+	// c_nonces are consumed
+	if err := server.CheckNonce(NONCE_DPOP, cnonce); err != ErrInvalidNonce {
+		t.Fatalf("Nonce of another purpose should be refused, got %v", err)
+	}
+	if err := server.CheckNonce(NONCE_C_NONCE, cnonce); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.CheckNonce(NONCE_C_NONCE, cnonce); err != ErrInvalidNonce {
+		t.Fatalf("Used c_nonce should be refused, got %v", err)
+	}
+
+	// DPoP nonces are reused until half their lifetime, and stay valid
+	// until they expire
+	resp = server.NewResponse()
+	if !server.CheckDPoPNonce(resp, dpopNonce, false) || resp.Headers.Get("DPoP-Nonce") != dpopNonce {
+		t.Fatalf("DPoP nonce should be valid and current: %v", resp.Output)
+	}
+	now = now.Add(200 * time.Second)
+	resp = server.NewResponse()
+	if !server.CheckDPoPNonce(resp, dpopNonce, false) {
+		t.Fatalf("DPoP nonce should still be valid: %v", resp.Output)
+	}
+	rotated := resp.Headers.Get("DPoP-Nonce")
+	if rotated == dpopNonce {
+		t.Fatal("DPoP nonce should be rotated")
+	}
+
+	// use_dpop_nonce errors
+	now = now.Add(200 * time.Second)
+	tests := map[string]struct {
+		nonce    string
+		resource bool
+	}{
+		"missing":  {"", false},
+		"expired":  {dpopNonce, false},
+		"resource": {"unknown", true},
+	}
+	for name, test := range tests {
+		resp = server.NewResponse()
+		if server.CheckDPoPNonce(resp, test.nonce, test.resource) || resp.ErrorId != E_USE_DPOP_NONCE || resp.Headers.Get("DPoP-Nonce") == "" {
+			t.Errorf("%s: expected use_dpop_nonce, got %v", name, resp.Output)
+		}
+		if challenge := resp.Headers.Get("WWW-Authenticate"); test.resource != strings.HasPrefix(challenge, `DPoP error="use_dpop_nonce"`) {
+			t.Errorf("%s: unexpected challenge %q", name, challenge)
+		}
+	}
+}
+
+func TestTokenEndpointDPoPNonce(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+	sconfig.RequireDPoPNonce = true
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+	server.NonceStore = NewMemoryNonceStore()
+	ks, err := NewKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := func(nonce string) *Response {
+		claims := map[string]interface{}{"htm": "POST", "htu": "http://localhost:14000/token"}
+		if nonce != "" {
+			claims["nonce"] = nonce
+		}
+		proof, err := ks.Sign(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, _ := http.NewRequest("POST", "http://localhost:14000/token", nil)
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Header.Set("DPoP", proof)
+		req.Form = url.Values{"grant_type": {string(CLIENT_CREDENTIALS)}}
+		req.PostForm = req.Form
+		resp := server.NewResponse()
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		return resp
+	}
+
+	resp := token("")
+	nonce := resp.Headers.Get("DPoP-Nonce")
+	if resp.ErrorId != E_USE_DPOP_NONCE || nonce == "" {
+		t.Fatalf("Expected use_dpop_nonce with a nonce, got %v %v", resp.Output, resp.Headers)
+	}
+	if resp = token(nonce); resp.IsError || resp.Headers.Get("DPoP-Nonce") == "" {
+		t.Fatalf("Token request with the nonce should succeed: %v", resp.Output)
+	}
+}
+
+func TestConsumeNonceOnce(t *testing.T) {
+	server := NewServer(NewServerConfig(), NewTestingStorage())
+	server.NonceStore = NewMemoryNonceStore()
+	n, err := server.IssueNonce(NONCE_C_NONCE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	valid := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if server.CheckNonce(NONCE_C_NONCE, n.Value) == nil {
+				mu.Lock()
+				valid++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if valid != 1 {
+		t.Fatalf("Nonce should be used once, got %d", valid)
+	}
+}
@@ -0,0 +1,323 @@
+package conformance
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/RangelReale/osin"
+)
+
+const codeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+func s256(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+var cases = []conformanceCase{
+	{"authorize_issues_code", "RFC 6749 4.1.2", func(e *env) error {
+		res := e.authorize(url.Values{
+			"response_type": {"code"},
+			"client_id":     {CLIENT_ID},
+			"redirect_uri":  {REDIRECT_URI},
+			"state":         {"xyz"},
+		})
+		if err := res.expectSuccess(); err != nil {
+			return err
+		}
+		if res.location == nil || !strings.HasPrefix(res.location.String(), REDIRECT_URI+"?") {
+			return fmt.Errorf("expected a redirect to %s, got %v", REDIRECT_URI, res.location)
+		}
+		if res.param("code") == "" || res.param("state") != "xyz" {
+			return fmt.Errorf("expected the code and state, got %s", res.location.RawQuery)
+		}
+		return nil
+	}},
+	{"authorize_unknown_client", "RFC 6749 4.1.2.1", func(e *env) error {
+		res := e.authorize(url.Values{
+			"response_type": {"code"},
+			"client_id":     {"conformance-unknown"},
+			"redirect_uri":  {REDIRECT_URI},
+		})
+		if err := res.expectError(""); err != nil {
+			return err
+		}
+		if res.location != nil {
+			return errors.New("must not redirect for an unknown client")
+		}
+		return nil
+	}},
+	{"authorize_redirect_mismatch", "RFC 6749 4.1.2.1", func(e *env) error {
+		res := e.authorize(url.Values{
+			"response_type": {"code"},
+			"client_id":     {CLIENT_ID},
+			"redirect_uri":  {"https://attacker.example.com/cb"},
+		})
+		if err := res.expectError(""); err != nil {
+			return err
+		}
+		if res.location != nil {
+			return errors.New("must not redirect to an unregistered redirect URI")
+		}
+		return nil
+	}},
+	{"authorize_unsupported_response_type", "RFC 6749 4.1.2.1", func(e *env) error {
+		res := e.authorize(url.Values{
+			"response_type": {"conformance"},
+			"client_id":     {CLIENT_ID},
+			"redirect_uri":  {REDIRECT_URI},
+			"state":         {"xyz"},
+		})
+		if err := res.expectError(osin.E_UNSUPPORTED_RESPONSE_TYPE); err != nil {
+			return err
+		}
+		if res.location == nil || res.param("state") != "xyz" {
+			return errors.New("expected the error redirected with the state")
+		}
+		return nil
+	}},
+	{"code_exchange", "RFC 6749 5.1", func(e *env) error {
+		code, err := e.code(CLIENT_ID, nil)
+		if err != nil {
+			return err
+		}
+		res := e.exchange(code)
+		if err := res.expectSuccess(); err != nil {
+			return err
+		}
+		if res.param("access_token") == "" || res.param("token_type") == "" {
+			return fmt.Errorf("expected access_token and token_type, got %v", res.body)
+		}
+		if !strings.Contains(res.header.Get("Cache-Control"), "no-store") {
+			return errors.New("expected Cache-Control: no-store")
+		}
+		return nil
+	}},
+	{"code_single_use", "RFC 6749 4.1.2", func(e *env) error {
+		code, err := e.code(CLIENT_ID, nil)
+		if err != nil {
+			return err
+		}
+		if err = e.exchange(code).expectSuccess(); err != nil {
+			return err
+		}
+		return e.exchange(code).expectError("")
+	}},
+	{"code_other_client", "RFC 6749 4.1.3", func(e *env) error {
+		code, err := e.code(CLIENT_ID, nil)
+		if err != nil {
+			return err
+		}
+		return e.token(OTHER_CLIENT_ID, CLIENT_SECRET, url.Values{
+			"grant_type":   {"authorization_code"},
+			"code":         {code},
+			"redirect_uri": {REDIRECT_URI},
+		}).expectError("")
+	}},
+	{"code_redirect_mismatch", "RFC 6749 4.1.3", func(e *env) error {
+		code, err := e.code(CLIENT_ID, nil)
+		if err != nil {
+			return err
+		}
+		return e.token(CLIENT_ID, CLIENT_SECRET, url.Values{
+			"grant_type":   {"authorization_code"},
+			"code":         {code},
+			"redirect_uri": {REDIRECT_URI + "/other"},
+		}).expectError("")
+	}},
+	{"code_expired", "RFC 6749 4.1.2", func(e *env) error {
+		client, err := e.storage.GetClient(CLIENT_ID)
+		if err != nil {
+			return err
+		}
+		data := &osin.AuthorizeData{
+			Client:      client,
+			Code:        "conformance-expired-code",
+			ExpiresIn:   60,
+			RedirectUri: REDIRECT_URI,
+			CreatedAt:   time.Now().Add(-time.Hour),
+		}
+		if err = e.storage.SaveAuthorize(data); err != nil {
+			return err
+		}
+		return e.exchange(data.Code).expectError("")
+	}},
+	{"code_unknown", "RFC 6749 5.2", func(e *env) error {
+		return e.exchange("conformance-unknown-code").expectError("")
+	}},
+	{"client_invalid_secret", "RFC 6749 5.2", func(e *env) error {
+		code, err := e.code(CLIENT_ID, nil)
+		if err != nil {
+			return err
+		}
+		return e.token(CLIENT_ID, "wrong", url.Values{
+			"grant_type":   {"authorization_code"},
+			"code":         {code},
+			"redirect_uri": {REDIRECT_URI},
+		}).expectError(osin.E_INVALID_CLIENT)
+	}},
+	{"unsupported_grant_type", "RFC 6749 5.2", func(e *env) error {
+		return e.token(CLIENT_ID, CLIENT_SECRET, url.Values{
+			"grant_type": {"urn:example:conformance"},
+		}).expectError(osin.E_UNSUPPORTED_GRANT_TYPE)
+	}},
+	{"pkce_s256", "RFC 7636 4.6", func(e *env) error {
+		code, err := e.code(PUBLIC_CLIENT_ID, url.Values{
+			"code_challenge":        {s256(codeVerifier)},
+			"code_challenge_method": {osin.PKCE_S256},
+		})
+		if err != nil {
+			return err
+		}
+		return e.token("", "", url.Values{
+			"grant_type":    {"authorization_code"},
+			"client_id":     {PUBLIC_CLIENT_ID},
+			"code":          {code},
+			"redirect_uri":  {REDIRECT_URI},
+			"code_verifier": {codeVerifier},
+		}).expectSuccess()
+	}},
+	{"pkce_wrong_verifier", "RFC 7636 4.6", func(e *env) error {
+		code, err := e.code(PUBLIC_CLIENT_ID, url.Values{
+			"code_challenge":        {s256(codeVerifier)},
+			"code_challenge_method": {osin.PKCE_S256},
+		})
+		if err != nil {
+			return err
+		}
+		return e.token("", "", url.Values{
+			"grant_type":    {"authorization_code"},
+			"client_id":     {PUBLIC_CLIENT_ID},
+			"code":          {code},
+			"redirect_uri":  {REDIRECT_URI},
+			"code_verifier": {strings.Repeat("a", 43)},
+		}).expectError(osin.E_INVALID_GRANT)
+	}},
+	{"pkce_missing_verifier", "RFC 7636 4.6", func(e *env) error {
+		code, err := e.code(PUBLIC_CLIENT_ID, url.Values{
+			"code_challenge":        {s256(codeVerifier)},
+			"code_challenge_method": {osin.PKCE_S256},
+		})
+		if err != nil {
+			return err
+		}
+		return e.token("", "", url.Values{
+			"grant_type":   {"authorization_code"},
+			"client_id":    {PUBLIC_CLIENT_ID},
+			"code":         {code},
+			"redirect_uri": {REDIRECT_URI},
+		}).expectError("")
+	}},
+	{"pkce_required_for_public_clients", "RFC 7636 4.4.1", func(e *env) error {
+		return e.authorize(url.Values{
+			"response_type": {"code"},
+			"client_id":     {PUBLIC_CLIENT_ID},
+			"redirect_uri":  {REDIRECT_URI},
+		}).expectError(osin.E_INVALID_REQUEST)
+	}},
+	{"pkce_unsupported_method", "RFC 7636 4.4.1", func(e *env) error {
+		return e.authorize(url.Values{
+			"response_type":         {"code"},
+			"client_id":             {PUBLIC_CLIENT_ID},
+			"redirect_uri":          {REDIRECT_URI},
+			"code_challenge":        {s256(codeVerifier)},
+			"code_challenge_method": {"S512"},
+		}).expectError(osin.E_INVALID_REQUEST)
+	}},
+	{"refresh", "RFC 6749 6", func(e *env) error {
+		_, refresh, err := e.tokens()
+		if err != nil {
+			return err
+		}
+		res := e.token(CLIENT_ID, CLIENT_SECRET, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {refresh},
+		})
+		if err := res.expectSuccess(); err != nil {
+			return err
+		}
+		if res.param("access_token") == "" {
+			return fmt.Errorf("expected an access_token, got %v", res.body)
+		}
+		return nil
+	}},
+	{"refresh_other_client", "RFC 6749 6", func(e *env) error {
+		_, refresh, err := e.tokens()
+		if err != nil {
+			return err
+		}
+		return e.token(OTHER_CLIENT_ID, CLIENT_SECRET, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {refresh},
+		}).expectError(osin.E_INVALID_GRANT)
+	}},
+	{"introspect_active", "RFC 7662 2.2", func(e *env) error {
+		access, _, err := e.tokens()
+		if err != nil {
+			return err
+		}
+		res := e.introspect(access)
+		if err := res.expectSuccess(); err != nil {
+			return err
+		}
+		if res.body["active"] != true || res.param("client_id") != CLIENT_ID {
+			return fmt.Errorf("expected an active token of %s, got %v", CLIENT_ID, res.body)
+		}
+		return nil
+	}},
+	{"introspect_unknown", "RFC 7662 2.2", func(e *env) error {
+		res := e.introspect("conformance-unknown-token")
+		if err := res.expectSuccess(); err != nil {
+			return err
+		}
+		if res.body["active"] != false || len(res.body) != 1 {
+			return fmt.Errorf("expected only active false, got %v", res.body)
+		}
+		return nil
+	}},
+	{"revoke", "RFC 7009 2.2", func(e *env) error {
+		access, refresh, err := e.tokens()
+		if err != nil {
+			return err
+		}
+		if err = e.revoke(CLIENT_ID, refresh).expectSuccess(); err != nil {
+			return err
+		}
+		res := e.introspect(access)
+		if err := res.expectSuccess(); err != nil {
+			return err
+		}
+		if res.body["active"] != false {
+			return errors.New("the access token of a revoked refresh token must be revoked")
+		}
+		return e.token(CLIENT_ID, CLIENT_SECRET, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {refresh},
+		}).expectError(osin.E_INVALID_GRANT)
+	}},
+	{"revoke_unknown", "RFC 7009 2.2", func(e *env) error {
+		return e.revoke(CLIENT_ID, "conformance-unknown-token").expectSuccess()
+	}},
+	{"revoke_other_client", "RFC 7009 2.1", func(e *env) error {
+		access, _, err := e.tokens()
+		if err != nil {
+			return err
+		}
+		if err = e.revoke(OTHER_CLIENT_ID, access).expectError(""); err != nil {
+			return err
+		}
+		res := e.introspect(access)
+		if err := res.expectSuccess(); err != nil {
+			return err
+		}
+		if res.body["active"] != true {
+			return errors.New("another client must not revoke the token")
+		}
+		return nil
+	}},
+}
@@ -0,0 +1,323 @@
+// Package conformance checks an osin server, and mainly its storage,
+// against the behavior required by RFC 6749, RFC 7636, RFC 7009 and
+// RFC 7662. Storage implementors can run it in CI:
+//
+//	func TestConformance(t *testing.T) {
+//		conformance.Test(t, conformance.Target{
+//			NewStorage: newMyStorage,
+//			SetClient:  saveMyClient,
+//		})
+//	}
+package conformance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/RangelReale/osin"
+)
+
+// Client ids, secret and redirect URI of the clients the suite registers
+const (
+	CLIENT_ID        = "conformance-client"
+	CLIENT_SECRET    = "conformance-secret"
+	OTHER_CLIENT_ID  = "conformance-other"
+	PUBLIC_CLIENT_ID = "conformance-public"
+	REDIRECT_URI     = "https://client.example.com/cb"
+)
+
+// Target is the server under test
+type Target struct {
+	// NewStorage returns the storage of a case. Every case gets a new
+	// storage, which should not contain the clients or tokens of the
+	// previous cases - required.
+	NewStorage func() (osin.Storage, error)
+
+	// SetClient saves a client in the storage - required
+	SetClient func(storage osin.Storage, client osin.Client) error
+
+	// Configure customizes the server configuration, after the suite
+	// enabled the grants it needs - optional
+	Configure func(config *osin.ServerConfig)
+
+	// Setup customizes the server, like setting hooks - optional
+	Setup func(server *osin.Server)
+}
+
+// Failure is a failed conformance case
+type Failure struct {
+	// Case name, and the specification section it checks
+	Case string
+	Spec string
+
+	Err error
+}
+
+func (f Failure) Error() string {
+	return fmt.Sprintf("%s (%s): %v", f.Case, f.Spec, f.Err)
+}
+
+// Run runs all the cases against the target, returning the failures
+func Run(target Target) []Failure {
+	var failures []Failure
+	for _, c := range cases {
+		if err := runCase(target, c); err != nil {
+			failures = append(failures, Failure{Case: c.name, Spec: c.spec, Err: err})
+		}
+	}
+	return failures
+}
+
+// Test runs all the cases against the target as subtests of t
+func Test(t *testing.T, target Target) {
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			if err := runCase(target, c); err != nil {
+				t.Errorf("%s: %v", c.spec, err)
+			}
+		})
+	}
+}
+
+// conformanceCase is a check of the server behavior
+type conformanceCase struct {
+	name string
+	spec string
+	run  func(e *env) error
+}
+
+func runCase(target Target, c conformanceCase) error {
+	e, err := newEnv(target)
+	if err != nil {
+		return fmt.Errorf("setup: %v", err)
+	}
+	defer e.storage.Close()
+	return c.run(e)
+}
+
+// env is the server of a case, with its clients registered
+type env struct {
+	server  *osin.Server
+	storage osin.Storage
+}
+
+func newEnv(target Target) (*env, error) {
+	if target.NewStorage == nil || target.SetClient == nil {
+		return nil, errors.New("NewStorage and SetClient are required")
+	}
+	storage, err := target.NewStorage()
+	if err != nil {
+		return nil, err
+	}
+	clients := []osin.Client{
+		&osin.DefaultClient{Id: CLIENT_ID, Secret: CLIENT_SECRET, RedirectUri: REDIRECT_URI},
+		&osin.DefaultClient{Id: OTHER_CLIENT_ID, Secret: CLIENT_SECRET, RedirectUri: REDIRECT_URI},
+		&osin.DefaultClient{Id: PUBLIC_CLIENT_ID, RedirectUri: REDIRECT_URI},
+	}
+	for _, client := range clients {
+		if err = target.SetClient(storage, client); err != nil {
+			storage.Close()
+			return nil, err
+		}
+	}
+
+	config := osin.NewServerConfig()
+	config.AllowedAuthorizeTypes = osin.AllowedAuthorizeType{osin.CODE}
+	config.AllowedAccessTypes = osin.AllowedAccessType{osin.AUTHORIZATION_CODE, osin.REFRESH_TOKEN, osin.CLIENT_CREDENTIALS}
+	config.RequirePKCEForPublicClients = true
+	if target.Configure != nil {
+		target.Configure(config)
+	}
+	server := osin.NewServer(config, storage)
+	if target.Setup != nil {
+		target.Setup(server)
+	}
+	return &env{server: server, storage: storage}, nil
+}
+
+// result is an endpoint response as seen by the client
+type result struct {
+	// err is set if the call failed, or the response is invalid
+	err error
+
+	status   int
+	header   http.Header
+	body     map[string]interface{}
+	location *url.URL
+}
+
+// param returns a parameter of the JSON body, or of the redirect
+func (res *result) param(name string) string {
+	if res.location != nil {
+		return res.location.Query().Get(name)
+	}
+	switch v := res.body[name].(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// expectError returns an error unless the response is the error id. An
+// empty id accepts any error.
+func (res *result) expectError(id string) error {
+	if res.err != nil {
+		return res.err
+	}
+	got := res.param("error")
+	if got == "" {
+		return fmt.Errorf("expected error %q, got success", id)
+	}
+	if id != "" && got != id {
+		return fmt.Errorf("expected error %q, got %q", id, got)
+	}
+	return nil
+}
+
+// expectSuccess returns an error if the call failed or is an error response
+func (res *result) expectSuccess() error {
+	if res.err != nil {
+		return res.err
+	}
+	if id := res.param("error"); id != "" {
+		return fmt.Errorf("unexpected error %q: %s", id, res.param("error_description"))
+	}
+	return nil
+}
+
+func output(resp *osin.Response, r *http.Request) *result {
+	rec := httptest.NewRecorder()
+	if err := osin.OutputJSON(resp, rec, r); err != nil {
+		return &result{err: err}
+	}
+	res := &result{status: rec.Code, header: rec.Header()}
+	if loc := rec.Header().Get("Location"); loc != "" {
+		res.location, res.err = url.Parse(loc)
+		return res
+	}
+	if rec.Body.Len() > 0 {
+		if err := json.Unmarshal(rec.Body.Bytes(), &res.body); err != nil {
+			res.err = fmt.Errorf("invalid JSON response: %v", err)
+		}
+	}
+	return res
+}
+
+// authorize calls the authorization endpoint, approving valid requests
+func (e *env) authorize(params url.Values) *result {
+	r := httptest.NewRequest("GET", "https://server.example.com/authorize?"+params.Encode(), nil)
+	resp := e.server.NewResponse()
+	defer resp.Close()
+	if ar := e.server.HandleAuthorizeRequest(resp, r); ar != nil {
+		ar.Authorized = true
+		e.server.FinishAuthorizeRequest(resp, r, ar)
+	}
+	return output(resp, r)
+}
+
+// token calls the token endpoint, approving valid requests. The client
+// authenticates with basic auth unless clientId is empty.
+func (e *env) token(clientId, secret string, form url.Values) *result {
+	r := newPost("https://server.example.com/token", form)
+	if clientId != "" {
+		r.SetBasicAuth(clientId, secret)
+	}
+	resp := e.server.NewResponse()
+	defer resp.Close()
+	if ar := e.server.HandleAccessRequest(resp, r); ar != nil {
+		ar.Authorized = true
+		e.server.FinishAccessRequest(resp, r, ar)
+	}
+	return output(resp, r)
+}
+
+// introspect calls the introspection endpoint as CLIENT_ID
+func (e *env) introspect(token string) *result {
+	r := newPost("https://server.example.com/introspect", url.Values{"token": {token}})
+	r.SetBasicAuth(CLIENT_ID, CLIENT_SECRET)
+	resp := e.server.NewResponse()
+	defer resp.Close()
+	if ir := e.server.HandleIntrospectionRequest(resp, r); ir != nil {
+		e.server.FinishIntrospectionRequest(resp, r, ir)
+	}
+	return output(resp, r)
+}
+
+// revoke calls the revocation endpoint
+func (e *env) revoke(clientId, token string) *result {
+	r := newPost("https://server.example.com/revoke", url.Values{"token": {token}})
+	r.SetBasicAuth(clientId, CLIENT_SECRET)
+	resp := e.server.NewResponse()
+	defer resp.Close()
+	if rr := e.server.HandleRevocationRequest(resp, r); rr != nil {
+		e.server.FinishRevocationRequest(resp, r, rr)
+	}
+	return output(resp, r)
+}
+
+func newPost(target string, form url.Values) *http.Request {
+	r := httptest.NewRequest("POST", target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+// code returns an authorization code of the client, with the extra
+// authorization request parameters
+func (e *env) code(clientId string, extra url.Values) (string, error) {
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {clientId},
+		"redirect_uri":  {REDIRECT_URI},
+		"state":         {"xyz"},
+	}
+	for k, v := range extra {
+		params[k] = v
+	}
+	res := e.authorize(params)
+	if err := res.expectSuccess(); err != nil {
+		return "", err
+	}
+	if res.location == nil {
+		return "", fmt.Errorf("expected a redirect, got %d %v", res.status, res.body)
+	}
+	if code := res.param("code"); code != "" {
+		return code, nil
+	}
+	return "", fmt.Errorf("no code in the redirect %s", res.location)
+}
+
+// exchange exchanges a code of CLIENT_ID
+func (e *env) exchange(code string) *result {
+	return e.token(CLIENT_ID, CLIENT_SECRET, url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {REDIRECT_URI},
+	})
+}
+
+// tokens returns an access and refresh token of CLIENT_ID
+func (e *env) tokens() (access, refresh string, err error) {
+	code, err := e.code(CLIENT_ID, nil)
+	if err != nil {
+		return "", "", err
+	}
+	res := e.exchange(code)
+	if err = res.expectSuccess(); err != nil {
+		return "", "", err
+	}
+	access, refresh = res.param("access_token"), res.param("refresh_token")
+	if access == "" || refresh == "" {
+		return "", "", fmt.Errorf("expected access and refresh tokens, got %v", res.body)
+	}
+	return access, refresh, nil
+}
@@ -0,0 +1,44 @@
+package conformance
+
+import (
+	"testing"
+
+	"github.com/RangelReale/osin"
+	"github.com/RangelReale/osin/osintest"
+)
+
+func memoryTarget() Target {
+	return Target{
+		NewStorage: func() (osin.Storage, error) {
+			return osintest.NewStorage(), nil
+		},
+		SetClient: func(storage osin.Storage, client osin.Client) error {
+			storage.(*osintest.Storage).SetClient(client.GetID(), client)
+			return nil
+		},
+	}
+}
+
+func TestConformance(t *testing.T) {
+	Test(t, memoryTarget())
+}
+
+func TestConformanceFailures(t *testing.T) {
+	// a storage that never removes authorization codes allows reuse
+	target := memoryTarget()
+	target.NewStorage = func() (osin.Storage, error) {
+		storage := osintest.NewStorage()
+		storage.FailWith("RemoveAuthorize", osin.ErrNotFound)
+		return storage, nil
+	}
+	failures := Run(target)
+	found := false
+	for _, f := range failures {
+		if f.Case == "code_single_use" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Expected code_single_use to fail, got %v", failures)
+	}
+}
@@ -0,0 +1,58 @@
+package osintest
+
+import (
+	"time"
+
+	"github.com/RangelReale/osin"
+)
+
+// MintAuthorizeCode saves a valid authorization code in the storage. Code,
+// ExpiresIn, CreatedAt and RedirectUri are set if empty; Client is required.
+func MintAuthorizeCode(storage osin.Storage, data *osin.AuthorizeData) (*osin.AuthorizeData, error) {
+	var err error
+	if data.Code == "" {
+		if data.Code, err = (osin.TokenFormat{Length: 16}).Generate(); err != nil {
+			return nil, err
+		}
+	}
+	if data.ExpiresIn == 0 {
+		data.ExpiresIn = 3600
+	}
+	if data.CreatedAt.IsZero() {
+		data.CreatedAt = time.Now()
+	}
+	if data.RedirectUri == "" {
+		data.RedirectUri = osin.FirstUri(data.Client.GetRedirectURI(), "")
+	}
+	if err = storage.SaveAuthorize(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// MintAccessToken saves a valid access token in the storage, with a
+// refresh token if generateRefresh. AccessToken, RefreshToken, ExpiresIn
+// and CreatedAt are set if empty; Client is required.
+func MintAccessToken(storage osin.Storage, data *osin.AccessData, generateRefresh bool) (*osin.AccessData, error) {
+	var err error
+	if data.AccessToken == "" {
+		if data.AccessToken, err = (osin.TokenFormat{Length: 16}).Generate(); err != nil {
+			return nil, err
+		}
+	}
+	if generateRefresh && data.RefreshToken == "" {
+		if data.RefreshToken, err = (osin.TokenFormat{Length: 16}).Generate(); err != nil {
+			return nil, err
+		}
+	}
+	if data.ExpiresIn == 0 {
+		data.ExpiresIn = 3600
+	}
+	if data.CreatedAt.IsZero() {
+		data.CreatedAt = time.Now()
+	}
+	if err = storage.SaveAccess(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,273 @@
+// Package osintest provides test doubles for code using osin: an in-memory
+// Storage with fault injection and call recording, and helpers minting
+// valid codes and tokens.
+package osintest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/RangelReale/osin"
+)
+
+// ANY_METHOD matches all the storage methods in FailWith, FailNext and
+// SetLatency
+const ANY_METHOD = "*"
+
+// Call is a recorded storage call
+type Call struct {
+	// Storage method name, like "LoadAccess"
+	Method string
+
+	// Id, code or token argument of the call
+	Arg string
+
+	// Error returned by the call
+	Err error
+}
+
+// Storage is an in-memory osin.Storage for tests. Errors and latency can
+// be injected per method, named like the osin.Storage methods, and every
+// call is recorded. It is safe for concurrent use, and clones share it.
+type Storage struct {
+	mu        sync.Mutex
+	clients   map[string]osin.Client
+	authorize map[string]*osin.AuthorizeData
+	access    map[string]*osin.AccessData
+	refresh   map[string]string
+
+	faults     map[string]error
+	nextFaults map[string][]error
+	latency    map[string]time.Duration
+	calls      []Call
+}
+
+// NewStorage creates an empty storage
+func NewStorage() *Storage {
+	return &Storage{
+		clients:    make(map[string]osin.Client),
+		authorize:  make(map[string]*osin.AuthorizeData),
+		access:     make(map[string]*osin.AccessData),
+		refresh:    make(map[string]string),
+		faults:     make(map[string]error),
+		nextFaults: make(map[string][]error),
+		latency:    make(map[string]time.Duration),
+	}
+}
+
+// FailWith makes every call of the method return err, until Reset. A nil
+// err stops failing.
+func (s *Storage) FailWith(method string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		delete(s.faults, method)
+	} else {
+		s.faults[method] = err
+	}
+}
+
+// FailNext makes the next call of the method return err. Calls queue
+// their errors.
+func (s *Storage) FailNext(method string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextFaults[method] = append(s.nextFaults[method], err)
+}
+
+// SetLatency delays every call of the method by d
+func (s *Storage) SetLatency(method string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency[method] = d
+}
+
+// Reset removes the injected errors and latencies, and the recorded calls.
+// The stored data is kept.
+func (s *Storage) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = make(map[string]error)
+	s.nextFaults = make(map[string][]error)
+	s.latency = make(map[string]time.Duration)
+	s.calls = nil
+}
+
+// Calls returns the recorded calls, in order
+func (s *Storage) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// CallCount returns the number of recorded calls of the method
+func (s *Storage) CallCount(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, c := range s.calls {
+		if c.Method == method {
+			n++
+		}
+	}
+	return n
+}
+
+// begin applies the latency of the method and returns its injected error,
+// if any. The storage is locked on return when the error is nil.
+func (s *Storage) begin(method string) error {
+	s.mu.Lock()
+	d, ok := s.latency[method]
+	if !ok {
+		d = s.latency[ANY_METHOD]
+	}
+	if d > 0 {
+		s.mu.Unlock()
+		time.Sleep(d)
+		s.mu.Lock()
+	}
+
+	for _, m := range []string{method, ANY_METHOD} {
+		if q := s.nextFaults[m]; len(q) > 0 {
+			s.nextFaults[m] = q[1:]
+			return q[0]
+		}
+	}
+	if err, ok := s.faults[method]; ok {
+		return err
+	}
+	return s.faults[ANY_METHOD]
+}
+
+// end records the call and unlocks the storage
+func (s *Storage) end(method, arg string, err error) {
+	s.calls = append(s.calls, Call{Method: method, Arg: arg, Err: err})
+	s.mu.Unlock()
+}
+
+// Clone returns the storage itself, clones share the data
+func (s *Storage) Clone() osin.Storage {
+	return s
+}
+
+// Close does nothing
+func (s *Storage) Close() {
+}
+
+// SetClient saves a client
+func (s *Storage) SetClient(id string, client osin.Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[id] = client
+}
+
+// GetClient satisfies osin.Storage
+func (s *Storage) GetClient(id string) (client osin.Client, err error) {
+	if err = s.begin("GetClient"); err == nil {
+		var ok bool
+		if client, ok = s.clients[id]; !ok {
+			err = osin.ErrNotFound
+		}
+	}
+	s.end("GetClient", id, err)
+	return
+}
+
+// SaveAuthorize satisfies osin.Storage
+func (s *Storage) SaveAuthorize(data *osin.AuthorizeData) (err error) {
+	if err = s.begin("SaveAuthorize"); err == nil {
+		s.authorize[data.Code] = data
+	}
+	s.end("SaveAuthorize", data.Code, err)
+	return
+}
+
+// InsertAuthorize satisfies osin.AuthorizeInserter
+func (s *Storage) InsertAuthorize(data *osin.AuthorizeData) (err error) {
+	if err = s.begin("InsertAuthorize"); err == nil {
+		if _, ok := s.authorize[data.Code]; ok {
+			err = osin.ErrAlreadyExists
+		} else {
+			s.authorize[data.Code] = data
+		}
+	}
+	s.end("InsertAuthorize", data.Code, err)
+	return
+}
+
+// LoadAuthorize satisfies osin.Storage
+func (s *Storage) LoadAuthorize(code string) (data *osin.AuthorizeData, err error) {
+	if err = s.begin("LoadAuthorize"); err == nil {
+		var ok bool
+		if data, ok = s.authorize[code]; !ok {
+			err = osin.ErrNotFound
+		}
+	}
+	s.end("LoadAuthorize", code, err)
+	return
+}
+
+// RemoveAuthorize satisfies osin.Storage
+func (s *Storage) RemoveAuthorize(code string) (err error) {
+	if err = s.begin("RemoveAuthorize"); err == nil {
+		delete(s.authorize, code)
+	}
+	s.end("RemoveAuthorize", code, err)
+	return
+}
+
+// SaveAccess satisfies osin.Storage
+func (s *Storage) SaveAccess(data *osin.AccessData) (err error) {
+	if err = s.begin("SaveAccess"); err == nil {
+		s.access[data.AccessToken] = data
+		if data.RefreshToken != "" {
+			s.refresh[data.RefreshToken] = data.AccessToken
+		}
+	}
+	s.end("SaveAccess", data.AccessToken, err)
+	return
+}
+
+// LoadAccess satisfies osin.Storage
+func (s *Storage) LoadAccess(token string) (data *osin.AccessData, err error) {
+	if err = s.begin("LoadAccess"); err == nil {
+		var ok bool
+		if data, ok = s.access[token]; !ok {
+			err = osin.ErrNotFound
+		}
+	}
+	s.end("LoadAccess", token, err)
+	return
+}
+
+// RemoveAccess satisfies osin.Storage
+func (s *Storage) RemoveAccess(token string) (err error) {
+	if err = s.begin("RemoveAccess"); err == nil {
+		delete(s.access, token)
+	}
+	s.end("RemoveAccess", token, err)
+	return
+}
+
+// LoadRefresh satisfies osin.Storage
+func (s *Storage) LoadRefresh(token string) (data *osin.AccessData, err error) {
+	if err = s.begin("LoadRefresh"); err == nil {
+		err = osin.ErrNotFound
+		if access, ok := s.refresh[token]; ok {
+			if data, ok = s.access[access]; ok {
+				err = nil
+			}
+		}
+	}
+	s.end("LoadRefresh", token, err)
+	return
+}
+
+// RemoveRefresh satisfies osin.Storage
+func (s *Storage) RemoveRefresh(token string) (err error) {
+	if err = s.begin("RemoveRefresh"); err == nil {
+		delete(s.refresh, token)
+	}
+	s.end("RemoveRefresh", token, err)
+	return
+}
@@ -0,0 +1,82 @@
+package osintest
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/RangelReale/osin"
+)
+
+func TestStorageFaults(t *testing.T) {
+	storage := NewStorage()
+	client := &osin.DefaultClient{Id: "1234", Secret: "aabbccdd", RedirectUri: "http://localhost:14000/appauth"}
+	storage.SetClient(client.Id, client)
+
+	data, err := MintAccessToken(storage, &osin.AccessData{Client: client}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := storage.LoadRefresh(data.RefreshToken); err != nil || loaded != data {
+		t.Fatalf("Unexpected %v, %v", loaded, err)
+	}
+
+	injected := errors.New("connection lost")
+	storage.FailNext("LoadAccess", injected)
+	if _, err = storage.LoadAccess(data.AccessToken); err != injected {
+		t.Fatalf("Expected the injected error, got %v", err)
+	}
+	if _, err = storage.LoadAccess(data.AccessToken); err != nil {
+		t.Fatalf("Only the next call should fail, got %v", err)
+	}
+
+	storage.FailWith(ANY_METHOD, injected)
+	if _, err = storage.GetClient("1234"); err != injected {
+		t.Fatalf("Expected the injected error, got %v", err)
+	}
+	if storage.CallCount("LoadAccess") != 2 {
+		t.Fatalf("Expected 2 LoadAccess calls, got %d", storage.CallCount("LoadAccess"))
+	}
+
+	storage.Reset()
+	if len(storage.Calls()) != 0 {
+		t.Fatal("Reset should clear the calls")
+	}
+	if _, err = storage.GetClient("1234"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageServerError(t *testing.T) {
+	storage := NewStorage()
+	client := &osin.DefaultClient{Id: "1234", Secret: "aabbccdd", RedirectUri: "http://localhost:14000/appauth"}
+	storage.SetClient(client.Id, client)
+	code, err := MintAuthorizeCode(storage, &osin.AuthorizeData{Client: client})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := osin.NewServer(osin.NewServerConfig(), storage)
+	storage.FailNext("SaveAccess", errors.New("disk full"))
+
+	resp := server.NewResponse()
+	req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("1234", "aabbccdd")
+	req.Form = url.Values{
+		"grant_type":   {string(osin.AUTHORIZATION_CODE)},
+		"code":         {code.Code},
+		"redirect_uri": {code.RedirectUri},
+	}
+	req.PostForm = req.Form
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		ar.Authorized = true
+		server.FinishAccessRequest(resp, req, ar)
+	}
+	if resp.ErrorId != osin.E_SERVER_ERROR {
+		t.Fatalf("Expected server_error, got %v", resp.Output)
+	}
+}
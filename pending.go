@@ -0,0 +1,252 @@
+package osin
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// PendingAuthorize is a suspended AuthorizeRequest, holding only serializable
+// data, so a multi-step login or consent flow can span several HTTP requests
+type PendingAuthorize struct {
+	// Handle identifying the pending request
+	Handle string
+
+	ClientIDs   []string
+	Type        AuthorizeRequestType
+	Scope       string
+	RedirectUri string
+	State       string
+	Expiration  int32
+
+	RedirectUriDefaulted bool
+
+	CodeChallenge       string
+	CodeChallengeMethod string
+
+	AuthorizationDetails      AuthorizationDetails
+	AuthenticationRequirement *AuthenticationRequirement
+	ClaimsRequest             *ClaimsRequest
+	Nonce                     string
+	ResponseMode              string
+
+	// Login in progress at an upstream identity provider, see
+	// RedirectToUpstream
+	Upstream *UpstreamLogin
+
+	// Data to be passed to storage. Not used by the library.
+	UserData interface{}
+
+	// Date created
+	CreatedAt time.Time
+
+	// Expiration in seconds
+	ExpiresIn int32
+}
+
+// IsExpiredAt is true if the pending request expires at time 't'
+func (p *PendingAuthorize) IsExpiredAt(t time.Time) bool {
+	return p.CreatedAt.Add(time.Duration(p.ExpiresIn) * time.Second).Before(t)
+}
+
+// PendingAuthorizeStore stores suspended authorize requests
+type PendingAuthorizeStore interface {
+	// SavePendingAuthorize saves the pending request by its handle
+	SavePendingAuthorize(p *PendingAuthorize) error
+
+	// LoadPendingAuthorize looks up a pending request by its handle.
+	// Returns ErrNotFound if not found.
+	LoadPendingAuthorize(handle string) (*PendingAuthorize, error)
+
+	// RemovePendingAuthorize deletes the pending request
+	RemovePendingAuthorize(handle string) error
+}
+
+// MemoryPendingAuthorizeStore is an in-memory PendingAuthorizeStore, for
+// single instance deployments and tests
+type MemoryPendingAuthorizeStore struct {
+	mu      sync.Mutex
+	pending map[string]*PendingAuthorize
+}
+
+// NewMemoryPendingAuthorizeStore creates a new MemoryPendingAuthorizeStore
+func NewMemoryPendingAuthorizeStore() *MemoryPendingAuthorizeStore {
+	return &MemoryPendingAuthorizeStore{
+		pending: make(map[string]*PendingAuthorize),
+	}
+}
+
+func (m *MemoryPendingAuthorizeStore) SavePendingAuthorize(p *PendingAuthorize) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending[p.Handle] = p
+	return nil
+}
+
+func (m *MemoryPendingAuthorizeStore) LoadPendingAuthorize(handle string) (*PendingAuthorize, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p, ok := m.pending[handle]; ok {
+		return p, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MemoryPendingAuthorizeStore) RemovePendingAuthorize(handle string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.pending, handle)
+	return nil
+}
+
+// newPendingAuthorize takes a serializable snapshot of the authorize request
+func (s *Server) newPendingAuthorize(config *ServerConfig, ar *AuthorizeRequest) *PendingAuthorize {
+	p := &PendingAuthorize{
+		Type:                      ar.Type,
+		Scope:                     ar.Scope,
+		RedirectUri:               ar.RedirectUri,
+		State:                     ar.State,
+		Expiration:                ar.Expiration,
+		CodeChallenge:             ar.CodeChallenge,
+		CodeChallengeMethod:       ar.CodeChallengeMethod,
+		AuthorizationDetails:      ar.AuthorizationDetails,
+		AuthenticationRequirement: ar.AuthenticationRequirement,
+		ClaimsRequest:             ar.ClaimsRequest,
+		Nonce:                     ar.Nonce,
+		ResponseMode:              ar.ResponseMode,
+		RedirectUriDefaulted:      ar.RedirectUriDefaulted,
+		UserData:                  ar.UserData,
+		CreatedAt:                 s.Now(),
+		ExpiresIn:                 config.PendingAuthorizeExpiration,
+	}
+	if combo, ok := ar.Client.(*ComboClient); ok {
+		p.ClientIDs = combo.Audience
+	} else {
+		p.ClientIDs = []string{ar.Client.GetID()}
+	}
+	return p
+}
+
+// SuspendAuthorizeRequest saves the authorize request in the
+// PendingAuthorizeStore and returns the handle to resume it later with
+// ResumeAuthorizeRequest, after the login and consent steps.
+func (s *Server) SuspendAuthorizeRequest(ar *AuthorizeRequest) (string, error) {
+	if s.PendingAuthorizeStore == nil {
+		return "", errors.New("no pending authorize store")
+	}
+
+	p := s.newPendingAuthorize(s.config(), ar)
+	var err error
+	if p.Handle, err = newPendingHandle(); err != nil {
+		return "", err
+	}
+	if err = s.PendingAuthorizeStore.SavePendingAuthorize(p); err != nil {
+		return "", err
+	}
+	return p.Handle, nil
+}
+
+// newPendingHandle generates a random pending request handle
+func newPendingHandle() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// ResumeAuthorizeRequest restores a suspended authorize request from its
+// handle, so it can be passed to FinishAuthorizeRequest. The handle can only
+// be resumed once. Sets an error on the response and returns nil if the
+// handle is unknown or expired.
+func (s *Server) ResumeAuthorizeRequest(w *Response, r *http.Request, handle string) *AuthorizeRequest {
+	p := s.takePendingAuthorize(w, handle)
+	if p == nil {
+		return nil
+	}
+	return s.restorePendingAuthorize(w, r, p)
+}
+
+// takePendingAuthorize loads and removes a pending request from the
+// PendingAuthorizeStore. Sets an error on the response and returns nil if
+// the handle is unknown or expired.
+func (s *Server) takePendingAuthorize(w *Response, handle string) *PendingAuthorize {
+	if s.PendingAuthorizeStore == nil {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = errors.New("no pending authorize store")
+		return nil
+	}
+
+	p, err := s.PendingAuthorizeStore.LoadPendingAuthorize(handle)
+	if err != nil && err != ErrNotFound {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = err
+		return nil
+	}
+	if p == nil {
+		w.SetError(E_INVALID_REQUEST, "authorize request not found")
+		return nil
+	}
+	if err = s.PendingAuthorizeStore.RemovePendingAuthorize(handle); err != nil {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = err
+		return nil
+	}
+	if p.IsExpiredAt(s.Now()) {
+		w.SetErrorState(E_INVALID_REQUEST, "authorize request expired", p.State)
+		return nil
+	}
+	return p
+}
+
+// restorePendingAuthorize rebuilds the authorize request of a pending one,
+// reloading its clients
+func (s *Server) restorePendingAuthorize(w *Response, r *http.Request, p *PendingAuthorize) *AuthorizeRequest {
+	ret := &AuthorizeRequest{
+		Type:                      p.Type,
+		Scope:                     p.Scope,
+		RedirectUri:               p.RedirectUri,
+		State:                     p.State,
+		Expiration:                p.Expiration,
+		CodeChallenge:             p.CodeChallenge,
+		CodeChallengeMethod:       p.CodeChallengeMethod,
+		AuthorizationDetails:      p.AuthorizationDetails,
+		AuthenticationRequirement: p.AuthenticationRequirement,
+		ClaimsRequest:             p.ClaimsRequest,
+		Nonce:                     p.Nonce,
+		ResponseMode:              p.ResponseMode,
+		RedirectUriDefaulted:      p.RedirectUriDefaulted,
+		UserData:                  p.UserData,
+		HttpRequest:               r,
+	}
+
+	// reload the clients, they may have changed meanwhile
+	combo := &ComboClient{
+		Audience: p.ClientIDs,
+		Clients:  make([]Client, 0, len(p.ClientIDs)),
+	}
+	for _, id := range p.ClientIDs {
+		cl, err := storageGetClient(r.Context(), w.Storage, id)
+		if err != nil && err != ErrNotFound {
+			w.SetErrorState(E_SERVER_ERROR, "unable to get client", ret.State)
+			w.InternalError = err
+			return nil
+		}
+		if cl == nil {
+			w.SetErrorState(E_UNAUTHORIZED_CLIENT, "client not found", ret.State)
+			return nil
+		}
+		combo.Clients = append(combo.Clients, cl)
+	}
+	if len(combo.Clients) == 1 {
+		ret.Client = combo.Clients[0]
+	} else {
+		ret.Client = combo
+	}
+
+	w.SetRedirect(ret.RedirectUri)
+	return ret
+}
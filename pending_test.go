@@ -0,0 +1,91 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAuthorizeSuspendResume(t *testing.T) {
+	storage := NewTestingStorage()
+	server := NewServer(NewServerConfig(), storage)
+	server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+	server.PendingAuthorizeStore = NewMemoryPendingAuthorizeStore()
+
+	// first request: validate and suspend
+	resp := server.NewResponse()
+	req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Form = make(url.Values)
+	req.Form.Set("response_type", string(CODE))
+	req.Form.Set("client_id", "1234")
+	req.Form.Set("state", "a")
+	req.Form.Set("scope", "profile")
+
+	ar := server.HandleAuthorizeRequest(resp, req)
+	if ar == nil {
+		t.Fatalf("Authorize request should be valid: %v", resp.Output)
+	}
+	handle, err := server.SuspendAuthorizeRequest(ar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// later request: resume and finish
+	resp = server.NewResponse()
+	req, err = http.NewRequest("POST", "http://localhost:14000/login", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar = server.ResumeAuthorizeRequest(resp, req, handle)
+	if ar == nil {
+		t.Fatalf("Authorize request should be resumed: %v", resp.Output)
+	}
+	if ar.Client.GetID() != "1234" || ar.Scope != "profile" || ar.State != "a" {
+		t.Fatalf("Unexpected resumed request: %+v", ar)
+	}
+	ar.Authorized = true
+	server.FinishAuthorizeRequest(resp, req, ar)
+
+	if resp.IsError {
+		t.Fatalf("Should not be an error: %v", resp.Output)
+	}
+	if d := resp.Output["code"]; d != "1" {
+		t.Fatalf("Unexpected authorization code: %v", d)
+	}
+	if d := storage.authorize["1"]; d == nil || d.Scope != "profile" {
+		t.Fatalf("Authorization not saved from resumed request")
+	}
+
+	// handle is single use
+	resp = server.NewResponse()
+	if ar = server.ResumeAuthorizeRequest(resp, req, handle); ar != nil {
+		t.Fatalf("Handle should not be resumed twice")
+	}
+}
+
+func TestAuthorizeResumeExpired(t *testing.T) {
+	now := time.Now()
+	server := NewServer(NewServerConfig(), NewTestingStorage())
+	server.Now = func() time.Time { return now }
+	server.PendingAuthorizeStore = NewMemoryPendingAuthorizeStore()
+
+	ar := &AuthorizeRequest{
+		Type:        CODE,
+		Client:      &DefaultClient{Id: "1234"},
+		RedirectUri: "http://localhost:14000/appauth",
+	}
+	handle, err := server.SuspendAuthorizeRequest(ar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(time.Hour)
+	resp := server.NewResponse()
+	if ar = server.ResumeAuthorizeRequest(resp, nil, handle); ar != nil || resp.ErrorId != E_INVALID_REQUEST {
+		t.Fatalf("Expired handle should not be resumed: %v", resp.Output)
+	}
+}
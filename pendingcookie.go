@@ -0,0 +1,159 @@
+package osin
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrPendingCookieInvalid  = errors.New("pending authorize cookie is invalid")
+	ErrPendingCookieExpired  = errors.New("pending authorize cookie is expired")
+	ErrPendingCookieTooLarge = errors.New("pending authorize cookie is too large")
+)
+
+// PendingAuthorizeCookie carries suspended authorize requests in an
+// HMAC-signed cookie across the login and consent round trips, instead of
+// a PendingAuthorizeStore. The request is readable by the user agent, and
+// may be resumed more than once until it expires.
+type PendingAuthorizeCookie struct {
+	// HMAC-SHA256 key - required
+	Key []byte
+
+	// Cookie name (default "osin_authorize")
+	Name string
+
+	// Cookie path (default "/")
+	Path string
+
+	// Cookie domain, host only if blank
+	Domain string
+
+	// Maximum size of the cookie value in bytes (default 4000)
+	MaxSize int
+
+	// If true, the cookie is sent over plain HTTP too - default false
+	Insecure bool
+}
+
+// NewPendingAuthorizeCookie creates a PendingAuthorizeCookie with the given key
+func NewPendingAuthorizeCookie(key []byte) *PendingAuthorizeCookie {
+	return &PendingAuthorizeCookie{Key: key}
+}
+
+func (c *PendingAuthorizeCookie) name() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return "osin_authorize"
+}
+
+func (c *PendingAuthorizeCookie) cookie(value string, maxAge int) *http.Cookie {
+	path := c.Path
+	if path == "" {
+		path = "/"
+	}
+	return &http.Cookie{
+		Name:     c.name(),
+		Value:    value,
+		Path:     path,
+		Domain:   c.Domain,
+		MaxAge:   maxAge,
+		Secure:   !c.Insecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// Encode signs the pending request into a cookie. UserData is encoded as
+// JSON, and restored as generic JSON values.
+func (c *PendingAuthorizeCookie) Encode(p *PendingAuthorize) (*http.Cookie, error) {
+	if len(c.Key) == 0 {
+		return nil, errors.New("pending authorize cookie key is required")
+	}
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	value := encoded + "." + c.sign(encoded)
+
+	max := c.MaxSize
+	if max <= 0 {
+		max = 4000
+	}
+	if len(value) > max {
+		return nil, ErrPendingCookieTooLarge
+	}
+	return c.cookie(value, int(p.ExpiresIn)), nil
+}
+
+// Decode verifies the signature of the cookie value and returns its
+// pending request. The expiration is checked by the caller.
+func (c *PendingAuthorizeCookie) Decode(value string) (*PendingAuthorize, error) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 2 || len(c.Key) == 0 {
+		return nil, ErrPendingCookieInvalid
+	}
+	if !hmac.Equal([]byte(c.sign(parts[0])), []byte(parts[1])) {
+		return nil, ErrPendingCookieInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrPendingCookieInvalid
+	}
+	p := &PendingAuthorize{}
+	if err := json.Unmarshal(payload, p); err != nil || len(p.ClientIDs) == 0 {
+		return nil, ErrPendingCookieInvalid
+	}
+	return p, nil
+}
+
+// Clear returns a cookie deleting the pending request cookie
+func (c *PendingAuthorizeCookie) Clear() *http.Cookie {
+	return c.cookie("", -1)
+}
+
+func (c *PendingAuthorizeCookie) sign(payload string) string {
+	mac := hmac.New(sha256.New, c.Key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SuspendAuthorizeRequestCookie returns a signed cookie carrying the
+// authorize request, to be set on the login or consent page response and
+// resumed with ResumeAuthorizeRequestCookie.
+func (s *Server) SuspendAuthorizeRequestCookie(c *PendingAuthorizeCookie, ar *AuthorizeRequest) (*http.Cookie, error) {
+	return c.Encode(s.newPendingAuthorize(s.config(), ar))
+}
+
+// ResumeAuthorizeRequestCookie restores the authorize request carried by
+// the signed cookie of the request, so it can be passed to
+// FinishAuthorizeRequest, and deletes the cookie in the response. Sets an
+// error on the response and returns nil if the cookie is missing,
+// tampered with or expired.
+func (s *Server) ResumeAuthorizeRequestCookie(w *Response, r *http.Request, c *PendingAuthorizeCookie) *AuthorizeRequest {
+	cookie, err := r.Cookie(c.name())
+	if err != nil {
+		w.SetError(E_INVALID_REQUEST, "authorize request not found")
+		return nil
+	}
+	w.Headers.Add("Set-Cookie", c.Clear().String())
+
+	p, err := c.Decode(cookie.Value)
+	if err != nil {
+		w.SetError(E_INVALID_REQUEST, "authorize request not found")
+		w.InternalError = err
+		return nil
+	}
+	if p.IsExpiredAt(s.Now()) {
+		w.SetErrorState(E_INVALID_REQUEST, "authorize request expired", p.State)
+		w.InternalError = ErrPendingCookieExpired
+		return nil
+	}
+	return s.restorePendingAuthorize(w, r, p)
+}
@@ -0,0 +1,81 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthorizeSuspendResumeCookie(t *testing.T) {
+	storage := NewTestingStorage()
+	server := NewServer(NewServerConfig(), storage)
+	server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+	carrier := NewPendingAuthorizeCookie([]byte("0123456789abcdef0123456789abcdef"))
+
+	// first request: validate and suspend in the cookie
+	resp := server.NewResponse()
+	req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Form = url.Values{"response_type": {string(CODE)}, "client_id": {"1234"}, "state": {"a"}, "scope": {"profile"}}
+	ar := server.HandleAuthorizeRequest(resp, req)
+	if ar == nil {
+		t.Fatalf("Authorize request should be valid: %v", resp.Output)
+	}
+	cookie, err := server.SuspendAuthorizeRequestCookie(carrier, ar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cookie.HttpOnly || !cookie.Secure || cookie.MaxAge != 600 {
+		t.Fatalf("Unexpected cookie: %v", cookie)
+	}
+
+	resume := func(value string) (*Response, *AuthorizeRequest) {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/login", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(&http.Cookie{Name: cookie.Name, Value: value})
+		return resp, server.ResumeAuthorizeRequestCookie(resp, req, carrier)
+	}
+
+	// later request: resume and finish
+	resp, ar = resume(cookie.Value)
+	if ar == nil {
+		t.Fatalf("Authorize request should be resumed: %v", resp.Output)
+	}
+	if ar.Client.GetID() != "1234" || ar.Scope != "profile" || ar.State != "a" {
+		t.Fatalf("Unexpected resumed request: %+v", ar)
+	}
+	if !strings.Contains(resp.Headers.Get("Set-Cookie"), "Max-Age=0") {
+		t.Errorf("Cookie should be cleared: %v", resp.Headers)
+	}
+	ar.Authorized = true
+	server.FinishAuthorizeRequest(resp, req, ar)
+	if d := resp.Output["code"]; d != "1" {
+		t.Fatalf("Unexpected authorization code: %v", d)
+	}
+
+	// tampered cookie
+	parts := strings.Split(cookie.Value, ".")
+	tampered, _ := carrier.Encode(&PendingAuthorize{ClientIDs: []string{"1234"}, Scope: "admin", CreatedAt: time.Now(), ExpiresIn: 600})
+	if resp, ar = resume(strings.Split(tampered.Value, ".")[0] + "." + parts[1]); ar != nil || resp.ErrorId != E_INVALID_REQUEST {
+		t.Fatalf("Tampered cookie should be refused: %v", resp.Output)
+	}
+
+	// expired cookie
+	server.Now = func() time.Time { return time.Now().Add(time.Hour) }
+	if resp, ar = resume(cookie.Value); ar != nil || resp.ErrorId != E_INVALID_REQUEST {
+		t.Fatalf("Expired cookie should be refused: %v", resp.Output)
+	}
+
+	// too large
+	carrier.MaxSize = 100
+	if _, err := server.SuspendAuthorizeRequestCookie(carrier, &AuthorizeRequest{Client: &DefaultClient{Id: "1234"}}); err != ErrPendingCookieTooLarge {
+		t.Fatalf("Expected ErrPendingCookieTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,259 @@
+package osin
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Pre-authorized code grant of OpenID for Verifiable Credential Issuance
+// (https://openid.net/specs/openid-4-verifiable-credential-issuance-1_0.html#section-3.5)
+const (
+	PRE_AUTHORIZED_CODE AccessRequestType = "urn:ietf:params:oauth:grant-type:pre-authorized_code"
+)
+
+// PreAuthorizedCodeStorage is an optional interface storages implement to
+// support the pre-authorized code grant
+type PreAuthorizedCodeStorage interface {
+	// SavePreAuthorizedCode saves a new or updated pre-authorized code
+	SavePreAuthorizedCode(data *PreAuthorizedCodeData) error
+
+	// LoadPreAuthorizedCode looks up a pre-authorized code. Client
+	// information MUST be loaded together.
+	LoadPreAuthorizedCode(code string) (*PreAuthorizedCodeData, error)
+
+	// RemovePreAuthorizedCode deletes a pre-authorized code
+	RemovePreAuthorizedCode(code string) error
+
+	// ConsumePreAuthorizedCode deletes a pre-authorized code and returns
+	// it, atomically, so a code is redeemed once. Returns ErrNotFound if
+	// not found.
+	ConsumePreAuthorizedCode(code string) (*PreAuthorizedCodeData, error)
+
+	// IncrementTxCodeAttempts increments the TxCodeAttempts of a
+	// pre-authorized code and returns the new value, atomically, so
+	// concurrent attempts are all counted. Returns ErrNotFound if not found.
+	IncrementTxCodeAttempts(code string) (int, error)
+}
+
+// PreAuthorizedCodeData is a pre-authorized code, sent to the wallet in a
+// credential offer after the issuer authorized the user out of band
+type PreAuthorizedCodeData struct {
+	// Code of the credential offer. Generated if blank.
+	Code string
+
+	// Client the code is bound to. Any client may redeem it if nil.
+	Client Client
+
+	// Local subject identifier of the user the credentials are issued to
+	Subject string
+
+	Scope                string
+	AuthorizationDetails AuthorizationDetails
+
+	// Transaction code the user must enter in the wallet, sent over
+	// another channel, like an email. Not required if blank.
+	TxCode string
+
+	// Number of transaction codes entered, see IncrementTxCodeAttempts
+	TxCodeAttempts int
+
+	// Expiration of the code in seconds. Config.PreAuthorizedCodeExpiration
+	// if 0.
+	ExpiresIn int32
+
+	// Date created
+	CreatedAt time.Time
+
+	// Data to be passed to storage. Not used by the library.
+	UserData interface{}
+}
+
+// IsExpiredAt is true if the pre-authorized code expires at time 't'
+func (d *PreAuthorizedCodeData) IsExpiredAt(t time.Time) bool {
+	return d.CreatedAt.Add(time.Duration(d.ExpiresIn) * time.Second).Before(t)
+}
+
+// GenerateTxCode returns a random numeric transaction code
+func GenerateTxCode(length int) (string, error) {
+	return RandomString("0123456789", length)
+}
+
+// preAuthorizedCodeStorage returns the response storage as
+// PreAuthorizedCodeStorage, setting a server error if unsupported
+func preAuthorizedCodeStorage(w *Response) PreAuthorizedCodeStorage {
+	ps, ok := storageAs[PreAuthorizedCodeStorage](w.Storage)
+	if !ok {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = errors.New("storage does not implement PreAuthorizedCodeStorage")
+		return nil
+	}
+	return ps
+}
+
+// IssuePreAuthorizedCode saves a pre-authorized code for a credential
+// offer, generating the code if blank
+func (s *Server) IssuePreAuthorizedCode(data *PreAuthorizedCodeData) error {
+	ps, ok := storageAs[PreAuthorizedCodeStorage](s.Storage)
+	if !ok {
+		return errors.New("storage does not implement PreAuthorizedCodeStorage")
+	}
+	if data.Code == "" {
+		code, err := (TokenFormat{}).Generate()
+		if err != nil {
+			return err
+		}
+		data.Code = code
+	}
+	if data.ExpiresIn == 0 {
+		data.ExpiresIn = s.config().PreAuthorizedCodeExpiration
+	}
+	data.CreatedAt = s.Now()
+	return ps.SavePreAuthorizedCode(data)
+}
+
+// getPreAuthorizedClient returns the client redeeming a pre-authorized
+// code: the authenticated or public client, or the
+// Config.PreAuthorizedAnonymousClient for wallets without a client_id
+func (s *Server) getPreAuthorizedClient(w *Response, r *http.Request) Client {
+	anonymous := s.requestConfig(w).PreAuthorizedAnonymousClient
+	if anonymous == "" || r.Form.Get("client_id") != "" || r.Header.Get("Authorization") != "" {
+		return s.getDeviceClient(w, r)
+	}
+	return getClientWithoutSecret(r.Context(), anonymous, w.Storage, w)
+}
+
+// handlePreAuthorizedCodeRequest handles the wallet redeeming a
+// pre-authorized code
+func (s *Server) handlePreAuthorizedCodeRequest(w *Response, r *http.Request) *AccessRequest {
+	ps := preAuthorizedCodeStorage(w)
+	if ps == nil {
+		return nil
+	}
+
+	ret := &AccessRequest{
+		Type:              PRE_AUTHORIZED_CODE,
+		Code:              r.Form.Get("pre-authorized_code"),
+		GenerateRefresh:   false,
+		Expiration:        s.requestConfig(w).AccessExpirationFor(PRE_AUTHORIZED_CODE),
+		RefreshExpiration: s.requestConfig(w).RefreshExpirationFor(PRE_AUTHORIZED_CODE),
+		IssueCNonce:       true,
+		HttpRequest:       r,
+	}
+	if ret.Code == "" {
+		w.SetError(E_INVALID_REQUEST, "pre-authorized_code is empty")
+		return nil
+	}
+	if ret.Client = s.getPreAuthorizedClient(w, r); ret.Client == nil {
+		return nil
+	}
+
+	pd, err := ps.LoadPreAuthorizedCode(ret.Code)
+	if err != nil && err != ErrNotFound {
+		w.SetError(E_SERVER_ERROR, "failed to load pre-authorized code")
+		w.InternalError = err
+		return nil
+	}
+	if pd == nil || (pd.Client != nil && !CheckClientID(pd.Client, ret.Client.GetID())) {
+		w.SetError(E_INVALID_GRANT, "pre-authorized_code is invalid")
+		return nil
+	}
+	if pd.IsExpiredAt(s.Now()) {
+		ps.RemovePreAuthorizedCode(pd.Code)
+		w.SetError(E_INVALID_GRANT, "pre-authorized_code is expired")
+		return nil
+	}
+
+	if pd.TxCode != "" {
+		// tx_code was named user_pin in the earlier drafts
+		txCode := r.Form.Get("tx_code")
+		if txCode == "" {
+			txCode = r.Form.Get("user_pin")
+		}
+		if txCode == "" {
+			w.SetError(E_INVALID_REQUEST, "tx_code is required")
+			return nil
+		}
+
+		// the attempt is counted before checking it, so concurrent
+		// guesses are limited too
+		attempts, err := ps.IncrementTxCodeAttempts(pd.Code)
+		if err == ErrNotFound {
+			w.SetError(E_INVALID_GRANT, "pre-authorized_code is invalid")
+			return nil
+		} else if err != nil {
+			w.SetError(E_SERVER_ERROR, "")
+			w.InternalError = err
+			return nil
+		}
+		max := s.requestConfig(w).TxCodeMaxAttempts
+		if (max > 0 && attempts > max) || subtle.ConstantTimeCompare([]byte(txCode), []byte(pd.TxCode)) != 1 {
+			if max > 0 && attempts >= max {
+				if err = ps.RemovePreAuthorizedCode(pd.Code); err != nil {
+					w.SetError(E_SERVER_ERROR, "")
+					w.InternalError = err
+					return nil
+				}
+			}
+			w.SetError(E_INVALID_GRANT, "tx_code is invalid")
+			return nil
+		}
+	}
+
+	ret.PreAuthorizedCode = pd
+	ret.Scope = pd.Scope
+	ret.AuthorizationDetails = pd.AuthorizationDetails
+	ret.Subject = pd.Subject
+	ret.UserData = pd.UserData
+	ret.RedirectUri = FirstRedirectURI(s.redirectURIs(w, ret.Client))
+	return ret
+}
+
+// consumePreAuthorizedCode consumes the pre-authorized code of the
+// request before the tokens are issued, so concurrent requests redeem it
+// once. Sets an error on the response and returns false on failure.
+func (s *Server) consumePreAuthorizedCode(w *Response, ar *AccessRequest) bool {
+	ps := preAuthorizedCodeStorage(w)
+	if ps == nil {
+		return false
+	}
+	if _, err := ps.ConsumePreAuthorizedCode(ar.PreAuthorizedCode.Code); err == ErrNotFound {
+		w.SetError(E_INVALID_GRANT, "pre-authorized_code is invalid")
+		return false
+	} else if err != nil {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = err
+		return false
+	}
+	return true
+}
+
+// issueCNonce sets a new c_nonce on the access data, for the key proofs of
+// the credential requests. It is issued by the NonceStore if any.
+func (s *Server) issueCNonce(w *Response, ret *AccessData) error {
+	if s.NonceStore != nil {
+		n, err := s.issueNonce(s.requestConfig(w), NONCE_C_NONCE)
+		if err != nil {
+			return err
+		}
+		ret.CNonce, ret.CNonceExpiresAt = n.Value, n.ExpiresAt
+		return nil
+	}
+
+	nonce, err := (TokenFormat{}).Generate()
+	if err != nil {
+		return err
+	}
+	ret.CNonce = nonce
+	ret.CNonceExpiresAt = s.Now().Add(time.Duration(s.requestConfig(w).CNonceExpiration) * time.Second)
+	return nil
+}
+
+// CheckCNonce returns true if the nonce is the c_nonce issued with the
+// token, and not expired at time 't'. With a server NonceStore, c_nonces of
+// the nonce endpoint are valid too, and are checked with
+// Server.CheckNonce(NONCE_C_NONCE, nonce) instead.
+func (d *AccessData) CheckCNonce(nonce string, t time.Time) bool {
+	return d.CNonce != "" && subtle.ConstantTimeCompare([]byte(nonce), []byte(d.CNonce)) == 1 && t.Before(d.CNonceExpiresAt)
+}
@@ -0,0 +1,159 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// preAuthorizedTestingStorage is a TestingStorage supporting the
+// pre-authorized code grant
+type preAuthorizedTestingStorage struct {
+	*TestingStorage
+	codes map[string]*PreAuthorizedCodeData
+}
+
+func (s *preAuthorizedTestingStorage) Clone() Storage {
+	return s
+}
+
+func (s *preAuthorizedTestingStorage) SavePreAuthorizedCode(data *PreAuthorizedCodeData) error {
+	s.codes[data.Code] = data
+	return nil
+}
+
+func (s *preAuthorizedTestingStorage) LoadPreAuthorizedCode(code string) (*PreAuthorizedCodeData, error) {
+	if d, ok := s.codes[code]; ok {
+		return d, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *preAuthorizedTestingStorage) RemovePreAuthorizedCode(code string) error {
+	delete(s.codes, code)
+	return nil
+}
+
+func (s *preAuthorizedTestingStorage) ConsumePreAuthorizedCode(code string) (*PreAuthorizedCodeData, error) {
+	d, ok := s.codes[code]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delete(s.codes, code)
+	return d, nil
+}
+
+func (s *preAuthorizedTestingStorage) IncrementTxCodeAttempts(code string) (int, error) {
+	d, ok := s.codes[code]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	d.TxCodeAttempts++
+	return d.TxCodeAttempts, nil
+}
+
+func TestPreAuthorizedCode(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{PRE_AUTHORIZED_CODE}
+	sconfig.PreAuthorizedAnonymousClient = "1234"
+	sconfig.TxCodeMaxAttempts = 2
+	storage := &preAuthorizedTestingStorage{TestingStorage: NewTestingStorage(), codes: make(map[string]*PreAuthorizedCodeData)}
+	server := NewServer(sconfig, storage)
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+	now := time.Now()
+	server.Now = func() time.Time { return now }
+
+	details := AuthorizationDetails{{Type: "openid_credential", Extra: map[string]interface{}{"credential_configuration_id": "UniversityDegree"}}}
+	offer := &PreAuthorizedCodeData{Subject: "user-1", AuthorizationDetails: details, TxCode: "493536"}
+	if err := server.IssuePreAuthorizedCode(offer); err != nil {
+		t.Fatal(err)
+	}
+	if offer.Code == "" || offer.ExpiresIn != 300 {
+		t.Fatalf("Unexpected pre-authorized code %+v", offer)
+	}
+
+	redeem := func(code, txCode string) *Response {
+		req, _ := http.NewRequest("POST", "http://localhost:14000/token", nil)
+		req.Form = url.Values{"grant_type": {string(PRE_AUTHORIZED_CODE)}, "pre-authorized_code": {code}, "tx_code": {txCode}}
+		req.PostForm = req.Form
+		resp := server.NewResponse()
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		return resp
+	}
+
+	// wrong tx_code, then the right one
+	if resp := redeem(offer.Code, "000000"); resp.ErrorId != E_INVALID_GRANT {
+		t.Fatalf("Expected invalid_grant, got %v", resp.Output)
+	}
+	resp := redeem(offer.Code, "493536")
+	if resp.IsError {
+		t.Fatalf("Token request should succeed: %v", resp.Output)
+	}
+	nonce, _ := resp.Output["c_nonce"].(string)
+	if resp.Output["access_token"] != "1" || nonce == "" || resp.Output["c_nonce_expires_in"] != int32(300) || resp.Output["refresh_token"] != nil {
+		t.Fatalf("Unexpected token response %v", resp.Output)
+	}
+	ad, err := storage.LoadAccess("1")
+	if err != nil || ad.Subject != "user-1" || len(ad.AuthorizationDetails) != 1 || !ad.CheckCNonce(nonce, now) || ad.CheckCNonce(nonce, now.Add(time.Hour)) {
+		t.Fatalf("Unexpected access data %+v %v", ad, err)
+	}
+	if resp = redeem(offer.Code, "493536"); resp.ErrorId != E_INVALID_GRANT {
+		t.Fatalf("Redeemed code should be removed, got %v", resp.Output)
+	}
+
+	// concurrent requests redeem the code once
+	offer = &PreAuthorizedCodeData{Subject: "user-1"}
+	server.IssuePreAuthorizedCode(offer)
+	var responses [2]*Response
+	var requests [2]*AccessRequest
+	for i := range requests {
+		req, _ := http.NewRequest("POST", "http://localhost:14000/token", nil)
+		req.Form = url.Values{"grant_type": {string(PRE_AUTHORIZED_CODE)}, "pre-authorized_code": {offer.Code}}
+		req.PostForm = req.Form
+		responses[i] = server.NewResponse()
+		if requests[i] = server.HandleAccessRequest(responses[i], req); requests[i] == nil {
+			t.Fatalf("Request %d failed: %v", i, responses[i].Output)
+		}
+	}
+	for i, ar := range requests {
+		ar.Authorized = true
+		server.FinishAccessRequest(responses[i], ar.HttpRequest, ar)
+	}
+	if responses[0].IsError || responses[1].ErrorId != E_INVALID_GRANT {
+		t.Fatalf("Expected the code redeemed once, got %v and %v", responses[0].Output, responses[1].Output)
+	}
+
+	// codes are removed after too many wrong tx_codes
+	offer = &PreAuthorizedCodeData{Subject: "user-1", TxCode: "493536"}
+	server.IssuePreAuthorizedCode(offer)
+	redeem(offer.Code, "000000")
+	redeem(offer.Code, "000001")
+	if len(storage.codes) != 0 {
+		t.Fatal("Code should be removed after too many attempts")
+	}
+
+	// attempts counted by other requests in progress are enforced too
+	offer = &PreAuthorizedCodeData{Subject: "user-1", TxCode: "493536"}
+	server.IssuePreAuthorizedCode(offer)
+	storage.codes[offer.Code].TxCodeAttempts = 2
+	if resp := redeem(offer.Code, "493536"); resp.ErrorId != E_INVALID_GRANT {
+		t.Fatalf("Expected invalid_grant after too many attempts, got %v", resp.Output)
+	}
+
+	// expired codes and codes bound to another client
+	tests := map[string]*PreAuthorizedCodeData{
+		"expired":      {Subject: "user-1", ExpiresIn: 1},
+		"other client": {Subject: "user-1", Client: &DefaultClient{Id: "other"}},
+	}
+	for name, offer := range tests {
+		server.IssuePreAuthorizedCode(offer)
+		now = now.Add(2 * time.Second)
+		if resp := redeem(offer.Code, ""); resp.ErrorId != E_INVALID_GRANT {
+			t.Errorf("%s: expected invalid_grant, got %v", name, resp.Output)
+		}
+	}
+}
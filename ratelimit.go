@@ -0,0 +1,218 @@
+package osin
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimiter tracks failed attempts by key and decides when a key must be
+// blocked
+type RateLimiter interface {
+	// Allow returns false and the time to wait if the key is currently blocked
+	Allow(key string) (bool, time.Duration)
+
+	// Fail records a failed attempt for the key
+	Fail(key string)
+
+	// Reset clears the failed attempts for the key
+	Reset(key string)
+}
+
+// BackoffRateLimiter is an in-memory RateLimiter that blocks a key with an
+// exponential backoff once the number of failed attempts reaches Threshold.
+// The zero value uses the defaults.
+type BackoffRateLimiter struct {
+	// Failed attempts allowed before blocking (default 5)
+	Threshold int
+
+	// Block duration after reaching the threshold, doubled at each new
+	// failure (default 1 second)
+	BaseDelay time.Duration
+
+	// Maximum block duration, acting as a temporary ban (default 15 minutes)
+	MaxDelay time.Duration
+
+	// Failures older than this are forgotten (default 1 hour)
+	Window time.Duration
+
+	// Maximum number of keys tracked. Keys not blocked are dropped first
+	// when it is reached (default 100000). Unlimited if negative.
+	MaxEntries int
+
+	// Current time, time.Now if nil
+	Now func() time.Time
+
+	mu       sync.Mutex
+	attempts map[string]*rateLimitEntry
+	sweptAt  time.Time
+}
+
+type rateLimitEntry struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// isExpiredAt is true if the failures are forgotten and the key no longer
+// blocked at time 't'
+func (e *rateLimitEntry) isExpiredAt(t time.Time, window time.Duration) bool {
+	return t.Sub(e.lastFailure) > window && !t.Before(e.blockedUntil)
+}
+
+// NewBackoffRateLimiter returns a new BackoffRateLimiter with default configuration
+func NewBackoffRateLimiter() *BackoffRateLimiter {
+	return &BackoffRateLimiter{
+		Threshold:  5,
+		BaseDelay:  time.Second,
+		MaxDelay:   15 * time.Minute,
+		Window:     time.Hour,
+		MaxEntries: 100000,
+		Now:        time.Now,
+		attempts:   make(map[string]*rateLimitEntry),
+	}
+}
+
+func (l *BackoffRateLimiter) now() time.Time {
+	if l.Now != nil {
+		return l.Now()
+	}
+	return time.Now()
+}
+
+func (l *BackoffRateLimiter) threshold() int {
+	if l.Threshold > 0 {
+		return l.Threshold
+	}
+	return 5
+}
+
+func (l *BackoffRateLimiter) baseDelay() time.Duration {
+	if l.BaseDelay > 0 {
+		return l.BaseDelay
+	}
+	return time.Second
+}
+
+func (l *BackoffRateLimiter) maxDelay() time.Duration {
+	if l.MaxDelay > 0 {
+		return l.MaxDelay
+	}
+	return 15 * time.Minute
+}
+
+func (l *BackoffRateLimiter) window() time.Duration {
+	if l.Window > 0 {
+		return l.Window
+	}
+	return time.Hour
+}
+
+func (l *BackoffRateLimiter) maxEntries() int {
+	if l.MaxEntries != 0 {
+		return l.MaxEntries
+	}
+	return 100000
+}
+
+// Allow returns false and the time to wait if the key is currently blocked
+func (l *BackoffRateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	e, ok := l.attempts[key]
+	if !ok {
+		return true, 0
+	}
+	if e.isExpiredAt(now, l.window()) {
+		delete(l.attempts, key)
+		return true, 0
+	}
+	if now.Before(e.blockedUntil) {
+		return false, e.blockedUntil.Sub(now)
+	}
+	return true, 0
+}
+
+// Fail records a failed attempt for the key
+func (l *BackoffRateLimiter) Fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if l.attempts == nil {
+		l.attempts = make(map[string]*rateLimitEntry)
+	}
+	if now.Sub(l.sweptAt) >= time.Minute {
+		l.sweep(now, false)
+	}
+	e, ok := l.attempts[key]
+	if !ok || e.isExpiredAt(now, l.window()) {
+		if max := l.maxEntries(); !ok && max > 0 && len(l.attempts) >= max {
+			l.evict(now, max)
+		}
+		e = &rateLimitEntry{}
+		l.attempts[key] = e
+	}
+	e.failures++
+	e.lastFailure = now
+
+	if threshold := l.threshold(); e.failures >= threshold {
+		delay := time.Duration(float64(l.baseDelay()) * math.Pow(2, float64(e.failures-threshold)))
+		if max := l.maxDelay(); delay > max || delay <= 0 {
+			delay = max
+		}
+		e.blockedUntil = now.Add(delay)
+	}
+}
+
+// sweep drops the expired entries, and the entries of the keys not blocked
+// if 'unblocked'
+func (l *BackoffRateLimiter) sweep(now time.Time, unblocked bool) {
+	for k, e := range l.attempts {
+		if e.isExpiredAt(now, l.window()) || (unblocked && !now.Before(e.blockedUntil)) {
+			delete(l.attempts, k)
+		}
+	}
+	l.sweptAt = now
+}
+
+// evict makes room for a new key: the expired entries are dropped, then
+// the keys not blocked, then the key unblocked first
+func (l *BackoffRateLimiter) evict(now time.Time, max int) {
+	l.sweep(now, false)
+	if len(l.attempts) < max {
+		return
+	}
+	l.sweep(now, true)
+	if len(l.attempts) < max {
+		return
+	}
+	var first *rateLimitEntry
+	var firstKey string
+	for k, e := range l.attempts {
+		if first == nil || e.blockedUntil.Before(first.blockedUntil) {
+			first, firstKey = e, k
+		}
+	}
+	delete(l.attempts, firstKey)
+}
+
+// Reset clears the failed attempts for the key
+func (l *BackoffRateLimiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.attempts, key)
+}
+
+// RemoteIP returns the source IP of the request, without the port
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,132 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestBackoffRateLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := NewBackoffRateLimiter()
+	limiter.Threshold = 2
+	limiter.Now = func() time.Time { return now }
+
+	limiter.Fail("key")
+	if ok, _ := limiter.Allow("key"); !ok {
+		t.Fatalf("Key should not be blocked before the threshold")
+	}
+
+	limiter.Fail("key")
+	if ok, wait := limiter.Allow("key"); ok || wait != time.Second {
+		t.Fatalf("Key should be blocked for 1s, got %v %v", ok, wait)
+	}
+
+	limiter.Fail("key")
+	if ok, wait := limiter.Allow("key"); ok || wait != 2*time.Second {
+		t.Fatalf("Key should be blocked for 2s, got %v %v", ok, wait)
+	}
+
+	now = now.Add(3 * time.Second)
+	if ok, _ := limiter.Allow("key"); !ok {
+		t.Fatalf("Key should not be blocked after the delay")
+	}
+
+	limiter.Fail("key")
+	limiter.Reset("key")
+	if ok, _ := limiter.Allow("key"); !ok {
+		t.Fatalf("Key should not be blocked after reset")
+	}
+}
+
+func TestBackoffRateLimiterZeroValue(t *testing.T) {
+	limiter := &BackoffRateLimiter{}
+	if ok, _ := limiter.Allow("key"); !ok {
+		t.Fatalf("Unknown key should not be blocked")
+	}
+
+	for i := 0; i < 4; i++ {
+		limiter.Fail("key")
+	}
+	if ok, _ := limiter.Allow("key"); !ok {
+		t.Fatalf("Key should not be blocked before the default threshold")
+	}
+
+	limiter.Fail("key")
+	if ok, wait := limiter.Allow("key"); ok || wait <= 0 || wait > time.Second {
+		t.Fatalf("Key should be blocked for the default delay, got %v %v", ok, wait)
+	}
+}
+
+func TestBackoffRateLimiterEntries(t *testing.T) {
+	now := time.Now()
+	limiter := NewBackoffRateLimiter()
+	limiter.Threshold = 1
+	limiter.MaxEntries = 2
+	limiter.Now = func() time.Time { return now }
+
+	limiter.Fail("blocked")
+	now = now.Add(time.Millisecond)
+	limiter.Fail("other")
+	now = now.Add(2 * time.Second)
+	limiter.Fail("blocked")
+	limiter.Fail("new")
+	if len(limiter.attempts) != 2 || limiter.attempts["other"] != nil {
+		t.Fatalf("The key not blocked should be dropped, got %v", limiter.attempts)
+	}
+	if ok, _ := limiter.Allow("blocked"); ok {
+		t.Fatalf("Blocked key should stay blocked")
+	}
+
+	// expired entries are swept
+	now = now.Add(2 * time.Hour)
+	limiter.Fail("last")
+	if len(limiter.attempts) != 1 {
+		t.Fatalf("Expired entries should be dropped, got %v", limiter.attempts)
+	}
+}
+
+func TestAccessClientAuthThrottling(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+	limiter := NewBackoffRateLimiter()
+	limiter.Threshold = 2
+	server.ClientAuthLimiter = limiter
+
+	request := func(secret string) *Response {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = "10.0.0.1:5000"
+		req.SetBasicAuth("1234", secret)
+		req.Form = make(url.Values)
+		req.Form.Set("grant_type", string(CLIENT_CREDENTIALS))
+		req.PostForm = make(url.Values)
+
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		return resp
+	}
+
+	for i := 0; i < 2; i++ {
+		if resp := request("wrong"); !resp.IsError || resp.ErrorId != E_INVALID_CLIENT {
+			t.Fatalf("Expected invalid_client, got %v", resp.Output)
+		}
+	}
+
+	// correct secret is refused while blocked
+	resp := request("aabbccdd")
+	if !resp.IsError || resp.ErrorId != E_INVALID_CLIENT {
+		t.Fatalf("Expected throttled request to fail")
+	}
+	if resp.Headers.Get("Retry-After") == "" {
+		t.Fatalf("Expected Retry-After header")
+	}
+}
@@ -0,0 +1,158 @@
+package osin
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// RedirectURIType is the kind of client a redirect uri was registered for
+type RedirectURIType string
+
+const (
+	// Redirect uri of a web application, the default
+	REDIRECT_URI_WEB RedirectURIType = "web"
+
+	// Redirect uri of a native application. Loopback uris accept any port,
+	// as described in RFC 8252 section 7.3.
+	REDIRECT_URI_NATIVE RedirectURIType = "native"
+
+	// Origin receiving the response by postMessage. Must match exactly.
+	REDIRECT_URI_POSTMESSAGE RedirectURIType = "postmessage"
+)
+
+// RedirectURI is a registered redirect uri of a client
+type RedirectURI struct {
+	URI string
+
+	// Type of the uri, REDIRECT_URI_WEB if empty
+	Type RedirectURIType
+}
+
+// ClientRedirectURIList is an optional interface clients can implement to
+// register many redirect uris without joining them with a separator. If it
+// returns uris, the framework will never call GetRedirectURI.
+type ClientRedirectURIList interface {
+	// GetRedirectURIs returns the redirect uris, default first
+	GetRedirectURIs() []RedirectURI
+}
+
+// ClientRedirectURIs returns the redirect uris of the client, from
+// ClientRedirectURIList if implemented, or else splitting GetRedirectURI
+// by separator. The uris of a ComboClient are those of all its clients.
+func ClientRedirectURIs(client Client, separator string) []RedirectURI {
+	if combo, ok := client.(*ComboClient); ok {
+		var ret []RedirectURI
+		for _, c := range combo.Clients {
+			ret = append(ret, ClientRedirectURIs(c, separator)...)
+		}
+		return ret
+	}
+	if l, ok := client.(ClientRedirectURIList); ok {
+		if uris := l.GetRedirectURIs(); len(uris) > 0 {
+			return uris
+		}
+	}
+	return SplitRedirectURIs(client.GetRedirectURI(), separator)
+}
+
+// SplitRedirectURIs splits a redirect uri list separated by separator.
+// If separator is blank, the list is a single uri.
+func SplitRedirectURIs(baseUriList string, separator string) []RedirectURI {
+	slist := []string{baseUriList}
+	if separator != "" {
+		slist = strings.Split(baseUriList, separator)
+	}
+	ret := make([]RedirectURI, len(slist))
+	for i, s := range slist {
+		ret[i] = RedirectURI{URI: s}
+	}
+	return ret
+}
+
+// hasRedirectURI returns true if the client registered any redirect uri
+func hasRedirectURI(client Client) bool {
+	if l, ok := client.(ClientRedirectURIList); ok && len(l.GetRedirectURIs()) > 0 {
+		return true
+	}
+	return client.GetRedirectURI() != ""
+}
+
+// joinRedirectURIs joins the uris for error messages
+func joinRedirectURIs(uris []RedirectURI) string {
+	s := make([]string, len(uris))
+	for i, u := range uris {
+		s[i] = u.URI
+	}
+	return strings.Join(s, " ")
+}
+
+// ValidateRedirectURIs validates that redirectUri is contained in one of
+// the uris, as ValidateUri does, depending on their type
+func ValidateRedirectURIs(uris []RedirectURI, redirectUri string) error {
+	for _, u := range uris {
+		err := validateRedirectURI(u, redirectUri)
+		// validated, return no error
+		if err == nil {
+			return nil
+		}
+
+		// if there was an error that is not a validation error, return it
+		if _, iok := err.(UriValidationError); !iok {
+			return err
+		}
+	}
+	return newUriValidationError("urls don't validate", joinRedirectURIs(uris), redirectUri)
+}
+
+func validateRedirectURI(u RedirectURI, redirectUri string) error {
+	switch u.Type {
+	case REDIRECT_URI_POSTMESSAGE:
+		if u.URI != "" && u.URI == redirectUri {
+			return nil
+		}
+		return newUriValidationError("urls don't match exactly", u.URI, redirectUri)
+	case REDIRECT_URI_NATIVE:
+		// any port of the registered loopback address
+		base, err := url.Parse(u.URI)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(base.Hostname()); ip != nil && ip.IsLoopback() {
+			redirect, err := url.Parse(redirectUri)
+			if err != nil {
+				return err
+			}
+			if redirect.Hostname() == base.Hostname() {
+				redirect.Host = base.Host
+				return ValidateUri(u.URI, redirect.String())
+			}
+		}
+	}
+	return ValidateUri(u.URI, redirectUri)
+}
+
+// ValidateRedirectURIsExact validates that redirectUri is exactly equal to
+// one of the uris, as required for the implicit flow
+func ValidateRedirectURIsExact(uris []RedirectURI, redirectUri string) error {
+	for _, u := range uris {
+		if u.URI != "" && u.URI == redirectUri {
+			return nil
+		}
+	}
+	return newUriValidationError("urls don't match exactly", joinRedirectURIs(uris), redirectUri)
+}
+
+// FirstRedirectURI returns the first uri of the list, the default one
+func FirstRedirectURI(uris []RedirectURI) string {
+	if len(uris) == 0 {
+		return ""
+	}
+	return uris[0].URI
+}
+
+// redirectURIs returns the redirect uris of the client, split with the
+// server RedirectUriSeparator
+func (s *Server) redirectURIs(w *Response, client Client) []RedirectURI {
+	return ClientRedirectURIs(client, s.requestConfig(w).RedirectUriSeparator)
+}
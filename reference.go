@@ -0,0 +1,159 @@
+package osin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// AccessTokenGenReference issues short opaque access tokens referencing the
+// tokens of another generator, like JWTs, kept server-side in
+// AccessData.ReferencedToken. Clients with header size limits get short
+// tokens, while trusted services exchange them for the full tokens with
+// ResolveReferenceToken or the reference token endpoint.
+type AccessTokenGenReference struct {
+	// Generator of the referenced tokens and of the refresh tokens.
+	// Required.
+	Gen AccessTokenGen
+
+	// Format of the reference tokens
+	Format TokenFormat
+}
+
+// GenerateAccessToken generates a reference token, without a request for
+// the referenced token generator
+func (a *AccessTokenGenReference) GenerateAccessToken(data *AccessData, generaterefresh bool) (string, string, error) {
+	return a.GenerateAccessTokenWithRequest(nil, data, generaterefresh)
+}
+
+// GenerateAccessTokenWithRequest generates the referenced token and the
+// refresh token with Gen, and returns a reference to the access token
+func (a *AccessTokenGenReference) GenerateAccessTokenWithRequest(ar *AccessRequest, data *AccessData, generaterefresh bool) (accesstoken string, refreshtoken string, err error) {
+	if a.Gen == nil {
+		return "", "", errors.New("reference access token generator has no Gen")
+	}
+	var referenced string
+	if gen, ok := a.Gen.(AccessTokenGenWithRequest); ok && ar != nil {
+		referenced, refreshtoken, err = gen.GenerateAccessTokenWithRequest(ar, data, generaterefresh)
+	} else {
+		referenced, refreshtoken, err = a.Gen.GenerateAccessToken(data, generaterefresh)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if accesstoken, err = a.Format.Generate(); err != nil {
+		return "", "", err
+	}
+	data.ReferencedToken = referenced
+	return accesstoken, refreshtoken, nil
+}
+
+// ResolveReferenceToken returns the token referenced by an active access
+// token and its access data. ErrNotFound is returned for unknown, expired
+// and revoked tokens, and tokens referencing nothing.
+func (s *Server) ResolveReferenceToken(storage Storage, token string) (string, *AccessData, error) {
+	data, err := s.loadStoredAccess(context.Background(), storage, token)
+	if err != nil {
+		return "", nil, err
+	}
+	if data == nil || data.ReferencedToken == "" || data.IsExpiredAt(s.Now()) {
+		return "", nil, ErrNotFound
+	}
+	revoked, err := s.isRevoked(token, data)
+	if err != nil {
+		return "", nil, err
+	}
+	if revoked {
+		return "", nil, ErrNotFound
+	}
+	return data.ReferencedToken, data, nil
+}
+
+// ReferenceTokenRequest is a request of a trusted service for the token
+// referenced by an access token
+type ReferenceTokenRequest struct {
+	// Reference access token
+	Token string
+
+	// Authenticated client calling the endpoint, allowed by the server
+	// ReferenceTokenCallers
+	Client Client
+
+	// Token referenced and its access data
+	ReferencedToken string
+	AccessData      *AccessData
+
+	// HttpRequest *http.Request for special use
+	HttpRequest *http.Request
+}
+
+// HandleReferenceTokenRequest is the reference token endpoint handler. The
+// calling client must authenticate and be allowed by the server
+// ReferenceTokenCallers.
+func (s *Server) HandleReferenceTokenRequest(w *Response, r *http.Request) *ReferenceTokenRequest {
+	// Only allow POST
+	if r.Method != "POST" {
+		w.SetError(E_INVALID_REQUEST, "")
+		w.InternalError = errors.New("Request must be POST")
+		return nil
+	}
+	if err := s.parseForm(w, r); err != nil {
+		w.SetError(E_INVALID_REQUEST, "")
+		w.InternalError = err
+		return nil
+	}
+	if !s.checkQueryCredentials(w, r) {
+		return nil
+	}
+	w.NoStore = true
+
+	// get client authentication
+	auth := GetClientAuth(w, r, s.requestConfig(w).AllowClientSecretInParams)
+	if auth == nil {
+		return nil
+	}
+
+	ret := &ReferenceTokenRequest{
+		Token:       r.PostForm.Get("token"),
+		HttpRequest: r,
+	}
+	if ret.Token == "" {
+		w.SetError(E_INVALID_REQUEST, "token is required")
+		return nil
+	}
+
+	// must be a valid and trusted client
+	if ret.Client = s.authenticateClient(auth, w, r); ret.Client == nil {
+		return nil
+	}
+	if s.ReferenceTokenCallers == nil || !s.ReferenceTokenCallers(ret.Client) {
+		w.SetError(E_UNAUTHORIZED_CLIENT, "")
+		w.InternalError = errors.New("client may not exchange reference tokens")
+		return nil
+	}
+
+	var err error
+	ret.ReferencedToken, ret.AccessData, err = s.ResolveReferenceToken(w.Storage, ret.Token)
+	if err == ErrNotFound {
+		w.SetError(E_INVALID_REQUEST, "token is not active")
+		return nil
+	}
+	if err != nil {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = err
+		return nil
+	}
+	return ret
+}
+
+// FinishReferenceTokenRequest outputs the referenced token, with its
+// remaining lifetime
+func (s *Server) FinishReferenceTokenRequest(w *Response, r *http.Request, rr *ReferenceTokenRequest) {
+	// don't process if is already an error
+	if w.IsError {
+		return
+	}
+	w.Output["token"] = rr.ReferencedToken
+	w.Output["expires_in"] = int64(rr.AccessData.ExpireAt().Sub(s.Now()) / time.Second)
+}
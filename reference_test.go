@@ -0,0 +1,66 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestReferenceToken(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+	storage := NewTestingStorage()
+	server := NewServer(sconfig, storage)
+	server.AccessTokenGen = &AccessTokenGenReference{Gen: &TestingAccessTokenGen{}}
+
+	resp := server.NewResponse()
+	req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("1234", "aabbccdd")
+	req.Form = url.Values{"grant_type": {string(CLIENT_CREDENTIALS)}}
+	req.PostForm = req.Form
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		ar.Authorized = true
+		server.FinishAccessRequest(resp, req, ar)
+	}
+	if resp.IsError {
+		t.Fatalf("Error in response: %v", resp.Output)
+	}
+	reference, _ := resp.Output["access_token"].(string)
+	if reference == "" || reference == "1" {
+		t.Fatalf("Expected a reference token, got %v", resp.Output)
+	}
+
+	exchange := func() *Response {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/reference", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = url.Values{"token": {reference}}
+		req.PostForm = req.Form
+		if rr := server.HandleReferenceTokenRequest(resp, req); rr != nil {
+			server.FinishReferenceTokenRequest(resp, req, rr)
+		}
+		return resp
+	}
+
+	// untrusted callers are refused
+	if resp = exchange(); resp.ErrorId != E_UNAUTHORIZED_CLIENT {
+		t.Fatalf("Expected unauthorized_client, got %v", resp.Output)
+	}
+
+	server.ReferenceTokenCallers = func(caller Client) bool { return caller.GetID() == "1234" }
+	if resp = exchange(); resp.IsError || resp.Output["token"] != "1" {
+		t.Fatalf("Expected the referenced token, got %v", resp.Output)
+	}
+
+	// revoked references resolve to nothing
+	storage.RemoveAccess(reference)
+	if resp = exchange(); resp.ErrorId != E_INVALID_REQUEST {
+		t.Fatalf("Expected invalid_request, got %v", resp.Output)
+	}
+}
@@ -0,0 +1,69 @@
+package osin
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// RefreshGraceStorage is an optional interface storages can implement to
+// share the refresh tokens rotated within Config.RefreshGracePeriod between
+// servers. Rotations are kept in server memory otherwise.
+type RefreshGraceStorage interface {
+	// SaveRotatedRefresh saves the grant issued when the refresh token was
+	// rotated, until expiresAt
+	SaveRotatedRefresh(token string, data *AccessData, expiresAt time.Time) error
+
+	// LoadRotatedRefresh loads the grant issued when the refresh token was
+	// rotated. Returns ErrNotFound if unknown or expired.
+	// Client information MUST be loaded together.
+	LoadRotatedRefresh(token string) (*AccessData, error)
+}
+
+// refreshGrace holds the in-memory rotated refresh tokens
+type refreshGrace struct {
+	// striped locks serializing the refreshes of the same token
+	locks [64]sync.Mutex
+
+	rotated memoCache
+}
+
+// lockRefresh serializes the refreshes of a token in this server, returning
+// the unlock function
+func (s *Server) lockRefresh(token string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(token))
+	l := &s.refreshGrace.locks[h.Sum32()%uint32(len(s.refreshGrace.locks))]
+	l.Lock()
+	return l.Unlock
+}
+
+// saveRotatedRefresh remembers the grant issued for a rotated refresh token
+// during the grace period
+func (s *Server) saveRotatedRefresh(w *Response, token string, data *AccessData) error {
+	now := s.Now()
+	expiresAt := now.Add(time.Duration(s.requestConfig(w).RefreshGracePeriod) * time.Second)
+	if gs, ok := storageAs[RefreshGraceStorage](w.Storage); ok {
+		return gs.SaveRotatedRefresh(token, data, expiresAt)
+	}
+
+	s.refreshGrace.rotated.put(token, data, now, expiresAt)
+	return nil
+}
+
+// loadRotatedRefresh returns the grant issued for a refresh token rotated
+// within the grace period, or nil
+func (s *Server) loadRotatedRefresh(storage Storage, token string) *AccessData {
+	if gs, ok := storageAs[RefreshGraceStorage](storage); ok {
+		data, err := gs.LoadRotatedRefresh(token)
+		if err != nil {
+			return nil
+		}
+		return data
+	}
+
+	if data, ok := s.refreshGrace.rotated.get(token, s.Now()); ok {
+		return data.(*AccessData)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func refreshGraceForTest(server *Server, refreshToken string) *Response {
+	resp := server.NewResponse()
+	req, _ := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+	req.SetBasicAuth("1234", "aabbccdd")
+	req.Form = url.Values{
+		"grant_type":    {string(REFRESH_TOKEN)},
+		"refresh_token": {refreshToken},
+	}
+	req.PostForm = req.Form
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		ar.Authorized = true
+		server.FinishAccessRequest(resp, req, ar)
+	}
+	return resp
+}
+
+func TestRefreshGracePeriod(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{REFRESH_TOKEN}
+	sconfig.RefreshGracePeriod = 10
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+	now := time.Now()
+	server.Now = func() time.Time { return now }
+
+	first := refreshGraceForTest(server, "r9999")
+	if first.IsError {
+		t.Fatalf("First refresh failed: %v", first.Output)
+	}
+
+	// the rotated token returns the same tokens within the grace period
+	second := refreshGraceForTest(server, "r9999")
+	if second.IsError {
+		t.Fatalf("Refresh within the grace period failed: %v", second.Output)
+	}
+	for _, k := range []string{"access_token", "refresh_token"} {
+		if first.Output[k] != second.Output[k] {
+			t.Fatalf("Expected the same %s, got %v and %v", k, first.Output[k], second.Output[k])
+		}
+	}
+
+	// and fails after it
+	now = now.Add(11 * time.Second)
+	if third := refreshGraceForTest(server, "r9999"); !third.IsError {
+		t.Fatalf("Refresh after the grace period should fail: %v", third.Output)
+	}
+}
+
+func TestRefreshGracePeriodDisabled(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{REFRESH_TOKEN}
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+
+	if first := refreshGraceForTest(server, "r9999"); first.IsError {
+		t.Fatalf("First refresh failed: %v", first.Output)
+	}
+	if second := refreshGraceForTest(server, "r9999"); !second.IsError {
+		t.Fatalf("Reusing a rotated refresh token should fail: %v", second.Output)
+	}
+}
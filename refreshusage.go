@@ -0,0 +1,146 @@
+package osin
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// A refresh token was used for the first time in its family, or from
+	// an IP or user agent not seen before in the family, see
+	// FamilyUsageStore. Data["family_id"] holds the family, Data["subject"]
+	// the user, Data["first_use"], Data["new_ip"] and Data["new_user_agent"]
+	// what is new, Data["ip"] and Data["user_agent"] the request source, and
+	// Data["last_ip"], Data["last_user_agent"] and Data["last_seen_at"] the
+	// previous use, if any.
+	EVENT_REFRESH_TOKEN_NEW_SOURCE EventType = "refresh_token_new_source"
+)
+
+// Maximum number of IPs and user agents remembered per family
+const maxFamilyUsageSeen = 10
+
+// FamilyUsage is the usage of the refresh tokens of a token family
+type FamilyUsage struct {
+	FamilyID string
+
+	// First and last refresh of the family
+	FirstUsedAt time.Time
+	LastSeenAt  time.Time
+
+	// Source of the last refresh
+	LastIP        string
+	LastUserAgent string
+
+	// Sources seen, most recent last, at most maxFamilyUsageSeen each
+	IPs        []string
+	UserAgents []string
+}
+
+// FamilyUsageStore stores the FamilyUsage of token families, to notify the
+// users of refreshes from new sources
+type FamilyUsageStore interface {
+	// LoadFamilyUsage looks up the usage of a family.
+	// Returns ErrNotFound if not found.
+	LoadFamilyUsage(familyID string) (*FamilyUsage, error)
+
+	// SaveFamilyUsage saves the usage of the family
+	SaveFamilyUsage(u *FamilyUsage) error
+}
+
+// MemoryFamilyUsageStore is an in-memory FamilyUsageStore, for single
+// instance deployments and tests
+type MemoryFamilyUsageStore struct {
+	mu    sync.Mutex
+	usage map[string]*FamilyUsage
+}
+
+// NewMemoryFamilyUsageStore creates a new MemoryFamilyUsageStore
+func NewMemoryFamilyUsageStore() *MemoryFamilyUsageStore {
+	return &MemoryFamilyUsageStore{
+		usage: make(map[string]*FamilyUsage),
+	}
+}
+
+func (m *MemoryFamilyUsageStore) LoadFamilyUsage(familyID string) (*FamilyUsage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u, ok := m.usage[familyID]; ok {
+		c := *u
+		return &c, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MemoryFamilyUsageStore) SaveFamilyUsage(u *FamilyUsage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := *u
+	m.usage[u.FamilyID] = &c
+	return nil
+}
+
+// trackRefreshUsage records the source of a refresh in the
+// FamilyUsageStore, emitting EVENT_REFRESH_TOKEN_NEW_SOURCE on the first
+// use of the family and on new sources. Failures don't fail the refresh.
+func (s *Server) trackRefreshUsage(r *http.Request, data *AccessData) {
+	if s.FamilyUsageStore == nil || data.FamilyID == "" || r == nil {
+		return
+	}
+	u, err := s.FamilyUsageStore.LoadFamilyUsage(data.FamilyID)
+	if err != nil && err != ErrNotFound {
+		return
+	}
+
+	now := s.Now()
+	ip, userAgent := RemoteIP(r), r.UserAgent()
+	event := map[string]interface{}{
+		"family_id":  data.FamilyID,
+		"subject":    data.Subject,
+		"ip":         ip,
+		"user_agent": userAgent,
+	}
+	if u == nil {
+		u = &FamilyUsage{FamilyID: data.FamilyID, FirstUsedAt: now}
+		event["first_use"] = true
+	} else {
+		event["last_ip"] = u.LastIP
+		event["last_user_agent"] = u.LastUserAgent
+		event["last_seen_at"] = u.LastSeenAt
+	}
+	var newIP, newUserAgent bool
+	u.IPs, newIP = appendSeen(u.IPs, ip)
+	u.UserAgents, newUserAgent = appendSeen(u.UserAgents, userAgent)
+	u.LastSeenAt, u.LastIP, u.LastUserAgent = now, ip, userAgent
+	if s.FamilyUsageStore.SaveFamilyUsage(u) != nil {
+		return
+	}
+
+	if event["first_use"] == true || newIP || newUserAgent {
+		event["new_ip"] = newIP
+		event["new_user_agent"] = newUserAgent
+		s.emitEvent(&Event{
+			Type:    EVENT_REFRESH_TOKEN_NEW_SOURCE,
+			Client:  data.Client,
+			Request: r,
+			Data:    event,
+		})
+	}
+}
+
+// appendSeen moves v to the end of the list, dropping the oldest entries
+// over the limit. Returns true if v was not in the list.
+func appendSeen(list []string, v string) ([]string, bool) {
+	ret := make([]string, 0, len(list)+1)
+	for _, e := range list {
+		if e != v {
+			ret = append(ret, e)
+		}
+	}
+	isNew := len(ret) == len(list)
+	ret = append(ret, v)
+	if len(ret) > maxFamilyUsageSeen {
+		ret = ret[len(ret)-maxFamilyUsageSeen:]
+	}
+	return ret, isNew
+}
@@ -0,0 +1,65 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestRefreshUsageNotifications(t *testing.T) {
+	storage := NewTestingStorage()
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{REFRESH_TOKEN}
+	sconfig.RetainTokenAfterRefresh = true
+	server := NewServer(sconfig, storage)
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+	server.FamilyUsageStore = NewMemoryFamilyUsageStore()
+	var events []*Event
+	server.AddEventListener(EventListenerFunc(func(e *Event) {
+		if e.Type == EVENT_REFRESH_TOKEN_NEW_SOURCE {
+			events = append(events, e)
+		}
+	}))
+	storage.access["9999"].FamilyID = "family-1"
+
+	refresh := func(ip, userAgent string) {
+		storage.refresh["r9999"] = "9999"
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = ip + ":5000"
+		req.Header.Set("User-Agent", userAgent)
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = url.Values{"grant_type": {string(REFRESH_TOKEN)}, "refresh_token": {"r9999"}}
+		req.PostForm = make(url.Values)
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if resp.IsError {
+			t.Fatalf("Unexpected error: %v", resp.Output)
+		}
+	}
+
+	refresh("10.0.0.1", "phone")
+	if len(events) != 1 || events[0].Data["first_use"] != true || events[0].Data["family_id"] != "family-1" {
+		t.Fatalf("Expected a first use event: %+v", events)
+	}
+
+	// same source, no event
+	refresh("10.0.0.1", "phone")
+	if len(events) != 1 {
+		t.Fatalf("Unexpected event for a known source: %+v", events[1:])
+	}
+
+	refresh("10.0.0.2", "laptop")
+	if len(events) != 2 {
+		t.Fatalf("Expected a new source event: %+v", events)
+	}
+	e := events[1]
+	if e.Data["new_ip"] != true || e.Data["new_user_agent"] != true || e.Data["last_ip"] != "10.0.0.1" || e.Data["first_use"] != nil {
+		t.Errorf("Unexpected new source event data: %v", e.Data)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -25,6 +26,7 @@ type Response struct {
 	StatusCode         int
 	StatusText         string
 	ErrorStatusCode    int
+	ErrorStatusMapper  ErrorStatusMapper
 	URL                string
 	Output             ResponseData
 	Headers            http.Header
@@ -33,8 +35,32 @@ type Response struct {
 	InternalError      error
 	RedirectInFragment bool
 
+	// If true, the response can't be cached - Cache-Control: no-store and
+	// Pragma: no-cache are always output, even if the headers were changed.
+	// Set for all token endpoint responses (RFC 6749 5.1).
+	NoStore bool
+
+	// Localizes the error descriptions in the Languages, if set
+	MessageCatalog MessageCatalog
+
+	// Languages preferred by the user, see RequestLanguages
+	Languages []string
+
+	// Reporting of the InternalError of error responses, applied on output
+	ErrorDetailLevel ErrorDetailLevel
+
+	// If positive, error responses caused by ErrStorageUnavailable are
+	// output as temporarily_unavailable, with a Retry-After header of
+	// this many seconds
+	StorageRetryAfter int32
+
 	// Storage to use in this response - required
 	Storage Storage
+
+	// Server configuration of the request, see Server.requestConfig
+	config *ServerConfig
+
+	errorDetailApplied bool
 }
 
 func NewResponse(storage Storage) *Response {
@@ -47,12 +73,16 @@ func NewResponse(storage Storage) *Response {
 		IsError:         false,
 		Storage:         storage.Clone(),
 	}
-	r.Headers.Add(
-		"Cache-Control",
+	// one allocation for the default values, capped so appends don't
+	// modify the next one
+	v := &[3]string{
 		"no-cache, no-store, max-age=0, must-revalidate",
-	)
-	r.Headers.Add("Pragma", "no-cache")
-	r.Headers.Add("Expires", "Fri, 01 Jan 1990 00:00:00 GMT")
+		"no-cache",
+		"Fri, 01 Jan 1990 00:00:00 GMT",
+	}
+	r.Headers["Cache-Control"] = v[0:1:1]
+	r.Headers["Pragma"] = v[1:2:2]
+	r.Headers["Expires"] = v[2:3:3]
 	return r
 }
 
@@ -71,6 +101,11 @@ func (r *Response) SetErrorState(id string, description string, state string) {
 // SetErrorUri sets an error id, description, state, and uri on the Response
 func (r *Response) SetErrorUri(id string, description string, uri string, state string) {
 	// get default error message
+	if r.MessageCatalog != nil {
+		if m, ok := r.MessageCatalog.Message(r.Languages, id, description); ok {
+			description = m
+		}
+	}
 	if description == "" {
 		description = deferror.Get(id)
 	}
@@ -79,6 +114,9 @@ func (r *Response) SetErrorUri(id string, description string, uri string, state
 	r.IsError = true
 	r.ErrorId = id
 	r.StatusCode = r.ErrorStatusCode
+	if r.ErrorStatusMapper != nil {
+		r.StatusCode = r.ErrorStatusMapper(id)
+	}
 	if r.StatusCode != 200 {
 		r.StatusText = description
 	} else {
@@ -95,6 +133,22 @@ func (r *Response) SetErrorUri(id string, description string, uri string, state
 	}
 }
 
+// SetHeader sets a header on the Response, replacing any existing values
+func (r *Response) SetHeader(name string, value string) {
+	if r.Headers == nil {
+		r.Headers = make(http.Header)
+	}
+	r.Headers.Set(name, value)
+}
+
+// AddHeader adds a header value on the Response
+func (r *Response) AddHeader(name string, value string) {
+	if r.Headers == nil {
+		r.Headers = make(http.Header)
+	}
+	r.Headers.Add(name, value)
+}
+
 // SetRedirect changes the response to redirect to the given url
 func (r *Response) SetRedirect(url string) {
 	// set redirect parameters
@@ -156,3 +210,14 @@ func (r *Response) GetRedirectUrl() (string, error) {
 func (r *Response) Close() {
 	r.Storage.Close()
 }
+
+// applyStorageUnavailable turns the errors caused by an unavailable storage
+// into temporarily_unavailable, if enabled
+func (r *Response) applyStorageUnavailable() {
+	if r.StorageRetryAfter <= 0 || !r.IsError || !errors.Is(r.InternalError, ErrStorageUnavailable) {
+		return
+	}
+	state, _ := r.Output["state"].(string)
+	r.SetErrorState(E_TEMPORARILY_UNAVAILABLE, "", state)
+	r.SetHeader("Retry-After", strconv.Itoa(int(r.StorageRetryAfter)))
+}
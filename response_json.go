@@ -1,17 +1,130 @@
 package osin
 
 import (
+	"bytes"
 	"encoding/json"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 )
 
+// jsonBufferPool holds the buffers responses are encoded in
+var jsonBufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// RenderedResponse is the HTTP response of a Response
+type RenderedResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// ResponseWriter writes rendered responses, to output them with frameworks
+// other than net/http, like fasthttp or serverless platforms
+type ResponseWriter interface {
+	WriteResponse(rr *RenderedResponse) error
+}
+
+// HTTPResponseWriter is the ResponseWriter of an http.ResponseWriter
+type HTTPResponseWriter struct {
+	http.ResponseWriter
+}
+
+// WriteResponse writes the headers, status code and body
+func (w HTTPResponseWriter) WriteResponse(rr *RenderedResponse) error {
+	header := w.Header()
+	for k, v := range rr.Header {
+		for _, e := range v {
+			header.Add(k, e)
+		}
+	}
+	w.WriteHeader(rr.StatusCode)
+	if len(rr.Body) > 0 {
+		_, err := w.Write(rr.Body)
+		return err
+	}
+	return nil
+}
+
+// WriteResponse renders the Response and writes it to the ResponseWriter
+func WriteResponse(rs *Response, w ResponseWriter) error {
+	rr, err := rs.Render()
+	if err != nil {
+		return err
+	}
+	return w.WriteResponse(rr)
+}
+
+// prepareOutput applies the output settings of the response
+func (rs *Response) prepareOutput() {
+	rs.applyStorageUnavailable()
+	rs.applyErrorDetail()
+
+	// Token responses must never be cached
+	if rs.NoStore {
+		if !strings.Contains(rs.Headers.Get("Cache-Control"), "no-store") {
+			rs.SetHeader("Cache-Control", "no-store")
+		}
+		rs.SetHeader("Pragma", "no-cache")
+	}
+}
+
+// Render returns the HTTP response, JSON encoded, as output by OutputJSON
+func (rs *Response) Render() (*RenderedResponse, error) {
+	rs.prepareOutput()
+
+	ret := &RenderedResponse{
+		Header: make(http.Header, len(rs.Headers)+2),
+	}
+	for k, v := range rs.Headers {
+		ret.Header[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+	}
+
+	if rs.Type == REDIRECT {
+		u, err := rs.GetRedirectUrl()
+		if err != nil {
+			return nil, err
+		}
+		ret.Header.Add("Location", u)
+		ret.StatusCode = 302
+		return ret, nil
+	}
+
+	if ret.Header.Get("Content-Type") == "" {
+		ret.Header.Set("Content-Type", "application/json")
+	}
+	ret.StatusCode = rs.StatusCode
+	if rs.StatusCode == http.StatusNotModified {
+		return ret, nil
+	}
+	var buf bytes.Buffer
+	if err := encodeResponseData(&buf, rs.Output); err != nil {
+		return nil, err
+	}
+	ret.Body = buf.Bytes()
+	return ret, nil
+}
+
 // OutputJSON encodes the Response to JSON and writes to the http.ResponseWriter
 func OutputJSON(rs *Response, w http.ResponseWriter, r *http.Request) error {
-	// Add headers
+	rs.prepareOutput()
+
+	// Add headers, sharing the values of the headers the writer doesn't
+	// have yet, capped so appends don't modify them
+	header := w.Header()
 	for i, k := range rs.Headers {
-		for _, v := range k {
-			w.Header().Add(i, v)
+		if _, ok := header[i]; ok || len(k) == 0 {
+			for _, v := range k {
+				header.Add(i, v)
+			}
+			continue
 		}
+		header[http.CanonicalHeaderKey(i)] = k[:len(k):len(k)]
 	}
 
 	if rs.Type == REDIRECT {
@@ -20,20 +133,97 @@ func OutputJSON(rs *Response, w http.ResponseWriter, r *http.Request) error {
 		if err != nil {
 			return err
 		}
-		w.Header().Add("Location", u)
+		header.Add("Location", u)
 		w.WriteHeader(302)
 	} else {
 		// set content type if the response doesn't already have one associated with it
-		if w.Header().Get("Content-Type") == "" {
-			w.Header().Set("Content-Type", "application/json")
+		if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", "application/json")
 		}
 		w.WriteHeader(rs.StatusCode)
+		if rs.StatusCode == http.StatusNotModified {
+			return nil
+		}
 
-		encoder := json.NewEncoder(w)
-		err := encoder.Encode(rs.Output)
-		if err != nil {
+		buf := jsonBufferPool.Get().(*bytes.Buffer)
+		defer jsonBufferPool.Put(buf)
+		buf.Reset()
+		if err := encodeResponseData(buf, rs.Output); err != nil {
+			return err
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeResponseData writes the output as json.Encoder does, with sorted
+// keys and a trailing newline, encoding the strings, integers and booleans
+// of token responses without reflection
+func encodeResponseData(buf *bytes.Buffer, output ResponseData) error {
+	var stack [16]string
+	keys := stack[:0]
+	for k := range output {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	b := buf.AvailableBuffer()
+	b = append(b, '{')
+	for i, k := range keys {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		var err error
+		if b, err = appendJSONString(b, k); err != nil {
+			return err
+		}
+		b = append(b, ':')
+		if b, err = appendJSONValue(b, output[k]); err != nil {
 			return err
 		}
 	}
+	b = append(b, '}', '\n')
+	buf.Write(b)
 	return nil
 }
+
+func appendJSONValue(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case string:
+		return appendJSONString(b, v)
+	case int32:
+		return strconv.AppendInt(b, int64(v), 10), nil
+	case int64:
+		return strconv.AppendInt(b, v, 10), nil
+	case int:
+		return strconv.AppendInt(b, int64(v), 10), nil
+	case bool:
+		return strconv.AppendBool(b, v), nil
+	case nil:
+		return append(b, "null"...), nil
+	}
+	e, err := json.Marshal(v)
+	if err != nil {
+		return b, err
+	}
+	return append(b, e...), nil
+}
+
+// appendJSONString appends the quoted string, escaping it with json.Marshal
+// unless it is plain printable ASCII
+func appendJSONString(b []byte, s string) ([]byte, error) {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c > 0x7e || c == '"' || c == '\\' || c == '<' || c == '>' || c == '&' {
+			e, err := json.Marshal(s)
+			if err != nil {
+				return b, err
+			}
+			return append(b, e...), nil
+		}
+	}
+	b = append(b, '"')
+	b = append(b, s...)
+	return append(b, '"'), nil
+}
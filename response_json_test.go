@@ -1,9 +1,12 @@
 package osin
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 )
 
@@ -113,3 +116,172 @@ func TestRedirectResponseJSON(t *testing.T) {
 		t.Fatalf("Invalid response location url: %s", w.HeaderMap.Get("Location"))
 	}
 }
+
+func TestResponseJSONHeaders(t *testing.T) {
+	req, err := http.NewRequest("POST", "http://localhost:14000/token", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sconfig := NewServerConfig()
+	sconfig.ResponseHeaders = http.Header{
+		"X-Frame-Options": []string{"DENY"},
+	}
+	server := NewServer(sconfig, NewTestingStorage())
+
+	r := server.NewResponse()
+	r.NoStore = true
+	r.SetHeader("X-Trace-Id", "abc")
+	r.SetHeader("Cache-Control", "public, max-age=3600")
+	r.Output["access_token"] = "1234"
+
+	w := httptest.NewRecorder()
+	if err := OutputJSON(r, w, req); err != nil {
+		t.Fatalf("Error outputting json: %s", err)
+	}
+
+	if v := w.HeaderMap.Get("X-Frame-Options"); v != "DENY" {
+		t.Fatalf("Configured header not output: %s", v)
+	}
+	if v := w.HeaderMap.Get("X-Trace-Id"); v != "abc" {
+		t.Fatalf("Custom header not output: %s", v)
+	}
+	if v := w.HeaderMap.Get("Cache-Control"); v != "no-store" {
+		t.Fatalf("Token response must not be cached: %s", v)
+	}
+	if v := w.HeaderMap.Get("Pragma"); v != "no-cache" {
+		t.Fatalf("Token response must have Pragma: no-cache: %s", v)
+	}
+}
+
+func TestErrorDetailLevel(t *testing.T) {
+	tests := map[ErrorDetailLevel]struct {
+		Description string
+		Reference   bool
+	}{
+		ERROR_DETAIL_HIDDEN:    {Description: "storage failed"},
+		ERROR_DETAIL_EXPOSE:    {Description: "storage failed: connection refused"},
+		ERROR_DETAIL_LOG:       {Description: "storage failed"},
+		ERROR_DETAIL_REFERENCE: {Description: "storage failed", Reference: true},
+	}
+
+	for level, test := range tests {
+		sconfig := NewServerConfig()
+		sconfig.ErrorDetailLevel = level
+		server := NewServer(sconfig, NewTestingStorage())
+
+		r := server.NewResponse()
+		r.SetError(E_SERVER_ERROR, "storage failed")
+		r.InternalError = errors.New("connection refused")
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := OutputJSON(r, w, req); err != nil {
+			t.Fatal(err)
+		}
+		output := make(map[string]interface{})
+		if err := json.Unmarshal(w.Body.Bytes(), &output); err != nil {
+			t.Fatal(err)
+		}
+		if d := output["error_description"]; d != test.Description {
+			t.Errorf("%s: unexpected description %v", level, d)
+		}
+		if ref, _ := output["error_reference"].(string); (ref != "") != test.Reference {
+			t.Errorf("%s: unexpected reference %q", level, ref)
+		}
+	}
+
+	sconfig := NewServerConfig()
+	sconfig.ErrorDetailLevel = "verbose"
+	if err := sconfig.Validate(); err == nil {
+		t.Error("Unknown level should be invalid")
+	}
+}
+
+func TestEncodeResponseData(t *testing.T) {
+	outputs := []ResponseData{
+		{},
+		{"access_token": "abc", "expires_in": int32(3600), "refresh_expires_in": int64(-1), "active": true, "n": 7, "x": nil},
+		{"error_description": "a <b> & \"c\" \\ \n é  ", "state": "\xff"},
+		{"authorization_details": []AuthorizationDetail{{Type: "payment", Actions: []string{"pay"}}}, "extra": map[string]interface{}{"k": 1.5}},
+	}
+	for _, output := range outputs {
+		var expected, got bytes.Buffer
+		if err := json.NewEncoder(&expected).Encode(output); err != nil {
+			t.Fatal(err)
+		}
+		if err := encodeResponseData(&got, output); err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != expected.String() {
+			t.Errorf("Expected %s, got %s", expected.String(), got.String())
+		}
+	}
+}
+
+type testResponseWriter struct {
+	written *RenderedResponse
+}
+
+func (w *testResponseWriter) WriteResponse(rr *RenderedResponse) error {
+	w.written = rr
+	return nil
+}
+
+func TestWriteResponse(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responses := map[string]func() *Response{
+		"data": func() *Response {
+			r := NewResponse(NewTestingStorage())
+			r.NoStore = true
+			r.Output["access_token"] = "1234"
+			r.Output["expires_in"] = int32(3600)
+			return r
+		},
+		"error": func() *Response {
+			r := NewResponse(NewTestingStorage())
+			r.ErrorStatusCode = 400
+			r.SetError(E_INVALID_REQUEST, "")
+			return r
+		},
+		"redirect": func() *Response {
+			r := NewResponse(NewTestingStorage())
+			r.SetRedirect("http://localhost:14000/appauth")
+			r.Output["code"] = "1"
+			return r
+		},
+	}
+
+	// the rendered response matches OutputJSON
+	for k, newResponse := range responses {
+		w := &testResponseWriter{}
+		if err := WriteResponse(newResponse(), w); err != nil {
+			t.Fatal(err)
+		}
+		expected := httptest.NewRecorder()
+		if err := OutputJSON(newResponse(), expected, req); err != nil {
+			t.Fatal(err)
+		}
+		if w.written.StatusCode != expected.Code || string(w.written.Body) != expected.Body.String() {
+			t.Errorf("%s: expected %d %s, got %d %s", k, expected.Code, expected.Body.String(), w.written.StatusCode, w.written.Body)
+		}
+		if !reflect.DeepEqual(w.written.Header, expected.Header()) {
+			t.Errorf("%s: expected headers %v, got %v", k, expected.Header(), w.written.Header)
+		}
+
+		// and is written as is by HTTPResponseWriter
+		got := httptest.NewRecorder()
+		if err := WriteResponse(newResponse(), HTTPResponseWriter{got}); err != nil {
+			t.Fatal(err)
+		}
+		if got.Code != expected.Code || got.Body.String() != expected.Body.String() || !reflect.DeepEqual(got.Header(), expected.Header()) {
+			t.Errorf("%s: unexpected HTTPResponseWriter output %d %v %s", k, got.Code, got.Header(), got.Body.String())
+		}
+	}
+}
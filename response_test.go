@@ -1,6 +1,7 @@
 package osin
 
 import (
+	"net/http"
 	"net/url"
 	"strings"
 	"testing"
@@ -72,3 +73,34 @@ func TestGetRedirectUrl(t *testing.T) {
 		}
 	}
 }
+
+func TestErrorStatusMapper(t *testing.T) {
+	server := NewServer(NewServerConfig(), NewTestingStorage())
+	server.ErrorStatusMapper = RFCErrorStatus
+
+	testcases := map[string]int{
+		E_INVALID_REQUEST:         http.StatusBadRequest,
+		E_INVALID_GRANT:           http.StatusBadRequest,
+		E_INVALID_CLIENT:          http.StatusUnauthorized,
+		E_INVALID_TOKEN:           http.StatusUnauthorized,
+		E_ACCESS_DENIED:           http.StatusForbidden,
+		E_INSUFFICIENT_SCOPE:      http.StatusForbidden,
+		E_SERVER_ERROR:            http.StatusInternalServerError,
+		E_TEMPORARILY_UNAVAILABLE: http.StatusServiceUnavailable,
+	}
+
+	for id, status := range testcases {
+		r := server.NewResponse()
+		r.SetError(id, "")
+		if r.StatusCode != status {
+			t.Errorf("%s: expected status %d, got %d", id, status, r.StatusCode)
+		}
+	}
+
+	// without a mapper, the configured status is used
+	r := NewServer(NewServerConfig(), NewTestingStorage()).NewResponse()
+	r.SetError(E_SERVER_ERROR, "")
+	if r.StatusCode != 200 {
+		t.Errorf("Expected configured status 200, got %d", r.StatusCode)
+	}
+}
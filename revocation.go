@@ -0,0 +1,158 @@
+package osin
+
+import (
+	"errors"
+	"net/http"
+)
+
+// RevocationChecker tells whether a token was revoked before its expiration,
+// for deployments where tokens outlive their storage entries, like stateless
+// JWT access tokens checked against a shared denylist
+type RevocationChecker interface {
+	// IsRevoked returns true if the access or refresh token was revoked.
+	// data is the access data loaded for the token.
+	IsRevoked(token string, data *AccessData) (bool, error)
+}
+
+// RevocationCheckerFunc allows a function to be used as a RevocationChecker
+type RevocationCheckerFunc func(token string, data *AccessData) (bool, error)
+
+// IsRevoked calls f(token, data)
+func (f RevocationCheckerFunc) IsRevoked(token string, data *AccessData) (bool, error) {
+	return f(token, data)
+}
+
+// isRevoked consults the server RevocationChecker, if any, and rejects the
+// tokens issued with a stale credential version
+func (s *Server) isRevoked(token string, data *AccessData) (bool, error) {
+	if stale, err := s.isCredentialStale(data); err != nil || stale {
+		return stale, err
+	}
+	if s.RevocationChecker == nil {
+		return false, nil
+	}
+	return s.RevocationChecker.IsRevoked(token, data)
+}
+
+// RevocationRequest is a token revocation request, as described in RFC 7009
+type RevocationRequest struct {
+	// Token to revoke and the optional token_type_hint
+	Token         string
+	TokenTypeHint string
+
+	// Authenticated client calling the endpoint
+	Client Client
+
+	// Access data of the token. Nil if the token is unknown, which is not
+	// an error.
+	AccessData *AccessData
+
+	// True if the token is a refresh token
+	IsRefresh bool
+
+	// HttpRequest *http.Request for special use
+	HttpRequest *http.Request
+}
+
+// HandleRevocationRequest is the token revocation endpoint handler. The
+// calling client must authenticate, and may only revoke its own tokens,
+// unless allowed by the TokenAccessPolicy.
+func (s *Server) HandleRevocationRequest(w *Response, r *http.Request) *RevocationRequest {
+	// Only allow POST
+	if r.Method != "POST" {
+		w.SetError(E_INVALID_REQUEST, "")
+		w.InternalError = errors.New("Request must be POST")
+		return nil
+	}
+	if err := s.parseForm(w, r); err != nil {
+		w.SetError(E_INVALID_REQUEST, "")
+		w.InternalError = err
+		return nil
+	}
+	if !s.checkQueryCredentials(w, r) {
+		return nil
+	}
+
+	// get client authentication
+	auth := GetClientAuth(w, r, s.requestConfig(w).AllowClientSecretInParams)
+	if auth == nil {
+		return nil
+	}
+
+	ret := &RevocationRequest{
+		Token:         r.PostForm.Get("token"),
+		TokenTypeHint: r.PostForm.Get("token_type_hint"),
+		HttpRequest:   r,
+	}
+	if ret.Token == "" {
+		w.SetError(E_INVALID_REQUEST, "token is required")
+		return nil
+	}
+
+	// must have a valid client
+	if ret.Client = s.authenticateClient(auth, w, r); ret.Client == nil {
+		return nil
+	}
+
+	// look up the token, following the hint first
+	lookups := []bool{false, true}
+	if ret.TokenTypeHint == "refresh_token" {
+		lookups = []bool{true, false}
+	}
+	for _, refresh := range lookups {
+		var data *AccessData
+		var err error
+		if refresh {
+			data, err = s.loadRefresh(r.Context(), w.Storage, ret.Token)
+		} else {
+			data, err = s.loadStoredAccess(r.Context(), w.Storage, ret.Token)
+		}
+		if err != nil && err != ErrNotFound {
+			w.SetError(E_SERVER_ERROR, "")
+			w.InternalError = err
+			return nil
+		}
+		if data != nil && data.Client != nil {
+			ret.AccessData, ret.IsRefresh = data, refresh
+			break
+		}
+	}
+
+	// clients may only revoke their own tokens, unless the policy allows
+	if ret.AccessData != nil && !s.allowTokenAccess(w, ENDPOINT_REVOCATION, ret.Client, ret.AccessData) {
+		w.SetError(E_UNAUTHORIZED_CLIENT, "")
+		w.InternalError = errors.New("client may not revoke the token")
+		return nil
+	}
+	return ret
+}
+
+// FinishRevocationRequest revokes the grant of the token, both its access
+// and refresh tokens, emitting EVENT_TOKEN_REVOKED. Unknown tokens succeed.
+func (s *Server) FinishRevocationRequest(w *Response, r *http.Request, rr *RevocationRequest) {
+	// don't process if is already an error
+	if w.IsError || rr.AccessData == nil {
+		return
+	}
+	data := rr.AccessData
+
+	if err := storageRemoveAccess(r.Context(), w.Storage, data.AccessToken); err != nil && err != ErrNotFound {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = err
+		return
+	}
+	if data.RefreshToken != "" {
+		if err := storageRemoveRefresh(r.Context(), w.Storage, data.RefreshToken); err != nil && err != ErrNotFound {
+			w.SetError(E_SERVER_ERROR, "")
+			w.InternalError = err
+			return
+		}
+	}
+
+	s.emitEvent(&Event{
+		Type:    EVENT_TOKEN_REVOKED,
+		Client:  rr.Client,
+		Request: r,
+		Data:    map[string]interface{}{"refresh": rr.IsRefresh},
+	})
+}
@@ -0,0 +1,115 @@
+package osin
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestRevocationChecker(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{REFRESH_TOKEN}
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+	denylist := map[string]bool{"9999": true, "r9999": true}
+	server.RevocationChecker = RevocationCheckerFunc(func(token string, data *AccessData) (bool, error) {
+		return denylist[token], nil
+	})
+
+	// bearer validation
+	resp := server.NewResponse()
+	req, err := http.NewRequest("GET", "http://localhost:14000/info", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer 9999")
+	if ir := server.HandleInfoRequest(resp, req); ir != nil {
+		t.Fatalf("Revoked access token should be refused")
+	}
+	if resp.Headers.Get("WWW-Authenticate") == "" {
+		t.Fatalf("Revoked access token should get a challenge")
+	}
+
+	// refresh
+	resp = server.NewResponse()
+	req, err = http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("1234", "aabbccdd")
+	req.Form = make(url.Values)
+	req.Form.Set("grant_type", string(REFRESH_TOKEN))
+	req.Form.Set("refresh_token", "r9999")
+	req.PostForm = req.Form
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		t.Fatalf("Revoked refresh token should be refused")
+	}
+	if resp.ErrorId != E_INVALID_GRANT {
+		t.Fatalf("Unexpected error: %s", resp.ErrorId)
+	}
+
+	// batch validation
+	delete(denylist, "9999")
+	result, err := server.ValidateTokens(context.Background(), []string{"9999"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result[0] == nil {
+		t.Fatalf("Token removed from the denylist should be valid")
+	}
+	denylist["9999"] = true
+	if result, _ = server.ValidateTokens(context.Background(), []string{"9999"}); result[0] != nil {
+		t.Fatalf("Revoked token should be invalid")
+	}
+}
+
+func TestRevocationRequest(t *testing.T) {
+	tests := map[string]struct {
+		ClientId, Secret string
+		Token            string
+		ErrorId          string
+		Revoked          bool
+	}{
+		"access":        {ClientId: "1234", Secret: "aabbccdd", Token: "9999", Revoked: true},
+		"refresh":       {ClientId: "1234", Secret: "aabbccdd", Token: "r9999", Revoked: true},
+		"unknown":       {ClientId: "1234", Secret: "aabbccdd", Token: "nope"},
+		"other client":  {ClientId: "other", Secret: "secret", Token: "9999", ErrorId: E_UNAUTHORIZED_CLIENT},
+		"bad client":    {ClientId: "1234", Secret: "wrong", Token: "9999", ErrorId: E_INVALID_CLIENT},
+		"missing token": {ClientId: "1234", Secret: "aabbccdd", ErrorId: E_INVALID_REQUEST},
+	}
+
+	for k, test := range tests {
+		storage := NewTestingStorage()
+		storage.SetClient("other", &DefaultClient{Id: "other", Secret: "secret", RedirectUri: "http://localhost:14000/appauth"})
+		server := NewServer(NewServerConfig(), storage)
+		var events []*Event
+		server.AddEventListener(EventListenerFunc(func(e *Event) { events = append(events, e) }))
+
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/revoke", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth(test.ClientId, test.Secret)
+		req.Form = url.Values{"token": {test.Token}}
+		req.PostForm = req.Form
+		if rr := server.HandleRevocationRequest(resp, req); rr != nil {
+			server.FinishRevocationRequest(resp, req, rr)
+		}
+
+		if resp.ErrorId != test.ErrorId {
+			t.Errorf("%s: expected error %q, got %q", k, test.ErrorId, resp.ErrorId)
+			continue
+		}
+		if _, err := storage.LoadAccess("9999"); (err != nil) != test.Revoked {
+			t.Errorf("%s: expected access token revoked %v, got %v", k, test.Revoked, err != nil)
+		}
+		if _, err := storage.LoadRefresh("r9999"); (err != nil) != test.Revoked {
+			t.Errorf("%s: expected refresh token revoked %v, got %v", k, test.Revoked, err != nil)
+		}
+		if test.Revoked && (len(events) == 0 || events[len(events)-1].Type != EVENT_TOKEN_REVOKED) {
+			t.Errorf("%s: expected a revocation event", k)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package osin
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RiskDecision is the outcome of a risk evaluation
+type RiskDecision int
+
+const (
+	// Issue the tokens
+	RISK_ALLOW RiskDecision = iota
+
+	// Require a second factor first, with an MFA challenge
+	RISK_STEP_UP
+
+	// Refuse the tokens with access_denied
+	RISK_DENY
+)
+
+// GeoLocation is the location of an IP address
+type GeoLocation struct {
+	Country string
+	Region  string
+	City    string
+
+	Latitude  float64
+	Longitude float64
+}
+
+// GeoLocator locates an IP address, usually with a GeoIP database
+type GeoLocator func(ip string) (*GeoLocation, error)
+
+// RiskContext is the metadata of a token request given to the RiskEvaluator
+type RiskContext struct {
+	Request *AccessRequest
+
+	// Source IP and user agent of the request
+	IP        string
+	UserAgent string
+
+	// Location of the IP, if the server has a GeoLocator
+	Geo *GeoLocation
+
+	// Token requests in the current velocity window, including this one,
+	// by the client, the resource owner (Subject or Username) and the IP.
+	// SubjectRequests is 0 for requests without resource owner.
+	ClientRequests  int
+	SubjectRequests int
+	IPRequests      int
+}
+
+// RiskAssessment is the result of a risk evaluation
+type RiskAssessment struct {
+	Decision RiskDecision
+
+	// Risk level stored in AccessData.RiskLevel, like "low" or "high"
+	Level string
+}
+
+// RiskEvaluator assesses the risk of token requests before the tokens are
+// issued, for adaptive authentication
+type RiskEvaluator interface {
+	EvaluateRisk(ctx context.Context, rc *RiskContext) (*RiskAssessment, error)
+}
+
+// RiskEvaluatorFunc allows a function to be used as a RiskEvaluator
+type RiskEvaluatorFunc func(ctx context.Context, rc *RiskContext) (*RiskAssessment, error)
+
+// EvaluateRisk calls f(ctx, rc)
+func (f RiskEvaluatorFunc) EvaluateRisk(ctx context.Context, rc *RiskContext) (*RiskAssessment, error) {
+	return f(ctx, rc)
+}
+
+// velocityCounter counts requests by key in fixed windows
+type velocityCounter struct {
+	mu      sync.Mutex
+	windows map[string]velocityWindow
+}
+
+type velocityWindow struct {
+	start time.Time
+	count int
+}
+
+// add counts a request of the key at 'now', returning the count of the
+// current window
+func (v *velocityCounter) add(key string, now time.Time, window time.Duration) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.windows == nil {
+		v.windows = make(map[string]velocityWindow)
+	}
+	w, ok := v.windows[key]
+	if !ok || !now.Before(w.start.Add(window)) {
+		// new window, dropping the expired ones once in a while
+		if len(v.windows) > 10000 {
+			for k, e := range v.windows {
+				if !now.Before(e.start.Add(window)) {
+					delete(v.windows, k)
+				}
+			}
+		}
+		w = velocityWindow{start: now}
+	}
+	w.count++
+	v.windows[key] = w
+	return w.count
+}
+
+// evaluateRisk consults the server RiskEvaluator, if any, for an authorized
+// request. Denied requests are unauthorized, and step ups require MFA.
+// Returns false if the evaluation failed, with the error set on the response.
+func (s *Server) evaluateRisk(w *Response, ar *AccessRequest) bool {
+	if s.RiskEvaluator == nil || !ar.Authorized {
+		return true
+	}
+
+	now := s.Now()
+	window := time.Duration(s.requestConfig(w).RiskVelocityWindow) * time.Second
+	rc := &RiskContext{
+		Request:        ar,
+		ClientRequests: s.velocity.add("client:"+ar.Client.GetID(), now, window),
+	}
+	if r := ar.HttpRequest; r != nil {
+		rc.IP = RemoteIP(r)
+		rc.UserAgent = r.UserAgent()
+		rc.IPRequests = s.velocity.add("ip:"+rc.IP, now, window)
+	}
+	owner := ar.Subject
+	if owner == "" {
+		owner = ar.Username
+	}
+	if owner != "" {
+		rc.SubjectRequests = s.velocity.add("subject:"+owner, now, window)
+	}
+	if s.GeoLocator != nil && rc.IP != "" {
+		geo, err := s.GeoLocator(rc.IP)
+		if err != nil {
+			w.SetError(E_SERVER_ERROR, "")
+			w.InternalError = err
+			return false
+		}
+		rc.Geo = geo
+	}
+
+	ra, err := s.RiskEvaluator.EvaluateRisk(ar.Context(), rc)
+	if err != nil {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = err
+		return false
+	}
+	if ra == nil {
+		return true
+	}
+	ar.RiskLevel = ra.Level
+	switch ra.Decision {
+	case RISK_DENY:
+		ar.Authorized = false
+	case RISK_STEP_UP:
+		// completing a challenge is the step up
+		if ar.Type != MFA_OTP {
+			ar.RequireMFA = true
+		}
+	}
+	return true
+}
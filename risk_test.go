@@ -0,0 +1,75 @@
+package osin
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestRiskEvaluator(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{PASSWORD}
+	storage := &mfaTestingStorage{TestingStorage: NewTestingStorage(), challenges: make(map[string]*MFAChallenge)}
+	server := NewServer(sconfig, storage)
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+	server.GeoLocator = func(ip string) (*GeoLocation, error) {
+		return &GeoLocation{Country: "NZ"}, nil
+	}
+	var last *RiskContext
+	server.RiskEvaluator = RiskEvaluatorFunc(func(ctx context.Context, rc *RiskContext) (*RiskAssessment, error) {
+		last = rc
+		switch {
+		case rc.Request.Username == "stepup":
+			return &RiskAssessment{Decision: RISK_STEP_UP, Level: "medium"}, nil
+		case rc.SubjectRequests > 2:
+			return &RiskAssessment{Decision: RISK_DENY, Level: "high"}, nil
+		}
+		return &RiskAssessment{Level: "low"}, nil
+	})
+
+	request := func(username string) *Response {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("User-Agent", "testing")
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = url.Values{"grant_type": {string(PASSWORD)}, "username": {username}, "password": {"testing"}}
+		req.PostForm = req.Form
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		return resp
+	}
+
+	resp := request("user")
+	if resp.IsError {
+		t.Fatalf("Error in response: %v", resp.Output)
+	}
+	if last.IP != "10.0.0.1" || last.UserAgent != "testing" || last.Geo == nil || last.Geo.Country != "NZ" {
+		t.Fatalf("Unexpected risk context %+v", last)
+	}
+	data, err := storage.LoadAccess(resp.Output["access_token"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.RiskLevel != "low" {
+		t.Fatalf("Expected risk level low, got %q", data.RiskLevel)
+	}
+
+	if resp = request("stepup"); resp.ErrorId != E_MFA_REQUIRED {
+		t.Fatalf("Expected mfa_required, got %v", resp.Output)
+	}
+
+	request("user")
+	if resp = request("user"); resp.ErrorId != E_ACCESS_DENIED {
+		t.Fatalf("Expected access_denied on the third request, got %v", resp.Output)
+	}
+	if last.ClientRequests != 4 || last.IPRequests != 4 || last.SubjectRequests != 3 {
+		t.Fatalf("Unexpected velocity counters %+v", last)
+	}
+}
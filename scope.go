@@ -0,0 +1,204 @@
+package osin
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ErrScopeNotAllowed is returned by ScopeManager when a requested scope is
+// not granted to the client
+var ErrScopeNotAllowed = errors.New("scope not allowed for the client")
+
+// ScopeManager resolves the scope granted to access requests
+type ScopeManager interface {
+	// ResolveScope returns the scope granted for the request, from the
+	// requested ar.Scope. Returns ErrScopeNotAllowed to refuse it.
+	ResolveScope(ar *AccessRequest) (string, error)
+}
+
+// ClientMetadata is an optional interface clients can implement to expose
+// their metadata, like a tenant, to scope templates
+type ClientMetadata interface {
+	GetMetadata() map[string]interface{}
+}
+
+// ClientScopeTemplates is an optional interface clients can implement to
+// be granted scopes by template, resolved by TemplateScopeManager
+type ClientScopeTemplates interface {
+	// GetScopeTemplates returns the scopes the client may be granted, like
+	// "tenant:{client.tenant}:read". {client.id} is the client id, and
+	// {client.<name>} a ClientMetadata entry. A "*" matches any run of
+	// characters but "/".
+	GetScopeTemplates() []string
+}
+
+// ResolveScopeTemplate substitutes the client placeholders of a scope
+// template. Returns false if a placeholder has no value.
+func ResolveScopeTemplate(template string, client Client) (string, bool) {
+	var metadata map[string]interface{}
+	if cm, ok := client.(ClientMetadata); ok {
+		metadata = cm.GetMetadata()
+	}
+
+	var b strings.Builder
+	for {
+		start := strings.Index(template, "{")
+		if start < 0 {
+			b.WriteString(template)
+			return b.String(), true
+		}
+		end := strings.Index(template[start:], "}")
+		if end < 0 {
+			return "", false
+		}
+		name := template[start+1 : start+end]
+		var value string
+		switch {
+		case name == "client.id":
+			value = client.GetID()
+		case strings.HasPrefix(name, "client."):
+			v, ok := metadata[strings.TrimPrefix(name, "client.")]
+			if !ok || v == nil {
+				return "", false
+			}
+			value = fmt.Sprint(v)
+		default:
+			return "", false
+		}
+		if value == "" || strings.ContainsAny(value, " ,*{}") {
+			return "", false
+		}
+		b.WriteString(template[:start])
+		b.WriteString(value)
+		template = template[start+end+1:]
+	}
+}
+
+// TemplateScopeManager grants the scopes of the client templates, see
+// ClientScopeTemplates. Requests without scope get all the templates
+// without wildcards. Clients without templates get the requested scope.
+type TemplateScopeManager struct{}
+
+// ResolveScope satisfies the ScopeManager interface
+func (m *TemplateScopeManager) ResolveScope(ar *AccessRequest) (string, error) {
+	ct, ok := ar.Client.(ClientScopeTemplates)
+	if !ok {
+		return ar.Scope, nil
+	}
+	var patterns []string
+	for _, t := range ct.GetScopeTemplates() {
+		if p, ok := ResolveScopeTemplate(t, ar.Client); ok {
+			patterns = append(patterns, p)
+		}
+	}
+
+	requested := strings.FieldsFunc(ar.Scope, func(r rune) bool { return r == ' ' || r == ',' })
+	if len(requested) == 0 {
+		var granted []string
+		for _, p := range patterns {
+			if !strings.Contains(p, "*") {
+				granted = append(granted, p)
+			}
+		}
+		return strings.Join(granted, " "), nil
+	}
+
+	for _, scope := range requested {
+		if !matchScope(patterns, scope) {
+			return "", ErrScopeNotAllowed
+		}
+	}
+	return strings.Join(requested, " "), nil
+}
+
+// matchScope returns true if the scope matches one of the patterns
+func matchScope(patterns []string, scope string) bool {
+	for _, p := range patterns {
+		if ok, err := path.Match(p, scope); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveScope sets the scope granted to the request by the server
+// ScopeManager, if any. Sets an error on the response and returns false
+// if refused.
+func (s *Server) resolveScope(w *Response, ar *AccessRequest) bool {
+	if s.ScopeManager == nil {
+		return true
+	}
+	scope, err := s.ScopeManager.ResolveScope(ar)
+	if err == ErrScopeNotAllowed {
+		w.SetError(E_INVALID_SCOPE, "")
+		w.InternalError = err
+		return false
+	}
+	if err != nil {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = err
+		return false
+	}
+	ar.Scope = scope
+	return true
+}
+
+// ClientScopeLimits is an optional interface clients can implement to have
+// a default scope and limit the scopes they can request
+type ClientScopeLimits interface {
+	// GetDefaultScope returns the scope granted when none is requested
+	GetDefaultScope() string
+
+	// GetMaxScope returns the scopes the client may request. Empty if
+	// unlimited.
+	GetMaxScope() string
+}
+
+// intersectScopes returns the scopes of requested also in granted
+func intersectScopes(granted, requested string) string {
+	var ret []string
+	for _, sc := range strings.FieldsFunc(requested, func(r rune) bool { return r == ' ' || r == ',' }) {
+		if HasScope(granted, sc) {
+			ret = append(ret, sc)
+		}
+	}
+	return strings.Join(ret, " ")
+}
+
+// limitScope applies the default and maximum scope of the client to the
+// requested scope. Excess scopes are trimmed with Config.TrimExcessScope,
+// otherwise sets an invalid_scope error on the response and returns false.
+func (s *Server) limitScope(w *Response, client Client, scope string, state string) (string, bool) {
+	cl, ok := client.(ClientScopeLimits)
+	if !ok {
+		return scope, true
+	}
+	if strings.TrimSpace(scope) == "" {
+		scope = cl.GetDefaultScope()
+	}
+	max := cl.GetMaxScope()
+	if max == "" {
+		return scope, true
+	}
+
+	var granted []string
+	trimmed := false
+	for _, sc := range strings.FieldsFunc(scope, func(r rune) bool { return r == ' ' || r == ',' }) {
+		if HasScope(max, sc) {
+			granted = append(granted, sc)
+		} else {
+			trimmed = true
+		}
+	}
+	if trimmed && !s.requestConfig(w).TrimExcessScope {
+		w.SetErrorState(E_INVALID_SCOPE, "", state)
+		w.InternalError = ErrScopeNotAllowed
+		return "", false
+	}
+	if !trimmed {
+		return scope, true
+	}
+	return strings.Join(granted, " "), true
+}
@@ -0,0 +1,228 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+type scopeTemplateClient struct {
+	DefaultClient
+	Templates []string
+	Metadata  map[string]interface{}
+}
+
+func (c *scopeTemplateClient) GetScopeTemplates() []string {
+	return c.Templates
+}
+
+func (c *scopeTemplateClient) GetMetadata() map[string]interface{} {
+	return c.Metadata
+}
+
+func TestTemplateScopeManager(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+	storage := NewTestingStorage()
+	storage.SetClient("machine", &scopeTemplateClient{
+		DefaultClient: DefaultClient{Id: "machine", Secret: "secret", RedirectUri: "http://localhost:14000/appauth"},
+		Templates:     []string{"tenant:{client.tenant}:read", "client:{client.id}", "reports:*", "region:{client.region}"},
+		Metadata:      map[string]interface{}{"tenant": "acme"},
+	})
+	server := NewServer(sconfig, storage)
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+	server.ScopeManager = &TemplateScopeManager{}
+
+	tests := map[string]struct {
+		ClientId, Secret string
+		Scope            string
+		Expected         string
+		ErrorId          string
+	}{
+		"default":           {ClientId: "machine", Secret: "secret", Expected: "tenant:acme:read client:machine"},
+		"subset":            {ClientId: "machine", Secret: "secret", Scope: "tenant:acme:read", Expected: "tenant:acme:read"},
+		"wildcard":          {ClientId: "machine", Secret: "secret", Scope: "reports:daily", Expected: "reports:daily"},
+		"other tenant":      {ClientId: "machine", Secret: "secret", Scope: "tenant:other:read", ErrorId: E_INVALID_SCOPE},
+		"missing metadata":  {ClientId: "machine", Secret: "secret", Scope: "region:", ErrorId: E_INVALID_SCOPE},
+		"without templates": {ClientId: "1234", Secret: "aabbccdd", Scope: "anything", Expected: "anything"},
+	}
+
+	for k, test := range tests {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth(test.ClientId, test.Secret)
+		req.Form = url.Values{"grant_type": {string(CLIENT_CREDENTIALS)}, "scope": {test.Scope}}
+		req.PostForm = req.Form
+
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if resp.ErrorId != test.ErrorId {
+			t.Errorf("%s: expected error %q, got %q", k, test.ErrorId, resp.ErrorId)
+			continue
+		}
+		if test.ErrorId == "" && resp.Output["scope"] != test.Expected {
+			t.Errorf("%s: expected scope %q, got %v", k, test.Expected, resp.Output["scope"])
+		}
+	}
+}
+
+type scopeLimitsClient struct {
+	DefaultClient
+	DefaultScope, MaxScope string
+}
+
+func (c *scopeLimitsClient) GetDefaultScope() string {
+	return c.DefaultScope
+}
+
+func (c *scopeLimitsClient) GetMaxScope() string {
+	return c.MaxScope
+}
+
+func TestClientScopeLimits(t *testing.T) {
+	tests := map[string]struct {
+		Scope    string
+		Trim     bool
+		Expected string
+		ErrorId  string
+	}{
+		"default":       {Expected: "read"},
+		"within":        {Scope: "read write", Expected: "read write"},
+		"exceeding":     {Scope: "read admin", ErrorId: E_INVALID_SCOPE},
+		"trimmed":       {Scope: "read admin", Trim: true, Expected: "read"},
+		"comma within":  {Scope: "read,write", Expected: "read,write"},
+		"comma trimmed": {Scope: "admin,write", Trim: true, Expected: "write"},
+	}
+
+	for k, test := range tests {
+		sconfig := NewServerConfig()
+		sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+		sconfig.TrimExcessScope = test.Trim
+		storage := NewTestingStorage()
+		storage.SetClient("limited", &scopeLimitsClient{
+			DefaultClient: DefaultClient{Id: "limited", Secret: "secret", RedirectUri: "http://localhost:14000/appauth"},
+			DefaultScope:  "read",
+			MaxScope:      "read write",
+		})
+		server := NewServer(sconfig, storage)
+		server.AccessTokenGen = &TestingAccessTokenGen{}
+
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("limited", "secret")
+		req.Form = url.Values{"grant_type": {string(CLIENT_CREDENTIALS)}, "scope": {test.Scope}}
+		req.PostForm = req.Form
+
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if resp.ErrorId != test.ErrorId {
+			t.Errorf("%s: expected error %q, got %q", k, test.ErrorId, resp.ErrorId)
+			continue
+		}
+		if test.ErrorId == "" && resp.Output["scope"] != test.Expected {
+			t.Errorf("%s: expected scope %q, got %v", k, test.Expected, resp.Output["scope"])
+		}
+	}
+}
+
+func TestGrantedScopes(t *testing.T) {
+	storage := NewTestingStorage()
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{AUTHORIZATION_CODE, REFRESH_TOKEN}
+	server := NewServer(sconfig, storage)
+	server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+
+	// the user grants only part of the requested scope
+	resp := server.NewResponse()
+	req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Form = make(url.Values)
+	req.Form.Set("response_type", string(CODE))
+	req.Form.Set("client_id", "1234")
+	req.Form.Set("scope", "read,write,admin")
+	if ar := server.HandleAuthorizeRequest(resp, req); ar != nil {
+		ar.Authorized = true
+		ar.GrantedScopes = "read,write"
+		server.FinishAuthorizeRequest(resp, req, ar)
+	}
+	if resp.IsError {
+		t.Fatalf("Unexpected authorize error: %v", resp.Output)
+	}
+
+	resp = server.NewResponse()
+	req, err = http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("1234", "aabbccdd")
+	req.Form = make(url.Values)
+	req.Form.Set("grant_type", string(AUTHORIZATION_CODE))
+	req.Form.Set("code", "1")
+	req.PostForm = make(url.Values)
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		ar.Authorized = true
+		server.FinishAccessRequest(resp, req, ar)
+	}
+	if resp.IsError {
+		t.Fatalf("Unexpected access error: %v", resp.Output)
+	}
+	if resp.Output["scope"] != "read,write" {
+		t.Errorf("Expected the granted scope, got %v", resp.Output["scope"])
+	}
+	ad := storage.access["1"]
+	if ad.Scope != "read,write,admin" || ad.GrantedScopes != "read,write" || ad.GrantedAt.IsZero() {
+		t.Errorf("Unexpected access data scopes: %q %q %v", ad.Scope, ad.GrantedScopes, ad.GrantedAt)
+	}
+
+	// introspection tells the requested and granted scopes apart
+	resp = server.NewResponse()
+	ireq := newIntrospectionRequest(t, "1")
+	if ir := server.HandleIntrospectionRequest(resp, ireq); ir != nil {
+		server.FinishIntrospectionRequest(resp, ireq, ir)
+	}
+	if resp.Output["scope"] != "read,write" || resp.Output["requested_scope"] != "read,write,admin" || resp.Output["granted_at"] != ad.GrantedAt.Unix() {
+		t.Errorf("Unexpected introspection: %v", resp.Output)
+	}
+
+	// refreshes narrow the granted scope, and can't reach the scope not granted
+	for scope, expected := range map[string]string{"read,admin": "read", "admin": ""} {
+		storage.access["1"], storage.refresh["r1"] = ad, "1"
+		resp = server.NewResponse()
+		req, err = http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = make(url.Values)
+		req.Form.Set("grant_type", string(REFRESH_TOKEN))
+		req.Form.Set("refresh_token", "r1")
+		req.Form.Set("scope", scope)
+		req.PostForm = make(url.Values)
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if expected == "" {
+			if resp.ErrorId != E_ACCESS_DENIED {
+				t.Errorf("%s: expected %s, got %v", scope, E_ACCESS_DENIED, resp.Output)
+			}
+			continue
+		}
+		if resp.IsError || resp.Output["scope"] != expected {
+			t.Errorf("%s: expected scope %s, got %v", scope, expected, resp.Output)
+		}
+	}
+}
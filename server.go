@@ -1,32 +1,251 @@
 package osin
 
 import (
+	"errors"
+	"sync/atomic"
 	"time"
 )
 
 // Server is an OAuth2 implementation
 type Server struct {
+	// Initial configuration. Use UpdateConfig to change it while serving
+	// requests, and CurrentConfig to read the one in use.
 	Config            *ServerConfig
 	Storage           Storage
 	AuthorizeTokenGen AuthorizeTokenGen
 	AccessTokenGen    AccessTokenGen
 	Now               func() time.Time
+
+	// Optional generator of the refresh tokens, so their format can differ
+	// from the access tokens, like opaque refresh tokens next to JWT access
+	// tokens. AccessTokenGen generates both if nil.
+	RefreshTokenGen RefreshTokenGen
+
+	// Optional generator run alongside AccessTokenGen in shadow mode.
+	// Its tokens are only reported to ShadowTokenReporter, never returned
+	// to the client, to validate a token format migration before cutting over.
+	ShadowAccessTokenGen AccessTokenGen
+
+	// Receives shadow token results. Logs them if nil.
+	ShadowTokenReporter ShadowTokenReporter
+
+	// Optional limiter for failed client authentication attempts, keyed by
+	// presented client_id and source IP, to mitigate client secret brute forcing
+	ClientAuthLimiter RateLimiter
+
+	// Decides the behavior when the user denies consent. Redirects with
+	// error=access_denied if nil.
+	ConsentDenialHandler ConsentDenialHandler
+
+	// Validators of the registered authorization_details types (RFC 9396).
+	// Use RegisterAuthorizationDetailType to add them.
+	AuthorizationDetailValidators map[string]AuthorizationDetailValidator
+
+	// Derives the subject identifiers clients receive. The local subject is
+	// used if nil.
+	SubjectIdentifierProvider SubjectIdentifierProvider
+
+	// Stores authorize requests suspended with SuspendAuthorizeRequest
+	PendingAuthorizeStore PendingAuthorizeStore
+
+	// Listeners receiving the server events
+	EventListeners []EventListener
+
+	// Maps the errors of the responses created by NewResponse to HTTP status
+	// codes. Config.ErrorStatusCode is used for all errors if nil; set to
+	// RFCErrorStatus for the statuses of the specifications.
+	ErrorStatusMapper ErrorStatusMapper
+
+	// Localizes the error descriptions of the responses created by
+	// NewResponse, in the languages of the authorize and token requests
+	MessageCatalog MessageCatalog
+
+	// Consulted on bearer validation and refresh to reject tokens revoked
+	// before their expiration
+	RevocationChecker RevocationChecker
+
+	// Generates the user codes of the device authorization grant
+	UserCodeGen *UserCodeGenerator
+
+	// Optional limiter for invalid user codes entered on the device
+	// verification page, keyed by source IP, to mitigate brute forcing
+	UserCodeLimiter RateLimiter
+
+	// Can deny the access requests authorized by the application in
+	// FinishAccessRequest. It never overrides AccessRequest.Authorized
+	// set to false.
+	AccessApprover AccessApprover
+
+	// Verifiers of the registered assertion types of the assertion grant.
+	// Use RegisterAssertionVerifier to add them.
+	AssertionVerifiers map[string]AssertionVerifier
+
+	// Decides which introspection response fields each calling client sees.
+	// All fields are exposed if nil.
+	IntrospectionFieldFilter IntrospectionFieldFilter
+
+	// Adds custom fields to the introspection responses of active tokens
+	IntrospectionExtender IntrospectionExtender
+
+	// Decides which tokens each client may introspect and revoke. If nil,
+	// clients may introspect and revoke their own tokens, and the
+	// Config.IntrospectionClients introspect any token. Public clients may
+	// not introspect tokens either way.
+	TokenAccessPolicy TokenAccessPolicy
+
+	// Signing keys of the JWTs issued by the server, like ID tokens
+	KeySet *KeySet
+
+	// Resolves the scope granted to client credentials requests. The
+	// requested scope is granted if nil.
+	ScopeManager ScopeManager
+
+	// Assesses the risk of token requests before issuing the tokens. It can
+	// deny them, require MFA or tag them with a risk level.
+	RiskEvaluator RiskEvaluator
+
+	// Locates the source IP of token requests for the RiskEvaluator
+	GeoLocator GeoLocator
+
+	// Decides which authenticated clients may exchange reference tokens for
+	// the tokens they reference. All exchanges are denied if nil.
+	ReferenceTokenCallers func(caller Client) bool
+
+	// Tracks the sources of the refreshes of each token family, to emit
+	// EVENT_REFRESH_TOKEN_NEW_SOURCE. Not tracked if nil.
+	FamilyUsageStore FamilyUsageStore
+
+	// Returns the credential version of the users, to reject the tokens
+	// issued before a password change or reset
+	CredentialVersionChecker CredentialVersionChecker
+
+	// Decides the token type of access requests. The client token type,
+	// if it implements ClientTokenType, or Config.TokenType is used if nil.
+	TokenTypeSelector TokenTypeSelector
+
+	// Resolves the users of the password grant, the device verification
+	// and the UserInfo endpoint. Identity is left to the application if nil.
+	UserStore UserStore
+
+	// Verifies the passwords of the password grant. The UserStore is used
+	// if nil and it implements PasswordAuthenticator; the password is then
+	// left to the application if neither does.
+	PasswordAuthenticator PasswordAuthenticator
+
+	// Upstream identity providers the authorize requests can be brokered
+	// to, by name. Use RegisterUpstreamConnector to add them.
+	UpstreamConnectors map[string]UpstreamConnector
+
+	// Maps the upstream identities to local users, like AccountLinker. The
+	// subject is derived from the provider and the upstream subject if nil.
+	IdentityMapper IdentityMapper
+
+	// Stores the server provided nonces, like the DPoP nonces and the
+	// c_nonces, see IssueNonce. The c_nonces are only kept in the access
+	// data if nil.
+	NonceStore NonceStore
+
+	// Middleware wrapping the authorize and token requests, see Use
+	middleware []Middleware
+
+	// Maintenance mode state, see StartMaintenance
+	maintenance maintenanceWindow
+
+	// Background cleanup state, see StartCleanup
+	cleanup cleanupWorker
+
+	// Refresh tokens rotated within Config.RefreshGracePeriod
+	refreshGrace refreshGrace
+
+	// Token responses saved under idempotency keys
+	idempotency idempotency
+
+	// Refresh requests in progress, see Config.CoalesceRefreshRequests
+	refreshFlights responseFlights
+
+	// Token request counters of the RiskEvaluator
+	velocity velocityCounter
+
+	// Nonces issued with NONCE_ROTATE_PERIODIC
+	currentNonces currentNonces
+
+	// Configuration set with UpdateConfig, a *ServerConfig
+	updatedConfig atomic.Value
+
+	// Token formats replaced with UpdateConfig
+	replacedTokenFormats tokenFormats
 }
 
 // NewServer creates a new server instance
 func NewServer(config *ServerConfig, storage Storage) *Server {
-	return &Server{
-		Config:            config,
-		Storage:           storage,
-		AuthorizeTokenGen: &AuthorizeTokenGenDefault{},
-		AccessTokenGen:    &AccessTokenGenDefault{},
-		Now:               time.Now,
+	s := &Server{
+		Config:      config,
+		Storage:     storage,
+		Now:         time.Now,
+		UserCodeGen: NewUserCodeGenerator(),
 	}
+	s.AuthorizeTokenGen = &AuthorizeTokenGenDefault{Format: config.TokenFormat, config: s.config}
+	s.AccessTokenGen = &AccessTokenGenDefault{Format: config.TokenFormat, config: s.config}
+	return s
+}
+
+// config returns the configuration in use
+func (s *Server) config() *ServerConfig {
+	if c, ok := s.updatedConfig.Load().(*ServerConfig); ok {
+		return c
+	}
+	return s.Config
+}
+
+// requestConfig returns the configuration of the request of the response.
+// It is taken once, when the response is created or first used, so a
+// request doesn't mix two configurations when UpdateConfig is called
+// meanwhile.
+func (s *Server) requestConfig(w *Response) *ServerConfig {
+	if w.config == nil {
+		w.config = s.config()
+	}
+	return w.config
+}
+
+// CurrentConfig returns the configuration in use, the last one set with
+// UpdateConfig or the initial Config. It must not be modified.
+func (s *Server) CurrentConfig() *ServerConfig {
+	return s.config()
+}
+
+// UpdateConfig validates, see ServerConfig.Validate, and replaces the configuration atomically, so
+// expirations, allowed types and cookie policies change without a restart.
+// The configuration must not be modified afterwards; update a copy instead.
+// Requests in flight keep the configuration they started with.
+func (s *Server) UpdateConfig(config *ServerConfig) error {
+	if config == nil {
+		return errors.New("config is nil")
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if previous := s.config().TokenFormat; previous != config.TokenFormat {
+		s.replacedTokenFormats.add(previous)
+	}
+	s.updatedConfig.Store(config)
+	return nil
 }
 
 // NewResponse creates a new response for the server
 func (s *Server) NewResponse() *Response {
 	r := NewResponse(s.Storage)
-	r.ErrorStatusCode = s.Config.ErrorStatusCode
+	config := s.requestConfig(r)
+	r.ErrorStatusCode = config.ErrorStatusCode
+	r.ErrorStatusMapper = s.ErrorStatusMapper
+	r.MessageCatalog = s.MessageCatalog
+	r.ErrorDetailLevel = config.ErrorDetailLevel
+	r.StorageRetryAfter = config.StorageUnavailableRetryAfter
+	for k, v := range config.ResponseHeaders {
+		r.Headers.Del(k)
+		for _, e := range v {
+			r.Headers.Add(k, e)
+		}
+	}
 	return r
 }
@@ -0,0 +1,102 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+func TestUpdateConfig(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+
+	request := func() *Response {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = url.Values{"grant_type": {string(CLIENT_CREDENTIALS)}}
+		req.PostForm = make(url.Values)
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		return resp
+	}
+	if resp := request(); resp.IsError || resp.Output["expires_in"] != int32(3600) {
+		t.Fatalf("Unexpected response: %v", resp.Output)
+	}
+
+	// the updated configuration applies to the next requests
+	updated := *sconfig
+	updated.AccessExpiration = 60
+	if err := server.UpdateConfig(&updated); err != nil {
+		t.Fatal(err)
+	}
+	if server.CurrentConfig() != &updated {
+		t.Error("CurrentConfig should return the updated configuration")
+	}
+	if resp := request(); resp.IsError || resp.Output["expires_in"] != int32(60) {
+		t.Fatalf("Unexpected response: %v", resp.Output)
+	}
+
+	disabled := updated
+	disabled.AllowedAccessTypes = AllowedAccessType{AUTHORIZATION_CODE}
+	server.UpdateConfig(&disabled)
+	if resp := request(); resp.ErrorId != E_UNSUPPORTED_GRANT_TYPE {
+		t.Fatalf("Expected %s, got %v", E_UNSUPPORTED_GRANT_TYPE, resp.Output)
+	}
+
+	// a request in flight keeps the configuration it started with
+	server.UpdateConfig(&updated)
+	resp := server.NewResponse()
+	req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("1234", "aabbccdd")
+	req.Form = url.Values{"grant_type": {string(CLIENT_CREDENTIALS)}}
+	req.PostForm = make(url.Values)
+	ar := server.HandleAccessRequest(resp, req)
+	if ar == nil {
+		t.Fatalf("Unexpected error: %v", resp.Output)
+	}
+	server.UpdateConfig(sconfig)
+	ar.Authorized = true
+	server.FinishAccessRequest(resp, req, ar)
+	if resp.IsError || resp.Output["expires_in"] != int32(60) {
+		t.Fatalf("The request should keep its configuration: %v", resp.Output)
+	}
+
+	// invalid configurations are rejected
+	invalid := updated
+	invalid.AccessExpiration = 0
+	if err := server.UpdateConfig(&invalid); err == nil {
+		t.Error("Invalid configuration should be rejected")
+	}
+	if err := server.UpdateConfig(nil); err == nil {
+		t.Error("Nil configuration should be rejected")
+	}
+
+	// updates don't race with requests
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				c := updated
+				server.UpdateConfig(&c)
+			}
+		}()
+	}
+	for j := 0; j < 20; j++ {
+		request()
+	}
+	wg.Wait()
+}
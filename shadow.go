@@ -0,0 +1,74 @@
+package osin
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ShadowTokenResult is the outcome of running the shadow AccessTokenGen
+// alongside the primary one. Shadow tokens are never returned to the client
+// nor saved in the storage.
+type ShadowTokenResult struct {
+	// Access data issued by the primary generator
+	AccessData *AccessData
+
+	// Tokens generated by the shadow generator
+	AccessToken  string
+	RefreshToken string
+
+	// Error returned by the shadow generator, if any
+	Err error
+}
+
+// ShadowTokenReporter receives the shadow token results, to log them or
+// compare them with the primary tokens.
+type ShadowTokenReporter interface {
+	ReportShadowToken(result *ShadowTokenResult)
+}
+
+// ShadowTokenLogger is the default ShadowTokenReporter, which logs the
+// result using logrus
+type ShadowTokenLogger struct {
+}
+
+// ReportShadowToken logs the shadow token result
+func (l *ShadowTokenLogger) ReportShadowToken(result *ShadowTokenResult) {
+	entry := logrus.WithFields(logrus.Fields{
+		"client_id":            result.AccessData.Client.GetID(),
+		"access_token_length":  len(result.AccessToken),
+		"refresh_token_length": len(result.RefreshToken),
+	})
+	if result.Err != nil {
+		entry.Warn("shadow token generation failed: ", result.Err)
+		return
+	}
+	entry.Info("shadow token generated")
+}
+
+// generateShadowToken runs the shadow generator, if any, on a copy of the
+// access data. Failures are only reported, never returned to the client.
+func (s *Server) generateShadowToken(data *AccessData, generaterefresh bool) {
+	if s.ShadowAccessTokenGen == nil {
+		return
+	}
+
+	result := &ShadowTokenResult{
+		AccessData: data,
+	}
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				result.Err = fmt.Errorf("shadow token generator panic: %v", r)
+			}
+		}()
+		shadow := *data
+		result.AccessToken, result.RefreshToken, result.Err = s.ShadowAccessTokenGen.GenerateAccessToken(&shadow, generaterefresh)
+	}()
+
+	reporter := s.ShadowTokenReporter
+	if reporter == nil {
+		reporter = &ShadowTokenLogger{}
+	}
+	reporter.ReportShadowToken(result)
+}
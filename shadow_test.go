@@ -0,0 +1,109 @@
+package osin
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+type recordingShadowReporter struct {
+	results []*ShadowTokenResult
+}
+
+func (r *recordingShadowReporter) ReportShadowToken(result *ShadowTokenResult) {
+	r.results = append(r.results, result)
+}
+
+type failingAccessTokenGen struct {
+}
+
+func (a *failingAccessTokenGen) GenerateAccessToken(data *AccessData, generaterefresh bool) (string, string, error) {
+	return "", "", errors.New("shadow failure")
+}
+
+func TestAccessShadowTokenGen(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{PASSWORD}
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+	server.ShadowAccessTokenGen = &TestingAccessTokenGen{acounter: 100, rcounter: 100}
+	reporter := &recordingShadowReporter{}
+	server.ShadowTokenReporter = reporter
+	resp := server.NewResponse()
+
+	req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("1234", "aabbccdd")
+
+	req.Form = make(url.Values)
+	req.Form.Set("grant_type", string(PASSWORD))
+	req.Form.Set("username", "testing")
+	req.Form.Set("password", "testing")
+	req.PostForm = make(url.Values)
+
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		ar.Authorized = true
+		server.FinishAccessRequest(resp, req, ar)
+	}
+
+	if resp.IsError {
+		t.Fatalf("Should not be an error: %v", resp.InternalError)
+	}
+
+	if d := resp.Output["access_token"]; d != "1" {
+		t.Fatalf("Unexpected access token: %s", d)
+	}
+
+	if len(reporter.results) != 1 {
+		t.Fatalf("Expected 1 shadow result, got %d", len(reporter.results))
+	}
+	result := reporter.results[0]
+	if result.AccessToken != "101" || result.RefreshToken != "r101" {
+		t.Fatalf("Unexpected shadow tokens: %s %s", result.AccessToken, result.RefreshToken)
+	}
+	if result.AccessData.AccessToken != "1" {
+		t.Fatalf("Shadow generator must not change the primary access data: %s", result.AccessData.AccessToken)
+	}
+	if _, err := server.Storage.LoadAccess("101"); err == nil {
+		t.Fatalf("Shadow token must not be saved")
+	}
+}
+
+func TestAccessShadowTokenGenFailure(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{PASSWORD}
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+	server.ShadowAccessTokenGen = &failingAccessTokenGen{}
+	reporter := &recordingShadowReporter{}
+	server.ShadowTokenReporter = reporter
+	resp := server.NewResponse()
+
+	req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("1234", "aabbccdd")
+
+	req.Form = make(url.Values)
+	req.Form.Set("grant_type", string(PASSWORD))
+	req.Form.Set("username", "testing")
+	req.Form.Set("password", "testing")
+	req.PostForm = make(url.Values)
+
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		ar.Authorized = true
+		server.FinishAccessRequest(resp, req, ar)
+	}
+
+	if resp.IsError {
+		t.Fatalf("Shadow failure must not fail the request: %v", resp.InternalError)
+	}
+
+	if len(reporter.results) != 1 || reporter.results[0].Err == nil {
+		t.Fatalf("Expected shadow failure to be reported")
+	}
+}
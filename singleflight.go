@@ -0,0 +1,89 @@
+package osin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// responseFlights coalesces concurrent requests with the same key, the
+// first one running and the others getting a copy of its response
+type responseFlights struct {
+	mu      sync.Mutex
+	flights map[string]*responseFlight
+}
+
+type responseFlight struct {
+	done chan struct{}
+	resp *Response
+}
+
+// do runs f to fill w, unless a request with the same key is in progress,
+// in which case it waits for it and copies its response into w. A panic of
+// f is recovered as a server error, for the waiting requests too.
+func (g *responseFlights) do(key string, w *Response, f func()) {
+	g.mu.Lock()
+	if g.flights == nil {
+		g.flights = make(map[string]*responseFlight)
+	}
+	if fl, ok := g.flights[key]; ok {
+		g.mu.Unlock()
+		<-fl.done
+		fl.resp.copyTo(w)
+		return
+	}
+	fl := &responseFlight{done: make(chan struct{})}
+	g.flights[key] = fl
+	g.mu.Unlock()
+
+	defer func() {
+		if p := recover(); p != nil {
+			w.SetError(E_SERVER_ERROR, "")
+			w.InternalError = fmt.Errorf("panic: %v", p)
+		}
+
+		// snapshot, the caller may keep changing w
+		fl.resp = &Response{}
+		w.copyTo(fl.resp)
+
+		g.mu.Lock()
+		delete(g.flights, key)
+		g.mu.Unlock()
+		close(fl.done)
+	}()
+	f()
+}
+
+// copyTo copies the outcome of the response into dst, keeping its storage
+func (r *Response) copyTo(dst *Response) {
+	dst.Type = r.Type
+	dst.StatusCode = r.StatusCode
+	dst.StatusText = r.StatusText
+	dst.URL = r.URL
+	dst.IsError = r.IsError
+	dst.ErrorId = r.ErrorId
+	dst.InternalError = r.InternalError
+	dst.RedirectInFragment = r.RedirectInFragment
+	dst.NoStore = r.NoStore
+	dst.Output = make(ResponseData, len(r.Output))
+	for k, v := range r.Output {
+		dst.Output[k] = v
+	}
+	dst.Headers = make(http.Header, len(r.Headers))
+	for k, v := range r.Headers {
+		dst.Headers[k] = append([]string(nil), v...)
+	}
+}
+
+// refreshFlightKey identifies identical authorized refresh requests, by
+// everything the issued tokens depend on
+func refreshFlightKey(ar *AccessRequest, redirectUri string) string {
+	details, _ := json.Marshal(ar.AuthorizationDetails)
+	return strings.Join([]string{
+		ar.Client.GetID(), ar.Code, ar.Scope, redirectUri, string(details), ar.Subject, ar.TokenType,
+		strconv.Itoa(int(ar.Expiration)), strconv.Itoa(int(ar.RefreshExpiration)), strconv.FormatBool(ar.GenerateRefresh),
+	}, "\x00")
+}
@@ -0,0 +1,172 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingAccessTokenGen blocks the generation until released
+type blockingAccessTokenGen struct {
+	TestingAccessTokenGen
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (a *blockingAccessTokenGen) GenerateAccessToken(data *AccessData, generaterefresh bool) (string, string, error) {
+	a.entered <- struct{}{}
+	<-a.release
+	return a.TestingAccessTokenGen.GenerateAccessToken(data, generaterefresh)
+}
+
+// lockedStorage serializes the calls to a TestingStorage, for concurrent
+// requests
+type lockedStorage struct {
+	mu sync.Mutex
+	s  *TestingStorage
+}
+
+func (l *lockedStorage) Clone() Storage {
+	return l
+}
+
+func (l *lockedStorage) Close() {
+}
+
+func (l *lockedStorage) GetClient(id string) (Client, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.s.GetClient(id)
+}
+
+func (l *lockedStorage) SaveAuthorize(data *AuthorizeData) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.s.SaveAuthorize(data)
+}
+
+func (l *lockedStorage) LoadAuthorize(code string) (*AuthorizeData, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.s.LoadAuthorize(code)
+}
+
+func (l *lockedStorage) RemoveAuthorize(code string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.s.RemoveAuthorize(code)
+}
+
+func (l *lockedStorage) SaveAccess(data *AccessData) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.s.SaveAccess(data)
+}
+
+func (l *lockedStorage) LoadAccess(token string) (*AccessData, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.s.LoadAccess(token)
+}
+
+func (l *lockedStorage) RemoveAccess(token string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.s.RemoveAccess(token)
+}
+
+func (l *lockedStorage) LoadRefresh(token string) (*AccessData, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.s.LoadRefresh(token)
+}
+
+func (l *lockedStorage) RemoveRefresh(token string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.s.RemoveRefresh(token)
+}
+
+func TestCoalesceRefreshRequests(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{REFRESH_TOKEN}
+	sconfig.CoalesceRefreshRequests = true
+	server := NewServer(sconfig, &lockedStorage{s: NewTestingStorage()})
+	gen := &blockingAccessTokenGen{entered: make(chan struct{}, 2), release: make(chan struct{})}
+	server.AccessTokenGen = gen
+
+	var wg sync.WaitGroup
+	responses := make([]*Response, 2)
+	refresh := func(i int) {
+		defer wg.Done()
+		responses[i] = refreshGraceForTest(server, "r9999")
+	}
+
+	wg.Add(2)
+	go refresh(0)
+	<-gen.entered
+
+	// a denied request doesn't join the one in progress
+	resp := server.NewResponse()
+	req, _ := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+	req.SetBasicAuth("1234", "aabbccdd")
+	req.Form = url.Values{"grant_type": {string(REFRESH_TOKEN)}, "refresh_token": {"r9999"}}
+	req.PostForm = req.Form
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		server.FinishAccessRequest(resp, req, ar)
+	}
+	if resp.ErrorId != E_ACCESS_DENIED {
+		t.Fatalf("Denied refresh should not get the tokens: %v", resp.Output)
+	}
+
+	go refresh(1)
+	// let the second request join the first one
+	time.Sleep(50 * time.Millisecond)
+	close(gen.release)
+	wg.Wait()
+
+	for i, resp := range responses {
+		if resp.IsError {
+			t.Fatalf("Refresh %d failed: %v", i, resp.Output)
+		}
+	}
+	for _, k := range []string{"access_token", "refresh_token"} {
+		if responses[0].Output[k] != responses[1].Output[k] {
+			t.Errorf("Expected the same %s, got %v and %v", k, responses[0].Output[k], responses[1].Output[k])
+		}
+	}
+	if gen.acounter != 1 {
+		t.Errorf("Expected a single token generation, got %d", gen.acounter)
+	}
+}
+
+func TestResponseFlightsPanic(t *testing.T) {
+	var g responseFlights
+	entered, release := make(chan struct{}), make(chan struct{})
+	leader, follower := &Response{}, &Response{}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.do("k", leader, func() {
+			close(entered)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-entered
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+	}()
+	g.do("k", follower, func() { t.Error("Follower should not run") })
+	<-done
+
+	for name, resp := range map[string]*Response{"leader": leader, "follower": follower} {
+		if resp.ErrorId != E_SERVER_ERROR {
+			t.Errorf("%s: expected server_error, got %v", name, resp.Output)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package osin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	ErrSoftwareStatementUnapproved = errors.New("software statement issuer is not approved")
+	ErrSoftwareStatementConflict   = errors.New("client metadata conflicts with the software statement")
+)
+
+// SoftwareStatementPolicy decides how the claims of a software statement
+// combine with the client metadata sent in plain JSON
+type SoftwareStatementPolicy int
+
+const (
+	// The statement claims replace the metadata values, as required by
+	// RFC 7591 section 2.3
+	SOFTWARE_STATEMENT_OVERRIDE SoftwareStatementPolicy = iota
+
+	// Metadata values different from the statement claims are refused
+	SOFTWARE_STATEMENT_STRICT
+)
+
+// SoftwareStatementVerifier validates the signed software statements of
+// dynamic client registration requests (RFC 7591 section 2.3), for the
+// registration endpoint of the application
+type SoftwareStatementVerifier struct {
+	// Returns the federation key of the issuer and key id, as accepted by
+	// JWT.Verify. Return ErrSoftwareStatementUnapproved for unknown issuers.
+	// Required.
+	KeyFunc func(issuer, keyId string) (interface{}, error)
+
+	// Approved issuers. Any issuer with a key is accepted if empty.
+	Issuers []string
+
+	// Combination of the statement claims with the metadata
+	Policy SoftwareStatementPolicy
+
+	// Requires the statement to be issued at most this long ago, if not 0
+	MaxAge time.Duration
+
+	// Allowed clock skew
+	Leeway time.Duration
+
+	// Returns the current time, time.Now if nil
+	Now func() time.Time
+}
+
+// Verify verifies the signature and the issuer of a software statement,
+// and returns its claims. The "exp" and "nbf" claims are checked if present.
+func (v *SoftwareStatementVerifier) Verify(statement string) (map[string]interface{}, error) {
+	if v.KeyFunc == nil {
+		return nil, errors.New("software statement verifier has no KeyFunc")
+	}
+	now := time.Now
+	if v.Now != nil {
+		now = v.Now
+	}
+
+	t, err := ParseJWT(statement)
+	if err != nil {
+		return nil, err
+	}
+	iss := t.StringClaim("iss")
+	if iss == "" || (len(v.Issuers) > 0 && !stringInList(iss, v.Issuers)) {
+		return nil, ErrSoftwareStatementUnapproved
+	}
+	key, err := v.KeyFunc(iss, t.KeyID())
+	if err != nil {
+		return nil, err
+	}
+	if err = t.Verify(key); err != nil {
+		return nil, err
+	}
+
+	n := now()
+	if _, ok := t.TimeClaim("exp"); ok {
+		if err = t.ValidateTimes(n, v.Leeway); err != nil {
+			return nil, err
+		}
+	} else if nbf, ok := t.TimeClaim("nbf"); ok && n.Add(v.Leeway).Before(nbf) {
+		return nil, ErrJWTNotYetValid
+	}
+	if v.MaxAge > 0 {
+		iat, ok := t.TimeClaim("iat")
+		if !ok || n.Sub(iat) > v.MaxAge+v.Leeway {
+			return nil, errors.New("software statement is too old")
+		}
+	}
+	return t.Claims, nil
+}
+
+// Apply verifies the "software_statement" of the registration metadata, if
+// present, and returns the metadata combined with the statement claims per
+// the policy. The JWT registered claims of the statement are not copied.
+func (v *SoftwareStatementVerifier) Apply(metadata map[string]interface{}) (map[string]interface{}, error) {
+	ret := make(map[string]interface{}, len(metadata))
+	for k, val := range metadata {
+		ret[k] = val
+	}
+	raw, ok := ret["software_statement"]
+	if !ok {
+		return ret, nil
+	}
+	delete(ret, "software_statement")
+
+	statement, ok := raw.(string)
+	if !ok {
+		return nil, errors.New("software_statement must be a string")
+	}
+	claims, err := v.Verify(statement)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, claim := range claims {
+		switch k {
+		case "iss", "sub", "aud", "exp", "nbf", "iat", "jti":
+			continue
+		}
+		if current, ok := ret[k]; ok && v.Policy == SOFTWARE_STATEMENT_STRICT && !jsonEqual(current, claim) {
+			return nil, ErrSoftwareStatementConflict
+		}
+		ret[k] = claim
+	}
+	return ret, nil
+}
+
+// SoftwareStatementErrorId returns the RFC 7591 error code of an error
+// returned by SoftwareStatementVerifier
+func SoftwareStatementErrorId(err error) string {
+	switch err {
+	case ErrSoftwareStatementUnapproved:
+		return E_UNAPPROVED_SOFTWARE_STATEMENT
+	case ErrSoftwareStatementConflict:
+		return E_INVALID_CLIENT_METADATA
+	}
+	return E_INVALID_SOFTWARE_STATEMENT
+}
+
+// jsonEqual compares two values by their JSON encoding
+func jsonEqual(a, b interface{}) bool {
+	ja, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	jb, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(ja, jb)
+}
@@ -0,0 +1,77 @@
+package osin
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSoftwareStatementVerifier(t *testing.T) {
+	key := []byte("federation-key")
+	now := time.Unix(1500000000, 0)
+	v := &SoftwareStatementVerifier{
+		KeyFunc: func(issuer, keyId string) (interface{}, error) {
+			if issuer != "https://directory.example.com" {
+				return nil, ErrSoftwareStatementUnapproved
+			}
+			return key, nil
+		},
+		MaxAge: time.Hour,
+		Now:    func() time.Time { return now },
+	}
+
+	statement, err := SignJWT(map[string]interface{}{
+		"iss":           "https://directory.example.com",
+		"iat":           now.Unix(),
+		"software_id":   "app-1",
+		"client_name":   "Approved App",
+		"redirect_uris": []string{"https://app.example.com/cb"},
+	}, "HS256", "", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unapproved, err := SignJWT(map[string]interface{}{"iss": "https://rogue.example.com", "iat": now.Unix()}, "HS256", "", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadata := map[string]interface{}{
+		"client_name":        "Other Name",
+		"logo_uri":           "https://app.example.com/logo.png",
+		"software_statement": statement,
+	}
+
+	// override
+	ret, err := v.Apply(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret["client_name"] != "Approved App" || ret["software_id"] != "app-1" || ret["logo_uri"] == nil {
+		t.Fatalf("Unexpected metadata: %v", ret)
+	}
+	if _, ok := ret["software_statement"]; ok || ret["iss"] != nil {
+		t.Fatalf("Statement and registered claims should not be copied: %v", ret)
+	}
+
+	// strict
+	v.Policy = SOFTWARE_STATEMENT_STRICT
+	if _, err = v.Apply(metadata); SoftwareStatementErrorId(err) != E_INVALID_CLIENT_METADATA {
+		t.Fatalf("Conflicting metadata should be refused: %v", err)
+	}
+	metadata["client_name"] = "Approved App"
+	if _, err = v.Apply(metadata); err != nil {
+		t.Fatalf("Matching metadata should be accepted: %v", err)
+	}
+
+	// unapproved issuer
+	metadata["software_statement"] = unapproved
+	if _, err = v.Apply(metadata); SoftwareStatementErrorId(err) != E_UNAPPROVED_SOFTWARE_STATEMENT {
+		t.Fatalf("Unapproved issuer should be refused: %v", err)
+	}
+
+	// too old
+	metadata["software_statement"] = statement
+	now = now.Add(2 * time.Hour)
+	if _, err = v.Apply(metadata); SoftwareStatementErrorId(err) != E_INVALID_SOFTWARE_STATEMENT {
+		t.Fatalf("Old statement should be refused: %v", err)
+	}
+}
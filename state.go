@@ -0,0 +1,128 @@
+package osin
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrStateInvalid  = errors.New("state is invalid")
+	ErrStateExpired  = errors.New("state is expired")
+	ErrStateReplayed = errors.New("state was already used")
+)
+
+// StateSigner generates and validates signed state values for clients of an
+// authorization server. Each state carries an expiration and a nonce, and
+// is accepted only once by the StateSigner that validates it.
+type StateSigner struct {
+	// HMAC-SHA256 key - required
+	Key []byte
+
+	// Validity of the generated states (default 10 minutes)
+	Expiration time.Duration
+
+	// Current time (default time.Now)
+	Now func() time.Time
+
+	mu   sync.Mutex
+	used map[string]time.Time
+}
+
+type stateClaims struct {
+	Nonce     string `json:"n"`
+	ExpiresAt int64  `json:"e"`
+	Data      string `json:"d,omitempty"`
+}
+
+// NewStateSigner creates a StateSigner with the given key
+func NewStateSigner(key []byte) *StateSigner {
+	return &StateSigner{Key: key}
+}
+
+func (s *StateSigner) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// Generate returns a new signed state carrying the application data, like
+// the URL to return to after the authorization
+func (s *StateSigner) Generate(data string) (string, error) {
+	if len(s.Key) == 0 {
+		return "", errors.New("state signer key is required")
+	}
+	nonce, err := RandomString(BASE62_ALPHABET, 22)
+	if err != nil {
+		return "", err
+	}
+	expiration := s.Expiration
+	if expiration <= 0 {
+		expiration = 10 * time.Minute
+	}
+
+	payload, err := json.Marshal(&stateClaims{
+		Nonce:     nonce,
+		ExpiresAt: s.now().Add(expiration).Unix(),
+		Data:      data,
+	})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + s.sign(encoded), nil
+}
+
+// Validate verifies the signature, expiration and single use of the state,
+// returning its application data
+func (s *StateSigner) Validate(state string) (string, error) {
+	parts := strings.Split(state, ".")
+	if len(parts) != 2 || len(s.Key) == 0 {
+		return "", ErrStateInvalid
+	}
+	if !hmac.Equal([]byte(s.sign(parts[0])), []byte(parts[1])) {
+		return "", ErrStateInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrStateInvalid
+	}
+	var claims stateClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Nonce == "" {
+		return "", ErrStateInvalid
+	}
+
+	now := s.now()
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+	if !now.Before(expiresAt) {
+		return "", ErrStateExpired
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.used == nil {
+		s.used = make(map[string]time.Time)
+	}
+	for n, exp := range s.used {
+		if !now.Before(exp) {
+			delete(s.used, n)
+		}
+	}
+	if _, ok := s.used[claims.Nonce]; ok {
+		return "", ErrStateReplayed
+	}
+	s.used[claims.Nonce] = expiresAt
+	return claims.Data, nil
+}
+
+func (s *StateSigner) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.Key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
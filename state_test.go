@@ -0,0 +1,75 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestStateSigner(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	signer := NewStateSigner([]byte("secret"))
+	signer.Now = func() time.Time { return now }
+
+	state, err := signer.Generate("/return/here")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := NewStateSigner([]byte("other"))
+	other.Now = signer.Now
+	if _, err := other.Validate(state); err != ErrStateInvalid {
+		t.Fatalf("State signed with other key should be invalid, got %v", err)
+	}
+	if _, err := signer.Validate(state + "x"); err != ErrStateInvalid {
+		t.Fatalf("Tampered state should be invalid, got %v", err)
+	}
+
+	data, err := signer.Validate(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data != "/return/here" {
+		t.Fatalf("Unexpected state data: %s", data)
+	}
+	if _, err := signer.Validate(state); err != ErrStateReplayed {
+		t.Fatalf("Replayed state should fail, got %v", err)
+	}
+
+	state, err = signer.Generate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(11 * time.Minute)
+	if _, err := signer.Validate(state); err != ErrStateExpired {
+		t.Fatalf("Expired state should fail, got %v", err)
+	}
+}
+
+func TestRequireState(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.RequireState = true
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+
+	for _, state := range []string{"", "a"} {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Form = make(url.Values)
+		req.Form.Set("response_type", string(CODE))
+		req.Form.Set("client_id", "1234")
+		req.Form.Set("state", state)
+
+		if ar := server.HandleAuthorizeRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAuthorizeRequest(resp, req, ar)
+		}
+		if resp.IsError != (state == "") {
+			t.Errorf("state %q: unexpected result %v", state, resp.Output)
+		}
+	}
+}
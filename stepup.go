@@ -0,0 +1,120 @@
+package osin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuthenticationContext holds how the resource owner was authenticated, as
+// described in https://openid.net/specs/openid-connect-core-1_0.html#IDToken
+type AuthenticationContext struct {
+	// Authentication context class reference achieved
+	ACR string
+
+	// Authentication methods references
+	AMR []string
+
+	// Time of the authentication. Zero if unknown.
+	AuthTime time.Time
+}
+
+// AuthenticationRequirement is a minimum authentication demanded by a client
+// or a resource server, as described in RFC 9470
+type AuthenticationRequirement struct {
+	// Acceptable acr values, from acr_values. Any achieved acr is accepted if empty.
+	ACRValues []string
+
+	// Maximum age of the authentication in seconds, from max_age. Negative if not set.
+	MaxAge int
+}
+
+// ParseAuthenticationRequirement reads acr_values and max_age from the request form
+func ParseAuthenticationRequirement(r *http.Request) (*AuthenticationRequirement, error) {
+	ret := &AuthenticationRequirement{
+		ACRValues: strings.Fields(r.Form.Get("acr_values")),
+		MaxAge:    -1,
+	}
+	if v := r.Form.Get("max_age"); v != "" {
+		maxAge, err := strconv.Atoi(v)
+		if err != nil || maxAge < 0 {
+			return nil, fmt.Errorf("invalid max_age: %s", v)
+		}
+		ret.MaxAge = maxAge
+	}
+	return ret, nil
+}
+
+// IsEmpty returns true if the requirement doesn't demand anything
+func (a *AuthenticationRequirement) IsEmpty() bool {
+	return a == nil || (len(a.ACRValues) == 0 && a.MaxAge < 0)
+}
+
+// SatisfiedBy returns true if the authentication context meets the requirement
+// at time 'now'. levels is the list of acr values ordered from weakest to
+// strongest, where an acr satisfies any weaker one; if empty, the acr must be
+// one of the required values.
+func (a *AuthenticationRequirement) SatisfiedBy(ctx AuthenticationContext, levels []string, now time.Time) bool {
+	if a.IsEmpty() {
+		return true
+	}
+	if a.MaxAge >= 0 && (ctx.AuthTime.IsZero() || now.Sub(ctx.AuthTime) > time.Duration(a.MaxAge)*time.Second) {
+		return false
+	}
+	if len(a.ACRValues) == 0 {
+		return true
+	}
+
+	achieved := indexOf(levels, ctx.ACR)
+	for _, v := range a.ACRValues {
+		if v == ctx.ACR {
+			return true
+		}
+		if required := indexOf(levels, v); achieved >= 0 && required >= 0 && achieved >= required {
+			return true
+		}
+	}
+	return false
+}
+
+func indexOf(list []string, v string) int {
+	for i, k := range list {
+		if k == v {
+			return i
+		}
+	}
+	return -1
+}
+
+// SetInsufficientUserAuthentication sets the insufficient_user_authentication
+// error on the response, with the RFC 9470 WWW-Authenticate challenge telling
+// the client the authentication it needs
+func (r *Response) SetInsufficientUserAuthentication(description string, req *AuthenticationRequirement) {
+	r.SetError(E_INSUFFICIENT_USER_AUTHENTICATION, description)
+
+	challenge := fmt.Sprintf(`Bearer error="%s", error_description="%s"`, E_INSUFFICIENT_USER_AUTHENTICATION, r.Output["error_description"])
+	if req != nil && len(req.ACRValues) > 0 {
+		challenge += fmt.Sprintf(`, acr_values="%s"`, strings.Join(req.ACRValues, " "))
+	}
+	if req != nil && req.MaxAge >= 0 {
+		challenge += fmt.Sprintf(`, max_age=%d`, req.MaxAge)
+	}
+	if r.Headers == nil {
+		r.Headers = make(http.Header)
+	}
+	r.Headers.Set("WWW-Authenticate", challenge)
+}
+
+// CheckAuthenticationRequirement verifies that the access data meets the
+// authentication requirement, for resource servers demanding step-up
+// authentication. Sets the insufficient_user_authentication error on the
+// response and returns false otherwise.
+func (s *Server) CheckAuthenticationRequirement(w *Response, ad *AccessData, req *AuthenticationRequirement) bool {
+	if req.SatisfiedBy(ad.AuthenticationContext, s.requestConfig(w).ACRLevels, s.Now()) {
+		return true
+	}
+	w.SetInsufficientUserAuthentication("", req)
+	return false
+}
@@ -0,0 +1,149 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthenticationRequirementSatisfiedBy(t *testing.T) {
+	now := time.Now()
+	levels := []string{"urn:acr:pwd", "urn:acr:mfa", "urn:acr:hwk"}
+
+	testcases := map[string]struct {
+		Requirement AuthenticationRequirement
+		Context     AuthenticationContext
+		Levels      []string
+		Expected    bool
+	}{
+		"empty": {
+			Requirement: AuthenticationRequirement{MaxAge: -1},
+			Expected:    true,
+		},
+		"exact acr": {
+			Requirement: AuthenticationRequirement{ACRValues: []string{"urn:acr:mfa"}, MaxAge: -1},
+			Context:     AuthenticationContext{ACR: "urn:acr:mfa"},
+			Expected:    true,
+		},
+		"weaker acr": {
+			Requirement: AuthenticationRequirement{ACRValues: []string{"urn:acr:mfa"}, MaxAge: -1},
+			Context:     AuthenticationContext{ACR: "urn:acr:pwd"},
+			Levels:      levels,
+			Expected:    false,
+		},
+		"stronger acr with levels": {
+			Requirement: AuthenticationRequirement{ACRValues: []string{"urn:acr:mfa"}, MaxAge: -1},
+			Context:     AuthenticationContext{ACR: "urn:acr:hwk"},
+			Levels:      levels,
+			Expected:    true,
+		},
+		"stronger acr without levels": {
+			Requirement: AuthenticationRequirement{ACRValues: []string{"urn:acr:mfa"}, MaxAge: -1},
+			Context:     AuthenticationContext{ACR: "urn:acr:hwk"},
+			Expected:    false,
+		},
+		"recent authentication": {
+			Requirement: AuthenticationRequirement{MaxAge: 60},
+			Context:     AuthenticationContext{AuthTime: now.Add(-30 * time.Second)},
+			Expected:    true,
+		},
+		"old authentication": {
+			Requirement: AuthenticationRequirement{MaxAge: 60},
+			Context:     AuthenticationContext{AuthTime: now.Add(-2 * time.Minute)},
+			Expected:    false,
+		},
+		"unknown authentication time": {
+			Requirement: AuthenticationRequirement{MaxAge: 60},
+			Expected:    false,
+		},
+	}
+
+	for k, tc := range testcases {
+		if result := tc.Requirement.SatisfiedBy(tc.Context, tc.Levels, now); result != tc.Expected {
+			t.Errorf("%s: expected %v, got %v", k, tc.Expected, result)
+		}
+	}
+}
+
+func TestAccessRefreshTokenStepUp(t *testing.T) {
+	storage := NewTestingStorage()
+	storage.access["9999"].AuthenticationContext = AuthenticationContext{
+		ACR:      "urn:acr:pwd",
+		AMR:      []string{"pwd"},
+		AuthTime: time.Now(),
+	}
+
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{REFRESH_TOKEN}
+	sconfig.ACRLevels = []string{"urn:acr:pwd", "urn:acr:mfa"}
+	server := NewServer(sconfig, storage)
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+
+	request := func(acrValues string) *Response {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = make(url.Values)
+		req.Form.Set("grant_type", string(REFRESH_TOKEN))
+		req.Form.Set("refresh_token", "r9999")
+		req.Form.Set("acr_values", acrValues)
+		req.PostForm = make(url.Values)
+
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		return resp
+	}
+
+	resp := request("urn:acr:mfa")
+	if resp.ErrorId != E_INSUFFICIENT_USER_AUTHENTICATION {
+		t.Fatalf("Expected insufficient_user_authentication, got %v", resp.Output)
+	}
+	if h := resp.Headers.Get("WWW-Authenticate"); !strings.Contains(h, `acr_values="urn:acr:mfa"`) {
+		t.Fatalf("Unexpected WWW-Authenticate header: %s", h)
+	}
+
+	resp = request("urn:acr:pwd")
+	if resp.IsError {
+		t.Fatalf("Should not be an error: %v", resp.Output)
+	}
+	if ad := storage.access["1"]; ad == nil || ad.AuthenticationContext.ACR != "urn:acr:pwd" {
+		t.Fatalf("Authentication context should be kept on refresh")
+	}
+}
+
+func TestInfoAuthenticationContext(t *testing.T) {
+	storage := NewTestingStorage()
+	storage.access["9999"].AuthenticationContext = AuthenticationContext{
+		ACR: "urn:acr:mfa",
+		AMR: []string{"pwd", "otp"},
+	}
+	server := NewServer(NewServerConfig(), storage)
+	resp := server.NewResponse()
+
+	req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer 9999")
+
+	if ir := server.HandleInfoRequest(resp, req); ir != nil {
+		server.FinishInfoRequest(resp, req, ir)
+	}
+
+	if resp.IsError {
+		t.Fatalf("Should not be an error: %v", resp.Output)
+	}
+	if d := resp.Output["acr"]; d != "urn:acr:mfa" {
+		t.Fatalf("Unexpected acr: %v", d)
+	}
+	if _, ok := resp.Output["auth_time"]; ok {
+		t.Fatalf("Unknown auth_time should not be output")
+	}
+}
@@ -1,6 +1,7 @@
 package osin
 
 import (
+	"context"
 	"errors"
 )
 
@@ -10,6 +11,10 @@ var (
 	// client is not found. All other returned errors must be treated as storage-specific errors,
 	// like "connection lost", "connection refused", etc.
 	ErrNotFound = errors.New("Entity not found")
+
+	// ErrAlreadyExists is the error returned by AuthorizeInserter when the
+	// code is already used
+	ErrAlreadyExists = errors.New("Entity already exists")
 )
 
 // Storage interface
@@ -57,3 +62,46 @@ type Storage interface {
 	// RemoveRefresh revokes or deletes refresh AccessData.
 	RemoveRefresh(token string) error
 }
+
+// StorageWrapper is implemented by the Storage decorators, like
+// CachingStorage, so the optional interfaces of the storage they decorate
+// (DeviceStorage, MFAStorage, IdempotencyStorage, RefreshGraceStorage,
+// PreAuthorizedCodeStorage, APIKeyStorage, ExpiringStorage) are found
+// through them. These calls go to the decorated storage directly.
+type StorageWrapper interface {
+	// Unwrap returns the decorated storage
+	Unwrap() Storage
+}
+
+// storageAs returns the storage, or the first storage it decorates,
+// implementing T
+func storageAs[T any](storage Storage) (T, bool) {
+	for storage != nil {
+		if t, ok := storage.(T); ok {
+			return t, true
+		}
+		sw, ok := storage.(StorageWrapper)
+		if !ok {
+			break
+		}
+		storage = sw.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// AuthorizeInserter is an optional interface storages can implement to
+// detect code collisions, so short codes can be used safely. The server
+// generates a new code when InsertAuthorize returns ErrAlreadyExists.
+type AuthorizeInserter interface {
+	// InsertAuthorize saves authorize data, unless its code exists
+	InsertAuthorize(*AuthorizeData) error
+}
+
+// AccessBatchLoader is an optional interface storages can implement to load
+// the access data of many tokens in a single round trip
+type AccessBatchLoader interface {
+	// LoadAccessBatch retrieves access data by tokens, in the same order.
+	// Entries of tokens not found are nil. Client information MUST be loaded together.
+	LoadAccessBatch(ctx context.Context, tokens []string) ([]*AccessData, error)
+}
@@ -0,0 +1,481 @@
+// Package dynamodb is an osin storage over a single DynamoDB table.
+//
+// The table has a string partition key "pk", and TTL enabled on the "ttl"
+// attribute. Two global secondary indexes, projecting all the attributes,
+// look up the grants by refresh token and by client:
+//
+//	REFRESH_INDEX: partition key "refresh" (string)
+//	CLIENT_INDEX:  partition key "client_id" (string)
+//
+// Authorization codes are consumed by LoadAuthorize with a conditional
+// write, so a code can only be exchanged once even by concurrent requests.
+//
+// UserData is stored in plaintext JSON by default. To encrypt it with the
+// osin Config.UserDataKeys, set the codec of the configuration:
+//
+//	storage := dynamodb.New(client, table)
+//	if storage.UserDataCodec, err = config.UserDataCodec(); err != nil {
+//		return err
+//	}
+//
+// Encrypted UserData is bound to the partition key of its item.
+package dynamodb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RangelReale/osin"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Attributes of the table items
+const (
+	ATTR_PK       = "pk"
+	ATTR_TTL      = "ttl"
+	ATTR_DATA     = "data"
+	ATTR_USERDATA = "userdata"
+	ATTR_REFRESH  = "refresh"
+	ATTR_CLIENT   = "client_id"
+	ATTR_CONSUMED = "consumed"
+)
+
+// Names of the global secondary indexes
+const (
+	REFRESH_INDEX = "refresh-index"
+	CLIENT_INDEX  = "client-index"
+)
+
+// Prefixes of the partition keys of each kind of item
+const (
+	PREFIX_CLIENT    = "client#"
+	PREFIX_AUTHORIZE = "code#"
+	PREFIX_ACCESS    = "access#"
+)
+
+// API is the part of the DynamoDB client used by the storage, implemented
+// by *dynamodb.Client
+type API interface {
+	GetItem(ctx context.Context, params *ddb.GetItemInput, optFns ...func(*ddb.Options)) (*ddb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *ddb.PutItemInput, optFns ...func(*ddb.Options)) (*ddb.PutItemOutput, error)
+	UpdateItem(ctx context.Context, params *ddb.UpdateItemInput, optFns ...func(*ddb.Options)) (*ddb.UpdateItemOutput, error)
+	DeleteItem(ctx context.Context, params *ddb.DeleteItemInput, optFns ...func(*ddb.Options)) (*ddb.DeleteItemOutput, error)
+	Query(ctx context.Context, params *ddb.QueryInput, optFns ...func(*ddb.Options)) (*ddb.QueryOutput, error)
+}
+
+// Storage is an osin.Storage and osin.ContextStorage over a DynamoDB table
+type Storage struct {
+	API   API
+	Table string
+
+	// Serializes the UserData of the grants - default osin.JSONUserDataCodec,
+	// in plaintext. Set it to ServerConfig.UserDataCodec to encrypt it.
+	UserDataCodec osin.UserDataCodec
+}
+
+// New creates a storage over the table
+func New(api API, table string) *Storage {
+	return &Storage{
+		API:           api,
+		Table:         table,
+		UserDataCodec: osin.JSONUserDataCodec{},
+	}
+}
+
+// Clone returns the storage itself, the DynamoDB client is safe for
+// concurrent use
+func (s *Storage) Clone() osin.Storage {
+	return s
+}
+
+// Close does nothing
+func (s *Storage) Close() {
+}
+
+func (s *Storage) codec() osin.UserDataCodec {
+	if s.UserDataCodec == nil {
+		return osin.JSONUserDataCodec{}
+	}
+	return s.UserDataCodec
+}
+
+func key(pk string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{ATTR_PK: &types.AttributeValueMemberS{Value: pk}}
+}
+
+func stringAttr(item map[string]types.AttributeValue, name string) string {
+	if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
+
+func ttlAttr(t time.Time) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(t.Unix(), 10)}
+}
+
+// isConditionFailed returns true if the error is a failed condition of a
+// conditional write
+func isConditionFailed(err error) bool {
+	var cf *types.ConditionalCheckFailedException
+	return errors.As(err, &cf)
+}
+
+// SetClient saves a client, as an osin.DefaultClient
+func (s *Storage) SetClient(ctx context.Context, client osin.Client) error {
+	c := &osin.DefaultClient{}
+	c.CopyFrom(client)
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = s.API.PutItem(ctx, &ddb.PutItemInput{
+		TableName: &s.Table,
+		Item: map[string]types.AttributeValue{
+			ATTR_PK:   &types.AttributeValueMemberS{Value: PREFIX_CLIENT + c.Id},
+			ATTR_DATA: &types.AttributeValueMemberB{Value: data},
+		},
+	})
+	return err
+}
+
+// RemoveClient removes a client
+func (s *Storage) RemoveClient(ctx context.Context, id string) error {
+	_, err := s.API.DeleteItem(ctx, &ddb.DeleteItemInput{TableName: &s.Table, Key: key(PREFIX_CLIENT + id)})
+	return err
+}
+
+// GetClientContext satisfies osin.ContextStorage
+func (s *Storage) GetClientContext(ctx context.Context, id string) (osin.Client, error) {
+	out, err := s.API.GetItem(ctx, &ddb.GetItemInput{TableName: &s.Table, Key: key(PREFIX_CLIENT + id)})
+	if err != nil {
+		return nil, err
+	}
+	data, ok := out.Item[ATTR_DATA].(*types.AttributeValueMemberB)
+	if !ok {
+		return nil, osin.ErrNotFound
+	}
+	c := &osin.DefaultClient{}
+	if err = json.Unmarshal(data.Value, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetClient satisfies osin.Storage
+func (s *Storage) GetClient(id string) (osin.Client, error) {
+	return s.GetClientContext(context.Background(), id)
+}
+
+// SaveAuthorizeContext satisfies osin.ContextStorage
+func (s *Storage) SaveAuthorizeContext(ctx context.Context, data *osin.AuthorizeData) error {
+	rec := *data
+	rec.Client, rec.UserData = nil, nil
+	b, err := json.Marshal(&rec)
+	if err != nil {
+		return err
+	}
+	item := map[string]types.AttributeValue{
+		ATTR_PK:     &types.AttributeValueMemberS{Value: PREFIX_AUTHORIZE + data.Code},
+		ATTR_TTL:    ttlAttr(data.ExpireAt()),
+		ATTR_DATA:   &types.AttributeValueMemberB{Value: b},
+		ATTR_CLIENT: &types.AttributeValueMemberS{Value: data.Client.GetID()},
+	}
+	if data.UserData != nil {
+		if b, err = osin.EncodeBoundUserData(s.codec(), data.UserData, []byte(PREFIX_AUTHORIZE+data.Code)); err != nil {
+			return err
+		}
+		item[ATTR_USERDATA] = &types.AttributeValueMemberB{Value: b}
+	}
+	_, err = s.API.PutItem(ctx, &ddb.PutItemInput{TableName: &s.Table, Item: item})
+	return err
+}
+
+// SaveAuthorize satisfies osin.Storage
+func (s *Storage) SaveAuthorize(data *osin.AuthorizeData) error {
+	return s.SaveAuthorizeContext(context.Background(), data)
+}
+
+// LoadAuthorizeContext satisfies osin.ContextStorage. The code is marked
+// consumed, later loads return osin.ErrNotFound.
+func (s *Storage) LoadAuthorizeContext(ctx context.Context, code string) (*osin.AuthorizeData, error) {
+	out, err := s.API.UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:                &s.Table,
+		Key:                      key(PREFIX_AUTHORIZE + code),
+		UpdateExpression:         strPtr("SET #consumed = :true"),
+		ConditionExpression:      strPtr("attribute_exists(#pk) AND attribute_not_exists(#consumed)"),
+		ExpressionAttributeNames: map[string]string{"#pk": ATTR_PK, "#consumed": ATTR_CONSUMED},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":true": &types.AttributeValueMemberBOOL{Value: true},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	if isConditionFailed(err) {
+		return nil, osin.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data := &osin.AuthorizeData{}
+	if err = s.decode(ctx, out.Attributes, data, &data.Client, &data.UserData); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// LoadAuthorize satisfies osin.Storage
+func (s *Storage) LoadAuthorize(code string) (*osin.AuthorizeData, error) {
+	return s.LoadAuthorizeContext(context.Background(), code)
+}
+
+// RemoveAuthorizeContext satisfies osin.ContextStorage
+func (s *Storage) RemoveAuthorizeContext(ctx context.Context, code string) error {
+	_, err := s.API.DeleteItem(ctx, &ddb.DeleteItemInput{TableName: &s.Table, Key: key(PREFIX_AUTHORIZE + code)})
+	return err
+}
+
+// RemoveAuthorize satisfies osin.Storage
+func (s *Storage) RemoveAuthorize(code string) error {
+	return s.RemoveAuthorizeContext(context.Background(), code)
+}
+
+// SaveAccessContext satisfies osin.ContextStorage
+func (s *Storage) SaveAccessContext(ctx context.Context, data *osin.AccessData) error {
+	rec := *data
+	rec.Client, rec.UserData, rec.AuthorizeData, rec.AccessData = nil, nil, nil, nil
+	b, err := json.Marshal(&rec)
+	if err != nil {
+		return err
+	}
+	item := map[string]types.AttributeValue{
+		ATTR_PK:     &types.AttributeValueMemberS{Value: PREFIX_ACCESS + data.AccessToken},
+		ATTR_DATA:   &types.AttributeValueMemberB{Value: b},
+		ATTR_CLIENT: &types.AttributeValueMemberS{Value: data.Client.GetID()},
+	}
+
+	// the item lives as long as its longest lived token
+	expireAt := data.ExpireAt()
+	if data.RefreshToken != "" {
+		item[ATTR_REFRESH] = &types.AttributeValueMemberS{Value: data.RefreshToken}
+		if data.RefreshExpireIn > 0 {
+			if refreshAt := data.CreatedAt.Add(time.Duration(data.RefreshExpireIn) * time.Second); refreshAt.After(expireAt) {
+				expireAt = refreshAt
+			}
+		} else {
+			expireAt = time.Time{}
+		}
+	}
+	if !expireAt.IsZero() {
+		item[ATTR_TTL] = ttlAttr(expireAt)
+	}
+	if data.UserData != nil {
+		if b, err = osin.EncodeBoundUserData(s.codec(), data.UserData, []byte(PREFIX_ACCESS+data.AccessToken)); err != nil {
+			return err
+		}
+		item[ATTR_USERDATA] = &types.AttributeValueMemberB{Value: b}
+	}
+	_, err = s.API.PutItem(ctx, &ddb.PutItemInput{TableName: &s.Table, Item: item})
+	return err
+}
+
+// SaveAccess satisfies osin.Storage
+func (s *Storage) SaveAccess(data *osin.AccessData) error {
+	return s.SaveAccessContext(context.Background(), data)
+}
+
+// LoadAccessContext satisfies osin.ContextStorage
+func (s *Storage) LoadAccessContext(ctx context.Context, token string) (*osin.AccessData, error) {
+	out, err := s.API.GetItem(ctx, &ddb.GetItemInput{
+		TableName:      &s.Table,
+		Key:            key(PREFIX_ACCESS + token),
+		ConsistentRead: boolPtr(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, osin.ErrNotFound
+	}
+	return s.decodeAccess(ctx, out.Item)
+}
+
+// LoadAccess satisfies osin.Storage
+func (s *Storage) LoadAccess(token string) (*osin.AccessData, error) {
+	return s.LoadAccessContext(context.Background(), token)
+}
+
+// RemoveAccessContext satisfies osin.ContextStorage. The refresh token of
+// the grant is removed too.
+func (s *Storage) RemoveAccessContext(ctx context.Context, token string) error {
+	_, err := s.API.DeleteItem(ctx, &ddb.DeleteItemInput{TableName: &s.Table, Key: key(PREFIX_ACCESS + token)})
+	return err
+}
+
+// RemoveAccess satisfies osin.Storage
+func (s *Storage) RemoveAccess(token string) error {
+	return s.RemoveAccessContext(context.Background(), token)
+}
+
+// refreshItem looks up the grant of a refresh token in REFRESH_INDEX.
+// Global secondary indexes are eventually consistent: a refresh token may
+// not be found for a moment after it was saved, and may still be found
+// after it was rotated or removed, so the grant is read again consistently
+// and must still have the token.
+func (s *Storage) refreshItem(ctx context.Context, token string) (map[string]types.AttributeValue, error) {
+	out, err := s.API.Query(ctx, &ddb.QueryInput{
+		TableName:                &s.Table,
+		IndexName:                strPtr(REFRESH_INDEX),
+		KeyConditionExpression:   strPtr("#refresh = :token"),
+		ExpressionAttributeNames: map[string]string{"#refresh": ATTR_REFRESH},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":token": &types.AttributeValueMemberS{Value: token},
+		},
+		Limit: int32Ptr(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Items) == 0 {
+		return nil, osin.ErrNotFound
+	}
+
+	got, err := s.API.GetItem(ctx, &ddb.GetItemInput{
+		TableName:      &s.Table,
+		Key:            key(stringAttr(out.Items[0], ATTR_PK)),
+		ConsistentRead: boolPtr(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if got.Item == nil || stringAttr(got.Item, ATTR_REFRESH) != token {
+		return nil, osin.ErrNotFound
+	}
+	return got.Item, nil
+}
+
+// LoadRefreshContext satisfies osin.ContextStorage
+func (s *Storage) LoadRefreshContext(ctx context.Context, token string) (*osin.AccessData, error) {
+	item, err := s.refreshItem(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return s.decodeAccess(ctx, item)
+}
+
+// LoadRefresh satisfies osin.Storage
+func (s *Storage) LoadRefresh(token string) (*osin.AccessData, error) {
+	return s.LoadRefreshContext(context.Background(), token)
+}
+
+// RemoveRefreshContext satisfies osin.ContextStorage. The access token of
+// the grant is kept.
+func (s *Storage) RemoveRefreshContext(ctx context.Context, token string) error {
+	item, err := s.refreshItem(ctx, token)
+	if err == osin.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = s.API.UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:                &s.Table,
+		Key:                      key(stringAttr(item, ATTR_PK)),
+		UpdateExpression:         strPtr("REMOVE #refresh"),
+		ConditionExpression:      strPtr("#refresh = :token"),
+		ExpressionAttributeNames: map[string]string{"#refresh": ATTR_REFRESH},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":token": &types.AttributeValueMemberS{Value: token},
+		},
+	})
+	if isConditionFailed(err) {
+		// removed or rotated concurrently
+		return nil
+	}
+	return err
+}
+
+// RemoveRefresh satisfies osin.Storage
+func (s *Storage) RemoveRefresh(token string) error {
+	return s.RemoveRefreshContext(context.Background(), token)
+}
+
+// AccessTokensByClient returns the access tokens issued to a client, from
+// CLIENT_INDEX, to revoke them or to audit the client
+func (s *Storage) AccessTokensByClient(ctx context.Context, clientId string) ([]string, error) {
+	var ret []string
+	input := &ddb.QueryInput{
+		TableName:                &s.Table,
+		IndexName:                strPtr(CLIENT_INDEX),
+		KeyConditionExpression:   strPtr("#client = :client"),
+		ExpressionAttributeNames: map[string]string{"#client": ATTR_CLIENT},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":client": &types.AttributeValueMemberS{Value: clientId},
+		},
+	}
+	for {
+		out, err := s.API.Query(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range out.Items {
+			if pk := stringAttr(item, ATTR_PK); strings.HasPrefix(pk, PREFIX_ACCESS) {
+				ret = append(ret, strings.TrimPrefix(pk, PREFIX_ACCESS))
+			}
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return ret, nil
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+func (s *Storage) decodeAccess(ctx context.Context, item map[string]types.AttributeValue) (*osin.AccessData, error) {
+	data := &osin.AccessData{}
+	if err := s.decode(ctx, item, data, &data.Client, &data.UserData); err != nil {
+		return nil, err
+	}
+	// the refresh token may have been removed from the grant
+	data.RefreshToken = stringAttr(item, ATTR_REFRESH)
+	return data, nil
+}
+
+// decode unmarshals the data of an item into v, loading its client and
+// decoding its user data, bound to the partition key
+func (s *Storage) decode(ctx context.Context, item map[string]types.AttributeValue, v interface{}, client *osin.Client, userData *interface{}) error {
+	data, ok := item[ATTR_DATA].(*types.AttributeValueMemberB)
+	if !ok {
+		return osin.ErrNotFound
+	}
+	if err := json.Unmarshal(data.Value, v); err != nil {
+		return err
+	}
+	if ud, ok := item[ATTR_USERDATA].(*types.AttributeValueMemberB); ok {
+		if err := osin.DecodeBoundUserData(s.codec(), ud.Value, userData, []byte(stringAttr(item, ATTR_PK))); err != nil {
+			return err
+		}
+	}
+	c, err := s.GetClientContext(ctx, stringAttr(item, ATTR_CLIENT))
+	if err != nil {
+		return err
+	}
+	*client = c
+	return nil
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
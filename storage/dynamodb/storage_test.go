@@ -0,0 +1,218 @@
+package dynamodb
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/RangelReale/osin/osintest/conformance"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// fakeAPI is an in-memory table understanding the expressions of the storage
+type fakeAPI struct {
+	mu    sync.Mutex
+	items map[string]map[string]types.AttributeValue
+
+	// stale items of the indexes, if set, for their eventual consistency
+	stale map[string]map[string]types.AttributeValue
+}
+
+func newFakeAPI() *fakeAPI {
+	return &fakeAPI{items: make(map[string]map[string]types.AttributeValue)}
+}
+
+func (f *fakeAPI) GetItem(ctx context.Context, params *ddb.GetItemInput, optFns ...func(*ddb.Options)) (*ddb.GetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &ddb.GetItemOutput{Item: f.items[stringAttr(params.Key, ATTR_PK)]}, nil
+}
+
+func (f *fakeAPI) PutItem(ctx context.Context, params *ddb.PutItemInput, optFns ...func(*ddb.Options)) (*ddb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.items[stringAttr(params.Item, ATTR_PK)] = params.Item
+	return &ddb.PutItemOutput{}, nil
+}
+
+func (f *fakeAPI) UpdateItem(ctx context.Context, params *ddb.UpdateItemInput, optFns ...func(*ddb.Options)) (*ddb.UpdateItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pk := stringAttr(params.Key, ATTR_PK)
+	item, ok := f.items[pk]
+	switch *params.UpdateExpression {
+	case "SET #consumed = :true":
+		if !ok || item[ATTR_CONSUMED] != nil {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+		updated := copyItem(item)
+		updated[ATTR_CONSUMED] = params.ExpressionAttributeValues[":true"]
+		f.items[pk] = updated
+		return &ddb.UpdateItemOutput{Attributes: updated}, nil
+	case "REMOVE #refresh":
+		if !ok || stringAttr(item, ATTR_REFRESH) != stringAttr(params.ExpressionAttributeValues, ":token") {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+		updated := copyItem(item)
+		delete(updated, ATTR_REFRESH)
+		f.items[pk] = updated
+		return &ddb.UpdateItemOutput{}, nil
+	}
+	panic("unexpected update " + *params.UpdateExpression)
+}
+
+func (f *fakeAPI) DeleteItem(ctx context.Context, params *ddb.DeleteItemInput, optFns ...func(*ddb.Options)) (*ddb.DeleteItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.items, stringAttr(params.Key, ATTR_PK))
+	return &ddb.DeleteItemOutput{}, nil
+}
+
+func (f *fakeAPI) Query(ctx context.Context, params *ddb.QueryInput, optFns ...func(*ddb.Options)) (*ddb.QueryOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	attr := ATTR_REFRESH
+	value := stringAttr(params.ExpressionAttributeValues, ":token")
+	if *params.IndexName == CLIENT_INDEX {
+		attr, value = ATTR_CLIENT, stringAttr(params.ExpressionAttributeValues, ":client")
+	}
+	items := f.items
+	if f.stale != nil {
+		items = f.stale
+	}
+	out := &ddb.QueryOutput{}
+	for _, item := range items {
+		if stringAttr(item, attr) == value {
+			out.Items = append(out.Items, item)
+		}
+	}
+	return out, nil
+}
+
+func copyItem(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	ret := make(map[string]types.AttributeValue, len(item))
+	for k, v := range item {
+		ret[k] = v
+	}
+	return ret
+}
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+	storage := New(newFakeAPI(), "osin")
+	client := &osin.DefaultClient{Id: "1234", Secret: "aabbccdd", RedirectUri: "http://localhost:14000/appauth"}
+	if err := storage.SetClient(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	code := &osin.AuthorizeData{
+		Client:    client,
+		Code:      "9999",
+		ExpiresIn: 60,
+		Scope:     "everything",
+		CreatedAt: now,
+		UserData:  map[string]interface{}{"login": "test"},
+	}
+	if err := storage.SaveAuthorize(code); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := storage.LoadAuthorize("9999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Client.GetSecret() != "aabbccdd" || loaded.Scope != "everything" || !loaded.CreatedAt.Equal(now) {
+		t.Fatalf("Unexpected authorize data %+v", loaded)
+	}
+	if loaded.UserData.(map[string]interface{})["login"] != "test" {
+		t.Fatalf("Unexpected user data %v", loaded.UserData)
+	}
+	if _, err = storage.LoadAuthorize("9999"); err != osin.ErrNotFound {
+		t.Fatalf("A code must be loaded once, got %v", err)
+	}
+
+	access := &osin.AccessData{
+		Client:          client,
+		AccessToken:     "a9999",
+		RefreshToken:    "r9999",
+		ExpiresIn:       3600,
+		RefreshExpireIn: 86400,
+		CreatedAt:       now,
+	}
+	if err = storage.SaveAccess(access); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := storage.LoadRefresh("r9999"); err != nil || data.AccessToken != "a9999" {
+		t.Fatalf("Unexpected refresh %v, %v", data, err)
+	}
+	if tokens, err := storage.AccessTokensByClient(ctx, "1234"); err != nil || len(tokens) != 1 || tokens[0] != "a9999" {
+		t.Fatalf("Unexpected client tokens %v, %v", tokens, err)
+	}
+
+	if err = storage.RemoveRefresh("r9999"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = storage.LoadRefresh("r9999"); err != osin.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+	data, err := storage.LoadAccess("a9999")
+	if err != nil || data.RefreshToken != "" {
+		t.Fatalf("Unexpected access %v, %v", data, err)
+	}
+
+	if err = storage.RemoveAccess("a9999"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = storage.LoadAccess("a9999"); err != osin.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestStaleRefreshIndex(t *testing.T) {
+	api := newFakeAPI()
+	storage := New(api, "osin")
+	client := &osin.DefaultClient{Id: "1234", Secret: "aabbccdd", RedirectUri: "http://localhost:14000/appauth"}
+	if err := storage.SetClient(context.Background(), client); err != nil {
+		t.Fatal(err)
+	}
+	access := &osin.AccessData{
+		Client:          client,
+		AccessToken:     "a9999",
+		RefreshToken:    "r9999",
+		ExpiresIn:       3600,
+		RefreshExpireIn: 86400,
+		CreatedAt:       time.Now(),
+	}
+	if err := storage.SaveAccess(access); err != nil {
+		t.Fatal(err)
+	}
+
+	// the index still has the refresh token after it was removed
+	api.stale = make(map[string]map[string]types.AttributeValue)
+	for k, v := range api.items {
+		api.stale[k] = v
+	}
+	if err := storage.RemoveRefresh("r9999"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.LoadRefresh("r9999"); err != osin.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+	if err := storage.RemoveRefresh("r9999"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	conformance.Test(t, conformance.Target{
+		NewStorage: func() (osin.Storage, error) {
+			return New(newFakeAPI(), "osin"), nil
+		},
+		SetClient: func(storage osin.Storage, client osin.Client) error {
+			return storage.(*Storage).SetClient(context.Background(), client)
+		},
+	})
+}
@@ -0,0 +1,345 @@
+// Package etcd is an osin storage over etcd, for small highly available
+// clusters already running it. Codes and tokens are attached to leases
+// matching their lifetime, so etcd removes them when they expire.
+//
+// Keys, under the storage prefix:
+//
+//	clients/<id>     client, as an osin.DefaultClient
+//	codes/<code>     authorization code
+//	access/<token>   access token grant
+//	refresh/<token>  access token of a refresh token
+//
+// UserData is stored in plaintext JSON by default. To encrypt it with the
+// osin Config.UserDataKeys, set the codec of the configuration:
+//
+//	storage := etcd.New(client, "/osin/")
+//	if storage.UserDataCodec, err = config.UserDataCodec(); err != nil {
+//		return err
+//	}
+//
+// Encrypted UserData is bound to its key, without the storage prefix.
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/RangelReale/osin"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// Key spaces under the storage prefix
+const (
+	KEY_CLIENTS = "clients/"
+	KEY_CODES   = "codes/"
+	KEY_ACCESS  = "access/"
+	KEY_REFRESH = "refresh/"
+)
+
+// Storage is an osin.Storage and osin.ContextStorage over etcd
+type Storage struct {
+	KV    clientv3.KV
+	Lease clientv3.Lease
+
+	// Prefix of all the keys, like "/osin/"
+	Prefix string
+
+	// Serializes the UserData of the grants - default osin.JSONUserDataCodec,
+	// in plaintext. Set it to ServerConfig.UserDataCodec to encrypt it.
+	UserDataCodec osin.UserDataCodec
+
+	// Returns the current time, to compute the lease TTLs - default time.Now
+	Now func() time.Time
+}
+
+// New creates a storage using the client, under the key prefix
+func New(client *clientv3.Client, prefix string) *Storage {
+	return &Storage{
+		KV:            client.KV,
+		Lease:         client.Lease,
+		Prefix:        prefix,
+		UserDataCodec: osin.JSONUserDataCodec{},
+	}
+}
+
+// record is the stored form of a grant
+type record struct {
+	ClientId string
+	Data     json.RawMessage
+	UserData []byte `json:",omitempty"`
+}
+
+// Clone returns the storage itself, the etcd client is safe for
+// concurrent use
+func (s *Storage) Clone() osin.Storage {
+	return s
+}
+
+// Close does nothing, the etcd client is closed by its owner
+func (s *Storage) Close() {
+}
+
+func (s *Storage) codec() osin.UserDataCodec {
+	if s.UserDataCodec == nil {
+		return osin.JSONUserDataCodec{}
+	}
+	return s.UserDataCodec
+}
+
+func (s *Storage) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// lease returns the put option attaching a key to a lease expiring at
+// expireAt, none if expireAt is zero
+func (s *Storage) lease(ctx context.Context, expireAt time.Time) ([]clientv3.OpOption, error) {
+	if expireAt.IsZero() {
+		return nil, nil
+	}
+	ttl := int64(expireAt.Sub(s.now()) / time.Second)
+	if ttl < 1 {
+		ttl = 1
+	}
+	resp, err := s.Lease.Grant(ctx, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return []clientv3.OpOption{clientv3.WithLease(resp.ID)}, nil
+}
+
+// encode stores v with its client id and the encoded user data, bound to
+// the key
+func (s *Storage) encode(key, clientId string, v interface{}, userData interface{}) (string, error) {
+	rec := record{ClientId: clientId}
+	var err error
+	if rec.Data, err = json.Marshal(v); err != nil {
+		return "", err
+	}
+	if userData != nil {
+		if rec.UserData, err = osin.EncodeBoundUserData(s.codec(), userData, []byte(key)); err != nil {
+			return "", err
+		}
+	}
+	b, err := json.Marshal(&rec)
+	return string(b), err
+}
+
+// decode unmarshals a stored grant of the key into v, loading its client
+// and decoding its user data
+func (s *Storage) decode(ctx context.Context, key string, b []byte, v interface{}, client *osin.Client, userData *interface{}) error {
+	var rec record
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(rec.Data, v); err != nil {
+		return err
+	}
+	if len(rec.UserData) > 0 {
+		if err := osin.DecodeBoundUserData(s.codec(), rec.UserData, userData, []byte(key)); err != nil {
+			return err
+		}
+	}
+	c, err := s.GetClientContext(ctx, rec.ClientId)
+	if err != nil {
+		return err
+	}
+	*client = c
+	return nil
+}
+
+// get returns the value of a key, osin.ErrNotFound if missing
+func (s *Storage) get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.KV.Get(ctx, s.Prefix+key)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, osin.ErrNotFound
+	}
+	return resp.Kvs[0].Value, nil
+}
+
+// SetClient saves a client, as an osin.DefaultClient
+func (s *Storage) SetClient(ctx context.Context, client osin.Client) error {
+	c := &osin.DefaultClient{}
+	c.CopyFrom(client)
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = s.KV.Put(ctx, s.Prefix+KEY_CLIENTS+c.Id, string(b))
+	return err
+}
+
+// RemoveClient removes a client
+func (s *Storage) RemoveClient(ctx context.Context, id string) error {
+	_, err := s.KV.Delete(ctx, s.Prefix+KEY_CLIENTS+id)
+	return err
+}
+
+// GetClientContext satisfies osin.ContextStorage
+func (s *Storage) GetClientContext(ctx context.Context, id string) (osin.Client, error) {
+	b, err := s.get(ctx, KEY_CLIENTS+id)
+	if err != nil {
+		return nil, err
+	}
+	c := &osin.DefaultClient{}
+	if err = json.Unmarshal(b, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetClient satisfies osin.Storage
+func (s *Storage) GetClient(id string) (osin.Client, error) {
+	return s.GetClientContext(context.Background(), id)
+}
+
+// SaveAuthorizeContext satisfies osin.ContextStorage
+func (s *Storage) SaveAuthorizeContext(ctx context.Context, data *osin.AuthorizeData) error {
+	rec := *data
+	rec.Client, rec.UserData = nil, nil
+	value, err := s.encode(KEY_CODES+data.Code, data.Client.GetID(), &rec, data.UserData)
+	if err != nil {
+		return err
+	}
+	opts, err := s.lease(ctx, data.ExpireAt())
+	if err != nil {
+		return err
+	}
+	_, err = s.KV.Put(ctx, s.Prefix+KEY_CODES+data.Code, value, opts...)
+	return err
+}
+
+// SaveAuthorize satisfies osin.Storage
+func (s *Storage) SaveAuthorize(data *osin.AuthorizeData) error {
+	return s.SaveAuthorizeContext(context.Background(), data)
+}
+
+// LoadAuthorizeContext satisfies osin.ContextStorage. The code is deleted
+// as it is loaded, atomically, so it can only be exchanged once.
+func (s *Storage) LoadAuthorizeContext(ctx context.Context, code string) (*osin.AuthorizeData, error) {
+	resp, err := s.KV.Delete(ctx, s.Prefix+KEY_CODES+code, clientv3.WithPrevKV())
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.PrevKvs) == 0 {
+		return nil, osin.ErrNotFound
+	}
+	data := &osin.AuthorizeData{}
+	if err = s.decode(ctx, KEY_CODES+code, resp.PrevKvs[0].Value, data, &data.Client, &data.UserData); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// LoadAuthorize satisfies osin.Storage
+func (s *Storage) LoadAuthorize(code string) (*osin.AuthorizeData, error) {
+	return s.LoadAuthorizeContext(context.Background(), code)
+}
+
+// RemoveAuthorizeContext satisfies osin.ContextStorage
+func (s *Storage) RemoveAuthorizeContext(ctx context.Context, code string) error {
+	_, err := s.KV.Delete(ctx, s.Prefix+KEY_CODES+code)
+	return err
+}
+
+// RemoveAuthorize satisfies osin.Storage
+func (s *Storage) RemoveAuthorize(code string) error {
+	return s.RemoveAuthorizeContext(context.Background(), code)
+}
+
+// SaveAccessContext satisfies osin.ContextStorage. The access and refresh
+// tokens are written in a single transaction, sharing a lease.
+func (s *Storage) SaveAccessContext(ctx context.Context, data *osin.AccessData) error {
+	rec := *data
+	rec.Client, rec.UserData, rec.AuthorizeData, rec.AccessData = nil, nil, nil, nil
+	value, err := s.encode(KEY_ACCESS+data.AccessToken, data.Client.GetID(), &rec, data.UserData)
+	if err != nil {
+		return err
+	}
+	// the grant lives as long as its longest lived token
+	expireAt := data.ExpireAt()
+	if data.RefreshToken != "" {
+		if data.RefreshExpireIn > 0 {
+			if refreshAt := data.CreatedAt.Add(time.Duration(data.RefreshExpireIn) * time.Second); refreshAt.After(expireAt) {
+				expireAt = refreshAt
+			}
+		} else {
+			expireAt = time.Time{}
+		}
+	}
+	opts, err := s.lease(ctx, expireAt)
+	if err != nil {
+		return err
+	}
+	ops := []clientv3.Op{clientv3.OpPut(s.Prefix+KEY_ACCESS+data.AccessToken, value, opts...)}
+	if data.RefreshToken != "" {
+		ops = append(ops, clientv3.OpPut(s.Prefix+KEY_REFRESH+data.RefreshToken, data.AccessToken, opts...))
+	}
+	_, err = s.KV.Txn(ctx).Then(ops...).Commit()
+	return err
+}
+
+// SaveAccess satisfies osin.Storage
+func (s *Storage) SaveAccess(data *osin.AccessData) error {
+	return s.SaveAccessContext(context.Background(), data)
+}
+
+// LoadAccessContext satisfies osin.ContextStorage
+func (s *Storage) LoadAccessContext(ctx context.Context, token string) (*osin.AccessData, error) {
+	b, err := s.get(ctx, KEY_ACCESS+token)
+	if err != nil {
+		return nil, err
+	}
+	data := &osin.AccessData{}
+	if err = s.decode(ctx, KEY_ACCESS+token, b, data, &data.Client, &data.UserData); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// LoadAccess satisfies osin.Storage
+func (s *Storage) LoadAccess(token string) (*osin.AccessData, error) {
+	return s.LoadAccessContext(context.Background(), token)
+}
+
+// RemoveAccessContext satisfies osin.ContextStorage
+func (s *Storage) RemoveAccessContext(ctx context.Context, token string) error {
+	_, err := s.KV.Delete(ctx, s.Prefix+KEY_ACCESS+token)
+	return err
+}
+
+// RemoveAccess satisfies osin.Storage
+func (s *Storage) RemoveAccess(token string) error {
+	return s.RemoveAccessContext(context.Background(), token)
+}
+
+// LoadRefreshContext satisfies osin.ContextStorage
+func (s *Storage) LoadRefreshContext(ctx context.Context, token string) (*osin.AccessData, error) {
+	access, err := s.get(ctx, KEY_REFRESH+token)
+	if err != nil {
+		return nil, err
+	}
+	return s.LoadAccessContext(ctx, string(access))
+}
+
+// LoadRefresh satisfies osin.Storage
+func (s *Storage) LoadRefresh(token string) (*osin.AccessData, error) {
+	return s.LoadRefreshContext(context.Background(), token)
+}
+
+// RemoveRefreshContext satisfies osin.ContextStorage
+func (s *Storage) RemoveRefreshContext(ctx context.Context, token string) error {
+	_, err := s.KV.Delete(ctx, s.Prefix+KEY_REFRESH+token)
+	return err
+}
+
+// RemoveRefresh satisfies osin.Storage
+func (s *Storage) RemoveRefresh(token string) error {
+	return s.RemoveRefreshContext(context.Background(), token)
+}
@@ -0,0 +1,185 @@
+package etcd
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/RangelReale/osin/osintest/conformance"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// fakeKV is an in-memory etcd, with leases granted but never expiring
+type fakeKV struct {
+	clientv3.KV
+	clientv3.Lease
+
+	mu     sync.Mutex
+	values map[string]string
+	leases int
+}
+
+func newFakeKV() *fakeKV {
+	return &fakeKV{values: make(map[string]string)}
+}
+
+func (f *fakeKV) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.values[key] = val
+	return &clientv3.PutResponse{}, nil
+}
+
+func (f *fakeKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp := &clientv3.GetResponse{}
+	if v, ok := f.values[key]; ok {
+		resp.Kvs = []*mvccpb.KeyValue{{Key: []byte(key), Value: []byte(v)}}
+	}
+	return resp, nil
+}
+
+func (f *fakeKV) Delete(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp := &clientv3.DeleteResponse{}
+	if v, ok := f.values[key]; ok {
+		resp.PrevKvs = []*mvccpb.KeyValue{{Key: []byte(key), Value: []byte(v)}}
+		delete(f.values, key)
+	}
+	return resp, nil
+}
+
+func (f *fakeKV) Txn(ctx context.Context) clientv3.Txn {
+	return &fakeTxn{kv: f}
+}
+
+func (f *fakeKV) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.leases++
+	return &clientv3.LeaseGrantResponse{ID: clientv3.LeaseID(f.leases), TTL: ttl}, nil
+}
+
+// fakeTxn applies the puts of Then unconditionally
+type fakeTxn struct {
+	clientv3.Txn
+	kv  *fakeKV
+	ops []clientv3.Op
+}
+
+func (t *fakeTxn) Then(ops ...clientv3.Op) clientv3.Txn {
+	t.ops = append(t.ops, ops...)
+	return t
+}
+
+func (t *fakeTxn) Commit() (*clientv3.TxnResponse, error) {
+	t.kv.mu.Lock()
+	defer t.kv.mu.Unlock()
+	for _, op := range t.ops {
+		if op.IsPut() {
+			t.kv.values[string(op.KeyBytes())] = string(op.ValueBytes())
+		}
+	}
+	return &clientv3.TxnResponse{Succeeded: true}, nil
+}
+
+func newTestStorage(kv *fakeKV) *Storage {
+	return &Storage{KV: kv, Lease: kv, Prefix: "/osin/", UserDataCodec: osin.JSONUserDataCodec{}}
+}
+
+func TestStorage(t *testing.T) {
+	kv := newFakeKV()
+	storage := newTestStorage(kv)
+	client := &osin.DefaultClient{Id: "1234", Secret: "aabbccdd", RedirectUri: "http://localhost:14000/appauth"}
+	if err := storage.SetClient(context.Background(), client); err != nil {
+		t.Fatal(err)
+	}
+
+	code := &osin.AuthorizeData{Client: client, Code: "9999", ExpiresIn: 60, CreatedAt: time.Now(), UserData: "test"}
+	if err := storage.SaveAuthorize(code); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := storage.LoadAuthorize("9999")
+	if err != nil || loaded.Client.GetSecret() != "aabbccdd" || loaded.UserData != "test" {
+		t.Fatalf("Unexpected authorize data %+v, %v", loaded, err)
+	}
+	if _, err = storage.LoadAuthorize("9999"); err != osin.ErrNotFound {
+		t.Fatalf("A code must be loaded once, got %v", err)
+	}
+
+	access := &osin.AccessData{Client: client, AccessToken: "a9999", RefreshToken: "r9999", ExpiresIn: 3600, CreatedAt: time.Now()}
+	if err = storage.SaveAccess(access); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := kv.values["/osin/"+KEY_REFRESH+"r9999"]; !ok {
+		t.Fatal("Refresh token not saved under the prefix")
+	}
+	if data, err := storage.LoadRefresh("r9999"); err != nil || data.AccessToken != "a9999" {
+		t.Fatalf("Unexpected refresh %v, %v", data, err)
+	}
+	if err = storage.RemoveAccess("a9999"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = storage.LoadRefresh("r9999"); err != osin.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+
+	// only the code has a lease, the refresh token never expires
+	if kv.leases != 1 {
+		t.Fatalf("Expected 1 lease, got %d", kv.leases)
+	}
+	for k := range kv.values {
+		if !strings.HasPrefix(k, "/osin/") {
+			t.Fatalf("Key %s outside the prefix", k)
+		}
+	}
+}
+
+func TestStorageEncryptedUserData(t *testing.T) {
+	kv := newFakeKV()
+	storage := newTestStorage(kv)
+	config := osin.NewServerConfig()
+	config.UserDataKeys = [][]byte{[]byte(strings.Repeat("k", 32))}
+	var err error
+	if storage.UserDataCodec, err = config.UserDataCodec(); err != nil {
+		t.Fatal(err)
+	}
+	client := &osin.DefaultClient{Id: "1234", Secret: "aabbccdd", RedirectUri: "http://localhost:14000/appauth"}
+	if err = storage.SetClient(context.Background(), client); err != nil {
+		t.Fatal(err)
+	}
+
+	access := &osin.AccessData{Client: client, AccessToken: "a1", ExpiresIn: 3600, CreatedAt: time.Now(), UserData: "secret"}
+	if err = storage.SaveAccess(access); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(kv.values["/osin/"+KEY_ACCESS+"a1"], "secret") {
+		t.Fatal("User data should be encrypted")
+	}
+	if data, err := storage.LoadAccess("a1"); err != nil || data.UserData != "secret" {
+		t.Fatalf("Unexpected access data %+v, %v", data, err)
+	}
+
+	// user data moved to another token doesn't decrypt
+	kv.values["/osin/"+KEY_ACCESS+"a2"] = kv.values["/osin/"+KEY_ACCESS+"a1"]
+	if _, err = storage.LoadAccess("a2"); err != osin.ErrUserDataDecrypt {
+		t.Fatalf("Expected decryption failure, got %v", err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	conformance.Test(t, conformance.Target{
+		NewStorage: func() (osin.Storage, error) {
+			return newTestStorage(newFakeKV()), nil
+		},
+		SetClient: func(storage osin.Storage, client osin.Client) error {
+			return storage.(*Storage).SetClient(context.Background(), client)
+		},
+	})
+}
@@ -0,0 +1,241 @@
+package osin
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrStorageUnavailable is returned by BreakerStorage while its circuit is
+// open. See ServerConfig.StorageUnavailableRetryAfter to answer these
+// requests with temporarily_unavailable.
+var ErrStorageUnavailable = errors.New("storage unavailable")
+
+// BreakerStorageOptions configures a BreakerStorage
+type BreakerStorageOptions struct {
+	// Consecutive failures opening the circuit (default 5), and the time it
+	// stays open before trying the storage again (default 10 seconds)
+	FailureThreshold int
+	OpenDuration     time.Duration
+
+	// Maximum number of access data kept from the successful LoadAccess
+	// calls, served while the circuit is open so bearer validation keeps
+	// working. Disabled if 0 (the default).
+	StaleAccessEntries int
+
+	// Maximum age of the access data served while the circuit is open.
+	// Revocations made meanwhile by other processes aren't seen. Default 5
+	// minutes.
+	StaleAccessTTL time.Duration
+
+	// Time source, time.Now if nil
+	Now func() time.Time
+}
+
+// BreakerStorage is a Storage decorator failing fast with
+// ErrStorageUnavailable after repeated storage failures, instead of piling
+// up requests on an unavailable storage. ErrNotFound and ErrAlreadyExists
+// are not failures. The calls of the optional interfaces of the inner
+// storage, found through Unwrap, are not guarded by the circuit.
+type BreakerStorage struct {
+	Storage
+	state *breakerState
+}
+
+// breakerState is shared between a BreakerStorage and its clones
+type breakerState struct {
+	opts BreakerStorageOptions
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	stale     *lruCache
+}
+
+// NewBreakerStorage creates a circuit breaker decorator for the inner storage
+func NewBreakerStorage(inner Storage, opts BreakerStorageOptions) *BreakerStorage {
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = 5
+	}
+	if opts.OpenDuration <= 0 {
+		opts.OpenDuration = 10 * time.Second
+	}
+	if opts.StaleAccessTTL <= 0 {
+		opts.StaleAccessTTL = 5 * time.Minute
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	state := &breakerState{opts: opts}
+	if opts.StaleAccessEntries > 0 {
+		state.stale = newLRUCache(opts.StaleAccessEntries, opts.StaleAccessTTL, opts.Now)
+	}
+	return &BreakerStorage{
+		Storage: inner,
+		state:   state,
+	}
+}
+
+// Unwrap returns the inner storage
+func (s *BreakerStorage) Unwrap() Storage {
+	return s.Storage
+}
+
+// Clone clones the inner storage, sharing the circuit
+func (s *BreakerStorage) Clone() Storage {
+	return &BreakerStorage{
+		Storage: s.Storage.Clone(),
+		state:   s.state,
+	}
+}
+
+// IsOpen returns true while the circuit is open
+func (s *BreakerStorage) IsOpen() bool {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+	return s.state.opts.Now().Before(s.state.openUntil)
+}
+
+// call runs f unless the circuit is open, recording its outcome
+func (s *BreakerStorage) call(f func() error) error {
+	if s.IsOpen() {
+		return ErrStorageUnavailable
+	}
+	err := f()
+
+	st := s.state
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if err == nil || err == ErrNotFound || err == ErrAlreadyExists {
+		st.failures = 0
+		return err
+	}
+	st.failures++
+	if st.failures >= st.opts.FailureThreshold {
+		st.openUntil = st.opts.Now().Add(st.opts.OpenDuration)
+		st.failures = 0
+	}
+	return err
+}
+
+func (s *BreakerStorage) GetClient(id string) (Client, error) {
+	return s.GetClientContext(context.Background(), id)
+}
+
+// GetClientContext satisfies ContextStorage
+func (s *BreakerStorage) GetClientContext(ctx context.Context, id string) (c Client, err error) {
+	err = s.call(func() error {
+		c, err = storageGetClient(ctx, s.Storage, id)
+		return err
+	})
+	return c, err
+}
+
+func (s *BreakerStorage) SaveAuthorize(data *AuthorizeData) error {
+	return s.SaveAuthorizeContext(context.Background(), data)
+}
+
+// SaveAuthorizeContext satisfies ContextStorage
+func (s *BreakerStorage) SaveAuthorizeContext(ctx context.Context, data *AuthorizeData) error {
+	return s.call(func() error { return storageSaveAuthorize(ctx, s.Storage, data) })
+}
+
+func (s *BreakerStorage) LoadAuthorize(code string) (*AuthorizeData, error) {
+	return s.LoadAuthorizeContext(context.Background(), code)
+}
+
+// LoadAuthorizeContext satisfies ContextStorage
+func (s *BreakerStorage) LoadAuthorizeContext(ctx context.Context, code string) (d *AuthorizeData, err error) {
+	err = s.call(func() error {
+		d, err = storageLoadAuthorize(ctx, s.Storage, code)
+		return err
+	})
+	return d, err
+}
+
+func (s *BreakerStorage) RemoveAuthorize(code string) error {
+	return s.RemoveAuthorizeContext(context.Background(), code)
+}
+
+// RemoveAuthorizeContext satisfies ContextStorage
+func (s *BreakerStorage) RemoveAuthorizeContext(ctx context.Context, code string) error {
+	return s.call(func() error { return storageRemoveAuthorize(ctx, s.Storage, code) })
+}
+
+func (s *BreakerStorage) SaveAccess(data *AccessData) error {
+	return s.SaveAccessContext(context.Background(), data)
+}
+
+// SaveAccessContext satisfies ContextStorage
+func (s *BreakerStorage) SaveAccessContext(ctx context.Context, data *AccessData) error {
+	return s.call(func() error { return storageSaveAccess(ctx, s.Storage, data) })
+}
+
+// LoadAccess loads the access data from the inner storage, or from the
+// stale entries while the circuit is open
+func (s *BreakerStorage) LoadAccess(token string) (*AccessData, error) {
+	return s.LoadAccessContext(context.Background(), token)
+}
+
+// LoadAccessContext satisfies ContextStorage
+func (s *BreakerStorage) LoadAccessContext(ctx context.Context, token string) (d *AccessData, err error) {
+	err = s.call(func() error {
+		d, err = storageLoadAccess(ctx, s.Storage, token)
+		return err
+	})
+
+	st := s.state
+	if st.stale == nil {
+		return d, err
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	switch {
+	case err == nil && d != nil:
+		st.stale.add(token, d)
+	case err == ErrNotFound:
+		st.stale.remove(token)
+	case err == ErrStorageUnavailable:
+		if v, ok := st.stale.get(token); ok {
+			return v.(*AccessData), nil
+		}
+	}
+	return d, err
+}
+
+func (s *BreakerStorage) RemoveAccess(token string) error {
+	return s.RemoveAccessContext(context.Background(), token)
+}
+
+// RemoveAccessContext satisfies ContextStorage
+func (s *BreakerStorage) RemoveAccessContext(ctx context.Context, token string) error {
+	if s.state.stale != nil {
+		s.state.mu.Lock()
+		s.state.stale.remove(token)
+		s.state.mu.Unlock()
+	}
+	return s.call(func() error { return storageRemoveAccess(ctx, s.Storage, token) })
+}
+
+func (s *BreakerStorage) LoadRefresh(token string) (*AccessData, error) {
+	return s.LoadRefreshContext(context.Background(), token)
+}
+
+// LoadRefreshContext satisfies ContextStorage
+func (s *BreakerStorage) LoadRefreshContext(ctx context.Context, token string) (d *AccessData, err error) {
+	err = s.call(func() error {
+		d, err = storageLoadRefresh(ctx, s.Storage, token)
+		return err
+	})
+	return d, err
+}
+
+func (s *BreakerStorage) RemoveRefresh(token string) error {
+	return s.RemoveRefreshContext(context.Background(), token)
+}
+
+// RemoveRefreshContext satisfies ContextStorage
+func (s *BreakerStorage) RemoveRefreshContext(ctx context.Context, token string) error {
+	return s.call(func() error { return storageRemoveRefresh(ctx, s.Storage, token) })
+}
@@ -0,0 +1,109 @@
+package osin
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// flakyStorage fails the access loads while down
+type flakyStorage struct {
+	Storage
+	down  bool
+	calls int
+}
+
+func (s *flakyStorage) Clone() Storage {
+	return s
+}
+
+func (s *flakyStorage) LoadAccess(token string) (*AccessData, error) {
+	s.calls++
+	if s.down {
+		return nil, errors.New("connection refused")
+	}
+	return s.Storage.LoadAccess(token)
+}
+
+func TestBreakerStorage(t *testing.T) {
+	now := time.Now()
+	inner := &flakyStorage{Storage: NewTestingStorage()}
+	storage := NewBreakerStorage(inner, BreakerStorageOptions{
+		FailureThreshold:   2,
+		OpenDuration:       time.Minute,
+		StaleAccessEntries: 10,
+		Now:                func() time.Time { return now },
+	})
+
+	if _, err := storage.LoadAccess("9999"); err != nil {
+		t.Fatal(err)
+	}
+
+	// failures open the circuit
+	inner.down = true
+	for i := 0; i < 2; i++ {
+		if _, err := storage.LoadAccess("other"); err == nil || err == ErrStorageUnavailable {
+			t.Fatalf("Expected the storage error, got %v", err)
+		}
+	}
+	if !storage.IsOpen() {
+		t.Fatal("Circuit should be open")
+	}
+	calls := inner.calls
+	if _, err := storage.LoadAccess("other"); err != ErrStorageUnavailable {
+		t.Fatalf("Expected ErrStorageUnavailable, got %v", err)
+	}
+	if inner.calls != calls {
+		t.Error("Storage should not be called while the circuit is open")
+	}
+
+	// recently loaded access data is served while open
+	if d, err := storage.LoadAccess("9999"); err != nil || d.AccessToken != "9999" {
+		t.Fatalf("Stale access data should be served: %v %v", d, err)
+	}
+
+	// requests fail with temporarily_unavailable
+	sconfig := NewServerConfig()
+	sconfig.StorageUnavailableRetryAfter = 30
+	server := NewServer(sconfig, storage)
+	server.Now = func() time.Time { return now }
+	resp := server.NewResponse()
+	req, err := http.NewRequest("GET", "http://localhost:14000/info", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Form = url.Values{"code": {"other"}}
+	if ir := server.HandleInfoRequest(resp, req); ir != nil {
+		server.FinishInfoRequest(resp, req, ir)
+	}
+	w := httptest.NewRecorder()
+	if err := OutputJSON(resp, w, req); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ErrorId != E_TEMPORARILY_UNAVAILABLE || w.Header().Get("Retry-After") != "30" {
+		t.Fatalf("Expected %s with Retry-After, got %v %v", E_TEMPORARILY_UNAVAILABLE, resp.Output, w.Header())
+	}
+
+	// the storage is tried again once the circuit closes
+	inner.down = false
+	now = now.Add(2 * time.Minute)
+	if _, err := storage.LoadAccess("other"); err != ErrNotFound && err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if storage.IsOpen() {
+		t.Error("Circuit should be closed")
+	}
+}
+
+func TestBreakerStorageOptionalInterfaces(t *testing.T) {
+	var storage Storage = NewBreakerStorage(newDeviceTestingStorage(), BreakerStorageOptions{})
+	if _, ok := storageAs[DeviceStorage](storage); !ok {
+		t.Errorf("DeviceStorage of the inner storage should be found")
+	}
+	if _, ok := storage.(ContextStorage); !ok {
+		t.Errorf("BreakerStorage should pass the context through")
+	}
+}
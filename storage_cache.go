@@ -0,0 +1,390 @@
+package osin
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// CachingStorageOptions configures a CachingStorage
+type CachingStorageOptions struct {
+	// Maximum number of entries of each cache (clients, access, refresh).
+	// Least recently used entries are evicted first. Default 10000.
+	MaxEntries int
+
+	// Time an entry is kept in the cache. Default 1 minute.
+	TTL time.Duration
+
+	// Time source, time.Now if nil
+	Now func() time.Time
+}
+
+// CachingStorage is a Storage decorator caching GetClient, LoadAccess and
+// LoadRefresh results in memory, invalidated on Save and Remove calls made
+// through it. Changes made directly on the inner storage are only seen after
+// the TTL, so keep it short if other processes revoke tokens. The request
+// context is passed to the inner storage, and its optional interfaces are
+// found through Unwrap.
+type CachingStorage struct {
+	Storage
+	cache *storageCache
+}
+
+// storageCache is shared between a CachingStorage and its clones
+type storageCache struct {
+	mu      sync.Mutex
+	clients *lruCache
+	access  *lruCache
+	refresh *lruCache
+
+	// refresh token by access token, to invalidate refresh entries on RemoveAccess
+	refreshByAccess map[string]string
+
+	// incremented by each invalidation, so loads racing with a write don't
+	// cache what they read before it
+	gen uint64
+}
+
+// NewCachingStorage creates a caching decorator for the inner storage
+func NewCachingStorage(inner Storage, opts CachingStorageOptions) *CachingStorage {
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = 10000
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = time.Minute
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	c := &storageCache{
+		clients:         newLRUCache(opts.MaxEntries, opts.TTL, opts.Now),
+		access:          newLRUCache(opts.MaxEntries, opts.TTL, opts.Now),
+		refresh:         newLRUCache(opts.MaxEntries, opts.TTL, opts.Now),
+		refreshByAccess: make(map[string]string),
+	}
+	c.refresh.onEvict = func(key string, value interface{}) {
+		if ad, ok := value.(*AccessData); ok && c.refreshByAccess[ad.AccessToken] == key {
+			delete(c.refreshByAccess, ad.AccessToken)
+		}
+	}
+	return &CachingStorage{
+		Storage: inner,
+		cache:   c,
+	}
+}
+
+// Clone clones the inner storage, sharing the cache
+func (s *CachingStorage) Clone() Storage {
+	return &CachingStorage{
+		Storage: s.Storage.Clone(),
+		cache:   s.cache,
+	}
+}
+
+// Unwrap returns the inner storage
+func (s *CachingStorage) Unwrap() Storage {
+	return s.Storage
+}
+
+// GetClient returns the cached client, or loads it from the inner storage
+func (s *CachingStorage) GetClient(id string) (Client, error) {
+	return s.GetClientContext(context.Background(), id)
+}
+
+// GetClientContext satisfies ContextStorage
+func (s *CachingStorage) GetClientContext(ctx context.Context, id string) (Client, error) {
+	s.cache.mu.Lock()
+	v, ok := s.cache.clients.get(id)
+	s.cache.mu.Unlock()
+	if ok {
+		return v.(Client), nil
+	}
+
+	client, err := storageGetClient(ctx, s.Storage, id)
+	if err == nil && client != nil {
+		s.cache.mu.Lock()
+		s.cache.clients.add(id, client)
+		s.cache.mu.Unlock()
+	}
+	return client, err
+}
+
+// InvalidateClient removes the client from the cache, to be called when the
+// client is changed
+func (s *CachingStorage) InvalidateClient(id string) {
+	s.cache.mu.Lock()
+	defer s.cache.mu.Unlock()
+	s.cache.clients.remove(id)
+}
+
+// SaveAuthorizeContext satisfies ContextStorage
+func (s *CachingStorage) SaveAuthorizeContext(ctx context.Context, data *AuthorizeData) error {
+	return storageSaveAuthorize(ctx, s.Storage, data)
+}
+
+// LoadAuthorizeContext satisfies ContextStorage
+func (s *CachingStorage) LoadAuthorizeContext(ctx context.Context, code string) (*AuthorizeData, error) {
+	return storageLoadAuthorize(ctx, s.Storage, code)
+}
+
+// RemoveAuthorizeContext satisfies ContextStorage
+func (s *CachingStorage) RemoveAuthorizeContext(ctx context.Context, code string) error {
+	return storageRemoveAuthorize(ctx, s.Storage, code)
+}
+
+// SaveAccess saves the access data in the inner storage and invalidates it,
+// before and after the write
+func (s *CachingStorage) SaveAccess(data *AccessData) error {
+	return s.SaveAccessContext(context.Background(), data)
+}
+
+// SaveAccessContext satisfies ContextStorage
+func (s *CachingStorage) SaveAccessContext(ctx context.Context, data *AccessData) error {
+	s.invalidateSaved(data)
+	err := storageSaveAccess(ctx, s.Storage, data)
+	s.invalidateSaved(data)
+	return err
+}
+
+func (s *CachingStorage) invalidateSaved(data *AccessData) {
+	s.invalidateAccess(data.AccessToken)
+	if data.RefreshToken != "" {
+		s.invalidateRefresh(data.RefreshToken)
+	}
+}
+
+// LoadAccess returns the cached access data, or loads it from the inner storage
+func (s *CachingStorage) LoadAccess(token string) (*AccessData, error) {
+	return s.LoadAccessContext(context.Background(), token)
+}
+
+// LoadAccessContext satisfies ContextStorage
+func (s *CachingStorage) LoadAccessContext(ctx context.Context, token string) (*AccessData, error) {
+	s.cache.mu.Lock()
+	v, ok := s.cache.access.get(token)
+	gen := s.cache.gen
+	s.cache.mu.Unlock()
+	if ok {
+		return v.(*AccessData), nil
+	}
+
+	data, err := storageLoadAccess(ctx, s.Storage, token)
+	if err == nil && data != nil {
+		s.cache.mu.Lock()
+		if s.cache.gen == gen {
+			s.cache.access.add(token, data)
+		}
+		s.cache.mu.Unlock()
+	}
+	return data, err
+}
+
+// PurgeExpired purges the expired grants of the inner storage, which must
+// implement ExpiringStorage. Cached entries expire on their own.
+func (s *CachingStorage) PurgeExpired(now time.Time) (PurgeResult, error) {
+	es, ok := storageAs[ExpiringStorage](s.Storage)
+	if !ok {
+		return PurgeResult{}, errors.New("inner storage does not implement ExpiringStorage")
+	}
+	return es.PurgeExpired(now)
+}
+
+// LoadAccessBatch returns the cached access data, loading the missing ones
+// from the inner storage, in a single round trip if it implements AccessBatchLoader
+func (s *CachingStorage) LoadAccessBatch(ctx context.Context, tokens []string) ([]*AccessData, error) {
+	ret := make([]*AccessData, len(tokens))
+	var missing []int
+
+	s.cache.mu.Lock()
+	for i, token := range tokens {
+		if v, ok := s.cache.access.get(token); ok {
+			ret[i] = v.(*AccessData)
+		} else {
+			missing = append(missing, i)
+		}
+	}
+	gen := s.cache.gen
+	s.cache.mu.Unlock()
+
+	if len(missing) == 0 {
+		return ret, nil
+	}
+
+	missingTokens := make([]string, len(missing))
+	for i, idx := range missing {
+		missingTokens[i] = tokens[idx]
+	}
+
+	var loaded []*AccessData
+	if loader, ok := s.Storage.(AccessBatchLoader); ok {
+		var err error
+		if loaded, err = loader.LoadAccessBatch(ctx, missingTokens); err != nil {
+			return nil, err
+		}
+	} else {
+		loaded = make([]*AccessData, len(missingTokens))
+		for i, token := range missingTokens {
+			data, err := storageLoadAccess(ctx, s.Storage, token)
+			if err != nil && err != ErrNotFound {
+				return nil, err
+			}
+			loaded[i] = data
+		}
+	}
+
+	s.cache.mu.Lock()
+	defer s.cache.mu.Unlock()
+	for i, idx := range missing {
+		if i < len(loaded) && loaded[i] != nil {
+			ret[idx] = loaded[i]
+			if s.cache.gen == gen {
+				s.cache.access.add(tokens[idx], loaded[i])
+			}
+		}
+	}
+	return ret, nil
+}
+
+// RemoveAccess removes the access data from the inner storage and the
+// cache, before and after the removal
+func (s *CachingStorage) RemoveAccess(token string) error {
+	return s.RemoveAccessContext(context.Background(), token)
+}
+
+// RemoveAccessContext satisfies ContextStorage
+func (s *CachingStorage) RemoveAccessContext(ctx context.Context, token string) error {
+	s.invalidateAccess(token)
+	err := storageRemoveAccess(ctx, s.Storage, token)
+	s.invalidateAccess(token)
+	return err
+}
+
+// LoadRefresh returns the cached refresh access data, or loads it from the inner storage
+func (s *CachingStorage) LoadRefresh(token string) (*AccessData, error) {
+	return s.LoadRefreshContext(context.Background(), token)
+}
+
+// LoadRefreshContext satisfies ContextStorage
+func (s *CachingStorage) LoadRefreshContext(ctx context.Context, token string) (*AccessData, error) {
+	s.cache.mu.Lock()
+	v, ok := s.cache.refresh.get(token)
+	gen := s.cache.gen
+	s.cache.mu.Unlock()
+	if ok {
+		return v.(*AccessData), nil
+	}
+
+	data, err := storageLoadRefresh(ctx, s.Storage, token)
+	if err == nil && data != nil {
+		s.cache.mu.Lock()
+		if s.cache.gen == gen {
+			s.cache.refresh.add(token, data)
+			s.cache.refreshByAccess[data.AccessToken] = token
+		}
+		s.cache.mu.Unlock()
+	}
+	return data, err
+}
+
+// RemoveRefresh removes the refresh access data from the inner storage and
+// the cache, before and after the removal
+func (s *CachingStorage) RemoveRefresh(token string) error {
+	return s.RemoveRefreshContext(context.Background(), token)
+}
+
+// RemoveRefreshContext satisfies ContextStorage
+func (s *CachingStorage) RemoveRefreshContext(ctx context.Context, token string) error {
+	s.invalidateRefresh(token)
+	err := storageRemoveRefresh(ctx, s.Storage, token)
+	s.invalidateRefresh(token)
+	return err
+}
+
+func (s *CachingStorage) invalidateAccess(token string) {
+	s.cache.mu.Lock()
+	defer s.cache.mu.Unlock()
+	s.cache.gen++
+	s.cache.access.remove(token)
+	if refresh, ok := s.cache.refreshByAccess[token]; ok {
+		s.cache.refresh.remove(refresh)
+	}
+}
+
+func (s *CachingStorage) invalidateRefresh(token string) {
+	s.cache.mu.Lock()
+	defer s.cache.mu.Unlock()
+	s.cache.gen++
+	s.cache.refresh.remove(token)
+}
+
+// lruCache is a size bounded cache with expiration. It is not safe for
+// concurrent use.
+type lruCache struct {
+	max     int
+	ttl     time.Duration
+	now     func() time.Time
+	ll      *list.List
+	items   map[string]*list.Element
+	onEvict func(key string, value interface{})
+}
+
+type lruEntry struct {
+	key       string
+	value     interface{}
+	expiresAt time.Time
+}
+
+func newLRUCache(max int, ttl time.Duration, now func() time.Time) *lruCache {
+	return &lruCache{
+		max:   max,
+		ttl:   ttl,
+		now:   now,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *lruCache) get(key string) (interface{}, bool) {
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*lruEntry)
+	if c.now().After(e.expiresAt) {
+		c.removeElement(el)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return e.value, true
+}
+
+func (c *lruCache) add(key string, value interface{}) {
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, value: value, expiresAt: c.now().Add(c.ttl)})
+	for c.ll.Len() > c.max {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *lruCache) remove(key string) {
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *lruCache) len() int {
+	return c.ll.Len()
+}
+
+func (c *lruCache) removeElement(el *list.Element) {
+	e := c.ll.Remove(el).(*lruEntry)
+	delete(c.items, e.key)
+	if c.onEvict != nil {
+		c.onEvict(e.key, e.value)
+	}
+}
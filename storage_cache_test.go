@@ -0,0 +1,162 @@
+package osin
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// countingStorage counts the calls reaching the inner storage
+type countingStorage struct {
+	*TestingStorage
+	calls map[string]int
+}
+
+func newCountingStorage() *countingStorage {
+	return &countingStorage{TestingStorage: NewTestingStorage(), calls: make(map[string]int)}
+}
+
+func (s *countingStorage) Clone() Storage { return s }
+
+func (s *countingStorage) GetClient(id string) (Client, error) {
+	s.calls["GetClient"]++
+	return s.TestingStorage.GetClient(id)
+}
+
+func (s *countingStorage) LoadAccess(token string) (*AccessData, error) {
+	s.calls["LoadAccess"]++
+	return s.TestingStorage.LoadAccess(token)
+}
+
+func (s *countingStorage) LoadRefresh(token string) (*AccessData, error) {
+	s.calls["LoadRefresh"]++
+	return s.TestingStorage.LoadRefresh(token)
+}
+
+func TestCachingStorage(t *testing.T) {
+	inner := newCountingStorage()
+	storage := NewCachingStorage(inner, CachingStorageOptions{})
+
+	for i := 0; i < 3; i++ {
+		if c, err := storage.GetClient("1234"); err != nil || c == nil {
+			t.Fatalf("GetClient failed: %v", err)
+		}
+		if ad, err := storage.LoadAccess("9999"); err != nil || ad == nil {
+			t.Fatalf("LoadAccess failed: %v", err)
+		}
+		if ad, err := storage.LoadRefresh("r9999"); err != nil || ad == nil {
+			t.Fatalf("LoadRefresh failed: %v", err)
+		}
+	}
+	for _, m := range []string{"GetClient", "LoadAccess", "LoadRefresh"} {
+		if inner.calls[m] != 1 {
+			t.Errorf("Expected 1 call to %s, got %d", m, inner.calls[m])
+		}
+	}
+
+	// not found is not cached
+	for i := 0; i < 2; i++ {
+		if _, err := storage.LoadAccess("unknown"); err != ErrNotFound {
+			t.Fatalf("Expected ErrNotFound, got %v", err)
+		}
+	}
+	if inner.calls["LoadAccess"] != 3 {
+		t.Errorf("Not found results should not be cached")
+	}
+
+	// removing the access invalidates both the access and the refresh entries
+	if err := storage.RemoveAccess("9999"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.LoadAccess("9999"); err != ErrNotFound {
+		t.Errorf("Removed access should not be served from cache")
+	}
+	if _, err := storage.LoadRefresh("r9999"); err != ErrNotFound {
+		t.Errorf("Refresh of removed access should not be served from cache")
+	}
+}
+
+// racingStorage loads the access data through the cache while removing it,
+// as a concurrent request would
+type racingStorage struct {
+	*countingStorage
+	cache *CachingStorage
+}
+
+func (s *racingStorage) RemoveAccess(token string) error {
+	s.cache.LoadAccess(token)
+	return s.countingStorage.RemoveAccess(token)
+}
+
+func TestCachingStorageRacingLoad(t *testing.T) {
+	inner := &racingStorage{countingStorage: newCountingStorage()}
+	storage := NewCachingStorage(inner, CachingStorageOptions{})
+	inner.cache = storage
+
+	if err := storage.RemoveAccess("9999"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.LoadAccess("9999"); err != ErrNotFound {
+		t.Errorf("Access loaded during its removal should not be cached")
+	}
+}
+
+func TestCachingStorageOptionalInterfaces(t *testing.T) {
+	var storage Storage = NewCachingStorage(newDeviceTestingStorage(), CachingStorageOptions{})
+	if _, ok := storageAs[DeviceStorage](storage); !ok {
+		t.Errorf("DeviceStorage of the inner storage should be found")
+	}
+	if _, ok := storageAs[MFAStorage](storage); ok {
+		t.Errorf("MFAStorage is not implemented by the inner storage")
+	}
+	if _, ok := storage.(ContextStorage); !ok {
+		t.Errorf("CachingStorage should pass the context through")
+	}
+}
+
+func TestCachingStorageExpiration(t *testing.T) {
+	now := time.Now()
+	inner := newCountingStorage()
+	storage := NewCachingStorage(inner, CachingStorageOptions{
+		MaxEntries: 1,
+		TTL:        time.Second,
+		Now:        func() time.Time { return now },
+	})
+
+	storage.LoadAccess("9999")
+	storage.LoadAccess("9999")
+	now = now.Add(2 * time.Second)
+	storage.LoadAccess("9999")
+	if inner.calls["LoadAccess"] != 2 {
+		t.Errorf("Expired entry should be reloaded, got %d calls", inner.calls["LoadAccess"])
+	}
+
+	// LRU eviction
+	storage.SaveAccess(&AccessData{AccessToken: "other", Client: inner.clients["1234"], CreatedAt: now, ExpiresIn: 3600})
+	storage.LoadAccess("other")
+	storage.LoadAccess("9999")
+	if inner.calls["LoadAccess"] != 4 {
+		t.Errorf("Least recently used entry should be evicted, got %d calls", inner.calls["LoadAccess"])
+	}
+	if n := storage.cache.access.len(); n != 1 {
+		t.Errorf("Cache should hold 1 entry, got %d", n)
+	}
+}
+
+func TestCachingStorageLoadAccessBatch(t *testing.T) {
+	inner := newCountingStorage()
+	storage := NewCachingStorage(inner, CachingStorageOptions{})
+
+	storage.LoadAccess("9999")
+	result, err := storage.LoadAccessBatch(context.Background(), []string{"9999", "unknown", "r9999"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 3 || result[0] == nil || result[1] != nil || result[2] == nil {
+		t.Fatalf("Unexpected batch result: %v", result)
+	}
+	// 1 initial load, 2 misses
+	if inner.calls["LoadAccess"] != 3 {
+		t.Errorf("Cached entries should not be reloaded, got %d calls", inner.calls["LoadAccess"])
+	}
+}
@@ -0,0 +1,128 @@
+package osin
+
+// MigrationStorage is a Storage moving the live grants from an old storage
+// to a new one without downtime. Writes go to both, reads try the new
+// storage first, then the old one. Once the longest lived tokens written
+// only to the old storage expired, the old storage can be dropped.
+type MigrationStorage struct {
+	Old Storage
+	New Storage
+
+	// If true, grants only found in the old storage are copied to the new
+	// one when read, to migrate faster
+	CopyOnRead bool
+
+	// If true, failed writes to the old storage are ignored. Otherwise they
+	// fail the call, keeping the old storage complete for a rollback.
+	IgnoreOldErrors bool
+}
+
+// NewMigrationStorage creates a storage migrating from oldStorage to
+// newStorage
+func NewMigrationStorage(oldStorage, newStorage Storage) *MigrationStorage {
+	return &MigrationStorage{
+		Old: oldStorage,
+		New: newStorage,
+	}
+}
+
+// Clone clones both storages
+func (s *MigrationStorage) Clone() Storage {
+	c := *s
+	c.Old, c.New = s.Old.Clone(), s.New.Clone()
+	return &c
+}
+
+// Close closes both storages
+func (s *MigrationStorage) Close() {
+	s.New.Close()
+	s.Old.Close()
+}
+
+// write writes to the new storage, then to the old one
+func (s *MigrationStorage) write(write func(Storage) error) error {
+	if err := write(s.New); err != nil && err != ErrNotFound {
+		return err
+	}
+	if err := write(s.Old); err != nil && err != ErrNotFound && !s.IgnoreOldErrors {
+		return err
+	}
+	return nil
+}
+
+// GetClient loads the client from the new storage, then the old one
+func (s *MigrationStorage) GetClient(id string) (Client, error) {
+	c, err := s.New.GetClient(id)
+	if err == ErrNotFound || (err == nil && c == nil) {
+		return s.Old.GetClient(id)
+	}
+	return c, err
+}
+
+// SaveAuthorize saves the code in both storages
+func (s *MigrationStorage) SaveAuthorize(data *AuthorizeData) error {
+	return s.write(func(st Storage) error { return st.SaveAuthorize(data) })
+}
+
+// LoadAuthorize loads the code from the new storage, then the old one
+func (s *MigrationStorage) LoadAuthorize(code string) (*AuthorizeData, error) {
+	data, err := s.New.LoadAuthorize(code)
+	if err != ErrNotFound && (err != nil || data != nil) {
+		return data, err
+	}
+	if data, err = s.Old.LoadAuthorize(code); err != nil {
+		return nil, err
+	}
+	if s.CopyOnRead && data != nil {
+		if err = s.New.SaveAuthorize(data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// RemoveAuthorize removes the code from both storages
+func (s *MigrationStorage) RemoveAuthorize(code string) error {
+	return s.write(func(st Storage) error { return st.RemoveAuthorize(code) })
+}
+
+// SaveAccess saves the grant in both storages
+func (s *MigrationStorage) SaveAccess(data *AccessData) error {
+	return s.write(func(st Storage) error { return st.SaveAccess(data) })
+}
+
+// LoadAccess loads the grant from the new storage, then the old one
+func (s *MigrationStorage) LoadAccess(token string) (*AccessData, error) {
+	return s.loadAccess(func(st Storage) (*AccessData, error) { return st.LoadAccess(token) })
+}
+
+// RemoveAccess removes the grant from both storages
+func (s *MigrationStorage) RemoveAccess(token string) error {
+	return s.write(func(st Storage) error { return st.RemoveAccess(token) })
+}
+
+// LoadRefresh loads the grant from the new storage, then the old one
+func (s *MigrationStorage) LoadRefresh(token string) (*AccessData, error) {
+	return s.loadAccess(func(st Storage) (*AccessData, error) { return st.LoadRefresh(token) })
+}
+
+// RemoveRefresh removes the refresh token from both storages
+func (s *MigrationStorage) RemoveRefresh(token string) error {
+	return s.write(func(st Storage) error { return st.RemoveRefresh(token) })
+}
+
+func (s *MigrationStorage) loadAccess(load func(Storage) (*AccessData, error)) (*AccessData, error) {
+	data, err := load(s.New)
+	if err != ErrNotFound && (err != nil || data != nil) {
+		return data, err
+	}
+	if data, err = load(s.Old); err != nil {
+		return nil, err
+	}
+	if s.CopyOnRead && data != nil {
+		if err = s.New.SaveAccess(data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
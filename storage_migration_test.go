@@ -0,0 +1,71 @@
+package osin
+
+import (
+	"errors"
+	"testing"
+)
+
+// failingSaveStorage fails all the saves
+type failingSaveStorage struct {
+	*TestingStorage
+}
+
+func (s *failingSaveStorage) Clone() Storage { return s }
+
+func (s *failingSaveStorage) SaveAccess(data *AccessData) error {
+	return errors.New("read only")
+}
+
+func TestMigrationStorage(t *testing.T) {
+	oldStorage := NewTestingStorage()
+	newStorage := NewTestingStorage()
+	delete(newStorage.access, "9999")
+	delete(newStorage.refresh, "r9999")
+	storage := NewMigrationStorage(oldStorage, newStorage)
+
+	// a token of the old storage is still valid
+	if data, err := storage.LoadRefresh("r9999"); err != nil || data.AccessToken != "9999" {
+		t.Fatalf("Unexpected refresh %v, %v", data, err)
+	}
+	if _, err := newStorage.LoadAccess("9999"); err != ErrNotFound {
+		t.Fatal("The token should not be copied")
+	}
+
+	storage.CopyOnRead = true
+	if _, err := storage.LoadAccess("9999"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newStorage.LoadAccess("9999"); err != nil {
+		t.Fatalf("The token should be copied, got %v", err)
+	}
+
+	// removes reach both storages
+	if err := storage.RemoveAccess("9999"); err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range []Storage{oldStorage, newStorage} {
+		if _, err := st.LoadAccess("9999"); err != ErrNotFound {
+			t.Fatalf("Expected ErrNotFound, got %v", err)
+		}
+	}
+
+	// new grants are written to both
+	client, _ := storage.GetClient("1234")
+	data := &AccessData{Client: client, AccessToken: "new", ExpiresIn: 3600}
+	if err := storage.SaveAccess(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := oldStorage.LoadAccess("new"); err != nil {
+		t.Fatalf("The old storage should have the token, got %v", err)
+	}
+
+	// old storage failures fail the writes, unless ignored
+	storage.Old = &failingSaveStorage{oldStorage}
+	if err := storage.SaveAccess(data); err == nil {
+		t.Fatal("Expected the old storage error")
+	}
+	storage.IgnoreOldErrors = true
+	if err := storage.SaveAccess(data); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,263 @@
+package osin
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// ReplicaStorageOptions configures a ReplicaStorage
+type ReplicaStorageOptions struct {
+	// Time the codes and tokens written through the storage are served from
+	// memory, so a replica lagging behind the primary doesn't miss them or
+	// return removed ones. Must be longer than the replication lag.
+	// Default 5 seconds.
+	RecentWriteWindow time.Duration
+
+	// If true, what the replica doesn't find is looked up in the primary,
+	// for writes made by other processes during the replication lag. Costs
+	// a primary read for every invalid token.
+	FallbackToPrimary bool
+
+	// Time source, time.Now if nil
+	Now func() time.Time
+}
+
+// ReplicaStorage is a Storage decorator sending the loads to read
+// replicas, round robin, and the saves and removes to the primary. Writes
+// made through it are also kept in memory for RecentWriteWindow, so a code
+// is found by the token request following its authorization. The optional
+// interfaces of the primary, found through Unwrap, are not sent to the
+// replicas.
+type ReplicaStorage struct {
+	// The primary storage
+	Storage
+
+	replicas []Storage
+	state    *replicaState
+}
+
+// replicaState is shared between a ReplicaStorage and its clones
+type replicaState struct {
+	opts   ReplicaStorageOptions
+	recent memoCache
+	next   uint32
+}
+
+// replicaRemoved marks a recently removed entry
+type replicaRemoved struct{}
+
+// NewReplicaStorage creates a storage writing to the primary and reading
+// from the replicas. With no replicas, everything goes to the primary.
+func NewReplicaStorage(primary Storage, replicas []Storage, opts ReplicaStorageOptions) *ReplicaStorage {
+	if opts.RecentWriteWindow <= 0 {
+		opts.RecentWriteWindow = 5 * time.Second
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &ReplicaStorage{
+		Storage:  primary,
+		replicas: replicas,
+		state:    &replicaState{opts: opts},
+	}
+}
+
+// Clone clones the primary and the replicas, sharing the recent writes
+func (s *ReplicaStorage) Clone() Storage {
+	replicas := make([]Storage, len(s.replicas))
+	for i, r := range s.replicas {
+		replicas[i] = r.Clone()
+	}
+	return &ReplicaStorage{
+		Storage:  s.Storage.Clone(),
+		replicas: replicas,
+		state:    s.state,
+	}
+}
+
+// Close closes the primary and the replicas
+func (s *ReplicaStorage) Close() {
+	s.Storage.Close()
+	for _, r := range s.replicas {
+		r.Close()
+	}
+}
+
+// Unwrap returns the primary storage
+func (s *ReplicaStorage) Unwrap() Storage {
+	return s.Storage
+}
+
+// replica returns the next replica to read from
+func (s *ReplicaStorage) replica() Storage {
+	if len(s.replicas) == 0 {
+		return s.Storage
+	}
+	n := atomic.AddUint32(&s.state.next, 1)
+	return s.replicas[n%uint32(len(s.replicas))]
+}
+
+func (s *ReplicaStorage) remember(key string, value interface{}) {
+	now := s.state.opts.Now()
+	s.state.recent.put(key, value, now, now.Add(s.state.opts.RecentWriteWindow))
+}
+
+// load returns the recent write of the key, or loads it from a replica
+func (s *ReplicaStorage) load(key string, load func(Storage) (interface{}, error)) (interface{}, error) {
+	if v, ok := s.state.recent.get(key, s.state.opts.Now()); ok {
+		if _, removed := v.(replicaRemoved); removed {
+			return nil, ErrNotFound
+		}
+		return v, nil
+	}
+	return s.fromReplica(load)
+}
+
+// fromReplica loads from a replica, falling back to the primary if enabled
+func (s *ReplicaStorage) fromReplica(load func(Storage) (interface{}, error)) (interface{}, error) {
+	v, err := load(s.replica())
+	if err == ErrNotFound && s.state.opts.FallbackToPrimary && len(s.replicas) > 0 {
+		return load(s.Storage)
+	}
+	return v, err
+}
+
+// GetClient loads the client from a replica
+func (s *ReplicaStorage) GetClient(id string) (Client, error) {
+	return s.GetClientContext(context.Background(), id)
+}
+
+// GetClientContext satisfies ContextStorage
+func (s *ReplicaStorage) GetClientContext(ctx context.Context, id string) (Client, error) {
+	v, err := s.fromReplica(func(st Storage) (interface{}, error) { return storageGetClient(ctx, st, id) })
+	if err != nil {
+		return nil, err
+	}
+	c, _ := v.(Client)
+	return c, nil
+}
+
+// SaveAuthorize saves the code in the primary
+func (s *ReplicaStorage) SaveAuthorize(data *AuthorizeData) error {
+	return s.SaveAuthorizeContext(context.Background(), data)
+}
+
+// SaveAuthorizeContext satisfies ContextStorage
+func (s *ReplicaStorage) SaveAuthorizeContext(ctx context.Context, data *AuthorizeData) error {
+	if err := storageSaveAuthorize(ctx, s.Storage, data); err != nil {
+		return err
+	}
+	s.remember("code:"+data.Code, data)
+	return nil
+}
+
+// LoadAuthorize loads the code from a replica, unless recently written
+func (s *ReplicaStorage) LoadAuthorize(code string) (*AuthorizeData, error) {
+	return s.LoadAuthorizeContext(context.Background(), code)
+}
+
+// LoadAuthorizeContext satisfies ContextStorage
+func (s *ReplicaStorage) LoadAuthorizeContext(ctx context.Context, code string) (*AuthorizeData, error) {
+	v, err := s.load("code:"+code, func(st Storage) (interface{}, error) { return storageLoadAuthorize(ctx, st, code) })
+	if err != nil {
+		return nil, err
+	}
+	data, _ := v.(*AuthorizeData)
+	return data, nil
+}
+
+// RemoveAuthorize removes the code from the primary
+func (s *ReplicaStorage) RemoveAuthorize(code string) error {
+	return s.RemoveAuthorizeContext(context.Background(), code)
+}
+
+// RemoveAuthorizeContext satisfies ContextStorage
+func (s *ReplicaStorage) RemoveAuthorizeContext(ctx context.Context, code string) error {
+	if err := storageRemoveAuthorize(ctx, s.Storage, code); err != nil {
+		return err
+	}
+	s.remember("code:"+code, replicaRemoved{})
+	return nil
+}
+
+// SaveAccess saves the grant in the primary
+func (s *ReplicaStorage) SaveAccess(data *AccessData) error {
+	return s.SaveAccessContext(context.Background(), data)
+}
+
+// SaveAccessContext satisfies ContextStorage
+func (s *ReplicaStorage) SaveAccessContext(ctx context.Context, data *AccessData) error {
+	if err := storageSaveAccess(ctx, s.Storage, data); err != nil {
+		return err
+	}
+	s.remember("access:"+data.AccessToken, data)
+	if data.RefreshToken != "" {
+		s.remember("refresh:"+data.RefreshToken, data)
+	}
+	return nil
+}
+
+// LoadAccess loads the grant from a replica, unless recently written
+func (s *ReplicaStorage) LoadAccess(token string) (*AccessData, error) {
+	return s.LoadAccessContext(context.Background(), token)
+}
+
+// LoadAccessContext satisfies ContextStorage
+func (s *ReplicaStorage) LoadAccessContext(ctx context.Context, token string) (*AccessData, error) {
+	v, err := s.load("access:"+token, func(st Storage) (interface{}, error) { return storageLoadAccess(ctx, st, token) })
+	if err != nil {
+		return nil, err
+	}
+	data, _ := v.(*AccessData)
+	return data, nil
+}
+
+// RemoveAccess removes the grant from the primary
+func (s *ReplicaStorage) RemoveAccess(token string) error {
+	return s.RemoveAccessContext(context.Background(), token)
+}
+
+// RemoveAccessContext satisfies ContextStorage
+func (s *ReplicaStorage) RemoveAccessContext(ctx context.Context, token string) error {
+	if err := storageRemoveAccess(ctx, s.Storage, token); err != nil {
+		return err
+	}
+	// the refresh token of a recent grant goes with it
+	if v, ok := s.state.recent.get("access:"+token, s.state.opts.Now()); ok {
+		if data, ok := v.(*AccessData); ok && data.RefreshToken != "" {
+			s.remember("refresh:"+data.RefreshToken, replicaRemoved{})
+		}
+	}
+	s.remember("access:"+token, replicaRemoved{})
+	return nil
+}
+
+// LoadRefresh loads the grant from a replica, unless recently written
+func (s *ReplicaStorage) LoadRefresh(token string) (*AccessData, error) {
+	return s.LoadRefreshContext(context.Background(), token)
+}
+
+// LoadRefreshContext satisfies ContextStorage
+func (s *ReplicaStorage) LoadRefreshContext(ctx context.Context, token string) (*AccessData, error) {
+	v, err := s.load("refresh:"+token, func(st Storage) (interface{}, error) { return storageLoadRefresh(ctx, st, token) })
+	if err != nil {
+		return nil, err
+	}
+	data, _ := v.(*AccessData)
+	return data, nil
+}
+
+// RemoveRefresh removes the refresh token from the primary
+func (s *ReplicaStorage) RemoveRefresh(token string) error {
+	return s.RemoveRefreshContext(context.Background(), token)
+}
+
+// RemoveRefreshContext satisfies ContextStorage
+func (s *ReplicaStorage) RemoveRefreshContext(ctx context.Context, token string) error {
+	if err := storageRemoveRefresh(ctx, s.Storage, token); err != nil {
+		return err
+	}
+	s.remember("refresh:"+token, replicaRemoved{})
+	return nil
+}
@@ -0,0 +1,70 @@
+package osin
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReplicaStorage(t *testing.T) {
+	primary := newCountingStorage()
+	replica := newCountingStorage()
+	now := time.Now()
+	storage := NewReplicaStorage(primary, []Storage{replica}, ReplicaStorageOptions{
+		Now: func() time.Time { return now },
+	})
+
+	// reads go to the replica
+	if _, err := storage.LoadAccess("9999"); err != nil {
+		t.Fatal(err)
+	}
+	if replica.calls["LoadAccess"] != 1 || primary.calls["LoadAccess"] != 0 {
+		t.Fatalf("Expected the load from the replica, got %v and %v", replica.calls, primary.calls)
+	}
+
+	// a recent write is seen even if the replica lags
+	client, _ := storage.GetClient("1234")
+	data := &AccessData{Client: client, AccessToken: "new", RefreshToken: "rnew", ExpiresIn: 3600, CreatedAt: now}
+	if err := storage.SaveAccess(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replica.TestingStorage.LoadAccess("new"); err != ErrNotFound {
+		t.Fatal("The replica should not have the token")
+	}
+	if ad, err := storage.LoadRefresh("rnew"); err != nil || ad != data {
+		t.Fatalf("Expected the recent write, got %v, %v", ad, err)
+	}
+
+	// a recent remove is not undone by the replica
+	if err := storage.RemoveAccess("9999"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.LoadAccess("9999"); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+
+	// after the window, the replica is read again
+	now = now.Add(10 * time.Second)
+	if _, err := storage.LoadAccess("new"); err != ErrNotFound {
+		t.Fatalf("Expected the replica miss, got %v", err)
+	}
+
+	storage.state.opts.FallbackToPrimary = true
+	if ad, err := storage.LoadAccess("new"); err != nil || ad == nil {
+		t.Fatalf("Expected the primary fallback, got %v, %v", ad, err)
+	}
+}
+
+func TestReplicaStorageOptionalInterfaces(t *testing.T) {
+	primary := newDeviceTestingStorage()
+	var storage Storage = NewReplicaStorage(primary, []Storage{NewTestingStorage()}, ReplicaStorageOptions{})
+	ds, ok := storageAs[DeviceStorage](storage)
+	if !ok {
+		t.Fatalf("DeviceStorage of the primary should be found")
+	}
+	if ds != primary {
+		t.Errorf("DeviceStorage calls should go to the primary")
+	}
+	if _, ok := storage.(ContextStorage); !ok {
+		t.Errorf("ReplicaStorage should pass the context through")
+	}
+}
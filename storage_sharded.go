@@ -0,0 +1,174 @@
+package osin
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// shardVirtualNodes is the number of points of each shard on the hash
+// ring, spreading the keys evenly
+const shardVirtualNodes = 128
+
+// ShardedStorage is a Storage decorator spreading the clients, codes and
+// tokens over several storages, by consistent hashing of their id. Adding
+// a shard only moves the keys it takes over.
+//
+// Grants are saved in the shard of the access token and, if different, in
+// the shard of the refresh token, to be found by LoadRefresh.
+type ShardedStorage struct {
+	shards map[string]Storage
+	ring   *hashRing
+}
+
+// hashRing maps the hashes of keys to shard names, shared by clones
+type hashRing struct {
+	points []uint64
+	names  map[uint64]string
+}
+
+// NewShardedStorage creates a storage over the shards, by name. Names must
+// be stable across restarts, they place the shards on the hash ring.
+func NewShardedStorage(shards map[string]Storage) *ShardedStorage {
+	ring := &hashRing{names: make(map[uint64]string)}
+	for name := range shards {
+		for i := 0; i < shardVirtualNodes; i++ {
+			h := shardHash(name + "#" + strconv.Itoa(i))
+			if _, ok := ring.names[h]; ok {
+				continue
+			}
+			ring.names[h] = name
+			ring.points = append(ring.points, h)
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i] < ring.points[j] })
+	return &ShardedStorage{shards: shards, ring: ring}
+}
+
+// shardHash hashes a key with FNV-1a, finalized as in MurmurHash3 so keys
+// differing in their last bytes spread over the whole ring
+func shardHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// ShardName returns the name of the shard of a key
+func (s *ShardedStorage) ShardName(key string) string {
+	h := shardHash(key)
+	i := sort.Search(len(s.ring.points), func(i int) bool { return s.ring.points[i] >= h })
+	if i == len(s.ring.points) {
+		i = 0
+	}
+	return s.ring.names[s.ring.points[i]]
+}
+
+func (s *ShardedStorage) shard(key string) Storage {
+	return s.shards[s.ShardName(key)]
+}
+
+// Clone clones all the shards
+func (s *ShardedStorage) Clone() Storage {
+	shards := make(map[string]Storage, len(s.shards))
+	for name, shard := range s.shards {
+		shards[name] = shard.Clone()
+	}
+	return &ShardedStorage{shards: shards, ring: s.ring}
+}
+
+// Close closes all the shards
+func (s *ShardedStorage) Close() {
+	for _, shard := range s.shards {
+		shard.Close()
+	}
+}
+
+// GetClient loads the client from its shard
+func (s *ShardedStorage) GetClient(id string) (Client, error) {
+	return s.shard("client:" + id).GetClient(id)
+}
+
+// ClientShard returns the storage holding a client, to save it
+func (s *ShardedStorage) ClientShard(id string) Storage {
+	return s.shard("client:" + id)
+}
+
+// SaveAuthorize saves the code in its shard
+func (s *ShardedStorage) SaveAuthorize(data *AuthorizeData) error {
+	return s.shard(data.Code).SaveAuthorize(data)
+}
+
+// LoadAuthorize loads the code from its shard
+func (s *ShardedStorage) LoadAuthorize(code string) (*AuthorizeData, error) {
+	return s.shard(code).LoadAuthorize(code)
+}
+
+// RemoveAuthorize removes the code from its shard
+func (s *ShardedStorage) RemoveAuthorize(code string) error {
+	return s.shard(code).RemoveAuthorize(code)
+}
+
+// SaveAccess saves the grant in the shards of its access and refresh tokens
+func (s *ShardedStorage) SaveAccess(data *AccessData) error {
+	if err := s.shard(data.AccessToken).SaveAccess(data); err != nil {
+		return err
+	}
+	if data.RefreshToken != "" && s.ShardName(data.RefreshToken) != s.ShardName(data.AccessToken) {
+		return s.shard(data.RefreshToken).SaveAccess(data)
+	}
+	return nil
+}
+
+// LoadAccess loads the grant from the shard of the access token
+func (s *ShardedStorage) LoadAccess(token string) (*AccessData, error) {
+	return s.shard(token).LoadAccess(token)
+}
+
+// RemoveAccess removes the grant from the shard of the access token, and
+// its copy from the shard of the refresh token
+func (s *ShardedStorage) RemoveAccess(token string) error {
+	shard := s.shard(token)
+	data, err := shard.LoadAccess(token)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	if err = shard.RemoveAccess(token); err != nil {
+		return err
+	}
+	if data != nil && data.RefreshToken != "" && s.ShardName(data.RefreshToken) != s.ShardName(token) {
+		if err = s.shard(data.RefreshToken).RemoveAccess(token); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadRefresh loads the grant from the shard of the refresh token
+func (s *ShardedStorage) LoadRefresh(token string) (*AccessData, error) {
+	return s.shard(token).LoadRefresh(token)
+}
+
+// RemoveRefresh removes the refresh token from its shard, and from the
+// shard of the access token
+func (s *ShardedStorage) RemoveRefresh(token string) error {
+	shard := s.shard(token)
+	data, err := shard.LoadRefresh(token)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	if err = shard.RemoveRefresh(token); err != nil {
+		return err
+	}
+	if data != nil && s.ShardName(data.AccessToken) != s.ShardName(token) {
+		if err = s.shard(data.AccessToken).RemoveRefresh(token); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package osin
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestShardedStorage(t *testing.T) {
+	shards := map[string]Storage{
+		"a": NewTestingStorage(),
+		"b": NewTestingStorage(),
+		"c": NewTestingStorage(),
+	}
+	storage := NewShardedStorage(shards)
+	client, err := storage.GetClient("1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	used := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		data := &AccessData{
+			Client:       client,
+			AccessToken:  fmt.Sprintf("access-%d", i),
+			RefreshToken: fmt.Sprintf("refresh-%d", i),
+			ExpiresIn:    3600,
+		}
+		if err = storage.SaveAccess(data); err != nil {
+			t.Fatal(err)
+		}
+		used[storage.ShardName(data.AccessToken)] = true
+	}
+	if len(used) != 3 {
+		t.Fatalf("Expected the tokens in 3 shards, got %v", used)
+	}
+
+	for i := 0; i < 50; i++ {
+		if data, err := storage.LoadRefresh(fmt.Sprintf("refresh-%d", i)); err != nil || data.AccessToken != fmt.Sprintf("access-%d", i) {
+			t.Fatalf("Unexpected refresh %v, %v", data, err)
+		}
+		if err = storage.RemoveAccess(fmt.Sprintf("access-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = storage.LoadAccess(fmt.Sprintf("access-%d", i)); err != ErrNotFound {
+			t.Fatalf("Expected ErrNotFound, got %v", err)
+		}
+		if _, err = storage.LoadRefresh(fmt.Sprintf("refresh-%d", i)); err != ErrNotFound {
+			t.Fatalf("Expected the refresh token removed with the grant, got %v", err)
+		}
+	}
+}
+
+func TestShardedStorageRebalance(t *testing.T) {
+	before := NewShardedStorage(map[string]Storage{"a": nil, "b": nil, "c": nil})
+	after := NewShardedStorage(map[string]Storage{"a": nil, "b": nil, "c": nil, "d": nil})
+
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("token-%d", i)
+		if name := after.ShardName(key); name != before.ShardName(key) {
+			if name != "d" {
+				t.Fatalf("Key %s moved between existing shards", key)
+			}
+			moved++
+		}
+	}
+	// the new shard takes about a quarter of the keys
+	if moved < 150 || moved > 350 {
+		t.Fatalf("Expected about 250 keys moved, got %d", moved)
+	}
+}
@@ -0,0 +1,82 @@
+package osin
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+)
+
+const (
+	// Subject types, as described in
+	// https://openid.net/specs/openid-connect-core-1_0.html#SubjectIDTypes
+	SUBJECT_PUBLIC   = "public"
+	SUBJECT_PAIRWISE = "pairwise"
+)
+
+// ClientSubjectType is an optional interface clients can implement to
+// configure the subject identifier type they receive
+type ClientSubjectType interface {
+	// GetSubjectType returns SUBJECT_PUBLIC or SUBJECT_PAIRWISE.
+	// Blank means SUBJECT_PUBLIC.
+	GetSubjectType() string
+
+	// GetSectorIdentifierURI returns the sector_identifier_uri of the client,
+	// or blank to use the host of the redirect uri
+	GetSectorIdentifierURI() string
+}
+
+// SubjectIdentifierProvider derives the subject identifier a client receives
+// for a local subject, used in ID tokens, UserInfo and introspection
+type SubjectIdentifierProvider interface {
+	SubjectIdentifier(client Client, subject string) (string, error)
+}
+
+// PairwiseSubjectIdentifierProvider returns the local subject to public
+// clients, and a stable per-sector subject to pairwise clients, calculated as
+// described in https://openid.net/specs/openid-connect-core-1_0.html#PairwiseAlg
+type PairwiseSubjectIdentifierProvider struct {
+	// Secret salt, must be kept stable for the subjects to be stable
+	Salt []byte
+
+	// Separator of the client redirect uri list, if any
+	RedirectUriSeparator string
+}
+
+// SubjectIdentifier returns the subject identifier for the client
+func (p *PairwiseSubjectIdentifierProvider) SubjectIdentifier(client Client, subject string) (string, error) {
+	c, ok := client.(ClientSubjectType)
+	if !ok || c.GetSubjectType() == "" || c.GetSubjectType() == SUBJECT_PUBLIC {
+		return subject, nil
+	}
+	if c.GetSubjectType() != SUBJECT_PAIRWISE {
+		return "", errors.New("unsupported subject type " + c.GetSubjectType())
+	}
+
+	sectorURI := c.GetSectorIdentifierURI()
+	if sectorURI == "" {
+		sectorURI = FirstRedirectURI(ClientRedirectURIs(client, p.RedirectUriSeparator))
+	}
+	u, err := url.Parse(sectorURI)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", errors.New("sector identifier has no host")
+	}
+
+	h := sha256.New()
+	h.Write([]byte(u.Host))
+	h.Write([]byte(subject))
+	h.Write(p.Salt)
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// SubjectIdentifier returns the subject identifier the client receives for
+// the local subject, using the server SubjectIdentifierProvider if set
+func (s *Server) SubjectIdentifier(client Client, subject string) (string, error) {
+	if s.SubjectIdentifierProvider == nil || subject == "" {
+		return subject, nil
+	}
+	return s.SubjectIdentifierProvider.SubjectIdentifier(client, subject)
+}
@@ -0,0 +1,82 @@
+package osin
+
+import (
+	"net/http"
+	"testing"
+)
+
+type clientWithSubjectType struct {
+	DefaultClient
+	SubjectType         string
+	SectorIdentifierURI string
+}
+
+func (c *clientWithSubjectType) GetSubjectType() string         { return c.SubjectType }
+func (c *clientWithSubjectType) GetSectorIdentifierURI() string { return c.SectorIdentifierURI }
+
+func TestPairwiseSubjectIdentifier(t *testing.T) {
+	provider := &PairwiseSubjectIdentifierProvider{Salt: []byte("salt")}
+
+	public := &clientWithSubjectType{DefaultClient: DefaultClient{Id: "public", RedirectUri: "https://a.example.com/cb"}}
+	pairwiseA := &clientWithSubjectType{DefaultClient: DefaultClient{Id: "a", RedirectUri: "https://a.example.com/cb"}, SubjectType: SUBJECT_PAIRWISE}
+	pairwiseA2 := &clientWithSubjectType{DefaultClient: DefaultClient{Id: "a2", RedirectUri: "https://a.example.com/other"}, SubjectType: SUBJECT_PAIRWISE}
+	pairwiseB := &clientWithSubjectType{DefaultClient: DefaultClient{Id: "b", RedirectUri: "https://b.example.com/cb"}, SubjectType: SUBJECT_PAIRWISE}
+	sectorB := &clientWithSubjectType{DefaultClient: DefaultClient{Id: "c", RedirectUri: "https://c.example.com/cb"}, SubjectType: SUBJECT_PAIRWISE, SectorIdentifierURI: "https://b.example.com/sector.json"}
+
+	sub := func(c Client) string {
+		s, err := provider.SubjectIdentifier(c, "user-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	if s := sub(public); s != "user-1" {
+		t.Errorf("Public client should receive the local subject, got %s", s)
+	}
+	if s := sub(&DefaultClient{Id: "default"}); s != "user-1" {
+		t.Errorf("Clients without subject type should receive the local subject, got %s", s)
+	}
+	if sub(pairwiseA) == "user-1" {
+		t.Errorf("Pairwise client should not receive the local subject")
+	}
+	if sub(pairwiseA) != sub(pairwiseA2) {
+		t.Errorf("Clients of the same sector should receive the same subject")
+	}
+	if sub(pairwiseA) == sub(pairwiseB) {
+		t.Errorf("Clients of different sectors should receive different subjects")
+	}
+	if sub(sectorB) != sub(pairwiseB) {
+		t.Errorf("Sector identifier uri should be used as sector")
+	}
+}
+
+func TestInfoPairwiseSubject(t *testing.T) {
+	storage := NewTestingStorage()
+	storage.access["9999"].Subject = "user-1"
+	storage.access["9999"].Client = &clientWithSubjectType{
+		DefaultClient: DefaultClient{Id: "1234", RedirectUri: "http://localhost:14000/appauth"},
+		SubjectType:   SUBJECT_PAIRWISE,
+	}
+	server := NewServer(NewServerConfig(), storage)
+	server.SubjectIdentifierProvider = &PairwiseSubjectIdentifierProvider{Salt: []byte("salt")}
+	resp := server.NewResponse()
+
+	req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer 9999")
+
+	if ir := server.HandleInfoRequest(resp, req); ir != nil {
+		server.FinishInfoRequest(resp, req, ir)
+	}
+
+	if resp.IsError {
+		t.Fatalf("Should not be an error: %v", resp.Output)
+	}
+	expected, _ := server.SubjectIdentifier(storage.access["9999"].Client, "user-1")
+	if d := resp.Output["sub"]; d != expected || d == "user-1" {
+		t.Fatalf("Unexpected sub: %v", d)
+	}
+}
@@ -1,33 +1,376 @@
 package osin
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"math"
+	"math/big"
+	"sync"
+	"time"
 
 	"github.com/pborman/uuid"
 )
 
+// TokenEncoding is the encoding of the random bytes of a token
+type TokenEncoding string
+
+const (
+	TOKEN_ENCODING_BASE64URL TokenEncoding = "base64url"
+	TOKEN_ENCODING_BASE62    TokenEncoding = "base62"
+	TOKEN_ENCODING_HEX       TokenEncoding = "hex"
+)
+
+// TokenFormat configures the tokens of the default generators. The zero value
+// generates base64url encoded random UUIDs.
+type TokenFormat struct {
+	// Number of random bytes. If 0, a random UUID is used (122 random bits).
+	// Use 32 for 256-bit tokens.
+	Length int
+
+	// Encoding of the random bytes - default TOKEN_ENCODING_BASE64URL
+	Encoding TokenEncoding
+
+	// If true, a CRC32 checksum of the token is appended, so malformed
+	// tokens can be rejected without a storage lookup
+	Checksum bool
+}
+
+// Generate generates a new random token
+func (f TokenFormat) Generate() (string, error) {
+	var b []byte
+	if f.Length <= 0 {
+		b = []byte(uuid.NewRandom())
+	} else {
+		b = make([]byte, f.Length)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+	}
+
+	token, err := f.encode(b)
+	if err != nil {
+		return "", err
+	}
+	if f.Checksum {
+		sum, _ := f.checksum(token)
+		token += sum
+	}
+	return token, nil
+}
+
+// VerifyChecksum returns true if the token ends with a valid checksum.
+// Always true if the format has no checksum.
+func (f TokenFormat) VerifyChecksum(token string) bool {
+	if !f.Checksum {
+		return true
+	}
+	sum, _ := f.checksum("")
+	if len(token) <= len(sum) {
+		return false
+	}
+	body := token[:len(token)-len(sum)]
+	expected, _ := f.checksum(body)
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(token[len(body):])) == 1
+}
+
+func (f TokenFormat) checksum(token string) (string, error) {
+	var c [4]byte
+	binary.BigEndian.PutUint32(c[:], crc32.ChecksumIEEE([]byte(token)))
+	return f.encode(c[:])
+}
+
+func (f TokenFormat) encode(b []byte) (string, error) {
+	switch f.Encoding {
+	case "", TOKEN_ENCODING_BASE64URL:
+		return base64.RawURLEncoding.EncodeToString(b), nil
+	case TOKEN_ENCODING_HEX:
+		return hex.EncodeToString(b), nil
+	case TOKEN_ENCODING_BASE62:
+		return encodeBase62(b), nil
+	}
+	return "", errors.New("unknown token encoding " + string(f.Encoding))
+}
+
+// encodeBase62 encodes the bytes in base62, padded to a fixed width for
+// the number of bytes
+func encodeBase62(b []byte) string {
+	width := int(math.Ceil(float64(len(b)*8) / math.Log2(62)))
+	ret := make([]byte, width)
+	n := new(big.Int).SetBytes(b)
+	base := big.NewInt(62)
+	mod := new(big.Int)
+	for i := width - 1; i >= 0; i-- {
+		n.DivMod(n, base, mod)
+		ret[i] = BASE62_ALPHABET[mod.Int64()]
+	}
+	return string(ret)
+}
+
 // AuthorizeTokenGenDefault is the default authorization token generator
 type AuthorizeTokenGenDefault struct {
+	// Format of the generated codes. The generator of NewServer uses the
+	// Config.TokenFormat in use instead.
+	Format TokenFormat
+
+	config func() *ServerConfig
 }
 
-// GenerateAuthorizeToken generates a base64-encoded UUID code
+// GenerateAuthorizeToken generates a random code, a base64-encoded UUID by default
 func (a *AuthorizeTokenGenDefault) GenerateAuthorizeToken(data *AuthorizeData) (ret string, err error) {
-	token := uuid.NewRandom()
-	return base64.RawURLEncoding.EncodeToString([]byte(token)), nil
+	if a.config != nil {
+		return a.config().TokenFormat.Generate()
+	}
+	return a.Format.Generate()
+}
+
+// RefreshTokenGenDefault generates random refresh tokens
+type RefreshTokenGenDefault struct {
+	// Format of the generated tokens. Use a Length of 32 for 256-bit tokens.
+	Format TokenFormat
+}
+
+// GenerateRefreshToken generates a random refresh token, a base64-encoded UUID by default
+func (a *RefreshTokenGenDefault) GenerateRefreshToken(data *AccessData) (string, error) {
+	return a.Format.Generate()
 }
 
 // AccessTokenGenDefault is the default authorization token generator
 type AccessTokenGenDefault struct {
+	// Format of the generated tokens. The generator of NewServer uses the
+	// Config.TokenFormat in use instead.
+	Format TokenFormat
+
+	config func() *ServerConfig
+}
+
+func (a *AccessTokenGenDefault) format() TokenFormat {
+	if a.config != nil {
+		return a.config().TokenFormat
+	}
+	return a.Format
 }
 
-// GenerateAccessToken generates base64-encoded UUID access and refresh tokens
+// GenerateAccessToken generates random access and refresh tokens, base64-encoded UUIDs by default
 func (a *AccessTokenGenDefault) GenerateAccessToken(data *AccessData, generaterefresh bool) (accesstoken string, refreshtoken string, err error) {
-	token := uuid.NewRandom()
-	accesstoken = base64.RawURLEncoding.EncodeToString([]byte(token))
+	format := a.format()
+	if accesstoken, err = format.Generate(); err != nil {
+		return "", "", err
+	}
 
 	if generaterefresh {
-		rtoken := uuid.NewRandom()
-		refreshtoken = base64.RawURLEncoding.EncodeToString([]byte(rtoken))
+		if refreshtoken, err = format.Generate(); err != nil {
+			return "", "", err
+		}
 	}
 	return
 }
+
+// tokenFormats is a set of token formats safe for concurrent use
+type tokenFormats struct {
+	mu      sync.Mutex
+	formats []TokenFormat
+}
+
+func (t *tokenFormats) add(f TokenFormat) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, e := range t.formats {
+		if e == f {
+			return
+		}
+	}
+	t.formats = append(t.formats, f)
+}
+
+// verifyChecksum returns true if the token has the checksum of one of the
+// formats
+func (t *tokenFormats) verifyChecksum(token string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, f := range t.formats {
+		if f.VerifyChecksum(token) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyConfigChecksum returns true if the token has the checksum of the
+// Config.TokenFormat in use, or of a previous one
+func (s *Server) verifyConfigChecksum(token string) bool {
+	c := s.config()
+	if c.TokenFormat.VerifyChecksum(token) {
+		return true
+	}
+	for _, f := range c.PreviousTokenFormats {
+		if f.VerifyChecksum(token) {
+			return true
+		}
+	}
+	return s.replacedTokenFormats.verifyChecksum(token)
+}
+
+// isMalformedToken returns true if the access or refresh token doesn't have
+// the checksum of the TokenFormat of the default generator issuing it, so
+// it is rejected without a storage lookup
+func (s *Server) isMalformedToken(token string, refresh bool) bool {
+	if refresh && s.RefreshTokenGen != nil {
+		gen, ok := s.RefreshTokenGen.(*RefreshTokenGenDefault)
+		return ok && !gen.Format.VerifyChecksum(token)
+	}
+	switch gen := s.AccessTokenGen.(type) {
+	case *AccessTokenGenDefault:
+		if gen.config != nil {
+			return !s.verifyConfigChecksum(token)
+		}
+		return !gen.Format.VerifyChecksum(token)
+	case *AccessTokenGenReference:
+		return !refresh && !gen.Format.VerifyChecksum(token)
+	}
+	return false
+}
+
+// loadStoredAccess loads the access data of an access token from the
+// storage, checking its checksum first
+func (s *Server) loadStoredAccess(ctx context.Context, storage Storage, token string) (*AccessData, error) {
+	if s.isMalformedToken(token, false) {
+		return nil, ErrNotFound
+	}
+	return storageLoadAccess(ctx, storage, token)
+}
+
+// loadRefresh loads the access data of a refresh token from the storage,
+// checking its checksum first
+func (s *Server) loadRefresh(ctx context.Context, storage Storage, token string) (*AccessData, error) {
+	if s.isMalformedToken(token, true) {
+		return nil, ErrNotFound
+	}
+	return storageLoadRefresh(ctx, storage, token)
+}
+
+const (
+	// Alphabet of base62 encoded tokens
+	BASE62_ALPHABET = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// RandomString returns a crypto-random string of length characters taken
+// uniformly from alphabet
+func RandomString(alphabet string, length int) (string, error) {
+	if len(alphabet) < 2 || len(alphabet) > 256 {
+		return "", errors.New("alphabet must have between 2 and 256 characters")
+	}
+
+	// reject bytes above the largest multiple of the alphabet length, to
+	// avoid a modulo bias
+	limit := 256 - 256%len(alphabet)
+	ret := make([]byte, 0, length)
+	buf := make([]byte, length+length/2+1)
+	for len(ret) < length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) >= limit {
+				continue
+			}
+			ret = append(ret, alphabet[int(b)%len(alphabet)])
+			if len(ret) == length {
+				break
+			}
+		}
+	}
+	return string(ret), nil
+}
+
+// AuthorizeTokenGenBase62 generates crypto-random base62 authorization codes
+type AuthorizeTokenGenBase62 struct {
+	// Number of characters, each one holding ~5.95 bits of entropy (default 22, ~131 bits)
+	Length int
+}
+
+// GenerateAuthorizeToken generates a crypto-random base62 code
+func (a *AuthorizeTokenGenBase62) GenerateAuthorizeToken(data *AuthorizeData) (string, error) {
+	length := a.Length
+	if length <= 0 {
+		length = 22
+	}
+	return RandomString(BASE62_ALPHABET, length)
+}
+
+// Alphabet of AuthorizeTokenGenShort: digits and upper case letters, which
+// QR codes encode compactly, without the ones easily confused (0, 1, I, L, O)
+const SHORT_CODE_ALPHABET = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+
+// AuthorizeTokenGenShort generates short crypto-random authorization codes,
+// for QR codes and short links. Short codes may collide: use a storage
+// implementing AuthorizeInserter, so a new code is generated on collision.
+type AuthorizeTokenGenShort struct {
+	// Characters of the codes - default SHORT_CODE_ALPHABET
+	Alphabet string
+
+	// Number of characters - default 10, ~49 bits with the default alphabet
+	Length int
+}
+
+// GenerateAuthorizeToken generates a short random code
+func (a *AuthorizeTokenGenShort) GenerateAuthorizeToken(data *AuthorizeData) (string, error) {
+	alphabet := a.Alphabet
+	if alphabet == "" {
+		alphabet = SHORT_CODE_ALPHABET
+	}
+	length := a.Length
+	if length <= 0 {
+		length = 10
+	}
+	return RandomString(alphabet, length)
+}
+
+// AuthorizeTokenGenUUIDv7 generates time ordered UUIDv7 authorization codes,
+// as described in RFC 9562. They carry 74 random bits.
+type AuthorizeTokenGenUUIDv7 struct {
+	Now func() time.Time
+}
+
+// GenerateAuthorizeToken generates a UUIDv7 code
+func (a *AuthorizeTokenGenUUIDv7) GenerateAuthorizeToken(data *AuthorizeData) (string, error) {
+	now := time.Now
+	if a.Now != nil {
+		now = a.Now
+	}
+
+	var u [16]byte
+	if _, err := rand.Read(u[6:]); err != nil {
+		return "", err
+	}
+	ms := uint64(now().UnixNano() / int64(time.Millisecond))
+	for i := 0; i < 6; i++ {
+		u[i] = byte(ms >> uint(40-8*i))
+	}
+	u[6] = (u[6] & 0x0f) | 0x70 // version 7
+	u[8] = (u[8] & 0x3f) | 0x80 // variant RFC 9562
+
+	h := hex.EncodeToString(u[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
+
+// AuthorizeTokenGenPrefixed adds a prefix to the codes of another generator,
+// to make them recognizable
+type AuthorizeTokenGenPrefixed struct {
+	Prefix string
+	Gen    AuthorizeTokenGen
+}
+
+// GenerateAuthorizeToken generates a prefixed code
+func (a *AuthorizeTokenGenPrefixed) GenerateAuthorizeToken(data *AuthorizeData) (string, error) {
+	code, err := a.Gen.GenerateAuthorizeToken(data)
+	if err != nil {
+		return "", err
+	}
+	return a.Prefix + code, nil
+}
@@ -0,0 +1,152 @@
+package osin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// JWTReservedClaims are the access token claims set by AccessTokenGenJWT,
+// which a ClaimsExtender may not set
+var JWTReservedClaims = map[string]bool{
+	"iss":       true,
+	"sub":       true,
+	"aud":       true,
+	"exp":       true,
+	"nbf":       true,
+	"iat":       true,
+	"jti":       true,
+	"client_id": true,
+	"scope":     true,
+	"auth_time": true,
+	"acr":       true,
+	"amr":       true,
+	"cnf":       true,
+}
+
+// ClaimsExtender returns custom claims added to a JWT access token, like
+// roles, tenant or permissions. The request is nil when the generator is
+// called without it, like by the shadow generator.
+type ClaimsExtender func(ar *AccessRequest, data *AccessData) (map[string]interface{}, error)
+
+// AccessTokenGenWithRequest is an optional interface access token
+// generators can implement to receive the access request
+type AccessTokenGenWithRequest interface {
+	GenerateAccessTokenWithRequest(ar *AccessRequest, data *AccessData, generaterefresh bool) (accesstoken string, refreshtoken string, err error)
+}
+
+// AccessTokenGenJWT generates JWT access tokens, as described in RFC 9068,
+// signed with a KeySet, and opaque refresh tokens. The subject is the local
+// one, pairwise identifiers are not applied.
+type AccessTokenGenJWT struct {
+	// Signing keys. Required.
+	KeySet *KeySet
+
+	// Issuer identifier of the server
+	Issuer string
+
+	// Audience of the tokens, the resource servers. The client id is used
+	// if empty.
+	Audience []string
+
+	// Format of the refresh tokens
+	RefreshFormat TokenFormat
+
+	// Adds custom claims, if not nil
+	ClaimsExtender ClaimsExtender
+}
+
+// GenerateAccessToken generates a JWT access token, without a request for
+// the ClaimsExtender
+func (a *AccessTokenGenJWT) GenerateAccessToken(data *AccessData, generaterefresh bool) (string, string, error) {
+	return a.GenerateAccessTokenWithRequest(nil, data, generaterefresh)
+}
+
+// GenerateAccessTokenWithRequest generates a JWT access token and an opaque
+// refresh token
+func (a *AccessTokenGenJWT) GenerateAccessTokenWithRequest(ar *AccessRequest, data *AccessData, generaterefresh bool) (accesstoken string, refreshtoken string, err error) {
+	if a.KeySet == nil {
+		return "", "", errors.New("jwt access token generator has no KeySet")
+	}
+	key, err := a.KeySet.SigningKey()
+	if err != nil {
+		return "", "", err
+	}
+	jti, err := (TokenFormat{Length: 16}).Generate()
+	if err != nil {
+		return "", "", err
+	}
+
+	claims := make(map[string]interface{})
+	if a.ClaimsExtender != nil {
+		extra, err := a.ClaimsExtender(ar, data)
+		if err != nil {
+			return "", "", err
+		}
+		for k, v := range extra {
+			if JWTReservedClaims[k] {
+				return "", "", fmt.Errorf("claims extender may not set the reserved claim %s", k)
+			}
+			claims[k] = v
+		}
+	}
+
+	clientId := data.Client.GetID()
+	claims["jti"] = jti
+	claims["client_id"] = clientId
+	claims["iat"] = data.CreatedAt.Unix()
+	claims["exp"] = data.ExpireAt().Unix()
+	if a.Issuer != "" {
+		claims["iss"] = a.Issuer
+	}
+	if len(a.Audience) > 0 {
+		claims["aud"] = a.Audience
+	} else {
+		claims["aud"] = clientId
+	}
+	if data.Subject != "" {
+		claims["sub"] = data.Subject
+	} else {
+		claims["sub"] = clientId
+	}
+	if data.Scope != "" {
+		// space separated, as in the scope parameter
+		claims["scope"] = strings.Join(strings.FieldsFunc(data.Scope, func(r rune) bool { return r == ' ' || r == ',' }), " ")
+	}
+	ac := data.AuthenticationContext
+	if !ac.AuthTime.IsZero() {
+		claims["auth_time"] = ac.AuthTime.Unix()
+	}
+	if ac.ACR != "" {
+		claims["acr"] = ac.ACR
+	}
+	if len(ac.AMR) > 0 {
+		claims["amr"] = ac.AMR
+	}
+	cnf := make(map[string]interface{})
+	if data.DPoPJKT != "" {
+		cnf["jkt"] = data.DPoPJKT
+	}
+	if data.CertThumbprint != "" {
+		cnf["x5t#S256"] = data.CertThumbprint
+	}
+	if len(cnf) > 0 {
+		claims["cnf"] = cnf
+	}
+
+	header := map[string]interface{}{"alg": key.Algorithm, "kid": key.ID, "typ": "at+jwt"}
+	ctx := context.Background()
+	if ar != nil {
+		ctx = ar.Context()
+	}
+	if accesstoken, err = a.KeySet.signWithKey(ctx, key, claims, header); err != nil {
+		return "", "", err
+	}
+	if generaterefresh {
+		if refreshtoken, err = a.RefreshFormat.Generate(); err != nil {
+			return "", "", err
+		}
+	}
+	return
+}
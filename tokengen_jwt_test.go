@@ -0,0 +1,125 @@
+package osin
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAccessTokenGenJWT(t *testing.T) {
+	ks, err := NewKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{PASSWORD}
+	server := NewServer(sconfig, NewTestingStorage())
+	gen := &AccessTokenGenJWT{
+		KeySet: ks,
+		Issuer: "http://localhost:14000",
+		ClaimsExtender: func(ar *AccessRequest, data *AccessData) (map[string]interface{}, error) {
+			if ar == nil {
+				t.Fatal("Access request should be passed")
+			}
+			return map[string]interface{}{"tenant": "t1", "roles": []string{"admin"}}, nil
+		},
+	}
+	server.AccessTokenGen = gen
+
+	newRequest := func() *http.Request {
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = url.Values{
+			"grant_type": {string(PASSWORD)},
+			"username":   {"testing"},
+			"password":   {"testing"},
+			"scope":      {"read,write"},
+		}
+		req.PostForm = req.Form
+		return req
+	}
+
+	resp := server.NewResponse()
+	req := newRequest()
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		ar.Authorized = true
+		ar.Subject = "user-1"
+		ar.DPoPJKT = "jkt-1"
+		ar.CertThumbprint = "x5t-1"
+		server.FinishAccessRequest(resp, req, ar)
+	}
+	if resp.IsError {
+		t.Fatalf("Request failed: %v", resp.Output)
+	}
+
+	token, err := ks.Verify(resp.Output["access_token"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Header["typ"] != "at+jwt" {
+		t.Fatalf("Unexpected header: %v", token.Header)
+	}
+	expected := map[string]string{
+		"iss":       "http://localhost:14000",
+		"sub":       "user-1",
+		"client_id": "1234",
+		"scope":     "read write",
+		"tenant":    "t1",
+	}
+	for k, v := range expected {
+		if token.StringClaim(k) != v {
+			t.Errorf("Claim %s should be %s, got %v", k, v, token.Claims[k])
+		}
+	}
+	cnf, _ := token.Claims["cnf"].(map[string]interface{})
+	if cnf["jkt"] != "jkt-1" || cnf["x5t#S256"] != "x5t-1" {
+		t.Errorf("Unexpected cnf claim: %v", token.Claims["cnf"])
+	}
+
+	// reserved claims are protected
+	gen.ClaimsExtender = func(ar *AccessRequest, data *AccessData) (map[string]interface{}, error) {
+		return map[string]interface{}{"sub": "admin"}, nil
+	}
+	resp = server.NewResponse()
+	req = newRequest()
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		ar.Authorized = true
+		server.FinishAccessRequest(resp, req, ar)
+	}
+	if resp.ErrorId != E_SERVER_ERROR {
+		t.Fatalf("Reserved claims should be refused: %v", resp.Output)
+	}
+}
+
+func TestAccessTokenGenJWTContext(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow := &slowSigner{Signer: priv, release: make(chan struct{})}
+	defer close(slow.release)
+	key, err := NewSigningKey("hsm-1", slow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := &KeySet{}
+	ks.AddKey(key)
+	gen := &AccessTokenGenJWT{KeySet: ks}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ar := (&AccessRequest{}).WithContext(ctx)
+	data := &AccessData{Client: &DefaultClient{Id: "1234"}, CreatedAt: time.Now(), ExpiresIn: 3600}
+	if _, _, err = gen.GenerateAccessTokenWithRequest(ar, data, false); err != context.Canceled {
+		t.Fatalf("Expected the request cancellation, got %v", err)
+	}
+}
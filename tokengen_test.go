@@ -0,0 +1,304 @@
+package osin
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRandomString(t *testing.T) {
+	s, err := RandomString("ab", 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s) != 64 || strings.Trim(s, "ab") != "" {
+		t.Fatalf("Unexpected random string: %s", s)
+	}
+	if _, err := RandomString("a", 10); err == nil {
+		t.Fatalf("Alphabet of 1 character should fail")
+	}
+}
+
+func TestAuthorizeTokenGenBase62(t *testing.T) {
+	gen := &AuthorizeTokenGenBase62{}
+	code, err := gen.GenerateAuthorizeToken(&AuthorizeData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile("^[0-9A-Za-z]{22}$").MatchString(code) {
+		t.Fatalf("Unexpected code: %s", code)
+	}
+
+	gen.Length = 8
+	if code, _ = gen.GenerateAuthorizeToken(&AuthorizeData{}); len(code) != 8 {
+		t.Fatalf("Unexpected code length: %s", code)
+	}
+}
+
+func TestAuthorizeTokenGenShort(t *testing.T) {
+	code, err := (&AuthorizeTokenGenShort{}).GenerateAuthorizeToken(&AuthorizeData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile("^[" + SHORT_CODE_ALPHABET + "]{10}$").MatchString(code) {
+		t.Fatalf("Unexpected code: %s", code)
+	}
+}
+
+// insertingStorage detects code collisions
+type insertingStorage struct {
+	*TestingStorage
+}
+
+func (s *insertingStorage) Clone() Storage { return s }
+
+func (s *insertingStorage) InsertAuthorize(data *AuthorizeData) error {
+	if _, ok := s.authorize[data.Code]; ok {
+		return ErrAlreadyExists
+	}
+	return s.SaveAuthorize(data)
+}
+
+func TestAuthorizeCodeCollision(t *testing.T) {
+	testcases := map[string]struct {
+		Attempts int
+		Code     string
+	}{
+		"retried":   {Attempts: 3, Code: "3"},
+		"exhausted": {Attempts: 2},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			storage := &insertingStorage{NewTestingStorage()}
+			storage.authorize["1"] = &AuthorizeData{Code: "1"}
+			storage.authorize["2"] = &AuthorizeData{Code: "2"}
+
+			sconfig := NewServerConfig()
+			sconfig.AuthorizeCodeAttempts = tc.Attempts
+			server := NewServer(sconfig, storage)
+			server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+			resp := server.NewResponse()
+
+			req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Form = url.Values{"response_type": {string(CODE)}, "client_id": {"1234"}}
+			if ar := server.HandleAuthorizeRequest(resp, req); ar != nil {
+				ar.Authorized = true
+				server.FinishAuthorizeRequest(resp, req, ar)
+			}
+
+			if tc.Code == "" {
+				if resp.ErrorId != E_SERVER_ERROR || resp.InternalError != ErrAlreadyExists {
+					t.Fatalf("Expected a collision error, got %v", resp.Output)
+				}
+				return
+			}
+			if resp.IsError || resp.Output["code"] != tc.Code {
+				t.Fatalf("Expected code %s, got %v", tc.Code, resp.Output)
+			}
+		})
+	}
+}
+
+func TestAuthorizeTokenGenUUIDv7(t *testing.T) {
+	now := time.Unix(1700000000, 123000000)
+	gen := &AuthorizeTokenGenUUIDv7{Now: func() time.Time { return now }}
+	code, err := gen.GenerateAuthorizeToken(&AuthorizeData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile("^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$").MatchString(code) {
+		t.Fatalf("Unexpected UUIDv7: %s", code)
+	}
+	// 1700000000123 ms
+	if !strings.HasPrefix(code, "018bcfe5-687b-") {
+		t.Fatalf("Unexpected UUIDv7 timestamp: %s", code)
+	}
+}
+
+func TestAuthorizeTokenGenPrefixed(t *testing.T) {
+	gen := &AuthorizeTokenGenPrefixed{Prefix: "ac_", Gen: &TestingAuthorizeTokenGen{}}
+	if code, err := gen.GenerateAuthorizeToken(&AuthorizeData{}); err != nil || code != "ac_1" {
+		t.Fatalf("Unexpected code: %s %v", code, err)
+	}
+}
+
+func TestTokenFormat(t *testing.T) {
+	testcases := map[string]struct {
+		Format  TokenFormat
+		Pattern string
+	}{
+		"default": {
+			Pattern: "^[0-9A-Za-z_-]{22}$",
+		},
+		"256-bit base64url": {
+			Format:  TokenFormat{Length: 32},
+			Pattern: "^[0-9A-Za-z_-]{43}$",
+		},
+		"256-bit base62": {
+			Format:  TokenFormat{Length: 32, Encoding: TOKEN_ENCODING_BASE62},
+			Pattern: "^[0-9A-Za-z]{43}$",
+		},
+		"256-bit hex": {
+			Format:  TokenFormat{Length: 32, Encoding: TOKEN_ENCODING_HEX},
+			Pattern: "^[0-9a-f]{64}$",
+		},
+		"base62 with checksum": {
+			Format:  TokenFormat{Length: 32, Encoding: TOKEN_ENCODING_BASE62, Checksum: true},
+			Pattern: "^[0-9A-Za-z]{49}$",
+		},
+		"hex with checksum": {
+			Format:  TokenFormat{Length: 16, Encoding: TOKEN_ENCODING_HEX, Checksum: true},
+			Pattern: "^[0-9a-f]{40}$",
+		},
+	}
+
+	for k, tc := range testcases {
+		token, err := tc.Format.Generate()
+		if err != nil {
+			t.Errorf("%s: %v", k, err)
+			continue
+		}
+		if !regexp.MustCompile(tc.Pattern).MatchString(token) {
+			t.Errorf("%s: unexpected token %s", k, token)
+		}
+		if !tc.Format.VerifyChecksum(token) {
+			t.Errorf("%s: checksum should verify", k)
+		}
+		if tc.Format.Checksum {
+			tampered := []byte(token)
+			if tampered[0] == 'a' {
+				tampered[0] = 'b'
+			} else {
+				tampered[0] = 'a'
+			}
+			if tc.Format.VerifyChecksum(string(tampered)) {
+				t.Errorf("%s: checksum of tampered token should not verify", k)
+			}
+		}
+	}
+
+	if _, err := (TokenFormat{Encoding: "base32"}).Generate(); err == nil {
+		t.Errorf("Unknown encoding should fail")
+	}
+}
+
+func TestServerDefaultTokenFormat(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.TokenFormat = TokenFormat{Length: 32, Encoding: TOKEN_ENCODING_HEX}
+	server := NewServer(sconfig, NewTestingStorage())
+
+	access, refresh, err := server.AccessTokenGen.GenerateAccessToken(&AccessData{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(access) != 64 || len(refresh) != 64 {
+		t.Fatalf("Unexpected token lengths: %s %s", access, refresh)
+	}
+	if code, _ := server.AuthorizeTokenGen.GenerateAuthorizeToken(&AuthorizeData{}); len(code) != 64 {
+		t.Fatalf("Unexpected code length: %s", code)
+	}
+}
+
+func TestTokenChecksumLookup(t *testing.T) {
+	storage := newCountingStorage()
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{REFRESH_TOKEN}
+	sconfig.TokenFormat = TokenFormat{Length: 16, Encoding: TOKEN_ENCODING_HEX, Checksum: true}
+	server := NewServer(sconfig, storage)
+	previous, _, err := server.AccessTokenGen.GenerateAccessToken(&AccessData{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the format is read from the configuration in use
+	updated := *sconfig
+	updated.TokenFormat = TokenFormat{Length: 16, Encoding: TOKEN_ENCODING_BASE62, Checksum: true}
+	if err := server.UpdateConfig(&updated); err != nil {
+		t.Fatal(err)
+	}
+	access, _, err := server.AccessTokenGen.GenerateAccessToken(&AccessData{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated.TokenFormat.VerifyChecksum(access) {
+		t.Fatalf("Token should have the updated format: %s", access)
+	}
+
+	// tokens of the replaced format are still looked up
+	for i, token := range []string{access, previous} {
+		server.ValidateTokens(context.Background(), []string{token})
+		if storage.calls["LoadAccess"] != i+1 {
+			t.Fatalf("Token %s should be looked up", token)
+		}
+	}
+	storage.calls["LoadAccess"] = 0
+
+	// tokens without the checksum are not looked up
+	if data, err := server.ValidateTokens(context.Background(), []string{"9999"}); err != nil || data[0] != nil {
+		t.Fatalf("Unexpected validation %v, %v", data, err)
+	}
+	if storage.calls["LoadAccess"] != 0 {
+		t.Errorf("Malformed access token should not be looked up")
+	}
+
+	resp := server.NewResponse()
+	req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("1234", "aabbccdd")
+	req.Form = url.Values{"grant_type": {string(REFRESH_TOKEN)}, "refresh_token": {"r9999"}}
+	req.PostForm = req.Form
+	if ar := server.HandleAccessRequest(resp, req); ar != nil || resp.ErrorId != E_INVALID_GRANT {
+		t.Fatalf("Malformed refresh token should be invalid: %v", resp.Output)
+	}
+	if storage.calls["LoadRefresh"] != 0 {
+		t.Errorf("Malformed refresh token should not be looked up")
+	}
+}
+
+func TestRefreshTokenGen(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{REFRESH_TOKEN}
+	server := NewServer(sconfig, NewTestingStorage())
+	gen := &TestingAccessTokenGen{}
+	server.AccessTokenGen = gen
+	server.RefreshTokenGen = &RefreshTokenGenDefault{Format: TokenFormat{Length: 32, Encoding: TOKEN_ENCODING_HEX}}
+	resp := server.NewResponse()
+
+	req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("1234", "aabbccdd")
+	req.Form = url.Values{"grant_type": {string(REFRESH_TOKEN)}, "refresh_token": {"r9999"}}
+	req.PostForm = make(url.Values)
+
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		ar.Authorized = true
+		server.FinishAccessRequest(resp, req, ar)
+	}
+	if resp.IsError {
+		t.Fatalf("Error in response: %v", resp.Output)
+	}
+	if d := resp.Output["access_token"]; d != "1" {
+		t.Fatalf("Unexpected access token: %v", d)
+	}
+	refresh, _ := resp.Output["refresh_token"].(string)
+	if len(refresh) != 64 {
+		t.Fatalf("Unexpected refresh token: %s", refresh)
+	}
+	if gen.rcounter != 0 {
+		t.Error("Access token generator should not generate the refresh token")
+	}
+	if _, err := server.Storage.LoadRefresh(refresh); err != nil {
+		t.Fatalf("Refresh token not saved: %s", err)
+	}
+}
@@ -0,0 +1,60 @@
+package osin
+
+// TokenAccessPolicy decides whether an authenticated client may introspect
+// or revoke a token, like to keep the clients of a tenant from reading the
+// tokens of another
+type TokenAccessPolicy interface {
+	// AllowTokenAccess returns true if the caller may use the endpoint,
+	// ENDPOINT_INTROSPECTION or ENDPOINT_REVOCATION, on the token of data
+	AllowTokenAccess(endpoint Endpoint, caller Client, data *AccessData) bool
+}
+
+// TokenAccessPolicyFunc allows a function to be used as a TokenAccessPolicy
+type TokenAccessPolicyFunc func(endpoint Endpoint, caller Client, data *AccessData) bool
+
+// AllowTokenAccess calls f(endpoint, caller, data)
+func (f TokenAccessPolicyFunc) AllowTokenAccess(endpoint Endpoint, caller Client, data *AccessData) bool {
+	return f(endpoint, caller, data)
+}
+
+// TokenAccessRules is a TokenAccessPolicy letting clients introspect and
+// revoke their own tokens, and the listed clients, like resource servers,
+// the tokens of any client
+type TokenAccessRules struct {
+	// Client ids allowed to introspect any token
+	IntrospectAny []string
+
+	// Client ids allowed to revoke any token
+	RevokeAny []string
+}
+
+// AllowTokenAccess implements the TokenAccessPolicy interface
+func (p *TokenAccessRules) AllowTokenAccess(endpoint Endpoint, caller Client, data *AccessData) bool {
+	if caller.GetID() == data.Client.GetID() {
+		return true
+	}
+	switch endpoint {
+	case ENDPOINT_INTROSPECTION:
+		return stringInList(caller.GetID(), p.IntrospectAny)
+	case ENDPOINT_REVOCATION:
+		return stringInList(caller.GetID(), p.RevokeAny)
+	}
+	return false
+}
+
+// allowTokenAccess consults the TokenAccessPolicy, or else lets clients
+// introspect and revoke their own tokens, and the Config.IntrospectionClients
+// introspect any token. Public clients, which anyone can act as, may not
+// introspect tokens.
+func (s *Server) allowTokenAccess(w *Response, endpoint Endpoint, caller Client, data *AccessData) bool {
+	if endpoint == ENDPOINT_INTROSPECTION && s.isPublicClient(caller) {
+		return false
+	}
+	if s.TokenAccessPolicy != nil {
+		return s.TokenAccessPolicy.AllowTokenAccess(endpoint, caller, data)
+	}
+	if caller.GetID() == data.Client.GetID() {
+		return true
+	}
+	return endpoint == ENDPOINT_INTROSPECTION && stringInList(caller.GetID(), s.requestConfig(w).IntrospectionClients)
+}
@@ -0,0 +1,84 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestTokenAccessPolicy(t *testing.T) {
+	tests := map[string]struct {
+		Policy               TokenAccessPolicy
+		IntrospectionClients []string
+		Endpoint             Endpoint
+		ClientId             string
+		Allowed              bool
+	}{
+		"default introspect own":      {Endpoint: ENDPOINT_INTROSPECTION, ClientId: "1234", Allowed: true},
+		"default introspect other":    {Endpoint: ENDPOINT_INTROSPECTION, ClientId: "other"},
+		"default introspect resource": {IntrospectionClients: []string{"rs"}, Endpoint: ENDPOINT_INTROSPECTION, ClientId: "rs", Allowed: true},
+		"default introspect public":   {IntrospectionClients: []string{"public"}, Endpoint: ENDPOINT_INTROSPECTION, ClientId: "public"},
+		"default revoke own":          {Endpoint: ENDPOINT_REVOCATION, ClientId: "1234", Allowed: true},
+		"default revoke other":        {Endpoint: ENDPOINT_REVOCATION, ClientId: "other"},
+		"rules introspect public":     {Policy: &TokenAccessRules{IntrospectAny: []string{"public"}}, Endpoint: ENDPOINT_INTROSPECTION, ClientId: "public"},
+		"rules introspect own":        {Policy: &TokenAccessRules{}, Endpoint: ENDPOINT_INTROSPECTION, ClientId: "1234", Allowed: true},
+		"rules introspect other":      {Policy: &TokenAccessRules{IntrospectAny: []string{"rs"}}, Endpoint: ENDPOINT_INTROSPECTION, ClientId: "other"},
+		"rules introspect resource":   {Policy: &TokenAccessRules{IntrospectAny: []string{"rs"}}, Endpoint: ENDPOINT_INTROSPECTION, ClientId: "rs", Allowed: true},
+		"rules revoke resource":       {Policy: &TokenAccessRules{IntrospectAny: []string{"rs"}}, Endpoint: ENDPOINT_REVOCATION, ClientId: "rs"},
+		"rules revoke any":            {Policy: &TokenAccessRules{RevokeAny: []string{"rs"}}, Endpoint: ENDPOINT_REVOCATION, ClientId: "rs", Allowed: true},
+		"func": {
+			Policy: TokenAccessPolicyFunc(func(endpoint Endpoint, caller Client, data *AccessData) bool {
+				return false
+			}),
+			Endpoint: ENDPOINT_INTROSPECTION,
+			ClientId: "1234",
+		},
+	}
+
+	for k, test := range tests {
+		storage := NewTestingStorage()
+		storage.SetClient("other", &DefaultClient{Id: "other", Secret: "secret", RedirectUri: "http://localhost:14000/appauth"})
+		storage.SetClient("rs", &DefaultClient{Id: "rs", Secret: "secret", RedirectUri: "http://localhost:14000/appauth"})
+		storage.SetClient("public", &DefaultClient{Id: "public", RedirectUri: "http://localhost:14000/appauth"})
+		sconfig := NewServerConfig()
+		sconfig.IntrospectionClients = test.IntrospectionClients
+		server := NewServer(sconfig, storage)
+		server.TokenAccessPolicy = test.Policy
+
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/"+string(test.Endpoint), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		secret := "secret"
+		switch test.ClientId {
+		case "1234":
+			secret = "aabbccdd"
+		case "public":
+			secret = ""
+		}
+		req.SetBasicAuth(test.ClientId, secret)
+		req.Form = url.Values{"token": {"9999"}}
+		req.PostForm = req.Form
+
+		var allowed bool
+		if test.Endpoint == ENDPOINT_INTROSPECTION {
+			if ir := server.HandleIntrospectionRequest(resp, req); ir != nil {
+				server.FinishIntrospectionRequest(resp, req, ir)
+			}
+			allowed = resp.Output["active"] == true
+		} else {
+			if rr := server.HandleRevocationRequest(resp, req); rr != nil {
+				server.FinishRevocationRequest(resp, req, rr)
+			}
+			_, err := storage.LoadAccess("9999")
+			allowed = err != nil
+			if !allowed && resp.ErrorId != E_UNAUTHORIZED_CLIENT {
+				t.Errorf("%s: expected %s, got %q", k, E_UNAUTHORIZED_CLIENT, resp.ErrorId)
+			}
+		}
+		if allowed != test.Allowed {
+			t.Errorf("%s: expected allowed %v, got %v: %v", k, test.Allowed, allowed, resp.Output)
+		}
+	}
+}
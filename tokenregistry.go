@@ -0,0 +1,152 @@
+package osin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// AccessTokenGenRegistry is an AccessTokenGen dispatching to one of several
+// generators keyed by token prefix, to migrate between token formats, like
+// from opaque tokens to JWTs, with the tokens of all formats valid during
+// the transition. Tokens not starting with the prefix of their generator
+// get it prepended, so JWTs can be registered with the "eyJ" prefix they
+// already have.
+type AccessTokenGenRegistry struct {
+	// Chooses the prefix of the generator of the request, like for a
+	// gradual rollout. The current generator is used if nil or blank.
+	Select func(ar *AccessRequest) string
+
+	mu      sync.RWMutex
+	current string
+	entries []*registeredTokenGen
+}
+
+type registeredTokenGen struct {
+	prefix    string
+	gen       AccessTokenGen
+	validator TokenIntrospector
+}
+
+// NewAccessTokenGenRegistry creates an empty AccessTokenGenRegistry
+func NewAccessTokenGenRegistry() *AccessTokenGenRegistry {
+	return &AccessTokenGenRegistry{}
+}
+
+// Register adds the generator of the tokens with the prefix, and the
+// validator of its tokens, if any. The generator replaces the one of the
+// same prefix. The first generator registered is the current one.
+func (r *AccessTokenGenRegistry) Register(prefix string, gen AccessTokenGen, validator TokenIntrospector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.entries) == 0 {
+		r.current = prefix
+	}
+	entry := &registeredTokenGen{prefix: prefix, gen: gen, validator: validator}
+	for i, e := range r.entries {
+		if e.prefix == prefix {
+			r.entries[i] = entry
+			return
+		}
+	}
+	// keep longer prefixes first, so they win the lookups
+	i := 0
+	for i < len(r.entries) && len(r.entries[i].prefix) >= len(prefix) {
+		i++
+	}
+	r.entries = append(r.entries, nil)
+	copy(r.entries[i+1:], r.entries[i:])
+	r.entries[i] = entry
+}
+
+// SetCurrent sets the prefix of the generator of the new tokens
+func (r *AccessTokenGenRegistry) SetCurrent(prefix string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.entry(prefix) == nil {
+		return errors.New("no access token generator with prefix " + prefix)
+	}
+	r.current = prefix
+	return nil
+}
+
+// entry returns the generator registered with the prefix, or nil
+func (r *AccessTokenGenRegistry) entry(prefix string) *registeredTokenGen {
+	for _, e := range r.entries {
+		if e.prefix == prefix {
+			return e
+		}
+	}
+	return nil
+}
+
+// Lookup returns the prefix and the generator of the token, false if no
+// registered prefix matches
+func (r *AccessTokenGenRegistry) Lookup(token string) (string, AccessTokenGen, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, e := range r.entries {
+		if strings.HasPrefix(token, e.prefix) {
+			return e.prefix, e.gen, true
+		}
+	}
+	return "", nil, false
+}
+
+// GenerateAccessToken generates a token with the current generator
+func (r *AccessTokenGenRegistry) GenerateAccessToken(data *AccessData, generaterefresh bool) (string, string, error) {
+	return r.GenerateAccessTokenWithRequest(nil, data, generaterefresh)
+}
+
+// GenerateAccessTokenWithRequest generates a token with the generator
+// chosen by Select, or the current one
+func (r *AccessTokenGenRegistry) GenerateAccessTokenWithRequest(ar *AccessRequest, data *AccessData, generaterefresh bool) (accesstoken string, refreshtoken string, err error) {
+	prefix := ""
+	if r.Select != nil && ar != nil {
+		prefix = r.Select(ar)
+	}
+
+	r.mu.RLock()
+	if prefix == "" {
+		prefix = r.current
+	}
+	e := r.entry(prefix)
+	r.mu.RUnlock()
+	if e == nil {
+		return "", "", errors.New("no access token generator with prefix " + prefix)
+	}
+
+	if gen, ok := e.gen.(AccessTokenGenWithRequest); ok && ar != nil {
+		accesstoken, refreshtoken, err = gen.GenerateAccessTokenWithRequest(ar, data, generaterefresh)
+	} else {
+		accesstoken, refreshtoken, err = e.gen.GenerateAccessToken(data, generaterefresh)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if !strings.HasPrefix(accesstoken, e.prefix) {
+		accesstoken = e.prefix + accesstoken
+	}
+	return accesstoken, refreshtoken, nil
+}
+
+// IntrospectToken validates the token with the validator registered with
+// its prefix, so the registry can be the Introspector of a Verifier.
+// Returns ErrInvalidToken for tokens of unknown prefixes or of generators
+// without validator.
+func (r *AccessTokenGenRegistry) IntrospectToken(ctx context.Context, token string) (*VerifiedToken, error) {
+	r.mu.RLock()
+	var validator TokenIntrospector
+	for _, e := range r.entries {
+		if strings.HasPrefix(token, e.prefix) {
+			validator = e.validator
+			break
+		}
+	}
+	r.mu.RUnlock()
+	if validator == nil {
+		return nil, ErrInvalidToken
+	}
+	return validator.IntrospectToken(ctx, token)
+}
@@ -0,0 +1,81 @@
+package osin
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAccessTokenGenRegistry(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+	server := NewServer(sconfig, NewTestingStorage())
+
+	validator := func(name string) TokenIntrospector {
+		return TokenIntrospectorFunc(func(ctx context.Context, token string) (*VerifiedToken, error) {
+			return &VerifiedToken{Token: token, Subject: name}, nil
+		})
+	}
+	registry := NewAccessTokenGenRegistry()
+	registry.Register("at_", &AccessTokenGenDefault{}, validator("opaque"))
+	registry.Register("at_v2_", &AccessTokenGenDefault{Format: TokenFormat{Length: 32, Encoding: TOKEN_ENCODING_HEX}}, validator("v2"))
+	server.AccessTokenGen = registry
+
+	issue := func() string {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = url.Values{"grant_type": {string(CLIENT_CREDENTIALS)}}
+		req.PostForm = make(url.Values)
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if resp.IsError {
+			t.Fatalf("Error in response: %v", resp.Output)
+		}
+		return resp.Output["access_token"].(string)
+	}
+
+	old := issue()
+	if !strings.HasPrefix(old, "at_") || strings.HasPrefix(old, "at_v2_") {
+		t.Fatalf("Unexpected token of the first generator: %s", old)
+	}
+	if err := registry.SetCurrent("at_v2_"); err != nil {
+		t.Fatal(err)
+	}
+	migrated := issue()
+	if !strings.HasPrefix(migrated, "at_v2_") || len(migrated) != 70 {
+		t.Fatalf("Unexpected token of the current generator: %s", migrated)
+	}
+	if err := registry.SetCurrent("none_"); err == nil {
+		t.Error("Unknown prefix should not be current")
+	}
+
+	// both formats stay valid
+	for token, expected := range map[string]string{old: "opaque", migrated: "v2"} {
+		if _, err := server.Storage.LoadAccess(token); err != nil {
+			t.Errorf("Token %s not saved: %s", token, err)
+		}
+		vt, err := registry.IntrospectToken(context.Background(), token)
+		if err != nil || vt.Subject != expected {
+			t.Errorf("Token %s validated by %v: %v", token, vt, err)
+		}
+	}
+	if _, err := registry.IntrospectToken(context.Background(), "unknown"); err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken, got %v", err)
+	}
+
+	// selected by request
+	registry.Select = func(ar *AccessRequest) string {
+		return "at_"
+	}
+	if token := issue(); strings.HasPrefix(token, "at_v2_") {
+		t.Errorf("Selected generator not used: %s", token)
+	}
+}
@@ -0,0 +1,114 @@
+package osin
+
+// TokenResponse is the typed output of a successful token request (RFC 6749
+// section 5.1), also sent by the implicit flow redirects. Set it with
+// Response.SetTokenResponse instead of writing the Output keys.
+type TokenResponse struct {
+	AccessToken string
+	TokenType   string
+	ExpiresIn   int32
+
+	// Set with RefreshExpiresIn only if a refresh token was issued
+	RefreshToken     string
+	RefreshExpiresIn int32
+
+	Scope                string
+	AuthorizationDetails AuthorizationDetails
+
+	// Other fields, like the token type specific ones. The typed fields
+	// take precedence.
+	Extra map[string]interface{}
+}
+
+// AuthorizeResponse is the typed output of a successful authorization code
+// request, sent in the redirect. Set it with Response.SetAuthorizeResponse.
+type AuthorizeResponse struct {
+	Code  string
+	State string
+
+	// Other fields. The typed fields take precedence.
+	Extra map[string]interface{}
+}
+
+// SetTokenResponse sets the fields of the token response in the output,
+// keeping the other fields
+func (r *Response) SetTokenResponse(t *TokenResponse) {
+	for k, v := range t.Extra {
+		r.Output[k] = v
+	}
+	r.Output["access_token"] = t.AccessToken
+	r.Output["token_type"] = t.TokenType
+	r.Output["expires_in"] = t.ExpiresIn
+	if t.RefreshToken != "" {
+		r.Output["refresh_token"] = t.RefreshToken
+		r.Output["refresh_expires_in"] = t.RefreshExpiresIn
+	}
+	if t.Scope != "" {
+		r.Output["scope"] = t.Scope
+	}
+	if len(t.AuthorizationDetails) > 0 {
+		r.Output["authorization_details"] = t.AuthorizationDetails
+	}
+}
+
+// TokenResponse returns the token response of the output, false if it has
+// no access token. The fields not typed are returned in Extra.
+func (r *Response) TokenResponse() (*TokenResponse, bool) {
+	ret := &TokenResponse{}
+	extra := make(map[string]interface{})
+	for k, v := range r.Output {
+		switch k {
+		case "access_token":
+			ret.AccessToken, _ = v.(string)
+		case "token_type":
+			ret.TokenType, _ = v.(string)
+		case "expires_in":
+			ret.ExpiresIn, _ = v.(int32)
+		case "refresh_token":
+			ret.RefreshToken, _ = v.(string)
+		case "refresh_expires_in":
+			ret.RefreshExpiresIn, _ = v.(int32)
+		case "scope":
+			ret.Scope, _ = v.(string)
+		case "authorization_details":
+			ret.AuthorizationDetails, _ = v.(AuthorizationDetails)
+		default:
+			extra[k] = v
+		}
+	}
+	if len(extra) > 0 {
+		ret.Extra = extra
+	}
+	return ret, ret.AccessToken != ""
+}
+
+// SetAuthorizeResponse sets the fields of the authorize response in the
+// output, keeping the other fields
+func (r *Response) SetAuthorizeResponse(a *AuthorizeResponse) {
+	for k, v := range a.Extra {
+		r.Output[k] = v
+	}
+	r.Output["code"] = a.Code
+	r.Output["state"] = a.State
+}
+
+// AuthorizeResponse returns the authorize response of the output, false if
+// it has no code. The fields not typed are returned in Extra.
+func (r *Response) AuthorizeResponse() (*AuthorizeResponse, bool) {
+	ret := &AuthorizeResponse{}
+	extra := make(map[string]interface{})
+	for k, v := range r.Output {
+		switch k {
+		case "code":
+			ret.Code, _ = v.(string)
+		case "state":
+			ret.State, _ = v.(string)
+		default:
+			extra[k] = v
+		}
+	}
+	if len(extra) > 0 {
+		ret.Extra = extra
+	}
+	return ret, ret.Code != ""
+}
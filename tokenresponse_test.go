@@ -0,0 +1,65 @@
+package osin
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenResponse(t *testing.T) {
+	resp := NewResponse(NewTestingStorage())
+	expected := &TokenResponse{
+		AccessToken:      "1",
+		TokenType:        "mac",
+		ExpiresIn:        3600,
+		RefreshToken:     "r1",
+		RefreshExpiresIn: 86400,
+		Scope:            "a b",
+		Extra:            map[string]interface{}{"mac_key": "k", "token_type": "ignored"},
+	}
+	resp.SetTokenResponse(expected)
+
+	if resp.Output["token_type"] != "mac" || resp.Output["mac_key"] != "k" {
+		t.Fatalf("Unexpected output: %v", resp.Output)
+	}
+	got, ok := resp.TokenResponse()
+	if !ok {
+		t.Fatal("Output should have a token response")
+	}
+	expected.Extra = map[string]interface{}{"mac_key": "k"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, got)
+	}
+
+	// optional fields are omitted
+	resp = NewResponse(NewTestingStorage())
+	resp.SetTokenResponse(&TokenResponse{AccessToken: "1", TokenType: "Bearer", ExpiresIn: 60})
+	for _, k := range []string{"refresh_token", "refresh_expires_in", "scope", "authorization_details"} {
+		if _, ok := resp.Output[k]; ok {
+			t.Errorf("Unexpected %s in output", k)
+		}
+	}
+	if _, ok := NewResponse(NewTestingStorage()).TokenResponse(); ok {
+		t.Error("Empty output should have no token response")
+	}
+}
+
+func TestAuthorizeResponse(t *testing.T) {
+	sconfig := NewServerConfig()
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+	resp := server.NewResponse()
+	ar := &AuthorizeRequest{
+		Type:        CODE,
+		Client:      &DefaultClient{Id: "1234", RedirectUri: "http://localhost:14000/appauth"},
+		RedirectUri: "http://localhost:14000/appauth",
+		State:       "a",
+		Expiration:  250,
+		Authorized:  true,
+	}
+	server.FinishAuthorizeRequest(resp, nil, ar)
+
+	got, ok := resp.AuthorizeResponse()
+	if !ok || got.Code != "1" || got.State != "a" || got.Extra != nil {
+		t.Fatalf("Unexpected authorize response: %+v", got)
+	}
+}
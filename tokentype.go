@@ -0,0 +1,53 @@
+package osin
+
+// ClientTokenType is an optional interface clients can implement to be
+// issued tokens of a type other than Config.TokenType, like "DPoP"
+type ClientTokenType interface {
+	// GetTokenType returns the token_type of the client tokens, or blank
+	// for the configured one
+	GetTokenType() string
+}
+
+// TokenTypeSelector decides the token type of access requests
+type TokenTypeSelector interface {
+	// SelectTokenType returns the token_type of the tokens issued for the
+	// request, and the type specific fields of the token response, like
+	// mac_key and mac_algorithm. A blank type selects the client or
+	// configured one.
+	SelectTokenType(ar *AccessRequest) (string, map[string]interface{}, error)
+}
+
+// TokenTypeSelectorFunc allows a function to be used as a TokenTypeSelector
+type TokenTypeSelectorFunc func(ar *AccessRequest) (string, map[string]interface{}, error)
+
+// SelectTokenType calls f(ar)
+func (f TokenTypeSelectorFunc) SelectTokenType(ar *AccessRequest) (string, map[string]interface{}, error) {
+	return f(ar)
+}
+
+// selectTokenType resolves the token type of the request, from the
+// request itself, the TokenTypeSelector, the client and the configuration,
+// in that order
+func (s *Server) selectTokenType(w *Response, ar *AccessRequest) (string, map[string]interface{}, error) {
+	if ar.TokenType != "" {
+		return ar.TokenType, ar.TokenTypeFields, nil
+	}
+	if s.TokenTypeSelector != nil {
+		tokenType, fields, err := s.TokenTypeSelector.SelectTokenType(ar)
+		if err != nil || tokenType != "" {
+			return tokenType, fields, err
+		}
+	}
+	if c, ok := ar.Client.(ClientTokenType); ok && c.GetTokenType() != "" {
+		return c.GetTokenType(), nil, nil
+	}
+	return s.requestConfig(w).TokenType, nil, nil
+}
+
+// accessTokenType returns the token type the access data was issued with
+func (s *Server) accessTokenType(w *Response, ad *AccessData) string {
+	if ad.TokenType != "" {
+		return ad.TokenType
+	}
+	return s.requestConfig(w).TokenType
+}
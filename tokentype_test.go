@@ -0,0 +1,97 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+type tokenTypeClient struct {
+	DefaultClient
+	TokenType string
+}
+
+func (c *tokenTypeClient) GetTokenType() string {
+	return c.TokenType
+}
+
+func TestAccessTokenType(t *testing.T) {
+	testcases := map[string]struct {
+		ClientType    string
+		Selector      TokenTypeSelector
+		RequestType   string
+		ExpectedType  string
+		ExpectedField string
+	}{
+		"configured": {
+			ExpectedType: "Bearer",
+		},
+		"client": {
+			ClientType:   "DPoP",
+			ExpectedType: "DPoP",
+		},
+		"selector": {
+			ClientType: "DPoP",
+			Selector: TokenTypeSelectorFunc(func(ar *AccessRequest) (string, map[string]interface{}, error) {
+				return "mac", map[string]interface{}{"mac_algorithm": "hmac-sha-256"}, nil
+			}),
+			ExpectedType:  "mac",
+			ExpectedField: "mac_algorithm",
+		},
+		"blank selector": {
+			ClientType: "DPoP",
+			Selector: TokenTypeSelectorFunc(func(ar *AccessRequest) (string, map[string]interface{}, error) {
+				return "", nil, nil
+			}),
+			ExpectedType: "DPoP",
+		},
+		"request": {
+			ClientType:   "DPoP",
+			RequestType:  "N_A",
+			ExpectedType: "N_A",
+		},
+	}
+
+	for k, tc := range testcases {
+		storage := NewTestingStorage()
+		storage.clients["1234"] = &tokenTypeClient{
+			DefaultClient: DefaultClient{Id: "1234", Secret: "aabbccdd", RedirectUri: "http://localhost:14000/appauth"},
+			TokenType:     tc.ClientType,
+		}
+		sconfig := NewServerConfig()
+		sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+		server := NewServer(sconfig, storage)
+		server.AccessTokenGen = &TestingAccessTokenGen{}
+		server.TokenTypeSelector = tc.Selector
+		resp := server.NewResponse()
+
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = make(url.Values)
+		req.Form.Set("grant_type", string(CLIENT_CREDENTIALS))
+		req.PostForm = make(url.Values)
+
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			ar.TokenType = tc.RequestType
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if resp.IsError {
+			t.Errorf("%s: unexpected error: %v", k, resp.Output)
+			continue
+		}
+
+		if resp.Output["token_type"] != tc.ExpectedType {
+			t.Errorf("%s: expected token type %s, got %v", k, tc.ExpectedType, resp.Output["token_type"])
+		}
+		if _, ok := resp.Output[tc.ExpectedField]; tc.ExpectedField != "" && !ok {
+			t.Errorf("%s: expected field %s in %v", k, tc.ExpectedField, resp.Output)
+		}
+		if tt := server.accessTokenType(server.NewResponse(), storage.access["1"]); tt != tc.ExpectedType {
+			t.Errorf("%s: expected saved token type %s, got %s", k, tc.ExpectedType, tt)
+		}
+	}
+}
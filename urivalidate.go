@@ -22,29 +22,11 @@ func newUriValidationError(msg string, base string, redirect string) UriValidati
 // baseUriList may be a string separated by separator.
 // If separator is blank, validate only 1 URI.
 func ValidateUriList(baseUriList string, redirectUri string, separator string) error {
-	// make a list of uris
-	var slist []string
-	if separator != "" {
-		slist = strings.Split(baseUriList, separator)
-	} else {
-		slist = make([]string, 0)
-		slist = append(slist, baseUriList)
+	err := ValidateRedirectURIs(SplitRedirectURIs(baseUriList, separator), redirectUri)
+	if _, iok := err.(UriValidationError); iok {
+		return newUriValidationError("urls don't validate", baseUriList, redirectUri)
 	}
-
-	for _, sitem := range slist {
-		err := ValidateUri(sitem, redirectUri)
-		// validated, return no error
-		if err == nil {
-			return nil
-		}
-
-		// if there was an error that is not a validation error, return it
-		if _, iok := err.(UriValidationError); !iok {
-			return err
-		}
-	}
-
-	return newUriValidationError("urls don't validate", baseUriList, redirectUri)
+	return err
 }
 
 // ValidateUri validates that redirectUri is contained in baseUri
@@ -99,16 +81,18 @@ func ValidateUri(baseUri string, redirectUri string) error {
 	return nil
 }
 
-// FirstUri Returns the first uri from an uri list
-func FirstUri(baseUriList string, separator string) string {
-	if separator != "" {
-		slist := strings.Split(baseUriList, separator)
-		if len(slist) > 0 {
-			return slist[0]
-		}
-	} else {
-		return baseUriList
+// ValidateUriListExact validates that redirectUri is exactly equal to one of
+// the URIs of baseUriList, as required for the implicit flow.
+// baseUriList may be a string separated by separator.
+// If separator is blank, validate only 1 URI.
+func ValidateUriListExact(baseUriList string, redirectUri string, separator string) error {
+	if ValidateRedirectURIsExact(SplitRedirectURIs(baseUriList, separator), redirectUri) != nil {
+		return newUriValidationError("urls don't match exactly", baseUriList, redirectUri)
 	}
+	return nil
+}
 
-	return ""
+// FirstUri Returns the first uri from an uri list
+func FirstUri(baseUriList string, separator string) string {
+	return FirstRedirectURI(SplitRedirectURIs(baseUriList, separator))
 }
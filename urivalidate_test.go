@@ -118,3 +118,37 @@ func TestURIListValidate(t *testing.T) {
 		t.Error("V4 should have failed")
 	}
 }
+
+func TestRedirectURIsValidate(t *testing.T) {
+	uris := []RedirectURI{
+		{URI: "https://example.com/cb?a=1,2"},
+		{URI: "http://127.0.0.1:8080/native", Type: REDIRECT_URI_NATIVE},
+		{URI: "https://app.example.com", Type: REDIRECT_URI_POSTMESSAGE},
+	}
+
+	tests := map[string]struct {
+		RedirectUri string
+		Valid       bool
+	}{
+		"separator in uri":       {RedirectUri: "https://example.com/cb?a=1,2", Valid: true},
+		"native other port":      {RedirectUri: "http://127.0.0.1:51004/native", Valid: true},
+		"native other host":      {RedirectUri: "http://127.0.0.2:8080/native"},
+		"postmessage exact":      {RedirectUri: "https://app.example.com", Valid: true},
+		"postmessage subpath":    {RedirectUri: "https://app.example.com/x"},
+		"web other host":         {RedirectUri: "https://example.org/cb"},
+		"native other subpath":   {RedirectUri: "http://127.0.0.1:9/native/cb", Valid: true},
+		"native path mismatched": {RedirectUri: "http://127.0.0.1:9/other"},
+	}
+	for k, test := range tests {
+		if err := ValidateRedirectURIs(uris, test.RedirectUri); (err == nil) != test.Valid {
+			t.Errorf("%s: expected valid %v, got %v", k, test.Valid, err)
+		}
+	}
+
+	if first := FirstRedirectURI(ClientRedirectURIs(&DefaultClient{RedirectUris: uris}, ",")); first != uris[0].URI {
+		t.Errorf("Expected first uri %s, got %s", uris[0].URI, first)
+	}
+	if first := FirstRedirectURI(ClientRedirectURIs(&DefaultClient{RedirectUri: "http://a/x,http://b/y"}, ",")); first != "http://a/x" {
+		t.Errorf("Expected first uri http://a/x, got %s", first)
+	}
+}
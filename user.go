@@ -0,0 +1,95 @@
+package osin
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrInvalidCredentials is returned by a PasswordAuthenticator when the
+// password doesn't match
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// User is a user account resolved by a UserStore
+type User struct {
+	// Local subject identifier, saved in the access data
+	Subject string
+
+	// Name the user logs in with
+	Username string
+
+	// Attributes of the user, returned as OpenID Connect claims, like
+	// "email" or "name"
+	Claims map[string]interface{}
+}
+
+// UserStore resolves the users shared by the password grant, the device
+// verification and the UserInfo endpoint. Unknown users are reported with
+// ErrNotFound.
+//
+// An adapter over an LDAP directory maps the subject and the username to
+// entry attributes, for example with github.com/go-ldap/ldap:
+//
+//	func (s *ldapUsers) FindUserByUsername(ctx context.Context, username string) (*osin.User, error) {
+//		return s.find("(uid=" + ldap.EscapeFilter(username) + ")")
+//	}
+//
+//	func (s *ldapUsers) FindUserBySubject(ctx context.Context, subject string) (*osin.User, error) {
+//		return s.find("(entryUUID=" + ldap.EscapeFilter(subject) + ")")
+//	}
+//
+//	func (s *ldapUsers) find(filter string) (*osin.User, error) {
+//		res, err := s.conn.Search(ldap.NewSearchRequest(s.baseDN, ldap.ScopeWholeSubtree,
+//			ldap.NeverDerefAliases, 2, 0, false, filter, []string{"entryUUID", "uid", "mail", "cn"}, nil))
+//		if err != nil {
+//			return nil, err
+//		}
+//		if len(res.Entries) != 1 {
+//			return nil, osin.ErrNotFound
+//		}
+//		e := res.Entries[0]
+//		return &osin.User{
+//			Subject:  e.GetAttributeValue("entryUUID"),
+//			Username: e.GetAttributeValue("uid"),
+//			Claims:   map[string]interface{}{"email": e.GetAttributeValue("mail"), "name": e.GetAttributeValue("cn")},
+//		}, nil
+//	}
+type UserStore interface {
+	FindUserByUsername(ctx context.Context, username string) (*User, error)
+	FindUserBySubject(ctx context.Context, subject string) (*User, error)
+}
+
+// PasswordAuthenticator verifies the passwords of the users. It returns
+// ErrNotFound for unknown users and ErrInvalidCredentials for wrong
+// passwords.
+type PasswordAuthenticator interface {
+	AuthenticatePassword(ctx context.Context, username, password string) (*User, error)
+}
+
+// passwordAuthenticator returns the server PasswordAuthenticator, or the
+// UserStore if it implements PasswordAuthenticator. Nil if there is none.
+func (s *Server) passwordAuthenticator() PasswordAuthenticator {
+	if s.PasswordAuthenticator != nil {
+		return s.PasswordAuthenticator
+	}
+	if pa, ok := s.UserStore.(PasswordAuthenticator); ok {
+		return pa
+	}
+	return nil
+}
+
+// AuthenticateUser verifies the password of a user with the server
+// PasswordAuthenticator, like the password grant does, for login pages
+// like the device verification page
+func (s *Server) AuthenticateUser(ctx context.Context, username, password string) (*User, error) {
+	pa := s.passwordAuthenticator()
+	if pa == nil {
+		return nil, errors.New("no password authenticator")
+	}
+	return pa.AuthenticatePassword(ctx, username, password)
+}
+
+// isUserUnknown returns true if err reports an unknown user or a wrong
+// password, rather than a failure to check them
+func isUserUnknown(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, ErrInvalidCredentials)
+}
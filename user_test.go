@@ -0,0 +1,171 @@
+package osin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// testUserStore keeps users and their passwords in memory
+type testUserStore struct {
+	users     map[string]*User
+	passwords map[string]string
+	err       error
+}
+
+func newTestUserStore() *testUserStore {
+	return &testUserStore{
+		users: map[string]*User{
+			"user-1": {Subject: "user-1", Username: "alice", Claims: map[string]interface{}{"email": "alice@example.com", "name": "Alice"}},
+		},
+		passwords: map[string]string{"alice": "secret"},
+	}
+}
+
+func (s *testUserStore) FindUserByUsername(ctx context.Context, username string) (*User, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	for _, u := range s.users {
+		if u.Username == username {
+			return u, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *testUserStore) FindUserBySubject(ctx context.Context, subject string) (*User, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if u, ok := s.users[subject]; ok {
+		return u, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *testUserStore) AuthenticatePassword(ctx context.Context, username, password string) (*User, error) {
+	u, err := s.FindUserByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if s.passwords[username] != password {
+		return nil, ErrInvalidCredentials
+	}
+	return u, nil
+}
+
+func TestUserStorePassword(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{PASSWORD}
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+	users := newTestUserStore()
+	server.UserStore = users
+
+	tests := map[string]struct {
+		username string
+		password string
+		err      error
+		errorId  string
+	}{
+		"valid":          {"alice", "secret", nil, ""},
+		"wrong password": {"alice", "wrong", nil, E_INVALID_GRANT},
+		"unknown user":   {"bob", "secret", nil, E_INVALID_GRANT},
+		"store failure":  {"alice", "secret", errors.New("directory down"), E_SERVER_ERROR},
+	}
+	for name, test := range tests {
+		users.err = test.err
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = url.Values{"grant_type": {string(PASSWORD)}, "username": {test.username}, "password": {test.password}}
+		req.PostForm = make(url.Values)
+
+		var subject string
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			subject = ar.Subject
+			if ar.User == nil || len(ar.AuthenticationContext.AMR) != 1 || ar.AuthenticationContext.AMR[0] != "pwd" {
+				t.Errorf("%s: unexpected authentication %+v", name, ar.AuthenticationContext)
+			}
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if resp.ErrorId != test.errorId {
+			t.Errorf("%s: expected error %q, got %q", name, test.errorId, resp.ErrorId)
+		}
+		if test.errorId == "" && subject != "user-1" {
+			t.Errorf("%s: unexpected subject %q", name, subject)
+		}
+	}
+}
+
+func TestUserStoreUserInfo(t *testing.T) {
+	storage := NewTestingStorage()
+	server := NewServer(NewServerConfig(), storage)
+	server.UserStore = newTestUserStore()
+	storage.access["9999"].Scope = "openid email"
+
+	userinfo := func(subject string) *Response {
+		storage.access["9999"].Subject = subject
+		resp := server.NewResponse()
+		req, err := http.NewRequest("GET", "http://localhost:14000/userinfo", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer 9999")
+		if ur := server.HandleUserInfoRequest(resp, req); ur != nil {
+			server.FinishUserInfoRequest(resp, req, ur)
+		}
+		return resp
+	}
+
+	resp := userinfo("user-1")
+	if resp.IsError || resp.Output["sub"] != "user-1" || resp.Output["email"] != "alice@example.com" {
+		t.Fatalf("Unexpected userinfo: %v", resp.Output)
+	}
+	if _, ok := resp.Output["name"]; ok {
+		t.Error("Claim not requested by the scope must not be returned")
+	}
+
+	if resp = userinfo("deleted"); resp.ErrorId != E_INVALID_GRANT {
+		t.Fatalf("Tokens of deleted users should be rejected, got %v", resp.Output)
+	}
+}
+
+func TestUserStoreDeviceVerification(t *testing.T) {
+	storage := newDeviceTestingStorage()
+	server := NewServer(NewServerConfig(), storage)
+	server.UserStore = newTestUserStore()
+	req, err := http.NewRequest("POST", "http://localhost:14000/device", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(vr *DeviceVerificationRequest) *Response {
+		vr.Authorized = true
+		vr.DeviceAuthorization = &DeviceAuthorizationData{DeviceCode: "dc", Status: DEVICE_PENDING}
+		resp := server.NewResponse()
+		server.FinishDeviceVerificationRequest(resp, req, vr)
+		return resp
+	}
+
+	user, err := server.AuthenticateUser(context.Background(), "alice", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := verify(&DeviceVerificationRequest{User: user}); resp.IsError || storage.devices["dc"].Subject != "user-1" {
+		t.Fatalf("Unexpected verification: %v", resp.Output)
+	}
+	if resp := verify(&DeviceVerificationRequest{Subject: "deleted"}); resp.ErrorId != E_ACCESS_DENIED {
+		t.Fatalf("Unknown users should be denied, got %v", resp.Output)
+	}
+	if _, err := server.AuthenticateUser(context.Background(), "alice", "wrong"); err != ErrInvalidCredentials {
+		t.Fatalf("Expected invalid credentials, got %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+package osin
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUserDataDecrypt is returned when encrypted UserData can't be
+	// decrypted with any of the keys
+	ErrUserDataDecrypt = errors.New("user data decryption failed")
+)
+
+// UserDataCodec serializes the UserData of the grants for storages, so it
+// is stored consistently. Storages call Encode on save and Decode on load.
+type UserDataCodec interface {
+	Encode(v interface{}) ([]byte, error)
+	Decode(b []byte, v interface{}) error
+}
+
+// UserDataCodecWithAssociatedData is implemented by the codecs binding the
+// encoded UserData to associated data, like the key of the record storing
+// it, so it can't be moved to another record. Storages call it through
+// EncodeBoundUserData and DecodeBoundUserData.
+type UserDataCodecWithAssociatedData interface {
+	EncodeAssociated(v interface{}, ad []byte) ([]byte, error)
+	DecodeAssociated(b []byte, v interface{}, ad []byte) error
+}
+
+// EncodeBoundUserData encodes v with the codec, bound to the associated
+// data if the codec implements UserDataCodecWithAssociatedData
+func EncodeBoundUserData(codec UserDataCodec, v interface{}, ad []byte) ([]byte, error) {
+	if c, ok := codec.(UserDataCodecWithAssociatedData); ok {
+		return c.EncodeAssociated(v, ad)
+	}
+	return codec.Encode(v)
+}
+
+// DecodeBoundUserData decodes b with the codec, verifying the associated
+// data if the codec implements UserDataCodecWithAssociatedData
+func DecodeBoundUserData(codec UserDataCodec, b []byte, v interface{}, ad []byte) error {
+	if c, ok := codec.(UserDataCodecWithAssociatedData); ok {
+		return c.DecodeAssociated(b, v, ad)
+	}
+	return codec.Decode(b, v)
+}
+
+// JSONUserDataCodec serializes UserData as JSON
+type JSONUserDataCodec struct{}
+
+// Encode satisfies the UserDataCodec interface
+func (JSONUserDataCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Decode satisfies the UserDataCodec interface
+func (JSONUserDataCodec) Decode(b []byte, v interface{}) error {
+	return json.Unmarshal(b, v)
+}
+
+// AEADUserDataCodec serializes UserData as JSON encrypted with AES-GCM, so
+// personal data isn't stored in plaintext. Through EncodeBoundUserData, the
+// ciphertext is bound to the record it is stored in.
+type AEADUserDataCodec struct {
+	// AES keys of 16, 24 or 32 bytes. The first one encrypts, all of them
+	// decrypt, to rotate keys.
+	Keys [][]byte
+}
+
+// NewAEADUserDataCodec creates a codec encrypting with the keys, verifying
+// their sizes
+func NewAEADUserDataCodec(keys ...[]byte) (*AEADUserDataCodec, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("user data codec needs a key")
+	}
+	for _, k := range keys {
+		if _, err := aes.NewCipher(k); err != nil {
+			return nil, err
+		}
+	}
+	return &AEADUserDataCodec{Keys: keys}, nil
+}
+
+func userDataAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encode satisfies the UserDataCodec interface. The output is the nonce
+// followed by the sealed JSON.
+func (c *AEADUserDataCodec) Encode(v interface{}) ([]byte, error) {
+	return c.EncodeAssociated(v, nil)
+}
+
+// EncodeAssociated satisfies the UserDataCodecWithAssociatedData
+// interface. The associated data is authenticated, not stored.
+func (c *AEADUserDataCodec) EncodeAssociated(v interface{}, ad []byte) ([]byte, error) {
+	if len(c.Keys) == 0 {
+		return nil, errors.New("user data codec has no key")
+	}
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := userDataAEAD(c.Keys[0])
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, ad), nil
+}
+
+// Decode satisfies the UserDataCodec interface
+func (c *AEADUserDataCodec) Decode(b []byte, v interface{}) error {
+	return c.DecodeAssociated(b, v, nil)
+}
+
+// DecodeAssociated satisfies the UserDataCodecWithAssociatedData
+// interface. Fails with ErrUserDataDecrypt if the associated data is not
+// the one it was encoded with.
+func (c *AEADUserDataCodec) DecodeAssociated(b []byte, v interface{}, ad []byte) error {
+	for _, k := range c.Keys {
+		aead, err := userDataAEAD(k)
+		if err != nil {
+			return err
+		}
+		if len(b) < aead.NonceSize() {
+			return ErrUserDataDecrypt
+		}
+		plaintext, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], ad)
+		if err != nil {
+			continue
+		}
+		return json.Unmarshal(plaintext, v)
+	}
+	return ErrUserDataDecrypt
+}
+
+// UserDataCodec returns the codec of the configured UserDataKeys, or a
+// JSONUserDataCodec without keys. Storages serializing UserData don't read
+// the server configuration; set their codec to this one.
+func (c *ServerConfig) UserDataCodec() (UserDataCodec, error) {
+	if len(c.UserDataKeys) == 0 {
+		return JSONUserDataCodec{}, nil
+	}
+	return NewAEADUserDataCodec(c.UserDataKeys...)
+}
+
+// GetUserData returns the UserData as a T. Values decoded into an
+// interface{}, like maps, are converted through JSON. Returns the zero T
+// without error for nil UserData.
+func GetUserData[T any](userData interface{}) (T, error) {
+	var ret T
+	if userData == nil {
+		return ret, nil
+	}
+	if v, ok := userData.(T); ok {
+		return v, nil
+	}
+	b, err := json.Marshal(userData)
+	if err != nil {
+		return ret, err
+	}
+	if err = json.Unmarshal(b, &ret); err != nil {
+		return ret, fmt.Errorf("user data is not a %T: %v", ret, err)
+	}
+	return ret, nil
+}
+
+// DecodeUserData decodes stored UserData with the codec as a T
+func DecodeUserData[T any](codec UserDataCodec, b []byte) (T, error) {
+	var ret T
+	err := codec.Decode(b, &ret)
+	return ret, err
+}
+
+// UserDataHolder is implemented by the types carrying UserData: clients,
+// requests and grants
+type UserDataHolder interface {
+	GetUserData() interface{}
+}
+
+// GetUserData returns the UserData of the grant
+func (d *AccessData) GetUserData() interface{} {
+	return d.UserData
+}
+
+// GetUserData returns the UserData of the grant
+func (d *AuthorizeData) GetUserData() interface{} {
+	return d.UserData
+}
+
+// GetUserData returns the UserData of the request
+func (ar *AccessRequest) GetUserData() interface{} {
+	return ar.UserData
+}
+
+// GetUserData returns the UserData of the request
+func (ar *AuthorizeRequest) GetUserData() interface{} {
+	return ar.UserData
+}
+
+// UserDataAs returns the UserData of a client, request or grant as a T, see
+// GetUserData. Nil holders have zero UserData.
+func UserDataAs[T any](h UserDataHolder) (T, error) {
+	if h == nil {
+		var ret T
+		return ret, nil
+	}
+	return GetUserData[T](h.GetUserData())
+}
+
+// TypedUserDataCodec wraps a codec to decode UserData as T values, so
+// storages decoding into an interface{} load a T instead of generic maps.
+// It uses a JSONUserDataCodec if Codec is nil.
+type TypedUserDataCodec[T any] struct {
+	Codec UserDataCodec
+}
+
+func (c TypedUserDataCodec[T]) codec() UserDataCodec {
+	if c.Codec == nil {
+		return JSONUserDataCodec{}
+	}
+	return c.Codec
+}
+
+// Encode satisfies the UserDataCodec interface
+func (c TypedUserDataCodec[T]) Encode(v interface{}) ([]byte, error) {
+	return c.codec().Encode(v)
+}
+
+// Decode satisfies the UserDataCodec interface. Decoding into an
+// *interface{} stores a T.
+func (c TypedUserDataCodec[T]) Decode(b []byte, v interface{}) error {
+	return c.DecodeAssociated(b, v, nil)
+}
+
+// EncodeAssociated satisfies the UserDataCodecWithAssociatedData
+// interface, binding the data if the wrapped codec does
+func (c TypedUserDataCodec[T]) EncodeAssociated(v interface{}, ad []byte) ([]byte, error) {
+	return EncodeBoundUserData(c.codec(), v, ad)
+}
+
+// DecodeAssociated satisfies the UserDataCodecWithAssociatedData
+// interface. Decoding into an *interface{} stores a T.
+func (c TypedUserDataCodec[T]) DecodeAssociated(b []byte, v interface{}, ad []byte) error {
+	p, ok := v.(*interface{})
+	if !ok {
+		return DecodeBoundUserData(c.codec(), b, v, ad)
+	}
+	var ret T
+	if err := DecodeBoundUserData(c.codec(), b, &ret, ad); err != nil {
+		return err
+	}
+	*p = ret
+	return nil
+}
@@ -0,0 +1,135 @@
+package osin
+
+import (
+	"bytes"
+	"testing"
+)
+
+type userDataTest struct {
+	Email string
+	Roles []string
+}
+
+func TestAEADUserDataCodec(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+
+	sconfig := NewServerConfig()
+	sconfig.UserDataKeys = [][]byte{oldKey}
+	codec, err := sconfig.UserDataCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := codec.Encode(&userDataTest{Email: "user@example.com", Roles: []string{"admin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte("user@example.com")) {
+		t.Fatal("User data should be encrypted")
+	}
+
+	// rotated keys still decrypt
+	rotated, err := NewAEADUserDataCodec(newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ud, err := DecodeUserData[userDataTest](rotated, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ud.Email != "user@example.com" || len(ud.Roles) != 1 {
+		t.Fatalf("Unexpected user data %+v", ud)
+	}
+
+	other, _ := NewAEADUserDataCodec(newKey)
+	if _, err = DecodeUserData[userDataTest](other, b); err != ErrUserDataDecrypt {
+		t.Fatalf("Expected decryption failure, got %v", err)
+	}
+	if _, err = NewAEADUserDataCodec([]byte("short")); err == nil {
+		t.Fatal("Invalid key size should fail")
+	}
+}
+
+func TestAEADUserDataCodecAssociatedData(t *testing.T) {
+	codec, err := NewAEADUserDataCodec(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := EncodeBoundUserData(codec, &userDataTest{Email: "user@example.com"}, []byte("access/1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ud userDataTest
+	if err = DecodeBoundUserData(codec, b, &ud, []byte("access/1")); err != nil || ud.Email != "user@example.com" {
+		t.Fatalf("Unexpected user data %+v, %v", ud, err)
+	}
+	// moved to another record
+	if err = DecodeBoundUserData(codec, b, &ud, []byte("access/2")); err != ErrUserDataDecrypt {
+		t.Fatalf("Expected decryption failure, got %v", err)
+	}
+	if err = codec.Decode(b, &ud); err != ErrUserDataDecrypt {
+		t.Fatalf("Expected decryption failure without associated data, got %v", err)
+	}
+
+	// typed codecs bind through the wrapped codec
+	typed := TypedUserDataCodec[userDataTest]{Codec: codec}
+	if b, err = EncodeBoundUserData(typed, &ud, []byte("access/1")); err != nil {
+		t.Fatal(err)
+	}
+	var v interface{}
+	if err = DecodeBoundUserData(typed, b, &v, []byte("access/2")); err != ErrUserDataDecrypt {
+		t.Fatalf("Expected decryption failure, got %v", err)
+	}
+	if err = DecodeBoundUserData(typed, b, &v, []byte("access/1")); err != nil || v.(userDataTest).Email != "user@example.com" {
+		t.Fatalf("Unexpected user data %+v, %v", v, err)
+	}
+}
+
+func TestGetUserData(t *testing.T) {
+	// stored values
+	if ud, err := GetUserData[*userDataTest](&userDataTest{Email: "a"}); err != nil || ud.Email != "a" {
+		t.Fatalf("Unexpected %+v, %v", ud, err)
+	}
+
+	// values decoded into an interface{}
+	var decoded interface{}
+	if err := (JSONUserDataCodec{}).Decode([]byte(`{"Email":"b","Roles":["x"]}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if ud, err := GetUserData[userDataTest](decoded); err != nil || ud.Email != "b" || ud.Roles[0] != "x" {
+		t.Fatalf("Unexpected %+v, %v", ud, err)
+	}
+
+	if ud, err := GetUserData[*userDataTest](nil); err != nil || ud != nil {
+		t.Fatalf("Unexpected %+v, %v", ud, err)
+	}
+	if _, err := GetUserData[userDataTest]("text"); err == nil {
+		t.Fatal("Mismatched user data should fail")
+	}
+}
+
+func TestTypedUserData(t *testing.T) {
+	codec := TypedUserDataCodec[*userDataTest]{}
+	b, err := codec.Encode(&userDataTest{Email: "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a storage decoding into an interface{}
+	data := &AccessData{}
+	if err = codec.Decode(b, &data.UserData); err != nil {
+		t.Fatal(err)
+	}
+	if ud, ok := data.UserData.(*userDataTest); !ok || ud.Email != "c" {
+		t.Fatalf("Expected typed user data, got %#v", data.UserData)
+	}
+
+	ud, err := UserDataAs[*userDataTest](data)
+	if err != nil || ud.Email != "c" {
+		t.Fatalf("Unexpected %+v, %v", ud, err)
+	}
+	if _, err = UserDataAs[*userDataTest](&DefaultClient{UserData: map[string]interface{}{"Email": "d"}}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,137 @@
+package osin
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ClaimSource is a source of aggregated or distributed claims, as described
+// in https://openid.net/specs/openid-connect-core-1_0.html#AggregatedDistributedClaims.
+// Set JWT for aggregated claims, or Endpoint for distributed claims.
+type ClaimSource struct {
+	// Name of the source, unique in the response
+	Name string
+
+	// Claims held by the source
+	Claims []string
+
+	// Signed JWT holding the claims, for aggregated claims
+	JWT string
+
+	// Endpoint serving the claims and the optional access token to call it,
+	// for distributed claims
+	Endpoint    string
+	AccessToken string
+}
+
+// UserInfoRequest is a request to the OpenID Connect UserInfo endpoint
+type UserInfoRequest struct {
+	// Access token presented
+	Code string
+
+	// Access data of the token
+	AccessData *AccessData
+
+	// Claims of the user, set by the application, or to the User claims
+	// by HandleUserInfoRequest. Only the claims requested by the scope and
+	// the claims request parameter are returned.
+	Claims map[string]interface{}
+
+	// User of the token, if the server has a UserStore
+	User *User
+
+	// Claims of the user held by other services, set by the application, to
+	// be referenced instead of embedded
+	ClaimSources []ClaimSource
+}
+
+// HandleUserInfoRequest validates the bearer token of a UserInfo request,
+// which must have been issued with the openid scope
+func (s *Server) HandleUserInfoRequest(w *Response, r *http.Request) *UserInfoRequest {
+	ir := s.HandleInfoRequest(w, r)
+	if ir == nil {
+		return nil
+	}
+	if !HasScope(ir.AccessData.GrantedScope(), "openid") || ir.AccessData.Subject == "" {
+		w.SetError(E_INSUFFICIENT_SCOPE, "")
+		w.SetChallenge("Bearer", s.requestConfig(w).Realm, E_INSUFFICIENT_SCOPE)
+		return nil
+	}
+	ret := &UserInfoRequest{
+		Code:       ir.Code,
+		AccessData: ir.AccessData,
+	}
+	if s.UserStore != nil {
+		user, err := s.UserStore.FindUserBySubject(r.Context(), ir.AccessData.Subject)
+		if err != nil {
+			// tokens of deleted users are no longer valid
+			if errors.Is(err, ErrNotFound) {
+				w.SetError(E_INVALID_GRANT, "")
+				w.SetChallenge("Bearer", s.requestConfig(w).Realm, E_INVALID_TOKEN)
+			} else {
+				w.SetError(E_SERVER_ERROR, "")
+			}
+			w.InternalError = err
+			return nil
+		}
+		ret.User = user
+		ret.Claims = make(map[string]interface{}, len(user.Claims))
+		for k, v := range user.Claims {
+			ret.Claims[k] = v
+		}
+	}
+	return ret
+}
+
+// FinishUserInfoRequest outputs the subject and the requested claims. Claims
+// of the ClaimSources are output as _claim_names and _claim_sources.
+func (s *Server) FinishUserInfoRequest(w *Response, r *http.Request, ur *UserInfoRequest) {
+	// don't process if is already an error
+	if w.IsError {
+		return
+	}
+
+	ad := ur.AccessData
+	claims, _ := ad.ClaimsRequest.Filter(CLAIMS_USERINFO, ad.GrantedScope(), ur.Claims)
+	for k, v := range claims {
+		w.Output[k] = v
+	}
+
+	sub, err := s.SubjectIdentifier(ad.Client, ad.Subject)
+	if err != nil {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = err
+		return
+	}
+	w.Output["sub"] = sub
+
+	requested := make(map[string]bool)
+	for _, name := range ad.ClaimsRequest.Names(CLAIMS_USERINFO, ad.GrantedScope()) {
+		requested[name] = true
+	}
+	names := make(map[string]string)
+	sources := make(map[string]interface{})
+	for _, src := range ur.ClaimSources {
+		for _, name := range src.Claims {
+			if !requested[name] {
+				continue
+			}
+			// referenced claims are not embedded too
+			delete(w.Output, name)
+			names[name] = src.Name
+			if src.JWT != "" {
+				sources[src.Name] = map[string]string{"JWT": src.JWT}
+			} else {
+				source := map[string]string{"endpoint": src.Endpoint}
+				if src.AccessToken != "" {
+					source["access_token"] = src.AccessToken
+				}
+				sources[src.Name] = source
+			}
+		}
+	}
+	if len(names) > 0 {
+		w.Output["_claim_names"] = names
+		w.Output["_claim_sources"] = sources
+	}
+}
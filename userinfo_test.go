@@ -0,0 +1,79 @@
+package osin
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestUserInfoClaimSources(t *testing.T) {
+	storage := NewTestingStorage()
+	server := NewServer(NewServerConfig(), storage)
+
+	cr, err := ParseClaimsRequest(`{"userinfo": {"entitlements": null}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.access["9999"].Scope = "openid email"
+	storage.access["9999"].Subject = "user-1"
+	storage.access["9999"].ClaimsRequest = cr
+
+	resp := server.NewResponse()
+	req, err := http.NewRequest("GET", "http://localhost:14000/userinfo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer 9999")
+
+	if ur := server.HandleUserInfoRequest(resp, req); ur != nil {
+		ur.Claims = map[string]interface{}{
+			"email":        "user@example.com",
+			"entitlements": []string{"local"},
+			"address":      "not requested",
+		}
+		ur.ClaimSources = []ClaimSource{
+			{Name: "hr", Claims: []string{"entitlements"}, Endpoint: "https://hr.example.com/claims", AccessToken: "hr-token"},
+			{Name: "kyc", Claims: []string{"address"}, JWT: "a.b.c"},
+		}
+		server.FinishUserInfoRequest(resp, req, ur)
+	}
+	if resp.IsError {
+		t.Fatalf("Unexpected error: %v", resp.Output)
+	}
+
+	if resp.Output["sub"] != "user-1" || resp.Output["email"] != "user@example.com" {
+		t.Errorf("Unexpected claims: %v", resp.Output)
+	}
+	if _, ok := resp.Output["entitlements"]; ok {
+		t.Error("Distributed claim must not be embedded")
+	}
+	if _, ok := resp.Output["address"]; ok {
+		t.Error("Claim not requested must not be returned")
+	}
+	if names := resp.Output["_claim_names"]; !reflect.DeepEqual(names, map[string]string{"entitlements": "hr"}) {
+		t.Errorf("Unexpected claim names: %v", names)
+	}
+	expected := map[string]interface{}{
+		"hr": map[string]string{"endpoint": "https://hr.example.com/claims", "access_token": "hr-token"},
+	}
+	if sources := resp.Output["_claim_sources"]; !reflect.DeepEqual(sources, expected) {
+		t.Errorf("Unexpected claim sources: %v", sources)
+	}
+}
+
+func TestUserInfoRequiresOpenID(t *testing.T) {
+	server := NewServer(NewServerConfig(), NewTestingStorage())
+	resp := server.NewResponse()
+	req, err := http.NewRequest("GET", "http://localhost:14000/userinfo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer 9999")
+
+	if ur := server.HandleUserInfoRequest(resp, req); ur != nil {
+		t.Fatal("Token without openid scope must be rejected")
+	}
+	if resp.ErrorId != E_INSUFFICIENT_SCOPE {
+		t.Errorf("Expected %s, got %s", E_INSUFFICIENT_SCOPE, resp.ErrorId)
+	}
+}
@@ -1,10 +1,12 @@
 package osin
 
 import (
+	"crypto/subtle"
 	"encoding/base64"
 	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/AccelByte/go-jose/json"
 	"github.com/sirupsen/logrus"
@@ -30,16 +32,45 @@ type JWTPayload struct {
 // CheckClientSecret determines whether the given secret matches a secret held by the client.
 // Public clients return true for a secret of ""
 func CheckClientSecret(client Client, secret string) bool {
+	_, ok := MatchClientSecret(client, secret, time.Now())
+	return ok
+}
+
+// MatchClientSecret determines whether the given secret matches a secret held
+// by the client at time 'now', and returns the name of the secret that matched.
+// The name is blank for clients not implementing ClientSecretList.
+func MatchClientSecret(client Client, secret string, now time.Time) (string, bool) {
 	switch client := client.(type) {
+	case ClientSecretList:
+		secrets := client.GetSecrets()
+		if len(secrets) == 0 {
+			// public client
+			return "", secret == ""
+		}
+		for _, s := range secrets {
+			if !s.ExpiresAt.IsZero() && now.After(s.ExpiresAt) {
+				continue
+			}
+			if subtle.ConstantTimeCompare([]byte(s.Secret), []byte(secret)) == 1 {
+				return s.Name, true
+			}
+		}
+		return "", false
 	case ClientSecretMatcher:
 		// Prefer the more secure method of giving the secret to the client for comparison
-		return client.ClientSecretMatches(secret)
+		return "", client.ClientSecretMatches(secret)
 	default:
 		// Fallback to the less secure method of extracting the plain text secret from the client for comparison
-		return client.GetSecret() == secret
+		return "", client.GetSecret() == secret
 	}
 }
 
+// isPublicClient is true if the client has no secret at the server time
+func (s *Server) isPublicClient(client Client) bool {
+	_, ok := MatchClientSecret(client, "", s.Now())
+	return ok
+}
+
 // CheckClientID determines whether the given id matches a client ID.
 func CheckClientID(client Client, id string) bool {
 	switch client := client.(type) {
@@ -50,6 +81,17 @@ func CheckClientID(client Client, id string) bool {
 	}
 }
 
+// HasScope determines whether the scope list, separated by spaces or commas,
+// contains the given scope.
+func HasScope(scopes string, scope string) bool {
+	for _, s := range strings.FieldsFunc(scopes, func(r rune) bool { return r == ' ' || r == ',' }) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
 // Return authorization header data
 func CheckBasicAuth(r *http.Request) (*BasicAuth, error) {
 	if r.Header.Get("Authorization") == "" {
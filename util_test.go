@@ -174,3 +174,12 @@ func TestDecodeJWT_RefreshToken(t *testing.T) {
 		t.Errorf("Expected issueAt is 1617779638, got %v", jwtPayload.IssueAt)
 	}
 }
+
+func TestHasScope(t *testing.T) {
+	if !HasScope("openid profile", "openid") || !HasScope("profile,openid", "openid") {
+		t.Fatalf("Scope should be found")
+	}
+	if HasScope("openid_extra profile", "openid") || HasScope("", "openid") {
+		t.Fatalf("Scope should not be found")
+	}
+}
@@ -0,0 +1,200 @@
+package osin
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ValidationRequest is a request to validate reference tokens, from trusted
+// internal resource servers. NOT an RFC specification.
+type ValidationRequest struct {
+	// Tokens to validate
+	Tokens []string
+
+	// AccessData associated with each token, nil if the token is not active
+	AccessData []*AccessData
+
+	// HttpRequest *http.Request for special use
+	HttpRequest *http.Request
+}
+
+// ValidationResult is the validation output of a single token
+type ValidationResult struct {
+	Token     string      `json:"token"`
+	Active    bool        `json:"active"`
+	ClientId  string      `json:"client_id,omitempty"`
+	Scope     string      `json:"scope,omitempty"`
+	ExpiresAt int64       `json:"exp,omitempty"`
+	IssuedAt  int64       `json:"iat,omitempty"`
+	UserData  interface{} `json:"user_data,omitempty"`
+}
+
+// checkValidationAPIKey returns true if the request has one of the
+// configured validation API keys
+func (s *Server) checkValidationAPIKey(w *Response, r *http.Request) bool {
+	key := r.Header.Get("X-Api-Key")
+	if key == "" {
+		return false
+	}
+	for _, k := range s.requestConfig(w).ValidationAPIKeys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleValidationRequest is the http.HandlerFunc for the lightweight reference
+// token validation endpoint. It is protected by an API key sent in the
+// X-Api-Key header, and accepts a batch of tokens in repeated "token" params.
+// Responses advertise ValidationKeepAlive in the Keep-Alive header.
+// NOT an RFC specification.
+func (s *Server) HandleValidationRequest(w *Response, r *http.Request) *ValidationRequest {
+	if keepAlive := s.requestConfig(w).ValidationKeepAlive; keepAlive > 0 {
+		w.Headers.Set("Keep-Alive", fmt.Sprintf("timeout=%d", keepAlive))
+	}
+
+	if r.Method != "POST" {
+		w.SetError(E_INVALID_REQUEST, "")
+		w.InternalError = errors.New("Request must be POST")
+		return nil
+	}
+
+	if !s.checkValidationAPIKey(w, r) {
+		w.SetError(E_INVALID_CLIENT, "")
+		w.InternalError = errors.New("invalid validation api key")
+		return nil
+	}
+
+	if err := s.parseForm(w, r); err != nil {
+		w.SetError(E_INVALID_REQUEST, "")
+		w.InternalError = err
+		return nil
+	}
+	if !s.checkParameterLengths(w, r) {
+		return nil
+	}
+
+	ret := &ValidationRequest{
+		Tokens:      r.PostForm["token"],
+		HttpRequest: r,
+	}
+	if len(ret.Tokens) == 0 {
+		w.SetError(E_INVALID_REQUEST, "token is required")
+		return nil
+	}
+	if max := s.requestConfig(w).MaxValidationBatch; max > 0 && len(ret.Tokens) > max {
+		w.SetError(E_INVALID_REQUEST, fmt.Sprintf("at most %d tokens can be validated at once", max))
+		return nil
+	}
+
+	var err error
+	if ret.AccessData, err = s.validateTokens(r.Context(), w.Storage, ret.Tokens); err != nil {
+		w.SetError(E_SERVER_ERROR, "")
+		w.InternalError = err
+		return nil
+	}
+
+	return ret
+}
+
+// ValidateTokens loads the access data of many tokens, in the same order,
+// using a single storage round trip if the storage implements
+// AccessBatchLoader. API keys are validated too. Entries of tokens not
+// found or expired are nil.
+func (s *Server) ValidateTokens(ctx context.Context, tokens []string) ([]*AccessData, error) {
+	storage := s.Storage.Clone()
+	defer storage.Close()
+	return s.validateTokens(ctx, storage, tokens)
+}
+
+func (s *Server) validateTokens(ctx context.Context, storage Storage, tokens []string) ([]*AccessData, error) {
+	// malformed tokens are not looked up
+	var lookup []string
+	var at []int
+	for i, token := range tokens {
+		if !s.isMalformedToken(token, false) {
+			lookup = append(lookup, token)
+			at = append(at, i)
+		}
+	}
+
+	ret := make([]*AccessData, len(tokens))
+	if loader, ok := storageAs[AccessBatchLoader](storage); ok && len(lookup) > 0 {
+		loaded, err := loader.LoadAccessBatch(ctx, lookup)
+		if err != nil {
+			return nil, err
+		}
+		if len(loaded) != len(lookup) {
+			return nil, fmt.Errorf("storage returned %d access data for %d tokens", len(loaded), len(lookup))
+		}
+		for j, ad := range loaded {
+			ret[at[j]] = ad
+		}
+	} else {
+		for j, token := range lookup {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			ad, err := storageLoadAccess(ctx, storage, token)
+			if err != nil && err != ErrNotFound {
+				return nil, err
+			}
+			ret[at[j]] = ad
+		}
+	}
+
+	now := s.Now()
+	for i, ad := range ret {
+		if ad == nil && s.IsAPIKey(tokens[i]) {
+			key, err := s.loadAPIKey(ctx, storage, tokens[i])
+			if err != nil && err != ErrNotFound {
+				return nil, err
+			}
+			if key != nil {
+				ad = key.accessData(tokens[i])
+				ret[i] = ad
+			}
+		}
+		if ad == nil || ad.Client == nil || ad.IsExpiredAt(now) {
+			ret[i] = nil
+			continue
+		}
+		revoked, err := s.isRevoked(tokens[i], ad)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			ret[i] = nil
+		}
+	}
+	return ret, nil
+}
+
+// FinishValidationRequest outputs the validation results in the "tokens"
+// field, in the same order as the request
+func (s *Server) FinishValidationRequest(w *Response, r *http.Request, vr *ValidationRequest) {
+	// don't process if is already an error
+	if w.IsError {
+		return
+	}
+
+	results := make([]ValidationResult, len(vr.Tokens))
+	for i, token := range vr.Tokens {
+		results[i].Token = token
+		ad := vr.AccessData[i]
+		if ad == nil {
+			continue
+		}
+		results[i].Active = true
+		results[i].ClientId = ad.Client.GetID()
+		results[i].Scope = ad.Scope
+		results[i].ExpiresAt = ad.ExpireAt().Unix()
+		results[i].IssuedAt = ad.CreatedAt.Unix()
+		results[i].UserData = ad.UserData
+	}
+	w.Output["tokens"] = results
+}
@@ -0,0 +1,153 @@
+package osin
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestValidation(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.ValidationAPIKeys = []string{"gateway-key"}
+	server := NewServer(sconfig, NewTestingStorage())
+	resp := server.NewResponse()
+
+	req, err := http.NewRequest("POST", "http://localhost:14000/validate", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", "gateway-key")
+	req.PostForm = url.Values{"token": {"9999", "unknown"}}
+	req.Form = req.PostForm
+
+	if vr := server.HandleValidationRequest(resp, req); vr != nil {
+		server.FinishValidationRequest(resp, req, vr)
+	}
+
+	if resp.IsError {
+		t.Fatalf("Should not be an error: %v", resp.Output)
+	}
+	if resp.Headers.Get("Keep-Alive") != "timeout=90" {
+		t.Fatalf("Unexpected Keep-Alive header: %q", resp.Headers.Get("Keep-Alive"))
+	}
+
+	results, ok := resp.Output["tokens"].([]ValidationResult)
+	if !ok || len(results) != 2 {
+		t.Fatalf("Unexpected output: %v", resp.Output)
+	}
+	if !results[0].Active || results[0].ClientId != "1234" {
+		t.Fatalf("First token should be active: %+v", results[0])
+	}
+	if results[1].Active || results[1].Token != "unknown" {
+		t.Fatalf("Second token should not be active: %+v", results[1])
+	}
+}
+
+func TestValidationAPIKey(t *testing.T) {
+	testcases := map[string]struct {
+		Keys []string
+		Key  string
+	}{
+		"no keys configured": {
+			Key: "gateway-key",
+		},
+		"missing key": {
+			Keys: []string{"gateway-key"},
+		},
+		"wrong key": {
+			Keys: []string{"gateway-key"},
+			Key:  "other",
+		},
+	}
+
+	for k, tc := range testcases {
+		sconfig := NewServerConfig()
+		sconfig.ValidationAPIKeys = tc.Keys
+		server := NewServer(sconfig, NewTestingStorage())
+		resp := server.NewResponse()
+
+		req, err := http.NewRequest("POST", "http://localhost:14000/validate", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.Key != "" {
+			req.Header.Set("X-Api-Key", tc.Key)
+		}
+		req.PostForm = url.Values{"token": {"9999"}}
+		req.Form = req.PostForm
+
+		if vr := server.HandleValidationRequest(resp, req); vr != nil {
+			t.Errorf("%s: request should be refused", k)
+			continue
+		}
+		if resp.ErrorId != E_INVALID_CLIENT {
+			t.Errorf("%s: expected invalid_client, got %v", k, resp.Output)
+		}
+	}
+}
+
+// batchStorage implements AccessBatchLoader, counting the batch calls
+type batchStorage struct {
+	*TestingStorage
+	batches int
+}
+
+func (s *batchStorage) Clone() Storage { return s }
+
+func (s *batchStorage) LoadAccessBatch(ctx context.Context, tokens []string) ([]*AccessData, error) {
+	s.batches++
+	ret := make([]*AccessData, len(tokens))
+	for i, token := range tokens {
+		ret[i] = s.access[token]
+	}
+	return ret, nil
+}
+
+func TestValidateTokens(t *testing.T) {
+	storage := &batchStorage{TestingStorage: NewTestingStorage()}
+	storage.access["expired"] = &AccessData{
+		Client:      storage.clients["1234"],
+		AccessToken: "expired",
+		ExpiresIn:   60,
+		CreatedAt:   time.Now().Add(-time.Hour),
+	}
+	server := NewServer(NewServerConfig(), storage)
+
+	result, err := server.ValidateTokens(context.Background(), []string{"9999", "unknown", "expired"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storage.batches != 1 {
+		t.Fatalf("Expected a single batch call, got %d", storage.batches)
+	}
+	if len(result) != 3 || result[0] == nil || result[1] != nil || result[2] != nil {
+		t.Fatalf("Unexpected validation result: %v", result)
+	}
+
+	// batch support of a wrapped storage
+	server = NewServer(NewServerConfig(), NewBreakerStorage(storage, BreakerStorageOptions{}))
+	if _, err = server.ValidateTokens(context.Background(), []string{"9999", "unknown"}); err != nil {
+		t.Fatal(err)
+	}
+	if storage.batches != 2 {
+		t.Fatalf("Expected the batch call of the wrapped storage, got %d", storage.batches)
+	}
+
+	// without batch support
+	server = NewServer(NewServerConfig(), NewTestingStorage())
+	result, err = server.ValidateTokens(context.Background(), []string{"unknown", "9999"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 2 || result[0] != nil || result[1] == nil {
+		t.Fatalf("Unexpected validation result: %v", result)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = server.ValidateTokens(ctx, []string{"9999"}); err == nil {
+		t.Fatalf("Canceled context should fail the validation")
+	}
+}
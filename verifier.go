@@ -0,0 +1,333 @@
+package osin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidToken is returned by Verifier for tokens that are malformed,
+	// expired, revoked or not issued for the resource server
+	ErrInvalidToken = errors.New("invalid token")
+
+	// ErrMissingToken is returned by Verifier.VerifyRequest for requests
+	// without a bearer token
+	ErrMissingToken = errors.New("missing bearer token")
+)
+
+// VerifiedToken is an access token validated by a Verifier
+type VerifiedToken struct {
+	// Token as presented
+	Token string
+
+	ClientId  string
+	Subject   string
+	Scope     string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+
+	// All the claims of the JWT, or the fields of the introspection response
+	Claims map[string]interface{}
+}
+
+// HasScope returns true if the token was granted the scope
+func (t *VerifiedToken) HasScope(scope string) bool {
+	return HasScope(t.Scope, scope)
+}
+
+// TokenIntrospector validates opaque tokens for a Verifier, usually calling
+// the introspection endpoint of the authorization server. Returns
+// ErrInvalidToken for inactive tokens.
+type TokenIntrospector interface {
+	IntrospectToken(ctx context.Context, token string) (*VerifiedToken, error)
+}
+
+// TokenIntrospectorFunc allows a function, like Verifier.Verify, to be used
+// as a TokenIntrospector
+type TokenIntrospectorFunc func(ctx context.Context, token string) (*VerifiedToken, error)
+
+// IntrospectToken calls f(ctx, token)
+func (f TokenIntrospectorFunc) IntrospectToken(ctx context.Context, token string) (*VerifiedToken, error) {
+	return f(ctx, token)
+}
+
+// Verifier validates access tokens for resource servers, without a Storage
+// or the issuing parts of the Server. JWT access tokens, as issued by
+// AccessTokenGenJWT, are verified with the public keys, and other tokens are
+// handed to the Introspector.
+type Verifier struct {
+	// Public keys verifying JWT access tokens. Fetched from JWKSURL if nil.
+	Keys *JSONWebKeySet
+
+	// jwks_uri of the authorization server, fetched when a token has an
+	// unknown key id, at most once per RefreshInterval
+	JWKSURL string
+
+	// Minimum time between JWKS fetches (default 5 minutes)
+	RefreshInterval time.Duration
+
+	// Expected "iss" of JWT access tokens. Required to verify JWTs.
+	Issuer string
+
+	// Identifier of the resource server, which must be in the "aud" of JWT
+	// access tokens. Required to verify JWTs, unless AnyAudience is set.
+	Audience string
+
+	// If true, JWT access tokens issued for any audience are accepted, for
+	// the resource servers shared by all the audiences - default false
+	AnyAudience bool
+
+	// Tolerated clock skew for the JWT times
+	Leeway time.Duration
+
+	// Validates the tokens that are not JWTs, like a RemoteIntrospector.
+	// Those are rejected if nil.
+	Introspector TokenIntrospector
+
+	// Validates the tokens starting with APIKeyPrefix (default "osk_", as
+	// ServerConfig.APIKeyPrefix), usually
+	// TokenIntrospectorFunc(server.VerifyAPIKey), so API keys are accepted
+	// along with the access tokens
+	APIKeys      TokenIntrospector
+	APIKeyPrefix string
+
+	// HTTP client fetching the JWKS. http.DefaultClient if nil.
+	HTTPClient *http.Client
+
+	// Current time, time.Now if nil
+	Now func() time.Time
+
+	mu        sync.Mutex
+	fetched   *JSONWebKeySet
+	fetchedAt time.Time
+
+	// closed when the fetch in progress, if any, is done
+	fetching chan struct{}
+}
+
+func (v *Verifier) now() time.Time {
+	if v.Now != nil {
+		return v.Now()
+	}
+	return time.Now()
+}
+
+func (v *Verifier) apiKeyPrefix() string {
+	if v.APIKeyPrefix != "" {
+		return v.APIKeyPrefix
+	}
+	return defaultAPIKeyPrefix
+}
+
+// VerifyRequest verifies the bearer token of the request
+func (v *Verifier) VerifyRequest(r *http.Request) (*VerifiedToken, error) {
+	bearer := CheckBearerAuth(r)
+	if bearer == nil || bearer.Code == "" {
+		return nil, ErrMissingToken
+	}
+	return v.Verify(r.Context(), bearer.Code)
+}
+
+// Verify validates the access token. JWTs must have the at+jwt type of
+// RFC 9068. Returns ErrInvalidToken if it is not valid, and other errors if
+// it could not be verified.
+func (v *Verifier) Verify(ctx context.Context, token string) (*VerifiedToken, error) {
+	if v.APIKeys != nil && strings.HasPrefix(token, v.apiKeyPrefix()) {
+		return v.APIKeys.IntrospectToken(ctx, token)
+	}
+
+	t, err := ParseJWT(token)
+	if err != nil {
+		if v.Introspector == nil {
+			return nil, ErrInvalidToken
+		}
+		return v.Introspector.IntrospectToken(ctx, token)
+	}
+
+	// ID tokens and other JWTs signed with the same keys aren't access
+	// tokens (https://www.rfc-editor.org/rfc/rfc9068#section-4)
+	if typ, _ := t.Header["typ"].(string); !strings.EqualFold(typ, "at+jwt") && !strings.EqualFold(typ, "application/at+jwt") {
+		return nil, ErrInvalidToken
+	}
+	if v.Issuer == "" || (v.Audience == "" && !v.AnyAudience) {
+		return nil, errors.New("verifier has no Issuer or Audience")
+	}
+	if err = v.verifySignature(ctx, t); err != nil {
+		return nil, err
+	}
+	if err = t.ValidateTimes(v.now(), v.Leeway); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if t.StringClaim("iss") != v.Issuer {
+		return nil, ErrInvalidToken
+	}
+	if !v.AnyAudience && !t.HasAudience(v.Audience) {
+		return nil, ErrInvalidToken
+	}
+
+	ret := &VerifiedToken{
+		Token:    token,
+		ClientId: t.StringClaim("client_id"),
+		Subject:  t.StringClaim("sub"),
+		Scope:    t.StringClaim("scope"),
+		Claims:   t.Claims,
+	}
+	ret.IssuedAt, _ = t.TimeClaim("iat")
+	ret.ExpiresAt, _ = t.TimeClaim("exp")
+	return ret, nil
+}
+
+// verifySignature verifies the JWT with the static keys, or the fetched
+// ones, fetching them again if the key id is unknown
+func (v *Verifier) verifySignature(ctx context.Context, t *JWT) error {
+	if v.Keys != nil {
+		if v.Keys.VerifyJWT(t) != nil {
+			return ErrInvalidToken
+		}
+		return nil
+	}
+	if v.JWKSURL == "" {
+		return errors.New("verifier has no keys")
+	}
+
+	keys, err := v.keys(ctx, false)
+	if err != nil {
+		return err
+	}
+	if keys.VerifyJWT(t) == nil {
+		return nil
+	}
+	if kid := t.KeyID(); kid != "" && !keys.hasKey(kid) {
+		// the authorization server may have rotated its keys
+		if keys, err = v.keys(ctx, true); err != nil {
+			return err
+		}
+		if keys.VerifyJWT(t) == nil {
+			return nil
+		}
+	}
+	return ErrInvalidToken
+}
+
+// keys returns the fetched JWKS, fetching it if missing, or if refresh is
+// set and RefreshInterval elapsed since the last fetch. The fetch doesn't
+// hold the lock: the calls with keys don't wait for it, the others wait for
+// the fetch in progress instead of fetching again.
+func (v *Verifier) keys(ctx context.Context, refresh bool) (*JSONWebKeySet, error) {
+	interval := v.RefreshInterval
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+
+	v.mu.Lock()
+	if v.fetched != nil && !refresh {
+		defer v.mu.Unlock()
+		return v.fetched, nil
+	}
+	for v.fetching != nil {
+		fetching := v.fetching
+		v.mu.Unlock()
+		select {
+		case <-fetching:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		v.mu.Lock()
+		// the fetch just done counts as the refresh
+		refresh = false
+	}
+	if v.fetched != nil && (!refresh || v.now().Sub(v.fetchedAt) < interval) {
+		defer v.mu.Unlock()
+		return v.fetched, nil
+	}
+	fetching := make(chan struct{})
+	v.fetching = fetching
+	previous := v.fetched
+	v.mu.Unlock()
+
+	keys, err := v.fetch(ctx)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.fetching = nil
+	close(fetching)
+	if err != nil {
+		if previous != nil {
+			return previous, nil
+		}
+		return nil, err
+	}
+	v.fetched, v.fetchedAt = keys, v.now()
+	return keys, nil
+}
+
+// fetch fetches the JWKS from JWKSURL
+func (v *Verifier) fetch(ctx context.Context) (*JSONWebKeySet, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", v.JWKSURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := v.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks fetch failed with status %d", resp.StatusCode)
+	}
+	var keys JSONWebKeySet
+	if err = json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		return nil, err
+	}
+	return &keys, nil
+}
+
+// hasKey returns true if the set has a key with the id
+func (s JSONWebKeySet) hasKey(kid string) bool {
+	for _, k := range s.Keys {
+		if k.Kid == kid {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware returns a handler verifying the bearer token before calling
+// next, answering 401 with a WWW-Authenticate challenge otherwise. The
+// verified token is in the request context, see VerifiedTokenFromContext.
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := v.VerifyRequest(r)
+		switch {
+		case err == ErrMissingToken:
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		case err == ErrInvalidToken:
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="%s"`, E_INVALID_TOKEN))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		case err != nil:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), verifiedTokenKey{}, token)))
+	})
+}
+
+type verifiedTokenKey struct{}
+
+// VerifiedTokenFromContext returns the token verified by Verifier.Middleware
+func VerifiedTokenFromContext(ctx context.Context) *VerifiedToken {
+	t, _ := ctx.Value(verifiedTokenKey{}).(*VerifiedToken)
+	return t
+}
@@ -0,0 +1,224 @@
+package osin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newVerifierToken(t *testing.T, ks *KeySet, scope string) string {
+	gen := &AccessTokenGenJWT{KeySet: ks, Issuer: "http://localhost:14000", Audience: []string{"api"}}
+	data := &AccessData{
+		Client:    &DefaultClient{Id: "1234"},
+		Subject:   "user-1",
+		Scope:     scope,
+		CreatedAt: time.Now(),
+		ExpiresIn: 3600,
+	}
+	token, _, err := gen.GenerateAccessToken(data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+type testingIntrospector map[string]*VerifiedToken
+
+func (i testingIntrospector) IntrospectToken(ctx context.Context, token string) (*VerifiedToken, error) {
+	if vt, ok := i[token]; ok {
+		return vt, nil
+	}
+	return nil, ErrInvalidToken
+}
+
+func TestVerifier(t *testing.T) {
+	ks, err := NewKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := ks.JWKS()
+	token := newVerifierToken(t, ks, "read write")
+	idToken, err := ks.Sign(map[string]interface{}{
+		"iss": "http://localhost:14000", "aud": "api", "sub": "user-1", "exp": time.Now().Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := &Verifier{
+		Keys:         &keys,
+		Issuer:       "http://localhost:14000",
+		Audience:     "api",
+		Introspector: testingIntrospector{"opaque": {Token: "opaque", Subject: "user-2"}},
+	}
+
+	vt, err := v.Verify(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vt.ClientId != "1234" || vt.Subject != "user-1" || !vt.HasScope("write") || vt.ExpiresAt.IsZero() {
+		t.Errorf("Unexpected verified token: %+v", vt)
+	}
+
+	testcases := map[string]struct {
+		Verifier *Verifier
+		Token    string
+		Error    error
+	}{
+		"wrong audience": {
+			Verifier: &Verifier{Keys: &keys, Issuer: "http://localhost:14000", Audience: "other"},
+			Token:    token,
+			Error:    ErrInvalidToken,
+		},
+		"any audience": {
+			Verifier: &Verifier{Keys: &keys, Issuer: "http://localhost:14000", AnyAudience: true},
+			Token:    token,
+		},
+		"wrong issuer": {
+			Verifier: &Verifier{Keys: &keys, Issuer: "http://other", Audience: "api"},
+			Token:    token,
+			Error:    ErrInvalidToken,
+		},
+		"expired": {
+			Verifier: &Verifier{Keys: &keys, Issuer: "http://localhost:14000", Audience: "api", Now: func() time.Time { return time.Now().Add(2 * time.Hour) }},
+			Token:    token,
+			Error:    ErrInvalidToken,
+		},
+		"tampered": {
+			Verifier: v,
+			Token:    token[:len(token)-4] + "AAAA",
+			Error:    ErrInvalidToken,
+		},
+		"id token": {
+			Verifier: v,
+			Token:    idToken,
+			Error:    ErrInvalidToken,
+		},
+		"opaque": {
+			Verifier: v,
+			Token:    "opaque",
+		},
+		"opaque unknown": {
+			Verifier: v,
+			Token:    "unknown",
+			Error:    ErrInvalidToken,
+		},
+		"opaque without introspector": {
+			Verifier: &Verifier{Keys: &keys},
+			Token:    "opaque",
+			Error:    ErrInvalidToken,
+		},
+	}
+	for k, tc := range testcases {
+		if _, err := tc.Verifier.Verify(context.Background(), tc.Token); err != tc.Error {
+			t.Errorf("%s: expected error %v, got %v", k, tc.Error, err)
+		}
+	}
+
+	// JWTs are not accepted without an expected issuer and audience
+	for _, nv := range []*Verifier{{Keys: &keys, Issuer: "http://localhost:14000"}, {Keys: &keys, Audience: "api"}} {
+		if _, err := nv.Verify(context.Background(), token); err == nil || err == ErrInvalidToken {
+			t.Errorf("Verifier without issuer or audience should fail, got %v", err)
+		}
+	}
+}
+
+func TestVerifierJWKSURL(t *testing.T) {
+	ks, err := NewKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		ks.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	v := &Verifier{JWKSURL: srv.URL, Issuer: "http://localhost:14000", Audience: "api"}
+	if _, err = v.Verify(context.Background(), newVerifierToken(t, ks, "")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a rotated key is fetched on first use
+	if _, err = ks.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	rotated := newVerifierToken(t, ks, "")
+	if _, err = v.Verify(context.Background(), rotated); err != ErrInvalidToken {
+		t.Fatalf("Refetch should wait for the refresh interval, got %v", err)
+	}
+	v.RefreshInterval = time.Nanosecond
+	if _, err = v.Verify(context.Background(), rotated); err != nil {
+		t.Fatal(err)
+	}
+	if fetches != 2 {
+		t.Errorf("Expected 2 fetches, got %d", fetches)
+	}
+
+	// middleware
+	handler := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if vt := VerifiedTokenFromContext(r.Context()); vt == nil || vt.Subject != "user-1" {
+			t.Errorf("Unexpected token in context: %+v", vt)
+		}
+	}))
+	for token, code := range map[string]int{rotated: http.StatusOK, "": http.StatusUnauthorized, "bad": http.StatusUnauthorized} {
+		req := httptest.NewRequest("GET", "/api", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != code {
+			t.Errorf("Expected status %d, got %d", code, rec.Code)
+		}
+	}
+}
+
+func TestVerifierSlowJWKSFetch(t *testing.T) {
+	ks, err := NewKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches++; fetches > 1 {
+			<-release
+		}
+		ks.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	v := &Verifier{JWKSURL: srv.URL, Issuer: "http://localhost:14000", Audience: "api", RefreshInterval: time.Nanosecond}
+	token := newVerifierToken(t, ks, "")
+	if _, err = v.Verify(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+
+	// a token of an unknown key refetches the keys, slowly
+	other, err := NewKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go v.Verify(context.Background(), newVerifierToken(t, other, ""))
+	time.Sleep(50 * time.Millisecond)
+
+	// the tokens of the known keys are verified meanwhile
+	done := make(chan error, 1)
+	go func() {
+		_, err := v.Verify(context.Background(), token)
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Verification should not wait for the fetch")
+	}
+}
@@ -0,0 +1,328 @@
+// Package webhook delivers the osin server events to HTTP endpoints, like
+// CRM or SIEM systems. Events are posted as JSON asynchronously, signed
+// with HMAC-SHA256 and retried with exponential backoff.
+//
+//	d := webhook.NewDispatcher(webhook.Endpoint{
+//		URL:    "https://siem.example.com/hooks/osin",
+//		Secret: []byte(secret),
+//		Events: []osin.EventType{osin.EVENT_TOKEN_REVOKED, webhook.EVENT_TOKEN_REFRESHED},
+//	})
+//	defer d.Close()
+//	server.AddEventListener(d)
+//
+// The body is a Payload. Receivers verify the SIGNATURE_HEADER, of the
+// form "t=<unix time>,v1=<hex HMAC-SHA256 of '<unix time>.<body>'>", and
+// should reject old timestamps.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/RangelReale/osin"
+)
+
+const (
+	// Header of the payload signature
+	SIGNATURE_HEADER = "X-Osin-Signature"
+
+	// Header of the payload id, the same for all the delivery attempts
+	ID_HEADER = "X-Osin-Delivery"
+
+	// Type of the osin.EVENT_TOKEN_ISSUED events of the refresh_token
+	// grant, to filter them apart
+	EVENT_TOKEN_REFRESHED osin.EventType = "token_refreshed"
+)
+
+var (
+	ErrQueueFull = errors.New("webhook queue is full")
+	ErrClosed    = errors.New("webhook dispatcher is closed")
+)
+
+// Endpoint receives the events
+type Endpoint struct {
+	URL string
+
+	// Key of the HMAC-SHA256 signature. Not signed if empty.
+	Secret []byte
+
+	// Types of the events delivered. All if empty.
+	Events []osin.EventType
+}
+
+// accepts returns true if the endpoint receives the events of the type
+func (e *Endpoint) accepts(typ osin.EventType) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, t := range e.Events {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Payload is the JSON body of a delivery
+type Payload struct {
+	Id       string                 `json:"id"`
+	Type     osin.EventType         `json:"type"`
+	Time     time.Time              `json:"time"`
+	ClientId string                 `json:"client_id,omitempty"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+}
+
+// NewPayload creates the payload of an event. Error values of the data are
+// converted to their message.
+func NewPayload(e *osin.Event) (*Payload, error) {
+	id, err := (osin.TokenFormat{Length: 16}).Generate()
+	if err != nil {
+		return nil, err
+	}
+	p := &Payload{Id: id, Type: e.Type, Time: e.Time.UTC()}
+	if e.Type == osin.EVENT_TOKEN_ISSUED && e.Data["grant_type"] == osin.REFRESH_TOKEN {
+		p.Type = EVENT_TOKEN_REFRESHED
+	}
+	if e.Client != nil {
+		p.ClientId = e.Client.GetID()
+	}
+	if len(e.Data) > 0 {
+		p.Data = make(map[string]interface{}, len(e.Data))
+		for k, v := range e.Data {
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			}
+			p.Data[k] = v
+		}
+	}
+	return p, nil
+}
+
+// Sign returns the SIGNATURE_HEADER value of a body sent at time t
+func Sign(secret []byte, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// delivery is a payload to deliver to an endpoint
+type delivery struct {
+	endpoint *Endpoint
+	id       string
+	body     []byte
+}
+
+// Dispatcher is an osin.EventListener delivering the events to the
+// endpoints. Deliveries are queued, and sent by background workers started
+// by NewDispatcher until Close or Shutdown.
+type Dispatcher struct {
+	Endpoints []Endpoint
+
+	// HTTP client - default one with a 10 seconds timeout
+	HTTPClient *http.Client
+
+	// Delivery attempts of an event to an endpoint (default 5). Responses
+	// other than 2xx are retried.
+	MaxAttempts int
+
+	// Wait before the first retry, doubled on each retry up to MaxBackoff
+	// (default 1 second, 1 minute)
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Receives the deliveries failing after all the attempts, or dropped
+	// because the queue is full or the dispatcher closed, if not nil
+	OnError func(endpoint Endpoint, payload []byte, err error)
+
+	// Returns the current time - default time.Now
+	Now func() time.Time
+
+	queue    chan *delivery
+	mu       sync.RWMutex
+	closed   bool
+	stop     chan struct{}
+	stopOnce sync.Once
+	workers  sync.WaitGroup
+}
+
+// NewDispatcher creates a dispatcher with a queue of 1000 deliveries and 4
+// workers, see NewDispatcherSize
+func NewDispatcher(endpoints ...Endpoint) *Dispatcher {
+	return NewDispatcherSize(1000, 4, endpoints...)
+}
+
+// NewDispatcherSize creates a dispatcher with a queue of queueSize
+// deliveries, sent by workers goroutines
+func NewDispatcherSize(queueSize, workers int, endpoints ...Endpoint) *Dispatcher {
+	d := &Dispatcher{
+		Endpoints: endpoints,
+		queue:     make(chan *delivery, queueSize),
+		stop:      make(chan struct{}),
+	}
+	d.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+func (d *Dispatcher) now() time.Time {
+	if d.Now != nil {
+		return d.Now()
+	}
+	return time.Now()
+}
+
+func (d *Dispatcher) fail(del *delivery, err error) {
+	if d.OnError != nil {
+		d.OnError(*del.endpoint, del.body, err)
+	}
+}
+
+// HandleEvent satisfies osin.EventListener, queuing the event for the
+// endpoints accepting it. It doesn't block.
+func (d *Dispatcher) HandleEvent(e *osin.Event) {
+	p, err := NewPayload(e)
+	if err != nil {
+		return
+	}
+	var body []byte
+	for i := range d.Endpoints {
+		ep := &d.Endpoints[i]
+		if !ep.accepts(p.Type) {
+			continue
+		}
+		if body == nil {
+			if body, err = json.Marshal(p); err != nil {
+				return
+			}
+		}
+		d.enqueue(&delivery{endpoint: ep, id: p.Id, body: body})
+	}
+}
+
+func (d *Dispatcher) enqueue(del *delivery) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		d.fail(del, ErrClosed)
+		return
+	}
+	select {
+	case d.queue <- del:
+	default:
+		d.fail(del, ErrQueueFull)
+	}
+}
+
+// Close stops queuing events, and waits for the queued ones to be
+// delivered, with their retries
+func (d *Dispatcher) Close() {
+	d.Shutdown(context.Background())
+}
+
+// Shutdown stops queuing events, and waits for the queued ones to be
+// delivered until ctx is done. The retries left are then abandoned, and
+// the deliveries not attempted yet get a single attempt.
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		d.stopOnce.Do(func() { close(d.stop) })
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (d *Dispatcher) work() {
+	defer d.workers.Done()
+	for del := range d.queue {
+		d.deliver(del)
+	}
+}
+
+// deliver sends a delivery, retrying until MaxAttempts or Shutdown
+func (d *Dispatcher) deliver(del *delivery) {
+	attempts, backoff, maxBackoff := d.MaxAttempts, d.Backoff, d.MaxBackoff
+	if attempts <= 0 {
+		attempts = 5
+	}
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = time.Minute
+	}
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-d.stop:
+				d.fail(del, fmt.Errorf("%w, after %d attempts: %v", ErrClosed, i, err))
+				return
+			}
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+		if err = d.post(del); err == nil {
+			return
+		}
+	}
+	d.fail(del, err)
+}
+
+func (d *Dispatcher) post(del *delivery) error {
+	req, err := http.NewRequest("POST", del.endpoint.URL, bytes.NewReader(del.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(ID_HEADER, del.id)
+	if len(del.endpoint.Secret) > 0 {
+		req.Header.Set(SIGNATURE_HEADER, Sign(del.endpoint.Secret, d.now(), del.body))
+	}
+
+	client := d.HTTPClient
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s failed with status %d", del.endpoint.URL, resp.StatusCode)
+	}
+	return nil
+}
+
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
@@ -0,0 +1,156 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+)
+
+// receiver records the payloads posted to it, failing the first failures
+// requests
+type receiver struct {
+	mu         sync.Mutex
+	failures   int
+	payloads   []Payload
+	signatures []string
+	bodies     [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.failures > 0 {
+		rc.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	var p Payload
+	json.Unmarshal(body, &p)
+	if r.Header.Get(ID_HEADER) != p.Id {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rc.payloads = append(rc.payloads, p)
+	rc.signatures = append(rc.signatures, r.Header.Get(SIGNATURE_HEADER))
+	rc.bodies = append(rc.bodies, body)
+}
+
+func TestDispatcher(t *testing.T) {
+	all := &receiver{failures: 2}
+	revocations := &receiver{}
+	allSrv := httptest.NewServer(all)
+	defer allSrv.Close()
+	revSrv := httptest.NewServer(revocations)
+	defer revSrv.Close()
+
+	now := time.Unix(1700000000, 0)
+	d := NewDispatcher(
+		Endpoint{URL: allSrv.URL, Secret: []byte("secret")},
+		Endpoint{URL: revSrv.URL, Events: []osin.EventType{osin.EVENT_TOKEN_REVOKED, EVENT_TOKEN_REFRESHED}},
+	)
+	d.Backoff = time.Millisecond
+	d.Now = func() time.Time { return now }
+
+	client := &osin.DefaultClient{Id: "1234"}
+	d.HandleEvent(&osin.Event{Type: osin.EVENT_TOKEN_ISSUED, Time: now, Client: client, Data: map[string]interface{}{"grant_type": osin.CLIENT_CREDENTIALS}})
+	d.HandleEvent(&osin.Event{Type: osin.EVENT_TOKEN_ISSUED, Time: now, Client: client, Data: map[string]interface{}{"grant_type": osin.REFRESH_TOKEN}})
+	d.HandleEvent(&osin.Event{Type: osin.EVENT_GRANTS_PURGE_FAILED, Time: now, Data: map[string]interface{}{"error": errors.New("storage down")}})
+	d.Close()
+
+	if len(all.payloads) != 3 {
+		t.Fatalf("Expected 3 deliveries after the retries, got %d", len(all.payloads))
+	}
+	types := make(map[osin.EventType]Payload)
+	for i, p := range all.payloads {
+		types[p.Type] = p
+		if all.signatures[i] != Sign([]byte("secret"), now, all.bodies[i]) {
+			t.Errorf("Invalid signature %q", all.signatures[i])
+		}
+	}
+	if p := types[osin.EVENT_TOKEN_ISSUED]; p.ClientId != "1234" || p.Data["grant_type"] != "client_credentials" {
+		t.Errorf("Unexpected token issued payload %+v", p)
+	}
+	if _, ok := types[EVENT_TOKEN_REFRESHED]; !ok {
+		t.Errorf("Refresh not delivered as %s", EVENT_TOKEN_REFRESHED)
+	}
+	if p := types[osin.EVENT_GRANTS_PURGE_FAILED]; p.Data["error"] != "storage down" {
+		t.Errorf("Unexpected error data %+v", p.Data)
+	}
+
+	if len(revocations.payloads) != 1 || revocations.payloads[0].Type != EVENT_TOKEN_REFRESHED || revocations.signatures[0] != "" {
+		t.Fatalf("Unexpected filtered deliveries %+v", revocations.payloads)
+	}
+}
+
+func TestDispatcherFailures(t *testing.T) {
+	rc := &receiver{failures: 100}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var failed []error
+	d := NewDispatcherSize(1, 1, Endpoint{URL: srv.URL})
+	d.MaxAttempts = 3
+	d.Backoff = time.Millisecond
+	d.OnError = func(endpoint Endpoint, payload []byte, err error) {
+		mu.Lock()
+		failed = append(failed, err)
+		mu.Unlock()
+	}
+
+	d.HandleEvent(&osin.Event{Type: osin.EVENT_TOKEN_REVOKED})
+	d.Close()
+	d.HandleEvent(&osin.Event{Type: osin.EVENT_TOKEN_REVOKED})
+
+	if rc.failures != 97 {
+		t.Fatalf("Expected 3 attempts, got %d", 100-rc.failures)
+	}
+	if len(failed) != 2 || errors.Is(failed[0], ErrClosed) || !errors.Is(failed[1], ErrClosed) {
+		t.Fatalf("Unexpected failures %v", failed)
+	}
+
+	// shutdown abandons the retries
+	rc.failures = 100
+	failed = nil
+	d = NewDispatcherSize(1, 1, Endpoint{URL: srv.URL})
+	d.Backoff = time.Hour
+	d.OnError = func(endpoint Endpoint, payload []byte, err error) {
+		mu.Lock()
+		failed = append(failed, err)
+		mu.Unlock()
+	}
+	d.HandleEvent(&osin.Event{Type: osin.EVENT_TOKEN_REVOKED})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := d.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+	if rc.failures != 99 || len(failed) != 1 || !errors.Is(failed[0], ErrClosed) {
+		t.Fatalf("Unexpected shutdown: %d attempts, failures %v", 100-rc.failures, failed)
+	}
+}
+
+func TestServerEvents(t *testing.T) {
+	rc := &receiver{}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	d := NewDispatcher(Endpoint{URL: srv.URL, Events: []osin.EventType{osin.EVENT_CLIENT_REGISTERED}})
+	server := osin.NewServer(osin.NewServerConfig(), nil)
+	server.AddEventListener(d)
+	server.EmitEvent(&osin.Event{Type: osin.EVENT_CLIENT_REGISTERED, Client: &osin.DefaultClient{Id: "new"}})
+	d.Close()
+
+	if len(rc.payloads) != 1 || rc.payloads[0].ClientId != "new" || rc.payloads[0].Time.IsZero() {
+		t.Fatalf("Unexpected deliveries %+v", rc.payloads)
+	}
+}